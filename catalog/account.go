@@ -0,0 +1,169 @@
+package catalog
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/account"
+)
+
+type warningService interface {
+	Get() (*account.UsageWarning, *http.Response, error)
+}
+type statsService interface {
+	GetQPS() (float32, *http.Response, error)
+}
+
+// defaultUsageWarning holds the thresholds used when the account's usage warnings can't be read
+var defaultUsageWarning = account.Warning{First: 80, Second: 95}
+
+// accountLimits watches the records and queries of the NS1 account against the limits of its plan.
+// The plan limits aren't exposed by the NS1 API client, so they are configured, while the thresholds
+// are the percentages of the account's usage warnings.
+type accountLimits struct {
+	// maxRecords is the record limit of the plan, 0 disables record checks
+	maxRecords int
+	// maxQPS is the query rate limit of the plan, 0 disables query checks
+	maxQPS float64
+	// pauseCreates skips services that would create records while usage is above the second threshold
+	pauseCreates bool
+
+	lock sync.Mutex
+	// records is the number of records in the service zone as of the last fetch
+	records int
+	paused  bool
+}
+
+// enabled reports whether any limit is configured
+func (a *accountLimits) enabled() bool {
+	return a.maxRecords > 0 || a.maxQPS > 0
+}
+
+// observeRecords records the number of records in the service zone
+func (a *accountLimits) observeRecords(records int) {
+	a.lock.Lock()
+	a.records = records
+	a.lock.Unlock()
+}
+
+// createsPaused reports whether creates are paused because usage is above the second threshold
+func (a *accountLimits) createsPaused() bool {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.paused
+}
+
+// usageWarning returns the thresholds of the account's usage warnings
+func (n *ns1) usageWarning() account.UsageWarning {
+	uw := account.UsageWarning{Records: defaultUsageWarning, Queries: defaultUsageWarning}
+	if n.client.Warnings == nil {
+		return uw
+	}
+	w, _, err := n.client.Warnings.Get()
+	if err != nil {
+		n.log.Warn("cannot read account usage warnings, using default thresholds", "error", err.Error())
+		return uw
+	}
+	if w.Records.First > 0 && w.Records.Second > 0 {
+		uw.Records = w.Records
+	}
+	if w.Queries.First > 0 && w.Queries.Second > 0 {
+		uw.Queries = w.Queries
+	}
+	return uw
+}
+
+// checkAccountUsage compares the records and queries of the account with the limits of its plan,
+// logs a warning above the first threshold and an error above the second one
+func (n *ns1) checkAccountUsage() {
+	a := &n.accountLimits
+	uw := n.usageWarning()
+	paused := false
+	if a.maxRecords > 0 {
+		a.lock.Lock()
+		records := a.records
+		a.lock.Unlock()
+		usage := 100 * float64(records) / float64(a.maxRecords)
+		metrics.SetGauge([]string{"account", "records"}, float32(records))
+		metrics.SetGauge([]string{"account", "records", "usage"}, float32(usage))
+		paused = n.warnUsage("records", fmt.Sprintf("%d", records), fmt.Sprintf("%d", a.maxRecords), usage, uw.Records)
+	}
+	if a.maxQPS > 0 {
+		qps, _, err := n.client.Stats.GetQPS()
+		if err != nil {
+			n.log.Error("cannot read account queries per second", "error", err.Error())
+		} else {
+			usage := 100 * float64(qps) / a.maxQPS
+			metrics.SetGauge([]string{"account", "qps"}, qps)
+			metrics.SetGauge([]string{"account", "qps", "usage"}, float32(usage))
+			if n.warnUsage("queries", fmt.Sprintf("%.1f", qps), fmt.Sprintf("%.1f", a.maxQPS), usage, uw.Queries) {
+				paused = true
+			}
+		}
+	}
+	paused = paused && a.pauseCreates
+	a.lock.Lock()
+	if paused != a.paused {
+		if paused {
+			n.log.Error("pausing creates of new records until account usage drops below the threshold")
+		} else {
+			n.log.Info("resuming creates of new records")
+		}
+	}
+	a.paused = paused
+	a.lock.Unlock()
+}
+
+// warnUsage logs the usage of a limit above the thresholds, it reports whether usage is above the second threshold
+func (n *ns1) warnUsage(kind, value, limit string, usage float64, w account.Warning) bool {
+	switch {
+	case usage >= float64(w.Second):
+		n.log.Error("account usage above second warning threshold", "usage", kind, "value", value,
+			"limit", limit, "percent", fmt.Sprintf("%.0f", usage), "threshold", fmt.Sprintf("%d", w.Second))
+		return true
+	case usage >= float64(w.First):
+		n.log.Warn("account usage above first warning threshold", "usage", kind, "value", value,
+			"limit", limit, "percent", fmt.Sprintf("%.0f", usage), "threshold", fmt.Sprintf("%d", w.First))
+	}
+	return false
+}
+
+// watchAccountUsage checks account usage at every interval until stopped
+func (n *ns1) watchAccountUsage(interval time.Duration, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-n.clock.After(interval):
+			n.checkAccountUsage()
+		}
+	}
+}
+
+// enforceAccountLimits returns the services of `upsert` that don't create records while creates are paused.
+// Services only updating existing records are kept.
+func (n *ns1) enforceAccountLimits(upsert map[string]service) map[string]service {
+	if !n.accountLimits.createsPaused() {
+		return upsert
+	}
+	keys := make([]string, 0, len(upsert))
+	for k := range upsert {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	result := map[string]service{}
+	for _, k := range keys {
+		s := upsert[k]
+		if n.newRecordCount(s) > 0 {
+			n.log.Warn("account usage above threshold, skipping service creating records", "service", k)
+			metrics.IncrCounter([]string{"account", "skipped"}, 1)
+			continue
+		}
+		result[k] = s
+	}
+	return result
+}
@@ -0,0 +1,98 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/account"
+)
+
+type mockWarningService struct {
+	warning *account.UsageWarning
+	err     error
+}
+
+func (s *mockWarningService) Get() (*account.UsageWarning, *http.Response, error) {
+	return s.warning, nil, s.err
+}
+
+type mockStatsService struct {
+	qps float32
+	err error
+}
+
+func (s *mockStatsService) GetQPS() (float32, *http.Response, error) {
+	return s.qps, nil, s.err
+}
+
+func TestUsageWarning(t *testing.T) {
+	custom := account.Warning{Send: true, First: 50, Second: 75}
+	table := map[string]struct {
+		warnings warningService
+		expected account.UsageWarning
+	}{
+		"No client": {
+			expected: account.UsageWarning{Records: defaultUsageWarning, Queries: defaultUsageWarning},
+		},
+		"Error": {
+			warnings: &mockWarningService{err: errors.New("unavailable")},
+			expected: account.UsageWarning{Records: defaultUsageWarning, Queries: defaultUsageWarning},
+		},
+		"Custom records thresholds": {
+			warnings: &mockWarningService{warning: &account.UsageWarning{Records: custom}},
+			expected: account.UsageWarning{Records: custom, Queries: defaultUsageWarning},
+		},
+	}
+	for name, v := range table {
+		n := ns1{log: hclog.NewNullLogger(), client: &ns1APIClient{Warnings: v.warnings}}
+		assert.Equal(t, v.expected, n.usageWarning(), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCheckAccountUsage(t *testing.T) {
+	table := map[string]struct {
+		records      int
+		qps          float32
+		pauseCreates bool
+		expected     bool
+	}{
+		"Below thresholds":              {records: 50, qps: 5, pauseCreates: true, expected: false},
+		"Records above first threshold": {records: 90, qps: 5, pauseCreates: true, expected: false},
+		"Records above second":          {records: 95, qps: 5, pauseCreates: true, expected: true},
+		"Queries above second":          {records: 50, qps: 9.6, pauseCreates: true, expected: true},
+		"Above second without pausing":  {records: 100, qps: 10, pauseCreates: false, expected: false},
+	}
+	for name, v := range table {
+		n := ns1{
+			log:           hclog.NewNullLogger(),
+			client:        &ns1APIClient{Stats: &mockStatsService{qps: v.qps}},
+			accountLimits: accountLimits{maxRecords: 100, maxQPS: 10, pauseCreates: v.pauseCreates},
+		}
+		n.accountLimits.observeRecords(v.records)
+		n.checkAccountUsage()
+		assert.Equal(t, v.expected, n.accountLimits.createsPaused(), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestEnforceAccountLimits(t *testing.T) {
+	n := ns1{
+		log:           hclog.NewNullLogger(),
+		addressFamily: ipv4Family,
+		accountLimits: accountLimits{paused: true},
+	}
+	upsert := map[string]service{
+		"new":     {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}},
+		"update":  {ns1IDs: recordIDs{aRecID: "a", srvRecID: "srv"}, nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}},
+		"partial": {ns1IDs: recordIDs{aRecID: "a"}, nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}},
+	}
+	result := n.enforceAccountLimits(upsert)
+	assert.Len(t, result, 1)
+	assert.Contains(t, result, "update")
+
+	n.accountLimits.paused = false
+	assert.Len(t, n.enforceAccountLimits(upsert), 3)
+}
@@ -0,0 +1,108 @@
+package catalog
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// addressFamily selects which instance addresses are eligible for publication
+type addressFamily string
+
+const (
+	// ipv4Family publishes IPv4 addresses in A records
+	ipv4Family addressFamily = "ipv4"
+	// ipv6Family publishes IPv6 addresses in AAAA records
+	ipv6Family addressFamily = "ipv6"
+	// dualFamily publishes both IPv4 and IPv6 addresses
+	dualFamily addressFamily = "dual"
+)
+
+// parseAddressFamily validates an address family, an empty family defaults to ipv4Family
+func parseAddressFamily(s string) (addressFamily, error) {
+	switch addressFamily(s) {
+	case "", ipv4Family:
+		return ipv4Family, nil
+	case ipv6Family, dualFamily:
+		return addressFamily(s), nil
+	}
+	return "", fmt.Errorf("unknown address family %q, must be one of %q, %q or %q", s, ipv4Family, ipv6Family, dualFamily)
+}
+
+// v4 reports whether IPv4 addresses are published
+func (f addressFamily) v4() bool {
+	return f != ipv6Family
+}
+
+// v6 reports whether IPv6 addresses are published
+func (f addressFamily) v6() bool {
+	return f == ipv6Family || f == dualFamily
+}
+
+// dropEmptyAddressFamilies unsets the TTL of the A or AAAA record of the services without addresses of its family,
+// so they are diffed as if they had no record of the family when publishing both families
+func dropEmptyAddressFamilies(services map[string]service) {
+	for k, s := range services {
+		if len(aAnswers(s.nodes)) == 0 {
+			s.ttls.aRecTTL = 0
+		}
+		if len(aaaaAnswers(s.nodes)) == 0 {
+			s.ttls.aaaaRecTTL = 0
+		}
+		services[k] = s
+	}
+}
+
+// instanceAddresses returns the IPv4 and IPv6 addresses of a service instance.
+// The service address takes precedence over the node address, tagged addresses are used
+// to find an address of the other family. An address that isn't an IP (e.g. a hostname)
+// is returned as the IPv4 address when no IP is registered, for backwards compatibility.
+func instanceAddresses(n *consulapi.CatalogService) (v4, v6 string) {
+	primary := n.ServiceAddress
+	if len(primary) == 0 {
+		primary = n.Address
+	}
+	candidates := []string{primary}
+	for _, k := range []string{"lan_ipv4", "lan_ipv6"} {
+		if a, ok := n.ServiceTaggedAddresses[k]; ok {
+			candidates = append(candidates, a.Address)
+		}
+	}
+	candidates = append(candidates, n.Address)
+	for _, k := range []string{"lan_ipv4", "lan_ipv6", "lan"} {
+		if a, ok := n.TaggedAddresses[k]; ok {
+			candidates = append(candidates, a)
+		}
+	}
+
+	for _, a := range candidates {
+		ip := net.ParseIP(a)
+		if ip == nil {
+			continue
+		}
+		if ip.To4() != nil {
+			if v4 == "" {
+				v4 = ip.String()
+			}
+		} else if v6 == "" {
+			v6 = ip.String()
+		}
+	}
+	if v4 == "" && v6 == "" {
+		v4 = primary
+	}
+	return v4, v6
+}
+
+// canonicalAddress returns the canonical form of an IP address, e.g. "2001:db8::1" for "2001:DB8:0:0::1", so the
+// addresses registered in Consul compare equal to the ones read back from NS1. Hostnames, e.g. SRV targets, are
+// returned without their trailing dot.
+func canonicalAddress(a string) string {
+	a = strings.TrimSuffix(a, ".")
+	if ip := net.ParseIP(a); ip != nil {
+		return ip.String()
+	}
+	return a
+}
@@ -0,0 +1,198 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestParseAddressFamily(t *testing.T) {
+	f, err := parseAddressFamily("")
+	assert.NoError(t, err)
+	assert.Equal(t, ipv4Family, f)
+	assert.True(t, f.v4())
+	assert.False(t, f.v6())
+
+	f, err = parseAddressFamily("ipv6")
+	assert.NoError(t, err)
+	assert.False(t, f.v4())
+	assert.True(t, f.v6())
+
+	f, err = parseAddressFamily("dual")
+	assert.NoError(t, err)
+	assert.True(t, f.v4())
+	assert.True(t, f.v6())
+
+	_, err = parseAddressFamily("ipx")
+	assert.Error(t, err)
+}
+
+func TestInstanceAddresses(t *testing.T) {
+	type variant struct {
+		input      *consulapi.CatalogService
+		expectedV4 string
+		expectedV6 string
+	}
+	table := map[string]variant{
+		"node address": {
+			input:      &consulapi.CatalogService{Address: "1.1.1.1"},
+			expectedV4: "1.1.1.1",
+		},
+		"service address takes precedence": {
+			input:      &consulapi.CatalogService{Address: "1.1.1.1", ServiceAddress: "2.2.2.2"},
+			expectedV4: "2.2.2.2",
+		},
+		"IPv6 service address and IPv4 node address": {
+			input:      &consulapi.CatalogService{Address: "1.1.1.1", ServiceAddress: "2001:db8::1"},
+			expectedV4: "1.1.1.1",
+			expectedV6: "2001:db8::1",
+		},
+		"service tagged addresses": {
+			input: &consulapi.CatalogService{
+				Address: "1.1.1.1",
+				ServiceTaggedAddresses: map[string]consulapi.ServiceAddress{
+					"lan_ipv6": {Address: "2001:db8::2"},
+				},
+			},
+			expectedV4: "1.1.1.1",
+			expectedV6: "2001:db8::2",
+		},
+		"node tagged addresses": {
+			input: &consulapi.CatalogService{
+				Address:         "2001:db8::1",
+				TaggedAddresses: map[string]string{"lan_ipv4": "3.3.3.3"},
+			},
+			expectedV4: "3.3.3.3",
+			expectedV6: "2001:db8::1",
+		},
+		"hostname": {
+			input:      &consulapi.CatalogService{Address: "1.1.1.1", ServiceAddress: "elb.example.com"},
+			expectedV4: "1.1.1.1",
+		},
+		"hostname only": {
+			input:      &consulapi.CatalogService{ServiceAddress: "elb.example.com"},
+			expectedV4: "elb.example.com",
+		},
+		"canonical IPv6": {
+			input:      &consulapi.CatalogService{Address: "2001:DB8:0:0::1"},
+			expectedV6: "2001:db8::1",
+		},
+		"IPv4-mapped IPv6": {
+			input:      &consulapi.CatalogService{Address: "::ffff:1.1.1.1"},
+			expectedV4: "1.1.1.1",
+		},
+	}
+	for name, v := range table {
+		v4, v6 := instanceAddresses(v.input)
+		assert.Equal(t, v.expectedV4, v4, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expectedV6, v6, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCanonicalAddress(t *testing.T) {
+	table := map[string]string{
+		"1.1.1.1":               "1.1.1.1",
+		"2001:db8::1":           "2001:db8::1",
+		"2001:0DB8:0000::0001":  "2001:db8::1",
+		"::ffff:1.1.1.1":        "1.1.1.1",
+		"p-h1.p-web.test.zone.": "p-h1.p-web.test.zone",
+		"elb.example.com":       "elb.example.com",
+	}
+	for address, expected := range table {
+		assert.Equal(t, expected, canonicalAddress(address), fmt.Sprintf("Test case: %s", address))
+	}
+}
+
+func TestTransformZoneRecords_MixedSRVTargets(t *testing.T) {
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, addressFamily: dualFamily, log: hclog.NewNullLogger()}
+	desired := map[string]service{
+		"web": {nodes: map[string]node{
+			"n1/web": {address: "1.1.1.1", aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}},
+			"n2/web": {address: "2001:db8::2", aaaaRecAnswer: "2001:db8::2", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2001:db8::2"}}},
+			"n3/web": {address: "3.3.3.3", aRecAnswer: "3.3.3.3", aaaaRecAnswer: "2001:db8::3",
+				srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "3.3.3.3"}}},
+			"n4/web": {address: "elb.example.com", srvRecAnswers: map[int]srvAnswer{443: {1, 1, 443, "elb.example.com"}}},
+		}, ttls: recordTTLs{aRecTTL: 10, aaaaRecTTL: 10, srvRecTTL: 10}},
+	}
+	// answers written by other tools may use any form of IPv6 literals and fully qualified targets
+	z := &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+		{Domain: "web.test.zone", ID: "1", Type: "A", TTL: 10, ShortAns: []string{"1.1.1.1", "3.3.3.3"}},
+		{Domain: "web.test.zone", ID: "2", Type: "AAAA", TTL: 10, ShortAns: []string{"2001:DB8::2", "2001:db8:0:0::3"}},
+		{Domain: "web.test.zone", ID: "3", Type: "SRV", TTL: 10, ShortAns: []string{
+			"1 1 80 1.1.1.1", "1 1 80 2001:0db8::0002", "1 1 80 3.3.3.3", "1 1 443 elb.example.com.", "1 1 8080",
+		}},
+	}}
+	actual := n.transformZoneRecords(z)
+	assert.Empty(t, onlyInFirst(desired, actual))
+	assert.Empty(t, onlyInFirst(actual, desired))
+	assert.Equal(t, []string{"2001:db8::2", "2001:db8::3"}, aaaaAnswers(actual["web"].nodes))
+}
+
+func TestConsulTransformNodes_AddressFamily(t *testing.T) {
+	cnodes := []*consulapi.CatalogService{
+		{Node: "n1", Address: "1.1.1.1", ServiceID: "s1", ServicePort: 1},
+		{Node: "n2", Address: "2001:db8::2", ServiceID: "s1", ServicePort: 1},
+		{Node: "n3", Address: "3.3.3.3", ServiceAddress: "2001:db8::3", ServiceID: "s1", ServicePort: 1},
+	}
+	type variant struct {
+		family   addressFamily
+		expected map[string][2]string
+	}
+	table := map[string]variant{
+		"ipv4": {
+			family: ipv4Family,
+			expected: map[string][2]string{
+				"n1/s1": {"1.1.1.1", ""},
+				"n3/s1": {"3.3.3.3", ""},
+			},
+		},
+		"ipv6": {
+			family: ipv6Family,
+			expected: map[string][2]string{
+				"n2/s1": {"", "2001:db8::2"},
+				"n3/s1": {"", "2001:db8::3"},
+			},
+		},
+		"dual": {
+			family: dualFamily,
+			expected: map[string][2]string{
+				"n1/s1": {"1.1.1.1", ""},
+				"n2/s1": {"", "2001:db8::2"},
+				"n3/s1": {"3.3.3.3", "2001:db8::3"},
+			},
+		},
+	}
+	for name, v := range table {
+		c := consul{addressFamily: v.family}
+		actual := map[string][2]string{}
+		for k, n := range c.transformNodes(cnodes) {
+			actual[k] = [2]string{n.aRecAnswer, n.aaaaRecAnswer}
+		}
+		assert.Equal(t, v.expected, actual, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCreate_WithAddressFamily(t *testing.T) {
+	n := testClient(nil)
+	n.addressFamily = ipv6Family
+	n.client = &ns1APIClient{
+		Zones:   &mockZoneService{},
+		Records: &mockRecordService{mux: &sync.Mutex{}},
+	}
+	input := map[string]service{
+		"s1": {
+			nodes:     map[string]node{"n1/s1": {aaaaRecAnswer: "2001:db8::1"}},
+			unchanged: recordTypes{srvRec: true},
+		},
+	}
+	expectedRecords := []*dns.Record{
+		newTestRecord("AAAA", "s1", n.serviceZone.name, []string{"2001:db8::1"}),
+	}
+	assert.Equal(t, int32(1), n.create(input))
+	assert.ElementsMatch(t, expectedRecords, n.client.Records.(*mockRecordService).records)
+}
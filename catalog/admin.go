@@ -0,0 +1,63 @@
+package catalog
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// adminHandler serves the admin API
+func adminHandler(approval *approvalGate, events *eventStream, cycle *syncCycle, polls *ns1) http.Handler {
+	mux := http.NewServeMux()
+	if approval != nil {
+		mux.HandleFunc("/v1/changes/pending", approval.handlePending)
+		mux.HandleFunc("/v1/changes/approve", approval.handleApprove)
+	}
+	if events != nil {
+		mux.HandleFunc("/v1/events", events.handleEvents)
+	}
+	if cycle != nil {
+		mux.HandleFunc("/v1/cycle", cycle.handleStatus)
+	}
+	if polls != nil && polls.tuner != nil {
+		mux.HandleFunc("/v1/poll-interval", polls.handleInterval)
+	}
+	return mux
+}
+
+// handleStatus responds with the state of the sync cycle
+func (c *syncCycle) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.status())
+}
+
+// handlePending responds with the pending change set, or 404 if there is none
+func (g *approvalGate) handlePending(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	cs, ok := g.pendingChanges()
+	if !ok {
+		http.Error(w, "no pending changes", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cs)
+}
+
+// handleApprove approves the pending change set given by the `id` query parameter
+func (g *approvalGate) handleApprove(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := g.approve(r.URL.Query().Get("id")); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
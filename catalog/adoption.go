@@ -0,0 +1,81 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// adoptionClass classifies unmarked records found under the service prefix
+type adoptionClass string
+
+const (
+	// toAdopt records belong to a registered service and already match its desired state
+	toAdopt adoptionClass = "adopt"
+	// conflicting records belong to a registered service but don't match its desired state
+	conflicting adoptionClass = "conflict"
+	// ignored records don't belong to any registered service
+	ignored adoptionClass = "ignore"
+)
+
+// adoptionEntry is a service found in NS1 without an ownership record
+type adoptionEntry struct {
+	service string
+	class   adoptionClass
+}
+
+// adoptionReport classifies the services read from NS1 that have records but no ownership record
+func adoptionReport(desired, existing map[string]service) []adoptionEntry {
+	report := []adoptionEntry{}
+	for k, s := range existing {
+		if !s.unmanaged() {
+			continue
+		}
+		class := ignored
+		if d, ok := desired[k]; ok {
+			class = conflicting
+			if onlyOwnerDiffers(d, s) {
+				class = toAdopt
+			}
+		}
+		report = append(report, adoptionEntry{service: k, class: class})
+	}
+	sort.Slice(report, func(i, j int) bool { return report[i].service < report[j].service })
+	return report
+}
+
+// adoptionAction describes what the conflict policy does with records of a class
+func (n *ns1) adoptionAction(class adoptionClass) string {
+	switch {
+	case n.conflictPolicy == adoptConflicts && class == ignored:
+		return "delete"
+	case n.conflictPolicy == adoptConflicts:
+		return "overwrite and mark"
+	case n.conflictPolicy == errorConflicts && class != ignored:
+		return "stop syncing"
+	}
+	return "leave alone"
+}
+
+// reportAdoption logs the services found in NS1 without an ownership record, what the conflict policy does
+// with them, and sets a gauge per class. It is called on the first cycle so operators can review what would
+// be taken over before enabling adoption.
+func (n *ns1) reportAdoption(desired, existing map[string]service) {
+	if !n.ownershipRegistry {
+		return
+	}
+	counts := map[adoptionClass]int{toAdopt: 0, conflicting: 0, ignored: 0}
+	for _, e := range adoptionReport(desired, existing) {
+		counts[e.class]++
+		n.log.Warn("unmarked records found under the service prefix", "service", e.service,
+			"class", string(e.class), "action", n.adoptionAction(e.class), "policy", string(n.conflictPolicy))
+	}
+	for class, count := range counts {
+		metrics.SetGaugeWithLabels([]string{"ownership", "unmarked"}, float32(count),
+			[]metrics.Label{{Name: "class", Value: string(class)}})
+	}
+	n.log.Info("adoption report", "adopt", fmt.Sprintf("%d", counts[toAdopt]),
+		"conflict", fmt.Sprintf("%d", counts[conflicting]), "ignore", fmt.Sprintf("%d", counts[ignored]),
+		"policy", string(n.conflictPolicy))
+}
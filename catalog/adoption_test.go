@@ -0,0 +1,46 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdoptionReport(t *testing.T) {
+	desired := map[string]service{
+		"same":    {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}, ttls: recordTTLs{aRecTTL: 10}},
+		"changed": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}, ttls: recordTTLs{aRecTTL: 10}},
+		"marked":  {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}, ttls: recordTTLs{aRecTTL: 10}},
+	}
+	existing := map[string]service{
+		"same":    {nodes: map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}}, ttls: recordTTLs{aRecTTL: 10}, ns1IDs: recordIDs{aRecID: "1"}},
+		"changed": {nodes: map[string]node{"2.2.2.2": {aRecAnswer: "2.2.2.2"}}, ttls: recordTTLs{aRecTTL: 10}, ns1IDs: recordIDs{aRecID: "2"}},
+		"marked":  {ns1IDs: recordIDs{aRecID: "3", ownerRecID: "4"}},
+		"manual":  {nodes: map[string]node{"3.3.3.3": {aRecAnswer: "3.3.3.3"}}, ns1IDs: recordIDs{aRecID: "5"}},
+	}
+	assert.Equal(t, []adoptionEntry{
+		{service: "changed", class: conflicting},
+		{service: "manual", class: ignored},
+		{service: "same", class: toAdopt},
+	}, adoptionReport(desired, existing))
+}
+
+func TestAdoptionAction(t *testing.T) {
+	table := map[string]struct {
+		policy   conflictPolicy
+		class    adoptionClass
+		expected string
+	}{
+		"skip adopt":     {policy: skipConflicts, class: toAdopt, expected: "leave alone"},
+		"skip ignore":    {policy: skipConflicts, class: ignored, expected: "leave alone"},
+		"adopt conflict": {policy: adoptConflicts, class: conflicting, expected: "overwrite and mark"},
+		"adopt ignore":   {policy: adoptConflicts, class: ignored, expected: "delete"},
+		"error conflict": {policy: errorConflicts, class: conflicting, expected: "stop syncing"},
+		"error ignore":   {policy: errorConflicts, class: ignored, expected: "leave alone"},
+	}
+	for name, v := range table {
+		n := ns1{conflictPolicy: v.policy}
+		assert.Equal(t, v.expected, n.adoptionAction(v.class), fmt.Sprintf("Test case: %s", name))
+	}
+}
@@ -0,0 +1,192 @@
+package catalog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+// changeSet describes the changes of a sync cycle held back for approval
+type changeSet struct {
+	// ID is derived from the changes, so an approval only applies to the exact changes that were reviewed
+	ID      string    `json:"id"`
+	Changes []string  `json:"changes"`
+	Since   time.Time `json:"since"`
+}
+
+// newChangeSet describes the services to upsert and remove, one change per line sorted by service
+func newChangeSet(upsert, remove map[string]service) changeSet {
+	changes := []string{}
+	for k, s := range upsert {
+		answers := []string{}
+		if a := aAnswers(s.nodes); len(a) > 0 {
+			answers = append(answers, "A="+strings.Join(a, ","))
+		}
+		if a := aaaaAnswers(s.nodes); len(a) > 0 {
+			answers = append(answers, "AAAA="+strings.Join(a, ","))
+		}
+		srvs := []string{}
+		for _, a := range srvAnswers(s.nodes) {
+			srvs = append(srvs, a.String())
+		}
+		if len(srvs) > 0 {
+			answers = append(answers, "SRV="+strings.Join(srvs, ","))
+		}
+		if s.txtRecAnswer != "" {
+			answers = append(answers, "TXT="+s.txtRecAnswer)
+		}
+		if s.cnameRecAnswer != "" {
+			answers = append(answers, "CNAME="+s.cnameRecAnswer)
+		}
+		changes = append(changes, strings.Join(append([]string{"upsert", k}, answers...), " "))
+	}
+	for k := range remove {
+		changes = append(changes, "remove "+k)
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return strings.SplitN(changes[i], " ", 3)[1] < strings.SplitN(changes[j], " ", 3)[1]
+	})
+	sum := sha256.Sum256([]byte(strings.Join(changes, "\n")))
+	return changeSet{ID: hex.EncodeToString(sum[:])[:12], Changes: changes}
+}
+
+// approvalGate holds back sync cycles changing more services than a threshold until an operator approves them,
+// either through the admin API or by writing the ID of the change set to a Consul KV key
+type approvalGate struct {
+	log hclog.Logger
+	// threshold is the number of changed services a cycle may apply without approval
+	threshold int
+	// file is written with the pending change set, if set
+	file string
+	// key is a Consul KV key approving the pending change set whose ID it holds, if set
+	key    string
+	client *consulapi.Client
+	// onApprove is called when the pending change set is approved
+	onApprove func()
+	clock     *clock
+
+	lock     sync.Mutex
+	pending  *changeSet
+	approved string
+}
+
+// newApprovalGate returns the approval gate of a configuration, nil if approval mode is disabled. Approval mode is
+// opt-in, a zero-value configuration never holds back changes.
+func newApprovalGate(cfg Config, client *consulapi.Client, onApprove func()) (*approvalGate, error) {
+	if !cfg.Approval {
+		return nil, nil
+	}
+	if cfg.ApprovalThreshold < 0 {
+		return nil, errors.New("the approval threshold must not be negative")
+	}
+	if cfg.AdminAddr == "" && cfg.ApprovalKey == "" {
+		return nil, errors.New("approval mode requires the admin API or an approval key")
+	}
+	return &approvalGate{
+		log:       hclog.Default().Named("approval"),
+		threshold: cfg.ApprovalThreshold,
+		file:      cfg.ApprovalFile,
+		key:       cfg.ApprovalKey,
+		client:    client,
+		onApprove: onApprove,
+	}, nil
+}
+
+// allow reports whether the changes of a sync cycle may be applied. Changes above the threshold
+// become the pending change set until they are approved.
+func (g *approvalGate) allow(upsert, remove map[string]service) bool {
+	if g == nil || len(upsert)+len(remove) <= g.threshold {
+		return true
+	}
+	cs := newChangeSet(upsert, remove)
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.pending == nil || g.pending.ID != cs.ID {
+		cs.Since = g.clock.Now()
+		g.pending, g.approved = &cs, ""
+		g.log.Warn("changes above approval threshold, waiting for approval", "id", cs.ID,
+			"changes", fmt.Sprintf("%d", len(cs.Changes)), "threshold", fmt.Sprintf("%d", g.threshold))
+		g.writeFile()
+	}
+	metrics.SetGauge([]string{"approval", "pending"}, float32(len(cs.Changes)))
+	if g.approved != cs.ID && !g.approvedByKey(cs.ID) {
+		return false
+	}
+	g.log.Info("applying approved changes", "id", cs.ID)
+	g.pending, g.approved = nil, ""
+	g.writeFile()
+	metrics.SetGauge([]string{"approval", "pending"}, 0)
+	return true
+}
+
+// approve approves the pending change set with the given ID
+func (g *approvalGate) approve(id string) error {
+	g.lock.Lock()
+	if g.pending == nil || g.pending.ID != id {
+		g.lock.Unlock()
+		return fmt.Errorf("no pending changes with id %q", id)
+	}
+	g.approved = id
+	g.lock.Unlock()
+	g.log.Info("changes approved", "id", id)
+	if g.onApprove != nil {
+		g.onApprove()
+	}
+	return nil
+}
+
+// pendingChanges returns a copy of the pending change set, if any
+func (g *approvalGate) pendingChanges() (changeSet, bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.pending == nil {
+		return changeSet{}, false
+	}
+	return *g.pending, true
+}
+
+// approvedByKey reports whether the approval key holds the given change set ID
+func (g *approvalGate) approvedByKey(id string) bool {
+	if g.key == "" || g.client == nil {
+		return false
+	}
+	pair, _, err := g.client.KV().Get(g.key, nil)
+	if err != nil {
+		g.log.Error("cannot read approval key", "key", g.key, "error", err.Error())
+		return false
+	}
+	return pair != nil && strings.TrimSpace(string(pair.Value)) == id
+}
+
+// writeFile writes the pending change set to the pending changes file, or removes it if there is none.
+// Must be called with the lock held.
+func (g *approvalGate) writeFile() {
+	if g.file == "" {
+		return
+	}
+	if g.pending == nil {
+		if err := os.Remove(g.file); err != nil && !os.IsNotExist(err) {
+			g.log.Error("cannot remove pending changes file", "file", g.file, "error", err.Error())
+		}
+		return
+	}
+	b, err := json.MarshalIndent(g.pending, "", "  ")
+	if err == nil {
+		err = ioutil.WriteFile(g.file, b, 0644)
+	}
+	if err != nil {
+		g.log.Error("cannot write pending changes file", "file", g.file, "error", err.Error())
+	}
+}
@@ -0,0 +1,126 @@
+package catalog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewChangeSet(t *testing.T) {
+	upsert := map[string]service{
+		"s2": {nodes: map[string]node{"n1/s2": {
+			aRecAnswer:    "1.1.1.1",
+			srvRecAnswers: map[int]srvAnswer{80: {priority: 1, weight: 1, port: 80, address: "1.1.1.1"}},
+		}}},
+	}
+	remove := map[string]service{"s1": {}}
+	cs := newChangeSet(upsert, remove)
+	assert.Equal(t, []string{"remove s1", "upsert s2 A=1.1.1.1 SRV=1 1 80 1.1.1.1"}, cs.Changes)
+	assert.Len(t, cs.ID, 12)
+	assert.Equal(t, cs.ID, newChangeSet(upsert, remove).ID)
+	assert.NotEqual(t, cs.ID, newChangeSet(upsert, nil).ID)
+}
+
+func TestApprovalGate(t *testing.T) {
+	approved := 0
+	g := &approvalGate{log: hclog.NewNullLogger(), threshold: 1, onApprove: func() { approved++ }}
+	small := map[string]service{"s1": {}}
+	large := map[string]service{"s1": {}, "s2": {}}
+
+	assert.True(t, g.allow(small, nil))
+	_, ok := g.pendingChanges()
+	assert.False(t, ok)
+
+	assert.False(t, g.allow(large, nil))
+	cs, ok := g.pendingChanges()
+	require.True(t, ok)
+	assert.Error(t, g.approve("unknown"))
+	assert.False(t, g.allow(large, nil))
+
+	// changes differing from the reviewed ones need a new approval
+	require.NoError(t, g.approve(cs.ID))
+	assert.Equal(t, 1, approved)
+	assert.False(t, g.allow(large, small))
+	assert.Error(t, g.approve(cs.ID))
+
+	cs, _ = g.pendingChanges()
+	require.NoError(t, g.approve(cs.ID))
+	assert.True(t, g.allow(large, small))
+	_, ok = g.pendingChanges()
+	assert.False(t, ok)
+
+	var disabled *approvalGate
+	assert.True(t, disabled.allow(large, small))
+}
+
+func TestApprovalGateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-ns1")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pending.json")
+	g := &approvalGate{log: hclog.NewNullLogger(), threshold: 0, file: file}
+
+	assert.False(t, g.allow(map[string]service{"s1": {}}, nil))
+	b, err := ioutil.ReadFile(file)
+	require.NoError(t, err)
+	var cs changeSet
+	require.NoError(t, json.Unmarshal(b, &cs))
+	assert.Equal(t, []string{"upsert s1"}, cs.Changes)
+
+	require.NoError(t, g.approve(cs.ID))
+	assert.True(t, g.allow(map[string]service{"s1": {}}, nil))
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestAdminHandlerApproval(t *testing.T) {
+	g := &approvalGate{log: hclog.NewNullLogger(), threshold: 0}
+	srv := httptest.NewServer(adminHandler(g, nil, nil, nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/changes/pending")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	g.allow(map[string]service{"s1": {}}, nil)
+	resp, err = http.Get(srv.URL + "/v1/changes/pending")
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var cs changeSet
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&cs))
+	resp.Body.Close()
+
+	resp, err = http.Post(srv.URL+"/v1/changes/approve?id=unknown", "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusConflict, resp.StatusCode)
+
+	resp, err = http.Post(srv.URL+"/v1/changes/approve?id="+cs.ID, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	assert.True(t, g.allow(map[string]service{"s1": {}}, nil))
+}
+
+func TestNewApprovalGate(t *testing.T) {
+	// approval mode is opt-in, the zero-value configuration never holds back changes
+	g, err := newApprovalGate(Config{}, nil, nil)
+	assert.NoError(t, err)
+	assert.Nil(t, g)
+	assert.True(t, g.allow(map[string]service{"s1": {}}, nil))
+
+	_, err = newApprovalGate(Config{Approval: true}, nil, nil)
+	assert.Error(t, err, "approval mode requires the admin API or an approval key")
+	_, err = newApprovalGate(Config{Approval: true, ApprovalThreshold: -1, ApprovalKey: "k"}, nil, nil)
+	assert.Error(t, err, "the approval threshold must not be negative")
+
+	g, err = newApprovalGate(Config{Approval: true, ApprovalKey: "k"}, nil, nil)
+	assert.NoError(t, err)
+	assert.False(t, g.allow(map[string]service{"s1": {}}, nil), "a zero threshold holds back any change")
+}
@@ -0,0 +1,170 @@
+package catalog
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// bootstrapNodeMeta marks the nodes registered by bootstrap as external, so they aren't reaped by anti-entropy
+// and health checking is left to e.g. consul-esm
+var bootstrapNodeMeta = map[string]string{"external-node": "true", "external-probe": "false"}
+
+// BootstrapResult is an external service instance derived from the records of an NS1 zone
+type BootstrapResult struct {
+	Registration *consulapi.CatalogRegistration
+	// Skipped is set when the instance isn't registered, e.g. because the service is already registered in Consul
+	Skipped string
+}
+
+// Bootstrap reads the records published under the configured prefix in the NS1 zone and registers the
+// external services they describe into the Consul catalog, unless `dryRun` is set. Services already
+// registered in Consul and records marked by another instance in the ownership registry are skipped.
+func Bootstrap(cfg Config, ns1Client *ns1api.Client, consulClient *consulapi.Client, dryRun bool) ([]BootstrapResult, error) {
+	log := hclog.Default().Named("bootstrap")
+	zone, _, err := ns1Client.Zones.Get(cfg.NS1Domain)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching zone %s: %s", cfg.NS1Domain, err)
+	}
+	registered, _, err := consulClient.Catalog().Services(&consulapi.QueryOptions{AllowStale: cfg.Stale})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching services: %s", err)
+	}
+	existing := map[string]bool{}
+	for name := range registered {
+		existing[strings.ToLower(name)] = true
+	}
+
+	results := []BootstrapResult{}
+	for _, r := range bootstrapRegistrations(zone, cfg.NS1Prefix) {
+		result := BootstrapResult{Registration: r}
+		if existing[strings.ToLower(r.Service.Service)] {
+			result.Skipped = "service is already registered in Consul"
+		} else if !dryRun {
+			if _, err := consulClient.Catalog().Register(r, nil); err != nil {
+				return results, fmt.Errorf("error registering service %s on node %s: %s", r.Service.Service, r.Node, err)
+			}
+			log.Info("registered external service", "service", r.Service.Service, "node", r.Node,
+				"address", r.Service.Address, "port", fmt.Sprintf("%d", r.Service.Port))
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// bootstrapRegistrations returns the catalog registrations of the services described by the records of a zone
+// published under `prefix`, sorted by service, node and port. Each address of a service is registered on an
+// external node of its own: SRV answers are registered with their port, A and AAAA answers not targeted by an
+// SRV answer without a port, and CNAME answers as virtual-hosted services.
+func bootstrapRegistrations(zone *dns.Zone, prefix string) []*consulapi.CatalogRegistration {
+	owners := zoneOwners(zone)
+	type endpoint struct {
+		address string
+		port    int
+	}
+	endpoints := map[string]map[endpoint]bool{}
+	hostnames := map[string]string{}
+	targeted := map[string]map[string]bool{}
+	suffix := "." + zone.Zone
+	for _, record := range zone.Records {
+		if isOwnerRecord(record) || !strings.HasSuffix(record.Domain, suffix) {
+			continue
+		}
+		if owner, ok := owners[record.Domain]; ok && owner != prefix {
+			continue
+		}
+		label := strings.TrimSuffix(record.Domain, suffix)
+		if strings.Contains(label, ".") || !strings.HasPrefix(label, prefix) || label == prefix {
+			continue
+		}
+		name := strings.TrimPrefix(label, prefix)
+		if endpoints[name] == nil {
+			endpoints[name], targeted[name] = map[endpoint]bool{}, map[string]bool{}
+		}
+		for _, ans := range record.ShortAns {
+			switch record.Type {
+			case "A", "AAAA":
+				if net.ParseIP(ans) != nil {
+					endpoints[name][endpoint{address: ans}] = true
+				}
+			case "SRV":
+				fields := strings.Fields(ans)
+				if len(fields) != 4 {
+					continue
+				}
+				port, err := strconv.Atoi(fields[2])
+				if err != nil {
+					continue
+				}
+				address := strings.TrimSuffix(fields[3], ".")
+				endpoints[name][endpoint{address: address, port: port}] = true
+				targeted[name][address] = true
+			case "CNAME":
+				hostnames[name] = strings.TrimSuffix(ans, ".")
+			}
+		}
+	}
+
+	registrations := []*consulapi.CatalogRegistration{}
+	for name, eps := range endpoints {
+		if h, ok := hostnames[name]; ok {
+			registrations = append(registrations, &consulapi.CatalogRegistration{
+				Node:     bootstrapNodeName(h),
+				Address:  h,
+				NodeMeta: bootstrapNodeMeta,
+				Service: &consulapi.AgentService{
+					ID:      name,
+					Service: name,
+					Address: h,
+					Meta:    map[string]string{hostnameMetaKey: h},
+				},
+				SkipNodeUpdate: true,
+			})
+			continue
+		}
+		for ep := range eps {
+			if ep.port == 0 && targeted[name][ep.address] {
+				continue
+			}
+			id := name
+			if ep.port != 0 {
+				id = fmt.Sprintf("%s-%d", name, ep.port)
+			}
+			registrations = append(registrations, &consulapi.CatalogRegistration{
+				Node:     bootstrapNodeName(ep.address),
+				Address:  ep.address,
+				NodeMeta: bootstrapNodeMeta,
+				Service: &consulapi.AgentService{
+					ID:      id,
+					Service: name,
+					Address: ep.address,
+					Port:    ep.port,
+				},
+				SkipNodeUpdate: true,
+			})
+		}
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		a, b := registrations[i], registrations[j]
+		if a.Service.Service != b.Service.Service {
+			return a.Service.Service < b.Service.Service
+		}
+		if a.Node != b.Node {
+			return a.Node < b.Node
+		}
+		return a.Service.Port < b.Service.Port
+	})
+	return registrations
+}
+
+// bootstrapNodeName returns the name of the external node registered for an address
+func bootstrapNodeName(address string) string {
+	return "ns1-" + strings.NewReplacer(".", "-", ":", "-").Replace(address)
+}
@@ -0,0 +1,44 @@
+package catalog
+
+import (
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestBootstrapRegistrations(t *testing.T) {
+	zone := &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+		{Domain: "p-web.test.zone", Type: "A", ShortAns: []string{"1.1.1.1", "2.2.2.2"}},
+		{Domain: "p-web.test.zone", Type: "SRV", ShortAns: []string{"1 1 80 1.1.1.1", "1 1 8080 1.1.1.1"}},
+		{Domain: "p-web.test.zone", Type: "TXT", ShortAns: []string{"port=80"}},
+		{Domain: "p-api.test.zone", Type: "CNAME", ShortAns: []string{"ingress.example.com."}},
+		{Domain: "p-v6.test.zone", Type: "AAAA", ShortAns: []string{"::1"}},
+		{Domain: "p-other.test.zone", Type: "A", ShortAns: []string{"3.3.3.3"}},
+		{Domain: ownerRecordLabel + "p-other.test.zone", Type: "TXT", ShortAns: []string{ownerTXTAnswer("q-")}},
+		{Domain: "unprefixed.test.zone", Type: "A", ShortAns: []string{"4.4.4.4"}},
+		{Domain: "a.p-nested.test.zone", Type: "A", ShortAns: []string{"5.5.5.5"}},
+		{Domain: "test.zone", Type: "A", ShortAns: []string{"6.6.6.6"}},
+	}}
+
+	external := func(node, service, id, address string, port int) *consulapi.CatalogRegistration {
+		return &consulapi.CatalogRegistration{
+			Node:           node,
+			Address:        address,
+			NodeMeta:       bootstrapNodeMeta,
+			Service:        &consulapi.AgentService{ID: id, Service: service, Address: address, Port: port},
+			SkipNodeUpdate: true,
+		}
+	}
+	vhost := external("ns1-ingress-example-com", "api", "api", "ingress.example.com", 0)
+	vhost.Service.Meta = map[string]string{hostnameMetaKey: "ingress.example.com"}
+
+	assert.Equal(t, []*consulapi.CatalogRegistration{
+		vhost,
+		external("ns1---1", "v6", "v6", "::1", 0),
+		external("ns1-1-1-1-1", "web", "web-80", "1.1.1.1", 80),
+		external("ns1-1-1-1-1", "web", "web-8080", "1.1.1.1", 8080),
+		external("ns1-2-2-2-2", "web", "web", "2.2.2.2", 0),
+	}, bootstrapRegistrations(zone, "p-"))
+}
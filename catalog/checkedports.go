@@ -0,0 +1,58 @@
+package catalog
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// checkPort returns the port a TCP or HTTP health check targets, or 0 for other checks, e.g. TTL or script checks
+func checkPort(check *consulapi.HealthCheck) int {
+	var port string
+	switch {
+	case check.Definition.TCP != "":
+		_, p, err := net.SplitHostPort(check.Definition.TCP)
+		if err != nil {
+			return 0
+		}
+		port = p
+	case check.Definition.HTTP != "":
+		u, err := url.Parse(check.Definition.HTTP)
+		if err != nil {
+			return 0
+		}
+		port = u.Port()
+		if port == "" && u.Scheme == "https" {
+			port = "443"
+		} else if port == "" {
+			port = "80"
+		}
+	}
+	p, _ := strconv.Atoi(port)
+	return p
+}
+
+// publishCheckedPortsOnly drops the SRV answers of the instances whose port isn't targeted by a health check of
+// the service on their node, so ports that are never validated aren't advertised. Instances on nodes whose
+// checks target no port, e.g. TTL or script checks, keep their answers.
+func publishCheckedPortsOnly(nodes map[string]node, checks consulapi.HealthChecks) map[string]node {
+	checked := map[string]map[int]bool{}
+	for _, check := range checks {
+		if p := checkPort(check); p != 0 {
+			if checked[check.Node] == nil {
+				checked[check.Node] = map[int]bool{}
+			}
+			checked[check.Node][p] = true
+		}
+	}
+	result := make(map[string]node, len(nodes))
+	for k, n := range nodes {
+		if ports, ok := checked[n.host]; ok && !ports[n.port] {
+			n.srvRecAnswers = map[int]srvAnswer{}
+		}
+		result[k] = n
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPort(t *testing.T) {
+	table := map[string]struct {
+		definition consulapi.HealthCheckDefinition
+		expected   int
+	}{
+		"tcp":           {consulapi.HealthCheckDefinition{TCP: "10.0.0.1:8080"}, 8080},
+		"http":          {consulapi.HealthCheckDefinition{HTTP: "http://10.0.0.1:9000/health"}, 9000},
+		"http default":  {consulapi.HealthCheckDefinition{HTTP: "http://10.0.0.1/health"}, 80},
+		"https default": {consulapi.HealthCheckDefinition{HTTP: "https://10.0.0.1/health"}, 443},
+		"ttl":           {consulapi.HealthCheckDefinition{}, 0},
+		"invalid":       {consulapi.HealthCheckDefinition{TCP: "10.0.0.1"}, 0},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, checkPort(&consulapi.HealthCheck{Definition: v.definition}), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestPublishCheckedPortsOnly(t *testing.T) {
+	instance := func(host string, port int) node {
+		return node{host: host, port: port, aRecAnswer: "1.1.1.1",
+			srvRecAnswers: map[int]srvAnswer{port: {priority: 1, weight: 1, port: int64(port), address: "1.1.1.1"}}}
+	}
+	nodes := map[string]node{
+		"n1/web":       instance("n1", 80),
+		"n1/web-admin": instance("n1", 9000),
+		"n2/web":       instance("n2", 80),
+	}
+	checks := consulapi.HealthChecks{
+		{Node: "n1", ServiceID: "web", Definition: consulapi.HealthCheckDefinition{HTTP: "http://1.1.1.1:80/health"}},
+		{Node: "n1", ServiceID: "web-admin", Definition: consulapi.HealthCheckDefinition{}},
+		{Node: "n2", ServiceID: "web", Definition: consulapi.HealthCheckDefinition{}},
+	}
+
+	published := publishCheckedPortsOnly(nodes, checks)
+	assert.Len(t, published["n1/web"].srvRecAnswers, 1)
+	assert.Empty(t, published["n1/web-admin"].srvRecAnswers, "unchecked ports aren't published")
+	assert.Len(t, published["n2/web"].srvRecAnswers, 1, "nodes without port checks are published unchanged")
+	assert.Equal(t, []string{"1.1.1.1"}, aAnswers(published))
+	assert.Len(t, nodes["n1/web-admin"].srvRecAnswers, 1, "nodes are not modified")
+}
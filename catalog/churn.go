@@ -0,0 +1,77 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+)
+
+// churnWindow is the sliding window the changes of a service are counted over
+const churnWindow = time.Hour
+
+// churn counts the changes written to NS1 for each service over the last hour and reports services exceeding
+// a threshold, since a flapping deployment can dominate the NS1 quota and mask real changes.
+// A nil churn counts nothing.
+type churn struct {
+	log   hclog.Logger
+	clock *clock
+	// threshold is the number of changes per hour above which a service is reported
+	threshold int
+	// changes holds the times of the changes of each service within the window
+	changes map[string][]time.Time
+	// exceeded holds the services currently above the threshold
+	exceeded map[string]bool
+}
+
+// record counts the services upserted or removed by a sync cycle, publishes the number of changes of each
+// service within the window and reports services crossing the threshold in either direction
+func (c *churn) record(upsert, remove map[string]service) {
+	if c == nil {
+		return
+	}
+	if c.changes == nil {
+		c.changes, c.exceeded = map[string][]time.Time{}, map[string]bool{}
+	}
+	now := c.clock.Now()
+	for _, changed := range []map[string]service{upsert, remove} {
+		for k := range changed {
+			c.changes[k] = append(c.changes[k], now)
+		}
+	}
+
+	names := make([]string, 0, len(c.changes))
+	for k := range c.changes {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		changes := c.changes[k]
+		for len(changes) > 0 && now.Sub(changes[0]) >= churnWindow {
+			changes = changes[1:]
+		}
+		labels := []metrics.Label{{Name: "service", Value: k}}
+		metrics.SetGaugeWithLabels([]string{"service", "churn"}, float32(len(changes)), labels)
+		if len(changes) == 0 {
+			delete(c.changes, k)
+		} else {
+			c.changes[k] = changes
+		}
+
+		exceeded := len(changes) > c.threshold
+		switch {
+		case exceeded && !c.exceeded[k]:
+			c.log.Warn("service changes more often than the churn threshold, it may be flapping", "service", k,
+				"changes", fmt.Sprintf("%d", len(changes)), "window", churnWindow.String(),
+				"threshold", fmt.Sprintf("%d", c.threshold))
+			metrics.IncrCounterWithLabels([]string{"service", "churn_exceeded"}, 1, labels)
+			c.exceeded[k] = true
+		case !exceeded && c.exceeded[k]:
+			c.log.Info("service changes less often than the churn threshold again", "service", k,
+				"changes", fmt.Sprintf("%d", len(changes)), "window", churnWindow.String())
+			delete(c.exceeded, k)
+		}
+	}
+}
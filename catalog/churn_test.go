@@ -0,0 +1,35 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChurn(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	c := &churn{log: hclog.NewNullLogger(), clock: &clock{now: func() time.Time { return now }}, threshold: 2}
+	changed := map[string]service{"web": {}}
+
+	c.record(changed, map[string]service{"old": {}})
+	now = now.Add(10 * time.Minute)
+	c.record(changed, map[string]service{})
+	assert.Len(t, c.changes["web"], 2)
+	assert.Empty(t, c.exceeded, "changes at the threshold aren't reported")
+
+	now = now.Add(10 * time.Minute)
+	c.record(changed, map[string]service{})
+	assert.Equal(t, map[string]bool{"web": true}, c.exceeded)
+
+	// changes older than the window are forgotten
+	now = now.Add(50 * time.Minute)
+	c.record(map[string]service{}, map[string]service{})
+	assert.Len(t, c.changes["web"], 1)
+	assert.NotContains(t, c.changes, "old")
+	assert.Empty(t, c.exceeded, "services are reported again once they settled")
+
+	var disabled *churn
+	disabled.record(changed, changed)
+}
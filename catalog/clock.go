@@ -0,0 +1,26 @@
+package catalog
+
+import "time"
+
+// clock tells the time and schedules waits for timing-dependent behaviour, e.g. polling, retries,
+// maintenance pauses and freeze windows, so it can be controlled in tests. A nil clock is the real clock.
+type clock struct {
+	now   func() time.Time
+	after func(d time.Duration) <-chan time.Time
+}
+
+// Now returns the current time
+func (c *clock) Now() time.Time {
+	if c == nil || c.now == nil {
+		return time.Now()
+	}
+	return c.now()
+}
+
+// After returns a channel receiving the current time once d has elapsed
+func (c *clock) After(d time.Duration) <-chan time.Time {
+	if c == nil || c.after == nil {
+		return time.After(d)
+	}
+	return c.after(d)
+}
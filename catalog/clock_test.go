@@ -0,0 +1,150 @@
+package catalog
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a clock controlled by tests, waits end when the clock is advanced past them
+type fakeClock struct {
+	lock    sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+// clock returns a clock backed by the fake clock
+func (f *fakeClock) clock() *clock {
+	return &clock{now: f.Now, after: f.After}
+}
+
+func (f *fakeClock) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+func (f *fakeClock) After(d time.Duration) <-chan time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.waiters = append(f.waiters, fakeWaiter{at: f.now.Add(d), c: c})
+	return c
+}
+
+// advance moves the clock forward and ends the waits that are due
+func (f *fakeClock) advance(d time.Duration) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.now = f.now.Add(d)
+	waiters := []fakeWaiter{}
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			waiters = append(waiters, w)
+		} else {
+			w.c <- f.now
+		}
+	}
+	f.waiters = waiters
+}
+
+// pending returns the number of waits that haven't ended
+func (f *fakeClock) pending() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return len(f.waiters)
+}
+
+func TestClock_Nil(t *testing.T) {
+	var c *clock
+	before := time.Now()
+	assert.False(t, c.Now().Before(before))
+	select {
+	case <-c.After(time.Millisecond):
+	case <-time.After(time.Second):
+		t.Fatal("nil clock should wait in real time")
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC)
+	f := newFakeClock(start)
+	c := f.clock()
+	after := c.After(time.Minute)
+	f.advance(59 * time.Second)
+	assert.Len(t, after, 0)
+	f.advance(time.Second)
+	require.Len(t, after, 1)
+	assert.Equal(t, start.Add(time.Minute), <-after)
+	assert.Equal(t, start.Add(time.Minute), c.Now())
+	assert.Equal(t, 0, f.pending())
+}
+
+func TestWritesPaused_Clock(t *testing.T) {
+	f := newFakeClock(time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC))
+	n := ns1{log: hclog.NewNullLogger(), clock: f.clock()}
+	n.pauseWrites(time.Minute)
+	assert.True(t, n.writesPaused())
+	f.advance(59 * time.Second)
+	assert.True(t, n.writesPaused())
+	f.advance(time.Second)
+	assert.False(t, n.writesPaused())
+}
+
+func TestFrozen_Clock(t *testing.T) {
+	ws, err := parseFreezeWindows([]string{"0 18 * * 5 62h"})
+	require.NoError(t, err)
+	// Friday
+	f := newFakeClock(time.Date(2019, 10, 4, 17, 59, 0, 0, time.UTC))
+	n := ns1{log: hclog.NewNullLogger(), clock: f.clock(), freezeWindows: ws}
+	upsert := map[string]service{"s1": {}}
+	assert.False(t, n.frozen(upsert, nil))
+	f.advance(time.Minute)
+	assert.True(t, n.frozen(upsert, nil))
+	f.advance(62 * time.Hour)
+	assert.False(t, n.frozen(upsert, nil))
+}
+
+func TestNS1FetchIndefinitely_Clock(t *testing.T) {
+	f := newFakeClock(time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC))
+	n := testClient(nil)
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: &mockRecordService{}}
+	n.clock = f.clock()
+	n.pollInterval = 30 * time.Second
+	n.trigger = make(chan bool)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go n.fetchIndefinitely(stop, stopped)
+
+	<-n.trigger
+	// the next fetch waits for the poll interval
+	for f.pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-n.trigger:
+		t.Fatal("fetched again before the poll interval elapsed")
+	case <-time.After(10 * time.Millisecond):
+	}
+	f.advance(30 * time.Second)
+	<-n.trigger
+
+	close(stop)
+	<-stopped
+}
@@ -0,0 +1,162 @@
+package catalog
+
+import (
+	"strings"
+	"sync"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// coManaged tracks the answers of A, AAAA and SRV records shared with answers managed by hand or by other tools.
+// Answers written by this instance are marked with a note in their meta, all other answers of a record are left
+// alone when it's updated or deleted. A nil coManaged owns all answers of the records it writes.
+type coManaged struct {
+	// marker is the note marking the answers written by this instance, see `ownerTXTAnswer` and `syncerMarker`
+	marker string
+	// legacy is the note marking the answers written before the instance had a syncer ID, adopted as its own
+	legacy string
+
+	lock sync.Mutex
+	// foreign holds the answers of each record not written by this instance as last fetched, keyed by `recordKey`
+	foreign map[string][]string
+	// pending holds the answers not written by this instance of each record fetched before updating it,
+	// until they're written back, keyed by `recordKey`
+	pending map[string][]*dns.Answer
+	// ids holds the IDs of the records without answers written by this instance, keyed by `recordKey`
+	ids map[string]string
+}
+
+// coManagedType reports whether records of a type may hold answers not written by this instance
+func coManagedType(recType string) bool {
+	return recType == "A" || recType == "AAAA" || recType == "SRV"
+}
+
+// owns reports whether an answer was written by this instance
+func (m *coManaged) owns(a *dns.Answer) bool {
+	return a.Meta != nil && (a.Meta.Note == m.marker || (m.legacy != "" && a.Meta.Note == m.legacy))
+}
+
+// fetched splits the answers of a record fetched from NS1 before updating it, keeps the answers not written
+// by this instance to write them back and returns the answers written by this instance
+func (m *coManaged) fetched(rec *dns.Record) []*dns.Answer {
+	if m == nil || !coManagedType(rec.Type) {
+		return rec.Answers
+	}
+	own, foreign := []*dns.Answer{}, []*dns.Answer{}
+	for _, a := range rec.Answers {
+		if m.owns(a) {
+			own = append(own, a)
+		} else {
+			foreign = append(foreign, a)
+		}
+	}
+	key := recordKey(rec.Domain, rec.Type)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.pending == nil {
+		m.pending, m.foreign, m.ids = map[string][]*dns.Answer{}, map[string][]string{}, map[string]string{}
+	}
+	m.pending[key] = foreign
+	m.foreign[key] = answerStrings(foreign)
+	return own
+}
+
+// mark marks the answers of a record about to be written as written by this instance,
+// and adds back the answers not written by this instance found when the record was fetched
+func (m *coManaged) mark(rec *dns.Record) {
+	if m == nil || !coManagedType(rec.Type) {
+		return
+	}
+	for _, a := range rec.Answers {
+		if a.Meta == nil {
+			a.Meta = &data.Meta{}
+		}
+		a.Meta.Note = m.marker
+	}
+	key := recordKey(rec.Domain, rec.Type)
+	m.lock.Lock()
+	foreign := m.pending[key]
+	delete(m.pending, key)
+	m.lock.Unlock()
+	rec.Answers = append(rec.Answers, foreign...)
+}
+
+// deleted forgets the answers of a deleted record
+func (m *coManaged) deleted(domain, recType string) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	delete(m.foreign, recordKey(domain, recType))
+	delete(m.ids, recordKey(domain, recType))
+	m.lock.Unlock()
+}
+
+// unowned reports whether none of the answers of a zone record was written by this instance, as far as it's known,
+// and remembers its ID so answers are added to the existing record
+func (m *coManaged) unowned(record *dns.ZoneRecord) bool {
+	if m == nil || len(record.ShortAns) == 0 || len(m.ownAnswers(record.Domain, record.Type, record.ShortAns)) > 0 {
+		return false
+	}
+	m.lock.Lock()
+	m.ids[recordKey(record.Domain, record.Type)] = record.ID
+	m.lock.Unlock()
+	return true
+}
+
+// recordID returns the ID of an existing record without answers written by this instance, if any
+func (m *coManaged) recordID(domain, recType string) string {
+	if m == nil {
+		return ""
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	return m.ids[recordKey(domain, recType)]
+}
+
+// ownAnswers filters the answers of a record not written by this instance out of its short answers,
+// as far as they're known
+func (m *coManaged) ownAnswers(domain, recType string, answers []string) []string {
+	if m == nil {
+		return answers
+	}
+	m.lock.Lock()
+	foreign := map[string]bool{}
+	for _, a := range m.foreign[recordKey(domain, recType)] {
+		foreign[a] = true
+	}
+	m.lock.Unlock()
+	if len(foreign) == 0 {
+		return answers
+	}
+	own := []string{}
+	for _, a := range answers {
+		if !foreign[strings.Join(strings.Fields(a), " ")] {
+			own = append(own, a)
+		}
+	}
+	return own
+}
+
+// removeOwnAnswers deletes the answers written by this instance from a record instead of deleting the record,
+// if it has answers not written by this instance. It returns the record left with those answers, or nil if
+// the record can be deleted.
+func (n *ns1) removeOwnAnswers(zone, domain, recType string) (*dns.Record, error) {
+	if n.coManaged == nil || !coManagedType(recType) {
+		return nil, nil
+	}
+	n.limiter.read()
+	rec, resp, err := n.client.Records.Get(zone, domain, recType)
+	if err != nil {
+		return nil, ns1Error(resp, err)
+	}
+	n.coManaged.fetched(rec)
+	rec.Answers = []*dns.Answer{}
+	n.coManaged.mark(rec)
+	if len(rec.Answers) == 0 {
+		return nil, nil
+	}
+	n.log.Debug("Removing own answers of co-managed record", "domain", domain, "type", recType)
+	return rec, n.upsertRecord(rec.ID, rec)
+}
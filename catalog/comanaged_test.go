@@ -0,0 +1,96 @@
+package catalog
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// coManagedRecordService returns A records holding the given answers written by hand and by this instance, if any
+type coManagedRecordService struct {
+	mockRecordService
+	manual, own string
+}
+
+func (s *coManagedRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	rec := dns.NewRecord(zone, domain, t)
+	rec.ID = "r1"
+	rec.TTL = 10
+	if s.manual != "" {
+		rec.AddAnswer(dns.NewAv4Answer(s.manual))
+	}
+	if s.own != "" {
+		a := dns.NewAv4Answer(s.own)
+		a.Meta.Note = ownerTXTAnswer("")
+		rec.AddAnswer(a)
+	}
+	return rec, nil, nil
+}
+
+func TestCreate_CoManaged(t *testing.T) {
+	n := testClient(nil)
+	n.coManaged = &coManaged{marker: ownerTXTAnswer("")}
+	records := &coManagedRecordService{mockRecordService{mux: &sync.Mutex{}}, "9.9.9.9", "1.1.1.1"}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+
+	input := map[string]service{
+		"s1": {
+			nodes:     map[string]node{"h1": {aRecAnswer: "2.2.2.2"}},
+			ns1IDs:    recordIDs{aRecID: "r1"},
+			unchanged: recordTypes{srvRec: true},
+		},
+	}
+	assert.Equal(t, int32(1), n.create(input))
+	assert.Len(t, records.records, 1)
+	answers := records.records[0].Answers
+	assert.Equal(t, []string{"2.2.2.2", "9.9.9.9"}, answerStrings(answers))
+	assert.Equal(t, ownerTXTAnswer(""), answers[0].Meta.Note, "own answers are marked")
+	assert.Nil(t, answers[1].Meta.Note, "answers written by hand are left alone")
+
+	// answers written by hand aren't part of the service read back from the zone
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"2.2.2.2", "9.9.9.9"}, Type: "A", TTL: 10},
+		},
+	}
+	assert.Equal(t, []string{"2.2.2.2"}, aAnswers(n.transformZoneRecords(z)["s1"].nodes))
+}
+
+func TestRemove_CoManaged(t *testing.T) {
+	n := testClient(nil)
+	n.coManaged = &coManaged{marker: ownerTXTAnswer("")}
+	records := &coManagedRecordService{mockRecordService{mux: &sync.Mutex{}}, "9.9.9.9", "1.1.1.1"}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+
+	// a record holding answers written by hand is kept with those answers
+	assert.Equal(t, int32(1), n.remove(map[string]service{"s1": {ns1IDs: recordIDs{aRecID: "r1"}}}))
+	assert.Empty(t, records.deleted)
+	assert.Len(t, records.records, 1)
+	assert.Equal(t, []string{"9.9.9.9"}, answerStrings(records.records[0].Answers))
+
+	// it's then ignored, but answers are added to it again
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"9.9.9.9"}, Type: "A", TTL: 10},
+		},
+	}
+	assert.Empty(t, n.transformZoneRecords(z))
+	records.own, records.records = "", nil
+	assert.Equal(t, int32(1), n.create(map[string]service{
+		"s1": {nodes: map[string]node{"h1": {aRecAnswer: "2.2.2.2"}}, unchanged: recordTypes{srvRec: true}},
+	}))
+	assert.Len(t, records.records, 1)
+	assert.Equal(t, "r1", records.records[0].ID)
+	assert.Equal(t, []string{"2.2.2.2", "9.9.9.9"}, answerStrings(records.records[0].Answers))
+
+	// records only holding own answers are deleted
+	records = &coManagedRecordService{mockRecordService: mockRecordService{mux: &sync.Mutex{}}, own: "1.1.1.1"}
+	n.client.Records = records
+	assert.Equal(t, int32(1), n.remove(map[string]service{"s1": {ns1IDs: recordIDs{aRecID: "r1"}}}))
+	assert.Equal(t, []string{"s1.test.zone A"}, records.deleted)
+}
@@ -0,0 +1,85 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// conflictPolicy decides what happens when a service would be written to a domain that already holds
+// records not marked as owned by this instance in the ownership registry
+type conflictPolicy string
+
+const (
+	// adoptConflicts overwrites the unmanaged records and marks them as owned
+	adoptConflicts conflictPolicy = "adopt"
+	// skipConflicts leaves the unmanaged records alone and doesn't sync the service
+	skipConflicts conflictPolicy = "skip"
+	// errorConflicts stops syncing
+	errorConflicts conflictPolicy = "error"
+)
+
+// parseConflictPolicy validates a conflict policy, an empty policy defaults to skipConflicts
+// so existing records are only taken over when adoption is explicitly enabled
+func parseConflictPolicy(s string) (conflictPolicy, error) {
+	switch conflictPolicy(s) {
+	case "", skipConflicts:
+		return skipConflicts, nil
+	case adoptConflicts, errorConflicts:
+		return conflictPolicy(s), nil
+	}
+	return "", fmt.Errorf("unknown conflict policy %q, must be one of %q, %q or %q", s, adoptConflicts, skipConflicts, errorConflicts)
+}
+
+// unmanaged reports whether a service read from NS1 has records but no ownership record
+func (s service) unmanaged() bool {
+	return s.ns1IDs.ownerRecID == "" && s.ns1IDs.recordCount() > 0
+}
+
+// resolveConflicts applies the conflict policy to the services of `upsert` whose records already
+// exist in NS1 without an ownership record. Conflicts are only detected with the ownership registry enabled.
+// An error is returned if a conflict is found and the policy is errorConflicts.
+func (n *ns1) resolveConflicts(upsert, existing map[string]service) (map[string]service, error) {
+	if !n.ownershipRegistry || n.conflictPolicy == adoptConflicts {
+		return upsert, nil
+	}
+	// sort for a deterministic error
+	keys := make([]string, 0, len(upsert))
+	for k := range upsert {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := map[string]service{}
+	for _, k := range keys {
+		if e, ok := existing[k]; ok && e.unmanaged() {
+			metrics.IncrCounterWithLabels([]string{"ownership", "conflict"}, 1,
+				[]metrics.Label{{Name: "policy", Value: string(n.conflictPolicy)}})
+			if n.conflictPolicy == errorConflicts {
+				return nil, wrapError(ErrRecordConflict,
+					fmt.Errorf("service %s conflicts with unmanaged records at %s", k, n.ns1Prefix+k+"."+n.serviceZone.name))
+			}
+			n.log.Warn("unmanaged records found for service, skipping", "service", k, "prefix", n.ns1Prefix)
+			continue
+		}
+		result[k] = upsert[k]
+	}
+	return result, nil
+}
+
+// managedOnly drops the services of `remove` without an ownership record, unless unmanaged records are adopted
+func (n *ns1) managedOnly(remove map[string]service) map[string]service {
+	if !n.ownershipRegistry || n.conflictPolicy == adoptConflicts {
+		return remove
+	}
+	result := map[string]service{}
+	for k, s := range remove {
+		if s.unmanaged() {
+			n.log.Debug("unmanaged records found for service, not removing", "service", k, "prefix", n.ns1Prefix)
+			continue
+		}
+		result[k] = s
+	}
+	return result
+}
@@ -0,0 +1,72 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	p, err := parseConflictPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, skipConflicts, p)
+
+	p, err = parseConflictPolicy("adopt")
+	assert.NoError(t, err)
+	assert.Equal(t, adoptConflicts, p)
+
+	_, err = parseConflictPolicy("overwrite")
+	assert.Error(t, err)
+}
+
+func TestResolveConflicts(t *testing.T) {
+	upsert := map[string]service{"s1": {name: "s1"}, "s2": {name: "s2"}, "s3": {name: "s3"}}
+	existing := map[string]service{
+		"s1": {ns1IDs: recordIDs{aRecID: "r1", ownerRecID: "r2"}},
+		"s2": {ns1IDs: recordIDs{aRecID: "r3"}},
+	}
+	type variant struct {
+		registry bool
+		policy   conflictPolicy
+		expected map[string]service
+		err      bool
+	}
+	table := map[string]variant{
+		"registry disabled": {policy: skipConflicts, expected: upsert},
+		"adopt":             {registry: true, policy: adoptConflicts, expected: upsert},
+		"skip": {
+			registry: true,
+			policy:   skipConflicts,
+			expected: map[string]service{"s1": {name: "s1"}, "s3": {name: "s3"}},
+		},
+		"error": {registry: true, policy: errorConflicts, err: true},
+	}
+	for name, v := range table {
+		n := ns1{log: hclog.NewNullLogger(), ownershipRegistry: v.registry, conflictPolicy: v.policy}
+		actual, err := n.resolveConflicts(upsert, existing)
+		if v.err {
+			assert.Equal(t, ErrRecordConflict, ErrorClass(err), fmt.Sprintf("Test case: %s", name))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expected, actual, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestManagedOnly(t *testing.T) {
+	remove := map[string]service{
+		"s1": {ns1IDs: recordIDs{aRecID: "r1", ownerRecID: "r2"}},
+		"s2": {ns1IDs: recordIDs{aRecID: "r3"}},
+		"s3": {ns1IDs: recordIDs{ownerRecID: "r4"}},
+	}
+	n := ns1{log: hclog.NewNullLogger(), ownershipRegistry: true, conflictPolicy: adoptConflicts}
+	assert.Equal(t, remove, n.managedOnly(remove))
+
+	n.conflictPolicy = skipConflicts
+	assert.Equal(t, map[string]service{
+		"s1": {ns1IDs: recordIDs{aRecID: "r1", ownerRecID: "r2"}},
+		"s3": {ns1IDs: recordIDs{ownerRecID: "r4"}},
+	}, n.managedOnly(remove))
+}
@@ -0,0 +1,65 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// isConnectProxy reports whether all instances of a service are Connect proxies fronting another service
+func isConnectProxy(cnodes []*consulapi.CatalogService) bool {
+	for _, n := range cnodes {
+		if n.ServiceProxy == nil || n.ServiceProxy.DestinationServiceName == "" {
+			return false
+		}
+	}
+	return len(cnodes) > 0
+}
+
+// sidecarProxySuffix is the suffix Consul names the sidecar proxies of services with, e.g. "web-sidecar-proxy"
+const sidecarProxySuffix = "-sidecar-proxy"
+
+// gatewayServices are the names Consul registers Connect gateways under by default
+var gatewayServices = []string{"mesh-gateway", "ingress-gateway", "terminating-gateway"}
+
+// isProxyService reports whether a service is a Connect sidecar proxy or gateway, which are mesh internals, by its
+// instances or by the names Consul gives them. Gateways named after their datacenter or partition, e.g.
+// "dc1-mesh-gateway", are detected too.
+func isProxyService(name string, cnodes []*consulapi.CatalogService) bool {
+	if isConnectProxy(cnodes) {
+		return true
+	}
+	name = strings.ToLower(name)
+	if strings.HasSuffix(name, sidecarProxySuffix) {
+		return true
+	}
+	for _, g := range gatewayServices {
+		if name == g || strings.HasSuffix(name, "-"+g) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchProxies retrieves the Connect proxies fronting a service. Mesh-only services are only reachable through
+// their proxies, so the proxies are published under the name of the service when `connectProxies` is set.
+func (c *consul) fetchProxies(service string) ([]*consulapi.CatalogService, error) {
+	opts := &consulapi.QueryOptions{AllowStale: c.stale}
+	proxies, _, err := c.client.Catalog().Connect(service, "", opts)
+	if err != nil {
+		return nil, wrapError(ErrConsulUnavailable, fmt.Errorf("error querying proxies, will retry: %s", err))
+	}
+	return proxies, nil
+}
+
+// fetchProxyHealth retrieves the status of health checks associated with the Connect proxies fronting a service,
+// like `fetchHealth` does for its instances
+func (c *consul) fetchProxyHealth(service string) (consulapi.HealthChecks, error) {
+	opts := &consulapi.QueryOptions{AllowStale: c.stale}
+	entries, _, err := c.client.Health().Connect(service, "", false, opts)
+	if err != nil {
+		return nil, wrapError(ErrConsulUnavailable, fmt.Errorf("error querying proxy health, will retry: %s", err))
+	}
+	return c.instanceChecks(entries), nil
+}
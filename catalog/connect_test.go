@@ -0,0 +1,107 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIsConnectProxy(t *testing.T) {
+	proxy := &consulapi.CatalogService{ServiceProxy: &consulapi.AgentServiceConnectProxyConfig{DestinationServiceName: "web"}}
+	plain := &consulapi.CatalogService{}
+	assert.True(t, isConnectProxy([]*consulapi.CatalogService{proxy, proxy}))
+	assert.False(t, isConnectProxy([]*consulapi.CatalogService{proxy, plain}))
+	assert.False(t, isConnectProxy([]*consulapi.CatalogService{plain}))
+	assert.False(t, isConnectProxy(nil))
+}
+
+func TestIsProxyService(t *testing.T) {
+	proxy := &consulapi.CatalogService{ServiceProxy: &consulapi.AgentServiceConnectProxyConfig{DestinationServiceName: "web"}}
+	plain := &consulapi.CatalogService{}
+	table := map[string]struct {
+		name     string
+		cnodes   []*consulapi.CatalogService
+		expected bool
+	}{
+		"plain":              {"web", []*consulapi.CatalogService{plain}, false},
+		"registered proxy":   {"web-envoy", []*consulapi.CatalogService{proxy}, true},
+		"sidecar name":       {"web-sidecar-proxy", []*consulapi.CatalogService{plain}, true},
+		"sidecar name case":  {"Web-Sidecar-Proxy", []*consulapi.CatalogService{plain}, true},
+		"mesh gateway":       {"mesh-gateway", []*consulapi.CatalogService{plain}, true},
+		"dc gateway":         {"dc1-mesh-gateway", []*consulapi.CatalogService{plain}, true},
+		"ingress gateway":    {"ingress-gateway", nil, true},
+		"terminating":        {"terminating-gateway", nil, true},
+		"gateway in name":    {"api-gateway", []*consulapi.CatalogService{plain}, false},
+		"gateway as prefix":  {"mesh-gateway-admin", []*consulapi.CatalogService{plain}, false},
+		"sidecar not suffix": {"web-sidecar-proxy-ui", []*consulapi.CatalogService{plain}, false},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, isProxyService(v.name, v.cnodes), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+// connectConsul serves a catalog holding a mesh-only service "web" fronted by the sidecar proxy "web-sidecar-proxy"
+func connectConsul(t *testing.T) (*consulapi.Client, *httptest.Server) {
+	proxy := &consulapi.CatalogService{
+		Node: "n1", ServiceID: "web-sidecar-proxy", ServiceName: "web-sidecar-proxy", Address: "10.0.0.1", ServicePort: 21000,
+		ServiceProxy: &consulapi.AgentServiceConnectProxyConfig{DestinationServiceName: "web"},
+	}
+	responses := map[string]interface{}{
+		"/v1/catalog/services": map[string][]string{"web": {}, "web-sidecar-proxy": {}},
+		"/v1/catalog/service/web": []*consulapi.CatalogService{
+			{Node: "n1", ServiceID: "web", ServiceName: "web", Address: "127.0.0.1", ServicePort: 8080},
+		},
+		"/v1/catalog/service/web-sidecar-proxy": []*consulapi.CatalogService{proxy},
+		"/v1/catalog/connect/web":               []*consulapi.CatalogService{proxy},
+		"/v1/health/service/web":                []*consulapi.ServiceEntry{},
+		"/v1/health/connect/web":                []*consulapi.ServiceEntry{},
+		"/v1/health/service/web-sidecar-proxy":  []*consulapi.ServiceEntry{},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		response, ok := responses[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("X-Consul-Index", "10")
+		json.NewEncoder(w).Encode(response)
+	}))
+	client, err := consulapi.NewClient(&consulapi.Config{Address: srv.URL})
+	require.NoError(t, err)
+	return client, srv
+}
+
+func TestConsulFetch_ConnectProxies(t *testing.T) {
+	client, srv := connectConsul(t)
+	defer srv.Close()
+	c := consul{client: client, log: hclog.NewNullLogger(), addressFamily: ipv4Family}
+	_, err := c.fetch(0)
+	require.NoError(t, err)
+	services := c.getServices()
+	assert.Len(t, services, 1, "proxies aren't published by default")
+	assert.Equal(t, []string{"127.0.0.1"}, aAnswers(services["web"].nodes))
+
+	c.proxyServices = true
+	_, err = c.fetch(0)
+	require.NoError(t, err)
+	services = c.getServices()
+	assert.Len(t, services, 2, "proxies are published as services of their own")
+	assert.Equal(t, []string{"10.0.0.1"}, aAnswers(services["web-sidecar-proxy"].nodes))
+
+	c.connectProxies = true
+	_, err = c.fetch(0)
+	require.NoError(t, err)
+	services = c.getServices()
+	assert.Len(t, services, 1, "proxies are published under the name of the service they front")
+	assert.Equal(t, []string{"10.0.0.1"}, aAnswers(services["web"].nodes))
+	srvs := srvAnswers(services["web"].nodes)
+	require.Len(t, srvs, 1)
+	assert.Equal(t, int64(21000), srvs[0].port)
+}
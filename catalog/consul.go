@@ -2,11 +2,17 @@ package catalog
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	consulapi "github.com/hashicorp/consul/api"
 	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/diff"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
 )
 
 const (
@@ -23,41 +29,321 @@ type consul struct {
 	lock      sync.RWMutex
 	stale     bool
 	dnsTTL    int64
+	// ttlJitter is the maximum deviation of record TTLs from dnsTTL in percent, 0 disables jitter
+	ttlJitter int
+
+	healthAggregation healthAggregation
+	ignoreNodeChecks  bool
+	// onlyPassing only publishes the instances passing their checks, see `passingInstances`
+	onlyPassing bool
+	// upFilter marks the instances failing their checks down instead of leaving them out, see `markDown`
+	upFilter bool
+	// feeds publishes whether the addresses of instances are up to data feeds, nil if answers aren't connected
+	// to feeds, see `upFeeds`
+	feeds *upFeeds
+	// monitors mirrors checks as NS1 monitoring jobs, nil if jobs aren't created, see `monitors`
+	monitors          *monitors
+	warningPolicy     warningPolicy
+	portHints         bool
+	addressFamily     addressFamily
+	ownershipRegistry bool
+	// instanceCounts counts the healthy instances of each service
+	instanceCounts bool
+	// lowercaseNames publishes services under their lowercased name
+	lowercaseNames bool
+	// names sanitizes the names services are published under, see `namePolicy`
+	names namePolicy
+	// connectProxies publishes the Connect sidecar proxies of a service instead of its instances
+	connectProxies bool
+	// proxyServices publishes the Connect sidecar proxies and gateways as services of their own
+	proxyServices bool
+	// srvTargetZone is the zone of the per-node records SRV answers point to, empty to point them to IPs
+	srvTargetZone string
+	// dcSubdomains publishes the instances of each datacenter under a subdomain of their service, see
+	// `addDatacenterSubdomains`
+	dcSubdomains bool
+	// tagSubdomains publishes the instances carrying each tag under a subdomain of their service, see
+	// `addTagSubdomains`
+	tagSubdomains bool
+	// naming renames services after a domain template, nil to publish them under their name, see
+	// `applyDomainTemplate`
+	naming *domainTemplate
+	// selection publishes the services selected by the allow and deny patterns, nil to publish all services
+	selection *serviceSelection
+	// zones only keeps the services routed to the zone of this instance, nil to sync all services, see `route`
+	zones *zoneRouting
+	// taggedAddresses are the tags of the addresses of instances published next to their address, see `taggedAnswers`
+	taggedAddresses []string
+	// weightedAnswers sets the weight of A and AAAA answers, see `answerWeight`
+	weightedAnswers bool
+	// geoMetadata publishes the location of instances in the meta of A and AAAA answers, see `geoMeta`
+	geoMetadata bool
+	// versionNotes publishes the version of instances in the note of A and AAAA answers, see `instanceVersion`
+	versionNotes bool
+	// geoRegions holds the georegion of the instances of each datacenter
+	geoRegions map[string]string
+	// datacenterRegions groups the answers of the instances in NS1 regions named after their datacenter
+	datacenterRegions bool
+	// checkedPortsOnly only publishes SRV answers for the ports targeted by health checks
+	checkedPortsOnly bool
+	// filtersKVPrefix is the KV prefix holding the filter chains of services, empty if it isn't read,
+	// see `fetchFilterOverrides`
+	filtersKVPrefix string
+	// filterOverrides holds the filter chains read from filtersKVPrefix, keyed by Consul service name
+	filterOverrides     map[string][]*filter.Filter
+	filterOverridesLock sync.Mutex
+	// rolloutsKVPrefix is the KV prefix holding the rollouts of services, empty if it isn't read, see `fetchRollouts`
+	rolloutsKVPrefix string
+	// rollouts holds the rollouts read from rolloutsKVPrefix, keyed by Consul service name
+	rollouts     map[string]rollout
+	rolloutsLock sync.Mutex
+	// features toggles behaviors at runtime from Consul KV, nil to keep the configured behaviors, see
+	// `featureFlags`
+	features *featureFlags
+	// minQueryInterval is the minimum time between two blocking queries for services
+	minQueryInterval time.Duration
+	// syncHealth reports whether each service is in sync through Consul checks, nil if disabled
+	syncHealth *syncHealth
+	// resync requests an immediate full reconciliation
+	resync chan struct{}
+	// fetchLock serializes applying fetched services, as resyncs fetch next to the fetch loop, see `fetch`
+	fetchLock sync.Mutex
+	// fetchedIndex is the index of the services last applied
+	fetchedIndex uint64
+	// latency notes the changes of services fetched, shared with the NS1 side writing them
+	latency *syncLatency
+	// clock is the clock of timing-dependent behaviour, nil is the real clock
+	clock *clock
+	// fetchBeat and syncBeat are beaten by the fetch and sync loops
+	fetchBeat heartbeat
+	syncBeat  heartbeat
+	// fetchErr and syncErr are the errors the fetch and sync loops gave up on
+	fetchErr error
+	syncErr  error
 }
 
+// newConsul returns the Consul side of a sync configured by `cfg`. Sync and the entry points fetching once, e.g.
+// Plan, share it so they publish services under the same names. Errors are of class ErrInvalidConfig.
+func newConsul(cfg Config, client *consulapi.Client) (*consul, error) {
+	healthAggregation, err := parseHealthAggregation(cfg.HealthAggregation)
+	if err != nil {
+		return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid health aggregation policy: %s", err))
+	}
+	family, err := parseAddressFamily(cfg.AddressFamily)
+	if err != nil {
+		return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid address family: %s", err))
+	}
+	warnings, err := parseWarningPolicy(cfg.WarningPolicy)
+	if err != nil {
+		return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid warning policy: %s", err))
+	}
+	geoRegions, err := parseGeoRegions(cfg.GeoRegions)
+	if err != nil {
+		return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid georegion mapping: %s", err))
+	}
+	names, err := parseNamePolicy(cfg.SanitizeServiceNames)
+	if err != nil {
+		return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid service name sanitization: %s", err))
+	}
+	naming, err := parseDomainTemplate(cfg.DomainTemplate)
+	if err != nil {
+		return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid domain template: %s", err))
+	}
+	selection, err := newServiceSelection(cfg)
+	if err != nil {
+		return nil, wrapError(ErrInvalidConfig, fmt.Errorf("invalid service selection: %s", err))
+	}
+	c := &consul{
+		client:            client,
+		log:               hclog.Default().Named("consul"),
+		trigger:           make(chan bool, 1),
+		resync:            make(chan struct{}, 1),
+		ns1Prefix:         cfg.NS1Prefix,
+		stale:             cfg.Stale,
+		dnsTTL:            cfg.NS1DNSTTL,
+		ttlJitter:         cfg.NS1DNSTTLJitter,
+		healthAggregation: healthAggregation,
+		ignoreNodeChecks:  cfg.IgnoreNodeChecks,
+		onlyPassing:       cfg.OnlyPassing,
+		upFilter:          cfg.UpFilter,
+		warningPolicy:     warnings,
+		portHints:         cfg.PortHints,
+		addressFamily:     family,
+		ownershipRegistry: cfg.OwnershipRegistry,
+		lowercaseNames:    cfg.LowercaseServiceNames,
+		names:             names,
+		connectProxies:    cfg.PublishConnectProxies,
+		proxyServices:     cfg.PublishProxyServices,
+		checkedPortsOnly:  cfg.CheckedPortsOnly,
+		instanceCounts:    cfg.PublishInstanceCount,
+		weightedAnswers:   cfg.WeightedAnswers,
+		geoMetadata:       cfg.GeoMetadata,
+		versionNotes:      cfg.VersionNotes,
+		geoRegions:        geoRegions,
+		datacenterRegions: cfg.DatacenterRegions,
+		taggedAddresses:   cfg.TaggedAddresses,
+		filtersKVPrefix:   cfg.FiltersKVPrefix,
+		rolloutsKVPrefix:  cfg.RolloutsKVPrefix,
+		dcSubdomains:      cfg.DatacenterSubdomains,
+		tagSubdomains:     cfg.TagSubdomains,
+		naming:            naming,
+		selection:         selection,
+	}
+	if cfg.SRVTargetHostnames {
+		c.srvTargetZone = cfg.NS1Domain
+	}
+	if cfg.ZoneRouting {
+		c.zones = &zoneRouting{zone: normalizeZone(cfg.NS1Domain), fallback: normalizeZone(cfg.NS1Domain)}
+		if cfg.DefaultZone != "" {
+			c.zones.fallback = normalizeZone(cfg.DefaultZone)
+		}
+	}
+	return c, nil
+}
+
+// reasons of a sync cycle, reported by the sync.cycle metric
+const (
+	// syncReasonConsulChange is a change of the Consul catalog
+	syncReasonConsulChange = "consul-change"
+	// syncReasonNS1Drift is a change of managed records outside of consul-ns1
+	syncReasonNS1Drift = "ns1-drift"
+	// syncReasonTimer is the periodic reconciliation when nothing changed
+	syncReasonTimer = "reconcile-timer"
+	// syncReasonResync is a requested full reconciliation
+	syncReasonResync = "resync"
+)
+
 func (c *consul) sync(ns1 *ns1, stop, stopped chan struct{}) {
 	defer close(stopped)
-	cTriggered := false
-	nTriggered := false
+	// registry garbage collection runs in this loop so it never races with a sync cycle
+	var gc <-chan time.Time
+	if ns1.ownershipRegistry && ns1.registryGCInterval > 0 {
+		gc = ns1.clock.After(ns1.registryGCInterval)
+	}
 	for {
+		// a sync cycle runs once both fetch loops completed an iteration, see `syncCycle`
+		interval := ns1.tuner.interval(ns1.pollInterval)
+		if interval < WaitTime*time.Second {
+			interval = WaitTime * time.Second
+		}
+		c.syncBeat.beat(interval)
+		ready := false
 		select {
-		case <-c.trigger:
-			cTriggered = true
-		case <-ns1.trigger:
-			nTriggered = true
+		case changed := <-c.trigger:
+			ready = ns1.cycle.reportConsul(changed)
+		case drifted := <-ns1.trigger:
+			ready = ns1.cycle.reportNS1(drifted)
+		case <-c.resync:
+			ns1.cycle.resyncing()
+			ready = ns1.cycle.resynced(c.resyncNow(ns1))
+		case <-gc:
+			if !c.features.enabled(observeOnlyFeature, false) {
+				ns1.collectRegistryGarbage(c.getServices())
+			}
+			gc = ns1.clock.After(ns1.registryGCInterval)
 		case <-stop:
 			return
 		}
 
-		if cTriggered && nTriggered {
-			ns1.log.Debug("Services before upsert", "consul", c.getServices(), "ns1", ns1.getServices())
-			upsert := onlyInFirst(c.getServices(), ns1.getServices())
-			count := ns1.create(upsert)
-			if count > 0 {
-				ns1.log.Info("upserted", "count", fmt.Sprintf("%d", count))
-			}
-
-			remove := serviceOnlyInFirst(ns1.getServices(), c.getServices())
-			count = ns1.remove(remove)
-			if count > 0 {
-				ns1.log.Info("removed", "count", fmt.Sprintf("%d", count))
+		if ready {
+			reason := ns1.cycle.diff()
+			metrics.IncrCounterWithLabels([]string{"sync", "cycle"}, 1, []metrics.Label{{Name: "reason", Value: reason}})
+			wrote, err := c.reconcile(ns1)
+			ns1.cycle.done(wrote)
+			if err != nil {
+				ns1.log.Error("cannot sync service", "error", err)
+				countError(err)
+				c.syncErr = err
+				return
 			}
-			cTriggered = false
-			nTriggered = false
 		}
 	}
 }
 
+// resyncNow fetches the services from Consul and NS1 from scratch for a full reconciliation, healing the
+// ownership registry first if requested. It reports whether both fetches succeeded. The fetches are serialized
+// with those of the fetch loops, see `fetch` and `poll`.
+func (c *consul) resyncNow(ns1 *ns1) bool {
+	ns1.log.Info("resync requested, fetching services from Consul and NS1")
+	if _, err := c.fetch(0); err != nil {
+		c.log.Error("error fetching for resync", "error", err.Error())
+		return false
+	}
+	if ns1.healRegistry {
+		// the zone is fetched again below to reflect the changes of the garbage collection
+		ns1.collectRegistryGarbage(c.getServices())
+		ns1.healRegistry = false
+	}
+	if err := ns1.fetch(); err != nil {
+		ns1.log.Error("error fetching for resync", "error", err.Error())
+		return false
+	}
+	return true
+}
+
+// reconcile writes the differences between the cached Consul and NS1 services to NS1 and reports whether it wrote
+// to NS1. It runs in the diffing phase of the sync cycle and moves it to applying, see `syncCycle`.
+func (c *consul) reconcile(ns1 *ns1) (bool, error) {
+	ns1.log.Debug("Services before upsert", "consul", c.getServices(), "ns1", ns1.getServices())
+	if !ns1.adoptionReported {
+		ns1.reportAdoption(c.getServices(), ns1.getServices())
+		ns1.adoptionReported = true
+	}
+	upsert := onlyInFirst(c.getServices(), ns1.getServices())
+	c.syncHealth.report(c.getServices(), upsert)
+	upsert, err := ns1.resolveConflicts(upsert, ns1.getServices())
+	if err != nil {
+		return false, err
+	}
+	upsert = ns1.enforceQuota(upsert, ns1.getServices())
+	upsert = ns1.enforceAccountLimits(upsert)
+	upsert = ns1.holdDependents(upsert, c.getServices(), ns1.getServices())
+	remove := serviceOnlyInFirst(ns1.getServices(), c.getServices())
+	remove = ns1.managedOnly(remove)
+	if len(remove) > 0 && !c.features.enabled(deletesFeature, true) {
+		ns1.log.Info("deletes feature flag disabled, keeping the records of services gone from Consul",
+			"count", fmt.Sprintf("%d", len(remove)))
+		remove = map[string]service{}
+	}
+	if c.observeOnly(upsert, remove) || ns1.frozen(upsert, remove) || !ns1.approval.allow(upsert, remove) {
+		return false, nil
+	}
+	ns1.cycle.apply()
+
+	if !ns1.ttlPassDone {
+		// TTL-only changes found on startup, e.g. because -ns1-dns-ttl changed, don't rewrite answers
+		upsert = ns1.updateTTLs(upsert, c.getServices(), ns1.getServices())
+		ns1.ttlPassDone = true
+	}
+	ns1.journal.begin(upsert, remove)
+	paused := ns1.writesPaused()
+	count := ns1.create(upsert)
+	if count > 0 {
+		ns1.log.Info("upserted", "count", fmt.Sprintf("%d", count))
+	}
+
+	count = ns1.remove(remove)
+	if count > 0 {
+		ns1.log.Info("removed", "count", fmt.Sprintf("%d", count))
+	}
+	ns1.journal.commit()
+	ns1.churn.record(upsert, remove)
+	ns1.latency.applied(upsert, remove, ns1.serviceDomain)
+	if !paused {
+		ns1.events.applied(upsert, remove, c.getServices(), ns1.getServices())
+	}
+
+	count = ns1.publishInstanceCounts(c.getServices(), ns1.getServices())
+	if count > 0 {
+		ns1.log.Info("published instance counts", "count", fmt.Sprintf("%d", count))
+	}
+	wrote := len(upsert)+len(remove) > 0 || count > 0
+	if wrote {
+		ns1.saveState()
+	}
+	return wrote, nil
+}
+
 // getServices returns a copy of currently registered services.  This is a blocking operation.
 func (c *consul) getServices() map[string]service {
 	c.lock.RLock()
@@ -78,19 +364,44 @@ func (c *consul) fetchNodes(service string) ([]*consulapi.CatalogService, error)
 	opts := &consulapi.QueryOptions{AllowStale: c.stale}
 	nodes, _, err := c.client.Catalog().Service(service, "", opts)
 	if err != nil {
-		return nil, fmt.Errorf("error querying services, will retry: %s", err)
+		return nil, wrapError(ErrConsulUnavailable, fmt.Errorf("error querying services, will retry: %s", err))
 	}
 	return nodes, err
 }
 
-// fetchHealth retrieves the status of health checks associated with a service
+// fetchHealth retrieves the status of health checks associated with the instances of a service,
+// including the node-level checks of the nodes they run on
 func (c *consul) fetchHealth(name string) (consulapi.HealthChecks, error) {
 	opts := &consulapi.QueryOptions{AllowStale: c.stale}
-	status, _, err := c.client.Health().Checks(name, opts)
+	entries, _, err := c.client.Health().Service(name, "", false, opts)
 	if err != nil {
-		return nil, fmt.Errorf("error querying health, will retry: %s", err)
+		return nil, wrapError(ErrConsulUnavailable, fmt.Errorf("error querying health, will retry: %s", err))
 	}
-	return status, nil
+	return c.instanceChecks(entries), nil
+}
+
+// instanceChecks flattens the checks of service entries into a list of checks of service instances.
+// Node-level checks (e.g. serfHealth) are attributed to the instance running on the node,
+// so an instance on a failed node is considered unhealthy, unless `ignoreNodeChecks` is set.
+func (c *consul) instanceChecks(entries []*consulapi.ServiceEntry) consulapi.HealthChecks {
+	checks := consulapi.HealthChecks{}
+	for _, e := range entries {
+		if e.Service == nil {
+			continue
+		}
+		for _, check := range e.Checks {
+			if check.ServiceID == "" {
+				if c.ignoreNodeChecks {
+					continue
+				}
+				nodeCheck := *check
+				nodeCheck.ServiceID = e.Service.ID
+				check = &nodeCheck
+			}
+			checks = append(checks, check)
+		}
+	}
+	return checks
 }
 
 // fetchServices retrieves all known services once the next index after `waitIndex` is reached
@@ -108,112 +419,376 @@ func (c *consul) fetchServices(waitIndex uint64) (map[string][]string, uint64, e
 	return services, meta.LastIndex, nil
 }
 
-// fetch queries all known services and updates the local `services` cache
+// fetch queries all known services and updates the local `services` cache. Fetches are applied one at a time,
+// and services fetched at an index older than the one last applied are dropped, unless fetched from scratch at
+// index 0, so a slow fetch never overwrites a newer one, e.g. of a resync.
 func (c *consul) fetch(waitIndex uint64) (uint64, error) {
-	cservices, waitIndex, err := c.fetchServices(waitIndex)
+	cservices, index, err := c.fetchServices(waitIndex)
 	if err != nil {
-		return waitIndex, fmt.Errorf("error fetching services: %s", err)
+		return index, wrapError(ErrConsulUnavailable, fmt.Errorf("error fetching services: %s", err))
 	}
-	c.log.Debug(fmt.Sprintf("Services fetched at index %d: %#v", waitIndex, cservices))
+	c.fetchLock.Lock()
+	defer c.fetchLock.Unlock()
+	if waitIndex != 0 && index < c.fetchedIndex {
+		c.log.Debug(fmt.Sprintf("Dropping services fetched at index %d, older than index %d", index, c.fetchedIndex))
+		return index, nil
+	}
+	c.log.Debug(fmt.Sprintf("Services fetched at index %d: %#v", index, cservices))
 	services := c.transformServices(cservices)
-	for id, s := range c.transformServices(cservices) {
+	feedStates := map[string]map[string]bool{}
+	specs := map[string]map[string]monitorSpec{}
+	tags := map[string]map[string][]string{}
+	onlyPassing := c.onlyPassing
+	if !c.upFilter && c.feeds == nil {
+		onlyPassing = c.features.enabled(onlyPassingFeature, c.onlyPassing)
+	}
+	for name, s := range services {
+		id := s.consulID
 		// fetch nodes and health for the service and transform
+		fetchHealth := c.fetchHealth
+		var weights map[string]int64
 		if cnodes, err := c.fetchNodes(id); err == nil {
+			if c.connectProxies && isConnectProxy(cnodes) {
+				// proxies are published under the name of the service they front
+				delete(services, name)
+				continue
+			}
+			if !c.proxyServices && isProxyService(id, cnodes) {
+				c.log.Debug("Connect proxy or gateway excluded", "service", id)
+				delete(services, name)
+				continue
+			}
+			if !c.routedHere(id, cservices[id], cnodes) {
+				delete(services, name)
+				continue
+			}
+			cnodes = c.publishedInstances(id, cnodes)
 			s.nodes = c.transformNodes(cnodes)
+			if c.naming != nil || c.tagSubdomains {
+				tags[name] = map[string][]string{}
+				for _, n := range cnodes {
+					tags[name][instanceKey(n.Node, n.ServiceID)] = withoutDirectives(n.ServiceTags)
+				}
+			}
+			if c.weightedAnswers {
+				s.nodes = c.applyRollout(id, cnodes, s.nodes)
+			}
+			weights = warningWeights(cnodes)
+			s.httpsRecAnswer = c.httpsAnswer(id, cnodes)
+			s.ttlOverride = c.serviceTTL(id, cnodes)
+			s.publishAfter = c.publishAfter(id, cnodes)
+			s.filters = c.serviceFilters(id, cnodes)
+			s.clientSubnet = c.serviceClientSubnet(id, cnodes)
+			s.cnameRecAnswer = c.virtualHost(id, cnodes)
+			if s.cnameRecAnswer == "" {
+				s.cnameRecAnswer = c.hostnameAddress(id, cnodes)
+			}
 		} else {
 			c.log.Error("error fetching nodes", "error", err)
 			continue
 		}
-		if chealths, err := c.fetchHealth(id); err == nil {
+		if c.connectProxies && s.cnameRecAnswer == "" {
+			if proxies, err := c.fetchProxies(id); err != nil {
+				c.log.Error("error fetching proxies", "error", err)
+			} else if len(proxies) > 0 {
+				proxies = c.publishedInstances(id, proxies)
+				s.nodes = c.transformNodes(proxies)
+				weights = warningWeights(proxies)
+				fetchHealth = c.fetchProxyHealth
+			}
+		}
+		if s.cnameRecAnswer == "" {
+			c.reportDuplicateEndpoints(id, s.nodes)
+		}
+		if chealths, err := fetchHealth(id); err == nil {
 			s.healths = c.transformHealth(chealths)
+			s.nodes = applyWarningWeights(s.nodes, chealths, weights)
+			if c.checkedPortsOnly {
+				s.nodes = publishCheckedPortsOnly(s.nodes, chealths)
+			}
+			if onlyPassing {
+				s.nodes = c.passingInstances(id, s.nodes, s.healths)
+			} else if c.upFilter {
+				s.nodes = c.markDown(id, s.nodes, s.healths)
+			} else if c.feeds != nil && s.cnameRecAnswer == "" {
+				feedStates[name] = c.feedStates(id, s.nodes, s.healths)
+			}
+			if c.monitors != nil && s.cnameRecAnswer == "" {
+				specs[name] = monitorSpecs(s.nodes, chealths)
+			}
 		} else {
 			c.log.Error("error fetch health", "error", err)
 		}
+		if c.instanceCounts {
+			s.healthyInstances = countHealthyInstances(s.nodes, s.healths)
+		}
 		// set default TTLs
-		s.ttls.aRecTTL, s.ttls.srvRecTTL = c.dnsTTL, c.dnsTTL
-		services[id] = s
+		if s.cnameRecAnswer != "" {
+			// virtual-hosted services only publish a CNAME, instance addresses are never exposed
+			s.nodes, s.httpsRecAnswer = nil, ""
+			s.ttls.cnameRecTTL = c.ttl(name, "CNAME", s.ttlOverride)
+		} else {
+			s.ttls.aRecTTL, s.ttls.srvRecTTL = c.ttl(name, "A", s.ttlOverride), c.ttl(name, "SRV", s.ttlOverride)
+			if c.addressFamily.v6() {
+				s.ttls.aaaaRecTTL = c.ttl(name, "AAAA", s.ttlOverride)
+			}
+			if c.portHints {
+				s.txtRecAnswer = portsTXTAnswer(s.nodes)
+				s.ttls.txtRecTTL = c.ttl(name, "TXT", s.ttlOverride)
+			}
+			if s.httpsRecAnswer != "" {
+				s.ttls.httpsRecTTL = c.ttl(name, "HTTPS", s.ttlOverride)
+			}
+			if key := overrideKey(s.filters, s.clientSubnet); key != "" {
+				s.filterKeys = map[diff.Family]string{diff.A: key, diff.AAAA: key, diff.SRV: key}
+			}
+		}
+		if c.ownershipRegistry {
+			s.ownerRecAnswer = ownerTXTAnswer(c.ns1Prefix)
+		}
+		services[name] = s
+	}
+	if !c.features.enabled(observeOnlyFeature, false) {
+		c.feeds.publish(feedStates, services)
+		c.monitors.sync(specs, services)
+	}
+	if c.naming != nil {
+		services = c.applyDomainTemplate(services, tags)
+	}
+	if c.dcSubdomains {
+		c.addDatacenterSubdomains(services)
+	}
+	if c.tagSubdomains {
+		c.addTagSubdomains(services, tags)
+	}
+	if c.srvTargetZone != "" {
+		c.addSRVTargets(services)
 	}
+	if c.addressFamily == dualFamily {
+		dropEmptyAddressFamilies(services)
+	}
+	c.latency.fetched(c.getServices(), services)
 	c.setServices(services)
-	return waitIndex, nil
+	c.fetchedIndex = index
+	return index, nil
 }
 
-// transformHealth transforms Consul `HealthChecks` status into a `service` `healths` enum
+// transformHealth transforms Consul `HealthChecks` status into a `service` `healths` enum, keyed by `instanceKey`.
+// When an instance has multiple checks, their statuses are combined according to the health aggregation policy.
 func (c *consul) transformHealth(chealths consulapi.HealthChecks) map[string]health {
 	healths := map[string]health{}
 	for _, h := range chealths {
+		var status health
 		switch h.Status {
 		case "passing":
-			healths[h.ServiceID] = passing
+			status = passing
 		case "critical":
-			healths[h.ServiceID] = critical
+			status = critical
 		default:
-			healths[h.ServiceID] = unknown
+			status = unknown
+		}
+		key := instanceKey(h.Node, h.ServiceID)
+		if existing, ok := healths[key]; ok {
+			status = c.healthAggregation.aggregate(existing, status)
 		}
+		healths[key] = status
 	}
 	return healths
 }
 
-// transformNodes transforms a list of Consul nodes for a service into a map of nodes and answers
+// transformNodes transforms a list of Consul nodes for a service into a map of instances and their answers,
+// keyed by `instanceKey`. Instances without an address of the configured address family are skipped.
 func (c *consul) transformNodes(cnodes []*consulapi.CatalogService) map[string]node {
 	nodes := map[string]node{}
 	for _, n := range cnodes {
-		address := n.ServiceAddress
-		if len(address) == 0 {
-			address = n.Address
+		v4, v6 := instanceAddresses(n)
+		if !c.addressFamily.v4() {
+			v4 = ""
 		}
-		if _, ok := nodes[address]; !ok {
-			nodes[address] = node{}
+		if !c.addressFamily.v6() {
+			v6 = ""
 		}
-		node := nodes[address]
-		if node.aRecAnswer == "" {
-			node.aRecAnswer = address
+		if v4 == "" && v6 == "" {
+			continue
 		}
-		if node.srvRecAnswers == nil {
-			node.srvRecAnswers = map[int]srvAnswer{}
+		if instanceHostname(n) != "" {
+			// hostnames are published as a CNAME, see hostnameAddress
+			continue
 		}
-		if _, ok := node.srvRecAnswers[n.ServicePort]; !ok {
-			node.srvRecAnswers[n.ServicePort] = srvAnswer{
-				priority: 1,
-				weight:   1,
-				port:     int64(n.ServicePort),
-				address:  address,
-			}
+		// SRV answers target the IPv4 address, if published
+		address := v4
+		if address == "" {
+			address = v6
+		}
+		priority, weight := c.srvPriorityWeight(n)
+		var answerWeight int64
+		if c.weightedAnswers {
+			answerWeight = c.answerWeight(n)
+		}
+		var geo geoMeta
+		if c.geoMetadata {
+			geo = c.geoMeta(n)
+		}
+		var region string
+		if c.datacenterRegions {
+			region = n.Datacenter
+		}
+		var version string
+		if c.versionNotes {
+			version = c.instanceVersion(n)
+		}
+		nodes[instanceKey(n.Node, n.ServiceID)] = node{
+			host:          n.Node,
+			datacenter:    n.Datacenter,
+			consulID:      n.ServiceID,
+			address:       address,
+			port:          n.ServicePort,
+			aRecAnswer:    v4,
+			aaaaRecAnswer: v6,
+			answerWeight:  answerWeight,
+			geo:           geo,
+			region:        region,
+			version:       version,
+			taggedAnswers: c.taggedAnswers(n, v4, v6),
+			srvRecAnswers: map[int]srvAnswer{
+				n.ServicePort: {
+					priority: priority,
+					weight:   weight,
+					port:     int64(n.ServicePort),
+					address:  address,
+				},
+			},
 		}
-		nodes[address] = node
 	}
 	return nodes
-
 }
 
-// transformServices transforms a map of services to the format required by local cache
+// transformServices transforms a map of services to the format required by local cache.
+// DNS names are case-insensitive, so services whose names only differ by case would be published to the same
+// records with alternating answers: the first name in lexical order is published and the others are reported.
+// Services tagged with the skip directive or not selected by the allow and deny patterns are left out.
 func (c *consul) transformServices(cservices map[string][]string) map[string]service {
-	services := make(map[string]service, len(cservices))
+	names := make([]string, 0, len(cservices))
 	for k := range cservices {
-		s := service{id: k, name: k, consulID: k}
-		services[s.name] = s
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	services := make(map[string]service, len(cservices))
+	published, sanitized := map[string]string{}, map[string]string{}
+	for _, k := range names {
+		if c.skipped(k, cservices[k]) {
+			continue
+		}
+		folded := strings.ToLower(k)
+		name := k
+		if c.lowercaseNames {
+			name = folded
+		}
+		name = c.names.sanitize(name)
+		if !c.selection.selects(name) {
+			c.log.Debug("service not selected by the allow and deny patterns, ignoring", "service", k)
+			continue
+		}
+		if other, ok := published[folded]; ok {
+			c.log.Error("service name only differs by case from another service, ignoring", "service", k, "published", other)
+			metrics.IncrCounter([]string{"consul", "name_conflict"}, 1)
+			continue
+		}
+		if other, ok := sanitized[strings.ToLower(name)]; ok {
+			c.log.Error("service name is sanitized to the name of another service, ignoring", "service", k,
+				"name", name, "published", other)
+			metrics.IncrCounter([]string{"consul", "name_conflict"}, 1)
+			continue
+		}
+		published[folded], sanitized[strings.ToLower(name)] = k, k
+		services[name] = service{id: k, name: name, consulID: k}
 	}
 	return services
 }
 
+// maxIndexJump is the largest increase of the Consul index trusted as regular progress. Larger jumps
+// happen when the state of Consul is replaced, e.g. by a snapshot restore, and are handled like a reset.
+const maxIndexJump = 1 << 32
+
+// minFetchInterval is the minimum time between two blocking queries that didn't return any change,
+// so the loop doesn't spin hot when Consul returns immediately
+var minFetchInterval = time.Second
+
+// queryDelay returns how long to wait before the next blocking query after one that took `elapsed`.
+// Queries are at least `minInterval` apart, and at least minFetchInterval if nothing changed.
+func queryDelay(minInterval, elapsed time.Duration, changed bool) time.Duration {
+	if !changed && minInterval < minFetchInterval {
+		minInterval = minFetchInterval
+	}
+	if elapsed >= minInterval {
+		return 0
+	}
+	return minInterval - elapsed
+}
+
+// nextWaitIndex returns the index of the next blocking query after one at `waitIndex` returned `index`,
+// and whether the index was reset, which happens when the state of Consul is reset or restored.
+// After a reset the next query is made at index 0, which doesn't block and returns the current index.
+// A returned index of 0 is never waited for, as a query at index 0 doesn't block either.
+func nextWaitIndex(waitIndex, index uint64) (uint64, bool) {
+	switch {
+	case index == 0:
+		return 1, waitIndex > 1
+	case index < waitIndex:
+		return 0, true
+	case waitIndex > 1 && index-waitIndex > maxIndexJump:
+		return 0, true
+	}
+	return index, false
+}
+
 // fetchIndefinitely is the main event loop for fetching services and handling channel events
 func (c *consul) fetchIndefinitely(stop, stopped chan struct{}) {
 	defer close(stopped)
 	waitIndex := uint64(1)
 	subsequentErrors := 0
 	for {
+		c.fetchBeat.beat(WaitTime * time.Second)
 		c.log.Debug(fmt.Sprintf("Fetching services at index %d", waitIndex))
+		start := c.clock.Now()
 		newIndex, err := c.fetch(waitIndex)
 		if err != nil {
 			c.log.Error("error fetching", "error", err.Error())
+			countError(err)
 			subsequentErrors++
 			if subsequentErrors > 10 {
+				c.fetchErr = err
 				return
 			}
-			time.Sleep(500 * time.Millisecond)
+			<-c.clock.After(500 * time.Millisecond)
+		} else if next, regressed := nextWaitIndex(waitIndex, newIndex); regressed {
+			// the local cache may mix the state before and after the reset, refetch from scratch
+			c.log.Warn("Consul index was reset, e.g. after a leader election, an agent restart or a snapshot restore, forcing a full resync",
+				"index", fmt.Sprintf("%d", newIndex), "previous", fmt.Sprintf("%d", waitIndex))
+			metrics.IncrCounter([]string{"consul", "index_reset"}, 1)
+			subsequentErrors = 0
+			waitIndex = next
+			c.requestResync()
 		} else {
 			subsequentErrors = 0
-			waitIndex = newIndex
-			c.trigger <- true
+			changed := next != waitIndex
+			metrics.IncrCounterWithLabels([]string{"consul", "wakeup"}, 1,
+				[]metrics.Label{{Name: "changed", Value: strconv.FormatBool(changed)}})
+			// don't spin hot when Consul returns immediately, e.g. while the catalog is churning
+			if delay := queryDelay(c.minQueryInterval, c.clock.Now().Sub(start), changed); delay > 0 {
+				select {
+				case <-c.clock.After(delay):
+				case <-stop:
+					return
+				}
+			}
+			waitIndex = next
+			select {
+			case c.trigger <- changed:
+			case <-stop:
+				return
+			}
 		}
 		select {
 		case <-stop:
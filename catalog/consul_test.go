@@ -1,9 +1,15 @@
 package catalog
 
 import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
 
 	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,40 +21,77 @@ func TestConsulTransformServices(t *testing.T) {
 	require.Equal(t, expected, c.transformServices(services))
 }
 
+func TestConsulTransformServices_Case(t *testing.T) {
+	services := map[string][]string{"Web": {}, "web": {}, "WEB": {}, "API": {}}
+
+	c := consul{log: hclog.NewNullLogger()}
+	require.Equal(t, map[string]service{
+		"API": {id: "API", name: "API", consulID: "API"},
+		"WEB": {id: "WEB", name: "WEB", consulID: "WEB"},
+	}, c.transformServices(services))
+
+	c.lowercaseNames = true
+	require.Equal(t, map[string]service{
+		"api": {id: "API", name: "api", consulID: "API"},
+		"web": {id: "WEB", name: "web", consulID: "WEB"},
+	}, c.transformServices(services))
+}
+
 func TestConsulTransformNodes(t *testing.T) {
 	c := consul{}
 	nodes := []*consulapi.CatalogService{
 		{
+			Node:        "n1",
 			Address:     "1.1.1.1",
 			ServicePort: 3,
-			ServiceID:   "s1",
+			ServiceID:   "s1-a",
 			ServiceMeta: map[string]string{"A": "B"},
 		},
 		{
+			Node:        "n1",
 			Address:     "1.1.1.1",
 			ServicePort: 4,
-			ServiceID:   "s1",
+			ServiceID:   "s1-b",
 			ServiceMeta: map[string]string{"A": "B"},
 		},
 		{
-			Address:     "2.2.2.2",
-			ServicePort: 3,
-			ServiceID:   "s1",
-			ServiceMeta: map[string]string{"A": "B"},
+			Node:           "n2",
+			Address:        "2.2.2.2",
+			ServiceAddress: "3.3.3.3",
+			ServicePort:    3,
+			ServiceID:      "s1-a",
+			ServiceMeta:    map[string]string{"A": "B"},
 		},
 	}
 	expected := map[string]node{
-		"1.1.1.1": {
+		"n1/s1-a": {
+			host:       "n1",
+			consulID:   "s1-a",
+			address:    "1.1.1.1",
+			port:       3,
 			aRecAnswer: "1.1.1.1",
 			srvRecAnswers: map[int]srvAnswer{
 				3: srvAnswer{priority: 1, weight: 1, port: 3, address: "1.1.1.1"},
+			},
+		},
+		"n1/s1-b": {
+			host:       "n1",
+			consulID:   "s1-b",
+			address:    "1.1.1.1",
+			port:       4,
+			aRecAnswer: "1.1.1.1",
+			srvRecAnswers: map[int]srvAnswer{
 				4: srvAnswer{priority: 1, weight: 1, port: 4, address: "1.1.1.1"},
 			},
 		},
-		"2.2.2.2": {
-			aRecAnswer: "2.2.2.2",
+		"n2/s1-a": {
+			host:       "n2",
+			consulID:   "s1-a",
+			address:    "3.3.3.3",
+			port:       3,
+			aRecAnswer: "3.3.3.3",
 			srvRecAnswers: map[int]srvAnswer{
-				3: srvAnswer{priority: 1, weight: 1, port: 3, address: "2.2.2.2"},
+				3: srvAnswer{priority: 1, weight: 1, port: 3, address: "3.3.3.3"},
 			},
 		},
 	}
@@ -58,14 +101,154 @@ func TestConsulTransformNodes(t *testing.T) {
 func TestConsulTransformHeath(t *testing.T) {
 	c := consul{}
 	healths := consulapi.HealthChecks{
-		&consulapi.HealthCheck{Status: "passing", ServiceID: "s1"},
-		&consulapi.HealthCheck{Status: "critical", ServiceID: "s2"},
-		&consulapi.HealthCheck{Status: "warning", ServiceID: "s3"},
+		&consulapi.HealthCheck{Status: "passing", Node: "n1", ServiceID: "s1"},
+		&consulapi.HealthCheck{Status: "critical", Node: "n1", ServiceID: "s2"},
+		&consulapi.HealthCheck{Status: "warning", Node: "n1", ServiceID: "s3"},
+		&consulapi.HealthCheck{Status: "critical", Node: "n2", ServiceID: "s1"},
 	}
 	expected := map[string]health{
-		"s1": passing,
-		"s2": critical,
-		"s3": unknown,
+		"n1/s1": passing,
+		"n1/s2": critical,
+		"n1/s3": unknown,
+		"n2/s1": critical,
 	}
 	require.Equal(t, expected, c.transformHealth(healths))
 }
+
+func TestConsulTransformHeath_MultipleChecks(t *testing.T) {
+	healths := consulapi.HealthChecks{
+		&consulapi.HealthCheck{Status: "passing", Node: "n1", ServiceID: "s1"},
+		&consulapi.HealthCheck{Status: "critical", Node: "n1", ServiceID: "s1"},
+		&consulapi.HealthCheck{Status: "passing", Node: "n1", ServiceID: "s1"},
+		&consulapi.HealthCheck{Status: "passing", Node: "n1", ServiceID: "s2"},
+		&consulapi.HealthCheck{Status: "warning", Node: "n1", ServiceID: "s2"},
+		&consulapi.HealthCheck{Status: "passing", Node: "n1", ServiceID: "s3"},
+		&consulapi.HealthCheck{Status: "passing", Node: "n1", ServiceID: "s3"},
+	}
+
+	c := consul{healthAggregation: worstHealth}
+	expected := map[string]health{
+		"n1/s1": critical,
+		"n1/s2": unknown,
+		"n1/s3": passing,
+	}
+	require.Equal(t, expected, c.transformHealth(healths))
+
+	c = consul{healthAggregation: bestHealth}
+	expected = map[string]health{
+		"n1/s1": passing,
+		"n1/s2": passing,
+		"n1/s3": passing,
+	}
+	require.Equal(t, expected, c.transformHealth(healths))
+}
+
+func TestConsulInstanceChecks(t *testing.T) {
+	entries := []*consulapi.ServiceEntry{
+		{
+			Node:    &consulapi.Node{Node: "n1"},
+			Service: &consulapi.AgentService{ID: "s1"},
+			Checks: consulapi.HealthChecks{
+				&consulapi.HealthCheck{Node: "n1", CheckID: "serfHealth", Status: "critical"},
+				&consulapi.HealthCheck{Node: "n1", CheckID: "service:s1", Status: "passing", ServiceID: "s1"},
+			},
+		},
+		{
+			Node:    &consulapi.Node{Node: "n2"},
+			Service: &consulapi.AgentService{ID: "s2"},
+			Checks: consulapi.HealthChecks{
+				&consulapi.HealthCheck{Node: "n2", CheckID: "serfHealth", Status: "passing"},
+				&consulapi.HealthCheck{Node: "n2", CheckID: "service:s2", Status: "passing", ServiceID: "s2"},
+			},
+		},
+	}
+
+	c := consul{}
+	expected := map[string]health{
+		"n1/s1": critical,
+		"n2/s2": passing,
+	}
+	require.Equal(t, expected, c.transformHealth(c.instanceChecks(entries)))
+	// node check must not be modified in place
+	require.Equal(t, "", entries[0].Checks[0].ServiceID)
+
+	c = consul{ignoreNodeChecks: true}
+	expected = map[string]health{
+		"n1/s1": passing,
+		"n2/s2": passing,
+	}
+	require.Equal(t, expected, c.transformHealth(c.instanceChecks(entries)))
+}
+
+func TestNextWaitIndex(t *testing.T) {
+	type variant struct {
+		waitIndex, index uint64
+		expected         uint64
+		regressed        bool
+	}
+	table := map[string]variant{
+		"first query":      {waitIndex: 1, index: 10, expected: 10},
+		"index increased":  {waitIndex: 10, index: 12, expected: 12},
+		"index unchanged":  {waitIndex: 10, index: 10, expected: 10},
+		"index went back":  {waitIndex: 10, index: 5, expected: 0, regressed: true},
+		"index reset to 0": {waitIndex: 10, index: 0, expected: 1, regressed: true},
+		"index 0 at start": {waitIndex: 1, index: 0, expected: 1},
+		"after reset":      {waitIndex: 0, index: 5, expected: 5},
+		"huge jump":        {waitIndex: 10, index: 10 + maxIndexJump + 1, expected: 0, regressed: true},
+		"jump at start":    {waitIndex: 1, index: 10 + maxIndexJump + 1, expected: 11 + maxIndexJump},
+	}
+	for name, v := range table {
+		next, regressed := nextWaitIndex(v.waitIndex, v.index)
+		require.Equal(t, v.expected, next, fmt.Sprintf("Test case: %s", name))
+		require.Equal(t, v.regressed, regressed, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestQueryDelay(t *testing.T) {
+	type variant struct {
+		minInterval, elapsed time.Duration
+		changed              bool
+		expected             time.Duration
+	}
+	table := map[string]variant{
+		"changed, no minimum":         {elapsed: 10 * time.Millisecond, changed: true, expected: 0},
+		"changed, below minimum":      {minInterval: 5 * time.Second, elapsed: 2 * time.Second, changed: true, expected: 3 * time.Second},
+		"changed, above minimum":      {minInterval: 5 * time.Second, elapsed: 6 * time.Second, changed: true, expected: 0},
+		"unchanged, returned early":   {elapsed: 200 * time.Millisecond, expected: minFetchInterval - 200*time.Millisecond},
+		"unchanged, blocked":          {elapsed: 10 * time.Second, expected: 0},
+		"unchanged, above fetch rate": {minInterval: 5 * time.Second, elapsed: 2 * time.Second, expected: 3 * time.Second},
+	}
+	for name, v := range table {
+		require.Equal(t, v.expected, queryDelay(v.minInterval, v.elapsed, v.changed), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestConsulFetch_DropsOlderIndex(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Consul-Index", "10")
+		switch r.URL.Path {
+		case "/v1/catalog/services":
+			json.NewEncoder(w).Encode(map[string][]string{"web": {}})
+		case "/v1/catalog/service/web":
+			json.NewEncoder(w).Encode([]*consulapi.CatalogService{
+				{Node: "n1", ServiceID: "web", ServiceName: "web", Address: "127.0.0.1", ServicePort: 8080},
+			})
+		default:
+			json.NewEncoder(w).Encode([]interface{}{})
+		}
+	}))
+	defer srv.Close()
+	client, err := consulapi.NewClient(&consulapi.Config{Address: srv.URL})
+	require.NoError(t, err)
+	c := consul{client: client, log: hclog.NewNullLogger(), addressFamily: ipv4Family, fetchedIndex: 20}
+
+	index, err := c.fetch(15)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), index)
+	require.Empty(t, c.getServices(), "services older than the last applied ones are dropped")
+
+	_, err = c.fetch(0)
+	require.NoError(t, err)
+	require.Len(t, c.getServices(), 1, "services fetched from scratch are always applied")
+	require.Equal(t, uint64(10), c.fetchedIndex)
+}
@@ -0,0 +1,34 @@
+package catalog
+
+import (
+	"net/http"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// splitRecordService reads records with one NS1 client and writes them with another, so the frequent reads can
+// use an API key that may only read
+type splitRecordService struct {
+	read  recordService
+	write recordService
+}
+
+// Get reads a record with the read client
+func (s *splitRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	return s.read.Get(zone, domain, t)
+}
+
+// Create creates a record with the write client
+func (s *splitRecordService) Create(r *dns.Record) (*http.Response, error) {
+	return s.write.Create(r)
+}
+
+// Update updates a record with the write client
+func (s *splitRecordService) Update(r *dns.Record) (*http.Response, error) {
+	return s.write.Update(r)
+}
+
+// Delete deletes a record with the write client
+func (s *splitRecordService) Delete(zone, domain, t string) (*http.Response, error) {
+	return s.write.Delete(zone, domain, t)
+}
@@ -0,0 +1,24 @@
+package catalog
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestSplitRecordService(t *testing.T) {
+	read, write := &mockRecordService{mux: &sync.Mutex{}}, &mockRecordService{mux: &sync.Mutex{}}
+	s := &splitRecordService{read: read, write: write}
+	r := dns.NewRecord("test.zone", "web.test.zone", "A")
+
+	s.Get("test.zone", "web.test.zone", "A")
+	s.Create(r)
+	s.Update(r)
+	s.Delete("test.zone", "web.test.zone", "A")
+	assert.Equal(t, 1, read.callCount)
+	assert.Equal(t, 3, write.callCount)
+	assert.Len(t, write.records, 2)
+	assert.Equal(t, []string{"web.test.zone A"}, write.deleted)
+}
@@ -0,0 +1,237 @@
+package catalog
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// errPollOverlapped is returned by polls of NS1 that overlapped the writes of a sync cycle
+var errPollOverlapped = errors.New("poll overlapped the writes of a sync cycle")
+
+// cyclePhase is the phase of the reconciliation between Consul and NS1, see `syncCycle`
+type cyclePhase int
+
+const (
+	// phaseIdle waits for the fetch loops, nothing was written since NS1 was last polled
+	phaseIdle cyclePhase = iota
+	// phaseFetching waits for the fetch loop that didn't report yet, or fetches both for a resync
+	phaseFetching
+	// phaseDiffing computes the changes to write, which freezes, approvals and limits may hold back
+	phaseDiffing
+	// phaseApplying writes the changes to NS1
+	phaseApplying
+	// phaseCooling waits for a poll of the zone written by the last cycle, the next cycle is diffed against it
+	phaseCooling
+)
+
+var cyclePhaseNames = [...]string{"idle", "fetching", "diffing", "applying", "cooling"}
+
+func (p cyclePhase) String() string {
+	return cyclePhaseNames[p]
+}
+
+// syncCycle is the state machine coordinating the Consul and NS1 fetch loops with the sync loop. A cycle moves
+// from idle to fetching when a fetch loop reports, and to diffing once both did. Changes that aren't held back are
+// then applied, and the cycle cools down until NS1 is polled again, or goes back to idle if nothing was written.
+//
+// A zone polled while a cycle is diffing or applying is only half updated, and taking it for the state of NS1
+// would undo the rest of the writes on the next cycle. Polls don't start during these phases, and polls that were
+// running when a cycle started diffing are discarded. A nil syncCycle never holds polls back, but the sync loop
+// requires one.
+type syncCycle struct {
+	clock *clock
+	lock  sync.Mutex
+	phase cyclePhase
+	// entered is the time the current phase was entered
+	entered time.Time
+	// consulReported and ns1Reported are set once the fetch loops completed an iteration since the last cycle
+	consulReported, ns1Reported bool
+	// awaitingPoll is set once a cycle wrote to NS1 until the NS1 fetch loop reports
+	awaitingPoll bool
+	// reason is why the next cycle runs, set by the first report carrying a change
+	reason string
+	// writing is closed once the running cycle is done diffing and applying, nil otherwise
+	writing chan struct{}
+	// generation counts the cycles that started diffing
+	generation uint64
+	// settled is the generation the last poll of NS1 applied to the services started in
+	settled uint64
+}
+
+// cycleStatus is the state of the sync cycle reported by the admin API
+type cycleStatus struct {
+	Phase      string    `json:"phase"`
+	Since      time.Time `json:"since"`
+	Generation uint64    `json:"generation"`
+	// Consul and NS1 are set once their fetch loop reported for the next cycle
+	Consul bool   `json:"consul"`
+	NS1    bool   `json:"ns1"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// enter moves the cycle to a phase and measures the time spent in the previous one. The lock must be held.
+func (c *syncCycle) enter(phase cyclePhase) {
+	now := c.clock.Now()
+	if phase == c.phase {
+		return
+	}
+	if !c.entered.IsZero() {
+		metrics.AddSampleWithLabels([]string{"sync", "phase_duration"}, float32(now.Sub(c.entered).Seconds()*1000),
+			[]metrics.Label{{Name: "phase", Value: c.phase.String()}})
+	}
+	c.phase, c.entered = phase, now
+	metrics.SetGauge([]string{"sync", "phase"}, float32(phase))
+}
+
+// waiting returns the phase of a cycle waiting for the fetch loops. The lock must be held.
+func (c *syncCycle) waiting() cyclePhase {
+	switch {
+	case c.awaitingPoll:
+		return phaseCooling
+	case c.consulReported || c.ns1Reported:
+		return phaseFetching
+	}
+	return phaseIdle
+}
+
+// reportConsul notes an iteration of the Consul fetch loop, `changed` if the catalog changed, and reports whether
+// the cycle is ready to diff
+func (c *syncCycle) reportConsul(changed bool) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.consulReported = true
+	if changed && c.reason == "" {
+		c.reason = syncReasonConsulChange
+	}
+	c.enter(c.waiting())
+	return c.ready()
+}
+
+// reportNS1 notes an iteration of the NS1 fetch loop, `drifted` if managed records were changed outside of
+// consul-ns1, and reports whether the cycle is ready to diff. A cooling cycle ignores polls started before it
+// wrote, e.g. reported while it was applying.
+func (c *syncCycle) reportNS1(drifted bool) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.awaitingPoll && c.settled != c.generation {
+		return false
+	}
+	c.ns1Reported, c.awaitingPoll = true, false
+	if drifted && c.reason == "" {
+		c.reason = syncReasonNS1Drift
+	}
+	c.enter(c.waiting())
+	return c.ready()
+}
+
+// ready reports whether both fetch loops reported since the last cycle. The lock must be held.
+func (c *syncCycle) ready() bool {
+	return c.consulReported && c.ns1Reported && !c.awaitingPoll
+}
+
+// resyncing moves the cycle to fetching while Consul and NS1 are fetched from scratch
+func (c *syncCycle) resyncing() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.enter(phaseFetching)
+}
+
+// resynced ends a resync and reports whether the cycle is ready to diff, which it is once both fetches succeeded
+func (c *syncCycle) resynced(ok bool) bool {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if ok {
+		c.consulReported, c.ns1Reported, c.awaitingPoll = true, true, false
+		c.reason = syncReasonResync
+	}
+	c.enter(c.waiting())
+	return c.ready()
+}
+
+// diff moves a ready cycle to diffing and returns why it runs. Polls are held back until the cycle is done.
+func (c *syncCycle) diff() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	reason := c.reason
+	if reason == "" {
+		reason = syncReasonTimer
+	}
+	c.consulReported, c.ns1Reported, c.reason = false, false, ""
+	c.writing = make(chan struct{})
+	c.generation++
+	c.enter(phaseDiffing)
+	return reason
+}
+
+// apply moves the cycle to applying once its changes were let through
+func (c *syncCycle) apply() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.enter(phaseApplying)
+}
+
+// done ends a cycle, which cools down until NS1 is polled again if it `wrote` to NS1
+func (c *syncCycle) done(wrote bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.writing != nil {
+		close(c.writing)
+		c.writing = nil
+	}
+	c.awaitingPoll = wrote
+	c.enter(c.waiting())
+}
+
+// busy returns a channel closed once the running cycle is done diffing and applying, nil if none is
+func (c *syncCycle) busy() <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.writing
+}
+
+// current returns the generation a poll starts in
+func (c *syncCycle) current() uint64 {
+	if c == nil {
+		return 0
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.generation
+}
+
+// settle runs f, which applies the result of a poll started in generation `gen`, unless a cycle started diffing
+// since, and reports whether it ran. Cycles don't start diffing while f runs.
+func (c *syncCycle) settle(gen uint64, f func()) bool {
+	if c == nil {
+		f()
+		return true
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.writing != nil || c.generation != gen {
+		return false
+	}
+	f()
+	c.settled = gen
+	return true
+}
+
+// status returns the state of the cycle
+func (c *syncCycle) status() cycleStatus {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return cycleStatus{
+		Phase:      c.phase.String(),
+		Since:      c.entered,
+		Generation: c.generation,
+		Consul:     c.consulReported,
+		NS1:        c.ns1Reported,
+		Reason:     c.reason,
+	}
+}
@@ -0,0 +1,111 @@
+package catalog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncCycle(t *testing.T) {
+	f := newFakeClock(time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC))
+	c := &syncCycle{clock: f.clock()}
+	assert.Equal(t, phaseIdle, c.phase)
+
+	// a cycle runs once both fetch loops reported
+	assert.False(t, c.reportConsul(true))
+	assert.Equal(t, phaseFetching, c.phase)
+	assert.True(t, c.reportNS1(false))
+	gen := c.current()
+	assert.Equal(t, syncReasonConsulChange, c.diff())
+	assert.Equal(t, phaseDiffing, c.phase)
+	writing := c.busy()
+	assert.NotNil(t, writing)
+	assert.False(t, c.settle(gen, func() { t.Fatal("settled while diffing") }))
+	c.apply()
+	assert.Equal(t, phaseApplying, c.phase)
+
+	// a cycle that wrote cools down until NS1 is polled again
+	c.done(true)
+	<-writing
+	assert.Nil(t, c.busy())
+	assert.Equal(t, phaseCooling, c.phase)
+	assert.False(t, c.reportConsul(false))
+	assert.Equal(t, phaseCooling, c.phase)
+	// polls started before the cycle are discarded or ignored
+	assert.False(t, c.settle(gen, func() { t.Fatal("settled a poll overlapping the cycle") }))
+	assert.False(t, c.reportNS1(true))
+	assert.Equal(t, phaseCooling, c.phase)
+	assert.True(t, c.settle(c.current(), func() {}))
+	assert.True(t, c.reportNS1(false))
+	assert.Equal(t, syncReasonTimer, c.diff())
+
+	// a cycle that wrote nothing, e.g. held back by a freeze, goes back to idle
+	c.done(false)
+	assert.Equal(t, phaseIdle, c.phase)
+
+	// resyncs fetch both sides
+	c.resyncing()
+	assert.Equal(t, phaseFetching, c.phase)
+	assert.False(t, c.resynced(false))
+	assert.Equal(t, phaseIdle, c.phase)
+	f.advance(time.Second)
+	c.resyncing()
+	assert.True(t, c.resynced(true))
+	assert.Equal(t, cycleStatus{Phase: "fetching", Since: f.now, Generation: 2, Consul: true, NS1: true, Reason: syncReasonResync}, c.status())
+	assert.Equal(t, syncReasonResync, c.diff())
+
+	var disabled *syncCycle
+	assert.Nil(t, disabled.busy())
+	ran := false
+	assert.True(t, disabled.settle(disabled.current(), func() { ran = true }))
+	assert.True(t, ran)
+}
+
+func TestSyncCycle_Status(t *testing.T) {
+	c := &syncCycle{}
+	c.reportConsul(true)
+	srv := httptest.NewServer(adminHandler(nil, nil, c, nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/cycle")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var status cycleStatus
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, "fetching", status.Phase)
+	assert.True(t, status.Consul)
+	assert.False(t, status.NS1)
+	assert.Equal(t, syncReasonConsulChange, status.Reason)
+}
+
+func TestNS1FetchIndefinitely_Cycle(t *testing.T) {
+	n := testClient(nil)
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: &mockRecordService{}}
+	n.cycle = &syncCycle{}
+	n.pollInterval = time.Hour
+	n.trigger = make(chan bool)
+	n.cycle.diff()
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go n.fetchIndefinitely(stop, stopped)
+
+	// the poll waits for the sync cycle to be done writing
+	select {
+	case <-n.trigger:
+		t.Fatal("polled while the sync cycle was writing")
+	case <-time.After(10 * time.Millisecond):
+	}
+	n.cycle.done(true)
+	<-n.trigger
+	// the poll ends the cool down
+	n.cycle.reportNS1(false)
+	assert.Equal(t, phaseFetching, n.cycle.phase)
+
+	close(stop)
+	<-stopped
+}
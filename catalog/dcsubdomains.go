@@ -0,0 +1,72 @@
+package catalog
+
+import (
+	"sort"
+)
+
+// addDatacenterSubdomains publishes the instances of each service in each datacenter as a service of its own,
+// named "<service>.<datacenter>", next to the service holding all its instances. The services are diffed,
+// written and removed like any other service and read back from NS1 as such, the datacenter being the last
+// label of their name.
+func (c *consul) addDatacenterSubdomains(services map[string]service) {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		s := services[k]
+		if s.cnameRecAnswer != "" || s.datacenter != "" {
+			continue
+		}
+		subdomains := map[string]map[string]node{}
+		datacenters := map[string]string{}
+		for key, n := range s.nodes {
+			if n.datacenter == "" {
+				continue
+			}
+			name := k + "." + srvTargetLabel(n.datacenter)
+			if subdomains[name] == nil {
+				subdomains[name] = map[string]node{}
+			}
+			subdomains[name][key] = n
+			datacenters[name] = n.datacenter
+		}
+		for name, nodes := range subdomains {
+			if other, ok := services[name]; ok && other.datacenter == "" {
+				c.log.Warn("service name collides with the subdomain of a datacenter, the service is published",
+					"service", name, "datacenter", datacenters[name])
+				continue
+			}
+			d := c.subsetService(s, name, nodes)
+			d.datacenter = datacenters[name]
+			services[name] = d
+		}
+	}
+}
+
+// subsetService returns a service publishing a subset of the instances of another service under a name of its
+// own, with the TTLs, port hints and instance count of the subset
+func (c *consul) subsetService(s service, name string, nodes map[string]node) service {
+	d := s
+	d.name, d.nodes = name, nodes
+	if s.cnameRecAnswer != "" {
+		d.ttls.cnameRecTTL = c.ttl(name, "CNAME", s.ttlOverride)
+		return d
+	}
+	d.ttls.aRecTTL, d.ttls.srvRecTTL = c.ttl(name, "A", s.ttlOverride), c.ttl(name, "SRV", s.ttlOverride)
+	if c.addressFamily.v6() {
+		d.ttls.aaaaRecTTL = c.ttl(name, "AAAA", s.ttlOverride)
+	}
+	if s.httpsRecAnswer != "" {
+		d.ttls.httpsRecTTL = c.ttl(name, "HTTPS", s.ttlOverride)
+	}
+	if c.portHints {
+		d.txtRecAnswer = portsTXTAnswer(nodes)
+		d.ttls.txtRecTTL = c.ttl(name, "TXT", s.ttlOverride)
+	}
+	if c.instanceCounts {
+		d.healthyInstances = countHealthyInstances(nodes, s.healths)
+	}
+	return d
+}
@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestAddDatacenterSubdomains(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), ns1Prefix: "p-", dnsTTL: 10, addressFamily: ipv4Family}
+	instance := func(dc, address string) node {
+		return node{host: "h-" + address, datacenter: dc, address: address, port: 80, aRecAnswer: address,
+			srvRecAnswers: map[int]srvAnswer{80: {priority: 1, weight: 1, port: 80, address: address}}}
+	}
+	services := map[string]service{
+		"web": {id: "web", name: "web", consulID: "web", nodes: map[string]node{
+			"h1/web": instance("dc1", "1.1.1.1"),
+			"h2/web": instance("dc1", "2.2.2.2"),
+			"h3/web": instance("DC_2", "3.3.3.3"),
+		}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+		"api":     {cnameRecAnswer: "ingress.example.com", ttls: recordTTLs{cnameRecTTL: 10}},
+		"db":      {nodes: map[string]node{"h1/db": instance("dc1", "1.1.1.1")}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+		"db.dc1":  {nodes: map[string]node{"h4/db": instance("", "4.4.4.4")}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+		"default": {nodes: map[string]node{"h5/default": instance("", "5.5.5.5")}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+	}
+	c.addDatacenterSubdomains(services)
+
+	assert.Len(t, services, 7)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, aAnswers(services["web"].nodes))
+	dc1 := services["web.dc1"]
+	assert.Equal(t, "dc1", dc1.datacenter)
+	assert.Equal(t, "web", dc1.consulID)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, aAnswers(dc1.nodes))
+	assert.Equal(t, recordTTLs{aRecTTL: 10, srvRecTTL: 10}, dc1.ttls)
+	assert.Equal(t, []string{"3.3.3.3"}, aAnswers(services["web.dc-2"].nodes))
+	// services colliding with a subdomain are published as they are
+	assert.Equal(t, []string{"4.4.4.4"}, aAnswers(services["db.dc1"].nodes))
+	assert.Equal(t, "", services["db.dc1"].datacenter)
+
+	// the records read back from NS1 match the desired state
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, ns1Prefix: "p-", addressFamily: ipv4Family, log: hclog.NewNullLogger()}
+	delete(services, "db")
+	delete(services, "db.dc1")
+	delete(services, "default")
+	z := &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+		{Domain: "p-web.test.zone", ID: "1", Type: "A", TTL: 10, ShortAns: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}},
+		{Domain: "p-web.test.zone", ID: "2", Type: "SRV", TTL: 10, ShortAns: []string{"1 1 80 1.1.1.1", "1 1 80 2.2.2.2", "1 1 80 3.3.3.3"}},
+		{Domain: "p-api.test.zone", ID: "3", Type: "CNAME", TTL: 10, ShortAns: []string{"ingress.example.com."}},
+		{Domain: "p-web.dc1.test.zone", ID: "4", Type: "A", TTL: 10, ShortAns: []string{"1.1.1.1", "2.2.2.2"}},
+		{Domain: "p-web.dc1.test.zone", ID: "5", Type: "SRV", TTL: 10, ShortAns: []string{"1 1 80 1.1.1.1", "1 1 80 2.2.2.2"}},
+		{Domain: "p-web.dc-2.test.zone", ID: "6", Type: "A", TTL: 10, ShortAns: []string{"3.3.3.3"}},
+		{Domain: "p-web.dc-2.test.zone", ID: "7", Type: "SRV", TTL: 10, ShortAns: []string{"1 1 80 3.3.3.3"}},
+	}}
+	actual := n.transformZoneRecords(z)
+	assert.Empty(t, onlyInFirst(services, actual))
+	assert.Empty(t, serviceOnlyInFirst(actual, services))
+}
@@ -0,0 +1,93 @@
+package catalog
+
+import (
+	"sort"
+
+	metrics "github.com/armon/go-metrics"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// publishAfterMetaKey is the service meta key naming another Consul service whose records must be published and
+// healthy before the records of the service are created, e.g. when SRV answers of the service target a name managed
+// for the other service
+const publishAfterMetaKey = "ns1-publish-after"
+
+// publishAfter returns the Consul service the instances of a service declare to be published after, empty if none
+// does. Instances are expected to agree, if they don't the first name in lexical order is used.
+func (c *consul) publishAfter(name string, cnodes []*consulapi.CatalogService) string {
+	after := []string{}
+	for _, n := range cnodes {
+		if v := n.ServiceMeta[publishAfterMetaKey]; v != "" && v != name {
+			after = append(after, v)
+		}
+	}
+	if len(after) == 0 {
+		return ""
+	}
+	sort.Strings(after)
+	if after[0] != after[len(after)-1] {
+		c.log.Warn("instances declare different services to be published after, using the first", "service", name,
+			"after", after[0])
+	}
+	return after[0]
+}
+
+// holdDependents leaves out of `upsert` the services that don't exist in NS1 yet and are published after a service
+// whose records don't all exist in NS1 yet or that has no healthy instance. They are created by a later cycle, once
+// the records of their dependency were written and read back. Services whose dependencies form a cycle aren't held.
+func (n *ns1) holdDependents(upsert, desired, existing map[string]service) map[string]service {
+	published := map[string][]string{}
+	for k, s := range desired {
+		if !s.srvTarget {
+			published[s.consulID] = append(published[s.consulID], k)
+		}
+	}
+	ready := func(after string) bool {
+		healthy := false
+		for _, k := range published[after] {
+			if e, ok := existing[k]; !ok || e.ns1IDs.recordCount() == 0 {
+				return false
+			}
+			s := desired[k]
+			if s.cnameRecAnswer != "" || countHealthyInstances(s.nodes, s.healths) > 0 {
+				healthy = true
+			}
+		}
+		return healthy
+	}
+	result := make(map[string]service, len(upsert))
+	for k, s := range upsert {
+		after := desired[k].publishAfter
+		if _, ok := existing[k]; ok || after == "" || ready(after) {
+			result[k] = s
+			continue
+		}
+		if dependencyCycle(desired[k].consulID, desired, published) {
+			n.log.Warn("services are published after each other, ignoring the dependency", "service", k, "after", after)
+			result[k] = s
+			continue
+		}
+		n.log.Info("service held back until the service it's published after is published and healthy",
+			"service", k, "after", after)
+		metrics.IncrCounter([]string{"ns1", "publish_held"}, 1)
+	}
+	return result
+}
+
+// dependencyCycle reports whether following the services a Consul service is published after leads back to it.
+// `published` holds the names each Consul service is published under.
+func dependencyCycle(consulID string, desired map[string]service, published map[string][]string) bool {
+	seen := map[string]bool{}
+	for next := consulID; next != "" && !seen[next]; {
+		seen[next] = true
+		names := published[next]
+		if len(names) == 0 {
+			return false
+		}
+		next = desired[names[0]].publishAfter
+		if next == consulID {
+			return true
+		}
+	}
+	return false
+}
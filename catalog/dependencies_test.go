@@ -0,0 +1,112 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishAfter(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	instance := func(after string) *consulapi.CatalogService {
+		meta := map[string]string{}
+		if after != "" {
+			meta[publishAfterMetaKey] = after
+		}
+		return &consulapi.CatalogService{Node: "n", ServiceMeta: meta}
+	}
+	table := map[string]struct {
+		cnodes   []*consulapi.CatalogService
+		expected string
+	}{
+		"none":      {[]*consulapi.CatalogService{instance("")}, ""},
+		"declared":  {[]*consulapi.CatalogService{instance(""), instance("db")}, "db"},
+		"agreeing":  {[]*consulapi.CatalogService{instance("db"), instance("db")}, "db"},
+		"different": {[]*consulapi.CatalogService{instance("db"), instance("cache")}, "cache"},
+		"itself":    {[]*consulapi.CatalogService{instance("web")}, ""},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, c.publishAfter("web", v.cnodes), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestHoldDependents(t *testing.T) {
+	n := testClient(nil)
+	healthy := map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}
+	published := service{ns1IDs: recordIDs{aRecID: "1"}}
+	table := map[string]struct {
+		desired  map[string]service
+		existing map[string]service
+		held     []string
+	}{
+		"dependency missing in NS1": {
+			desired: map[string]service{
+				"web": {consulID: "web", nodes: healthy, publishAfter: "db"},
+				"db":  {consulID: "db", nodes: healthy},
+			},
+			existing: map[string]service{},
+			held:     []string{"web"},
+		},
+		"dependency without healthy instance": {
+			desired: map[string]service{
+				"web": {consulID: "web", nodes: healthy, publishAfter: "db"},
+				"db":  {consulID: "db", nodes: healthy, healths: map[string]health{"h1": critical}},
+			},
+			existing: map[string]service{"db": published},
+			held:     []string{"web"},
+		},
+		"dependency not in Consul": {
+			desired: map[string]service{
+				"web": {consulID: "web", nodes: healthy, publishAfter: "db"},
+			},
+			existing: map[string]service{},
+			held:     []string{"web"},
+		},
+		"dependency ready": {
+			desired: map[string]service{
+				"web": {consulID: "web", nodes: healthy, publishAfter: "db"},
+				"db":  {consulID: "db", nodes: healthy},
+			},
+			existing: map[string]service{"db": published},
+		},
+		"service already published": {
+			desired: map[string]service{
+				"web": {consulID: "web", nodes: healthy, publishAfter: "db"},
+				"db":  {consulID: "db", nodes: healthy},
+			},
+			existing: map[string]service{"web": published},
+		},
+		"chain": {
+			desired: map[string]service{
+				"web":   {consulID: "web", nodes: healthy, publishAfter: "api"},
+				"api":   {consulID: "api", nodes: healthy, publishAfter: "db"},
+				"db":    {consulID: "db", nodes: healthy},
+				"other": {consulID: "other", nodes: healthy},
+			},
+			existing: map[string]service{},
+			held:     []string{"web", "api"},
+		},
+		"cycle": {
+			desired: map[string]service{
+				"web": {consulID: "web", nodes: healthy, publishAfter: "api"},
+				"api": {consulID: "api", nodes: healthy, publishAfter: "web"},
+			},
+			existing: map[string]service{},
+		},
+	}
+	for name, v := range table {
+		upsert := onlyInFirst(v.desired, v.existing)
+		result := n.holdDependents(upsert, v.desired, v.existing)
+		for k := range upsert {
+			_, ok := result[k]
+			held := false
+			for _, h := range v.held {
+				held = held || h == k
+			}
+			assert.Equal(t, !held, ok, fmt.Sprintf("Test case: %s, service %s", name, k))
+		}
+	}
+}
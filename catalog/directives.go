@@ -0,0 +1,110 @@
+package catalog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// directivePrefix is the prefix of the service tags read as directives, e.g. "ns1:ttl=30", letting the owners of
+// a service control how it's published without changing the configuration of the sync
+const directivePrefix = "ns1:"
+
+const (
+	// skipDirective excludes a service from DNS
+	skipDirective = "skip"
+	// ttlDirective overrides the TTL of the records of a service in seconds, like the ns1-ttl meta
+	ttlDirective = "ttl"
+	// weightDirective replaces the Consul passing weight of an instance
+	weightDirective = "weight"
+	// zoneDirective routes a service to an NS1 zone, like the ns1-zone meta
+	zoneDirective = "zone"
+)
+
+// isDirective reports whether a tag is a directive rather than a tag of the service
+func isDirective(tag string) bool {
+	return strings.HasPrefix(tag, directivePrefix)
+}
+
+// tagDirectives returns the values of the directives among tags, keyed by directive. Directives without a value,
+// e.g. "ns1:skip", have an empty value.
+func tagDirectives(tags []string) map[string][]string {
+	directives := map[string][]string{}
+	for _, tag := range tags {
+		if !isDirective(tag) {
+			continue
+		}
+		parts := strings.SplitN(strings.TrimPrefix(tag, directivePrefix), "=", 2)
+		key, value := strings.ToLower(strings.TrimSpace(parts[0])), ""
+		if len(parts) == 2 {
+			value = strings.TrimSpace(parts[1])
+		}
+		directives[key] = append(directives[key], value)
+	}
+	return directives
+}
+
+// withoutDirectives returns the tags that aren't directives
+func withoutDirectives(tags []string) []string {
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !isDirective(tag) {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+// skipped reports whether the tags of a service exclude it from DNS, reporting the directives it doesn't know
+func (c *consul) skipped(name string, tags []string) bool {
+	directives := tagDirectives(tags)
+	unknown := []string{}
+	for k := range directives {
+		switch k {
+		case skipDirective, ttlDirective, weightDirective, zoneDirective:
+		default:
+			unknown = append(unknown, k)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		c.log.Warn("unknown directives in service tags, ignoring", "service", name,
+			"directives", strings.Join(unknown, ","))
+	}
+	if _, ok := directives[skipDirective]; ok {
+		c.log.Debug("service excluded by tag", "service", name)
+		return true
+	}
+	return false
+}
+
+// directiveInt parses the value of a numeric directive among the tags of an instance, between 1 and `max`.
+// Invalid values are logged and ignored.
+func (c *consul) directiveInt(service, node string, tags []string, key string, max uint64) (int64, bool) {
+	values := tagDirectives(tags)[key]
+	if len(values) == 0 {
+		return 0, false
+	}
+	i, err := strconv.ParseUint(values[0], 10, 64)
+	if err != nil || i == 0 || i > max {
+		c.log.Warn("invalid directive in service tags, ignoring", "service", service, "node", node,
+			"directive", key, "value", values[0])
+		return 0, false
+	}
+	if len(values) > 1 {
+		c.log.Warn("instance repeats a directive in its tags, using the first", "service", service, "node", node,
+			"directive", key)
+	}
+	return int64(i), true
+}
+
+// passingWeight returns the passing weight of an instance: its weight directive, else its passing Consul weight,
+// 0 if it has neither. Weights are the weights of SRV answers, so they're at most 65535.
+func (c *consul) passingWeight(n *consulapi.CatalogService) int64 {
+	if w, ok := c.directiveInt(n.ServiceName, n.Node, n.ServiceTags, weightDirective, 65535); ok {
+		return w
+	}
+	return int64(n.ServiceWeights.Passing)
+}
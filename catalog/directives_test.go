@@ -0,0 +1,69 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagDirectives(t *testing.T) {
+	table := map[string]struct {
+		tags     []string
+		expected map[string][]string
+	}{
+		"none":      {[]string{"primary", "ns1-zone=internal.example.com"}, map[string][]string{}},
+		"skip":      {[]string{"primary", "ns1:skip"}, map[string][]string{"skip": {""}}},
+		"values":    {[]string{"ns1:ttl=30", "ns1:Weight = 5"}, map[string][]string{"ttl": {"30"}, "weight": {"5"}}},
+		"repeated":  {[]string{"ns1:zone=a.example.com", "ns1:zone=b.example.com"}, map[string][]string{"zone": {"a.example.com", "b.example.com"}}},
+		"separator": {[]string{"ns1:note=a=b"}, map[string][]string{"note": {"a=b"}}},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, tagDirectives(v.tags), fmt.Sprintf("Test case: %s", name))
+	}
+	assert.Equal(t, []string{"primary"}, withoutDirectives([]string{"ns1:skip", "primary", "ns1:ttl=30"}))
+}
+
+func TestConsulTransformServices_Skipped(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	services := c.transformServices(map[string][]string{
+		"web":   {"primary", "ns1:ttl=30"},
+		"debug": {"ns1:skip"},
+		"Api":   {"ns1:skip"},
+		"api":   {"ns1:unknown"},
+	})
+	assert.Equal(t, map[string]service{
+		"web": {id: "web", name: "web", consulID: "web"},
+		"api": {id: "api", name: "api", consulID: "api"},
+	}, services, "skipped services don't conflict with the services they differ from by case")
+}
+
+func TestPassingWeight(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), weightedAnswers: true}
+	table := map[string]struct {
+		tags   []string
+		meta   map[string]string
+		answer int64
+		srv    int64
+	}{
+		"consul weight":  {nil, nil, 10, 10},
+		"directive":      {[]string{"ns1:weight=5"}, nil, 5, 5},
+		"invalid":        {[]string{"ns1:weight=70000"}, nil, 10, 10},
+		"srv meta":       {[]string{"ns1:weight=5"}, map[string]string{"ns1-srv-weight": "20"}, 5, 20},
+		"zero directive": {[]string{"ns1:weight=0"}, nil, 10, 10},
+	}
+	for name, v := range table {
+		n := &consulapi.CatalogService{Node: "n1", ServiceName: "web", ServiceTags: v.tags, ServiceMeta: v.meta,
+			ServiceWeights: consulapi.Weights{Passing: 10, Warning: 1}}
+		_, weight := c.srvPriorityWeight(n)
+		assert.Equal(t, v.srv, weight, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.answer, c.answerWeight(n), fmt.Sprintf("Test case: %s", name))
+	}
+
+	// the meta of the node takes precedence
+	n := &consulapi.CatalogService{Node: "n1", ServiceTags: []string{"ns1:weight=5"},
+		NodeMeta: map[string]string{"ns1-weight": "3"}}
+	assert.Equal(t, int64(3), c.answerWeight(n))
+}
@@ -0,0 +1,170 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// driftDetector tracks the state of managed records to detect changes that didn't originate from
+// this instance, e.g. manual edits in the NS1 portal. The zero value is ready to use.
+type driftDetector struct {
+	lock sync.Mutex
+	// known holds the state of each managed record as of the last fetch, nil until the first fetch
+	known map[string]string
+	// writes holds the records written by this instance since the last fetch started
+	writes []recordWrite
+}
+
+// recordWrite is the state of a record after a successful write, an empty state means the record was deleted
+type recordWrite struct {
+	key   string
+	state string
+	at    time.Time
+}
+
+// driftKind classifies a change of a managed record
+type driftKind string
+
+const (
+	driftAdded    driftKind = "added"
+	driftModified driftKind = "modified"
+	driftRemoved  driftKind = "removed"
+)
+
+// recordDrift is a change of a managed record that didn't originate from this instance
+type recordDrift struct {
+	key  string
+	kind driftKind
+}
+
+// recordKey identifies a record by domain and type
+func recordKey(domain, recType string) string {
+	return domain + " " + recType
+}
+
+// recordState returns a comparable representation of the answers and TTL of a record
+func recordState(answers []string, ttl int) string {
+	trimmed := make([]string, len(answers))
+	for i, a := range answers {
+		trimmed[i] = strings.Trim(a, "\"")
+	}
+	sort.Strings(trimmed)
+	return fmt.Sprintf("%s ttl=%d", strings.Join(trimmed, ","), ttl)
+}
+
+// writtenState returns the state of a record as written to NS1
+func writtenState(rec *dns.Record) string {
+	answers := make([]string, len(rec.Answers))
+	for i, a := range rec.Answers {
+		answers[i] = strings.Join(a.Rdata, " ")
+	}
+	return recordState(answers, rec.TTL)
+}
+
+// wrote records the state of a record after a successful write at `at`
+func (d *driftDetector) wrote(domain, recType, state string, at time.Time) {
+	d.lock.Lock()
+	d.writes = append(d.writes, recordWrite{key: recordKey(domain, recType), state: state, at: at})
+	d.lock.Unlock()
+}
+
+// observe compares the managed records of a fetch that started at `start` with the previous fetch
+// and the writes of this instance in between, and returns the changes that can't be explained by either.
+// Writes that happened after `start` may not be reflected in the fetch yet and are kept for the next one.
+func (d *driftDetector) observe(observed map[string]string, start time.Time) []recordDrift {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	known, writes := d.known, d.writes
+	d.known = observed
+	d.writes = nil
+	for _, w := range writes {
+		if !w.at.Before(start) {
+			d.writes = append(d.writes, w)
+		}
+	}
+	if known == nil {
+		return nil
+	}
+
+	// expected holds every state a record may be in, latest holds the one it should be in
+	expected := map[string]map[string]bool{}
+	latest := map[string]string{}
+	for k, state := range known {
+		expected[k] = map[string]bool{state: true}
+		latest[k] = state
+	}
+	for _, w := range writes {
+		if expected[w.key] == nil {
+			expected[w.key] = map[string]bool{"": true}
+		}
+		expected[w.key][w.state] = true
+		latest[w.key] = w.state
+	}
+	for k := range observed {
+		if expected[k] == nil {
+			expected[k] = map[string]bool{"": true}
+		}
+	}
+
+	drifts := []recordDrift{}
+	for k, states := range expected {
+		state := observed[k]
+		if states[state] {
+			continue
+		}
+		kind := driftModified
+		if latest[k] == "" {
+			kind = driftAdded
+		} else if state == "" {
+			kind = driftRemoved
+		}
+		drifts = append(drifts, recordDrift{key: k, kind: kind})
+	}
+	sort.Slice(drifts, func(i, j int) bool { return drifts[i].key < drifts[j].key })
+	return drifts
+}
+
+// managedRecords returns the state of the records of a zone managed by this instance, keyed by `recordKey`
+func (n *ns1) managedRecords(ns1Zone *dns.Zone) map[string]string {
+	owners := zoneOwners(ns1Zone)
+	records := map[string]string{}
+	for _, record := range ns1Zone.Records {
+		managed := false
+		switch {
+		case isOwnerRecord(record):
+			prefix, ok := parseOwnerTXTAnswer(record.ShortAns)
+			name := strings.TrimSuffix(strings.TrimPrefix(record.Domain, ownerRecordLabel+n.ns1Prefix), "."+n.serviceZone.name)
+			managed = n.ownershipRegistry && ok && prefix == n.ns1Prefix && n.selection.selectsRecords(name)
+		case !n.inScope(record.Domain, owners):
+		case record.Type == "A" || record.Type == "SRV" || record.Type == "CNAME" || record.Type == "HTTPS":
+			managed = true
+		case record.Type == "AAAA":
+			managed = n.addressFamily.v6()
+		case record.Type == "TXT":
+			_, managed = n.portsTXTAnswer(record)
+		}
+		if managed {
+			records[recordKey(record.Domain, record.Type)] = recordState(record.ShortAns, record.TTL)
+		}
+	}
+	return records
+}
+
+// detectDrift logs and counts changes of managed records in a zone fetched at `start`
+// that didn't originate from this instance, and reports whether there were any
+func (n *ns1) detectDrift(ns1Zone *dns.Zone, start time.Time) bool {
+	drifts := n.drift.observe(n.managedRecords(ns1Zone), start)
+	for _, d := range drifts {
+		n.log.Warn("zone drift detected, managed record was changed outside of consul-ns1",
+			"zone", n.serviceZone.name, "record", d.key, "change", string(d.kind))
+		metrics.IncrCounterWithLabels([]string{"ns1", "drift"}, 1,
+			[]metrics.Label{{Name: "type", Value: string(d.kind)}})
+	}
+	return len(drifts) > 0
+}
@@ -0,0 +1,129 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestRecordState(t *testing.T) {
+	assert.Equal(t, "1.1.1.1,2.2.2.2 ttl=60", recordState([]string{"2.2.2.2", "1.1.1.1"}, 60))
+	assert.Equal(t, "ports=1 ttl=60", recordState([]string{"\"ports=1\""}, 60))
+
+	rec := dns.NewRecord("test.zone", "s1.test.zone", "SRV")
+	rec.TTL = 60
+	rec.AddAnswer(dns.NewAnswer([]string{"1", "1", "80", "1.1.1.1"}))
+	assert.Equal(t, recordState([]string{"1 1 80 1.1.1.1"}, 60), writtenState(rec))
+}
+
+func TestDriftDetectorObserve(t *testing.T) {
+	start := time.Now()
+	type variant struct {
+		known    map[string]string
+		writes   []recordWrite
+		observed map[string]string
+		expected []recordDrift
+	}
+	table := map[string]variant{
+		"first fetch": {
+			observed: map[string]string{"s1 A": "1.1.1.1"},
+			expected: nil,
+		},
+		"unchanged": {
+			known:    map[string]string{"s1 A": "1.1.1.1"},
+			observed: map[string]string{"s1 A": "1.1.1.1"},
+			expected: []recordDrift{},
+		},
+		"written by syncer": {
+			known: map[string]string{"s1 A": "1.1.1.1", "s2 A": "2.2.2.2"},
+			writes: []recordWrite{
+				{key: "s1 A", state: "3.3.3.3", at: start.Add(-time.Second)},
+				{key: "s2 A", state: "", at: start.Add(-time.Second)},
+				{key: "s3 A", state: "4.4.4.4", at: start.Add(-time.Second)},
+			},
+			observed: map[string]string{"s1 A": "3.3.3.3", "s3 A": "4.4.4.4"},
+			expected: []recordDrift{},
+		},
+		"write not reflected yet": {
+			known:    map[string]string{"s1 A": "1.1.1.1"},
+			writes:   []recordWrite{{key: "s1 A", state: "3.3.3.3", at: start.Add(time.Second)}},
+			observed: map[string]string{"s1 A": "1.1.1.1"},
+			expected: []recordDrift{},
+		},
+		"manual edits": {
+			known:    map[string]string{"s1 A": "1.1.1.1", "s2 A": "2.2.2.2"},
+			writes:   []recordWrite{{key: "s4 A", state: "4.4.4.4", at: start.Add(-time.Second)}},
+			observed: map[string]string{"s1 A": "9.9.9.9", "s3 A": "3.3.3.3", "s4 A": "5.5.5.5"},
+			expected: []recordDrift{
+				{key: "s1 A", kind: driftModified},
+				{key: "s2 A", kind: driftRemoved},
+				{key: "s3 A", kind: driftAdded},
+				{key: "s4 A", kind: driftModified},
+			},
+		},
+	}
+	for name, v := range table {
+		d := driftDetector{known: v.known, writes: v.writes}
+		assert.Equal(t, v.expected, d.observe(v.observed, start), fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.observed, d.known, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestDriftDetectorKeepsLateWrites(t *testing.T) {
+	start := time.Now()
+	late := recordWrite{key: "s1 A", state: "3.3.3.3", at: start.Add(time.Second)}
+	d := driftDetector{
+		known:  map[string]string{},
+		writes: []recordWrite{{key: "s2 A", state: "2.2.2.2", at: start.Add(-time.Second)}, late},
+	}
+	d.observe(map[string]string{"s2 A": "2.2.2.2"}, start)
+	assert.Equal(t, []recordWrite{late}, d.writes)
+}
+
+func TestNS1PollReportsDrift(t *testing.T) {
+	n := testClient(nil)
+	zone := &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+		{Domain: "s1.test.zone", Type: "A", ShortAns: []string{"1.1.1.1"}},
+	}}
+	n.client = &ns1APIClient{Zones: &staticZoneService{zone: zone}}
+
+	drifted, err := n.poll()
+	assert.NoError(t, err)
+	assert.False(t, drifted, "the first poll has nothing to compare with")
+	drifted, err = n.poll()
+	assert.NoError(t, err)
+	assert.False(t, drifted)
+
+	zone.Records[0].ShortAns = []string{"2.2.2.2"}
+	drifted, err = n.poll()
+	assert.NoError(t, err)
+	assert.True(t, drifted)
+}
+
+func TestManagedRecords(t *testing.T) {
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "a-s1.test.zone", ShortAns: []string{"1.1.1.1"}, Type: "A", TTL: 1},
+			{Domain: "a-s1.test.zone", ShortAns: []string{"2001:db8::1"}, Type: "AAAA", TTL: 1},
+			{Domain: "a-s1.test.zone", ShortAns: []string{"\"ports=80\""}, Type: "TXT", TTL: 1},
+			{Domain: "a-s1.test.zone", ShortAns: []string{"v=spf1 -all"}, Type: "MX", TTL: 1},
+			{Domain: "_consul-ns1.a-s1.test.zone", ShortAns: []string{ownerTXTAnswer("a-")}, Type: "TXT", TTL: 1},
+			{Domain: "b-s2.test.zone", ShortAns: []string{"2.2.2.2"}, Type: "A", TTL: 1},
+		},
+	}
+	n := ns1{log: hclog.NewNullLogger(), ns1Prefix: "a-", addressFamily: ipv4Family}
+	assert.Equal(t, map[string]string{"a-s1.test.zone A": "1.1.1.1 ttl=1"}, n.managedRecords(z))
+
+	n.addressFamily, n.portHints, n.ownershipRegistry = dualFamily, true, true
+	assert.Equal(t, map[string]string{
+		"a-s1.test.zone A":               "1.1.1.1 ttl=1",
+		"a-s1.test.zone AAAA":            "2001:db8::1 ttl=1",
+		"a-s1.test.zone TXT":             "ports=80 ttl=1",
+		"_consul-ns1.a-s1.test.zone TXT": "heritage=consul-ns1,prefix=a- ttl=1",
+	}, n.managedRecords(z))
+}
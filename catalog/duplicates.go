@@ -0,0 +1,44 @@
+package catalog
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// duplicateEndpoints returns the instances of a service registered with the same address and port, e.g. behind
+// NAT or during rolling deploys, keyed by endpoint. Their answers are only published once, as answers are
+// de-duplicated, but they usually indicate a stale registration.
+func duplicateEndpoints(nodes map[string]node) map[string][]string {
+	instances := map[string][]string{}
+	for k, n := range nodes {
+		endpoint := net.JoinHostPort(n.address, fmt.Sprintf("%d", n.port))
+		instances[endpoint] = append(instances[endpoint], k)
+	}
+	duplicates := map[string][]string{}
+	for endpoint, keys := range instances {
+		if len(keys) > 1 {
+			sort.Strings(keys)
+			duplicates[endpoint] = keys
+		}
+	}
+	return duplicates
+}
+
+// reportDuplicateEndpoints logs and counts the instances of a service sharing an endpoint
+func (c *consul) reportDuplicateEndpoints(name string, nodes map[string]node) {
+	duplicates := duplicateEndpoints(nodes)
+	endpoints := make([]string, 0, len(duplicates))
+	for endpoint := range duplicates {
+		endpoints = append(endpoints, endpoint)
+	}
+	sort.Strings(endpoints)
+	for _, endpoint := range endpoints {
+		c.log.Warn("instances of service share an endpoint, publishing it once", "service", name,
+			"endpoint", endpoint, "instances", strings.Join(duplicates[endpoint], ","))
+		metrics.IncrCounter([]string{"consul", "duplicate_endpoint"}, 1)
+	}
+}
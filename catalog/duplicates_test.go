@@ -0,0 +1,33 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDuplicateEndpoints(t *testing.T) {
+	srv := func(address string, port int) map[int]srvAnswer {
+		return map[int]srvAnswer{port: {priority: 1, weight: 1, port: int64(port), address: address}}
+	}
+	nodes := map[string]node{
+		"n1/web-1": {address: "10.0.0.1", port: 80, aRecAnswer: "10.0.0.1", srvRecAnswers: srv("10.0.0.1", 80)},
+		"n2/web-1": {address: "10.0.0.1", port: 80, aRecAnswer: "10.0.0.1", srvRecAnswers: srv("10.0.0.1", 80)},
+		"n3/web-2": {address: "10.0.0.1", port: 8080, aRecAnswer: "10.0.0.1", srvRecAnswers: srv("10.0.0.1", 8080)},
+		"n4/web-3": {address: "2001:db8::1", port: 80, aaaaRecAnswer: "2001:db8::1", srvRecAnswers: srv("2001:db8::1", 80)},
+		"n5/web-3": {address: "2001:db8::1", port: 80, aaaaRecAnswer: "2001:db8::1", srvRecAnswers: srv("2001:db8::1", 80)},
+	}
+	assert.Equal(t, map[string][]string{
+		"10.0.0.1:80":      {"n1/web-1", "n2/web-1"},
+		"[2001:db8::1]:80": {"n4/web-3", "n5/web-3"},
+	}, duplicateEndpoints(nodes))
+
+	// duplicates are published once
+	assert.Equal(t, []string{"10.0.0.1"}, aAnswers(nodes))
+	assert.Equal(t, []string{"2001:db8::1"}, aaaaAnswers(nodes))
+	assert.Len(t, srvAnswers(nodes), 3)
+
+	delete(nodes, "n2/web-1")
+	delete(nodes, "n5/web-3")
+	assert.Empty(t, duplicateEndpoints(nodes))
+}
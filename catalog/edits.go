@@ -0,0 +1,173 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// editPolicy decides what happens when a record about to be updated was edited outside of consul-ns1
+// since this instance last wrote it, e.g. in the NS1 portal
+type editPolicy string
+
+const (
+	// overwriteEdits replaces the edited answers with the desired ones
+	overwriteEdits editPolicy = "overwrite"
+	// skipEdits leaves the edited record alone until the edit is reverted
+	skipEdits editPolicy = "skip"
+	// mergeEdits keeps the answers added by the edit next to the desired ones
+	mergeEdits editPolicy = "merge"
+)
+
+// parseEditPolicy validates an edit policy, an empty policy defaults to overwriteEdits
+func parseEditPolicy(s string) (editPolicy, error) {
+	switch editPolicy(s) {
+	case "", overwriteEdits:
+		return overwriteEdits, nil
+	case skipEdits, mergeEdits:
+		return editPolicy(s), nil
+	}
+	return "", fmt.Errorf("unknown edit policy %q, must be one of %q, %q or %q", s, overwriteEdits, skipEdits, mergeEdits)
+}
+
+// editGuard compares the answers of a record fetched before an update with the answers this instance last
+// wrote to it, to apply the edit policy to records edited in between. Records this instance didn't write
+// since it started, or according to the stored sync state, are never considered edited. A nil editGuard overwrites every record.
+type editGuard struct {
+	log    hclog.Logger
+	policy editPolicy
+
+	lock sync.Mutex
+	// synced holds the answers of each record as last written by this instance, keyed by `recordKey`
+	synced map[string][]string
+	// current holds the answers of each record as fetched before updating it, keyed by `recordKey`
+	current map[string][]*dns.Answer
+	// reported holds the answers of each edited record when the edit was reported, so it's only reported once
+	reported map[string]string
+}
+
+// answerStrings returns the answers of a record as strings, e.g. "1 1 8080 10.0.0.1"
+func answerStrings(answers []*dns.Answer) []string {
+	result := make([]string, len(answers))
+	for i, a := range answers {
+		result[i] = strings.Join(a.Rdata, " ")
+	}
+	return result
+}
+
+// fetched records the answers of a record fetched from NS1 before updating it
+func (g *editGuard) fetched(rec *dns.Record) {
+	if g == nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.current == nil {
+		g.current = map[string][]*dns.Answer{}
+	}
+	g.current[recordKey(rec.Domain, rec.Type)] = rec.Answers
+}
+
+// wrote records the answers of a record written by this instance after a successful write, excluding merged
+// answers, nil answers mean the record was deleted
+func (g *editGuard) wrote(domain, recType string, answers []string) {
+	if g == nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.synced == nil {
+		g.synced = map[string][]string{}
+	}
+	key := recordKey(domain, recType)
+	if answers == nil {
+		delete(g.synced, key)
+		return
+	}
+	g.synced[key] = answers
+}
+
+// resolve applies the edit policy to a record about to be written with its desired answers, and reports
+// whether it should be written. With mergeEdits the answers added by an edit are appended to the record.
+func (g *editGuard) resolve(rec *dns.Record) bool {
+	if g == nil {
+		return true
+	}
+	key := recordKey(rec.Domain, rec.Type)
+	g.lock.Lock()
+	current, fetched := g.current[key]
+	synced, known := g.synced[key]
+	delete(g.current, key)
+	g.lock.Unlock()
+	if !fetched || !known {
+		return true
+	}
+	currentAnswers, desired := answerStrings(current), answerStrings(rec.Answers)
+	if sameAnswers(currentAnswers, synced) || sameAnswers(currentAnswers, desired) {
+		return true
+	}
+
+	if state := recordState(currentAnswers, 0); g.report(key, state) {
+		g.log.Warn("record was edited outside of consul-ns1 since it was last synced", "record", key,
+			"policy", string(g.policy), "answers", currentAnswers, "synced", synced)
+		metrics.IncrCounterWithLabels([]string{"ns1", "edit_conflict"}, 1,
+			[]metrics.Label{{Name: "policy", Value: string(g.policy)}})
+	}
+	switch g.policy {
+	case skipEdits:
+		return false
+	case mergeEdits:
+		known := map[string]bool{}
+		for _, a := range synced {
+			known[a] = true
+		}
+		for _, a := range desired {
+			known[a] = true
+		}
+		for i, a := range currentAnswers {
+			if !known[a] {
+				rec.AddAnswer(current[i])
+				known[a] = true
+			}
+		}
+		// the merged record may be what's already in NS1
+		return !sameAnswers(currentAnswers, answerStrings(rec.Answers))
+	}
+	return true
+}
+
+// report records the state of an edited record and reports whether it wasn't reported yet
+func (g *editGuard) report(key, state string) bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.reported == nil {
+		g.reported = map[string]string{}
+	}
+	if g.reported[key] == state {
+		return false
+	}
+	g.reported[key] = state
+	return true
+}
+
+// sameAnswers determines if two lists of answers are equal regardless of order and duplicates
+func sameAnswers(a, b []string) bool {
+	return recordState(dedupe(a), 0) == recordState(dedupe(b), 0)
+}
+
+// dedupe returns the distinct strings of a list in their original order
+func dedupe(list []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, s := range list {
+		if !seen[s] {
+			seen[s] = true
+			result = append(result, s)
+		}
+	}
+	return result
+}
@@ -0,0 +1,82 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestParseEditPolicy(t *testing.T) {
+	p, err := parseEditPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, overwriteEdits, p)
+	p, err = parseEditPolicy("merge")
+	assert.NoError(t, err)
+	assert.Equal(t, mergeEdits, p)
+	_, err = parseEditPolicy("ignore")
+	assert.Error(t, err)
+}
+
+func TestEditGuardResolve(t *testing.T) {
+	table := map[string]struct {
+		policy   editPolicy
+		current  []string
+		synced   []string
+		write    bool
+		expected []string
+	}{
+		"never written":  {skipEdits, []string{"1.1.1.1", "9.9.9.9"}, nil, true, []string{"2.2.2.2"}},
+		"unchanged":      {skipEdits, []string{"1.1.1.1"}, []string{"1.1.1.1"}, true, []string{"2.2.2.2"}},
+		"already synced": {skipEdits, []string{"2.2.2.2"}, []string{"1.1.1.1"}, true, []string{"2.2.2.2"}},
+		"overwrite":      {overwriteEdits, []string{"1.1.1.1", "9.9.9.9"}, []string{"1.1.1.1"}, true, []string{"2.2.2.2"}},
+		"skip":           {skipEdits, []string{"1.1.1.1", "9.9.9.9"}, []string{"1.1.1.1"}, false, []string{"2.2.2.2"}},
+		"merge":          {mergeEdits, []string{"1.1.1.1", "9.9.9.9"}, []string{"1.1.1.1"}, true, []string{"2.2.2.2", "9.9.9.9"}},
+		"merge removal":  {mergeEdits, []string{}, []string{"1.1.1.1"}, true, []string{"2.2.2.2"}},
+		"merged":         {mergeEdits, []string{"2.2.2.2", "9.9.9.9"}, []string{"1.1.1.1"}, false, []string{"2.2.2.2", "9.9.9.9"}},
+	}
+	for name, v := range table {
+		g := &editGuard{log: hclog.NewNullLogger(), policy: v.policy}
+		current := dns.NewRecord("test.zone", "s1.test.zone", "A")
+		for _, a := range v.current {
+			current.AddAnswer(dns.NewAv4Answer(a))
+		}
+		g.fetched(current)
+		if v.synced != nil {
+			g.wrote("s1.test.zone", "A", v.synced)
+		}
+		rec := dns.NewRecord("test.zone", "s1.test.zone", "A")
+		rec.AddAnswer(dns.NewAv4Answer("2.2.2.2"))
+		assert.Equal(t, v.write, g.resolve(rec), fmt.Sprintf("Test case: %s", name))
+		assert.ElementsMatch(t, v.expected, answerStrings(rec.Answers), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCreate_EditedRecord(t *testing.T) {
+	n := testClient(nil)
+	n.edits = &editGuard{log: hclog.NewNullLogger(), policy: skipEdits}
+	// the stored record answers 1.1.1.1
+	records := &storedRecordService{mockRecordService{mux: &sync.Mutex{}}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	input := map[string]service{
+		"s1": {
+			nodes:     map[string]node{"h1": {aRecAnswer: "2.2.2.2"}},
+			ns1IDs:    recordIDs{aRecID: "r1"},
+			unchanged: recordTypes{srvRec: true},
+		},
+	}
+
+	// the record was last synced with 3.3.3.3 and edited since
+	n.edits.wrote("s1.test.zone", "A", []string{"3.3.3.3"})
+	assert.Equal(t, int32(0), n.create(input))
+	assert.Empty(t, records.records)
+
+	// once the edit is reverted, the record is updated again
+	n.edits.wrote("s1.test.zone", "A", []string{"1.1.1.1"})
+	assert.Equal(t, int32(1), n.create(input))
+	assert.Len(t, records.records, 1)
+	assert.Equal(t, []string{"2.2.2.2"}, answerStrings(records.records[0].Answers))
+}
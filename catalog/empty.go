@@ -0,0 +1,81 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// emptyPolicy decides what happens when an update would leave a record that has answers without any, which
+// usually means the health or the catalog of the service is wrong rather than it scaled to zero
+type emptyPolicy string
+
+const (
+	// warnEmpty writes the record without answers and logs a warning
+	warnEmpty emptyPolicy = "warn"
+	// blockEmpty leaves the record with its answers until the service has instances again
+	blockEmpty emptyPolicy = "block"
+)
+
+// parseEmptyPolicy validates an empty answer policy, an empty policy defaults to warnEmpty
+func parseEmptyPolicy(s string) (emptyPolicy, error) {
+	switch emptyPolicy(s) {
+	case "", warnEmpty:
+		return warnEmpty, nil
+	case blockEmpty:
+		return blockEmpty, nil
+	}
+	return "", fmt.Errorf("unknown empty answer policy %q, must be one of %q or %q", s, warnEmpty, blockEmpty)
+}
+
+// emptyGuard compares the number of answers of a record fetched before an update with the number of answers it
+// is about to be written with, to apply the empty answer policy to updates removing all its answers. A nil
+// emptyGuard writes every record.
+type emptyGuard struct {
+	log    hclog.Logger
+	policy emptyPolicy
+
+	lock sync.Mutex
+	// answers holds the number of answers of each record as fetched before updating it, keyed by `recordKey`
+	answers map[string]int
+}
+
+// fetched records the number of answers of a record fetched from NS1 before updating it
+func (g *emptyGuard) fetched(rec *dns.Record) {
+	if g == nil {
+		return
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.answers == nil {
+		g.answers = map[string]int{}
+	}
+	g.answers[recordKey(rec.Domain, rec.Type)] = len(rec.Answers)
+}
+
+// allow reports whether a record may be written with its answers
+func (g *emptyGuard) allow(rec *dns.Record) bool {
+	if g == nil {
+		return true
+	}
+	key := recordKey(rec.Domain, rec.Type)
+	g.lock.Lock()
+	current := g.answers[key]
+	delete(g.answers, key)
+	g.lock.Unlock()
+	if len(rec.Answers) > 0 || current == 0 {
+		return true
+	}
+	metrics.IncrCounterWithLabels([]string{"ns1", "empty_publish"}, 1, []metrics.Label{{Name: "policy", Value: string(g.policy)}})
+	if g.policy == blockEmpty {
+		g.log.Warn("update would remove all answers of record, blocked: check the health and the catalog of the service",
+			"domain", rec.Domain, "type", rec.Type, "answers", fmt.Sprintf("%d", current))
+		return false
+	}
+	g.log.Warn("update removes all answers of record: check the health and the catalog of the service",
+		"domain", rec.Domain, "type", rec.Type, "answers", fmt.Sprintf("%d", current))
+	return true
+}
@@ -0,0 +1,48 @@
+package catalog
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEmptyPolicy(t *testing.T) {
+	p, err := parseEmptyPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, warnEmpty, p)
+	p, err = parseEmptyPolicy("block")
+	assert.NoError(t, err)
+	assert.Equal(t, blockEmpty, p)
+	_, err = parseEmptyPolicy("ignore")
+	assert.Error(t, err)
+}
+
+func TestCreate_EmptyRecord(t *testing.T) {
+	for _, policy := range []emptyPolicy{warnEmpty, blockEmpty} {
+		n := testClient(nil)
+		n.empty = &emptyGuard{log: hclog.NewNullLogger(), policy: policy}
+		// the stored record answers 1.1.1.1
+		records := &storedRecordService{mockRecordService{mux: &sync.Mutex{}}}
+		n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+		input := map[string]service{
+			"s1": {ns1IDs: recordIDs{aRecID: "r1"}, unchanged: recordTypes{srvRec: true}},
+		}
+
+		if policy == blockEmpty {
+			assert.Equal(t, int32(0), n.create(input), string(policy))
+			assert.Empty(t, records.records, string(policy))
+		} else {
+			assert.Equal(t, int32(1), n.create(input), string(policy))
+			assert.Len(t, records.records, 1, string(policy))
+			assert.Empty(t, records.records[0].Answers, string(policy))
+		}
+
+		// records with answers are written whatever the policy
+		input["s1"] = service{nodes: map[string]node{"h1": {aRecAnswer: "2.2.2.2"}}, ns1IDs: recordIDs{aRecID: "r1"},
+			unchanged: recordTypes{srvRec: true}}
+		records.records = nil
+		assert.Equal(t, int32(1), n.create(input), string(policy))
+	}
+}
@@ -0,0 +1,127 @@
+package catalog
+
+import (
+	"errors"
+	"net/http"
+
+	metrics "github.com/armon/go-metrics"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+)
+
+// Classes of the errors returned by this package, see `Error`
+var (
+	// ErrInvalidConfig is returned when the configuration is rejected before syncing
+	ErrInvalidConfig = errors.New("invalid configuration")
+	// ErrConsulUnavailable is returned when Consul can't be queried
+	ErrConsulUnavailable = errors.New("consul unavailable")
+	// ErrNS1Unavailable is returned when NS1 can't be reached or fails to respond
+	ErrNS1Unavailable = errors.New("NS1 unavailable")
+	// ErrNS1RateLimited is returned when NS1 rejects a request because of its rate limits
+	ErrNS1RateLimited = errors.New("NS1 rate limit exceeded")
+	// ErrZoneMissing is returned when the zone to sync to doesn't exist in NS1
+	ErrZoneMissing = errors.New("zone not found in NS1")
+	// ErrRecordConflict is returned when records to write already exist in NS1 without an ownership record
+	ErrRecordConflict = errors.New("record conflict")
+	// ErrLeadershipLost is returned when the syncer stops because another instance took over
+	ErrLeadershipLost = errors.New("leadership lost")
+)
+
+// errorClasses are the classes of errors along with their metric label and exit code
+var errorClasses = []struct {
+	class    error
+	label    string
+	exitCode int
+}{
+	{ErrInvalidConfig, "invalid_config", 2},
+	{ErrConsulUnavailable, "consul_unavailable", 3},
+	{ErrNS1Unavailable, "ns1_unavailable", 4},
+	{ErrNS1RateLimited, "ns1_rate_limited", 5},
+	{ErrZoneMissing, "zone_missing", 6},
+	{ErrRecordConflict, "record_conflict", 7},
+	{ErrLeadershipLost, "leadership_lost", 8},
+}
+
+// Error is an error of one of the classes above, e.g. ErrConsulUnavailable, wrapping its cause.
+// Use `ErrorClass` to branch on the class of an error, or `errors.Is` with Go 1.13 and later.
+type Error struct {
+	Class error
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Class.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause of the error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the error is of class `target`
+func (e *Error) Is(target error) bool {
+	return e.Class == target
+}
+
+// wrapError wraps an error in an error of a class, errors that already have a class keep it
+func wrapError(class, err error) error {
+	if err == nil || ErrorClass(err) != nil {
+		return err
+	}
+	return &Error{Class: class, Err: err}
+}
+
+// ErrorClass returns the class of an error returned by this package, or nil if it has none
+func ErrorClass(err error) error {
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e.Class
+		}
+		u, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			return nil
+		}
+		err = u.Unwrap()
+	}
+	return nil
+}
+
+// ExitCode maps an error to the exit code of the process: 0 without error, 1 for errors without a class
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	class := ErrorClass(err)
+	for _, c := range errorClasses {
+		if c.class == class {
+			return c.exitCode
+		}
+	}
+	return 1
+}
+
+// countError increments the error counter labeled with the class of an error
+func countError(err error) {
+	label := "other"
+	class := ErrorClass(err)
+	for _, c := range errorClasses {
+		if c.class == class {
+			label = c.label
+		}
+	}
+	metrics.IncrCounterWithLabels([]string{"errors"}, 1, []metrics.Label{{Name: "class", Value: label}})
+}
+
+// ns1Error classifies an error returned by the NS1 API given its response, if any
+func ns1Error(resp *http.Response, err error) error {
+	switch {
+	case err == nil:
+		return nil
+	case err == ns1api.ErrZoneMissing:
+		return wrapError(ErrZoneMissing, err)
+	case resp == nil || resp.StatusCode >= 500:
+		return wrapError(ErrNS1Unavailable, err)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return wrapError(ErrNS1RateLimited, err)
+	}
+	return err
+}
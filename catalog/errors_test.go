@@ -0,0 +1,57 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+)
+
+func TestErrorClass(t *testing.T) {
+	cause := errors.New("connection refused")
+	table := map[string]struct {
+		err      error
+		class    error
+		exitCode int
+	}{
+		"nil":         {nil, nil, 0},
+		"unclassed":   {cause, nil, 1},
+		"classed":     {wrapError(ErrConsulUnavailable, cause), ErrConsulUnavailable, 3},
+		"rewrapped":   {wrapError(ErrNS1Unavailable, wrapError(ErrConsulUnavailable, cause)), ErrConsulUnavailable, 3},
+		"retry after": {&retryAfterError{err: wrapError(ErrNS1RateLimited, cause), wait: time.Second}, ErrNS1RateLimited, 5},
+		"conflict":    {wrapError(ErrRecordConflict, cause), ErrRecordConflict, 7},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.class, ErrorClass(v.err), fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.exitCode, ExitCode(v.err), fmt.Sprintf("Test case: %s", name))
+	}
+
+	err := wrapError(ErrConsulUnavailable, cause)
+	assert.EqualError(t, err, "consul unavailable: connection refused")
+	assert.Equal(t, cause, err.(*Error).Unwrap())
+	assert.True(t, err.(*Error).Is(ErrConsulUnavailable))
+	assert.False(t, err.(*Error).Is(ErrNS1Unavailable))
+}
+
+func TestNS1Error(t *testing.T) {
+	cause := errors.New("request failed")
+	table := map[string]struct {
+		resp  *http.Response
+		err   error
+		class error
+	}{
+		"no error":     {nil, nil, nil},
+		"no response":  {nil, cause, ErrNS1Unavailable},
+		"server error": {&http.Response{StatusCode: http.StatusServiceUnavailable}, cause, ErrNS1Unavailable},
+		"rate limited": {&http.Response{StatusCode: http.StatusTooManyRequests}, cause, ErrNS1RateLimited},
+		"bad request":  {&http.Response{StatusCode: http.StatusBadRequest}, cause, nil},
+		"zone missing": {&http.Response{StatusCode: http.StatusNotFound}, ns1api.ErrZoneMissing, ErrZoneMissing},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.class, ErrorClass(ns1Error(v.resp, v.err)), fmt.Sprintf("Test case: %s", name))
+	}
+}
@@ -0,0 +1,197 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nsone/consul-ns1/diff"
+)
+
+// types of events
+const (
+	// eventPublish is an answer written to NS1
+	eventPublish = "publish"
+	// eventUnpublish is an answer deleted from NS1
+	eventUnpublish = "unpublish"
+	// eventUp is an instance that started passing its checks
+	eventUp = "up"
+	// eventDown is an instance that started failing its checks
+	eventDown = "down"
+)
+
+// eventBuffer is the number of events buffered for a subscriber, events are dropped for subscribers falling behind
+const eventBuffer = 256
+
+// Event is a change of the DNS state of a service as applied to NS1
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`
+	Service string    `json:"service"`
+	// Instance identifies the instance of up and down events as "<node>/<service ID>"
+	Instance string `json:"instance,omitempty"`
+	// Answer is the answer of publish and unpublish events prefixed by its type, e.g. "A 10.0.0.1"
+	Answer string `json:"answer,omitempty"`
+}
+
+// eventStream delivers the events of each sync cycle to the subscribers of the admin API.
+// A nil eventStream delivers nothing.
+type eventStream struct {
+	clock       *clock
+	lock        sync.Mutex
+	subscribers map[chan Event]struct{}
+	// healths holds the last health of each instance, keyed by service and `instanceKey`
+	healths map[string]health
+}
+
+// subscribe returns a channel receiving events and a function to unsubscribe
+func (e *eventStream) subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+	e.lock.Lock()
+	if e.subscribers == nil {
+		e.subscribers = map[chan Event]struct{}{}
+	}
+	e.subscribers[ch] = struct{}{}
+	e.lock.Unlock()
+	return ch, func() {
+		e.lock.Lock()
+		delete(e.subscribers, ch)
+		e.lock.Unlock()
+	}
+}
+
+// applied delivers the answers published and unpublished by a sync cycle that wrote `upsert` and deleted `remove`,
+// comparing the desired state with the state of NS1 before the cycle, and the instances that went up or down
+func (e *eventStream) applied(upsert, remove, desired, actual map[string]service) {
+	if e == nil {
+		return
+	}
+	now := e.clock.Now()
+	events := []Event{}
+	for _, k := range sortedNames(upsert) {
+		de, ae := compareEntries(desired[k], actual[k])
+		events = append(events, answerEvents(now, k, de, ae)...)
+	}
+	for _, k := range sortedNames(remove) {
+		events = append(events, answerEvents(now, k, diff.Entry{}, actual[k].entry())...)
+	}
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	healths := map[string]health{}
+	for _, k := range sortedNames(desired) {
+		keys := make([]string, 0, len(desired[k].healths))
+		for instance := range desired[k].healths {
+			keys = append(keys, instance)
+		}
+		sort.Strings(keys)
+		for _, instance := range keys {
+			h := desired[k].healths[instance]
+			healths[k+" "+instance] = h
+			last, ok := e.healths[k+" "+instance]
+			switch {
+			case !ok || last == h:
+			case h == passing:
+				events = append(events, Event{Time: now, Type: eventUp, Service: k, Instance: instance})
+			case h == critical:
+				events = append(events, Event{Time: now, Type: eventDown, Service: k, Instance: instance})
+			}
+		}
+	}
+	e.healths = healths
+
+	for _, ev := range events {
+		for ch := range e.subscribers {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+	}
+}
+
+// answerEvents returns the answers of the address and CNAME records of a service added and removed
+// between the actual and desired records
+func answerEvents(now time.Time, service string, desired, actual diff.Entry) []Event {
+	events := []Event{}
+	for _, f := range []diff.Family{diff.A, diff.AAAA, diff.SRV, diff.CNAME} {
+		d, a := answerSet(desired[f]), answerSet(actual[f])
+		for _, ans := range sortedKeys(d) {
+			if !a[ans] {
+				events = append(events, Event{Time: now, Type: eventPublish, Service: service, Answer: string(f) + " " + ans})
+			}
+		}
+		for _, ans := range sortedKeys(a) {
+			if !d[ans] {
+				events = append(events, Event{Time: now, Type: eventUnpublish, Service: service, Answer: string(f) + " " + ans})
+			}
+		}
+	}
+	return events
+}
+
+// answerSet returns the non-empty answers of a record
+func answerSet(r diff.Record) map[string]bool {
+	set := map[string]bool{}
+	for _, a := range r.Answers {
+		if a != "" {
+			set[a] = true
+		}
+	}
+	return set
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedNames returns the names of a map of services in order
+func sortedNames(services map[string]service) []string {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// handleEvents streams events as server-sent events until the client disconnects
+func (e *eventStream) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	events, unsubscribe := e.subscribe()
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			b, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, b)
+			flusher.Flush()
+		}
+	}
+}
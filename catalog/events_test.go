@@ -0,0 +1,82 @@
+package catalog
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventStreamApplied(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	e := &eventStream{clock: &clock{now: func() time.Time { return now }}}
+	events, unsubscribe := e.subscribe()
+	defer unsubscribe()
+
+	desired := map[string]service{
+		"web": {
+			nodes:   map[string]node{"n1/web": {aRecAnswer: "1.1.1.1"}, "n2/web": {aRecAnswer: "2.2.2.2"}},
+			healths: map[string]health{"n1/web": passing, "n2/web": critical},
+		},
+	}
+	actual := map[string]service{
+		"web": {nodes: map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}, "3.3.3.3": {aRecAnswer: "3.3.3.3"}}},
+		"old": {cnameRecAnswer: "ingress.example.com"},
+	}
+	e.applied(map[string]service{"web": {}}, map[string]service{"old": {}}, desired, actual)
+	assert.Equal(t, []Event{
+		{Time: now, Type: eventPublish, Service: "web", Answer: "A 2.2.2.2"},
+		{Time: now, Type: eventUnpublish, Service: "web", Answer: "A 3.3.3.3"},
+		{Time: now, Type: eventUnpublish, Service: "old", Answer: "CNAME ingress.example.com"},
+	}, drain(events), "health is only reported once it changes")
+
+	desired["web"] = service{nodes: desired["web"].nodes, healths: map[string]health{"n1/web": critical, "n2/web": passing}}
+	e.applied(map[string]service{}, map[string]service{}, desired, actual)
+	assert.Equal(t, []Event{
+		{Time: now, Type: eventDown, Service: "web", Instance: "n1/web"},
+		{Time: now, Type: eventUp, Service: "web", Instance: "n2/web"},
+	}, drain(events))
+
+	var disabled *eventStream
+	disabled.applied(desired, desired, desired, actual)
+}
+
+// drain returns the events buffered in a channel
+func drain(events <-chan Event) []Event {
+	drained := []Event{}
+	for {
+		select {
+		case ev := <-events:
+			drained = append(drained, ev)
+		default:
+			return drained
+		}
+	}
+}
+
+func TestHandleEvents(t *testing.T) {
+	e := &eventStream{}
+	srv := httptest.NewServer(adminHandler(nil, e, nil, nil))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/v1/events")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	e.applied(map[string]service{"web": {}}, map[string]service{},
+		map[string]service{"web": {nodes: map[string]node{"n1/web": {aRecAnswer: "1.1.1.1"}}}}, map[string]service{})
+	r := bufio.NewReader(resp.Body)
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "event: publish\n", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(line, "data: {"))
+	assert.Contains(t, line, `"answer":"A 1.1.1.1"`)
+}
@@ -0,0 +1,26 @@
+package catalog
+
+import (
+	"strconv"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// publishMetaKey is the service meta key excluding an instance from DNS when set to "false", e.g. a debug
+// instance, while the other instances of the service are published
+const publishMetaKey = "ns1-publish"
+
+// publishedInstances returns the instances of a service that aren't excluded by their meta
+func (c *consul) publishedInstances(name string, cnodes []*consulapi.CatalogService) []*consulapi.CatalogService {
+	published := make([]*consulapi.CatalogService, 0, len(cnodes))
+	for _, n := range cnodes {
+		if v, ok := n.ServiceMeta[publishMetaKey]; ok {
+			if publish, err := strconv.ParseBool(v); err == nil && !publish {
+				c.log.Debug("instance excluded by meta", "service", name, "node", n.Node, "id", n.ServiceID)
+				continue
+			}
+		}
+		published = append(published, n)
+	}
+	return published
+}
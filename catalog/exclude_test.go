@@ -0,0 +1,25 @@
+package catalog
+
+import (
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublishedInstances(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	cnodes := []*consulapi.CatalogService{
+		{Node: "n1", ServiceID: "web"},
+		{Node: "n2", ServiceID: "web-debug", ServiceMeta: map[string]string{"ns1-publish": "false"}},
+		{Node: "n3", ServiceID: "web", ServiceMeta: map[string]string{"ns1-publish": "true"}},
+		{Node: "n4", ServiceID: "web", ServiceMeta: map[string]string{"ns1-publish": "invalid"}},
+	}
+	published := c.publishedInstances("web", cnodes)
+	nodes := []string{}
+	for _, n := range published {
+		nodes = append(nodes, n.Node)
+	}
+	assert.Equal(t, []string{"n1", "n3", "n4"}, nodes)
+}
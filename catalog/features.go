@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// feature flags read from the KV prefix of feature flags, each at "<prefix>/<flag>" holding "true" or "false"
+const (
+	// deletesFeature removes the records of services that are gone from Consul, true by default
+	deletesFeature = "deletes"
+	// onlyPassingFeature only publishes the instances passing their checks, -only-passing by default
+	onlyPassingFeature = "only-passing"
+	// observeOnlyFeature fetches and diffs services without writing to NS1, false by default
+	observeOnlyFeature = "observe-only"
+)
+
+// featureFlags toggles behaviors of the sync at runtime, so operators can flip them across instances without
+// restarting them. Flags that aren't set keep their configured behavior, and a nil featureFlags keeps all of them.
+type featureFlags struct {
+	lock sync.Mutex
+	// prefix is the KV prefix the flags are read from
+	prefix string
+	// values holds the flags set in KV
+	values map[string]bool
+}
+
+// enabled returns whether a flag is enabled, or `fallback` if it isn't set
+func (f *featureFlags) enabled(flag string, fallback bool) bool {
+	if f == nil {
+		return fallback
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if v, ok := f.values[flag]; ok {
+		return v
+	}
+	return fallback
+}
+
+// fetchFeatureFlags reads the feature flags in their KV prefix once the next index after `waitIndex` is reached
+// or `WaitTime` has passed, and returns the index of the prefix. Unknown flags and invalid values are ignored.
+func (c *consul) fetchFeatureFlags(waitIndex uint64) (uint64, error) {
+	prefix := strings.TrimSuffix(c.features.prefix, "/") + "/"
+	opts := &consulapi.QueryOptions{AllowStale: c.stale, WaitIndex: waitIndex, WaitTime: WaitTime * time.Second}
+	pairs, meta, err := c.client.KV().List(prefix, opts)
+	if err != nil {
+		return 0, err
+	}
+	values := map[string]bool{}
+	for _, pair := range pairs {
+		flag := strings.TrimPrefix(pair.Key, prefix)
+		if flag == "" || strings.Contains(flag, "/") {
+			continue
+		}
+		switch flag {
+		case deletesFeature, onlyPassingFeature, observeOnlyFeature:
+		default:
+			c.log.Warn("unknown feature flag in KV, ignoring", "flag", flag, "key", pair.Key)
+			continue
+		}
+		v, err := strconv.ParseBool(strings.TrimSpace(string(pair.Value)))
+		if err != nil {
+			c.log.Warn("invalid feature flag in KV, must be true or false, ignoring", "flag", flag, "key", pair.Key,
+				"value", string(pair.Value))
+			continue
+		}
+		values[flag] = v
+	}
+	c.features.lock.Lock()
+	previous := c.features.values
+	c.features.values = values
+	c.features.lock.Unlock()
+	c.reportFeatureFlags(previous, values)
+	return meta.LastIndex, nil
+}
+
+// reportFeatureFlags logs the feature flags set, changed or cleared and sets their gauges
+func (c *consul) reportFeatureFlags(previous, values map[string]bool) {
+	for _, flag := range []string{deletesFeature, observeOnlyFeature, onlyPassingFeature} {
+		v, set := values[flag]
+		old, wasSet := previous[flag]
+		switch {
+		case set && (!wasSet || v != old):
+			c.log.Info("feature flag set", "flag", flag, "value", fmt.Sprintf("%t", v))
+		case !set && wasSet:
+			c.log.Info("feature flag cleared, using the configured behavior", "flag", flag)
+		}
+		gauge := float32(-1)
+		if set && v {
+			gauge = 1
+		} else if set {
+			gauge = 0
+		}
+		metrics.SetGaugeWithLabels([]string{"feature", "flag"}, gauge, []metrics.Label{{Name: "flag", Value: flag}})
+	}
+}
+
+// watchFeatureFlags reads the feature flags in their KV prefix until stopped and requests a resync whenever they
+// change, so they apply to the services at once
+func (c *consul) watchFeatureFlags(stop chan struct{}) {
+	c.watchResync("prefix", c.features.prefix, stop, func(waitIndex uint64) (uint64, uint64, error) {
+		index, err := c.fetchFeatureFlags(waitIndex)
+		return index, index, err
+	})
+}
+
+// observeOnly reports whether the observe-only flag holds back the changes of a sync cycle, logging them
+func (c *consul) observeOnly(upsert, remove map[string]service) bool {
+	if !c.features.enabled(observeOnlyFeature, false) {
+		return false
+	}
+	if len(upsert) > 0 || len(remove) > 0 {
+		c.log.Info("observe-only feature flag set, not writing to NS1",
+			"upserts", fmt.Sprintf("%d", len(upsert)), "removals", fmt.Sprintf("%d", len(remove)))
+		for k := range upsert {
+			c.log.Debug("change observed", "service", k, "change", "upsert")
+		}
+		for k := range remove {
+			c.log.Debug("change observed", "service", k, "change", "remove")
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package catalog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFeatureFlags_Enabled(t *testing.T) {
+	var none *featureFlags
+	assert.True(t, none.enabled(deletesFeature, true))
+	assert.False(t, none.enabled(observeOnlyFeature, false))
+
+	f := &featureFlags{values: map[string]bool{deletesFeature: false, observeOnlyFeature: true}}
+	assert.False(t, f.enabled(deletesFeature, true))
+	assert.True(t, f.enabled(observeOnlyFeature, false))
+	assert.True(t, f.enabled(onlyPassingFeature, true), "flags that aren't set keep the configured behavior")
+}
+
+func TestFetchFeatureFlags(t *testing.T) {
+	pairs := consulapi.KVPairs{
+		{Key: "consul-ns1/flags/deletes", Value: []byte("false")},
+		{Key: "consul-ns1/flags/observe-only", Value: []byte(" TRUE\n")},
+		{Key: "consul-ns1/flags/only-passing", Value: []byte("maybe")},
+		{Key: "consul-ns1/flags/unknown", Value: []byte("true")},
+		{Key: "consul-ns1/flags/nested/deletes", Value: []byte("true")},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/kv/consul-ns1/flags/", r.URL.Path)
+		w.Header().Set("X-Consul-Index", "42")
+		json.NewEncoder(w).Encode(pairs)
+	}))
+	defer srv.Close()
+	client, err := consulapi.NewClient(&consulapi.Config{Address: srv.URL})
+	require.NoError(t, err)
+
+	c := consul{client: client, log: hclog.NewNullLogger(), features: &featureFlags{prefix: "consul-ns1/flags"}}
+	index, err := c.fetchFeatureFlags(0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), index)
+	assert.Equal(t, map[string]bool{deletesFeature: false, observeOnlyFeature: true}, c.features.values)
+}
+
+func TestObserveOnly(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	upsert := map[string]service{"web": {}}
+	assert.False(t, c.observeOnly(upsert, nil))
+
+	c.features = &featureFlags{values: map[string]bool{observeOnlyFeature: true}}
+	assert.True(t, c.observeOnly(upsert, nil))
+	assert.True(t, c.observeOnly(nil, nil))
+}
@@ -0,0 +1,243 @@
+package catalog
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+type dataSourceService interface {
+	List() ([]*data.Source, *http.Response, error)
+	Create(ds *data.Source) (*http.Response, error)
+	Publish(dsID string, data interface{}) (*http.Response, error)
+}
+type dataFeedService interface {
+	List(sourceID string) ([]*data.Feed, *http.Response, error)
+	Create(sourceID string, df *data.Feed) (*http.Response, error)
+	Delete(sourceID string, feedID string) (*http.Response, error)
+}
+
+// upFeedSourceType is the type of the NS1 API data source, which accepts data of any feed it holds
+const upFeedSourceType = "nsone_v1"
+
+// feedLabel identifies the feed of an address of a service in the data source
+func feedLabel(service, address string) string {
+	return service + "/" + address
+}
+
+// feedAddresses returns the addresses of an instance connected to feeds: the answers of its A and AAAA records and
+// the targets of its SRV answers
+func feedAddresses(n node) []string {
+	addresses := append(n.v4Answers(), n.v6Answers()...)
+	for _, a := range n.srvRecAnswers {
+		addresses = append(addresses, a.address)
+	}
+	return addresses
+}
+
+// feedStates returns whether each address of the instances of a service is up, keyed by address. An address is down
+// when all the instances it is an address of are failing their checks, see `markDown`.
+func (c *consul) feedStates(name string, nodes map[string]node, healths map[string]health) map[string]bool {
+	marked := make(map[string]node, len(nodes))
+	for k, n := range nodes {
+		marked[k] = n
+	}
+	marked = c.markDown(name, marked, healths)
+	down := downAnswers(marked, feedAddresses)
+	states := map[string]bool{}
+	for _, a := range nodeAnswers(marked, feedAddresses) {
+		states[a] = !down[a]
+	}
+	return states
+}
+
+// upFeeds connects the up meta of answers to the data feeds of an NS1 API data source, one feed per address of a
+// service, and publishes the health of the addresses to the feeds as soon as it is fetched from Consul. Health
+// changes then steer traffic without rewriting records. A nil upFeeds doesn't connect answers to feeds.
+type upFeeds struct {
+	sources dataSourceService
+	feeds   dataFeedService
+	log     hclog.Logger
+	limiter *apiLimiter
+	// name is the name of the data source
+	name string
+
+	lock     sync.Mutex
+	sourceID string
+	// ids holds the ID of each feed, keyed by `feedLabel`
+	ids map[string]string
+	// up holds whether the address of each feed is up as last fetched from Consul, keyed by `feedLabel`
+	up map[string]bool
+	// published holds the states last published to the feeds, keyed by `feedLabel`
+	published map[string]bool
+}
+
+// init finds or creates the data source and reads its feeds
+func (f *upFeeds) init() error {
+	f.limiter.read()
+	sources, resp, err := f.sources.List()
+	if err != nil {
+		return ns1Error(resp, err)
+	}
+	var source *data.Source
+	for _, s := range sources {
+		if s.Name == f.name && s.Type == upFeedSourceType {
+			source = s
+			break
+		}
+	}
+	if source == nil {
+		source = data.NewSource(f.name, upFeedSourceType)
+		f.limiter.write()
+		if resp, err := f.sources.Create(source); err != nil {
+			return ns1Error(resp, err)
+		}
+		f.log.Info("Created data source for up feeds", "name", f.name, "id", source.ID)
+	}
+	f.limiter.read()
+	feeds, resp, err := f.feeds.List(source.ID)
+	if err != nil {
+		return ns1Error(resp, err)
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sourceID, f.ids, f.up, f.published = source.ID, map[string]string{}, map[string]bool{}, map[string]bool{}
+	for _, feed := range feeds {
+		if label, ok := feed.Config["label"].(string); ok {
+			f.ids[label] = feed.ID
+		}
+	}
+	return nil
+}
+
+// publish records whether the addresses of each service are up, keyed by service and address, and publishes the
+// changes to the feeds. The feeds of addresses and services that are gone are deleted.
+func (f *upFeeds) publish(states map[string]map[string]bool, services map[string]service) {
+	if f == nil {
+		return
+	}
+	f.lock.Lock()
+	changes := map[string]data.Meta{}
+	for service, addresses := range states {
+		for address, up := range addresses {
+			label := feedLabel(service, address)
+			f.up[label] = up
+			if _, ok := f.ids[label]; !ok {
+				continue
+			}
+			if published, ok := f.published[label]; !ok || published != up {
+				changes[label] = data.Meta{Up: up}
+			}
+		}
+	}
+	gone := map[string]string{}
+	for label, id := range f.ids {
+		i := strings.LastIndex(label, "/")
+		service, address := label[:i], label[i+1:]
+		if addresses, ok := states[service]; ok {
+			if _, ok := addresses[address]; ok {
+				continue
+			}
+		} else if _, ok := services[service]; ok {
+			// the health of the service couldn't be fetched
+			continue
+		}
+		gone[label] = id
+		delete(f.ids, label)
+		delete(f.up, label)
+		delete(f.published, label)
+	}
+	sourceID := f.sourceID
+	f.lock.Unlock()
+
+	f.push(changes)
+	labels := make([]string, 0, len(gone))
+	for label := range gone {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		f.limiter.write()
+		if resp, err := f.feeds.Delete(sourceID, gone[label]); err != nil {
+			f.log.Error("cannot delete up feed", "label", label, "error", err.Error())
+			countError(ns1Error(resp, err))
+		}
+	}
+}
+
+// push publishes states to the feeds, keyed by `feedLabel`
+func (f *upFeeds) push(changes map[string]data.Meta) {
+	if len(changes) == 0 {
+		return
+	}
+	f.lock.Lock()
+	sourceID := f.sourceID
+	f.lock.Unlock()
+	f.limiter.write()
+	if resp, err := f.sources.Publish(sourceID, changes); err != nil {
+		f.log.Error("cannot publish to up feeds", "error", err.Error())
+		countError(ns1Error(resp, err))
+		return
+	}
+	metrics.IncrCounter([]string{"ns1", "feed_publish"}, float32(len(changes)))
+	f.lock.Lock()
+	for label, meta := range changes {
+		f.published[label] = meta.Up.(bool)
+	}
+	f.lock.Unlock()
+}
+
+// feedID returns the ID of the feed of an address of a service, creating the feed and publishing the state of the
+// address to it if it doesn't exist. Addresses whose state isn't known don't get a feed.
+func (f *upFeeds) feedID(service, address string) (string, bool) {
+	label := feedLabel(service, address)
+	f.lock.Lock()
+	id, ok := f.ids[label]
+	up, known := f.up[label]
+	sourceID := f.sourceID
+	f.lock.Unlock()
+	if ok || !known {
+		return id, ok
+	}
+	feed := data.NewFeed(label, data.Config{"label": label})
+	f.limiter.write()
+	if resp, err := f.feeds.Create(sourceID, feed); err != nil {
+		f.log.Error("cannot create up feed", "label", label, "error", err.Error())
+		countError(ns1Error(resp, err))
+		return "", false
+	}
+	f.log.Debug("Created up feed", "label", label, "id", feed.ID)
+	f.lock.Lock()
+	f.ids[label] = feed.ID
+	f.lock.Unlock()
+	f.push(map[string]data.Meta{label: {Up: up}})
+	return feed.ID, true
+}
+
+// upConnector returns the ID of the feed the up meta of the answers of an address of a service points to
+type upConnector interface {
+	feedID(service, address string) (string, bool)
+}
+
+// connectFeeds points the up meta of the answers of a record of a service to the feeds of their address, and appends
+// the up filter to its filter chain. Answers without a feed are left up.
+func connectFeeds(rec *dns.Record, service string, feeds upConnector) {
+	for _, a := range rec.Answers {
+		address := strings.TrimSuffix(a.Rdata[len(a.Rdata)-1], ".")
+		id, ok := feeds.feedID(service, address)
+		if !ok {
+			continue
+		}
+		if a.Meta == nil {
+			a.Meta = &data.Meta{}
+		}
+		a.Meta.Up = data.FeedPtr{FeedID: id}
+	}
+	appendUpFilter(rec)
+}
@@ -0,0 +1,111 @@
+package catalog
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+)
+
+type mockDataSourceService struct {
+	sources   []*data.Source
+	published []map[string]data.Meta
+}
+
+func (m *mockDataSourceService) List() ([]*data.Source, *http.Response, error) {
+	return m.sources, nil, nil
+}
+
+func (m *mockDataSourceService) Create(ds *data.Source) (*http.Response, error) {
+	ds.ID = fmt.Sprintf("source%d", len(m.sources)+1)
+	m.sources = append(m.sources, ds)
+	return nil, nil
+}
+
+func (m *mockDataSourceService) Publish(dsID string, d interface{}) (*http.Response, error) {
+	m.published = append(m.published, d.(map[string]data.Meta))
+	return nil, nil
+}
+
+type mockDataFeedService struct {
+	feeds   []*data.Feed
+	deleted []string
+	created int
+}
+
+func (m *mockDataFeedService) List(sourceID string) ([]*data.Feed, *http.Response, error) {
+	return m.feeds, nil, nil
+}
+
+func (m *mockDataFeedService) Create(sourceID string, df *data.Feed) (*http.Response, error) {
+	m.created++
+	df.ID = fmt.Sprintf("feed%d", m.created)
+	return nil, nil
+}
+
+func (m *mockDataFeedService) Delete(sourceID string, feedID string) (*http.Response, error) {
+	m.deleted = append(m.deleted, feedID)
+	return nil, nil
+}
+
+func TestFeedStates(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), warningPolicy: excludeWarning}
+	nodes := map[string]node{
+		"n1/web":  {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}},
+		"n1/web2": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{81: {1, 1, 81, "1.1.1.1"}}},
+		"n2/web":  {aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2.2.2.2"}}},
+	}
+	healths := map[string]health{"n1/web": critical, "n2/web": critical}
+	assert.Equal(t, map[string]bool{"1.1.1.1": true, "2.2.2.2": false}, c.feedStates("web", nodes, healths))
+	assert.False(t, nodes["n1/web"].down, "the nodes of the service aren't marked down")
+}
+
+func TestUpFeeds(t *testing.T) {
+	sources := &mockDataSourceService{}
+	feeds := &mockDataFeedService{feeds: []*data.Feed{{ID: "old", Config: data.Config{"label": "gone/3.3.3.3"}}}}
+	f := &upFeeds{sources: sources, feeds: feeds, log: hclog.NewNullLogger(), name: "consul-ns1 test.zone"}
+	require.NoError(t, f.init())
+	assert.Len(t, sources.sources, 1, "the data source is created")
+	assert.Equal(t, upFeedSourceType, sources.sources[0].Type)
+	require.NoError(t, f.init())
+	assert.Len(t, sources.sources, 1, "an existing data source is reused")
+
+	// states are published once their feed exists
+	f.publish(map[string]map[string]bool{"web": {"1.1.1.1": true, "2.2.2.2": false}}, map[string]service{"web": {}})
+	assert.Equal(t, []string{"old"}, feeds.deleted, "the feeds of services that are gone are deleted")
+	assert.Empty(t, sources.published)
+
+	n := testClient(nil)
+	n.feeds = f
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	nodes := map[string]node{
+		"n1/web": {aRecAnswer: "1.1.1.1"},
+		"n2/web": {aRecAnswer: "2.2.2.2"},
+	}
+	assert.Equal(t, int32(1), n.create(map[string]service{"web": {nodes: nodes, unchanged: recordTypes{srvRec: true}}}))
+	rec := records.records[0]
+	assert.Equal(t, data.FeedPtr{FeedID: "feed1"}, rec.Answers[0].Meta.Up)
+	assert.Equal(t, data.FeedPtr{FeedID: "feed2"}, rec.Answers[1].Meta.Up)
+	assert.Equal(t, "up", rec.Filters[0].Type)
+	assert.Equal(t, []map[string]data.Meta{
+		{"web/1.1.1.1": {Up: true}},
+		{"web/2.2.2.2": {Up: false}},
+	}, sources.published)
+
+	// only changes are published, and feeds of addresses that are gone are deleted
+	sources.published = nil
+	f.publish(map[string]map[string]bool{"web": {"1.1.1.1": true, "2.2.2.2": true}}, map[string]service{"web": {}})
+	assert.Equal(t, []map[string]data.Meta{{"web/2.2.2.2": {Up: true}}}, sources.published)
+	f.publish(map[string]map[string]bool{"web": {"1.1.1.1": true}}, map[string]service{"web": {}})
+	assert.Equal(t, []string{"old", "feed2"}, feeds.deleted)
+
+	// the feeds of a service whose health couldn't be fetched are kept
+	f.publish(map[string]map[string]bool{}, map[string]service{"web": {}})
+	assert.Equal(t, []string{"old", "feed2"}, feeds.deleted)
+}
@@ -0,0 +1,63 @@
+package catalog
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+// parseFilters parses the default filter chain of the records created, a JSON list of filters in the format of the
+// NS1 API, e.g. `[{"filter": "up"}, {"filter": "select_first_n", "config": {"N": 1}}]`, or the path of a file
+// holding one. An empty spec is an empty chain.
+func parseFilters(spec string) ([]*filter.Filter, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, nil
+	}
+	b := []byte(spec)
+	if !strings.HasPrefix(spec, "[") {
+		var err error
+		if b, err = ioutil.ReadFile(spec); err != nil {
+			return nil, err
+		}
+	}
+	return parseFilterChain(b)
+}
+
+// parseFilterChain parses a JSON list of filters in the format of the NS1 API
+func parseFilterChain(b []byte) ([]*filter.Filter, error) {
+	filters := []*filter.Filter{}
+	if err := json.Unmarshal(b, &filters); err != nil {
+		return nil, fmt.Errorf("invalid filter chain: %s", err)
+	}
+	for i, f := range filters {
+		if f == nil || f.Type == "" {
+			return nil, errors.New("invalid filter chain: every filter needs a type")
+		}
+		if f.Config == nil {
+			filters[i].Config = filter.Config{}
+		}
+	}
+	return filters, nil
+}
+
+// setFilters sets a copy of a filter chain on a record being created
+func setFilters(rec *dns.Record, filters []*filter.Filter) {
+	if len(filters) == 0 {
+		return
+	}
+	rec.Filters = make([]*filter.Filter, 0, len(filters))
+	for _, f := range filters {
+		clone := *f
+		clone.Config = make(filter.Config, len(f.Config))
+		for k, v := range f.Config {
+			clone.Config[k] = v
+		}
+		rec.Filters = append(rec.Filters, &clone)
+	}
+}
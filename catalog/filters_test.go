@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+func TestParseFilters(t *testing.T) {
+	f, err := ioutil.TempFile("", "filters")
+	assert.NoError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(`[{"filter": "shuffle"}]`)
+	f.Close()
+
+	table := map[string]struct {
+		spec     string
+		expected []*filter.Filter
+		err      bool
+	}{
+		"empty": {"", nil, false},
+		"json": {`[{"filter": "up"}, {"filter": "select_first_n", "config": {"N": 1}}]`,
+			[]*filter.Filter{{Type: "up", Config: filter.Config{}}, {Type: "select_first_n", Config: filter.Config{"N": float64(1)}}}, false},
+		"file":         {f.Name(), []*filter.Filter{{Type: "shuffle", Config: filter.Config{}}}, false},
+		"missing file": {f.Name() + ".missing", nil, true},
+		"invalid":      {`[{"filter": "up"`, nil, true},
+		"no type":      {`[{"config": {}}]`, nil, true},
+	}
+	for name, v := range table {
+		actual, err := parseFilters(v.spec)
+		assert.Equal(t, v.err, err != nil, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expected, actual, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCreate_Filters(t *testing.T) {
+	n := testClient(nil)
+	n.filters = []*filter.Filter{{Type: "up", Config: filter.Config{}}, filter.NewSelFirstN(1)}
+	n.states = &answerStates{}
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]service{
+		"s1": {nodes: map[string]node{"n1/web": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}}}},
+	}
+
+	assert.Equal(t, int32(2), n.create(desired))
+	for _, rec := range records.records {
+		// the up filter is already part of the chain
+		assert.Len(t, rec.Filters, 2, rec.Type)
+		assert.Equal(t, "up", rec.Filters[0].Type)
+		assert.Equal(t, "select_first_n", rec.Filters[1].Type)
+	}
+	// records get a copy of the chain
+	records.records[0].Filters[1].Config["N"] = 2
+	assert.Equal(t, 1, n.filters[1].Config["N"])
+
+	// existing records keep their filters
+	stored := &storedRecordService{mockRecordService{mux: &sync.Mutex{}}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: stored}
+	s := desired["s1"]
+	s.ns1IDs.aRecID = "r1"
+	desired["s1"] = s
+	assert.Equal(t, int32(2), n.create(desired))
+	for _, rec := range stored.records {
+		if rec.Type == "A" {
+			assert.Len(t, rec.Filters, 1)
+			assert.Equal(t, "up", rec.Filters[0].Type)
+		}
+	}
+}
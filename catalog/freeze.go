@@ -0,0 +1,183 @@
+package catalog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// freezeWindow is a recurring period during which no changes are written to NS1. It starts whenever
+// the current UTC time matches a cron schedule and lasts for a fixed duration.
+type freezeWindow struct {
+	spec string
+	// minutes, hours, days, months and weekdays hold the allowed values of each cron field
+	minutes, hours, days, months, weekdays map[int]bool
+	// anyDay and anyWeekday are set when the day of month or day of week field is "*"
+	anyDay, anyWeekday bool
+	duration           time.Duration
+}
+
+// cronField describes the valid range of a cron field
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// parseFreezeWindow parses a freeze window made of a five field cron schedule
+// (minute, hour, day of month, month and day of week) followed by a duration,
+// e.g. "0 18 * * 5 62h" freezes from Friday 18:00 UTC until Monday 08:00 UTC.
+func parseFreezeWindow(spec string) (freezeWindow, error) {
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields)+1 {
+		return freezeWindow{}, fmt.Errorf("invalid freeze window %q, must be a cron schedule followed by a duration", spec)
+	}
+	values := make([]map[int]bool, len(cronFields))
+	for i, f := range cronFields {
+		v, err := parseCronField(fields[i], f)
+		if err != nil {
+			return freezeWindow{}, fmt.Errorf("invalid freeze window %q: %s", spec, err)
+		}
+		values[i] = v
+	}
+	duration, err := time.ParseDuration(fields[len(cronFields)])
+	if err != nil || duration <= 0 {
+		return freezeWindow{}, fmt.Errorf("invalid freeze window %q: duration must be positive, e.g. \"2h\"", spec)
+	}
+	return freezeWindow{
+		spec:       spec,
+		minutes:    values[0],
+		hours:      values[1],
+		days:       values[2],
+		months:     values[3],
+		weekdays:   values[4],
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+		duration:   duration,
+	}, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges ("1-5") and steps ("*/15", "0-30/10")
+func parseCronField(s string, f cronField) (map[int]bool, error) {
+	values := map[int]bool{}
+	for _, part := range strings.Split(s, ",") {
+		rng, step := part, 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			rng = part[:i]
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step in %s field %q", f.name, part)
+			}
+		}
+		lo, hi := f.min, f.max
+		if rng != "*" {
+			bounds := strings.SplitN(rng, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, fmt.Errorf("invalid %s field %q", f.name, part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, fmt.Errorf("invalid %s field %q", f.name, part)
+				}
+			}
+		}
+		if lo < f.min || hi > f.max || lo > hi {
+			return nil, fmt.Errorf("%s field %q out of range %d-%d", f.name, part, f.min, f.max)
+		}
+		for v := lo; v <= hi; v += step {
+			values[v] = true
+		}
+	}
+	return values, nil
+}
+
+// starts reports whether the window starts at the minute of t
+func (w freezeWindow) starts(t time.Time) bool {
+	if !w.minutes[t.Minute()] || !w.hours[t.Hour()] || !w.months[int(t.Month())] {
+		return false
+	}
+	day, weekday := w.days[t.Day()], w.weekdays[int(t.Weekday())]
+	// like cron, a day matches either field when both are restricted
+	switch {
+	case w.anyDay && w.anyWeekday:
+		return true
+	case w.anyDay:
+		return weekday
+	case w.anyWeekday:
+		return day
+	}
+	return day || weekday
+}
+
+// activeAt reports whether the window is active at t, i.e. it started less than its duration before t
+func (w freezeWindow) activeAt(t time.Time) bool {
+	t = t.UTC().Truncate(time.Minute)
+	for start := t; t.Sub(start) < w.duration; start = start.Add(-time.Minute) {
+		if w.starts(start) {
+			return true
+		}
+	}
+	return false
+}
+
+// freezeWindows is a set of freeze windows
+type freezeWindows []freezeWindow
+
+// parseFreezeWindows parses a list of freeze windows, see parseFreezeWindow
+func parseFreezeWindows(specs []string) (freezeWindows, error) {
+	windows := freezeWindows{}
+	for _, spec := range specs {
+		w, err := parseFreezeWindow(spec)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, w)
+	}
+	return windows, nil
+}
+
+// activeAt returns the first window active at t
+func (ws freezeWindows) activeAt(t time.Time) (freezeWindow, bool) {
+	for _, w := range ws {
+		if w.activeAt(t) {
+			return w, true
+		}
+	}
+	return freezeWindow{}, false
+}
+
+// frozen reports whether a freeze window is active, in which case the pending changes are logged
+// instead of written. Pending changes are recomputed every cycle, so they are applied once the window ends.
+func (n *ns1) frozen(upsert, remove map[string]service) bool {
+	w, active := n.freezeWindows.activeAt(n.clock.Now())
+	if !active {
+		metrics.SetGauge([]string{"freeze", "active"}, 0)
+		metrics.SetGauge([]string{"freeze", "pending"}, 0)
+		return false
+	}
+	metrics.SetGauge([]string{"freeze", "active"}, 1)
+	metrics.SetGauge([]string{"freeze", "pending"}, float32(len(upsert)+len(remove)))
+	if len(upsert) > 0 || len(remove) > 0 {
+		n.log.Info("change freeze active, not writing to NS1", "window", w.spec,
+			"upserts", fmt.Sprintf("%d", len(upsert)), "removals", fmt.Sprintf("%d", len(remove)))
+		for k := range upsert {
+			n.log.Debug("change frozen", "service", k, "change", "upsert")
+		}
+		for k := range remove {
+			n.log.Debug("change frozen", "service", k, "change", "remove")
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFreezeWindow(t *testing.T) {
+	w, err := parseFreezeWindow("0,30 9-17/4 * * 1-5 1h")
+	require.NoError(t, err)
+	assert.Equal(t, map[int]bool{0: true, 30: true}, w.minutes)
+	assert.Equal(t, map[int]bool{9: true, 13: true, 17: true}, w.hours)
+	assert.Equal(t, map[int]bool{1: true, 2: true, 3: true, 4: true, 5: true}, w.weekdays)
+	assert.Len(t, w.days, 31)
+	assert.True(t, w.anyDay)
+	assert.False(t, w.anyWeekday)
+	assert.Equal(t, time.Hour, w.duration)
+
+	for _, spec := range []string{
+		"0 18 * * 5",
+		"0 18 * * 5 0s",
+		"0 18 * * 5 forever",
+		"60 18 * * 5 1h",
+		"0 18 0 * * 1h",
+		"0 18 * * 7 1h",
+		"0 18-17 * * * 1h",
+		"*/0 18 * * * 1h",
+		"a 18 * * * 1h",
+	} {
+		_, err := parseFreezeWindow(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestFreezeWindowActiveAt(t *testing.T) {
+	type variant struct {
+		spec     string
+		at       string
+		expected bool
+	}
+	table := map[string]variant{
+		"weekend freeze on friday evening": {"0 18 * * 5 62h", "2019-10-04T18:00:00Z", true},
+		"weekend freeze on sunday":         {"0 18 * * 5 62h", "2019-10-06T12:00:00Z", true},
+		"weekend freeze on monday morning": {"0 18 * * 5 62h", "2019-10-07T07:59:59Z", true},
+		"weekend freeze ended":             {"0 18 * * 5 62h", "2019-10-07T08:00:00Z", false},
+		"weekend freeze not started":       {"0 18 * * 5 62h", "2019-10-04T17:59:00Z", false},
+		"other timezone":                   {"0 18 * * 5 62h", "2019-10-04T19:30:00+02:00", false},
+		"day of month":                     {"0 0 24 12 * 48h", "2019-12-25T12:00:00Z", true},
+		"day of month or week":             {"0 0 1 * 1 1h", "2019-10-07T00:30:00Z", true},
+		"wrong month":                      {"0 0 24 12 * 48h", "2019-11-25T12:00:00Z", false},
+	}
+	for name, v := range table {
+		w, err := parseFreezeWindow(v.spec)
+		require.NoError(t, err)
+		at, err := time.Parse(time.RFC3339, v.at)
+		require.NoError(t, err)
+		assert.Equal(t, v.expected, w.activeAt(at), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestFreezeWindowsActiveAt(t *testing.T) {
+	ws, err := parseFreezeWindows([]string{"0 18 * * 5 62h", "0 0 24 12 * 48h"})
+	require.NoError(t, err)
+	w, active := ws.activeAt(time.Date(2019, 12, 24, 10, 0, 0, 0, time.UTC))
+	assert.True(t, active)
+	assert.Equal(t, "0 0 24 12 * 48h", w.spec)
+
+	_, active = ws.activeAt(time.Date(2019, 12, 27, 10, 0, 0, 0, time.UTC))
+	assert.False(t, active)
+
+	_, err = parseFreezeWindows([]string{"0 18 * * 5 62h", "invalid"})
+	assert.Error(t, err)
+}
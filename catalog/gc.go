@@ -0,0 +1,64 @@
+package catalog
+
+import (
+	"fmt"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/nsone/consul-ns1/diff"
+)
+
+// collectRegistryGarbage keeps the ownership registry consistent with the records of the zone after an
+// interrupted cycle, e.g. a crash. Ownership records left without records of a service that is no longer
+// desired are deleted and, when adopting, unmarked records of a desired service that already match its
+// desired state, e.g. written by this instance before it could mark them, are marked.
+// It returns the number of deleted and written ownership records.
+func (n *ns1) collectRegistryGarbage(desired map[string]service) (int32, int32) {
+	if !n.ownershipRegistry {
+		return 0, 0
+	}
+	if w, active := n.freezeWindows.activeAt(n.clock.Now()); active {
+		n.log.Debug("change freeze active, skipping registry garbage collection", "window", w.spec)
+		return 0, 0
+	}
+	if err := n.fetch(); err != nil {
+		n.log.Error("cannot fetch zone for registry garbage collection", "error", err.Error())
+		return 0, 0
+	}
+
+	orphaned, unmarked := map[string]service{}, map[string]service{}
+	for k, s := range n.getServices() {
+		d, wanted := desired[k]
+		switch {
+		case !wanted && s.ns1IDs.ownerRecID != "" && s.ns1IDs.recordCount() == 1:
+			orphaned[k] = s
+		case wanted && s.unmanaged() && n.conflictPolicy == adoptConflicts && onlyOwnerDiffers(d, s):
+			s.ownerRecAnswer = d.ownerRecAnswer
+			s.unchanged = recordTypes{aRec: true, aaaaRec: true, srvRec: true, txtRec: true, cnameRec: true}
+			unmarked[k] = s
+		}
+	}
+	for k := range orphaned {
+		n.log.Info("removing orphaned ownership record", "service", k)
+	}
+	for k := range unmarked {
+		n.log.Info("marking unmarked records matching the desired state", "service", k)
+	}
+	removed, marked := n.remove(orphaned), n.create(unmarked)
+	if removed > 0 || marked > 0 {
+		n.log.Info("registry garbage collected", "removed", fmt.Sprintf("%d", removed), "marked", fmt.Sprintf("%d", marked))
+	}
+	metrics.IncrCounterWithLabels([]string{"registry", "gc"}, float32(removed), []metrics.Label{{Name: "type", Value: "removed"}})
+	metrics.IncrCounterWithLabels([]string{"registry", "gc"}, float32(marked), []metrics.Label{{Name: "type", Value: "marked"}})
+	return removed, marked
+}
+
+// onlyOwnerDiffers reports whether the records of a desired service and a service read from NS1
+// only differ by their ownership record
+func onlyOwnerDiffers(desired, existing service) bool {
+	for f, unchanged := range diff.Unchanged(compareEntries(desired, existing)) {
+		if !unchanged && f != ownerFamily {
+			return false
+		}
+	}
+	return true
+}
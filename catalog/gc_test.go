@@ -0,0 +1,96 @@
+package catalog
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// staticZoneService returns the same zone for every name
+type staticZoneService struct {
+	zone *dns.Zone
+}
+
+func (s *staticZoneService) Get(z string) (*dns.Zone, *http.Response, error) {
+	return s.zone, nil, nil
+}
+
+func TestCollectRegistryGarbage(t *testing.T) {
+	n := testClient(nil)
+	n.ownershipRegistry = true
+	n.conflictPolicy = adoptConflicts
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	owner := []string{ownerTXTAnswer("")}
+	n.client = &ns1APIClient{
+		Zones: &staticZoneService{zone: &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+			// orphaned ownership record of a service that is gone
+			{Domain: "_consul-ns1.gone.test.zone", ID: "1", Type: "TXT", ShortAns: owner},
+			// orphaned ownership record of a desired service, its records are recreated by the sync cycle
+			{Domain: "_consul-ns1.pending.test.zone", ID: "2", Type: "TXT", ShortAns: owner},
+			// unmarked records of a desired service matching its desired state
+			{Domain: "unmarked.test.zone", ID: "3", Type: "A", TTL: 10, ShortAns: []string{"1.1.1.1"}},
+			{Domain: "unmarked.test.zone", ID: "4", Type: "SRV", TTL: 10, ShortAns: []string{"1 1 80 1.1.1.1"}},
+			// unmarked records of a desired service that don't match its desired state
+			{Domain: "foreign.test.zone", ID: "5", Type: "A", TTL: 10, ShortAns: []string{"2.2.2.2"}},
+			// unmarked records of a service that isn't desired
+			{Domain: "manual.test.zone", ID: "6", Type: "A", TTL: 10, ShortAns: []string{"3.3.3.3"}},
+		}}},
+		Records: records,
+	}
+	desiredService := func(address string) service {
+		return service{
+			nodes: map[string]node{"h1": {
+				aRecAnswer:    address,
+				srvRecAnswers: map[int]srvAnswer{80: {priority: 1, weight: 1, port: 80, address: address}},
+			}},
+			ttls:           recordTTLs{aRecTTL: 10, srvRecTTL: 10},
+			ownerRecAnswer: ownerTXTAnswer(""),
+		}
+	}
+	desired := map[string]service{
+		"pending":  desiredService("4.4.4.4"),
+		"unmarked": desiredService("1.1.1.1"),
+		"foreign":  desiredService("1.1.1.1"),
+	}
+
+	removed, marked := n.collectRegistryGarbage(desired)
+	assert.Equal(t, int32(1), removed)
+	assert.Equal(t, int32(1), marked)
+	assert.Equal(t, []string{"_consul-ns1.gone.test.zone TXT"}, records.deleted)
+	assert.Equal(t, []*dns.Record{
+		newTestRecord("TXT", "_consul-ns1.unmarked", n.serviceZone.name, owner),
+	}, records.records)
+}
+
+func TestCollectRegistryGarbage_Disabled(t *testing.T) {
+	n := testClient(nil)
+	removed, marked := n.collectRegistryGarbage(map[string]service{})
+	assert.Equal(t, int32(0), removed)
+	assert.Equal(t, int32(0), marked)
+}
+
+func TestRemove_OwnershipRecordLast(t *testing.T) {
+	n := testClient(nil)
+	n.ownershipRegistry = true
+	records := &expectErrorRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	// the ownership record is kept while records of the service are left
+	assert.Equal(t, int32(0), n.remove(map[string]service{"s1": {ns1IDs: recordIDs{aRecID: "r1", ownerRecID: "r2"}}}))
+	assert.Equal(t, 1, records.callCount)
+}
+
+func TestRemove_UnmarkedRecords(t *testing.T) {
+	n := testClient(nil)
+	n.ownershipRegistry = true
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	// records without an ownership record are refused, even if a caller asks for their removal
+	assert.Equal(t, int32(2), n.remove(map[string]service{
+		"marked":   {ns1IDs: recordIDs{aRecID: "r1", ownerRecID: "r2"}},
+		"unmarked": {ns1IDs: recordIDs{aRecID: "r3"}},
+	}))
+	assert.Equal(t, []string{"marked.test.zone A", "_consul-ns1.marked.test.zone TXT"}, records.deleted)
+}
@@ -0,0 +1,259 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+// geoMetaKeys are the node meta keys holding the location of the instances running on a node
+const (
+	georegionMetaKey = "georegion"
+	countryMetaKey   = "country"
+	latitudeMetaKey  = "latitude"
+	longitudeMetaKey = "longitude"
+)
+
+// geotargetRegionalFilter is the NS1 filter preferring answers in the georegion of the requester
+const geotargetRegionalFilter = "geotarget_regional"
+
+// georegions are the georegions NS1 accepts in answer meta
+var georegions = []string{"US-WEST", "US-EAST", "US-CENTRAL", "EUROPE", "AFRICA", "ASIAPAC", "SOUTH-AMERICA"}
+
+// parseGeoRegions parses "DATACENTER=GEOREGION" mappings into a map of the georegion of each datacenter
+func parseGeoRegions(mappings []string) (map[string]string, error) {
+	regions := map[string]string{}
+	for _, m := range mappings {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid georegion mapping %q, must be DATACENTER=GEOREGION", m)
+		}
+		region := strings.ToUpper(parts[1])
+		if !validGeoregion(region) {
+			return nil, fmt.Errorf("unknown georegion %q, must be one of %s", parts[1], strings.Join(georegions, ", "))
+		}
+		regions[parts[0]] = region
+	}
+	return regions, nil
+}
+
+func validGeoregion(region string) bool {
+	for _, r := range georegions {
+		if r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// geoMeta is the location of an instance published in the meta of its A and AAAA answers, empty fields aren't set
+type geoMeta struct {
+	georegion string
+	country   string
+	latitude  string
+	longitude string
+}
+
+// String returns the location as space separated key=value pairs, empty if it's unknown
+func (g geoMeta) String() string {
+	pairs := []string{}
+	for _, p := range [][2]string{{georegionMetaKey, g.georegion}, {countryMetaKey, g.country},
+		{latitudeMetaKey, g.latitude}, {longitudeMetaKey, g.longitude}} {
+		if p[1] != "" {
+			pairs = append(pairs, p[0]+"="+p[1])
+		}
+	}
+	return strings.Join(pairs, " ")
+}
+
+// parseGeoMeta parses a location formatted by `geoMeta.String`
+func parseGeoMeta(s string) geoMeta {
+	g := geoMeta{}
+	for _, pair := range strings.Fields(s) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case georegionMetaKey:
+			g.georegion = parts[1]
+		case countryMetaKey:
+			g.country = parts[1]
+		case latitudeMetaKey:
+			g.latitude = parts[1]
+		case longitudeMetaKey:
+			g.longitude = parts[1]
+		}
+	}
+	return g
+}
+
+// geoMeta returns the location of an instance: the georegion of its datacenter, overridden by the meta of its node,
+// which also holds its country and coordinates. Invalid meta are ignored.
+func (c *consul) geoMeta(n *consulapi.CatalogService) geoMeta {
+	g := geoMeta{georegion: c.geoRegions[n.Datacenter]}
+	if v, ok := n.NodeMeta[georegionMetaKey]; ok {
+		if validGeoregion(strings.ToUpper(v)) {
+			g.georegion = strings.ToUpper(v)
+		} else {
+			c.log.Warn("invalid georegion in node meta, ignoring", "node", n.Node, "value", v)
+		}
+	}
+	if v, ok := n.NodeMeta[countryMetaKey]; ok {
+		if len(v) == 2 {
+			g.country = strings.ToUpper(v)
+		} else {
+			c.log.Warn("invalid country in node meta, ignoring", "node", n.Node, "value", v)
+		}
+	}
+	lat, latErr := strconv.ParseFloat(n.NodeMeta[latitudeMetaKey], 64)
+	long, longErr := strconv.ParseFloat(n.NodeMeta[longitudeMetaKey], 64)
+	if latErr == nil && longErr == nil && lat >= -90 && lat <= 90 && long >= -180 && long <= 180 {
+		g.latitude, g.longitude = strconv.FormatFloat(lat, 'f', -1, 64), strconv.FormatFloat(long, 'f', -1, 64)
+	} else if n.NodeMeta[latitudeMetaKey] != "" || n.NodeMeta[longitudeMetaKey] != "" {
+		c.log.Warn("invalid coordinates in node meta, ignoring", "node", n.Node,
+			"latitude", n.NodeMeta[latitudeMetaKey], "longitude", n.NodeMeta[longitudeMetaKey])
+	}
+	return g
+}
+
+// nodeGeos returns the location of each answer selected by `answers` from a map of nodes. Instances sharing an
+// address share the location of the first of them, by instance key, that has one.
+func nodeGeos(nodes map[string]node, answers func(node) []string) map[string]geoMeta {
+	keys := make([]string, 0, len(nodes))
+	for k := range nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	geos := map[string]geoMeta{}
+	for _, k := range keys {
+		n := nodes[k]
+		if n.geo == (geoMeta{}) {
+			continue
+		}
+		for _, a := range answers(n) {
+			if _, ok := geos[a]; !ok && a != "" {
+				geos[a] = n.geo
+			}
+		}
+	}
+	return geos
+}
+
+// setGeos sets the location meta of the answers of a record and, with `regional`, appends the geotarget_regional
+// filter to its filter chain, unless it's already there. Records without located answers are left alone.
+func setGeos(rec *dns.Record, geos map[string]geoMeta, regional bool) {
+	if len(geos) == 0 {
+		return
+	}
+	for _, a := range rec.Answers {
+		g, ok := geos[strings.Join(a.Rdata, " ")]
+		if !ok {
+			continue
+		}
+		if a.Meta == nil {
+			a.Meta = &data.Meta{}
+		}
+		if g.georegion != "" {
+			a.Meta.Georegion = []string{g.georegion}
+		}
+		if g.country != "" {
+			a.Meta.Country = []string{g.country}
+		}
+		if g.latitude != "" {
+			a.Meta.Latitude, _ = strconv.ParseFloat(g.latitude, 64)
+			a.Meta.Longitude, _ = strconv.ParseFloat(g.longitude, 64)
+		}
+	}
+	if !regional {
+		return
+	}
+	for _, f := range rec.Filters {
+		if f.Type == geotargetRegionalFilter {
+			return
+		}
+	}
+	rec.Filters = append(rec.Filters, filter.NewGeotargetRegional())
+}
+
+// answerGeos remembers the locations of the answers written to NS1, which aren't part of the zone records answers
+// are read back from
+type answerGeos struct {
+	lock sync.Mutex
+	// geos holds the location of each answer formatted by `geoMeta.String`, keyed by `recordKey` and the answer
+	geos map[string]map[string]string
+}
+
+// wrote remembers the locations of the answers of a record written to NS1
+func (g *answerGeos) wrote(rec *dns.Record) {
+	if g == nil {
+		return
+	}
+	geos := map[string]string{}
+	for _, a := range rec.Answers {
+		if a.Meta == nil {
+			continue
+		}
+		m := geoMeta{}
+		m.georegion, m.country = firstMetaValue(a.Meta.Georegion), firstMetaValue(a.Meta.Country)
+		if v, ok := a.Meta.Latitude.(float64); ok {
+			m.latitude = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		if v, ok := a.Meta.Longitude.(float64); ok {
+			m.longitude = strconv.FormatFloat(v, 'f', -1, 64)
+		}
+		if m != (geoMeta{}) {
+			geos[strings.Join(a.Rdata, " ")] = m.String()
+		}
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	if g.geos == nil {
+		g.geos = map[string]map[string]string{}
+	}
+	g.geos[recordKey(rec.Domain, rec.Type)] = geos
+}
+
+// firstMetaValue returns the first value of a list meta field, empty if it's unset. The field holds a []string as set
+// by `setGeos`, or a []interface{} once the record was decoded from the response of NS1 to the write.
+func firstMetaValue(field interface{}) string {
+	switch v := field.(type) {
+	case []string:
+		if len(v) > 0 {
+			return v[0]
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			s, _ := v[0].(string)
+			return s
+		}
+	}
+	return ""
+}
+
+// deleted forgets the locations of the answers of a deleted record
+func (g *answerGeos) deleted(domain, recType string) {
+	if g == nil {
+		return
+	}
+	g.lock.Lock()
+	delete(g.geos, recordKey(domain, recType))
+	g.lock.Unlock()
+}
+
+// geo returns the location last written for an answer of a record, empty if unknown
+func (g *answerGeos) geo(domain, recType, answer string) geoMeta {
+	if g == nil {
+		return geoMeta{}
+	}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	return parseGeoMeta(g.geos[recordKey(domain, recType)][answer])
+}
@@ -0,0 +1,118 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/diff"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestParseGeoRegions(t *testing.T) {
+	table := map[string]struct {
+		mappings []string
+		expected map[string]string
+		err      bool
+	}{
+		"none":           {nil, map[string]string{}, false},
+		"mapped":         {[]string{"dc1=US-EAST", "dc2=europe"}, map[string]string{"dc1": "US-EAST", "dc2": "EUROPE"}, false},
+		"no region":      {[]string{"dc1"}, nil, true},
+		"no datacenter":  {[]string{"=US-EAST"}, nil, true},
+		"unknown region": {[]string{"dc1=MARS"}, nil, true},
+	}
+	for name, v := range table {
+		actual, err := parseGeoRegions(v.mappings)
+		assert.Equal(t, v.err, err != nil, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expected, actual, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestGeoMeta(t *testing.T) {
+	table := map[string]struct {
+		datacenter string
+		meta       map[string]string
+		expected   string
+	}{
+		"unknown":         {"dc3", nil, ""},
+		"datacenter":      {"dc1", nil, "georegion=US-EAST"},
+		"node region":     {"dc1", map[string]string{"georegion": "europe"}, "georegion=EUROPE"},
+		"invalid region":  {"dc1", map[string]string{"georegion": "mars"}, "georegion=US-EAST"},
+		"country":         {"dc3", map[string]string{"country": "de"}, "country=DE"},
+		"invalid country": {"dc3", map[string]string{"country": "germany"}, ""},
+		"coordinates": {"dc1", map[string]string{"latitude": "50.110", "longitude": "8.68"},
+			"georegion=US-EAST latitude=50.11 longitude=8.68"},
+		"missing longitude": {"dc3", map[string]string{"latitude": "50.11"}, ""},
+		"out of range":      {"dc3", map[string]string{"latitude": "91", "longitude": "8.68"}, ""},
+	}
+	for name, v := range table {
+		c := consul{log: hclog.NewNullLogger(), geoRegions: map[string]string{"dc1": "US-EAST"}}
+		actual := c.geoMeta(&consulapi.CatalogService{Node: "n1", Datacenter: v.datacenter, NodeMeta: v.meta})
+		assert.Equal(t, v.expected, actual.String(), fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, actual, parseGeoMeta(actual.String()), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestRecordAnswers_Geo(t *testing.T) {
+	nodes := map[string]node{
+		"n1/web":  {aRecAnswer: "1.1.1.1", geo: geoMeta{georegion: "US-EAST"}},
+		"n1/web2": {aRecAnswer: "1.1.1.1", geo: geoMeta{georegion: "EUROPE"}},
+		"n2/web":  {aRecAnswer: "2.2.2.2", answerWeight: 3, geo: geoMeta{country: "DE"}},
+		"n3/web":  {aRecAnswer: "3.3.3.3"},
+	}
+	assert.Equal(t, []string{"1.1.1.1 georegion=US-EAST", "2.2.2.2 weight=3 country=DE", "3.3.3.3"},
+		recordAnswers(nodes, node.v4Answers))
+}
+
+func TestCreate_Geo(t *testing.T) {
+	n := testClient(nil)
+	n.geos, n.geotargetRegional = &answerGeos{}, true
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]node{
+		"n1/web": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}},
+			geo: geoMeta{georegion: "US-EAST", country: "US", latitude: "40.7", longitude: "-74"}},
+		"n2/web": {aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2.2.2.2"}}},
+	}
+
+	assert.Equal(t, int32(2), n.create(map[string]service{"s1": {nodes: desired}}))
+	for _, rec := range records.records {
+		if rec.Type == "SRV" {
+			assert.Empty(t, rec.Filters)
+			continue
+		}
+		assert.Len(t, rec.Answers, 2)
+		assert.Equal(t, []string{"US-EAST"}, rec.Answers[0].Meta.Georegion)
+		assert.Equal(t, []string{"US"}, rec.Answers[0].Meta.Country)
+		assert.Equal(t, 40.7, rec.Answers[0].Meta.Latitude)
+		assert.Equal(t, -74.0, rec.Answers[0].Meta.Longitude)
+		assert.Nil(t, rec.Answers[1].Meta.Georegion)
+		assert.Len(t, rec.Filters, 1)
+		assert.Equal(t, "geotarget_regional", rec.Filters[0].Type)
+	}
+
+	// the locations written are part of the service read back from the zone
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1", "2.2.2.2"}, Type: "A", TTL: 10},
+		},
+	}
+	actual := n.transformZoneRecords(z)["s1"].nodes
+	assert.Equal(t, service{nodes: desired}.entry()[diff.A].Answers, service{nodes: actual}.entry()[diff.A].Answers)
+
+	// a deleted record forgets its locations
+	n.geos.deleted("s1.test.zone", "A")
+	assert.Equal(t, geoMeta{}, n.geos.geo("s1.test.zone", "A", "1.1.1.1"))
+}
+
+func TestFirstMetaValue(t *testing.T) {
+	assert.Equal(t, "US-EAST", firstMetaValue([]string{"US-EAST"}))
+	// records decoded from the response of NS1 hold lists of interfaces
+	assert.Equal(t, "US-EAST", firstMetaValue([]interface{}{"US-EAST"}))
+	assert.Equal(t, "", firstMetaValue([]interface{}{}))
+	assert.Equal(t, "", firstMetaValue(nil))
+}
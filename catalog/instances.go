@@ -0,0 +1,95 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+)
+
+// instanceCountNote returns the record note publishing the number of healthy instances of a service
+func instanceCountNote(healthy int) string {
+	return fmt.Sprintf("consul-ns1 healthy_instances=%d", healthy)
+}
+
+// countHealthyInstances returns the number of instances of a service passing their checks.
+// Instances without checks are healthy.
+func countHealthyInstances(nodes map[string]node, healths map[string]health) int {
+	count := 0
+	for k := range nodes {
+		if h, ok := healths[k]; !ok || h == passing {
+			count++
+		}
+	}
+	return count
+}
+
+// publishInstanceCounts writes the number of healthy instances of each managed service into the note of
+// its records when it changed since the last time it was written. Records written for the first time get
+// their note on the next cycle. Returns the number of services whose count was written.
+func (n *ns1) publishInstanceCounts(desired, existing map[string]service) int32 {
+	if !n.instanceCountMeta || n.writesPaused() {
+		return 0
+	}
+	keys := make([]string, 0, len(desired))
+	for k, s := range desired {
+		if !s.srvTarget {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	n.instanceCountsLock.Lock()
+	defer n.instanceCountsLock.Unlock()
+	if n.instanceCounts == nil {
+		n.instanceCounts = map[string]int{}
+	}
+	for k := range n.instanceCounts {
+		if _, ok := desired[k]; !ok {
+			delete(n.instanceCounts, k)
+		}
+	}
+	var count int32
+	for _, k := range keys {
+		healthy := desired[k].healthyInstances
+		e, ok := existing[k]
+		if !ok || (n.ownershipRegistry && n.conflictPolicy != adoptConflicts && e.unmanaged()) {
+			continue
+		}
+		if published, ok := n.instanceCounts[k]; ok && published == healthy {
+			continue
+		}
+		written := true
+		for _, r := range []struct {
+			id      string
+			recType string
+		}{
+			{e.ns1IDs.aRecID, "A"},
+			{e.ns1IDs.aaaaRecID, "AAAA"},
+			{e.ns1IDs.srvRecID, "SRV"},
+			{e.ns1IDs.cnameRecID, "CNAME"},
+		} {
+			if r.id == "" {
+				continue
+			}
+			n.limiter.read()
+			rec, _, err := n.client.Records.Get(n.serviceZone.name, n.serviceDomain(k), r.recType)
+			if err == nil {
+				if rec.Meta == nil {
+					rec.Meta = &data.Meta{}
+				}
+				rec.Meta.Note = instanceCountNote(healthy)
+				err = n.upsertRecord(r.id, rec)
+			}
+			if err != nil {
+				n.log.Error("cannot write instance count of service", "service", k, "type", r.recType, "error", err.Error())
+				written = false
+			}
+		}
+		if written {
+			n.instanceCounts[k] = healthy
+			count++
+		}
+	}
+	return count
+}
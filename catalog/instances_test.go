@@ -0,0 +1,60 @@
+package catalog
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// getRecordService returns an existing record without meta on Get and records writes
+type getRecordService struct {
+	*mockRecordService
+}
+
+func (s getRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	return dns.NewRecord(zone, domain, t), nil, nil
+}
+
+func TestCountHealthyInstances(t *testing.T) {
+	nodes := map[string]node{"n1/a": {}, "n1/b": {}, "n2/a": {}, "n3/a": {}}
+	healths := map[string]health{"n1/a": passing, "n1/b": critical, "n2/a": unknown}
+	// n3/a has no checks
+	assert.Equal(t, 2, countHealthyInstances(nodes, healths))
+	assert.Equal(t, 0, countHealthyInstances(nil, healths))
+}
+
+func TestPublishInstanceCounts(t *testing.T) {
+	n := testClient(nil)
+	n.instanceCountMeta = true
+	records := getRecordService{&mockRecordService{mux: &sync.Mutex{}}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]service{
+		"s1":  {healthyInstances: 3},
+		"new": {healthyInstances: 1},
+	}
+	existing := map[string]service{
+		"s1": {ns1IDs: recordIDs{aRecID: "a", srvRecID: "srv", txtRecID: "txt"}},
+	}
+
+	assert.Equal(t, int32(1), n.publishInstanceCounts(desired, existing))
+	require.Len(t, records.records, 2)
+	for _, rec := range records.records {
+		assert.Equal(t, "s1.test.zone", rec.Domain)
+		assert.Equal(t, "consul-ns1 healthy_instances=3", rec.Meta.Note, fmt.Sprintf("type %s", rec.Type))
+	}
+
+	// unchanged counts aren't written again
+	assert.Equal(t, int32(0), n.publishInstanceCounts(desired, existing))
+	desired["s1"] = service{healthyInstances: 2}
+	assert.Equal(t, int32(1), n.publishInstanceCounts(desired, existing))
+	assert.Len(t, records.records, 4)
+
+	n.instanceCountMeta = false
+	desired["s1"] = service{healthyInstances: 1}
+	assert.Equal(t, int32(0), n.publishInstanceCounts(desired, existing))
+}
@@ -0,0 +1,97 @@
+package catalog
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+)
+
+// journalEntry is a mutation of a service about to be written to NS1
+type journalEntry struct {
+	Service string `json:"service"`
+	// Op is either "upsert" or "remove"
+	Op string `json:"op"`
+}
+
+// journalCycle holds the mutations of a sync cycle being applied
+type journalCycle struct {
+	Since   time.Time      `json:"since"`
+	Entries []journalEntry `json:"entries"`
+}
+
+// journal records the mutations of a sync cycle in a file before they are applied, and removes the file once
+// they were. A file left behind means the syncer stopped mid-cycle, e.g. because it crashed, without knowing
+// which of the mutations landed. A nil journal records nothing.
+type journal struct {
+	log   hclog.Logger
+	file  string
+	clock *clock
+}
+
+// begin records the mutations of a sync cycle about to be applied
+func (j *journal) begin(upsert, remove map[string]service) {
+	if j == nil || len(upsert)+len(remove) == 0 {
+		return
+	}
+	cycle := journalCycle{Since: j.clock.Now(), Entries: []journalEntry{}}
+	for k := range upsert {
+		cycle.Entries = append(cycle.Entries, journalEntry{Service: k, Op: "upsert"})
+	}
+	for k := range remove {
+		cycle.Entries = append(cycle.Entries, journalEntry{Service: k, Op: "remove"})
+	}
+	sort.Slice(cycle.Entries, func(i, k int) bool { return cycle.Entries[i].Service < cycle.Entries[k].Service })
+	b, err := json.MarshalIndent(cycle, "", "  ")
+	if err == nil {
+		// write atomically, so a crash while writing never leaves a truncated journal
+		tmp := j.file + ".tmp"
+		if err = ioutil.WriteFile(tmp, b, 0644); err == nil {
+			err = os.Rename(tmp, j.file)
+		}
+	}
+	if err != nil {
+		j.log.Error("cannot write journal, changes are applied without it", "file", j.file, "error", err.Error())
+	}
+}
+
+// commit records that the mutations of the current sync cycle were applied
+func (j *journal) commit() {
+	if j == nil {
+		return
+	}
+	if err := os.Remove(j.file); err != nil && !os.IsNotExist(err) {
+		j.log.Error("cannot remove journal", "file", j.file, "error", err.Error())
+	}
+}
+
+// recover returns the mutations of a sync cycle that was interrupted before they were all applied,
+// and removes the journal
+func (j *journal) recover() ([]journalEntry, error) {
+	if j == nil {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(j.file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	cycle := journalCycle{}
+	if err := json.Unmarshal(b, &cycle); err != nil {
+		// the journal is written atomically, an unreadable journal wasn't written by this syncer
+		return nil, err
+	}
+	for _, e := range cycle.Entries {
+		j.log.Warn("sync cycle was interrupted, change may not have been applied", "service", e.Service,
+			"op", e.Op, "since", cycle.Since.Format(time.RFC3339))
+	}
+	metrics.IncrCounter([]string{"journal", "recovered"}, float32(len(cycle.Entries)))
+	j.commit()
+	return cycle.Entries, nil
+}
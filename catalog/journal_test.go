@@ -0,0 +1,61 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "consul-ns1")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	j := &journal{log: hclog.NewNullLogger(), file: filepath.Join(dir, "journal.json")}
+
+	entries, err := j.recover()
+	require.NoError(t, err)
+	assert.Empty(t, entries, "nothing to recover without a journal")
+
+	// a completed cycle leaves no journal behind
+	j.begin(map[string]service{"web": {}}, map[string]service{})
+	assert.FileExists(t, j.file)
+	j.commit()
+	_, err = os.Stat(j.file)
+	assert.True(t, os.IsNotExist(err))
+
+	// changes without a cycle aren't journaled
+	j.begin(map[string]service{}, map[string]service{})
+	_, err = os.Stat(j.file)
+	assert.True(t, os.IsNotExist(err))
+
+	// an interrupted cycle is recovered once
+	j.begin(map[string]service{"web": {}, "api": {}}, map[string]service{"old": {}})
+	entries, err = j.recover()
+	require.NoError(t, err)
+	assert.Equal(t, []journalEntry{
+		{Service: "api", Op: "upsert"},
+		{Service: "old", Op: "remove"},
+		{Service: "web", Op: "upsert"},
+	}, entries)
+	entries, err = j.recover()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	require.NoError(t, ioutil.WriteFile(j.file, []byte("{"), 0644))
+	_, err = j.recover()
+	assert.Error(t, err)
+}
+
+func TestJournal_Nil(t *testing.T) {
+	var j *journal
+	j.begin(map[string]service{"web": {}}, nil)
+	j.commit()
+	entries, err := j.recover()
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,90 @@
+package catalog
+
+import (
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/nsone/consul-ns1/diff"
+)
+
+// syncLatency measures the time between observing a change of a service in the Consul catalog and writing it to
+// NS1, so SLOs on the propagation of discovery changes can be enforced. A nil syncLatency measures nothing.
+type syncLatency struct {
+	clock *clock
+	lock  sync.Mutex
+	// observed holds the time the pending change of each service was first observed in Consul
+	observed map[string]time.Time
+	// written holds the time of the last successful write to the records of each domain
+	written map[string]time.Time
+}
+
+// fetched notes the services whose records changed between two fetches of the Consul catalog. Services that
+// already have a change pending keep the time it was observed.
+func (l *syncLatency) fetched(previous, current map[string]service) {
+	if l == nil || previous == nil {
+		// nothing was observed changing on the first fetch
+		return
+	}
+	now := l.clock.Now()
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.observed == nil {
+		l.observed = map[string]time.Time{}
+	}
+	for k, s := range current {
+		p, ok := previous[k]
+		if _, pending := l.observed[k]; !pending && (!ok || diff.Changed(s.entry(), p.entry())) {
+			l.observed[k] = now
+		}
+	}
+	for k := range previous {
+		if _, ok := current[k]; !ok {
+			if _, pending := l.observed[k]; !pending {
+				l.observed[k] = now
+			}
+		}
+	}
+}
+
+// wrote notes a successful write to the records of a domain
+func (l *syncLatency) wrote(domain string) {
+	if l == nil {
+		return
+	}
+	now := l.clock.Now()
+	l.lock.Lock()
+	if l.written == nil {
+		l.written = map[string]time.Time{}
+	}
+	l.written[domain] = now
+	l.lock.Unlock()
+}
+
+// applied measures the latency of the changes of the services upserted or removed by a sync cycle whose records
+// were written since the change was observed. Changes whose writes failed stay pending, and changes of services
+// that didn't need to be written, e.g. because they were reverted, are dropped.
+func (l *syncLatency) applied(upsert, remove map[string]service, domain func(string) string) {
+	if l == nil {
+		return
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	for k, observed := range l.observed {
+		_, upserted := upsert[k]
+		_, removed := remove[k]
+		if !upserted && !removed {
+			delete(l.observed, k)
+			continue
+		}
+		written, ok := l.written[domain(k)]
+		if !ok || written.Before(observed) {
+			continue
+		}
+		ms := float32(written.Sub(observed).Seconds() * 1000)
+		metrics.AddSample([]string{"sync", "latency"}, ms)
+		metrics.SetGaugeWithLabels([]string{"service", "sync_latency"}, ms, []metrics.Label{{Name: "service", Value: k}})
+		delete(l.observed, k)
+		delete(l.written, domain(k))
+	}
+}
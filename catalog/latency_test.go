@@ -0,0 +1,51 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSyncLatency(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	l := &syncLatency{clock: &clock{now: func() time.Time { return now }}}
+	domain := func(k string) string { return k + ".test.zone" }
+	web := service{nodes: map[string]node{"n1/web": {aRecAnswer: "1.1.1.1"}}}
+	previous := map[string]service{"web": web, "api": web, "old": web}
+
+	// nothing is observed on the first fetch
+	l.fetched(nil, previous)
+	assert.Empty(t, l.observed)
+
+	current := map[string]service{
+		"web": {nodes: map[string]node{"n1/web": {aRecAnswer: "2.2.2.2"}}},
+		"api": web,
+		"new": web,
+	}
+	l.fetched(previous, current)
+	assert.Equal(t, map[string]time.Time{"web": now, "new": now, "old": now}, l.observed)
+
+	// changes still pending keep the time they were first observed
+	start := now
+	now = now.Add(time.Second)
+	l.fetched(current, map[string]service{"web": {nodes: map[string]node{"n1/web": {aRecAnswer: "3.3.3.3"}}}, "new": web})
+	assert.Equal(t, start, l.observed["web"])
+
+	// changes are measured once their records are written, failed writes stay pending
+	now = now.Add(time.Second)
+	l.wrote("web.test.zone")
+	l.wrote("old.test.zone")
+	l.applied(map[string]service{"web": {}, "new": {}}, map[string]service{"old": {}}, domain)
+	assert.Equal(t, map[string]time.Time{"new": start}, l.observed)
+	assert.Empty(t, l.written)
+
+	// changes that didn't need to be written are dropped
+	l.applied(map[string]service{}, map[string]service{}, domain)
+	assert.Empty(t, l.observed)
+
+	var disabled *syncLatency
+	disabled.fetched(previous, current)
+	disabled.wrote("web.test.zone")
+	disabled.applied(current, current, domain)
+}
@@ -0,0 +1,51 @@
+package catalog
+
+import (
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+// leaderLockSession is the name of the Consul session holding the leader lock
+const leaderLockSession = "consul-ns1"
+
+// leadership elects a single active instance among the instances sharing a Consul KV key, so multiple instances
+// can be deployed for high availability without writing to NS1 concurrently
+type leadership struct {
+	log  hclog.Logger
+	lock *consulapi.Lock
+}
+
+// newLeadership returns the leadership of the instances sharing the given key
+func newLeadership(client *consulapi.Client, key string) (*leadership, error) {
+	lock, err := client.LockOpts(&consulapi.LockOptions{Key: key, SessionName: leaderLockSession})
+	if err != nil {
+		return nil, err
+	}
+	return &leadership{log: hclog.Default().Named("leader"), lock: lock}, nil
+}
+
+// acquire blocks until this instance is the leader or stop is closed, in which case the returned channel is nil.
+// The returned channel is closed when leadership is lost.
+func (l *leadership) acquire(stop chan struct{}) (<-chan struct{}, error) {
+	l.log.Info("waiting for leadership")
+	lost, err := l.lock.Lock(stop)
+	if err != nil || lost == nil {
+		return nil, err
+	}
+	l.log.Info("acquired leadership")
+	return lost, nil
+}
+
+// release gives up leadership so another instance can take over immediately
+func (l *leadership) release() {
+	if err := l.lock.Unlock(); err != nil && err != consulapi.ErrLockNotHeld {
+		l.log.Error("cannot release leadership", "error", err.Error())
+	}
+}
+
+// heal makes the first cycle after acquiring leadership a full reconciliation that also garbage collects the
+// ownership registry, healing partial writes the previous leader may have left behind
+func (c *consul) heal(ns1 *ns1) {
+	ns1.healRegistry = ns1.ownershipRegistry
+	c.requestResync()
+}
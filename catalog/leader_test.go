@@ -0,0 +1,42 @@
+package catalog
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestHeal(t *testing.T) {
+	client, srv := connectConsul(t)
+	defer srv.Close()
+	c := consul{client: client, log: hclog.NewNullLogger(), addressFamily: ipv4Family, resync: make(chan struct{}, 1)}
+	n := testClient(nil)
+	n.ownershipRegistry = true
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{
+		Zones: &staticZoneService{zone: &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+			// left behind by the previous leader while removing a service
+			{Domain: "_consul-ns1.gone.test.zone", ID: "1", Type: "TXT", ShortAns: []string{ownerTXTAnswer("")}},
+		}}},
+		Records: records,
+	}
+
+	c.heal(n)
+	assert.True(t, n.healRegistry)
+	assert.Len(t, c.resync, 1, "a full reconciliation is requested")
+
+	assert.True(t, c.resyncNow(n))
+	assert.False(t, n.healRegistry, "the registry is only healed once")
+	assert.Equal(t, []string{"_consul-ns1.gone.test.zone TXT"}, records.deleted)
+}
+
+func TestHeal_WithoutRegistry(t *testing.T) {
+	c := consul{resync: make(chan struct{}, 1)}
+	n := testClient(nil)
+	c.heal(n)
+	assert.False(t, n.healRegistry)
+	assert.Len(t, c.resync, 1)
+}
@@ -0,0 +1,99 @@
+package catalog
+
+import (
+	"sync"
+	"time"
+)
+
+// apiLimiter is a token bucket shared by the requests reading and writing the NS1 API, so the poll loop and
+// the write workers don't exceed the API quota together. Each request takes its weight in tokens, refilled at
+// rate tokens per second. Writes wait for tokens, while polls of the zone are deferred until there are enough
+// tokens, so a heavy write cycle delays the next poll. A nil apiLimiter doesn't limit requests.
+type apiLimiter struct {
+	// rate is the number of tokens added per second
+	rate float64
+	// readWeight and writeWeight are the tokens taken by a read and a write
+	readWeight  float64
+	writeWeight float64
+	// clock is the clock refilling the bucket, nil is the real clock
+	clock *clock
+
+	lock   sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// burst is the capacity of the bucket: a second worth of tokens, or enough for a single request
+func (l *apiLimiter) burst() float64 {
+	burst := l.rate
+	if l.readWeight > burst {
+		burst = l.readWeight
+	}
+	if l.writeWeight > burst {
+		burst = l.writeWeight
+	}
+	return burst
+}
+
+// delay returns how long until the bucket holds `weight` tokens, taking them if it already does.
+// It must be called with the lock held.
+func (l *apiLimiter) delay(weight float64, take bool) time.Duration {
+	now := l.clock.Now()
+	if l.last.IsZero() {
+		l.tokens = l.burst()
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if burst := l.burst(); l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+	if l.tokens >= weight {
+		if take {
+			l.tokens -= weight
+		}
+		return 0
+	}
+	return time.Duration((weight - l.tokens) / l.rate * float64(time.Second))
+}
+
+// wait blocks until the bucket holds `weight` tokens and takes them
+func (l *apiLimiter) wait(weight float64) {
+	if l == nil {
+		return
+	}
+	for {
+		l.lock.Lock()
+		d := l.delay(weight, true)
+		l.lock.Unlock()
+		if d == 0 {
+			return
+		}
+		<-l.clock.After(d)
+	}
+}
+
+// read waits for the tokens of a read request
+func (l *apiLimiter) read() {
+	if l != nil {
+		l.wait(l.readWeight)
+	}
+}
+
+// write waits for the tokens of a write request
+func (l *apiLimiter) write() {
+	if l != nil {
+		l.wait(l.writeWeight)
+	}
+}
+
+// pollDelay returns how long the next poll of the zone should be deferred for the bucket to hold the tokens
+// of a read, without taking them
+func (l *apiLimiter) pollDelay() time.Duration {
+	if l == nil {
+		return 0
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.delay(l.readWeight, false)
+}
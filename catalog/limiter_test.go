@@ -0,0 +1,54 @@
+package catalog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPILimiter(t *testing.T) {
+	f := newFakeClock(time.Date(2019, 10, 1, 0, 0, 0, 0, time.UTC))
+	l := &apiLimiter{rate: 2, readWeight: 4, writeWeight: 1, clock: f.clock()}
+
+	// the bucket starts full, with enough tokens for a read
+	assert.Equal(t, time.Duration(0), l.pollDelay())
+	for i := 0; i < 4; i++ {
+		l.write()
+	}
+	// the writes defer the next poll until the bucket refills
+	assert.Equal(t, 2*time.Second, l.pollDelay())
+	f.advance(time.Second)
+	assert.Equal(t, time.Second, l.pollDelay())
+	f.advance(time.Second)
+	assert.Equal(t, time.Duration(0), l.pollDelay())
+	l.read()
+
+	// writes wait for tokens
+	written := make(chan struct{})
+	go func() {
+		l.write()
+		close(written)
+	}()
+	for f.pending() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	select {
+	case <-written:
+		t.Fatal("write should wait for tokens")
+	default:
+	}
+	f.advance(500 * time.Millisecond)
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("write should have been allowed once the bucket refilled")
+	}
+}
+
+func TestAPILimiter_Nil(t *testing.T) {
+	var l *apiLimiter
+	l.read()
+	l.write()
+	assert.Equal(t, time.Duration(0), l.pollDelay())
+}
@@ -0,0 +1,51 @@
+package catalog
+
+import (
+	"strings"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// managedMarker is the token marking the records written by consul-ns1 in their note
+const managedMarker = "managed-by=consul-ns1"
+
+// markedNote reports whether a record note carries the managed marker
+func markedNote(note interface{}) bool {
+	s, _ := note.(string)
+	for _, f := range strings.Fields(s) {
+		if f == managedMarker {
+			return true
+		}
+	}
+	return false
+}
+
+// markRecord adds the managed marker to the note of a record about to be written, keeping the rest of the note
+func (n *ns1) markRecord(rec *dns.Record) {
+	if !n.recordMarker {
+		return
+	}
+	if rec.Meta == nil {
+		rec.Meta = &data.Meta{}
+	}
+	if markedNote(rec.Meta.Note) {
+		return
+	}
+	note, _ := rec.Meta.Note.(string)
+	rec.Meta.Note = strings.TrimSpace(note + " " + managedMarker)
+}
+
+// marked fetches a record and reports whether it carries the managed marker, i.e. whether it may be deleted.
+// All records may be deleted with the marker disabled.
+func (n *ns1) marked(zone, domain, recType string) (bool, error) {
+	if !n.recordMarker {
+		return true, nil
+	}
+	n.limiter.read()
+	rec, resp, err := n.client.Records.Get(zone, domain, recType)
+	if err != nil {
+		return false, ns1Error(resp, err)
+	}
+	return rec.Meta != nil && markedNote(rec.Meta.Note), nil
+}
@@ -0,0 +1,89 @@
+package catalog
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// markedRecordService holds records marked as managed by consul-ns1 at the domains of `marked`
+type markedRecordService struct {
+	mockRecordService
+	marked map[string]bool
+}
+
+func (s *markedRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	rec := dns.NewRecord(zone, domain, t)
+	rec.ID = "r1"
+	rec.AddAnswer(dns.NewAv4Answer("1.1.1.1"))
+	if s.marked[domain] {
+		rec.Meta = &data.Meta{Note: "consul-ns1 healthy_instances=1 managed-by=consul-ns1"}
+	}
+	return rec, nil, nil
+}
+
+func TestMarkedNote(t *testing.T) {
+	table := map[string]struct {
+		note     interface{}
+		expected bool
+	}{
+		"none":      {nil, false},
+		"marker":    {"managed-by=consul-ns1", true},
+		"counts":    {"consul-ns1 healthy_instances=3 managed-by=consul-ns1", true},
+		"other":     {"managed-by=terraform", false},
+		"substring": {"managed-by=consul-ns1-legacy", false},
+		"number":    {42, false},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, markedNote(v.note), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCreate_RecordMarker(t *testing.T) {
+	n := testClient(nil)
+	n.recordMarker = true
+	records := &markedRecordService{mockRecordService: mockRecordService{mux: &sync.Mutex{}}, marked: map[string]bool{"s2.test.zone": true}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]service{
+		"s1": {nodes: map[string]node{"n1/web": {aRecAnswer: "1.1.1.1"}}},
+		"s2": {nodes: map[string]node{"n1/api": {aRecAnswer: "2.2.2.2"}}, ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"}},
+	}
+
+	assert.Equal(t, int32(4), n.create(desired))
+	for _, rec := range records.records {
+		assert.True(t, markedNote(rec.Meta.Note), rec.String())
+	}
+	// the rest of the note of existing records is kept
+	for _, rec := range records.records {
+		if rec.Domain == "s2.test.zone" {
+			assert.Equal(t, "consul-ns1 healthy_instances=1 managed-by=consul-ns1", rec.Meta.Note)
+		}
+	}
+}
+
+func TestRemove_RecordMarker(t *testing.T) {
+	n := testClient(nil)
+	n.recordMarker = true
+	records := &markedRecordService{mockRecordService: mockRecordService{mux: &sync.Mutex{}}, marked: map[string]bool{"s1.test.zone": true}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	remove := map[string]service{
+		"s1": {ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"}},
+		"s2": {ns1IDs: recordIDs{aRecID: "r3", srvRecID: "r4"}},
+	}
+
+	// records made by hand are kept
+	assert.Equal(t, int32(2), n.remove(remove))
+	sort.Strings(records.deleted)
+	assert.Equal(t, []string{"s1.test.zone A", "s1.test.zone SRV"}, records.deleted)
+
+	// all records are deleted with the marker disabled
+	n.recordMarker = false
+	records.deleted = nil
+	assert.Equal(t, int32(4), n.remove(remove))
+}
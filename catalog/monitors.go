@@ -0,0 +1,353 @@
+package catalog
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/monitor"
+)
+
+type monitorJobService interface {
+	List() ([]*monitor.Job, *http.Response, error)
+	Create(mj *monitor.Job) (*http.Response, error)
+	Update(mj *monitor.Job) (*http.Response, error)
+	Delete(id string) (*http.Response, error)
+}
+
+const (
+	// monitorSourceType is the type of the data source holding the feeds of NS1 monitoring jobs
+	monitorSourceType = "nsone_monitoring"
+	// minMonitorFrequency is the minimum number of seconds between the runs of a monitoring job
+	minMonitorFrequency = 20
+)
+
+// defaultMonitorRegions are the NS1 regions monitoring jobs run from by default
+var defaultMonitorRegions = []string{"lga", "sjc", "ams"}
+
+// monitorSpec is what a monitoring job checks: an HTTP URL or a TCP host and port, every `frequency` seconds
+type monitorSpec struct {
+	jobType   string
+	url       string
+	method    string
+	host      string
+	port      int
+	frequency int
+}
+
+// config returns the config of the monitoring job of a spec
+func (s monitorSpec) config() monitor.Config {
+	if s.jobType == "http" {
+		return monitor.Config{"url": s.url, "method": s.method}
+	}
+	return monitor.Config{"host": s.host, "port": s.port}
+}
+
+// jobSpec returns the spec of an existing monitoring job
+func jobSpec(job *monitor.Job) monitorSpec {
+	spec := monitorSpec{jobType: job.Type, frequency: job.Frequency}
+	spec.url, _ = job.Config["url"].(string)
+	spec.method, _ = job.Config["method"].(string)
+	spec.host, _ = job.Config["host"].(string)
+	switch p := job.Config["port"].(type) {
+	case float64:
+		spec.port = int(p)
+	case int:
+		spec.port = p
+	}
+	return spec
+}
+
+// checkSpec translates an HTTP or TCP Consul check into the spec of a monitoring job targeting `address` instead of
+// the host the check targets, which may only be reachable from inside the cluster
+func checkSpec(check *consulapi.HealthCheck, address string) (monitorSpec, bool) {
+	interval := check.Definition.IntervalDuration
+	if interval == 0 {
+		interval = time.Duration(check.Definition.Interval)
+	}
+	spec := monitorSpec{frequency: int(interval / time.Second)}
+	if spec.frequency < minMonitorFrequency {
+		spec.frequency = minMonitorFrequency
+	}
+	switch {
+	case check.Definition.HTTP != "":
+		u, err := url.Parse(check.Definition.HTTP)
+		if err != nil || u.Host == "" {
+			return spec, false
+		}
+		if port := u.Port(); port != "" {
+			u.Host = net.JoinHostPort(address, port)
+		} else if strings.Contains(address, ":") {
+			u.Host = "[" + address + "]"
+		} else {
+			u.Host = address
+		}
+		spec.jobType, spec.url, spec.method = "http", u.String(), check.Definition.Method
+		if spec.method == "" {
+			spec.method = http.MethodGet
+		}
+	case check.Definition.TCP != "":
+		_, p, err := net.SplitHostPort(check.Definition.TCP)
+		port, perr := strconv.Atoi(p)
+		if err != nil || perr != nil {
+			return spec, false
+		}
+		spec.jobType, spec.host, spec.port = "tcp", address, port
+	default:
+		return spec, false
+	}
+	return spec, true
+}
+
+// monitorSpecs returns the monitoring job of each address of the instances of a service, keyed by address. An
+// address is monitored with the first HTTP or TCP check, by check ID, of the instances it is an address of.
+func monitorSpecs(nodes map[string]node, checks consulapi.HealthChecks) map[string]monitorSpec {
+	sorted := make(consulapi.HealthChecks, len(checks))
+	copy(sorted, checks)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].CheckID < sorted[j].CheckID })
+	keys := make([]string, 0, len(nodes))
+	for k := range nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	specs := map[string]monitorSpec{}
+	for _, check := range sorted {
+		for _, k := range keys {
+			if instanceKey(check.Node, check.ServiceID) != k {
+				continue
+			}
+			for _, address := range []string{nodes[k].aRecAnswer, nodes[k].aaaaRecAnswer} {
+				if _, ok := specs[address]; ok || address == "" {
+					continue
+				}
+				if spec, ok := checkSpec(check, address); ok {
+					specs[address] = spec
+				}
+			}
+		}
+	}
+	return specs
+}
+
+// monitors mirrors the HTTP and TCP checks of the instances of each service as NS1 monitoring jobs, one per address
+// of a service, and connects the up meta of the answers to the feeds of the jobs, so NS1 validates that addresses
+// are reachable from the edge. A nil monitors doesn't create jobs.
+type monitors struct {
+	jobs    monitorJobService
+	sources dataSourceService
+	feeds   dataFeedService
+	log     hclog.Logger
+	limiter *apiLimiter
+	// name is the name of the data source, and the prefix of the names of the jobs
+	name    string
+	regions []string
+
+	lock     sync.Mutex
+	sourceID string
+	// jobIDs, specs and feedIDs hold the ID and spec of the job of each address and the ID of its feed,
+	// keyed by `feedLabel`
+	jobIDs  map[string]string
+	specs   map[string]monitorSpec
+	feedIDs map[string]string
+}
+
+// jobName returns the name of the monitoring job of a label
+func (m *monitors) jobName(label string) string {
+	return m.name + " " + label
+}
+
+// init finds or creates the data source of the jobs and reads the jobs and feeds created before
+func (m *monitors) init() error {
+	m.limiter.read()
+	sources, resp, err := m.sources.List()
+	if err != nil {
+		return ns1Error(resp, err)
+	}
+	var source *data.Source
+	for _, s := range sources {
+		if s.Name == m.name && s.Type == monitorSourceType {
+			source = s
+			break
+		}
+	}
+	if source == nil {
+		source = data.NewSource(m.name, monitorSourceType)
+		m.limiter.write()
+		if resp, err := m.sources.Create(source); err != nil {
+			return ns1Error(resp, err)
+		}
+		m.log.Info("Created data source for monitoring jobs", "name", m.name, "id", source.ID)
+	}
+	m.limiter.read()
+	jobs, resp, err := m.jobs.List()
+	if err != nil {
+		return ns1Error(resp, err)
+	}
+	m.limiter.read()
+	feeds, resp, err := m.feeds.List(source.ID)
+	if err != nil {
+		return ns1Error(resp, err)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.sourceID, m.jobIDs, m.specs, m.feedIDs = source.ID, map[string]string{}, map[string]monitorSpec{}, map[string]string{}
+	labels := map[string]string{}
+	for _, job := range jobs {
+		if label := strings.TrimPrefix(job.Name, m.name+" "); label != job.Name {
+			m.jobIDs[label], m.specs[label] = job.ID, jobSpec(job)
+			labels[job.ID] = label
+		}
+	}
+	for _, feed := range feeds {
+		if id, ok := feed.Config["jobid"].(string); ok && labels[id] != "" {
+			m.feedIDs[labels[id]] = feed.ID
+		}
+	}
+	return nil
+}
+
+// sync creates, updates and deletes the monitoring jobs and their feeds to match the specs of the addresses of each
+// service, keyed by service and address. The jobs of services whose health couldn't be fetched are kept.
+func (m *monitors) sync(specs map[string]map[string]monitorSpec, services map[string]service) {
+	if m == nil {
+		return
+	}
+	m.lock.Lock()
+	desired := map[string]monitorSpec{}
+	for service, addresses := range specs {
+		for address, spec := range addresses {
+			desired[feedLabel(service, address)] = spec
+		}
+	}
+	gone := []string{}
+	for label := range m.jobIDs {
+		if _, ok := desired[label]; ok {
+			continue
+		}
+		service := label[:strings.LastIndex(label, "/")]
+		if _, ok := specs[service]; !ok {
+			if _, ok := services[service]; ok {
+				continue
+			}
+		}
+		gone = append(gone, label)
+	}
+	m.lock.Unlock()
+
+	labels := make([]string, 0, len(desired))
+	for label := range desired {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	for _, label := range labels {
+		m.writeJob(label, desired[label])
+	}
+	sort.Strings(gone)
+	for _, label := range gone {
+		m.deleteJob(label)
+	}
+}
+
+// writeJob creates or updates the monitoring job of a label, and creates its feed if it has none
+func (m *monitors) writeJob(label string, spec monitorSpec) {
+	m.lock.Lock()
+	id, exists := m.jobIDs[label]
+	current := m.specs[label]
+	_, connected := m.feedIDs[label]
+	sourceID := m.sourceID
+	m.lock.Unlock()
+	if !exists || current != spec {
+		job := &monitor.Job{
+			ID:          id,
+			Name:        m.jobName(label),
+			Type:        spec.jobType,
+			Config:      spec.config(),
+			Regions:     m.regions,
+			Active:      true,
+			Frequency:   spec.frequency,
+			Policy:      "quorum",
+			RegionScope: "fixed",
+			Notes:       "Mirrors a Consul check, managed by consul-ns1",
+		}
+		if spec.jobType == "http" {
+			job.Rules = []*monitor.Rule{{Key: "status_code", Comparison: "<", Value: 300}}
+		}
+		var resp *http.Response
+		var err error
+		m.limiter.write()
+		if exists {
+			resp, err = m.jobs.Update(job)
+		} else {
+			resp, err = m.jobs.Create(job)
+		}
+		if err != nil {
+			m.log.Error("cannot write monitoring job", "label", label, "error", err.Error())
+			countError(ns1Error(resp, err))
+			return
+		}
+		m.log.Debug("Wrote monitoring job", "label", label, "id", job.ID)
+		m.lock.Lock()
+		m.jobIDs[label], m.specs[label] = job.ID, spec
+		m.lock.Unlock()
+		id = job.ID
+	}
+	if connected {
+		return
+	}
+	feed := data.NewFeed(m.jobName(label), data.Config{"jobid": id})
+	m.limiter.write()
+	if resp, err := m.feeds.Create(sourceID, feed); err != nil {
+		m.log.Error("cannot create feed of monitoring job", "label", label, "error", err.Error())
+		countError(ns1Error(resp, err))
+		return
+	}
+	m.lock.Lock()
+	m.feedIDs[label] = feed.ID
+	m.lock.Unlock()
+}
+
+// deleteJob deletes the monitoring job of a label and its feed
+func (m *monitors) deleteJob(label string) {
+	m.lock.Lock()
+	id, feedID, sourceID := m.jobIDs[label], m.feedIDs[label], m.sourceID
+	m.lock.Unlock()
+	if feedID != "" {
+		m.limiter.write()
+		if resp, err := m.feeds.Delete(sourceID, feedID); err != nil {
+			m.log.Error("cannot delete feed of monitoring job", "label", label, "error", err.Error())
+			countError(ns1Error(resp, err))
+			return
+		}
+	}
+	m.limiter.write()
+	if resp, err := m.jobs.Delete(id); err != nil {
+		m.log.Error("cannot delete monitoring job", "label", label, "error", err.Error())
+		countError(ns1Error(resp, err))
+		m.lock.Lock()
+		delete(m.feedIDs, label)
+		m.lock.Unlock()
+		return
+	}
+	m.log.Debug("Deleted monitoring job", "label", label, "id", id)
+	m.lock.Lock()
+	delete(m.jobIDs, label)
+	delete(m.specs, label)
+	delete(m.feedIDs, label)
+	m.lock.Unlock()
+}
+
+// feedID returns the ID of the feed of the monitoring job of an address of a service
+func (m *monitors) feedID(service, address string) (string, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	id, ok := m.feedIDs[feedLabel(service, address)]
+	return id, ok
+}
@@ -0,0 +1,121 @@
+package catalog
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/monitor"
+)
+
+type mockMonitorJobService struct {
+	jobs    []*monitor.Job
+	created int
+	updated []string
+	deleted []string
+}
+
+func (m *mockMonitorJobService) List() ([]*monitor.Job, *http.Response, error) {
+	return m.jobs, nil, nil
+}
+
+func (m *mockMonitorJobService) Create(mj *monitor.Job) (*http.Response, error) {
+	m.created++
+	mj.ID = fmt.Sprintf("job%d", m.created)
+	m.jobs = append(m.jobs, mj)
+	return nil, nil
+}
+
+func (m *mockMonitorJobService) Update(mj *monitor.Job) (*http.Response, error) {
+	m.updated = append(m.updated, mj.ID)
+	return nil, nil
+}
+
+func (m *mockMonitorJobService) Delete(id string) (*http.Response, error) {
+	m.deleted = append(m.deleted, id)
+	return nil, nil
+}
+
+func TestCheckSpec(t *testing.T) {
+	table := map[string]struct {
+		definition consulapi.HealthCheckDefinition
+		address    string
+		expected   monitorSpec
+		ok         bool
+	}{
+		"http": {consulapi.HealthCheckDefinition{HTTP: "http://localhost:8080/health", IntervalDuration: time.Minute},
+			"1.1.1.1", monitorSpec{jobType: "http", url: "http://1.1.1.1:8080/health", method: "GET", frequency: 60}, true},
+		"http without port": {consulapi.HealthCheckDefinition{HTTP: "https://localhost/health", Method: "HEAD"},
+			"2001:db8::1", monitorSpec{jobType: "http", url: "https://[2001:db8::1]/health", method: "HEAD", frequency: 20}, true},
+		"tcp": {consulapi.HealthCheckDefinition{TCP: "localhost:5432", Interval: consulapi.ReadableDuration(30 * time.Second)},
+			"1.1.1.1", monitorSpec{jobType: "tcp", host: "1.1.1.1", port: 5432, frequency: 30}, true},
+		"ttl": {consulapi.HealthCheckDefinition{}, "1.1.1.1", monitorSpec{}, false},
+	}
+	for name, v := range table {
+		spec, ok := checkSpec(&consulapi.HealthCheck{Definition: v.definition}, v.address)
+		assert.Equal(t, v.ok, ok, fmt.Sprintf("Test case: %s", name))
+		if ok {
+			assert.Equal(t, v.expected, spec, fmt.Sprintf("Test case: %s", name))
+		}
+	}
+}
+
+func TestMonitorSpecs(t *testing.T) {
+	nodes := map[string]node{
+		"n1/web":  {aRecAnswer: "1.1.1.1"},
+		"n1/web2": {aRecAnswer: "1.1.1.1"},
+		"n2/web":  {aRecAnswer: "2.2.2.2"},
+	}
+	checks := consulapi.HealthChecks{
+		{Node: "n1", ServiceID: "web2", CheckID: "b", Definition: consulapi.HealthCheckDefinition{TCP: "localhost:81"}},
+		{Node: "n1", ServiceID: "web", CheckID: "a", Definition: consulapi.HealthCheckDefinition{TCP: "localhost:80"}},
+		{Node: "n2", ServiceID: "web", CheckID: "c", Definition: consulapi.HealthCheckDefinition{}},
+	}
+	specs := monitorSpecs(nodes, checks)
+	assert.Equal(t, map[string]monitorSpec{
+		"1.1.1.1": {jobType: "tcp", host: "1.1.1.1", port: 80, frequency: minMonitorFrequency},
+	}, specs)
+}
+
+func TestMonitors(t *testing.T) {
+	jobs := &mockMonitorJobService{jobs: []*monitor.Job{{ID: "old", Name: "consul-ns1 test.zone gone/3.3.3.3"}}}
+	feeds := &mockDataFeedService{feeds: []*data.Feed{{ID: "oldfeed", Config: data.Config{"jobid": "old"}}}}
+	m := &monitors{jobs: jobs, sources: &mockDataSourceService{}, feeds: feeds, log: hclog.NewNullLogger(),
+		name: "consul-ns1 test.zone", regions: defaultMonitorRegions}
+	require.NoError(t, m.init())
+
+	tcp := monitorSpec{jobType: "tcp", host: "1.1.1.1", port: 80, frequency: 20}
+	m.sync(map[string]map[string]monitorSpec{"web": {"1.1.1.1": tcp}}, map[string]service{"web": {}})
+	assert.Equal(t, 1, jobs.created)
+	job := jobs.jobs[1]
+	assert.Equal(t, "consul-ns1 test.zone web/1.1.1.1", job.Name)
+	assert.Equal(t, monitor.Config{"host": "1.1.1.1", "port": 80}, job.Config)
+	assert.Equal(t, defaultMonitorRegions, job.Regions)
+	id, ok := m.feedID("web", "1.1.1.1")
+	assert.True(t, ok)
+	assert.Equal(t, "feed1", id)
+	assert.Equal(t, []string{"old"}, jobs.deleted, "the jobs of services that are gone are deleted")
+	assert.Equal(t, []string{"oldfeed"}, feeds.deleted)
+
+	// unchanged jobs aren't written again, changed ones are updated
+	m.sync(map[string]map[string]monitorSpec{"web": {"1.1.1.1": tcp}}, map[string]service{"web": {}})
+	assert.Empty(t, jobs.updated)
+	tcp.port = 81
+	m.sync(map[string]map[string]monitorSpec{"web": {"1.1.1.1": tcp}}, map[string]service{"web": {}})
+	assert.Equal(t, []string{"job1"}, jobs.updated)
+	assert.Equal(t, 1, feeds.created)
+
+	// the jobs of a service whose health couldn't be fetched are kept
+	m.sync(map[string]map[string]monitorSpec{}, map[string]service{"web": {}})
+	assert.Equal(t, []string{"old"}, jobs.deleted)
+	m.sync(map[string]map[string]monitorSpec{"web": {}}, map[string]service{"web": {}})
+	assert.Equal(t, []string{"old", "job1"}, jobs.deleted)
+	_, ok = m.feedID("web", "1.1.1.1")
+	assert.False(t, ok)
+}
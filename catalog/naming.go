@@ -0,0 +1,171 @@
+package catalog
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// placeholders of domain templates
+const (
+	prefixPlaceholder    = "{prefix}"
+	zonePlaceholder      = "{zone}"
+	servicePlaceholder   = "{service}"
+	dcPlaceholder        = "{dc}"
+	tagPlaceholder       = "{tag}"
+	namespacePlaceholder = "{namespace}"
+)
+
+// placeholder matches the placeholders of a domain template
+var placeholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// domainTemplate names the records of services after a template, e.g. "{prefix}{service}.{dc}.{zone}". Services
+// are published under the name the template renders between the prefix and the zone, so records are read back
+// from NS1 as such, and records whose name doesn't match the template are out of scope.
+type domainTemplate struct {
+	// name is the part of the template between the prefix and the zone, e.g. "{service}.{dc}"
+	name string
+	// pattern matches the names rendered by the template
+	pattern *regexp.Regexp
+	// serviceGroup is the index of the submatch of `pattern` holding the service
+	serviceGroup int
+}
+
+// parseDomainTemplate parses and validates a domain template. The template must start with {prefix}, end with
+// .{zone} and hold {service} once, and {dc} and {tag} at most once. The default template, which names records
+// like without template, returns nil.
+func parseDomainTemplate(s string) (*domainTemplate, error) {
+	if s == "" || s == prefixPlaceholder+servicePlaceholder+"."+zonePlaceholder {
+		return nil, nil
+	}
+	if !strings.HasPrefix(s, prefixPlaceholder) || !strings.HasSuffix(s, "."+zonePlaceholder) {
+		return nil, fmt.Errorf("domain template %q must start with %s and end with .%s", s, prefixPlaceholder, zonePlaceholder)
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(s, prefixPlaceholder), "."+zonePlaceholder)
+	counts := map[string]int{}
+	for _, p := range placeholder.FindAllString(name, -1) {
+		switch p {
+		case servicePlaceholder, dcPlaceholder, tagPlaceholder:
+			counts[p]++
+		case namespacePlaceholder:
+			return nil, fmt.Errorf("%s isn't supported, the Consul API client doesn't know namespaces", p)
+		default:
+			return nil, fmt.Errorf("unknown placeholder %s in domain template %q", p, s)
+		}
+	}
+	if counts[servicePlaceholder] != 1 || counts[dcPlaceholder] > 1 || counts[tagPlaceholder] > 1 {
+		return nil, fmt.Errorf("domain template %q must hold %s once, and %s and %s at most once", s,
+			servicePlaceholder, dcPlaceholder, tagPlaceholder)
+	}
+	literals := placeholder.Split(name, -1)
+	for _, l := range literals {
+		if strings.Trim(l, "abcdefghijklmnopqrstuvwxyz0123456789-_.") != "" || strings.Contains(l, "..") {
+			return nil, fmt.Errorf("domain template %q holds invalid characters %q", s, l)
+		}
+	}
+	pattern, serviceGroup := "^", 0
+	for i, p := range placeholder.FindAllString(name, -1) {
+		pattern += regexp.QuoteMeta(literals[i])
+		if p == servicePlaceholder {
+			pattern += "(.+)"
+			serviceGroup = i + 1
+		} else {
+			pattern += "([^.]+)"
+		}
+	}
+	pattern += regexp.QuoteMeta(literals[len(literals)-1]) + "$"
+	return &domainTemplate{name: name, pattern: regexp.MustCompile(pattern), serviceGroup: serviceGroup}, nil
+}
+
+// has reports whether the template holds a placeholder
+func (t *domainTemplate) has(p string) bool {
+	return strings.Contains(t.name, p)
+}
+
+// render returns the name of a service in a datacenter, under a tag
+func (t *domainTemplate) render(service, dc, tag string) string {
+	return strings.NewReplacer(servicePlaceholder, service, dcPlaceholder, dc, tagPlaceholder, tag).Replace(t.name)
+}
+
+// matches reports whether a name, without prefix and zone, was rendered by the template. All names match without
+// template.
+func (t *domainTemplate) matches(name string) bool {
+	if t == nil {
+		return true
+	}
+	return t.pattern.MatchString(name)
+}
+
+// service returns the service a name, without prefix and zone, was rendered for, or the name itself if the
+// template didn't render it
+func (t *domainTemplate) service(name string) string {
+	m := t.pattern.FindStringSubmatch(name)
+	if m == nil {
+		return name
+	}
+	return m[t.serviceGroup]
+}
+
+// applyDomainTemplate renames services after the domain template. With {dc} or {tag}, the instances of a service
+// are split between a service per datacenter or per tag: instances without datacenter or tags aren't published,
+// instances with several tags are published under each of them, like Consul DNS does, and virtual-hosted services
+// aren't published. Services rendered to
+// the same name are reported and the first of them by name is published. `tags` holds the tags of the instances
+// of each service, keyed by service and `instanceKey`.
+func (c *consul) applyDomainTemplate(services map[string]service, tags map[string]map[string][]string) map[string]service {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	result := make(map[string]service, len(services))
+	owners := map[string]string{}
+	for _, k := range names {
+		s := services[k]
+		renamed := map[string]map[string]node{}
+		split := c.naming.has(dcPlaceholder) || c.naming.has(tagPlaceholder)
+		if !split {
+			renamed[c.naming.render(k, "", "")] = s.nodes
+		} else if s.cnameRecAnswer != "" {
+			c.log.Warn("virtual-hosted services have no datacenter or tags to render the domain template, skipping",
+				"service", k)
+			continue
+		}
+		for key, n := range s.nodes {
+			if !split {
+				break
+			}
+			dc := ""
+			if c.naming.has(dcPlaceholder) {
+				if dc = srvTargetLabel(n.datacenter); n.datacenter == "" {
+					continue
+				}
+			}
+			instanceTags := []string{""}
+			if c.naming.has(tagPlaceholder) {
+				instanceTags = nil
+				for _, tag := range tags[k][key] {
+					instanceTags = append(instanceTags, srvTargetLabel(tag))
+				}
+			}
+			for _, tag := range instanceTags {
+				name := c.naming.render(k, dc, tag)
+				if renamed[name] == nil {
+					renamed[name] = map[string]node{}
+				}
+				renamed[name][key] = n
+			}
+		}
+		for name, nodes := range renamed {
+			if owner, ok := owners[name]; ok {
+				c.log.Warn("services are rendered to the same name by the domain template, publishing the first",
+					"name", name, "service", k, "published", owner)
+				continue
+			}
+			owners[name] = k
+			result[name] = c.subsetService(s, name, nodes)
+		}
+	}
+	return result
+}
@@ -0,0 +1,140 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestParseDomainTemplate(t *testing.T) {
+	table := map[string]struct {
+		template string
+		name     string
+		err      bool
+	}{
+		"empty":             {"", "", false},
+		"default":           {"{prefix}{service}.{zone}", "", false},
+		"datacenter":        {"{prefix}{service}.{dc}.{zone}", "{service}.{dc}", false},
+		"tag":               {"{prefix}{tag}.{service}.{zone}", "{tag}.{service}", false},
+		"literal":           {"{prefix}{service}-svc.{dc}.{zone}", "{service}-svc.{dc}", false},
+		"no prefix":         {"{service}.{zone}", "", true},
+		"no zone":           {"{prefix}{service}", "", true},
+		"no service":        {"{prefix}{dc}.{zone}", "", true},
+		"twice the service": {"{prefix}{service}.{service}.{zone}", "", true},
+		"twice the dc":      {"{prefix}{service}.{dc}.{dc}.{zone}", "", true},
+		"namespace":         {"{prefix}{service}.{namespace}.{zone}", "", true},
+		"unknown":           {"{prefix}{service}.{region}.{zone}", "", true},
+		"invalid literal":   {"{prefix}{service}.Web!.{zone}", "", true},
+		"empty label":       {"{prefix}{service}..{dc}.{zone}", "", true},
+	}
+	for name, v := range table {
+		tmpl, err := parseDomainTemplate(v.template)
+		if v.err {
+			assert.Error(t, err, fmt.Sprintf("Test case: %s", name))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("Test case: %s", name))
+		if v.name == "" {
+			assert.Nil(t, tmpl, fmt.Sprintf("Test case: %s", name))
+			continue
+		}
+		require.NotNil(t, tmpl, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.name, tmpl.name, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestDomainTemplate_Matches(t *testing.T) {
+	tmpl, err := parseDomainTemplate("{prefix}{service}.{dc}.{zone}")
+	require.NoError(t, err)
+	assert.Equal(t, "web.dc1", tmpl.render("web", "dc1", ""))
+	assert.True(t, tmpl.matches("web.dc1"))
+	assert.True(t, tmpl.matches("node.web.dc1"))
+	assert.False(t, tmpl.matches("web"))
+	assert.False(t, tmpl.matches("web.dc1.extra."))
+
+	tmpl, err = parseDomainTemplate("{prefix}{tag}.{service}-svc.{zone}")
+	require.NoError(t, err)
+	assert.Equal(t, "v2.web-svc", tmpl.render("web", "", "v2"))
+	assert.True(t, tmpl.matches("v2.web-svc"))
+	assert.False(t, tmpl.matches("v2.web"))
+	assert.Equal(t, "web", tmpl.service("v2.web-svc"))
+	assert.Equal(t, "other", tmpl.service("other"), "names the template didn't render are their own service")
+
+	var none *domainTemplate
+	assert.True(t, none.matches("anything"))
+}
+
+func TestApplyDomainTemplate(t *testing.T) {
+	instance := func(dc, address string) node {
+		return node{host: "h-" + address, datacenter: dc, address: address, port: 80, aRecAnswer: address,
+			srvRecAnswers: map[int]srvAnswer{80: {priority: 1, weight: 1, port: 80, address: address}}}
+	}
+	services := func() map[string]service {
+		return map[string]service{
+			"web": {id: "web", name: "web", consulID: "web", nodes: map[string]node{
+				"h1/web": instance("dc1", "1.1.1.1"),
+				"h2/web": instance("dc1", "2.2.2.2"),
+				"h3/web": instance("DC_2", "3.3.3.3"),
+				"h4/web": instance("", "4.4.4.4"),
+			}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+			"api": {id: "api", name: "api", consulID: "api", cnameRecAnswer: "ingress.example.com",
+				ttls: recordTTLs{cnameRecTTL: 10}},
+		}
+	}
+	tags := map[string]map[string][]string{"web": {
+		"h1/web": {"v1", "primary"},
+		"h2/web": {"v2"},
+		"h3/web": {"v2"},
+	}}
+
+	c := consul{log: hclog.NewNullLogger(), dnsTTL: 10, addressFamily: ipv4Family}
+	c.naming, _ = parseDomainTemplate("{prefix}{service}.{dc}.{zone}")
+	renamed := c.applyDomainTemplate(services(), tags)
+	assert.Len(t, renamed, 2)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, aAnswers(renamed["web.dc1"].nodes))
+	assert.Equal(t, "web.dc1", renamed["web.dc1"].name)
+	assert.Equal(t, "web", renamed["web.dc1"].consulID)
+	assert.Equal(t, []string{"3.3.3.3"}, aAnswers(renamed["web.dc-2"].nodes))
+	// virtual-hosted services have no datacenter
+	assert.NotContains(t, renamed, "api.")
+
+	c.naming, _ = parseDomainTemplate("{prefix}{tag}.{service}.{zone}")
+	renamed = c.applyDomainTemplate(services(), tags)
+	assert.Equal(t, []string{"1.1.1.1"}, aAnswers(renamed["v1.web"].nodes))
+	assert.Equal(t, []string{"1.1.1.1"}, aAnswers(renamed["primary.web"].nodes))
+	assert.Equal(t, []string{"2.2.2.2", "3.3.3.3"}, aAnswers(renamed["v2.web"].nodes))
+
+	c.naming, _ = parseDomainTemplate("{prefix}{service}-svc.{zone}")
+	renamed = c.applyDomainTemplate(services(), tags)
+	assert.Len(t, renamed, 2)
+	assert.Len(t, renamed["web-svc"].nodes, 4)
+	assert.Equal(t, "ingress.example.com", renamed["api-svc"].cnameRecAnswer)
+}
+
+func TestTransformZoneRecords_DomainTemplate(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), dnsTTL: 10, addressFamily: ipv4Family}
+	c.naming, _ = parseDomainTemplate("{prefix}{service}.{dc}.{zone}")
+	services := c.applyDomainTemplate(map[string]service{
+		"web": {id: "web", name: "web", consulID: "web", nodes: map[string]node{
+			"h1/web": {host: "h1", datacenter: "dc1", address: "1.1.1.1", port: 80, aRecAnswer: "1.1.1.1",
+				srvRecAnswers: map[int]srvAnswer{80: {priority: 1, weight: 1, port: 80, address: "1.1.1.1"}}},
+		}},
+	}, nil)
+
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, ns1Prefix: "p-", addressFamily: ipv4Family,
+		naming: c.naming, log: hclog.NewNullLogger()}
+	z := &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+		{Domain: "p-web.dc1.test.zone", ID: "1", Type: "A", TTL: 10, ShortAns: []string{"1.1.1.1"}},
+		{Domain: "p-web.dc1.test.zone", ID: "2", Type: "SRV", TTL: 10, ShortAns: []string{"1 1 80 1.1.1.1"}},
+		// records the template doesn't render are out of scope
+		{Domain: "p-legacy.test.zone", ID: "3", Type: "A", TTL: 10, ShortAns: []string{"9.9.9.9"}},
+	}}
+	actual := n.transformZoneRecords(z)
+	assert.Len(t, actual, 1)
+	assert.Empty(t, onlyInFirst(services, actual))
+	assert.Empty(t, serviceOnlyInFirst(actual, services))
+}
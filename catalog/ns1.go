@@ -9,8 +9,12 @@ import (
 	"sync/atomic"
 	"time"
 
+	metrics "github.com/armon/go-metrics"
 	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/diff"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
 	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
 )
 
 type zone struct {
@@ -32,27 +36,140 @@ type recordService interface {
 type ns1APIClient struct {
 	Zones   zoneService
 	Records recordService
+	// Warnings and Stats are only used to check account usage
+	Warnings warningService
+	Stats    statsService
+	// Search is only used to poll the records under the service prefix, nil to poll the whole zone
+	Search searchService
+	// ZoneCreator is only used to create the service zone when it's missing, nil to fail instead
+	ZoneCreator zoneCreateService
 }
 
 type ns1 struct {
-	client       *ns1APIClient
-	log          hclog.Logger
-	serviceZone  zone
-	ns1Prefix    string
-	services     map[string]service
-	trigger      chan bool
-	lock         sync.RWMutex
+	client      *ns1APIClient
+	log         hclog.Logger
+	serviceZone zone
+	ns1Prefix   string
+	services    map[string]service
+	trigger     chan bool
+	lock        sync.RWMutex
+	// pollLock serializes polls, as resyncs poll next to the fetch loop, see `poll`
+	pollLock     sync.Mutex
 	pollInterval time.Duration
-	dnsTTL       int64
+	// tuner overrides pollInterval at runtime, nil if it can't be changed
+	tuner         *pollTuner
+	dnsTTL        int64
+	portHints     bool
+	quota         quota
+	addressFamily addressFamily
+	// answerSeed ranks the answers of each record in a stable pseudo-random order, empty to sort them
+	answerSeed string
+	// naming names services after a domain template, records whose name it doesn't render are out of scope, nil
+	// if services are named after themselves
+	naming *domainTemplate
+	// selection leaves the records of services it doesn't select out of scope, nil to manage the records of all
+	// services
+	selection *serviceSelection
+	// ownershipRegistry marks every managed service with a TXT record naming this instance as its owner
+	ownershipRegistry bool
+	// recordMarker marks every record written with `managedMarker` in its note and only deletes marked records
+	recordMarker   bool
+	conflictPolicy conflictPolicy
+	// instanceCountMeta publishes the number of healthy instances of each service in the note of its records
+	instanceCountMeta bool
+	// instanceCounts holds the instance count last written for each service
+	instanceCounts     map[string]int
+	instanceCountsLock sync.Mutex
+	// ttlPassDone is set once the TTL-only changes found on startup were applied
+	ttlPassDone bool
+	// adoptionReported is set once unmarked records were reported on the first cycle
+	adoptionReported bool
+	// freezeWindows are the periods during which no changes are written to NS1
+	freezeWindows freezeWindows
+	// approval holds back changes above a threshold until approved, nil if approval mode is disabled
+	approval *approvalGate
+
+	// pausedUntil is set when NS1 asks clients to back off via Retry-After, writes are skipped until then
+	pausedUntil     time.Time
+	maintenanceLock sync.Mutex
+
+	// clock is the clock of timing-dependent behaviour, nil is the real clock
+	clock *clock
+	// registryGCInterval is the interval between garbage collections of the ownership registry, 0 disables them
+	registryGCInterval time.Duration
+	// journal records the mutations of a sync cycle while they are applied, nil if disabled
+	journal *journal
+	// events delivers the changes applied by each sync cycle to the admin API, nil if disabled
+	events *eventStream
+	// churn counts the changes of each service and reports flapping services, nil if disabled
+	churn *churn
+	// settings carries the answer settings not managed by consul-ns1 over updates, nil to rewrite answers from scratch
+	settings *answerSettings
+	// cycle keeps polls from reading the zone while a sync cycle is writing to it, nil to poll regardless
+	cycle *syncCycle
+	// latency measures the time between observing changes in Consul and writing them, see `syncLatency`
+	latency *syncLatency
+	// edits applies the edit policy to records edited outside of consul-ns1, nil to overwrite them
+	edits *editGuard
+	// coManaged leaves the answers of records not written by this instance alone, nil to own all answers
+	coManaged *coManaged
+	// weights remembers the weights of the A and AAAA answers written, nil if answers aren't weighted
+	weights *answerWeights
+	// states remembers the answers written down, nil if answers aren't marked down
+	states *answerStates
+	// geos remembers the locations of the A and AAAA answers written, nil if locations aren't published
+	geos *answerGeos
+	// versions remembers the versions of the A and AAAA answers written, nil if versions aren't noted
+	versions *answerVersions
+	// geotargetRegional appends the geotarget_regional filter to the records of located answers
+	geotargetRegional bool
+	// regions remembers the regions of the answers written, nil if answers aren't grouped in regions
+	regions *answerRegions
+	// georegions holds the georegion of the region of each datacenter
+	georegions map[string]string
+	// syncers coordinates the instances sharing co-managed records, nil if they're not coordinated
+	syncers *syncers
+	// feeds returns the data feeds the up meta of answers points to, nil if answers aren't connected to feeds,
+	// see `upFeeds` and `monitors`
+	feeds upConnector
+	// empty applies the empty answer policy to updates removing all the answers of a record
+	empty *emptyGuard
+	// filters is the default filter chain of the records created, replaced by the filters of the template record
+	filters []*filter.Filter
+	// clientSubnet enables or disables EDNS Client Subnet on the records created, nil for the default of NS1
+	clientSubnet *bool
+	// filterOverrides tracks the filter chains of services overriding filters, nil to leave the chain of existing
+	// records alone
+	filterOverrides *filterOverrides
+	// template clones the routing of a template record onto the records created, nil to create them without template
+	template *recordTemplate
+	// limiter shares the NS1 API quota between polls and writes, nil to send requests unlimited
+	limiter *apiLimiter
+	// store keeps the sync state across restarts, nil to keep it in memory only
+	store stateStore
+	// healRegistry garbage collects the ownership registry on the next resync, e.g. after acquiring leadership
+	healRegistry bool
+	// accountLimits watches account usage against the limits of the NS1 plan
+	accountLimits accountLimits
+	// fetchBeat is beaten by the fetch loop
+	fetchBeat heartbeat
+
+	// drift detects changes of managed records that didn't originate from this instance
+	drift driftDetector
 }
 
-// setupServiceZone attempts to fetch a zone and store it's metadata to use when sync'ing services
+// setupServiceZone attempts to fetch a zone and store it's metadata to use when sync'ing services.
+// A missing zone is created if the client can create zones.
 func (n *ns1) setupServiceZone(zoneName string) error {
 	zone, err := n.fetchZone(zoneName)
+	if ErrorClass(err) == ErrZoneMissing && n.client.ZoneCreator != nil {
+		zone, err = n.createZone(zoneName)
+	}
 	if err != nil {
 		return err
 	}
 	n.serviceZone = n.transformZone(zone)
+	n.warnOverlappingOwners(zone)
 	return nil
 }
 
@@ -73,38 +190,162 @@ func (n *ns1) setServices(services map[string]service) {
 
 // fetch queries records from the service zone and updates the local `services` cache
 func (n *ns1) fetch() error {
+	_, err := n.poll()
+	return err
+}
+
+// poll fetches like `fetch` and reports whether managed records were changed outside of consul-ns1.
+// Zones fetched while a sync cycle was writing are discarded with errPollOverlapped, see `syncCycle`.
+// Polls run one at a time, so a slow poll never overwrites the zone of a later one, e.g. of a resync.
+func (n *ns1) poll() (bool, error) {
+	n.pollLock.Lock()
+	defer n.pollLock.Unlock()
 	n.log.Debug("Performing fetch from NS1", "zone", n.serviceZone.name)
-	zone, err := n.fetchZone(n.serviceZone.name)
+	start := n.clock.Now()
+	gen := n.cycle.current()
+	var zone *dns.Zone
+	var err error
+	if n.client.Search != nil {
+		zone, err = n.searchZone()
+	} else {
+		zone, err = n.fetchZone(n.serviceZone.name)
+	}
 	if err != nil {
-		return err
+		return false, err
 	}
-	services := n.transformZoneRecords(zone)
-	n.setServices(services)
-	return nil
+	var drifted bool
+	settled := n.cycle.settle(gen, func() {
+		drifted = n.detectDrift(zone, start)
+		if n.client.Search == nil {
+			n.accountLimits.observeRecords(len(zone.Records))
+		}
+		n.setServices(n.transformZoneRecords(zone))
+	})
+	if !settled {
+		return false, errPollOverlapped
+	}
+	return drifted, nil
 }
 
 // fetchZone retrieves a zone from NS1
 func (n *ns1) fetchZone(zoneName string) (*dns.Zone, error) {
-	ns1Zone, _, err := n.client.Zones.Get(zoneName)
+	n.limiter.read()
+	ns1Zone, resp, err := n.client.Zones.Get(zoneName)
 	if err != nil {
+		err = ns1Error(resp, err)
+		if d, ok := retryAfter(resp, n.clock.Now()); ok {
+			return nil, &retryAfterError{err: err, wait: d}
+		}
 		return nil, err
 	}
 	return ns1Zone, nil
 }
 
+// retryAfterError wraps an error returned by NS1 along with the duration it asked clients to wait
+type retryAfterError struct {
+	err  error
+	wait time.Duration
+}
+
+func (e *retryAfterError) Error() string {
+	return fmt.Sprintf("%s (retry after %s)", e.err, e.wait)
+}
+
+// Unwrap returns the error returned by NS1
+func (e *retryAfterError) Unwrap() error {
+	return e.err
+}
+
+// retryAfter returns the duration NS1 asked clients to wait when it responds with a 5xx status
+// and a Retry-After header. The header may contain either a number of seconds or an HTTP date.
+func retryAfter(resp *http.Response, now time.Time) (time.Duration, bool) {
+	if resp == nil || resp.StatusCode < 500 {
+		return 0, false
+	}
+	h := resp.Header.Get("Retry-After")
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		d := t.Sub(now)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
+
+// pauseWrites stops writes to NS1 for duration d, e.g. during a provider maintenance window
+func (n *ns1) pauseWrites(d time.Duration) {
+	until := n.clock.Now().Add(d)
+	n.maintenanceLock.Lock()
+	if until.After(n.pausedUntil) {
+		n.pausedUntil = until
+		n.log.Warn("NS1 asked to retry later, pausing writes", "retry-after", d.String())
+	}
+	n.maintenanceLock.Unlock()
+	metrics.SetGauge([]string{"ns1", "maintenance"}, 1)
+}
+
+// writesPaused reports whether writes to NS1 are currently paused
+func (n *ns1) writesPaused() bool {
+	n.maintenanceLock.Lock()
+	paused := n.clock.Now().Before(n.pausedUntil)
+	n.maintenanceLock.Unlock()
+	if !paused {
+		metrics.SetGauge([]string{"ns1", "maintenance"}, 0)
+	}
+	return paused
+}
+
 // transformZone transforms a NS1 zone into a zone required by local cache
 func (n *ns1) transformZone(ns1Zone *dns.Zone) zone {
 	return zone{id: ns1Zone.ID, name: ns1Zone.Zone}
 }
 
-// transformZoneRecords transforms records in a NS1 zone into a map of services
+// transformZoneRecords transforms records in a NS1 zone into a map of services.
+// Only records in scope of this instance are considered, see `inScope`.
 func (n *ns1) transformZoneRecords(ns1Zone *dns.Zone) map[string]service {
 	services := map[string]service{}
+	owners := zoneOwners(ns1Zone)
 	for _, record := range ns1Zone.Records {
-		if record.Type != "A" && record.Type != "SRV" {
+		if isOwnerRecord(record) {
+			if n.ownershipRegistry {
+				n.transformOwnerTXTRecord(record, services)
+			}
+			continue
+		}
+		if !n.inScope(record.Domain, owners) {
+			n.log.Debug("Record out of scope of this instance, ignoring", "domain", record.Domain, "type", record.Type)
+			continue
+		}
+		if record.Type == "TXT" && n.portHints {
+			n.transformPortsTXTRecord(record, services)
+			continue
+		}
+		if record.Type == "CNAME" {
+			n.transformCNAMERecord(record, services)
+			continue
+		}
+		if record.Type == "HTTPS" {
+			n.transformHTTPSRecord(record, services)
+			continue
+		}
+		if record.Type != "A" && record.Type != "SRV" && !(record.Type == "AAAA" && n.addressFamily.v6()) {
 			n.log.Debug("Non-service record type found in zone, ignoring", "ID", fmt.Sprintf("%s", record.ID))
 			continue
 		}
+		if n.template.isTemplate(record.Domain) {
+			continue
+		}
+		if n.coManaged.unowned(record) {
+			n.log.Debug("Co-managed record without answers written by this instance, ignoring", "domain", record.Domain, "type", record.Type)
+			continue
+		}
 		// Trim zone name and prefix, if applicable
 		serviceName := strings.TrimPrefix(record.Domain, n.ns1Prefix)
 		serviceName = strings.TrimSuffix(serviceName, "."+n.serviceZone.name)
@@ -120,25 +361,50 @@ func (n *ns1) transformZoneRecords(ns1Zone *dns.Zone) map[string]service {
 		if record.Type == "A" {
 			svc.ns1IDs.aRecID = record.ID
 			svc.ttls.aRecTTL = int64(record.TTL)
+		} else if record.Type == "AAAA" {
+			svc.ns1IDs.aaaaRecID = record.ID
+			svc.ttls.aaaaRecTTL = int64(record.TTL)
 		} else if record.Type == "SRV" {
 			svc.ns1IDs.srvRecID = record.ID
 			svc.ttls.srvRecTTL = int64(record.TTL)
 		}
+		if key := n.filterOverrides.key(record.Domain, record.Type); key != "" {
+			if svc.filterKeys == nil {
+				svc.filterKeys = map[diff.Family]string{}
+			}
+			svc.filterKeys[diff.Family(record.Type)] = key
+		}
 		// Populate node
 		if len(record.ShortAns) > 0 && svc.nodes == nil {
 			svc.nodes = map[string]node{}
 		}
-		for _, ans := range record.ShortAns {
+		for _, ans := range n.coManaged.ownAnswers(record.Domain, record.Type, record.ShortAns) {
 			var address string
 			ansFields := strings.Fields(ans)
+			if record.Type == "SRV" && len(ansFields) != 4 {
+				n.log.Debug("Malformed SRV answer found in zone, ignoring", "domain", record.Domain, "answer", ans)
+				continue
+			}
 			if len(ansFields) == 4 {
-				address = ansFields[3]
+				// SRV targets are IPv4 or IPv6 addresses, or hostnames with -ns1-srv-target-hostnames
+				address = canonicalAddress(ansFields[3])
+			} else if len(ansFields) > 0 {
+				address = canonicalAddress(ansFields[0])
 			} else {
-				address = ansFields[0]
+				continue
+			}
+			// answers written down belong to a node of their own, so an address can have answers both up and down
+			key, answer := address, address
+			if len(ansFields) == 4 {
+				answer = strings.Join(append(ansFields[:3:3], address), " ")
+			}
+			down := n.states.isDown(record.Domain, record.Type, answer)
+			if down {
+				key += " up=false"
 			}
 
 			var ansNode node
-			if n, ok := svc.nodes[address]; !ok {
+			if n, ok := svc.nodes[key]; !ok {
 				ansNode = node{}
 			} else {
 				ansNode = n
@@ -146,6 +412,14 @@ func (n *ns1) transformZoneRecords(ns1Zone *dns.Zone) map[string]service {
 
 			if record.Type == "A" {
 				ansNode.aRecAnswer = address
+				ansNode.answerWeight = n.weights.weight(record.Domain, record.Type, address)
+				ansNode.geo = n.geos.geo(record.Domain, record.Type, address)
+				ansNode.version = n.versions.version(record.Domain, record.Type, address)
+			} else if record.Type == "AAAA" {
+				ansNode.aaaaRecAnswer = address
+				ansNode.answerWeight = n.weights.weight(record.Domain, record.Type, address)
+				ansNode.geo = n.geos.geo(record.Domain, record.Type, address)
+				ansNode.version = n.versions.version(record.Domain, record.Type, address)
 			} else if record.Type == "SRV" && len(ansFields) == 4 {
 				if ansNode.srvRecAnswers == nil {
 					ansNode.srvRecAnswers = map[int]srvAnswer{}
@@ -172,7 +446,11 @@ func (n *ns1) transformZoneRecords(ns1Zone *dns.Zone) map[string]service {
 					address:  address,
 				}
 			}
-			svc.nodes[address] = ansNode
+			ansNode.down = down
+			if region := n.regions.region(record.Domain, record.Type, answer); region != "" {
+				ansNode.region = region
+			}
+			svc.nodes[key] = ansNode
 		}
 
 		services[serviceName] = svc
@@ -180,24 +458,119 @@ func (n *ns1) transformZoneRecords(ns1Zone *dns.Zone) map[string]service {
 	return services
 }
 
-// upsertRecord creates a DNS record, if no ID is given.
-// If an ID is given, it updates an existing record.
+// transformPortsTXTRecord adds a TXT record holding port hints to the service it belongs to.
+// TXT records without a port hints answer are ignored.
+func (n *ns1) transformPortsTXTRecord(record *dns.ZoneRecord, services map[string]service) {
+	answer, ok := n.portsTXTAnswer(record)
+	if !ok {
+		n.log.Debug("TXT record without port hints found in zone, ignoring", "ID", record.ID)
+		return
+	}
+	serviceName := strings.TrimPrefix(record.Domain, n.ns1Prefix)
+	serviceName = strings.TrimSuffix(serviceName, "."+n.serviceZone.name)
+	svc, ok := services[serviceName]
+	if !ok {
+		svc = service{name: serviceName}
+	}
+	svc.ns1IDs.txtRecID = record.ID
+	svc.ttls.txtRecTTL = int64(record.TTL)
+	svc.txtRecAnswer = answer
+	services[serviceName] = svc
+}
+
+// transformOwnerTXTRecord adds an ownership TXT record to the service it marks.
+// Records marking services of other instances are ignored.
+func (n *ns1) transformOwnerTXTRecord(record *dns.ZoneRecord, services map[string]service) {
+	prefix, ok := parseOwnerTXTAnswer(record.ShortAns)
+	if !ok || prefix != n.ns1Prefix {
+		return
+	}
+	serviceName := strings.TrimPrefix(record.Domain, ownerRecordLabel+n.ns1Prefix)
+	serviceName = strings.TrimSuffix(serviceName, "."+n.serviceZone.name)
+	if !n.selection.selectsRecords(serviceName) {
+		return
+	}
+	svc, ok := services[serviceName]
+	if !ok {
+		svc = service{name: serviceName}
+	}
+	svc.ns1IDs.ownerRecID = record.ID
+	svc.ownerRecAnswer = ownerTXTAnswer(prefix)
+	services[serviceName] = svc
+}
+
+// portsTXTAnswer returns the port hints answer of a TXT record
+func (n *ns1) portsTXTAnswer(record *dns.ZoneRecord) (string, bool) {
+	if !n.portHints {
+		return "", false
+	}
+	for _, ans := range record.ShortAns {
+		ans = strings.Trim(ans, "\"")
+		if strings.HasPrefix(ans, portsTXTPrefix) {
+			return ans, true
+		}
+	}
+	return "", false
+}
+
+// upsertRecord creates a DNS record, if no ID is given and the record has none.
+// Otherwise, it updates an existing record. A create of a record that already exists is retried as an update,
+// and an update of a record that doesn't exist anymore as a create, so the record is written in this cycle
+// rather than after the next poll.
 func (n *ns1) upsertRecord(id string, rec *dns.Record) error {
 	var err error
-	if id == "" {
+	var resp *http.Response
+	n.markRecord(rec)
+	n.limiter.write()
+	if id == "" && rec.ID == "" {
 		n.log.Debug("Creating record", "domain", rec.Domain, "type", rec.Type, "Answers", rec.Answers)
-		_, err = n.client.Records.Create(rec)
+		resp, err = n.client.Records.Create(rec)
+		if err == ns1api.ErrRecordExists && n.overwritesUnknownRecords() {
+			n.log.Info("record already exists, updating it instead", "domain", rec.Domain, "type", rec.Type)
+			metrics.IncrCounterWithLabels([]string{"ns1", "write_retried"}, 1, []metrics.Label{{Name: "as", Value: "update"}})
+			n.limiter.write()
+			resp, err = n.client.Records.Update(rec)
+		}
 	} else {
 		n.log.Debug("Updating record", "domain", rec.Domain, "type", rec.Type, "Answers", rec.Answers, "Filters", rec.Filters)
-		_, err = n.client.Records.Update(rec)
+		resp, err = n.client.Records.Update(rec)
+		if err == ns1api.ErrRecordMissing {
+			n.log.Info("record doesn't exist anymore, creating it instead", "domain", rec.Domain, "type", rec.Type)
+			metrics.IncrCounterWithLabels([]string{"ns1", "write_retried"}, 1, []metrics.Label{{Name: "as", Value: "create"}})
+			rec.ID = ""
+			n.limiter.write()
+			resp, err = n.client.Records.Create(rec)
+		}
 	}
 	if err != nil {
-		return err
+		if d, ok := retryAfter(resp, n.clock.Now()); ok {
+			n.pauseWrites(d)
+		}
+		return ns1Error(resp, err)
 	}
 
 	return nil
 }
 
+// overwritesUnknownRecords reports whether a record created since the last poll may be overwritten. Co-managed
+// records hold the answers of other instances, and records not owned in the ownership registry are only
+// overwritten with the adopt conflict policy.
+func (n *ns1) overwritesUnknownRecords() bool {
+	if n.coManaged != nil {
+		return false
+	}
+	return !n.ownershipRegistry || n.conflictPolicy == adoptConflicts
+}
+
+// recordTTL returns the TTL a record of a service is written with: the TTL it is diffed with, e.g. overridden or
+// jittered, or else the default TTL
+func (n *ns1) recordTTL(ttl int64) int {
+	if ttl > 0 {
+		return int(ttl)
+	}
+	return int(n.dnsTTL)
+}
+
 // generateRecord creates a new dns.Record struct for a service of type t.
 // If no id is given a new struct with default values is returned.
 // If an id is given, record values are fetched from NS1. Existing answers will be removed and TTL will be overwritten.
@@ -205,121 +578,456 @@ func (n *ns1) generateRecord(id, name, t string) (*dns.Record, error) {
 	var err error
 	domain := name + "." + n.serviceZone.name
 	rec := &dns.Record{}
+	if id == "" {
+		// co-managed records may exist without answers written by this instance
+		id = n.coManaged.recordID(domain, t)
+	}
 	if id == "" {
 		rec = dns.NewRecord(n.serviceZone.name, domain, t)
+		setFilters(rec, n.filters)
+		setClientSubnet(rec, n.clientSubnet)
+		n.template.apply(rec)
 	} else {
+		n.limiter.read()
 		rec, _, err = n.client.Records.Get(n.serviceZone.name, domain, t)
 		if err != nil {
+			n.coManaged.deleted(domain, t)
 			return nil, err
 		}
+		n.empty.fetched(rec)
+		n.settings.fetched(rec)
+		rec.Answers = n.coManaged.fetched(rec)
+		n.edits.fetched(rec)
 	}
 	rec.Answers = []*dns.Answer{}
 	rec.TTL = int(n.dnsTTL)
 	return rec, nil
 }
 
-// Create creates or updates records in NS1 for a set of services. Returns the number of created or updated records.
+// Create creates or updates records in NS1 for a set of services. Records flagged as unchanged are skipped.
+// Returns the number of created or updated records.
 func (n *ns1) create(services map[string]service) int32 {
 	wg := sync.WaitGroup{}
 	var count int32
+	if len(services) > 0 && n.writesPaused() {
+		n.log.Info("NS1 writes are paused, skipping upserts", "count", len(services))
+		return count
+	}
+	n.removeConflictingRecords(services)
+	n.template.reset()
+	// mark services before writing their records, so an interrupted cycle never leaves unmarked records behind
+	if n.ownershipRegistry {
+		for k, s := range services {
+			name := n.ns1Prefix + k
+			if !s.unchanged.ownerRec && s.ownerRecAnswer != "" {
+				ownerRec, err := n.generateRecord(s.ns1IDs.ownerRecID, ownerRecordLabel+name, "TXT")
+				if err != nil {
+					n.log.Error("cannot fetch ownership record for service, generating new record", "name", name, "id", s.ns1IDs.ownerRecID, "error", err.Error())
+					ownerRec, _ = n.generateRecord("", ownerRecordLabel+name, "TXT")
+				}
+				ownerRec.AddAnswer(dns.NewTXTAnswer(s.ownerRecAnswer))
+				wg.Add(1)
+				go n.upsertRecordWorker(&wg, s.ns1IDs.ownerRecID, ownerRec, &count)
+			}
+		}
+		wg.Wait()
+	}
 	for k, s := range services {
 		name := n.ns1Prefix + k
-		aRec, err := n.generateRecord(s.ns1IDs.aRecID, name, "A")
-		if err != nil {
-			n.log.Error("cannot fetch A record for service, generating new record", "name", name, "id", s.ns1IDs.aRecID, "error", err.Error())
-			aRec, _ = n.generateRecord("", name, "A")
+		// a CNAME can't coexist with other records of the same name
+		vhost := s.cnameRecAnswer != ""
+		if vhost && !s.unchanged.cnameRec {
+			cnameRec, err := n.generateRecord(s.ns1IDs.cnameRecID, name, "CNAME")
+			if err != nil {
+				n.log.Error("cannot fetch CNAME record for service, generating new record", "name", name, "id", s.ns1IDs.cnameRecID, "error", err.Error())
+				cnameRec, _ = n.generateRecord("", name, "CNAME")
+			}
+			cnameRec.TTL = n.recordTTL(s.ttls.cnameRecTTL)
+			cnameRec.AddAnswer(dns.NewCNAMEAnswer(s.cnameRecAnswer))
+			wg.Add(1)
+			go n.upsertRecordWorker(&wg, s.ns1IDs.cnameRecID, cnameRec, &count)
 		}
-		srvRec, err := n.generateRecord(s.ns1IDs.srvRecID, name, "SRV")
-		if err != nil {
-			n.log.Error("cannot fetch SRV record for service, generating new record", "name", name, "domain", s.ns1IDs.srvRecID, "error", err.Error())
-			srvRec, _ = n.generateRecord("", name, "SRV")
+
+		// publishing both families, a service without addresses of a family has no record of the family
+		dual := n.addressFamily == dualFamily
+		if !vhost && dual && !s.unchanged.aRec && len(aAnswers(s.nodes)) == 0 {
+			n.removeEmptyRecord(&wg, k, s.ns1IDs.aRecID, "A", &count)
+		} else if !vhost && n.addressFamily.v4() && !s.unchanged.aRec {
+			aRec, err := n.generateRecord(s.ns1IDs.aRecID, name, "A")
+			if err != nil {
+				n.log.Error("cannot fetch A record for service, generating new record", "name", name, "id", s.ns1IDs.aRecID, "error", err.Error())
+				aRec, _ = n.generateRecord("", name, "A")
+			}
+			aRec.TTL = n.recordTTL(s.ttls.aRecTTL)
+			n.filterOverrides.apply(aRec, s.filters, n.filters, s.clientSubnet, n.clientSubnet)
+			setClientSubnet(aRec, s.clientSubnet)
+			// Add answers
+			for _, a := range n.orderAnswers(aAnswers(s.nodes), name, "A") {
+				aRec.AddAnswer(dns.NewAv4Answer(a))
+			}
+			setWeights(aRec, nodeWeights(s.nodes, node.v4Answers))
+			setGeos(aRec, nodeGeos(s.nodes, node.v4Answers), n.geotargetRegional)
+			setRegions(aRec, nodeRegions(s.nodes, node.v4Answers), n.georegions)
+			setTags(aRec, nodeTags(s.nodes))
+			setVersions(aRec, nodeVersions(s.nodes, node.v4Answers))
+			if n.feeds != nil {
+				connectFeeds(aRec, k, n.feeds)
+			} else if n.states != nil {
+				setUp(aRec, downAnswers(s.nodes, node.v4Answers))
+			}
+			// Update record in NS1
+			wg.Add(1)
+			go n.upsertRecordWorker(&wg, s.ns1IDs.aRecID, aRec, &count)
 		}
 
-		// Add answers
-		for _, node := range s.nodes {
-			if node.aRecAnswer != "" {
-				aRec.AddAnswer(dns.NewAv4Answer(node.aRecAnswer))
+		if !vhost && dual && !s.unchanged.aaaaRec && len(aaaaAnswers(s.nodes)) == 0 {
+			n.removeEmptyRecord(&wg, k, s.ns1IDs.aaaaRecID, "AAAA", &count)
+		} else if !vhost && n.addressFamily.v6() && !s.unchanged.aaaaRec {
+			aaaaRec, err := n.generateRecord(s.ns1IDs.aaaaRecID, name, "AAAA")
+			if err != nil {
+				n.log.Error("cannot fetch AAAA record for service, generating new record", "name", name, "id", s.ns1IDs.aaaaRecID, "error", err.Error())
+				aaaaRec, _ = n.generateRecord("", name, "AAAA")
 			}
+			aaaaRec.TTL = n.recordTTL(s.ttls.aaaaRecTTL)
+			n.filterOverrides.apply(aaaaRec, s.filters, n.filters, s.clientSubnet, n.clientSubnet)
+			setClientSubnet(aaaaRec, s.clientSubnet)
+			// Add answers
+			for _, a := range n.orderAnswers(aaaaAnswers(s.nodes), name, "AAAA") {
+				aaaaRec.AddAnswer(dns.NewAv6Answer(a))
+			}
+			setWeights(aaaaRec, nodeWeights(s.nodes, node.v6Answers))
+			setGeos(aaaaRec, nodeGeos(s.nodes, node.v6Answers), n.geotargetRegional)
+			setRegions(aaaaRec, nodeRegions(s.nodes, node.v6Answers), n.georegions)
+			setTags(aaaaRec, nodeTags(s.nodes))
+			setVersions(aaaaRec, nodeVersions(s.nodes, node.v6Answers))
+			if n.feeds != nil {
+				connectFeeds(aaaaRec, k, n.feeds)
+			} else if n.states != nil {
+				setUp(aaaaRec, downAnswers(s.nodes, node.v6Answers))
+			}
+			// Update record in NS1
+			wg.Add(1)
+			go n.upsertRecordWorker(&wg, s.ns1IDs.aaaaRecID, aaaaRec, &count)
+		}
 
-			for _, a := range node.srvRecAnswers {
-				srvFields := strings.Fields(a.String())
-				srvRec.AddAnswer(dns.NewAnswer(srvFields))
+		// the node an SRV answer points to only has A and AAAA records
+		if !vhost && !s.srvTarget && !s.unchanged.srvRec {
+			srvRec, err := n.generateRecord(s.ns1IDs.srvRecID, name, "SRV")
+			if err != nil {
+				n.log.Error("cannot fetch SRV record for service, generating new record", "name", name, "domain", s.ns1IDs.srvRecID, "error", err.Error())
+				srvRec, _ = n.generateRecord("", name, "SRV")
+			}
+			srvRec.TTL = n.recordTTL(s.ttls.srvRecTTL)
+			n.filterOverrides.apply(srvRec, s.filters, n.filters, s.clientSubnet, n.clientSubnet)
+			setClientSubnet(srvRec, s.clientSubnet)
+			// Add answers
+			answers := []string{}
+			for _, a := range srvAnswers(s.nodes) {
+				answers = append(answers, a.String())
+			}
+			for _, a := range n.orderAnswers(answers, name, "SRV") {
+				srvRec.AddAnswer(dns.NewAnswer(strings.Fields(a)))
+			}
+			setRegions(srvRec, nodeRegions(s.nodes, node.srvAnswerStrings), n.georegions)
+			if n.feeds != nil {
+				connectFeeds(srvRec, k, n.feeds)
+			} else if n.states != nil {
+				setUp(srvRec, downAnswers(s.nodes, node.srvAnswerStrings))
+			}
+			// Update record in NS1
+			wg.Add(1)
+			go n.upsertRecordWorker(&wg, s.ns1IDs.srvRecID, srvRec, &count)
+		}
+
+		if !vhost && n.portHints && !s.unchanged.txtRec && s.txtRecAnswer != "" {
+			txtRec, err := n.generateRecord(s.ns1IDs.txtRecID, name, "TXT")
+			if err != nil {
+				n.log.Error("cannot fetch TXT record for service, generating new record", "name", name, "id", s.ns1IDs.txtRecID, "error", err.Error())
+				txtRec, _ = n.generateRecord("", name, "TXT")
+			}
+			txtRec.TTL = n.recordTTL(s.ttls.txtRecTTL)
+			txtRec.AddAnswer(dns.NewTXTAnswer(s.txtRecAnswer))
+			wg.Add(1)
+			go n.upsertRecordWorker(&wg, s.ns1IDs.txtRecID, txtRec, &count)
+		}
+
+		if !vhost && !s.unchanged.httpsRec {
+			if s.httpsRecAnswer != "" {
+				httpsRec, err := n.generateRecord(s.ns1IDs.httpsRecID, name, "HTTPS")
+				if err != nil {
+					n.log.Error("cannot fetch HTTPS record for service, generating new record", "name", name, "id", s.ns1IDs.httpsRecID, "error", err.Error())
+					httpsRec, _ = n.generateRecord("", name, "HTTPS")
+				}
+				httpsRec.TTL = n.recordTTL(s.ttls.httpsRecTTL)
+				httpsRec.AddAnswer(dns.NewAnswer(strings.Fields(s.httpsRecAnswer)))
+				wg.Add(1)
+				go n.upsertRecordWorker(&wg, s.ns1IDs.httpsRecID, httpsRec, &count)
+			} else if s.ns1IDs.httpsRecID != "" {
+				// the instances no longer declare HTTPS parameters
+				wg.Add(1)
+				go n.removeRecordWorker(&wg, n.serviceZone.name, n.serviceDomain(k), "HTTPS", &count)
 			}
 		}
 
-		// Update records in NS1
-		wg.Add(2)
-		go n.upsertRecordWorker(&wg, s.ns1IDs.aRecID, aRec, &count)
-		go n.upsertRecordWorker(&wg, s.ns1IDs.srvRecID, srvRec, &count)
 	}
 	wg.Wait()
+	if count > 0 {
+		if err := n.syncers.register(n.regions.written()); err != nil {
+			n.log.Error("cannot register regions in the coordination record", "error", err.Error())
+			countError(err)
+		}
+	}
 	return count
 }
 
 // upsertRecordWorker wraps upsertRecord for coordination via WaitGroup and mutates count if upsertion was succesful
 func (n *ns1) upsertRecordWorker(wg *sync.WaitGroup, recID string, rec *dns.Record, count *int32) {
+	if n.writesPaused() {
+		n.log.Debug("NS1 writes are paused, skipping record", "domain", rec.Domain, "type", rec.Type)
+		wg.Done()
+		return
+	}
+	own := answerStrings(rec.Answers)
+	if !n.edits.resolve(rec) {
+		n.log.Debug("record was edited outside of consul-ns1, skipping", "domain", rec.Domain, "type", rec.Type)
+		wg.Done()
+		return
+	}
+	if !n.empty.allow(rec) {
+		wg.Done()
+		return
+	}
+	n.settings.restore(rec)
+	n.coManaged.mark(rec)
+	n.regions.prune(rec, n.syncers)
 	err := n.upsertRecord(recID, rec)
 	if err != nil {
 		n.log.Error("cannot create or update record for service", "domain", rec.Domain, "type", rec.Type, "error", err.Error())
+		countError(err)
 	} else {
+		n.drift.wrote(rec.Domain, rec.Type, writtenState(rec), n.clock.Now())
+		n.edits.wrote(rec.Domain, rec.Type, own)
+		n.weights.wrote(rec)
+		n.states.wrote(rec)
+		n.geos.wrote(rec)
+		n.versions.wrote(rec)
+		n.regions.wrote(rec)
+		n.filterOverrides.wrote(rec)
+		n.latency.wrote(rec.Domain)
 		atomic.AddInt32(count, 1)
 	}
 	wg.Done()
 }
 
+// removeEmptyRecord removes the record of a service left without answers, if it has one
+func (n *ns1) removeEmptyRecord(wg *sync.WaitGroup, name, id, recType string, count *int32) {
+	if id == "" {
+		return
+	}
+	wg.Add(1)
+	go n.removeRecordWorker(wg, n.serviceZone.name, n.serviceDomain(name), recType, count)
+}
+
 // removeRecordWorker wraps ns1.client.Records.Delete for coordination via WaitGroup
 // and mutates count if deletion was successful
 func (n *ns1) removeRecordWorker(wg *sync.WaitGroup, zone, domain, recType string, count *int32) {
+	if n.writesPaused() {
+		n.log.Debug("NS1 writes are paused, skipping record removal", "domain", domain, "type", recType)
+		wg.Done()
+		return
+	}
+	if ok, err := n.marked(zone, domain, recType); err != nil || !ok {
+		if err != nil {
+			n.log.Error("cannot check the marker of record, not removing it", "zone", zone, "domain", domain, "type", recType, "error", err.Error())
+			countError(err)
+		} else {
+			n.log.Warn("record isn't marked as managed by consul-ns1, not removing it", "domain", domain, "type", recType)
+			metrics.IncrCounter([]string{"ns1", "unmarked_kept"}, 1)
+		}
+		wg.Done()
+		return
+	}
+	if rec, err := n.removeOwnAnswers(zone, domain, recType); err != nil || rec != nil {
+		if err != nil {
+			n.log.Error("Answers of co-managed record could not be removed", "zone", zone, "domain", domain, "type", recType, "error", err.Error())
+			countError(err)
+		} else {
+			n.drift.wrote(domain, recType, writtenState(rec), n.clock.Now())
+			n.edits.wrote(domain, recType, nil)
+			n.weights.wrote(rec)
+			n.states.wrote(rec)
+			n.geos.wrote(rec)
+			n.versions.wrote(rec)
+			n.regions.wrote(rec)
+			n.latency.wrote(domain)
+			atomic.AddInt32(count, 1)
+		}
+		wg.Done()
+		return
+	}
 	n.log.Debug("Removing record", "zone", n.serviceZone.name, "domain", domain, "type", recType)
-	_, err := n.client.Records.Delete(zone, domain, recType)
+	n.limiter.write()
+	resp, err := n.client.Records.Delete(zone, domain, recType)
 	if err != nil {
+		if d, ok := retryAfter(resp, n.clock.Now()); ok {
+			n.pauseWrites(d)
+		}
 		n.log.Error("Record for service could not be deleted", "zone", zone, "domain", domain, "type", recType, "error", err.Error())
+		countError(ns1Error(resp, err))
 	} else {
+		n.drift.wrote(domain, recType, "", n.clock.Now())
+		n.edits.wrote(domain, recType, nil)
+		n.coManaged.deleted(domain, recType)
+		n.weights.deleted(domain, recType)
+		n.states.deleted(domain, recType)
+		n.geos.deleted(domain, recType)
+		n.versions.deleted(domain, recType)
+		n.regions.deleted(domain, recType)
+		n.filterOverrides.deleted(domain, recType)
+		n.latency.wrote(domain)
 		atomic.AddInt32(count, 1)
 	}
 	wg.Done()
 }
 
+// serviceDomain returns the domain of the records of a service
+func (n *ns1) serviceDomain(k string) string {
+	if k == n.serviceZone.name {
+		// handle apex record
+		return n.serviceZone.name
+	}
+	return n.ns1Prefix + k + "." + n.serviceZone.name
+}
+
 // Remove deletes a record for a service from NS1, it ignores service nodes
-// as nodes are sync'ed with answers in Create
+// as nodes are sync'ed with answers in Create. Records without an ownership record are never deleted
+// with the ownership registry enabled, see `managedOnly`, whichever caller asks for their removal.
 func (n *ns1) remove(services map[string]service) int32 {
 	wg := sync.WaitGroup{}
 	var count int32
+	services = n.managedOnly(services)
+	if len(services) > 0 && n.writesPaused() {
+		n.log.Info("NS1 writes are paused, skipping removals", "count", len(services))
+		return count
+	}
+	// records of a service are deleted before its ownership record, so an interrupted cycle never leaves
+	// unmarked records behind. Ownership records left without records are collected by `collectRegistryGarbage`.
+	deleted := map[string]*int32{}
 	for k, s := range services {
-		domain := ""
-		if k == n.serviceZone.name {
-			// handle apex record
-			domain = n.serviceZone.name
-		} else {
-			name := n.ns1Prefix + k
-			domain = name + "." + n.serviceZone.name
+		domain := n.serviceDomain(k)
+		deleted[k] = new(int32)
+		for _, r := range []struct {
+			id      string
+			recType string
+		}{
+			{s.ns1IDs.aRecID, "A"},
+			{s.ns1IDs.aaaaRecID, "AAAA"},
+			{s.ns1IDs.srvRecID, "SRV"},
+			{s.ns1IDs.txtRecID, "TXT"},
+			{s.ns1IDs.cnameRecID, "CNAME"},
+			{s.ns1IDs.httpsRecID, "HTTPS"},
+		} {
+			if len(r.id) != 0 {
+				wg.Add(1)
+				go n.removeRecordWorker(&wg, n.serviceZone.name, domain, r.recType, deleted[k])
+			}
 		}
-		if len(s.ns1IDs.aRecID) != 0 {
-			wg.Add(1)
-			go n.removeRecordWorker(&wg, n.serviceZone.name, domain, "A", &count)
+	}
+	wg.Wait()
+	for _, d := range deleted {
+		count += *d
+	}
+	for k, s := range services {
+		if len(s.ns1IDs.ownerRecID) == 0 {
+			continue
 		}
-		if len(s.ns1IDs.srvRecID) != 0 {
-			wg.Add(1)
-			go n.removeRecordWorker(&wg, n.serviceZone.name, domain, "SRV", &count)
+		if int(*deleted[k]) != s.ns1IDs.recordCount()-1 {
+			n.log.Warn("keeping ownership record of service until all its records are deleted", "service", k)
+			continue
 		}
+		wg.Add(1)
+		go n.removeRecordWorker(&wg, n.serviceZone.name, ownerRecordLabel+n.serviceDomain(k), "TXT", &count)
 	}
 	wg.Wait()
 	return count
 }
 
+// drain removes the records of all managed services from NS1, e.g. when the syncer stops for good.
+// Returns the number of deleted records.
+func (n *ns1) drain() int32 {
+	if err := n.fetch(); err != nil {
+		n.log.Error("cannot fetch zone, not removing managed records", "error", err.Error())
+		return 0
+	}
+	services := n.managedOnly(n.getServices())
+	if n.frozen(nil, services) {
+		n.log.Warn("change freeze active, not removing managed records")
+		return 0
+	}
+	count := n.remove(services)
+	n.log.Info("removed managed records", "services", fmt.Sprintf("%d", len(services)), "count", fmt.Sprintf("%d", count))
+	return count
+}
+
+// fetchIndefinitely is the main event loop for fetching records from NS1.
+// When NS1 responds with a Retry-After, the next poll is delayed accordingly, and polls wait for sync cycles
+// to be done writing. Changing the poll interval at runtime polls right away.
 func (n *ns1) fetchIndefinitely(stop, stopped chan struct{}) {
 	defer close(stopped)
 	for {
-		err := n.fetch()
+		n.fetchBeat.beat(n.tuner.interval(n.pollInterval))
+		if d := n.limiter.pollDelay(); d > 0 {
+			n.log.Debug("Deferring poll until the NS1 API limiter refills", "delay", d.String())
+			metrics.IncrCounter([]string{"ns1", "poll_deferred"}, 1)
+			n.fetchBeat.beat(d)
+			select {
+			case <-stop:
+				return
+			case <-n.clock.After(d):
+				continue
+			}
+		}
+		if applying := n.cycle.busy(); applying != nil {
+			n.log.Debug("Deferring poll until the sync cycle is done writing")
+			metrics.IncrCounterWithLabels([]string{"ns1", "poll_overlap"}, 1, []metrics.Label{{Name: "action", Value: "deferred"}})
+			select {
+			case <-stop:
+				return
+			case <-applying:
+				continue
+			}
+		}
+		wait := n.tuner.interval(n.pollInterval)
+		drifted, err := n.poll()
+		if err == errPollOverlapped {
+			// poll again once the sync cycle is done, it waits for a poll of the zone it wrote
+			n.log.Debug("Discarding poll overlapping the writes of a sync cycle")
+			metrics.IncrCounterWithLabels([]string{"ns1", "poll_overlap"}, 1, []metrics.Label{{Name: "action", Value: "discarded"}})
+			continue
+		}
 		if err != nil {
 			n.log.Error("error fetching", "error", err.Error())
+			countError(err)
+			if raErr, ok := err.(*retryAfterError); ok && raErr.wait > wait {
+				wait = raErr.wait
+			}
 		} else {
-			n.trigger <- true
+			metrics.IncrCounterWithLabels([]string{"ns1", "poll"}, 1,
+				[]metrics.Label{{Name: "drift", Value: strconv.FormatBool(drifted)}})
+			select {
+			case n.trigger <- drifted:
+			case <-stop:
+				return
+			}
 		}
+		n.fetchBeat.beat(wait)
 		select {
 		case <-stop:
 			return
-		case <-time.After(n.pollInterval):
+		case <-n.clock.After(wait):
+			continue
+		case <-n.tuner.changed():
+			n.log.Debug("Poll interval changed, polling now")
 			continue
 		}
 	}
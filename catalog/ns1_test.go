@@ -9,6 +9,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
 	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
 
@@ -234,11 +235,45 @@ func (s *expectErrorRecordService) Get(zone, domain, t string) (*dns.Record, *ht
 	return nil, nil, e
 }
 
+// expectRetryAfterRecordService fulfils the recordService interface for mocking calls to
+// to ns1-go RecordService that will fail with a 503 and a Retry-After header
+type expectRetryAfterRecordService struct {
+	callCount int
+	mux       *sync.Mutex
+}
+
+func (s *expectRetryAfterRecordService) response() (*http.Response, error) {
+	s.mux.Lock()
+	s.callCount++
+	s.mux.Unlock()
+	resp := &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}}
+	resp.Header.Set("Retry-After", "3600")
+	return resp, errors.New("service unavailable")
+}
+
+func (s *expectRetryAfterRecordService) Create(r *dns.Record) (*http.Response, error) {
+	return s.response()
+}
+
+func (s *expectRetryAfterRecordService) Update(r *dns.Record) (*http.Response, error) {
+	return s.response()
+}
+
+func (s *expectRetryAfterRecordService) Delete(zone string, domain string, t string) (*http.Response, error) {
+	return s.response()
+}
+
+func (s *expectRetryAfterRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	resp, err := s.response()
+	return nil, resp, err
+}
+
 // mockRecordService fulfils the recordService interface for mocking calls to
 // to ns1-go RecordService that will create or update records
 type mockRecordService struct {
 	callCount int
 	records   []*dns.Record
+	deleted   []string
 	mux       *sync.Mutex
 }
 
@@ -259,7 +294,10 @@ func (s *mockRecordService) Update(r *dns.Record) (*http.Response, error) {
 }
 
 func (s *mockRecordService) Delete(zone string, domain string, t string) (*http.Response, error) {
+	s.mux.Lock()
 	s.callCount++
+	s.deleted = append(s.deleted, domain+" "+t)
+	s.mux.Unlock()
 	return nil, nil
 }
 
@@ -463,6 +501,61 @@ func TestTransformZoneRecords(t *testing.T) {
 	assert.Equal(t, expected, n.transformZoneRecords(z))
 }
 
+func TestTransformZoneRecords_PortHints(t *testing.T) {
+	z := &dns.Zone{
+		ID:   "57d95da659272400013334de",
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1"}, Type: "A", TTL: 1},
+			{Domain: "s1.test.zone", ID: "r2", ShortAns: []string{"v=spf1 -all", "\"ports=1,2\""}, Type: "TXT", TTL: 3},
+			{Domain: "s2.test.zone", ID: "r3", ShortAns: []string{"v=spf1 -all"}, Type: "TXT", TTL: 3},
+		},
+	}
+	expected := map[string]service{
+		"s1": {
+			name:         "s1",
+			ns1IDs:       recordIDs{aRecID: "r1", txtRecID: "r2"},
+			ttls:         recordTTLs{aRecTTL: 1, txtRecTTL: 3},
+			txtRecAnswer: "ports=1,2",
+			nodes:        map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}},
+		},
+	}
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, log: hclog.NewNullLogger(), portHints: true}
+	assert.Equal(t, expected, n.transformZoneRecords(z))
+
+	// TXT records are ignored when port hints are disabled
+	n.portHints = false
+	expected["s1"] = service{
+		name:   "s1",
+		ns1IDs: recordIDs{aRecID: "r1"},
+		ttls:   recordTTLs{aRecTTL: 1},
+		nodes:  map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}},
+	}
+	assert.Equal(t, expected, n.transformZoneRecords(z))
+}
+
+func TestCreate_WithPortHints(t *testing.T) {
+	n := testClient(nil)
+	n.portHints = true
+	n.client = &ns1APIClient{
+		Zones:   &mockZoneService{},
+		Records: &mockRecordService{mux: &sync.Mutex{}},
+	}
+	input := map[string]service{
+		"s1": {
+			nodes:        map[string]node{"h1": {aRecAnswer: "1.1.1.1"}},
+			txtRecAnswer: "ports=1",
+			unchanged:    recordTypes{srvRec: true},
+		},
+	}
+	expectedRecords := []*dns.Record{
+		newTestRecord("A", "s1", n.serviceZone.name, []string{"1.1.1.1"}),
+		newTestRecord("TXT", "s1", n.serviceZone.name, []string{"ports=1"}),
+	}
+	assert.Equal(t, int32(2), n.create(input))
+	assert.ElementsMatch(t, expectedRecords, n.client.Records.(*mockRecordService).records)
+}
+
 func TestUpsertRecord(t *testing.T) {
 	n := testClient(nil)
 	n.client = &ns1APIClient{
@@ -487,7 +580,59 @@ func TestUpsertRecord(t *testing.T) {
 
 	n.client.Records = &expectErrorRecordService{}
 	n.client.Records.(*expectErrorRecordService).mux = &sync.Mutex{}
-	assert.EqualError(t, n.upsertRecord("", r), "default error type")
+	// errors without a response are classified as NS1 being unavailable
+	err := n.upsertRecord("", r)
+	assert.EqualError(t, err, "NS1 unavailable: default error type")
+	assert.Equal(t, ErrNS1Unavailable, ErrorClass(err))
+}
+
+// staleRecordService fails creating the records in `existing` and updating the others, like NS1 does when the
+// zone changed since it was polled
+type staleRecordService struct {
+	mockRecordService
+	existing map[string]bool
+	calls    []string
+}
+
+func (s *staleRecordService) Create(r *dns.Record) (*http.Response, error) {
+	s.calls = append(s.calls, "create "+r.Domain)
+	if s.existing[r.Domain] {
+		return nil, ns1api.ErrRecordExists
+	}
+	return nil, nil
+}
+
+func (s *staleRecordService) Update(r *dns.Record) (*http.Response, error) {
+	s.calls = append(s.calls, "update "+r.Domain)
+	if !s.existing[r.Domain] {
+		return nil, ns1api.ErrRecordMissing
+	}
+	return nil, nil
+}
+
+func TestUpsertRecord_Retried(t *testing.T) {
+	n := testClient(nil)
+	records := &staleRecordService{existing: map[string]bool{"s1.test.zone": true}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+
+	// a record created since the last poll is updated
+	assert.NoError(t, n.upsertRecord("", &dns.Record{Zone: "test.zone", Domain: "s1.test.zone", Type: "A"}))
+	// a record deleted since the last poll is created
+	r := &dns.Record{ID: "2", Zone: "test.zone", Domain: "s2.test.zone", Type: "A"}
+	assert.NoError(t, n.upsertRecord("2", r))
+	assert.Equal(t, "", r.ID)
+	assert.Equal(t, []string{"create s1.test.zone", "update s1.test.zone", "update s2.test.zone", "create s2.test.zone"}, records.calls)
+
+	// records that may not be owned by this instance aren't overwritten
+	records.calls = nil
+	n.ownershipRegistry, n.conflictPolicy = true, skipConflicts
+	assert.Error(t, n.upsertRecord("", &dns.Record{Zone: "test.zone", Domain: "s1.test.zone", Type: "A"}))
+	assert.Equal(t, []string{"create s1.test.zone"}, records.calls)
+
+	records.calls = nil
+	n.ownershipRegistry, n.coManaged = false, &coManaged{marker: ownerTXTAnswer("")}
+	assert.Error(t, n.upsertRecord("", &dns.Record{Zone: "test.zone", Domain: "s1.test.zone", Type: "A"}))
+	assert.Equal(t, []string{"create s1.test.zone"}, records.calls)
 }
 
 func TestGenerateRecord(t *testing.T) {
@@ -616,6 +761,30 @@ func TestCreate(t *testing.T) {
 			expectedRecords: []*dns.Record{newTestRecord("A", "s8", n.serviceZone.name, nil), newTestRecord("SRV", "s8", n.serviceZone.name, []string{"1 1 1 1.1.1.1", "1 1 2 2.2.2.2"})},
 			expectedCount:   2,
 		},
+		"service with unchanged A record": {
+			input: map[string]service{
+				"s11": {
+					nodes: map[string]node{
+						"h1": {
+							aRecAnswer: "1.1.1.1",
+							srvRecAnswers: map[int]srvAnswer{
+								1: srvAnswer{priority: 1, weight: 1, port: 1, address: "1.1.1.1"},
+							},
+						},
+					},
+					unchanged: recordTypes{aRec: true},
+				},
+			},
+			expectedRecords: []*dns.Record{newTestRecord("SRV", "s11", n.serviceZone.name, []string{"1 1 1 1.1.1.1"})},
+			expectedCount:   1,
+		},
+		"service with unchanged A and SRV records": {
+			input: map[string]service{
+				"s12": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}, unchanged: recordTypes{aRec: true, srvRec: true}},
+			},
+			expectedRecords: nil,
+			expectedCount:   0,
+		},
 		"multiple services with one A rec answer": {
 			input: map[string]service{
 				"s9":  {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}},
@@ -757,6 +926,62 @@ func TestCreate_WithPrefix(t *testing.T) {
 	}
 }
 
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2019, 10, 1, 12, 0, 0, 0, time.UTC)
+	type variant struct {
+		status   int
+		header   string
+		expected time.Duration
+		ok       bool
+	}
+	table := map[string]variant{
+		"no response":        {status: 0, expected: 0, ok: false},
+		"not a server error": {status: http.StatusTooManyRequests, header: "10", expected: 0, ok: false},
+		"no header":          {status: http.StatusServiceUnavailable, expected: 0, ok: false},
+		"seconds":            {status: http.StatusServiceUnavailable, header: "120", expected: 2 * time.Minute, ok: true},
+		"http date":          {status: http.StatusBadGateway, header: now.Add(time.Minute).Format(http.TimeFormat), expected: time.Minute, ok: true},
+		"http date in past":  {status: http.StatusServiceUnavailable, header: now.Add(-time.Minute).Format(http.TimeFormat), expected: 0, ok: true},
+		"malformed":          {status: http.StatusServiceUnavailable, header: "soon", expected: 0, ok: false},
+	}
+	for name, v := range table {
+		var resp *http.Response
+		if v.status != 0 {
+			resp = &http.Response{StatusCode: v.status, Header: http.Header{}}
+			if v.header != "" {
+				resp.Header.Set("Retry-After", v.header)
+			}
+		}
+		d, ok := retryAfter(resp, now)
+		assert.Equal(t, v.ok, ok, fmt.Sprintf("test case: %s", name))
+		assert.Equal(t, v.expected, d, fmt.Sprintf("test case: %s", name))
+	}
+}
+
+func TestCreate_WithRetryAfter(t *testing.T) {
+	var stderr bytes.Buffer
+	n := testClient(&stderr)
+	n.client = &ns1APIClient{
+		Zones:   &mockZoneService{},
+		Records: &expectRetryAfterRecordService{mux: &sync.Mutex{}},
+	}
+	input := map[string]service{
+		"s1": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}},
+	}
+	assert.Equal(t, int32(0), n.create(input))
+	// the second worker may already observe the pause
+	callCount := n.client.Records.(*expectRetryAfterRecordService).callCount
+	assert.True(t, callCount >= 1 && callCount <= 2, "Expected one or two calls to NS1")
+	assert.True(t, n.writesPaused(), "Expected writes to be paused after Retry-After")
+
+	// no further calls while paused
+	assert.Equal(t, int32(0), n.create(input))
+	assert.Equal(t, int32(0), n.remove(map[string]service{"s1": {ns1IDs: recordIDs{aRecID: "r1"}}}))
+	assert.Equal(t, callCount, n.client.Records.(*expectRetryAfterRecordService).callCount)
+
+	n.pausedUntil = time.Time{}
+	assert.False(t, n.writesPaused())
+}
+
 func TestRemove(t *testing.T) {
 	n := testClient(nil)
 	n.client = &ns1APIClient{
@@ -802,6 +1027,14 @@ func TestRemove(t *testing.T) {
 			expectedRecords: []*dns.Record{},
 			expectedCount:   4,
 		},
+		"delete A and TXT record": {
+			input: map[string]service{
+				"s5": {ns1IDs: recordIDs{aRecID: "r1", txtRecID: "r3"}},
+			},
+			mockRecords:     []*dns.Record{newTestRecord("A", "s5", n.serviceZone.name, nil), newTestRecord("TXT", "s5", n.serviceZone.name, nil)},
+			expectedRecords: []*dns.Record{},
+			expectedCount:   2,
+		},
 		"delete apex A record": {
 			input: map[string]service{
 				"test.zone": {ns1IDs: recordIDs{aRecID: "r1"}},
@@ -852,3 +1085,19 @@ func newTestRecord(t string, s string, z string, ans []string) *dns.Record {
 	}
 	return &r
 }
+
+func TestDrain(t *testing.T) {
+	n := testClient(nil)
+	n.ns1Prefix = "c-"
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{
+		Zones: &staticZoneService{zone: &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+			{Domain: "c-s1.test.zone", ID: "1", Type: "A", ShortAns: []string{"1.1.1.1"}},
+			{Domain: "c-s1.test.zone", ID: "2", Type: "SRV", ShortAns: []string{"1 1 80 1.1.1.1"}},
+			{Domain: "other.test.zone", ID: "3", Type: "A", ShortAns: []string{"2.2.2.2"}},
+		}}},
+		Records: records,
+	}
+	assert.Equal(t, int32(2), n.drain())
+	assert.ElementsMatch(t, []string{"c-s1.test.zone A", "c-s1.test.zone SRV"}, records.deleted)
+}
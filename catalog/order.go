@@ -0,0 +1,31 @@
+package catalog
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// orderAnswers returns the answers of a record in the order they are stored in NS1. Without an answer seed
+// answers are sorted. With a seed, answers are ranked by a hash of the seed, the record and the answer: the
+// order is stable across syncs, answers keep their relative order as others are added or removed, and
+// filters picking the first answers, e.g. select_first_n, don't favour the same addresses in every record.
+func (n *ns1) orderAnswers(answers []string, name, recType string) []string {
+	ordered := append([]string{}, answers...)
+	if n.answerSeed == "" {
+		sort.Strings(ordered)
+		return ordered
+	}
+	ranks := make(map[string]uint32, len(ordered))
+	for _, a := range ordered {
+		h := fnv.New32a()
+		h.Write([]byte(n.answerSeed + " " + name + " " + recType + " " + a))
+		ranks[a] = h.Sum32()
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		if ranks[ordered[i]] != ranks[ordered[j]] {
+			return ranks[ordered[i]] < ranks[ordered[j]]
+		}
+		return ordered[i] < ordered[j]
+	})
+	return ordered
+}
@@ -0,0 +1,29 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOrderAnswers(t *testing.T) {
+	answers := []string{"3.3.3.3", "1.1.1.1", "2.2.2.2", "4.4.4.4"}
+	n := ns1{}
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}, n.orderAnswers(answers, "web", "A"))
+	assert.Equal(t, "3.3.3.3", answers[0], "answers are not modified")
+
+	n.answerSeed = "seed"
+	ordered := n.orderAnswers(answers, "web", "A")
+	assert.ElementsMatch(t, answers, ordered)
+	assert.Equal(t, ordered, n.orderAnswers([]string{"4.4.4.4", "2.2.2.2", "1.1.1.1", "3.3.3.3"}, "web", "A"),
+		"the order doesn't depend on the order of the desired answers")
+
+	// removing an answer keeps the relative order of the others
+	without := []string{}
+	for _, a := range ordered {
+		if a != "2.2.2.2" {
+			without = append(without, a)
+		}
+	}
+	assert.Equal(t, without, n.orderAnswers([]string{"1.1.1.1", "3.3.3.3", "4.4.4.4"}, "web", "A"))
+}
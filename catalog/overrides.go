@@ -0,0 +1,204 @@
+package catalog
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+// filtersMetaKey is the service meta key holding the filter chain of the A, AAAA and SRV records of a service,
+// a JSON list of filters in the format of the NS1 API
+const filtersMetaKey = "ns1-filters"
+
+// filtersKVKey is the key holding the filter chain of a service under its path in the KV prefix of overrides,
+// e.g. "consul-ns1/services/web/filters"
+const filtersKVKey = "filters"
+
+// filtersKey identifies a filter chain, empty for no chain. An empty chain isn't no chain.
+func filtersKey(filters []*filter.Filter) string {
+	if filters == nil {
+		return ""
+	}
+	b, _ := json.Marshal(filters)
+	return string(b)
+}
+
+// clientSubnetKeySep separates the filter chain from the EDNS Client Subnet option in an `overrideKey`
+const clientSubnetKeySep = " use_client_subnet="
+
+// overrideKey identifies the filter chain and EDNS Client Subnet option overriding the defaults of the records of a
+// service, empty for none
+func overrideKey(filters []*filter.Filter, subnet *bool) string {
+	if subnet == nil {
+		return filtersKey(filters)
+	}
+	return filtersKey(filters) + clientSubnetKeySep + strconv.FormatBool(*subnet)
+}
+
+// splitOverrideKey returns the `filtersKey` and the EDNS Client Subnet option, empty for none, of an `overrideKey`
+func splitOverrideKey(key string) (string, string) {
+	i := strings.LastIndex(key, clientSubnetKeySep)
+	if i < 0 {
+		return key, ""
+	}
+	return key[:i], key[i+len(clientSubnetKeySep):]
+}
+
+// serviceFilters returns the filter chain overriding the default of the records of a service, nil if none does.
+// A chain in the KV prefix takes precedence over the meta of the instances, which are expected to agree. If they
+// don't the chain of the first instance declaring one is used.
+func (c *consul) serviceFilters(name string, cnodes []*consulapi.CatalogService) []*filter.Filter {
+	c.filterOverridesLock.Lock()
+	filters, ok := c.filterOverrides[name]
+	c.filterOverridesLock.Unlock()
+	if ok {
+		return filters
+	}
+	for _, n := range cnodes {
+		v, ok := n.ServiceMeta[filtersMetaKey]
+		if !ok {
+			continue
+		}
+		f, err := parseFilterChain([]byte(v))
+		if err != nil {
+			c.log.Warn("invalid filter chain in service meta, ignoring", "service", name, "node", n.Node, "error", err)
+			continue
+		}
+		if filters == nil {
+			filters = f
+		} else if filtersKey(f) != filtersKey(filters) {
+			c.log.Warn("instances declare different filter chains, using the first", "service", name, "node", n.Node)
+		}
+	}
+	return filters
+}
+
+// fetchFilterOverrides reads the filter chains of the services in the KV prefix of overrides once the next index
+// after `waitIndex` is reached or `WaitTime` has passed, and returns the index of the prefix
+func (c *consul) fetchFilterOverrides(waitIndex uint64) (uint64, error) {
+	prefix := strings.TrimSuffix(c.filtersKVPrefix, "/") + "/"
+	opts := &consulapi.QueryOptions{AllowStale: c.stale, WaitIndex: waitIndex, WaitTime: WaitTime * time.Second}
+	pairs, meta, err := c.client.KV().List(prefix, opts)
+	if err != nil {
+		return 0, err
+	}
+	overrides := map[string][]*filter.Filter{}
+	for _, pair := range pairs {
+		name := strings.TrimSuffix(strings.TrimPrefix(pair.Key, prefix), "/"+filtersKVKey)
+		if name == "" || strings.Contains(name, "/") || !strings.HasSuffix(pair.Key, "/"+filtersKVKey) {
+			continue
+		}
+		filters, err := parseFilterChain(pair.Value)
+		if err != nil {
+			c.log.Warn("invalid filter chain in KV, ignoring", "service", name, "key", pair.Key, "error", err)
+			continue
+		}
+		overrides[name] = filters
+	}
+	c.filterOverridesLock.Lock()
+	c.filterOverrides = overrides
+	c.filterOverridesLock.Unlock()
+	return meta.LastIndex, nil
+}
+
+// watchFilterOverrides reads the filter chains in the KV prefix of overrides until stopped and requests a resync
+// whenever they change, so the records of the services are rewritten with their new chain
+func (c *consul) watchFilterOverrides(stop chan struct{}) {
+	c.watchResync("prefix", c.filtersKVPrefix, stop, func(waitIndex uint64) (uint64, uint64, error) {
+		index, err := c.fetchFilterOverrides(waitIndex)
+		return index, index, err
+	})
+}
+
+// filterOverrides remembers the filter chain and EDNS Client Subnet overrides written to the records of NS1, which
+// aren't part of the zone records they are read back from
+type filterOverrides struct {
+	lock sync.Mutex
+	// written holds the `overrideKey` of the overrides last written to each record, keyed by `recordKey`
+	written map[string]string
+	// pending holds the overrides set on records being written
+	pending map[string]string
+}
+
+// apply sets the filter chain overriding the default of a record about to be written and tracks its EDNS Client
+// Subnet option, set by `setClientSubnet`. Records whose override was removed get the default back, the default of
+// NS1, which enables the option, if there is no default option. Other records keep their settings.
+func (o *filterOverrides) apply(rec *dns.Record, filters, defaults []*filter.Filter, subnet, defaultSubnet *bool) {
+	if o == nil {
+		return
+	}
+	k := recordKey(rec.Domain, rec.Type)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	writtenFilters, writtenSubnet := splitOverrideKey(o.written[k])
+	switch {
+	case filters != nil:
+		rec.Filters = []*filter.Filter{}
+		setFilters(rec, filters)
+	case writtenFilters != "":
+		rec.Filters = []*filter.Filter{}
+		setFilters(rec, defaults)
+	}
+	switch {
+	case subnet != nil || writtenSubnet == "":
+	case defaultSubnet != nil:
+		setClientSubnet(rec, defaultSubnet)
+	default:
+		enabled := true
+		setClientSubnet(rec, &enabled)
+	}
+	if o.pending == nil {
+		o.pending = map[string]string{}
+	}
+	o.pending[k] = overrideKey(filters, subnet)
+}
+
+// wrote remembers the override set on a record written to NS1
+func (o *filterOverrides) wrote(rec *dns.Record) {
+	if o == nil {
+		return
+	}
+	k := recordKey(rec.Domain, rec.Type)
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	key, ok := o.pending[k]
+	if !ok {
+		return
+	}
+	delete(o.pending, k)
+	if o.written == nil {
+		o.written = map[string]string{}
+	}
+	if key == "" {
+		delete(o.written, k)
+	} else {
+		o.written[k] = key
+	}
+}
+
+// deleted forgets the override of a deleted record
+func (o *filterOverrides) deleted(domain, recType string) {
+	if o == nil {
+		return
+	}
+	o.lock.Lock()
+	delete(o.written, recordKey(domain, recType))
+	delete(o.pending, recordKey(domain, recType))
+	o.lock.Unlock()
+}
+
+// key returns the `overrideKey` of the overrides last written to a record, empty if none was
+func (o *filterOverrides) key(domain, recType string) string {
+	if o == nil {
+		return ""
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.written[recordKey(domain, recType)]
+}
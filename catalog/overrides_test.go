@@ -0,0 +1,146 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/diff"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+func TestServiceFilters(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), filterOverrides: map[string][]*filter.Filter{"kv": {{Type: "shuffle", Config: filter.Config{}}}}}
+	up := []*filter.Filter{{Type: "up", Config: filter.Config{}}}
+	table := map[string]struct {
+		service  string
+		cnodes   []*consulapi.CatalogService
+		expected []*filter.Filter
+	}{
+		"none": {"web", []*consulapi.CatalogService{{Node: "n1"}}, nil},
+		"meta": {"web", []*consulapi.CatalogService{
+			{Node: "n1"},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-filters": `[{"filter": "up"}]`}},
+		}, up},
+		"empty": {"web", []*consulapi.CatalogService{{Node: "n1", ServiceMeta: map[string]string{"ns1-filters": `[]`}}}, []*filter.Filter{}},
+		"invalid": {"web", []*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-filters": `[{"filter": "up"`}},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-filters": `/etc/filters.json`}},
+		}, nil},
+		"different": {"web", []*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-filters": `[{"filter": "up"}]`}},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-filters": `[{"filter": "shuffle"}]`}},
+		}, up},
+		"kv": {"kv", []*consulapi.CatalogService{{Node: "n1", ServiceMeta: map[string]string{"ns1-filters": `[{"filter": "up"}]`}}},
+			[]*filter.Filter{{Type: "shuffle", Config: filter.Config{}}}},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, c.serviceFilters(v.service, v.cnodes), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestFetchFilterOverrides(t *testing.T) {
+	pairs := consulapi.KVPairs{
+		{Key: "consul-ns1/services/web/filters", Value: []byte(`[{"filter": "up"}]`)},
+		{Key: "consul-ns1/services/api/filters", Value: []byte(`invalid`)},
+		{Key: "consul-ns1/services/api/ttl", Value: []byte(`30`)},
+		{Key: "consul-ns1/services/a/b/filters", Value: []byte(`[]`)},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/kv/consul-ns1/services/", r.URL.Path)
+		w.Header().Set("X-Consul-Index", "42")
+		json.NewEncoder(w).Encode(pairs)
+	}))
+	defer srv.Close()
+	client, err := consulapi.NewClient(&consulapi.Config{Address: srv.URL})
+	require.NoError(t, err)
+
+	c := consul{client: client, log: hclog.NewNullLogger(), filtersKVPrefix: "consul-ns1/services/"}
+	index, err := c.fetchFilterOverrides(0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), index)
+	assert.Equal(t, map[string][]*filter.Filter{"web": {{Type: "up", Config: filter.Config{}}}}, c.filterOverrides)
+}
+
+func TestCreate_FilterOverrides(t *testing.T) {
+	n := testClient(nil)
+	n.filters = []*filter.Filter{{Type: "up", Config: filter.Config{}}}
+	n.filterOverrides = &filterOverrides{}
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	shuffle := []*filter.Filter{{Type: "shuffle", Config: filter.Config{}}}
+	key := filtersKey(shuffle)
+	desired := map[string]service{
+		"s1": {nodes: map[string]node{"n1/web": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}}},
+			filters: shuffle, filterKeys: map[diff.Family]string{diff.A: key, diff.SRV: key}},
+	}
+
+	assert.Equal(t, int32(2), n.create(desired))
+	for _, rec := range records.records {
+		assert.Equal(t, shuffle, rec.Filters, rec.Type)
+	}
+
+	// the overrides written are part of the service read back from the zone
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1"}, Type: "A", TTL: 10},
+			{Domain: "s1.test.zone", ID: "r2", ShortAns: []string{"1 1 80 1.1.1.1"}, Type: "SRV", TTL: 10},
+		},
+	}
+	desired["s1"] = service{nodes: desired["s1"].nodes, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}, filters: shuffle,
+		filterKeys: desired["s1"].filterKeys}
+	actual := n.transformZoneRecords(z)
+	assert.True(t, nodesAreEqual(desired["s1"].nodes, actual["s1"].nodes))
+	assert.Empty(t, onlyInFirst(desired, actual))
+
+	// removing the override restores the default chain of existing records
+	stored := &storedRecordService{mockRecordService{mux: &sync.Mutex{}}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: stored}
+	s := desired["s1"]
+	s.filters, s.filterKeys = nil, nil
+	s.ns1IDs = recordIDs{aRecID: "r1", srvRecID: "r2"}
+	desired["s1"] = s
+	assert.Len(t, onlyInFirst(desired, actual), 1)
+	assert.Equal(t, int32(2), n.create(desired))
+	for _, rec := range stored.records {
+		assert.Equal(t, n.filters, rec.Filters, rec.Type)
+	}
+	assert.Equal(t, "", n.filterOverrides.key("s1.test.zone", "A"))
+
+	// records without override keep their chain
+	stored.records = nil
+	assert.Equal(t, int32(2), n.create(desired))
+	for _, rec := range stored.records {
+		assert.Empty(t, rec.Filters, rec.Type)
+	}
+}
+
+func TestFilterOverrides_ClientSubnet(t *testing.T) {
+	enabled, disabled := true, false
+	o := &filterOverrides{}
+	rec := &dns.Record{Domain: "s1.test.zone", Type: "A"}
+
+	// the option of a record is tracked along with its chain
+	o.apply(rec, nil, nil, &disabled, nil)
+	o.wrote(rec)
+	assert.Equal(t, overrideKey(nil, &disabled), o.key("s1.test.zone", "A"))
+	f, subnet := splitOverrideKey(o.key("s1.test.zone", "A"))
+	assert.Equal(t, "", f)
+	assert.Equal(t, "false", subnet)
+
+	// a record whose option override was removed gets the default of NS1 back
+	rec.UseClientSubnet = &disabled
+	o.apply(rec, nil, nil, nil, nil)
+	o.wrote(rec)
+	assert.Equal(t, &enabled, rec.UseClientSubnet)
+	assert.Equal(t, "", o.key("s1.test.zone", "A"))
+}
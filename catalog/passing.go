@@ -0,0 +1,45 @@
+package catalog
+
+import "fmt"
+
+// warningPolicy decides whether instances in warning state are published when only passing instances are
+type warningPolicy string
+
+const (
+	// excludeWarning leaves instances in warning state out of the answers
+	excludeWarning warningPolicy = "exclude"
+	// publishWarning publishes instances in warning state like passing ones, like Consul DNS does
+	publishWarning warningPolicy = "publish"
+)
+
+// parseWarningPolicy validates a warning policy, an empty policy defaults to excludeWarning
+func parseWarningPolicy(s string) (warningPolicy, error) {
+	switch warningPolicy(s) {
+	case "", excludeWarning:
+		return excludeWarning, nil
+	case publishWarning:
+		return publishWarning, nil
+	}
+	return "", fmt.Errorf("unknown warning policy %q, must be one of %q or %q", s, excludeWarning, publishWarning)
+}
+
+// failing returns whether an instance with the given health fails its checks: critical instances do, instances
+// in warning state depend on the warning policy
+func (c *consul) failing(h health) bool {
+	return h == critical || (h != passing && c.warningPolicy == excludeWarning)
+}
+
+// passingInstances returns the instances of a service whose checks are passing, keyed by `instanceKey`.
+// Failing instances are left out, and instances without checks are published.
+func (c *consul) passingInstances(name string, nodes map[string]node, healths map[string]health) map[string]node {
+	passingNodes := make(map[string]node, len(nodes))
+	for key, n := range nodes {
+		h, ok := healths[key]
+		if ok && c.failing(h) {
+			c.log.Debug("instance not passing its checks, excluding", "service", name, "instance", key, "health", string(h))
+			continue
+		}
+		passingNodes[key] = n
+	}
+	return passingNodes
+}
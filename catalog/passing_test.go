@@ -0,0 +1,83 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseWarningPolicy(t *testing.T) {
+	p, err := parseWarningPolicy("")
+	assert.NoError(t, err)
+	assert.Equal(t, excludeWarning, p)
+	p, err = parseWarningPolicy("publish")
+	assert.NoError(t, err)
+	assert.Equal(t, publishWarning, p)
+	_, err = parseWarningPolicy("include")
+	assert.Error(t, err)
+}
+
+func TestPassingInstances(t *testing.T) {
+	nodes := map[string]node{
+		"n1/web": {aRecAnswer: "1.1.1.1"},
+		"n2/web": {aRecAnswer: "2.2.2.2"},
+		"n3/web": {aRecAnswer: "3.3.3.3"},
+		"n4/web": {aRecAnswer: "4.4.4.4"},
+	}
+	checks := consulapi.HealthChecks{
+		{Node: "n1", ServiceID: "web", Status: "passing"},
+		{Node: "n2", ServiceID: "web", Status: "warning"},
+		{Node: "n3", ServiceID: "web", Status: "critical"},
+	}
+	table := map[string]struct {
+		policy   warningPolicy
+		expected []string
+	}{
+		"exclude warning": {excludeWarning, []string{"1.1.1.1", "4.4.4.4"}},
+		"publish warning": {publishWarning, []string{"1.1.1.1", "2.2.2.2", "4.4.4.4"}},
+	}
+	for name, v := range table {
+		c := consul{log: hclog.NewNullLogger(), onlyPassing: true, warningPolicy: v.policy}
+		passing := c.passingInstances("web", nodes, c.transformHealth(checks))
+		assert.Equal(t, v.expected, aAnswers(passing), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestPassingInstances_Flapping(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), addressFamily: ipv4Family, onlyPassing: true, warningPolicy: excludeWarning}
+	cnodes := []*consulapi.CatalogService{
+		{Node: "n1", ServiceID: "web", Address: "1.1.1.1", ServicePort: 80},
+		{Node: "n2", ServiceID: "web", Address: "2.2.2.2", ServicePort: 80},
+	}
+	// the check of n2 flaps between passing and critical, through warning
+	cycles := []struct {
+		status   string
+		expected []string
+		changed  bool
+	}{
+		{"passing", []string{"1.1.1.1", "2.2.2.2"}, false},
+		{"critical", []string{"1.1.1.1"}, true},
+		{"critical", []string{"1.1.1.1"}, false},
+		{"passing", []string{"1.1.1.1", "2.2.2.2"}, true},
+		{"warning", []string{"1.1.1.1"}, true},
+		{"passing", []string{"1.1.1.1", "2.2.2.2"}, true},
+	}
+	published := map[string]service{"web": {nodes: c.transformNodes(cnodes)}}
+	for i, cycle := range cycles {
+		checks := consulapi.HealthChecks{
+			{Node: "n1", ServiceID: "web", Status: "passing"},
+			{Node: "n2", ServiceID: "web", Status: cycle.status},
+		}
+		desired := map[string]service{"web": {nodes: c.passingInstances("web", c.transformNodes(cnodes), c.transformHealth(checks))}}
+		assert.Equal(t, cycle.expected, aAnswers(desired["web"].nodes), fmt.Sprintf("Cycle %d", i))
+		upsert := onlyInFirst(desired, published)
+		assert.Equal(t, cycle.changed, len(upsert) > 0, fmt.Sprintf("Cycle %d", i))
+		if cycle.changed {
+			assert.False(t, upsert["web"].unchanged.aRec, fmt.Sprintf("Cycle %d", i))
+		}
+		published = desired
+	}
+}
@@ -0,0 +1,157 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/diff"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+)
+
+// Actions planned for a service or record
+const (
+	PlanCreate = "create"
+	PlanUpdate = "update"
+	PlanDelete = "delete"
+)
+
+// ServicePlan describes the changes the next sync would make to the records of a service
+type ServicePlan struct {
+	// Service is the name of the service the records are published for
+	Service string `json:"service"`
+	// Domain is the fully qualified name the records of the service are published at
+	Domain string `json:"domain"`
+	// Action is PlanCreate, PlanUpdate or PlanDelete
+	Action string `json:"action"`
+	// Records holds the changes to the records of each type, in the order they are written
+	Records []RecordPlan `json:"records"`
+}
+
+// RecordPlan describes the changes to the record of one type of a service
+type RecordPlan struct {
+	// Type is the type of the record, e.g. "A"
+	Type string `json:"type"`
+	// Action is PlanCreate, PlanUpdate or PlanDelete
+	Action string `json:"action"`
+	// Added, Removed and Kept hold the sorted answers added, removed and left as they are
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Kept    []string `json:"kept"`
+	// TTL is the TTL the record is written with and OldTTL the TTL of the existing record, if any
+	TTL    int64 `json:"ttl"`
+	OldTTL int64 `json:"old_ttl,omitempty"`
+}
+
+// Plan fetches the services from Consul and the records from NS1 once and returns the changes the next sync would
+// make, without changing anything. Plans are sorted by service name.
+func Plan(cfg Config, ns1Client *ns1api.Client, consulClient *consulapi.Client) ([]ServicePlan, error) {
+	consul, err := fetchOnce(cfg, consulClient)
+	if err != nil {
+		return nil, err
+	}
+	n := &ns1{
+		log:               hclog.Default().Named("ns1"),
+		ns1Prefix:         cfg.NS1Prefix,
+		portHints:         cfg.PortHints,
+		addressFamily:     consul.addressFamily,
+		ownershipRegistry: cfg.OwnershipRegistry,
+		conflictPolicy:    skipConflicts,
+		naming:            consul.naming,
+		selection:         consul.selection,
+	}
+	z, _, err := ns1Client.Zones.Get(cfg.NS1Domain)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching zone %s: %s", cfg.NS1Domain, err)
+	}
+	n.serviceZone = n.transformZone(z)
+	desired, actual := consul.getServices(), n.transformZoneRecords(z)
+	// the filter chains of records aren't part of the zone, they can't be compared
+	for k, s := range desired {
+		s.filterKeys = nil
+		desired[k] = s
+	}
+
+	// services without an ownership record are left alone, as they are by the sync
+	remove := n.managedOnly(serviceOnlyInFirst(actual, desired))
+	for k := range serviceOnlyInFirst(actual, desired) {
+		if _, ok := remove[k]; !ok {
+			delete(actual, k)
+		}
+	}
+	return planServices(desired, actual, cfg.NS1Prefix, cfg.NS1Domain), nil
+}
+
+// planServices returns the changes turning the actual services into the desired ones. Services and records
+// without changes are left out.
+func planServices(desired, actual map[string]service, prefix, domain string) []ServicePlan {
+	keys := sortedNames(desired)
+	for _, k := range sortedNames(actual) {
+		if _, ok := desired[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	plans := []ServicePlan{}
+	for _, k := range keys {
+		d, inDesired := desired[k]
+		a, inActual := actual[k]
+		p := ServicePlan{Service: k, Domain: prefix + k + "." + domain, Action: PlanUpdate}
+		switch {
+		case !inActual:
+			p.Action = PlanCreate
+		case !inDesired:
+			p.Action = PlanDelete
+		}
+		de, ae := compareEntries(d, a)
+		if !inDesired {
+			de = diff.Entry{}
+		}
+		for _, f := range recordFamilies {
+			if r, ok := planRecord(f.family, de[f.family], ae[f.family]); ok {
+				p.Records = append(p.Records, r)
+			}
+		}
+		if len(p.Records) > 0 {
+			plans = append(plans, p)
+		}
+	}
+	return plans
+}
+
+// planRecord returns the changes turning the actual record of a family into the desired one, and whether there are any
+func planRecord(family diff.Family, desired, actual diff.Record) (RecordPlan, bool) {
+	if diff.Equal(desired, actual) {
+		return RecordPlan{}, false
+	}
+	wanted, existing := answerSet(desired), answerSet(actual)
+	if len(wanted) == 0 && len(existing) == 0 {
+		// a record without answers is never written
+		return RecordPlan{}, false
+	}
+	r := RecordPlan{Type: string(family), Action: PlanUpdate, Added: []string{}, Removed: []string{}, Kept: []string{},
+		TTL: desired.TTL}
+	switch {
+	case len(existing) == 0:
+		r.Action = PlanCreate
+	case len(wanted) == 0:
+		r.Action, r.TTL, r.OldTTL = PlanDelete, 0, actual.TTL
+	case actual.TTL != desired.TTL:
+		r.OldTTL = actual.TTL
+	}
+	for _, a := range sortedKeys(wanted) {
+		if existing[a] {
+			r.Kept = append(r.Kept, a)
+		} else {
+			r.Added = append(r.Added, a)
+		}
+	}
+	for _, a := range sortedKeys(existing) {
+		if !wanted[a] {
+			r.Removed = append(r.Removed, a)
+		}
+	}
+	return r, true
+}
@@ -0,0 +1,71 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nsone/consul-ns1/diff"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlanRecord(t *testing.T) {
+	table := map[string]struct {
+		desired  diff.Record
+		actual   diff.Record
+		expected RecordPlan
+		ok       bool
+	}{
+		"equal": {diff.Record{Answers: []string{"1.1.1.1"}, TTL: 10}, diff.Record{Answers: []string{"1.1.1.1"}, TTL: 10, ID: "r1"},
+			RecordPlan{}, false},
+		"empty": {diff.Record{TTL: 10}, diff.Record{}, RecordPlan{}, false},
+		"create": {diff.Record{Answers: []string{"2.2.2.2", "1.1.1.1"}, TTL: 10}, diff.Record{},
+			RecordPlan{Type: "A", Action: PlanCreate, Added: []string{"1.1.1.1", "2.2.2.2"}, Removed: []string{}, Kept: []string{}, TTL: 10}, true},
+		"delete": {diff.Record{TTL: 10}, diff.Record{Answers: []string{"1.1.1.1"}, TTL: 20},
+			RecordPlan{Type: "A", Action: PlanDelete, Added: []string{}, Removed: []string{"1.1.1.1"}, Kept: []string{}, OldTTL: 20}, true},
+		"update": {diff.Record{Answers: []string{"1.1.1.1", "3.3.3.3"}, TTL: 10}, diff.Record{Answers: []string{"1.1.1.1", "2.2.2.2"}, TTL: 10},
+			RecordPlan{Type: "A", Action: PlanUpdate, Added: []string{"3.3.3.3"}, Removed: []string{"2.2.2.2"}, Kept: []string{"1.1.1.1"}, TTL: 10}, true},
+		"ttl": {diff.Record{Answers: []string{"1.1.1.1"}, TTL: 10}, diff.Record{Answers: []string{"1.1.1.1"}, TTL: 20},
+			RecordPlan{Type: "A", Action: PlanUpdate, Added: []string{}, Removed: []string{}, Kept: []string{"1.1.1.1"}, TTL: 10, OldTTL: 20}, true},
+	}
+	for name, v := range table {
+		actual, ok := planRecord(diff.A, v.desired, v.actual)
+		assert.Equal(t, v.ok, ok, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expected, actual, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestPlanServices(t *testing.T) {
+	desired := map[string]service{
+		"new": {nodes: map[string]node{"n1/new": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}}},
+			ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+		"same": {nodes: map[string]node{"n1/same": {aRecAnswer: "1.1.1.1"}}, ttls: recordTTLs{aRecTTL: 10}},
+		"web": {nodes: map[string]node{
+			"n1/web": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}},
+			"n3/web": {aRecAnswer: "3.3.3.3", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "3.3.3.3"}}},
+		}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+	}
+	actual := map[string]service{
+		"old":  {nodes: map[string]node{"4.4.4.4": {aRecAnswer: "4.4.4.4"}}, ttls: recordTTLs{aRecTTL: 10}},
+		"same": {nodes: map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}}, ttls: recordTTLs{aRecTTL: 10}},
+		"web": {nodes: map[string]node{
+			"1.1.1.1": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}},
+			"2.2.2.2": {aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2.2.2.2"}}},
+		}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+	}
+
+	none := []string{}
+	assert.Equal(t, []ServicePlan{
+		{Service: "new", Domain: "p-new.test.zone", Action: PlanCreate, Records: []RecordPlan{
+			{Type: "A", Action: PlanCreate, Added: []string{"1.1.1.1"}, Removed: none, Kept: none, TTL: 10},
+			{Type: "SRV", Action: PlanCreate, Added: []string{"1 1 80 1.1.1.1"}, Removed: none, Kept: none, TTL: 10},
+		}},
+		{Service: "old", Domain: "p-old.test.zone", Action: PlanDelete, Records: []RecordPlan{
+			{Type: "A", Action: PlanDelete, Added: none, Removed: []string{"4.4.4.4"}, Kept: none, OldTTL: 10},
+		}},
+		{Service: "web", Domain: "p-web.test.zone", Action: PlanUpdate, Records: []RecordPlan{
+			{Type: "A", Action: PlanUpdate, Added: []string{"3.3.3.3"}, Removed: []string{"2.2.2.2"}, Kept: []string{"1.1.1.1"}, TTL: 10},
+			{Type: "SRV", Action: PlanUpdate, Added: []string{"1 1 80 3.3.3.3"}, Removed: []string{"1 1 80 2.2.2.2"},
+				Kept: []string{"1 1 80 1.1.1.1"}, TTL: 10},
+		}},
+	}, planServices(desired, actual, "p-", "test.zone"))
+}
@@ -0,0 +1,122 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// minPollInterval is the shortest interval between two polls of NS1, shorter intervals would hammer the API
+const minPollInterval = time.Second
+
+// parsePollInterval parses and validates an interval between two polls of NS1
+func parsePollInterval(s string) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < minPollInterval {
+		return 0, fmt.Errorf("poll interval %s is shorter than %s", d, minPollInterval)
+	}
+	return d, nil
+}
+
+// pollTuner overrides the configured poll interval of NS1 at runtime, e.g. to speed up drift detection during an
+// incident, through the admin API
+type pollTuner struct {
+	lock sync.Mutex
+	// override is the interval set at runtime, 0 for the configured interval
+	override time.Duration
+	// retuned is signalled when the interval changes, so a poll waiting for the previous interval runs right away
+	retuned chan struct{}
+}
+
+// pollIntervalStatus is the poll interval reported by the admin API
+type pollIntervalStatus struct {
+	Interval   string `json:"interval"`
+	Configured string `json:"configured"`
+}
+
+// interval returns the interval set at runtime, or else the configured interval
+func (p *pollTuner) interval(configured time.Duration) time.Duration {
+	if p == nil {
+		return configured
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.override > 0 {
+		return p.override
+	}
+	return configured
+}
+
+// set overrides the poll interval, 0 restores the configured interval
+func (p *pollTuner) set(d time.Duration) {
+	p.lock.Lock()
+	p.override = d
+	if p.retuned == nil {
+		p.retuned = make(chan struct{}, 1)
+	}
+	p.lock.Unlock()
+	select {
+	case p.retuned <- struct{}{}:
+	default:
+	}
+}
+
+// changed returns the channel signalled when the interval changes, nil if it can't change
+func (p *pollTuner) changed() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.retuned == nil {
+		p.retuned = make(chan struct{}, 1)
+	}
+	return p.retuned
+}
+
+// handleInterval responds with the poll interval on GET, sets it from the `interval` query parameter on PUT or
+// POST and restores the configured interval on DELETE
+func (n *ns1) handleInterval(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut, http.MethodPost:
+		d, err := parsePollInterval(r.URL.Query().Get("interval"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		n.log.Info("poll interval changed", "interval", d.String())
+		n.tuner.set(d)
+	case http.MethodDelete:
+		n.log.Info("poll interval restored", "interval", n.pollInterval.String())
+		n.tuner.set(0)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(pollIntervalStatus{
+		Interval:   n.tuner.interval(n.pollInterval).String(),
+		Configured: n.pollInterval.String(),
+	})
+}
+
+// watchReload restores the configured poll interval whenever `reload` is signalled, until stopped
+func (n *ns1) watchReload(reload <-chan struct{}, stop chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			return
+		case <-reload:
+			if n.tuner.interval(0) != 0 {
+				n.log.Info("poll interval restored", "interval", n.pollInterval.String())
+				n.tuner.set(0)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePollInterval(t *testing.T) {
+	table := map[string]struct {
+		value    string
+		expected time.Duration
+		err      bool
+	}{
+		"default":     {"30s", 30 * time.Second, false},
+		"minimum":     {"1s", time.Second, false},
+		"sub-second":  {"300ms", 0, true},
+		"zero":        {"0", 0, true},
+		"negative":    {"-1m", 0, true},
+		"invalid":     {"fast", 0, true},
+		"fractional":  {"1.5m", 90 * time.Second, false},
+		"empty value": {"", 0, true},
+	}
+	for name, v := range table {
+		d, err := parsePollInterval(v.value)
+		if v.err {
+			assert.Error(t, err, fmt.Sprintf("Test case: %s", name))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expected, d, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestPollTuner_Admin(t *testing.T) {
+	n := &ns1{log: hclog.NewNullLogger(), pollInterval: 30 * time.Second, tuner: &pollTuner{}}
+	srv := httptest.NewServer(adminHandler(nil, nil, nil, n))
+	defer srv.Close()
+	do := func(method, query string) (int, pollIntervalStatus) {
+		req, err := http.NewRequest(method, srv.URL+"/v1/poll-interval"+query, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		var status pollIntervalStatus
+		if resp.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&status))
+		}
+		return resp.StatusCode, status
+	}
+
+	code, status := do(http.MethodGet, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, pollIntervalStatus{Interval: "30s", Configured: "30s"}, status)
+
+	code, status = do(http.MethodPut, "?interval=5s")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, pollIntervalStatus{Interval: "5s", Configured: "30s"}, status)
+	assert.Equal(t, 5*time.Second, n.tuner.interval(n.pollInterval))
+	select {
+	case <-n.tuner.changed():
+	default:
+		t.Fatal("changing the interval wasn't signalled")
+	}
+
+	code, _ = do(http.MethodPut, "?interval=100ms")
+	assert.Equal(t, http.StatusBadRequest, code)
+	assert.Equal(t, 5*time.Second, n.tuner.interval(n.pollInterval))
+
+	code, status = do(http.MethodDelete, "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, pollIntervalStatus{Interval: "30s", Configured: "30s"}, status)
+
+	code, _ = do(http.MethodPatch, "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+
+	var disabled *pollTuner
+	assert.Equal(t, time.Minute, disabled.interval(time.Minute))
+	assert.Nil(t, disabled.changed())
+}
+
+func TestNS1FetchIndefinitely_Retuned(t *testing.T) {
+	n := testClient(nil)
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: &mockRecordService{}}
+	n.pollInterval = time.Hour
+	n.tuner = &pollTuner{}
+	n.trigger = make(chan bool)
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go n.fetchIndefinitely(stop, stopped)
+	<-n.trigger
+
+	// changing the interval polls right away
+	n.tuner.set(time.Second)
+	select {
+	case <-n.trigger:
+	case <-time.After(time.Second):
+		t.Fatal("didn't poll after changing the interval")
+	}
+
+	// the configured interval is restored on reload
+	reload := make(chan struct{})
+	go n.watchReload(reload, stop)
+	reload <- struct{}{}
+	<-n.trigger
+	assert.Equal(t, time.Hour, n.tuner.interval(n.pollInterval))
+
+	close(stop)
+	<-stopped
+}
@@ -0,0 +1,88 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// ServicePreview describes where a Consul service would be published in NS1
+type ServicePreview struct {
+	// Service is the name of the service in Consul
+	Service string
+	// Domain is the fully qualified name the records of the service are published at, empty if it isn't published
+	Domain string
+	// Records holds the types of the records published for the service, e.g. "A"
+	Records []string
+	// Conflict is the service published instead of this one because their names only differ by case
+	Conflict string
+}
+
+// Preview fetches the services from Consul once and returns where each of them would be published in NS1,
+// applying the prefix and naming rules of the sync. Previews are sorted by service name.
+func Preview(cfg Config, consulClient *consulapi.Client) ([]ServicePreview, error) {
+	consul, err := fetchOnce(cfg, consulClient)
+	if err != nil {
+		return nil, err
+	}
+	// names that only differ by case are dropped when transforming services, list them from the catalog
+	cservices, _, err := consul.fetchServices(0)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching services: %s", err)
+	}
+	names := make([]string, 0, len(cservices))
+	for k := range cservices {
+		names = append(names, k)
+	}
+	return previewServices(names, consul.getServices(), consul.names, cfg.NS1Prefix, cfg.NS1Domain, consul.addressFamily, consul.portHints), nil
+}
+
+// previewServices returns where each of the Consul services with the given names is published
+// according to the services transformed from them with a name sanitization policy
+func previewServices(names []string, services map[string]service, policy namePolicy, prefix, domain string, family addressFamily, portHints bool) []ServicePreview {
+	// published maps the Consul name of each published service to the name it is published as,
+	// folded maps the lowercased sanitized Consul names to the Consul name of the published service
+	published, folded := map[string]string{}, map[string]string{}
+	for k, s := range services {
+		published[s.consulID] = k
+		folded[strings.ToLower(policy.sanitize(s.consulID))] = s.consulID
+	}
+	sort.Strings(names)
+
+	previews := []ServicePreview{}
+	for _, name := range names {
+		p := ServicePreview{Service: name}
+		if k, ok := published[name]; ok {
+			p.Domain = prefix + k + "." + domain
+			p.Records = publishedTypes(services[k], family, portHints)
+		} else {
+			p.Conflict = folded[strings.ToLower(policy.sanitize(name))]
+		}
+		previews = append(previews, p)
+	}
+	return previews
+}
+
+// publishedTypes returns the types of the records published for a service, in the order they are written
+func publishedTypes(s service, family addressFamily, portHints bool) []string {
+	if s.cnameRecAnswer != "" {
+		return []string{"CNAME"}
+	}
+	types := []string{}
+	if family.v4() {
+		types = append(types, "A")
+	}
+	if family.v6() {
+		types = append(types, "AAAA")
+	}
+	types = append(types, "SRV")
+	if portHints {
+		types = append(types, "TXT")
+	}
+	if s.httpsRecAnswer != "" {
+		types = append(types, "HTTPS")
+	}
+	return types
+}
@@ -0,0 +1,26 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewServices(t *testing.T) {
+	names := []string{"web", "Web", "api", "Admin"}
+	services := map[string]service{
+		"api":   {consulID: "api"},
+		"web":   {consulID: "Web", cnameRecAnswer: "ingress.example.com"},
+		"admin": {consulID: "Admin"},
+	}
+	previews := previewServices(names, services, namePolicy{}, "p-", "test.zone", dualFamily, true)
+	assert.Equal(t, []ServicePreview{
+		{Service: "Admin", Domain: "p-admin.test.zone", Records: []string{"A", "AAAA", "SRV", "TXT"}},
+		{Service: "Web", Domain: "p-web.test.zone", Records: []string{"CNAME"}},
+		{Service: "api", Domain: "p-api.test.zone", Records: []string{"A", "AAAA", "SRV", "TXT"}},
+		{Service: "web", Conflict: "Web"},
+	}, previews)
+
+	previews = previewServices([]string{"api"}, map[string]service{"api": {consulID: "api"}}, namePolicy{}, "", "test.zone", ipv4Family, false)
+	assert.Equal(t, []ServicePreview{{Service: "api", Domain: "api.test.zone", Records: []string{"A", "SRV"}}}, previews)
+}
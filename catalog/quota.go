@@ -0,0 +1,114 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// quota limits the records and answers a syncer may manage under its service prefix.
+// This prevents runaway registrations of one team from exhausting a zone shared with other teams.
+type quota struct {
+	// maxRecords is the maximum number of records managed under the prefix, 0 means unlimited
+	maxRecords int
+	// maxAnswers is the maximum number of answers of a single record, 0 means unlimited
+	maxAnswers int
+}
+
+// recordCount returns the number of records that exist in NS1 for a service
+func (ids recordIDs) recordCount() int {
+	count := 0
+	for _, id := range []string{ids.aRecID, ids.aaaaRecID, ids.srvRecID, ids.txtRecID, ids.cnameRecID, ids.httpsRecID, ids.ownerRecID} {
+		if id != "" {
+			count++
+		}
+	}
+	return count
+}
+
+// newRecordCount returns the number of records that would be created in NS1 when upserting a service
+func (n *ns1) newRecordCount(s service) int {
+	count := 0
+	if s.cnameRecAnswer != "" {
+		if !s.unchanged.cnameRec && s.ns1IDs.cnameRecID == "" {
+			count++
+		}
+		if n.ownershipRegistry && !s.unchanged.ownerRec && s.ownerRecAnswer != "" && s.ns1IDs.ownerRecID == "" {
+			count++
+		}
+		return count
+	}
+	if n.addressFamily.v4() && !s.unchanged.aRec && s.ns1IDs.aRecID == "" {
+		count++
+	}
+	if n.addressFamily.v6() && !s.unchanged.aaaaRec && s.ns1IDs.aaaaRecID == "" {
+		count++
+	}
+	if !s.unchanged.srvRec && s.ns1IDs.srvRecID == "" {
+		count++
+	}
+	if n.portHints && !s.unchanged.txtRec && s.txtRecAnswer != "" && s.ns1IDs.txtRecID == "" {
+		count++
+	}
+	if !s.unchanged.httpsRec && s.httpsRecAnswer != "" && s.ns1IDs.httpsRecID == "" {
+		count++
+	}
+	if n.ownershipRegistry && !s.unchanged.ownerRec && s.ownerRecAnswer != "" && s.ns1IDs.ownerRecID == "" {
+		count++
+	}
+	return count
+}
+
+// enforceQuota returns the services of `upsert` that can be written to NS1 without exceeding the quota,
+// given the services currently `existing` in NS1. Services exceeding the quota are logged and skipped.
+func (n *ns1) enforceQuota(upsert, existing map[string]service) map[string]service {
+	if n.quota.maxRecords <= 0 && n.quota.maxAnswers <= 0 {
+		return upsert
+	}
+	managed := 0
+	for _, s := range existing {
+		managed += s.ns1IDs.recordCount()
+	}
+
+	// sort for a deterministic choice of services when the quota is exhausted
+	keys := make([]string, 0, len(upsert))
+	for k := range upsert {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	result := map[string]service{}
+	for _, k := range keys {
+		s := upsert[k]
+		if n.quota.maxAnswers > 0 {
+			answers := len(aAnswers(s.nodes))
+			if aaaa := len(aaaaAnswers(s.nodes)); aaaa > answers {
+				answers = aaaa
+			}
+			if srv := len(srvAnswers(s.nodes)); srv > answers {
+				answers = srv
+			}
+			if answers > n.quota.maxAnswers {
+				n.log.Error("answer quota exceeded, skipping service", "service", k,
+					"answers", answers, "max", n.quota.maxAnswers)
+				metrics.IncrCounterWithLabels([]string{"quota", "exceeded"}, 1,
+					[]metrics.Label{{Name: "type", Value: "answers"}})
+				continue
+			}
+		}
+		if newRecords := n.newRecordCount(s); newRecords > 0 && n.quota.maxRecords > 0 {
+			if managed+newRecords > n.quota.maxRecords {
+				n.log.Error("record quota exceeded, skipping service", "service", k,
+					"prefix", n.ns1Prefix, "records", fmt.Sprintf("%d", managed), "max", n.quota.maxRecords)
+				metrics.IncrCounterWithLabels([]string{"quota", "exceeded"}, 1,
+					[]metrics.Label{{Name: "type", Value: "records"}})
+				continue
+			}
+			managed += newRecords
+		}
+		result[k] = s
+	}
+	metrics.SetGauge([]string{"quota", "records"}, float32(managed))
+	return result
+}
@@ -0,0 +1,69 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEnforceQuota(t *testing.T) {
+	type variant struct {
+		quota    quota
+		upsert   map[string]service
+		existing map[string]service
+		expected []string
+	}
+	existing := map[string]service{
+		"s1": {ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"}},
+	}
+	twoAnswers := map[string]node{"h1": {aRecAnswer: "1.1.1.1"}, "h2": {aRecAnswer: "2.2.2.2"}}
+
+	table := map[string]variant{
+		"no quota": {
+			upsert:   map[string]service{"s2": {}, "s3": {}},
+			existing: existing,
+			expected: []string{"s2", "s3"},
+		},
+		"within record quota": {
+			quota:    quota{maxRecords: 4},
+			upsert:   map[string]service{"s2": {}},
+			existing: existing,
+			expected: []string{"s2"},
+		},
+		"record quota exceeded": {
+			quota:    quota{maxRecords: 4},
+			upsert:   map[string]service{"s2": {}, "s3": {}},
+			existing: existing,
+			expected: []string{"s2"},
+		},
+		"updates allowed when record quota is exhausted": {
+			quota:    quota{maxRecords: 2},
+			upsert:   map[string]service{"s1": {ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"}}, "s2": {}},
+			existing: existing,
+			expected: []string{"s1"},
+		},
+		"unchanged records don't count": {
+			quota:    quota{maxRecords: 3},
+			upsert:   map[string]service{"s2": {unchanged: recordTypes{aRec: true}}},
+			existing: existing,
+			expected: []string{"s2"},
+		},
+		"answer quota exceeded": {
+			quota:    quota{maxAnswers: 1},
+			upsert:   map[string]service{"s2": {nodes: twoAnswers}, "s3": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}}},
+			existing: existing,
+			expected: []string{"s3"},
+		},
+	}
+	for name, v := range table {
+		n := testClient(nil)
+		n.quota = v.quota
+		actual := n.enforceQuota(v.upsert, v.existing)
+		keys := []string{}
+		for k := range actual {
+			keys = append(keys, k)
+		}
+		assert.ElementsMatch(t, v.expected, keys, fmt.Sprintf("Test case: %s", name))
+	}
+}
@@ -0,0 +1,144 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// nodeRegions returns the region of each answer selected by `answers` from a map of nodes. Instances sharing an
+// answer share the region of the first of them, by instance key, that has one.
+func nodeRegions(nodes map[string]node, answers func(node) []string) map[string]string {
+	keys := make([]string, 0, len(nodes))
+	for k := range nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	regions := map[string]string{}
+	for _, k := range keys {
+		n := nodes[k]
+		if n.region == "" {
+			continue
+		}
+		for _, a := range answers(n) {
+			if _, ok := regions[a]; !ok && a != "" {
+				regions[a] = n.region
+			}
+		}
+	}
+	return regions
+}
+
+// setRegions assigns the answers of a record to their region and sets the georegion of their datacenter in the meta
+// of the regions if it's mapped, leaving the rest of the meta of existing regions alone. Records without answers in
+// a region are left alone.
+func setRegions(rec *dns.Record, regions map[string]string, georegions map[string]string) {
+	if len(regions) == 0 {
+		return
+	}
+	if rec.Regions == nil {
+		rec.Regions = data.Regions{}
+	}
+	for _, a := range rec.Answers {
+		region, ok := regions[strings.Join(a.Rdata, " ")]
+		if !ok {
+			continue
+		}
+		a.SetRegion(region)
+		r := rec.Regions[region]
+		if georegion, ok := georegions[region]; ok {
+			r.Meta.Georegion = []string{georegion}
+		}
+		rec.Regions[region] = r
+	}
+}
+
+// answerRegions remembers the regions of the answers written to NS1, which aren't part of the zone records answers
+// are read back from
+type answerRegions struct {
+	lock sync.Mutex
+	// regions holds the region of each answer, keyed by `recordKey` and the answer
+	regions map[string]map[string]string
+}
+
+// wrote remembers the regions of the answers of a record written to NS1
+func (r *answerRegions) wrote(rec *dns.Record) {
+	if r == nil {
+		return
+	}
+	regions := map[string]string{}
+	for _, a := range rec.Answers {
+		if a.RegionName != "" {
+			regions[strings.Join(a.Rdata, " ")] = a.RegionName
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.regions == nil {
+		r.regions = map[string]map[string]string{}
+	}
+	r.regions[recordKey(rec.Domain, rec.Type)] = regions
+}
+
+// deleted forgets the regions of the answers of a deleted record
+func (r *answerRegions) deleted(domain, recType string) {
+	if r == nil {
+		return
+	}
+	r.lock.Lock()
+	delete(r.regions, recordKey(domain, recType))
+	r.lock.Unlock()
+}
+
+// region returns the region an answer of a record was last written to, empty if unknown
+func (r *answerRegions) region(domain, recType, answer string) string {
+	if r == nil {
+		return ""
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.regions[recordKey(domain, recType)][answer]
+}
+
+// written returns the regions the answers of all records were last written to
+func (r *answerRegions) written() []string {
+	if r == nil {
+		return nil
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	seen := map[string]bool{}
+	regions := []string{}
+	for _, answers := range r.regions {
+		for _, region := range answers {
+			if !seen[region] {
+				seen[region] = true
+				regions = append(regions, region)
+			}
+		}
+	}
+	sort.Strings(regions)
+	return regions
+}
+
+// prune removes the regions of a record about to be written that its answers were last written to and no answer
+// belongs to anymore. Regions not written by this instance or claimed by other instances are left alone.
+func (r *answerRegions) prune(rec *dns.Record, others *syncers) {
+	if r == nil {
+		return
+	}
+	used := map[string]bool{}
+	for _, a := range rec.Answers {
+		used[a.RegionName] = true
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, region := range r.regions[recordKey(rec.Domain, rec.Type)] {
+		if !used[region] && !others.claimedByOthers(region) {
+			delete(rec.Regions, region)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package catalog
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/nsone/consul-ns1/diff"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestRecordAnswers_Regions(t *testing.T) {
+	nodes := map[string]node{
+		"n1/web":  {aRecAnswer: "1.1.1.1", region: "dc1"},
+		"n1/web2": {aRecAnswer: "1.1.1.1", region: "dc2"},
+		"n2/web":  {aRecAnswer: "2.2.2.2", region: "dc2", down: true},
+		"n3/web":  {aRecAnswer: "3.3.3.3"},
+	}
+	assert.Equal(t, []string{"1.1.1.1 region=dc1", "2.2.2.2 up=false region=dc2", "3.3.3.3"},
+		recordAnswers(nodes, node.v4Answers))
+}
+
+func TestCreate_Regions(t *testing.T) {
+	n := testClient(nil)
+	n.regions, n.georegions = &answerRegions{}, map[string]string{"dc1": "US-EAST"}
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]node{
+		"n1/web": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}, region: "dc1"},
+		"n2/web": {aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2.2.2.2"}}, region: "dc2"},
+	}
+
+	assert.Equal(t, int32(2), n.create(map[string]service{"s1": {nodes: desired}}))
+	assert.Len(t, records.records, 2)
+	for _, rec := range records.records {
+		assert.Equal(t, "dc1", rec.Answers[0].RegionName, rec.Type)
+		assert.Equal(t, "dc2", rec.Answers[1].RegionName, rec.Type)
+		assert.Equal(t, data.Regions{
+			"dc1": {Meta: data.Meta{Georegion: []string{"US-EAST"}}},
+			"dc2": {Meta: data.Meta{}},
+		}, rec.Regions, rec.Type)
+	}
+
+	// the regions written are part of the service read back from the zone
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1", "2.2.2.2"}, Type: "A", TTL: 10},
+			{Domain: "s1.test.zone", ID: "r2", ShortAns: []string{"1 1 80 1.1.1.1", "1 1 80 2.2.2.2"}, Type: "SRV", TTL: 10},
+		},
+	}
+	actual := n.transformZoneRecords(z)["s1"].nodes
+	for _, family := range []diff.Family{diff.A, diff.SRV} {
+		assert.Equal(t, service{nodes: desired}.entry()[family].Answers, service{nodes: actual}.entry()[family].Answers)
+	}
+}
+
+func TestAnswerRegions_Prune(t *testing.T) {
+	r := &answerRegions{}
+	rec := dns.NewRecord("test.zone", "s1.test.zone", "A")
+	a1, a2 := dns.NewAv4Answer("1.1.1.1"), dns.NewAv4Answer("2.2.2.2")
+	a1.SetRegion("dc1")
+	a2.SetRegion("dc2")
+	rec.Answers = []*dns.Answer{a1, a2}
+	r.wrote(rec)
+
+	// regions written without answers left are removed, others are kept
+	rec.Answers = []*dns.Answer{a1}
+	rec.Regions = data.Regions{"dc1": {}, "dc2": {}, "manual": {}}
+	r.prune(rec, nil)
+	assert.Equal(t, data.Regions{"dc1": {}, "manual": {}}, rec.Regions)
+
+	r.deleted("s1.test.zone", "A")
+	assert.Equal(t, "", r.region("s1.test.zone", "A", "1.1.1.1"))
+}
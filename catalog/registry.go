@@ -0,0 +1,91 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+const (
+	// ownerRecordLabel is prepended to the domain of a service record to name the TXT record marking its owner
+	ownerRecordLabel = "_consul-ns1."
+	// ownerTXTPrefix is the prefix of the answer of an ownership TXT record, followed by the service prefix of the owner
+	ownerTXTPrefix = "heritage=consul-ns1,prefix="
+)
+
+// ownerTXTAnswer returns the answer of the TXT record marking a service as owned by the instance syncing `prefix`
+func ownerTXTAnswer(prefix string) string {
+	return ownerTXTPrefix + prefix
+}
+
+// parseOwnerTXTAnswer returns the service prefix of the owner from the answers of an ownership TXT record
+func parseOwnerTXTAnswer(answers []string) (string, bool) {
+	for _, ans := range answers {
+		ans = strings.Trim(ans, "\"")
+		if strings.HasPrefix(ans, ownerTXTPrefix) {
+			return strings.TrimPrefix(ans, ownerTXTPrefix), true
+		}
+	}
+	return "", false
+}
+
+// isOwnerRecord reports whether a zone record belongs to the ownership registry
+func isOwnerRecord(record *dns.ZoneRecord) bool {
+	return record.Type == "TXT" && strings.HasPrefix(record.Domain, ownerRecordLabel)
+}
+
+// zoneOwners returns the service prefix of the owner of each marked domain in a zone, keyed by the marked domain
+func zoneOwners(ns1Zone *dns.Zone) map[string]string {
+	owners := map[string]string{}
+	for _, record := range ns1Zone.Records {
+		if !isOwnerRecord(record) {
+			continue
+		}
+		if prefix, ok := parseOwnerTXTAnswer(record.ShortAns); ok {
+			owners[strings.TrimPrefix(record.Domain, ownerRecordLabel)] = prefix
+		}
+	}
+	return owners
+}
+
+// inScope reports whether a record domain belongs to this instance: it must carry the service prefix
+// and must not be marked as owned by an instance syncing a different prefix.
+func (n *ns1) inScope(domain string, owners map[string]string) bool {
+	if !strings.HasPrefix(domain, n.ns1Prefix) {
+		return false
+	}
+	if owner, ok := owners[domain]; ok && owner != n.ns1Prefix {
+		return false
+	}
+	name := strings.TrimSuffix(strings.TrimPrefix(domain, n.ns1Prefix), "."+n.serviceZone.name)
+	return n.naming.matches(name) && n.selection.selectsRecords(name)
+}
+
+// overlappingOwners returns the prefixes of other instances marking records in a zone whose scope
+// overlaps with this instance, i.e. either prefix is a prefix of the other.
+func (n *ns1) overlappingOwners(ns1Zone *dns.Zone) []string {
+	seen := map[string]bool{}
+	overlapping := []string{}
+	for _, prefix := range zoneOwners(ns1Zone) {
+		if prefix == n.ns1Prefix || seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+		if strings.HasPrefix(prefix, n.ns1Prefix) || strings.HasPrefix(n.ns1Prefix, prefix) {
+			overlapping = append(overlapping, prefix)
+		}
+	}
+	sort.Strings(overlapping)
+	return overlapping
+}
+
+// warnOverlappingOwners logs a warning for every other instance whose scope overlaps with this instance.
+// Records marked by another instance are never touched, but unmarked records in the overlap are
+// claimed by whichever instance writes them first.
+func (n *ns1) warnOverlappingOwners(ns1Zone *dns.Zone) {
+	for _, prefix := range n.overlappingOwners(ns1Zone) {
+		n.log.Warn("another consul-ns1 instance manages an overlapping prefix in this zone, "+
+			"use distinct, non-nested prefixes", "zone", n.serviceZone.name, "prefix", n.ns1Prefix, "other", prefix)
+	}
+}
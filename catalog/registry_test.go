@@ -0,0 +1,117 @@
+package catalog
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestParseOwnerTXTAnswer(t *testing.T) {
+	prefix, ok := parseOwnerTXTAnswer([]string{"v=spf1 -all", "\"heritage=consul-ns1,prefix=a-\""})
+	assert.True(t, ok)
+	assert.Equal(t, "a-", prefix)
+
+	prefix, ok = parseOwnerTXTAnswer([]string{ownerTXTAnswer("")})
+	assert.True(t, ok)
+	assert.Equal(t, "", prefix)
+
+	_, ok = parseOwnerTXTAnswer([]string{"v=spf1 -all"})
+	assert.False(t, ok)
+}
+
+func TestTransformZoneRecords_Scope(t *testing.T) {
+	z := &dns.Zone{
+		ID:   "57d95da659272400013334de",
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "a-s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1"}, Type: "A", TTL: 1},
+			{Domain: "_consul-ns1.a-s1.test.zone", ID: "r2", ShortAns: []string{"\"heritage=consul-ns1,prefix=a-\""}, Type: "TXT", TTL: 1},
+			{Domain: "a-b-s2.test.zone", ID: "r3", ShortAns: []string{"2.2.2.2"}, Type: "A", TTL: 1},
+			{Domain: "_consul-ns1.a-b-s2.test.zone", ID: "r4", ShortAns: []string{"\"heritage=consul-ns1,prefix=a-b-\""}, Type: "TXT", TTL: 1},
+			{Domain: "b-s3.test.zone", ID: "r5", ShortAns: []string{"3.3.3.3"}, Type: "A", TTL: 1},
+			{Domain: "a-s4.test.zone", ID: "r6", ShortAns: []string{"4.4.4.4"}, Type: "A", TTL: 1},
+		},
+	}
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, log: hclog.NewNullLogger(), ns1Prefix: "a-", ownershipRegistry: true}
+	expected := map[string]service{
+		"s1": {
+			name:           "s1",
+			ns1IDs:         recordIDs{aRecID: "r1", ownerRecID: "r2"},
+			ttls:           recordTTLs{aRecTTL: 1},
+			ownerRecAnswer: "heritage=consul-ns1,prefix=a-",
+			nodes:          map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}},
+		},
+		"s4": {
+			name:   "s4",
+			ns1IDs: recordIDs{aRecID: "r6"},
+			ttls:   recordTTLs{aRecTTL: 1},
+			nodes:  map[string]node{"4.4.4.4": {aRecAnswer: "4.4.4.4"}},
+		},
+	}
+	assert.Equal(t, expected, n.transformZoneRecords(z))
+
+	// records of other instances are out of scope even when the registry is disabled
+	n.ownershipRegistry = false
+	expected["s1"] = service{
+		name:   "s1",
+		ns1IDs: recordIDs{aRecID: "r1"},
+		ttls:   recordTTLs{aRecTTL: 1},
+		nodes:  map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}},
+	}
+	assert.Equal(t, expected, n.transformZoneRecords(z))
+}
+
+func TestOverlappingOwners(t *testing.T) {
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "_consul-ns1.a-s1.test.zone", ShortAns: []string{ownerTXTAnswer("a-")}, Type: "TXT"},
+			{Domain: "_consul-ns1.a-b-s2.test.zone", ShortAns: []string{ownerTXTAnswer("a-b-")}, Type: "TXT"},
+			{Domain: "_consul-ns1.a-b-s3.test.zone", ShortAns: []string{ownerTXTAnswer("a-b-")}, Type: "TXT"},
+			{Domain: "_consul-ns1.b-s3.test.zone", ShortAns: []string{ownerTXTAnswer("b-")}, Type: "TXT"},
+			{Domain: "_consul-ns1.s4.test.zone", ShortAns: []string{ownerTXTAnswer("")}, Type: "TXT"},
+		},
+	}
+	type variant struct {
+		prefix   string
+		expected []string
+	}
+	table := map[string]variant{
+		"nested prefixes": {prefix: "a-", expected: []string{"", "a-b-"}},
+		"no prefix":       {prefix: "", expected: []string{"a-", "a-b-", "b-"}},
+		"distinct prefix": {prefix: "c-", expected: []string{""}},
+	}
+	for name, v := range table {
+		n := ns1{ns1Prefix: v.prefix}
+		assert.Equal(t, v.expected, n.overlappingOwners(z), name)
+	}
+}
+
+func TestCreate_WithOwnershipRegistry(t *testing.T) {
+	n := testClient(nil)
+	n.ownershipRegistry = true
+	n.client = &ns1APIClient{
+		Zones:   &mockZoneService{},
+		Records: &mockRecordService{mux: &sync.Mutex{}},
+	}
+	input := map[string]service{
+		"s1": {
+			nodes:          map[string]node{"h1": {aRecAnswer: "1.1.1.1"}},
+			ownerRecAnswer: ownerTXTAnswer(""),
+			unchanged:      recordTypes{srvRec: true},
+		},
+	}
+	expectedRecords := []*dns.Record{
+		newTestRecord("A", "s1", n.serviceZone.name, []string{"1.1.1.1"}),
+		newTestRecord("TXT", "_consul-ns1.s1", n.serviceZone.name, []string{"heritage=consul-ns1,prefix="}),
+	}
+	assert.Equal(t, int32(2), n.create(input))
+	assert.ElementsMatch(t, expectedRecords, n.client.Records.(*mockRecordService).records)
+
+	// the ownership record is removed along with the service
+	n.client.Records = &mockRecordService{mux: &sync.Mutex{}}
+	assert.Equal(t, int32(2), n.remove(map[string]service{"s1": {ns1IDs: recordIDs{aRecID: "r1", ownerRecID: "r2"}}}))
+}
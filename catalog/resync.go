@@ -0,0 +1,81 @@
+package catalog
+
+import (
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// resyncRetryInterval is the time to wait before watching a resync trigger again after an error
+var resyncRetryInterval = 5 * time.Second
+
+// requestResync asks the sync loop for an immediate full reconciliation.
+// Requests are coalesced while one is pending.
+func (c *consul) requestResync() {
+	select {
+	case c.resync <- struct{}{}:
+	default:
+	}
+}
+
+// watchResyncEvent requests a resync whenever a Consul user event with the given name is fired,
+// e.g. with `consul event -name=ns1-resync`. Events fired before the watch started are ignored.
+func (c *consul) watchResyncEvent(name string, stop chan struct{}) {
+	c.watchResync("event", name, stop, func(waitIndex uint64) (uint64, uint64, error) {
+		opts := &consulapi.QueryOptions{WaitIndex: waitIndex, WaitTime: WaitTime * time.Second}
+		_, meta, err := c.client.Event().List(name, opts)
+		if err != nil {
+			return 0, 0, err
+		}
+		// the index of an event list is derived from the IDs of the events it holds
+		return meta.LastIndex, meta.LastIndex, nil
+	})
+}
+
+// watchResyncKey requests a resync whenever the given Consul KV key is modified,
+// e.g. with `consul kv put ns1/resync 1`. The value of the key is ignored.
+func (c *consul) watchResyncKey(key string, stop chan struct{}) {
+	c.watchResync("key", key, stop, func(waitIndex uint64) (uint64, uint64, error) {
+		opts := &consulapi.QueryOptions{AllowStale: c.stale, WaitIndex: waitIndex, WaitTime: WaitTime * time.Second}
+		pair, meta, err := c.client.KV().Get(key, opts)
+		if err != nil {
+			return 0, 0, err
+		}
+		if pair == nil {
+			// the key doesn't exist (yet)
+			return meta.LastIndex, 0, nil
+		}
+		return meta.LastIndex, pair.ModifyIndex, nil
+	})
+}
+
+// watchResync runs a blocking query until stopped and requests a resync whenever the version of the trigger
+// it returns changes. The query returns the index to wait for next and the version of the trigger.
+func (c *consul) watchResync(kind, name string, stop chan struct{}, query func(waitIndex uint64) (uint64, uint64, error)) {
+	var waitIndex, lastVersion uint64
+	first := true
+	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		index, version, err := query(waitIndex)
+		if err != nil {
+			c.log.Error("error watching resync trigger, will retry", kind, name, "error", err.Error())
+			select {
+			case <-stop:
+				return
+			case <-c.clock.After(resyncRetryInterval):
+			}
+			continue
+		}
+		if !first && version != lastVersion {
+			c.log.Info("resync triggered", kind, name)
+			c.requestResync()
+		}
+		first = false
+		waitIndex, _ = nextWaitIndex(waitIndex, index)
+		lastVersion = version
+	}
+}
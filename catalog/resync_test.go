@@ -0,0 +1,58 @@
+package catalog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequestResync(t *testing.T) {
+	c := consul{resync: make(chan struct{}, 1)}
+	c.requestResync()
+	c.requestResync()
+	assert.Len(t, c.resync, 1)
+}
+
+func TestWatchResync(t *testing.T) {
+	defer func(d time.Duration) { resyncRetryInterval = d }(resyncRetryInterval)
+	resyncRetryInterval = time.Millisecond
+	c := consul{log: hclog.NewNullLogger(), resync: make(chan struct{}, 1)}
+	stop := make(chan struct{})
+	// each step returns the index to wait for and the version of the trigger
+	type step struct {
+		index, version uint64
+		err            error
+		resync         bool
+	}
+	steps := []step{
+		{index: 10, version: 5},
+		{index: 11, version: 5},
+		{index: 12, version: 12, resync: true},
+		{err: errors.New("unavailable")},
+		{index: 13, version: 12},
+	}
+	waitIndexes := []uint64{}
+	i := 0
+	c.watchResync("key", "ns1/resync", stop, func(waitIndex uint64) (uint64, uint64, error) {
+		waitIndexes = append(waitIndexes, waitIndex)
+		if i > 0 && steps[i-1].err == nil {
+			select {
+			case <-c.resync:
+				assert.True(t, steps[i-1].resync, "step %d", i-1)
+			default:
+				assert.False(t, steps[i-1].resync, "step %d", i-1)
+			}
+		}
+		if i == len(steps) {
+			close(stop)
+			return 0, 0, errors.New("stopped")
+		}
+		s := steps[i]
+		i++
+		return s.index, s.version, s.err
+	})
+	assert.Equal(t, []uint64{0, 10, 11, 12, 12, 13}, waitIndexes)
+}
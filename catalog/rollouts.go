@@ -0,0 +1,128 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// rollout shifts a share of the DNS traffic of a service to the instances carrying a tag, e.g. canaries. It is
+// read from the KV prefix of rollouts at "<prefix>/<service>", e.g. `{"tag": "canary", "weight": 10}`.
+type rollout struct {
+	// Tag selects the instances of the rollout
+	Tag string `json:"tag"`
+	// Weight is the percentage of the traffic sent to the instances of the rollout, between 1 and 99
+	Weight int64 `json:"weight"`
+}
+
+// parseRollout parses and validates a rollout
+func parseRollout(b []byte) (rollout, error) {
+	var r rollout
+	if err := json.Unmarshal(b, &r); err != nil {
+		return r, err
+	}
+	if r.Tag == "" {
+		return r, fmt.Errorf("rollout without tag")
+	}
+	if r.Weight < 1 || r.Weight > 99 {
+		return r, fmt.Errorf("rollout weight %d out of range, must be between 1 and 99", r.Weight)
+	}
+	return r, nil
+}
+
+// fetchRollouts reads the rollouts of the services in the KV prefix of rollouts once the next index after
+// `waitIndex` is reached or `WaitTime` has passed, and returns the index of the prefix
+func (c *consul) fetchRollouts(waitIndex uint64) (uint64, error) {
+	prefix := strings.TrimSuffix(c.rolloutsKVPrefix, "/") + "/"
+	opts := &consulapi.QueryOptions{AllowStale: c.stale, WaitIndex: waitIndex, WaitTime: WaitTime * time.Second}
+	pairs, meta, err := c.client.KV().List(prefix, opts)
+	if err != nil {
+		return 0, err
+	}
+	rollouts := map[string]rollout{}
+	for _, pair := range pairs {
+		name := strings.TrimPrefix(pair.Key, prefix)
+		if name == "" || strings.Contains(name, "/") {
+			continue
+		}
+		r, err := parseRollout(pair.Value)
+		if err != nil {
+			c.log.Warn("invalid rollout in KV, ignoring", "service", name, "key", pair.Key, "error", err)
+			continue
+		}
+		rollouts[name] = r
+	}
+	c.rolloutsLock.Lock()
+	c.rollouts = rollouts
+	c.rolloutsLock.Unlock()
+	return meta.LastIndex, nil
+}
+
+// watchRollouts reads the rollouts in the KV prefix of rollouts until stopped and requests a resync whenever they
+// change, so the answers of the services are rewritten with their new weights
+func (c *consul) watchRollouts(stop chan struct{}) {
+	c.watchResync("prefix", c.rolloutsKVPrefix, stop, func(waitIndex uint64) (uint64, uint64, error) {
+		index, err := c.fetchRollouts(waitIndex)
+		return index, index, err
+	})
+}
+
+// applyRollout scales the weights of the A and AAAA answers of the instances of a service so the instances
+// carrying the tag of its rollout get the weight of the rollout in percent of the traffic, and the others the
+// rest. Instances keep their relative weight within each group. Services without rollout, or whose instances all
+// carry the tag or none does, are left alone.
+func (c *consul) applyRollout(name string, cnodes []*consulapi.CatalogService, nodes map[string]node) map[string]node {
+	c.rolloutsLock.Lock()
+	r, ok := c.rollouts[name]
+	c.rolloutsLock.Unlock()
+	if !ok {
+		return nodes
+	}
+	tagged := map[string]bool{}
+	for _, n := range cnodes {
+		for _, tag := range n.ServiceTags {
+			if tag == r.Tag {
+				tagged[instanceKey(n.Node, n.ServiceID)] = true
+			}
+		}
+	}
+	var inRollout, others int64
+	for k, n := range nodes {
+		if tagged[k] {
+			inRollout += n.answerWeight
+		} else {
+			others += n.answerWeight
+		}
+	}
+	if inRollout == 0 || others == 0 {
+		c.log.Debug("rollout doesn't split the instances of service, ignoring", "service", name, "tag", r.Tag)
+		return nodes
+	}
+	result := make(map[string]node, len(nodes))
+	var divisor int64
+	for k, n := range nodes {
+		if tagged[k] {
+			n.answerWeight *= r.Weight * others
+		} else {
+			n.answerWeight *= (100 - r.Weight) * inRollout
+		}
+		divisor = gcd(divisor, n.answerWeight)
+		result[k] = n
+	}
+	for k, n := range result {
+		n.answerWeight /= divisor
+		result[k] = n
+	}
+	return result
+}
+
+// gcd returns the greatest common divisor of two weights, `b` if `a` is 0
+func gcd(a, b int64) int64 {
+	for a != 0 {
+		a, b = b%a, a
+	}
+	return b
+}
@@ -0,0 +1,101 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRollout(t *testing.T) {
+	table := map[string]struct {
+		value    string
+		expected rollout
+		err      bool
+	}{
+		"valid":      {`{"tag": "canary", "weight": 10}`, rollout{Tag: "canary", Weight: 10}, false},
+		"invalid":    {`{"tag": "canary"`, rollout{}, true},
+		"no tag":     {`{"weight": 10}`, rollout{}, true},
+		"no weight":  {`{"tag": "canary"}`, rollout{}, true},
+		"all":        {`{"tag": "canary", "weight": 100}`, rollout{}, true},
+		"negative":   {`{"tag": "canary", "weight": -1}`, rollout{}, true},
+		"fractional": {`{"tag": "canary", "weight": 2.5}`, rollout{}, true},
+	}
+	for name, v := range table {
+		r, err := parseRollout([]byte(v.value))
+		if v.err {
+			assert.Error(t, err, fmt.Sprintf("Test case: %s", name))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expected, r, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestFetchRollouts(t *testing.T) {
+	pairs := consulapi.KVPairs{
+		{Key: "consul-ns1/rollouts/web", Value: []byte(`{"tag": "canary", "weight": 10}`)},
+		{Key: "consul-ns1/rollouts/api", Value: []byte(`invalid`)},
+		{Key: "consul-ns1/rollouts/a/b", Value: []byte(`{"tag": "canary", "weight": 10}`)},
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/kv/consul-ns1/rollouts/", r.URL.Path)
+		w.Header().Set("X-Consul-Index", "42")
+		json.NewEncoder(w).Encode(pairs)
+	}))
+	defer srv.Close()
+	client, err := consulapi.NewClient(&consulapi.Config{Address: srv.URL})
+	require.NoError(t, err)
+
+	c := consul{client: client, log: hclog.NewNullLogger(), rolloutsKVPrefix: "consul-ns1/rollouts"}
+	index, err := c.fetchRollouts(0)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(42), index)
+	assert.Equal(t, map[string]rollout{"web": {Tag: "canary", Weight: 10}}, c.rollouts)
+}
+
+func TestApplyRollout(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), rollouts: map[string]rollout{"web": {Tag: "canary", Weight: 10}}}
+	cnodes := []*consulapi.CatalogService{
+		{Node: "n1", ServiceID: "web", ServiceTags: []string{"canary"}},
+		{Node: "n2", ServiceID: "web", ServiceTags: []string{"primary"}},
+		{Node: "n3", ServiceID: "web"},
+		{Node: "n4", ServiceID: "web", ServiceTags: []string{"primary"}},
+	}
+	weights := func(nodes map[string]node) map[string]int64 {
+		w := map[string]int64{}
+		for k, n := range nodes {
+			w[k] = n.answerWeight
+		}
+		return w
+	}
+	table := map[string]struct {
+		service  string
+		cnodes   []*consulapi.CatalogService
+		weights  map[string]int64
+		expected map[string]int64
+	}{
+		"no rollout": {"api", cnodes, map[string]int64{"n1/web": 1, "n2/web": 1, "n3/web": 1, "n4/web": 1},
+			map[string]int64{"n1/web": 1, "n2/web": 1, "n3/web": 1, "n4/web": 1}},
+		"equal": {"web", cnodes, map[string]int64{"n1/web": 1, "n2/web": 1, "n3/web": 1, "n4/web": 1},
+			map[string]int64{"n1/web": 1, "n2/web": 3, "n3/web": 3, "n4/web": 3}},
+		"relative": {"web", cnodes, map[string]int64{"n1/web": 5, "n2/web": 2, "n3/web": 1, "n4/web": 1},
+			map[string]int64{"n1/web": 4, "n2/web": 18, "n3/web": 9, "n4/web": 9}},
+		"all tagged": {"web", cnodes[:1], map[string]int64{"n1/web": 1}, map[string]int64{"n1/web": 1}},
+		"none tagged": {"web", cnodes[1:], map[string]int64{"n2/web": 1, "n3/web": 1, "n4/web": 1},
+			map[string]int64{"n2/web": 1, "n3/web": 1, "n4/web": 1}},
+	}
+	for name, v := range table {
+		nodes := map[string]node{}
+		for k, w := range v.weights {
+			nodes[k] = node{answerWeight: w}
+		}
+		assert.Equal(t, v.expected, weights(c.applyRollout(v.service, v.cnodes, nodes)), fmt.Sprintf("Test case: %s", name))
+	}
+}
@@ -0,0 +1,198 @@
+package catalog
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"unicode/utf8"
+)
+
+// steps of a name sanitization policy
+const (
+	sanitizeLowercase   = "lowercase"
+	sanitizeUnderscores = "underscores"
+	sanitizeLong        = "long"
+	sanitizeUnicode     = "unicode"
+	sanitizeAll         = "all"
+)
+
+// maxLabelLength is the longest DNS label, RFC 1035
+const maxLabelLength = 63
+
+// namePolicy sanitizes the names of Consul services into valid DNS names. Services are published, diffed and
+// read back from NS1 under their sanitized name, and instances are still looked up under their Consul name.
+type namePolicy struct {
+	// lowercase lowercases names
+	lowercase bool
+	// underscores replaces underscores, not allowed in hostnames, with hyphens
+	underscores bool
+	// long shortens labels longer than maxLabelLength, keeping a hash of the label so they don't collide
+	long bool
+	// unicode encodes labels holding non-ASCII characters in punycode, RFC 3492
+	unicode bool
+}
+
+// parseNamePolicy parses a comma-separated list of sanitization steps, "all" enables every step. An empty
+// policy leaves names alone.
+func parseNamePolicy(s string) (namePolicy, error) {
+	p := namePolicy{}
+	if s == "" {
+		return p, nil
+	}
+	for _, step := range strings.Split(s, ",") {
+		switch strings.TrimSpace(step) {
+		case sanitizeLowercase:
+			p.lowercase = true
+		case sanitizeUnderscores:
+			p.underscores = true
+		case sanitizeLong:
+			p.long = true
+		case sanitizeUnicode:
+			p.unicode = true
+		case sanitizeAll:
+			p = namePolicy{lowercase: true, underscores: true, long: true, unicode: true}
+		default:
+			return p, fmt.Errorf("unknown name sanitization step %q, must be %q, %q, %q, %q or %q", step,
+				sanitizeLowercase, sanitizeUnderscores, sanitizeLong, sanitizeUnicode, sanitizeAll)
+		}
+	}
+	return p, nil
+}
+
+// sanitize returns the sanitized name of a service, each of its labels is sanitized on its own
+func (p namePolicy) sanitize(name string) string {
+	if p == (namePolicy{}) {
+		return name
+	}
+	if p.lowercase {
+		name = strings.ToLower(name)
+	}
+	if p.underscores {
+		name = strings.Replace(name, "_", "-", -1)
+	}
+	labels := strings.Split(name, ".")
+	for i, l := range labels {
+		if p.unicode {
+			l = punycodeLabel(l)
+		}
+		if p.long && len(l) > maxLabelLength {
+			l = shortenLabel(l)
+		}
+		labels[i] = l
+	}
+	return strings.Join(labels, ".")
+}
+
+// shortenLabel shortens a label to maxLabelLength, replacing its end with a hash of the whole label
+func shortenLabel(l string) string {
+	h := fnv.New32a()
+	h.Write([]byte(l))
+	suffix := fmt.Sprintf("-%08x", h.Sum32())
+	cut := maxLabelLength - len(suffix)
+	for cut > 0 && !utf8.RuneStart(l[cut]) {
+		cut--
+	}
+	return l[:cut] + suffix
+}
+
+// punycode parameters, RFC 3492 section 5
+const (
+	punycodeBase        = 36
+	punycodeTMin        = 1
+	punycodeTMax        = 26
+	punycodeSkew        = 38
+	punycodeDamp        = 700
+	punycodeInitialBias = 72
+	punycodeInitialN    = 128
+)
+
+// punycodeLabel returns the ASCII form of a label, "xn--" followed by its punycode encoding if it holds non-ASCII
+// characters
+func punycodeLabel(l string) string {
+	for i := 0; i < len(l); i++ {
+		if l[i] >= utf8.RuneSelf {
+			return "xn--" + punycode(l)
+		}
+	}
+	return l
+}
+
+// punycode encodes a string in punycode, RFC 3492 section 6.3
+func punycode(s string) string {
+	runes := []rune(s)
+	out := []byte{}
+	for _, r := range runes {
+		if r < utf8.RuneSelf {
+			out = append(out, byte(r))
+		}
+	}
+	basic := len(out)
+	handled := basic
+	if basic > 0 {
+		out = append(out, '-')
+	}
+	n, delta, bias := rune(punycodeInitialN), 0, punycodeInitialBias
+	for handled < len(runes) {
+		m := rune(utf8.MaxRune)
+		for _, r := range runes {
+			if r >= n && r < m {
+				m = r
+			}
+		}
+		delta += int(m-n) * (handled + 1)
+		n = m
+		for _, r := range runes {
+			if r < n {
+				delta++
+			}
+			if r != n {
+				continue
+			}
+			q := delta
+			for k := punycodeBase; ; k += punycodeBase {
+				t := k - bias
+				if t < punycodeTMin {
+					t = punycodeTMin
+				} else if t > punycodeTMax {
+					t = punycodeTMax
+				}
+				if q < t {
+					break
+				}
+				out = append(out, punycodeDigit(t+(q-t)%(punycodeBase-t)))
+				q = (q - t) / (punycodeBase - t)
+			}
+			out = append(out, punycodeDigit(q))
+			bias = punycodeAdapt(delta, handled+1, handled == basic)
+			delta = 0
+			handled++
+		}
+		delta++
+		n++
+	}
+	return string(out)
+}
+
+// punycodeDigit returns the character of a punycode digit
+func punycodeDigit(d int) byte {
+	if d < 26 {
+		return byte('a' + d)
+	}
+	return byte('0' + d - 26)
+}
+
+// punycodeAdapt adapts the bias after encoding a character, RFC 3492 section 6.1
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punycodeDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((punycodeBase-punycodeTMin)*punycodeTMax)/2 {
+		delta /= punycodeBase - punycodeTMin
+		k += punycodeBase
+	}
+	return k + (punycodeBase-punycodeTMin+1)*delta/(delta+punycodeSkew)
+}
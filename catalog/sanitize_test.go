@@ -0,0 +1,98 @@
+package catalog
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNamePolicy(t *testing.T) {
+	table := map[string]struct {
+		value    string
+		expected namePolicy
+		err      bool
+	}{
+		"empty":    {"", namePolicy{}, false},
+		"single":   {"lowercase", namePolicy{lowercase: true}, false},
+		"list":     {"underscores, unicode", namePolicy{underscores: true, unicode: true}, false},
+		"all":      {"all", namePolicy{lowercase: true, underscores: true, long: true, unicode: true}, false},
+		"unknown":  {"lowercase,dots", namePolicy{}, true},
+		"trailing": {"lowercase,", namePolicy{}, true},
+	}
+	for name, v := range table {
+		p, err := parseNamePolicy(v.value)
+		if v.err {
+			assert.Error(t, err, fmt.Sprintf("Test case: %s", name))
+			continue
+		}
+		assert.NoError(t, err, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expected, p, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestPunycode(t *testing.T) {
+	// RFC 3492 section 7.1 and IDNA examples
+	table := map[string]string{
+		"bücher":  "xn--bcher-kva",
+		"münchen": "xn--mnchen-3ya",
+		"пример":  "xn--e1afmkfd",
+		"日本語":     "xn--wgv71a119e",
+		"web":     "web",
+	}
+	for label, expected := range table {
+		assert.Equal(t, expected, punycodeLabel(label), fmt.Sprintf("Test case: %s", label))
+	}
+}
+
+func TestNamePolicySanitize(t *testing.T) {
+	all := namePolicy{lowercase: true, underscores: true, long: true, unicode: true}
+	long := strings.Repeat("a", 70)
+	table := map[string]struct {
+		policy   namePolicy
+		name     string
+		expected string
+	}{
+		"none":             {namePolicy{}, "My_Service", "My_Service"},
+		"lowercase":        {namePolicy{lowercase: true}, "My_Service", "my_service"},
+		"underscores":      {namePolicy{underscores: true}, "My_Service", "My-Service"},
+		"all":              {all, "My_Service", "my-service"},
+		"unicode":          {all, "Bücher", "xn--bcher-kva"},
+		"labels":           {all, "web.München", "web.xn--mnchen-3ya"},
+		"long label":       {all, long + ".web", shortenLabel(long) + ".web"},
+		"long kept":        {namePolicy{lowercase: true}, long, long},
+		"maximum":          {all, long[:63], long[:63]},
+		"long unicode":     {namePolicy{long: true}, strings.Repeat("ü", 40), shortenLabel(strings.Repeat("ü", 40))},
+		"already accepted": {all, "api-v2", "api-v2"},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, v.policy.sanitize(v.name), fmt.Sprintf("Test case: %s", name))
+	}
+
+	// shortened labels are valid and don't collide
+	a, b := shortenLabel(long+"a"), shortenLabel(long+"b")
+	assert.Len(t, a, maxLabelLength)
+	assert.NotEqual(t, a, b)
+	assert.True(t, len(shortenLabel(strings.Repeat("ü", 40))) <= maxLabelLength)
+}
+
+func TestConsulTransformServices_Sanitized(t *testing.T) {
+	services := map[string][]string{"my_service": {}, "my-service": {}, "Bücher": {}, "web": {}}
+
+	c := consul{log: hclog.NewNullLogger()}
+	c.names, _ = parseNamePolicy("all")
+	require.Equal(t, map[string]service{
+		"xn--bcher-kva": {id: "Bücher", name: "xn--bcher-kva", consulID: "Bücher"},
+		"my-service":    {id: "my-service", name: "my-service", consulID: "my-service"},
+		"web":           {id: "web", name: "web", consulID: "web"},
+	}, c.transformServices(services))
+
+	previews := previewServices([]string{"my-service", "my_service"}, c.transformServices(services), c.names,
+		"", "test.zone", ipv4Family, false)
+	require.Len(t, previews, 2)
+	assert.Equal(t, "my-service.test.zone", previews[0].Domain)
+	assert.Equal(t, "my-service", previews[1].Conflict)
+}
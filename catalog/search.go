@@ -0,0 +1,73 @@
+package catalog
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// searchLimit is the maximum number of records returned by a search. A search returning as many records may be
+// truncated, so the whole zone is fetched instead.
+const searchLimit = 2500
+
+type searchService interface {
+	Search(q string, max int) ([]*searchRecord, *http.Response, error)
+}
+
+// searchRecord is a record returned by the NS1 search API, which lists records of every zone
+type searchRecord struct {
+	dns.ZoneRecord
+	Zone string `json:"zone"`
+}
+
+// ns1SearchService searches the records of the account with the NS1 search API, which the NS1 SDK doesn't wrap
+type ns1SearchService struct {
+	client *ns1api.Client
+}
+
+// Search returns up to `max` records matching `q`
+func (s *ns1SearchService) Search(q string, max int) ([]*searchRecord, *http.Response, error) {
+	path := fmt.Sprintf("search?q=%s&type=record&max=%d", url.QueryEscape(q), max)
+	req, err := s.client.NewRequest("GET", path, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	records := []*searchRecord{}
+	resp, err := s.client.Do(req, &records)
+	if err != nil {
+		return nil, resp, err
+	}
+	return records, resp, nil
+}
+
+// searchZone returns the service zone holding only the records under the service prefix, found with the NS1 search
+// API, so polls don't list the records of the zone that aren't managed. The whole zone is fetched when the search
+// fails or may be truncated.
+func (n *ns1) searchZone() (*dns.Zone, error) {
+	n.limiter.read()
+	records, resp, err := n.client.Search.Search(n.ns1Prefix, searchLimit)
+	if err != nil {
+		n.log.Warn("cannot search records under the service prefix, fetching the whole zone", "error", ns1Error(resp, err).Error())
+		return n.fetchZone(n.serviceZone.name)
+	}
+	if len(records) >= searchLimit {
+		n.log.Warn("search for records under the service prefix may be truncated, fetching the whole zone",
+			"limit", fmt.Sprintf("%d", searchLimit))
+		return n.fetchZone(n.serviceZone.name)
+	}
+	zone := &dns.Zone{ID: n.serviceZone.id, Zone: n.serviceZone.name, Records: []*dns.ZoneRecord{}}
+	for _, r := range records {
+		// the search matches the prefix anywhere in the names of records of any zone
+		if r.Zone != n.serviceZone.name || !strings.HasPrefix(r.Domain, n.ns1Prefix) ||
+			!strings.HasSuffix(r.Domain, "."+n.serviceZone.name) {
+			continue
+		}
+		record := r.ZoneRecord
+		zone.Records = append(zone.Records, &record)
+	}
+	return zone, nil
+}
@@ -0,0 +1,61 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+type mockSearchService struct {
+	records []*searchRecord
+	err     error
+}
+
+func (m *mockSearchService) Search(q string, max int) ([]*searchRecord, *http.Response, error) {
+	if m.err != nil {
+		return nil, nil, m.err
+	}
+	return m.records, nil, nil
+}
+
+func TestSearchZone(t *testing.T) {
+	whole, _, _ := (&mockZoneService{}).Get("test.zone")
+	wholeIDs := []string{}
+	for _, r := range whole.Records {
+		wholeIDs = append(wholeIDs, r.ID)
+	}
+	found := []*searchRecord{
+		{ZoneRecord: dns.ZoneRecord{Domain: "consul-web.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1"}, Type: "A"}, Zone: "test.zone"},
+		{ZoneRecord: dns.ZoneRecord{Domain: "consul-web.other.zone", ID: "r3", Type: "A"}, Zone: "other.zone"},
+		{ZoneRecord: dns.ZoneRecord{Domain: "www.consul-web.test.zone", ID: "r4", Type: "A"}, Zone: "test.zone"},
+	}
+	truncated := make([]*searchRecord, searchLimit)
+	for i := range truncated {
+		truncated[i] = &searchRecord{ZoneRecord: dns.ZoneRecord{Domain: fmt.Sprintf("consul-%d.test.zone", i)}, Zone: "test.zone"}
+	}
+	table := map[string]struct {
+		search   *mockSearchService
+		expected []string
+	}{
+		"managed records":   {&mockSearchService{records: found}, []string{"r1"}},
+		"failed search":     {&mockSearchService{err: errors.New("search failed")}, wholeIDs},
+		"truncated results": {&mockSearchService{records: truncated}, wholeIDs},
+	}
+	for name, v := range table {
+		n := testClient(nil)
+		n.ns1Prefix = "consul-"
+		n.client = &ns1APIClient{Zones: &mockZoneService{}, Search: v.search}
+		zone, err := n.searchZone()
+		require.NoError(t, err, fmt.Sprintf("Test case: %s", name))
+		ids := []string{}
+		for _, r := range zone.Records {
+			ids = append(ids, r.ID)
+		}
+		assert.Equal(t, v.expected, ids, fmt.Sprintf("Test case: %s", name))
+	}
+}
@@ -0,0 +1,130 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// serviceSelection publishes a subset of the services of the catalog, selected by their name with allow and deny
+// patterns. The records of services it doesn't select are out of scope, so they're neither removed nor updated.
+type serviceSelection struct {
+	// allow selects the services matching any of its patterns, all services if it's empty
+	allow []*regexp.Regexp
+	// deny leaves out the services matching any of its patterns, it takes precedence over `allow`
+	deny []*regexp.Regexp
+	// prefix, naming, dcSubdomains, tagSubdomains and srvTargets tell the service of records from their name, see
+	// `service`
+	prefix        string
+	naming        *domainTemplate
+	dcSubdomains  bool
+	tagSubdomains bool
+	srvTargets    bool
+}
+
+// parseServicePatterns parses a comma-separated list of service name patterns. Patterns between slashes are
+// regular expressions, e.g. "/^web-[0-9]+$/", others are globs where "*" matches any characters and "?" any
+// character, e.g. "web-*". Patterns are case-insensitive, like DNS names.
+func parseServicePatterns(s string) ([]*regexp.Regexp, error) {
+	patterns := []*regexp.Regexp{}
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		expr := ""
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			expr = p[1 : len(p)-1]
+		} else {
+			expr = "^" + strings.NewReplacer(`\*`, ".*", `\?`, ".").Replace(regexp.QuoteMeta(p)) + "$"
+		}
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid service pattern %q: %s", p, err)
+		}
+		patterns = append(patterns, re)
+	}
+	return patterns, nil
+}
+
+// matchesAny reports whether a name matches any of the patterns
+func matchesAny(patterns []*regexp.Regexp, name string) bool {
+	for _, p := range patterns {
+		if p.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// selects reports whether a service is published, by the name it's published under. All services are without
+// selection.
+func (s *serviceSelection) selects(name string) bool {
+	if s == nil {
+		return true
+	}
+	if len(s.allow) > 0 && !matchesAny(s.allow, name) {
+		return false
+	}
+	return !matchesAny(s.deny, name)
+}
+
+// service returns the service the records with a name, without prefix and zone, are published for: the service
+// the domain template rendered the name for, the service of a datacenter or tag subdomain, or the service an SRV
+// target is published for. Names are the names of services otherwise.
+func (s *serviceSelection) service(name string) string {
+	switch {
+	case s.naming != nil:
+		return s.naming.service(name)
+	case !strings.Contains(name, "."):
+		return name
+	case s.dcSubdomains:
+		return name[:strings.LastIndex(name, ".")]
+	case s.tagSubdomains || s.srvTargets:
+		return strings.TrimPrefix(name[strings.Index(name, ".")+1:], s.prefix)
+	}
+	return name
+}
+
+// selectsRecords reports whether the records with a name, without prefix and zone, belong to a selected service
+func (s *serviceSelection) selectsRecords(name string) bool {
+	if s == nil {
+		return true
+	}
+	return s.selects(s.service(name))
+}
+
+// newServiceSelection returns the selection of services configured by the allow and deny patterns, nil if there
+// are none. Datacenter subdomains can't be combined with tag subdomains or SRV target hostnames, as the service of
+// a dotted name would be ambiguous.
+func newServiceSelection(cfg Config) (*serviceSelection, error) {
+	allow, err := parseServicePatterns(cfg.AllowServices)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := parseServicePatterns(cfg.DenyServices)
+	if err != nil {
+		return nil, err
+	}
+	if len(allow) == 0 && len(deny) == 0 {
+		return nil, nil
+	}
+	if cfg.DatacenterSubdomains && (cfg.TagSubdomains || cfg.SRVTargetHostnames) {
+		return nil, errors.New("allow and deny patterns can't be combined with datacenter subdomains and tag " +
+			"subdomains or SRV target hostnames")
+	}
+	naming, err := parseDomainTemplate(cfg.DomainTemplate)
+	if err != nil {
+		return nil, err
+	}
+	return &serviceSelection{
+		allow:         allow,
+		deny:          deny,
+		prefix:        cfg.NS1Prefix,
+		naming:        naming,
+		dcSubdomains:  cfg.DatacenterSubdomains,
+		tagSubdomains: cfg.TagSubdomains,
+		srvTargets:    cfg.SRVTargetHostnames,
+	}, nil
+}
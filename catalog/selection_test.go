@@ -0,0 +1,95 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestServiceSelection_Selects(t *testing.T) {
+	table := map[string]struct {
+		allow    string
+		deny     string
+		selected []string
+		left     []string
+	}{
+		"none":         {"", "", []string{"web", "db"}, nil},
+		"glob":         {"web-*, api", "", []string{"web-1", "WEB-2", "api"}, []string{"web", "api-v2", "db"}},
+		"single char":  {"web-?", "", []string{"web-1"}, []string{"web-12"}},
+		"regex":        {"/^web-[0-9]+$/", "", []string{"web-1", "web-12"}, []string{"web-a", "legacy-web-1"}},
+		"unanchored":   {"/web/", "", []string{"web", "legacy-web-1"}, []string{"db"}},
+		"deny":         {"", "*-internal,/^test-/", []string{"web", "internal"}, []string{"web-internal", "test-web"}},
+		"deny precede": {"web*", "web-internal", []string{"web", "web-1"}, []string{"web-internal", "db"}},
+		"literal dots": {"web.v1", "", []string{"web.v1"}, []string{"webxv1"}},
+	}
+	for name, v := range table {
+		selection, err := newServiceSelection(Config{AllowServices: v.allow, DenyServices: v.deny})
+		require.NoError(t, err, fmt.Sprintf("Test case: %s", name))
+		for _, s := range v.selected {
+			assert.True(t, selection.selects(s), fmt.Sprintf("Test case: %s, service %s", name, s))
+		}
+		for _, s := range v.left {
+			assert.False(t, selection.selects(s), fmt.Sprintf("Test case: %s, service %s", name, s))
+		}
+	}
+
+	_, err := newServiceSelection(Config{AllowServices: "/web[/"})
+	assert.Error(t, err)
+	_, err = newServiceSelection(Config{DenyServices: "db", DatacenterSubdomains: true, TagSubdomains: true})
+	assert.Error(t, err)
+}
+
+func TestServiceSelection_Service(t *testing.T) {
+	table := map[string]struct {
+		cfg      Config
+		name     string
+		expected string
+	}{
+		"service":              {Config{}, "web", "web"},
+		"dotted":               {Config{}, "web.v1", "web.v1"},
+		"datacenter subdomain": {Config{DatacenterSubdomains: true}, "web.dc1", "web"},
+		"tag subdomain":        {Config{TagSubdomains: true}, "primary.web", "web"},
+		"SRV target":           {Config{NS1Prefix: "p-", SRVTargetHostnames: true}, "host-1.p-web", "web"},
+		"domain template":      {Config{DomainTemplate: "{prefix}{service}.{dc}.{zone}"}, "web.dc1", "web"},
+	}
+	for name, v := range table {
+		v.cfg.AllowServices = "*"
+		selection, err := newServiceSelection(v.cfg)
+		require.NoError(t, err, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expected, selection.service(v.name), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestConsulTransformServices_Selection(t *testing.T) {
+	selection, err := newServiceSelection(Config{AllowServices: "web*", DenyServices: "web-internal"})
+	require.NoError(t, err)
+	c := consul{log: hclog.NewNullLogger(), selection: selection}
+	services := c.transformServices(map[string][]string{"web": {}, "web-internal": {}, "db": {}, "WEB-1": {}})
+	assert.Equal(t, map[string]service{
+		"web":   {id: "web", name: "web", consulID: "web"},
+		"WEB-1": {id: "WEB-1", name: "WEB-1", consulID: "WEB-1"},
+	}, services)
+}
+
+func TestTransformZoneRecords_Selection(t *testing.T) {
+	selection, err := newServiceSelection(Config{NS1Prefix: "a-", DenyServices: "db", DatacenterSubdomains: true})
+	require.NoError(t, err)
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, log: hclog.NewNullLogger(), ns1Prefix: "a-",
+		ownershipRegistry: true, selection: selection}
+	z := &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+		{Domain: "a-web.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1"}, Type: "A", TTL: 1},
+		{Domain: "a-web.dc1.test.zone", ID: "r2", ShortAns: []string{"1.1.1.1"}, Type: "A", TTL: 1},
+		{Domain: "a-db.test.zone", ID: "r3", ShortAns: []string{"2.2.2.2"}, Type: "A", TTL: 1},
+		{Domain: "_consul-ns1.a-db.test.zone", ID: "r4", ShortAns: []string{ownerTXTAnswer("a-")}, Type: "TXT", TTL: 1},
+		{Domain: "a-db.dc1.test.zone", ID: "r5", ShortAns: []string{"2.2.2.2"}, Type: "A", TTL: 1},
+	}}
+	services := n.transformZoneRecords(z)
+	assert.Len(t, services, 2)
+	assert.Contains(t, services, "web")
+	assert.Contains(t, services, "web.dc1")
+	assert.Len(t, n.managedRecords(z), 2, "the records of services that aren't selected aren't managed")
+}
@@ -1,6 +1,14 @@
 package catalog
 
-import "fmt"
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nsone/consul-ns1/diff"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
 
 type health string
 
@@ -10,6 +18,53 @@ const (
 	unknown  health = ""
 )
 
+// severity ranks a health status, higher values are worse
+func (h health) severity() int {
+	switch h {
+	case passing:
+		return 0
+	case critical:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// healthAggregation is a policy for combining the statuses of multiple health checks of an instance
+type healthAggregation string
+
+const (
+	// worstHealth uses the worst status of all checks
+	worstHealth healthAggregation = "worst"
+	// bestHealth uses the best status of all checks
+	bestHealth healthAggregation = "best"
+)
+
+// parseHealthAggregation validates a health aggregation policy, an empty policy defaults to worstHealth
+func parseHealthAggregation(s string) (healthAggregation, error) {
+	switch healthAggregation(s) {
+	case "", worstHealth:
+		return worstHealth, nil
+	case bestHealth:
+		return bestHealth, nil
+	}
+	return "", fmt.Errorf("unknown health aggregation policy %q, must be one of %q or %q", s, worstHealth, bestHealth)
+}
+
+// aggregate combines two health statuses according to the policy
+func (p healthAggregation) aggregate(a, b health) health {
+	if p == bestHealth {
+		if b.severity() < a.severity() {
+			return b
+		}
+		return a
+	}
+	if b.severity() > a.severity() {
+		return b
+	}
+	return a
+}
+
 type service struct {
 	id       string
 	name     string
@@ -18,24 +73,127 @@ type service struct {
 	ttls     recordTTLs
 	ns1IDs   recordIDs
 	consulID string
+	// txtRecAnswer holds the port hints published in a TXT record next to the A record, e.g. "ports=8080,8443"
+	txtRecAnswer string
+	// cnameRecAnswer holds the hostname a virtual-hosted service is published as a CNAME to
+	cnameRecAnswer string
+	// httpsRecAnswer holds the HTTPS record answer declared by the meta of the instances, see `httpsAnswer`
+	httpsRecAnswer string
+	// ownerRecAnswer holds the ownership marker published in the registry TXT record of the service
+	ownerRecAnswer string
+	// unchanged flags records that already match the desired state and don't need to be written
+	unchanged recordTypes
+	// healthyInstances is the number of instances passing their checks, only counted when published
+	healthyInstances int
+	// srvTarget flags the records of a node the SRV answers of a service point to, see `addSRVTargets`
+	srvTarget bool
+	// datacenter is the datacenter of the instances of a datacenter subdomain of a service, empty for other
+	// services, see `addDatacenterSubdomains`
+	datacenter string
+	// tag is the tag of the instances of a tag subdomain of a service, empty for other services, see
+	// `addTagSubdomains`
+	tag string
+	// publishAfter is the Consul service whose records must be published and healthy before the records of
+	// the service are created, see `holdDependents`
+	publishAfter string
+	// ttlOverride replaces the default TTL of the records of the service when non-zero, see `serviceTTL`
+	ttlOverride int64
+	// filters replaces the default filter chain of the A, AAAA and SRV records of the service when non-nil,
+	// see `serviceFilters`
+	filters []*filter.Filter
+	// filterKeys holds the `overrideKey` of the filter chain and EDNS Client Subnet overrides of each record
+	filterKeys map[diff.Family]string
+	// clientSubnet enables or disables EDNS Client Subnet on the A, AAAA and SRV records of the service when
+	// non-nil, see `serviceClientSubnet`
+	clientSubnet *bool
 }
 
+// node is a single instance of a service. Instances fetched from Consul are keyed by `instanceKey`,
+// instances read back from NS1 are keyed by address as NS1 answers carry no instance identity.
 type node struct {
-	host          string
-	datacenter    string
+	// host is the name of the Consul node the instance runs on
+	host       string
+	datacenter string
+	// consulID is the ServiceID of the instance
 	consulID      string
+	address       string
+	port          int
 	aRecAnswer    string
+	aaaaRecAnswer string
 	srvRecAnswers map[int]srvAnswer
+	// answerWeight is the weight of the A and AAAA answers of the instance, 0 if answers aren't weighted
+	answerWeight int64
+	// geo is the location published in the meta of the A and AAAA answers of the instance, see `geoMeta`
+	geo geoMeta
+	// region is the NS1 region the answers of the instance belong to, the datacenter of the instance with
+	// -ns1-datacenter-regions
+	region string
+	// version is the version of the instance noted on its A and AAAA answers, see `instanceVersion`
+	version string
+	// taggedAnswers holds the tagged addresses of the instance published next to its address, keyed by
+	// address with the tag as value, see `taggedAnswers`
+	taggedAnswers map[string]string
+	// down marks the answers of the instance down, see `markDown`
+	down bool
+}
+
+// instanceKey identifies a service instance. A ServiceID is only unique within a Consul node,
+// so the node name is part of the key.
+func instanceKey(nodeName, serviceID string) string {
+	return nodeName + "/" + serviceID
 }
 
 type recordIDs struct {
-	aRecID   string
-	srvRecID string
+	aRecID     string
+	aaaaRecID  string
+	srvRecID   string
+	txtRecID   string
+	cnameRecID string
+	httpsRecID string
+	// ownerRecID is the ID of the TXT record marking the service as owned by this instance
+	ownerRecID string
+}
+
+// recordTypes holds a flag for each type of record managed for a service
+type recordTypes struct {
+	aRec     bool
+	aaaaRec  bool
+	srvRec   bool
+	txtRec   bool
+	cnameRec bool
+	httpsRec bool
+	ownerRec bool
 }
 
 type recordTTLs struct {
-	aRecTTL   int64
-	srvRecTTL int64
+	aRecTTL     int64
+	aaaaRecTTL  int64
+	srvRecTTL   int64
+	txtRecTTL   int64
+	cnameRecTTL int64
+	httpsRecTTL int64
+}
+
+// portsTXTPrefix is the prefix of the TXT answer holding the port hints of a service
+const portsTXTPrefix = "ports="
+
+// portsTXTAnswer returns a TXT answer listing the sorted, de-duplicated ports of a map of nodes,
+// for clients that can only consume A records
+func portsTXTAnswer(nodes map[string]node) string {
+	seen := map[int64]struct{}{}
+	ports := []int{}
+	for _, a := range srvAnswers(nodes) {
+		if _, ok := seen[a.port]; !ok {
+			seen[a.port] = struct{}{}
+			ports = append(ports, int(a.port))
+		}
+	}
+	sort.Ints(ports)
+	portStrs := make([]string, len(ports))
+	for i, p := range ports {
+		portStrs[i] = strconv.Itoa(p)
+	}
+	return portsTXTPrefix + strings.Join(portStrs, ",")
 }
 
 type srvAnswer struct {
@@ -49,99 +207,259 @@ func (a srvAnswer) String() string {
 	return fmt.Sprintf("%d %d %d %s", a.priority, a.weight, a.port, a.address)
 }
 
-// serviceOnlyInFirst compares two maps of services and returns a map of the ones that only exist in the first map.
-// It ignores diffs between nodes or answers and only includes answer in result if serviceA does not exist servicesB.
-func serviceOnlyInFirst(servicesA, servicesB map[string]service) map[string]service {
-	result := map[string]service{}
-	for k, sa := range servicesA {
-		if _, ok := servicesB[k]; !ok {
-			result[k] = sa
+// aAnswers returns the sorted, de-duplicated A record answers of a map of nodes
+func aAnswers(nodes map[string]node) []string {
+	return nodeAnswers(nodes, node.v4Answers)
+}
+
+// aaaaAnswers returns the sorted, de-duplicated AAAA record answers of a map of nodes
+func aaaaAnswers(nodes map[string]node) []string {
+	return nodeAnswers(nodes, node.v6Answers)
+}
+
+// v4Answers returns the A record answers of an instance, its IPv4 address followed by its tagged IPv4 addresses
+func (n node) v4Answers() []string {
+	return append([]string{n.aRecAnswer}, n.tagged(true)...)
+}
+
+// v6Answers returns the AAAA record answers of an instance, its IPv6 address followed by its tagged IPv6 addresses
+func (n node) v6Answers() []string {
+	return append([]string{n.aaaaRecAnswer}, n.tagged(false)...)
+}
+
+// srvAnswerStrings returns the SRV record answers of an instance
+func (n node) srvAnswerStrings() []string {
+	answers := make([]string, 0, len(n.srvRecAnswers))
+	for _, a := range n.srvRecAnswers {
+		answers = append(answers, a.String())
+	}
+	return answers
+}
+
+// nodeAnswers returns the sorted, de-duplicated non-empty answers selected by `answers` from a map of nodes
+func nodeAnswers(nodes map[string]node, answers func(node) []string) []string {
+	seen := map[string]struct{}{}
+	result := []string{}
+	for _, n := range nodes {
+		for _, a := range answers(n) {
+			if a == "" {
+				continue
+			}
+			if _, ok := seen[a]; !ok {
+				seen[a] = struct{}{}
+				result = append(result, a)
+			}
 		}
 	}
+	sort.Strings(result)
 	return result
 }
 
-// nodesAreEqual determines if two maps of nodes are considered equal
-func nodesAreEqual(expected, actual map[string]node) bool {
-	if len(expected) != len(actual) {
-		return false
-	}
-	for h, expectedNode := range expected {
-		if _, ok := actual[h]; !ok {
-			return false
-		}
-		actualNode := actual[h]
-		// compare A record answers
-		if actualNode.aRecAnswer != expectedNode.aRecAnswer {
-			return false
-		}
-		// compare SRV record answers
-		if len(expectedNode.srvRecAnswers) != len(actualNode.srvRecAnswers) {
-			return false
+// srvAnswers returns the sorted, de-duplicated SRV record answers of a map of nodes
+func srvAnswers(nodes map[string]node) []srvAnswer {
+	seen := map[srvAnswer]struct{}{}
+	answers := []srvAnswer{}
+	for _, n := range nodes {
+		for _, a := range n.srvRecAnswers {
+			if _, ok := seen[a]; !ok {
+				seen[a] = struct{}{}
+				answers = append(answers, a)
+			}
 		}
-		for p, expectedSrv := range expectedNode.srvRecAnswers {
-			if actualSrv, ok := actualNode.srvRecAnswers[p]; !ok || expectedSrv != actualSrv {
-				return false
+	}
+	sort.Slice(answers, func(i, j int) bool { return answers[i].String() < answers[j].String() })
+	return answers
+}
+
+// ownerFamily is the family of the TXT record marking a service as owned by this instance, see `ownerTXTAnswer`
+const ownerFamily diff.Family = "OWNER"
+
+// recordFamily maps a type of record managed for a service to the diff engine
+type recordFamily struct {
+	family diff.Family
+	// record returns the record of the family of a service
+	record func(s service) diff.Record
+	// store sets the ID and TTL of the record of the family of a service, and whether it is unchanged
+	store func(s *service, r diff.Record, unchanged bool)
+}
+
+// recordFamilies are the types of records managed for a service
+var recordFamilies = []recordFamily{
+	{
+		family: diff.A,
+		record: func(s service) diff.Record {
+			return diff.Record{Answers: recordAnswers(s.nodes, node.v4Answers), TTL: s.ttls.aRecTTL, ID: s.ns1IDs.aRecID,
+				Filters: s.filterKeys[diff.A]}
+		},
+		store: func(s *service, r diff.Record, unchanged bool) {
+			s.ns1IDs.aRecID, s.ttls.aRecTTL, s.unchanged.aRec = r.ID, r.TTL, unchanged
+		},
+	},
+	{
+		family: diff.AAAA,
+		record: func(s service) diff.Record {
+			return diff.Record{Answers: recordAnswers(s.nodes, node.v6Answers), TTL: s.ttls.aaaaRecTTL, ID: s.ns1IDs.aaaaRecID,
+				Filters: s.filterKeys[diff.AAAA]}
+		},
+		store: func(s *service, r diff.Record, unchanged bool) {
+			s.ns1IDs.aaaaRecID, s.ttls.aaaaRecTTL, s.unchanged.aaaaRec = r.ID, r.TTL, unchanged
+		},
+	},
+	{
+		family: diff.SRV,
+		record: func(s service) diff.Record {
+			answers := []string{}
+			down := downAnswers(s.nodes, node.srvAnswerStrings)
+			regions := nodeRegions(s.nodes, node.srvAnswerStrings)
+			for _, a := range srvAnswers(s.nodes) {
+				answer := a.String()
+				if down[a.String()] {
+					answer += " up=false"
+				}
+				if region, ok := regions[a.String()]; ok {
+					answer += " region=" + region
+				}
+				answers = append(answers, answer)
 			}
+			return diff.Record{Answers: answers, TTL: s.ttls.srvRecTTL, ID: s.ns1IDs.srvRecID, Filters: s.filterKeys[diff.SRV]}
+		},
+		store: func(s *service, r diff.Record, unchanged bool) {
+			s.ns1IDs.srvRecID, s.ttls.srvRecTTL, s.unchanged.srvRec = r.ID, r.TTL, unchanged
+		},
+	},
+	{
+		family: diff.TXT,
+		record: func(s service) diff.Record {
+			return diff.Record{Answers: []string{s.txtRecAnswer}, TTL: s.ttls.txtRecTTL, ID: s.ns1IDs.txtRecID}
+		},
+		store: func(s *service, r diff.Record, unchanged bool) {
+			s.ns1IDs.txtRecID, s.ttls.txtRecTTL, s.unchanged.txtRec = r.ID, r.TTL, unchanged
+		},
+	},
+	{
+		family: diff.CNAME,
+		record: func(s service) diff.Record {
+			return diff.Record{Answers: []string{s.cnameRecAnswer}, TTL: s.ttls.cnameRecTTL, ID: s.ns1IDs.cnameRecID}
+		},
+		store: func(s *service, r diff.Record, unchanged bool) {
+			s.ns1IDs.cnameRecID, s.ttls.cnameRecTTL, s.unchanged.cnameRec = r.ID, r.TTL, unchanged
+		},
+	},
+	{
+		family: diff.HTTPS,
+		record: func(s service) diff.Record {
+			return diff.Record{Answers: []string{s.httpsRecAnswer}, TTL: s.ttls.httpsRecTTL, ID: s.ns1IDs.httpsRecID}
+		},
+		store: func(s *service, r diff.Record, unchanged bool) {
+			s.ns1IDs.httpsRecID, s.ttls.httpsRecTTL, s.unchanged.httpsRec = r.ID, r.TTL, unchanged
+		},
+	},
+	{
+		family: ownerFamily,
+		record: func(s service) diff.Record {
+			return diff.Record{Answers: []string{s.ownerRecAnswer}, ID: s.ns1IDs.ownerRecID}
+		},
+		store: func(s *service, r diff.Record, unchanged bool) {
+			s.ns1IDs.ownerRecID, s.unchanged.ownerRec = r.ID, unchanged
+		},
+	},
+}
+
+// srvTargetFamilies are the families of the records of a node SRV answers point to, see `addSRVTargets`
+var srvTargetFamilies = map[diff.Family]bool{diff.A: true, diff.AAAA: true, ownerFamily: true}
+
+// entry returns the records of all families of a service, only the ones of `srvTargetFamilies` for the node an SRV
+// answer points to
+func (s service) entry() diff.Entry {
+	e := diff.Entry{}
+	for _, f := range recordFamilies {
+		if !s.srvTarget || srvTargetFamilies[f.family] {
+			e[f.family] = f.record(s)
 		}
 	}
+	return e
+}
 
-	return true
+// compareEntries returns the records of a desired service and of the service read back from NS1 to compare them
+// by, the families of the desired service. Services read back don't know whether they are the node of an SRV answer.
+func compareEntries(desired, actual service) (diff.Entry, diff.Entry) {
+	actual.srvTarget = desired.srvTarget
+	return desired.entry(), actual.entry()
+}
+
+// entries returns the records of all families of a map of services
+func entries(services map[string]service) map[string]diff.Entry {
+	result := map[string]diff.Entry{}
+	for k, s := range services {
+		result[k] = s.entry()
+	}
+	return result
+}
+
+// serviceOnlyInFirst compares two maps of services and returns a map of the ones that only exist in the first map.
+// It ignores diffs between nodes or answers and only includes answer in result if serviceA does not exist servicesB.
+func serviceOnlyInFirst(servicesA, servicesB map[string]service) map[string]service {
+	result := map[string]service{}
+	for _, k := range diff.Removed(entries(servicesB), entries(servicesA)) {
+		result[k] = servicesA[k]
+	}
+	return result
+}
+
+// nodesAreEqual determines if two maps of nodes are considered equal, i.e. they result in the same answers
+func nodesAreEqual(expected, actual map[string]node) bool {
+	return !diff.Changed(service{nodes: expected}.entry(), service{nodes: actual}.entry())
 }
 
 // onlyInFirst compares two maps of services and returns a map of the ones that only exist in the first map.
 // On any diff between a service's nodes in A vs B, all nodes are included return map.
+// The records of each family are compared independently, records that don't differ are flagged as unchanged.
 func onlyInFirst(servicesA, servicesB map[string]service) map[string]service {
 	result := map[string]service{}
 	for k, sa := range servicesA {
-		if sb, ok := servicesB[k]; !ok {
+		sb, ok := servicesB[k]
+		if !ok {
 			// service k is not defined in servicesB, should be in results
 			result[k] = sa
-		} else {
-			nodes := map[string]node{}
-			// if nodes aren't equal or TTLs don't match
-			if !nodesAreEqual(sa.nodes, sb.nodes) || sa.ttls != sb.ttls {
-				nodes = sa.nodes
-				id := sa.id
-				if len(sa.id) == 0 {
-					id = sb.id
-				}
-				name := sa.name
-				if len(sa.name) == 0 {
-					name = sb.name
-				}
-				ns1IDs := recordIDs{
-					aRecID:   sa.ns1IDs.aRecID,
-					srvRecID: sa.ns1IDs.srvRecID,
-				}
-				if len(ns1IDs.aRecID) == 0 {
-					ns1IDs.aRecID = sb.ns1IDs.aRecID
-				}
-				if len(ns1IDs.srvRecID) == 0 {
-					ns1IDs.srvRecID = sb.ns1IDs.srvRecID
-				}
-				ttls := recordTTLs{
-					aRecTTL:   sa.ttls.aRecTTL,
-					srvRecTTL: sa.ttls.srvRecTTL,
-				}
-				if ttls.aRecTTL == 0 {
-					ttls.aRecTTL = sb.ttls.aRecTTL
-				}
-				if ttls.srvRecTTL == 0 {
-					ttls.srvRecTTL = sb.ttls.srvRecTTL
-				}
-				s := service{
-					id:     id,
-					name:   name,
-					ttls:   ttls,
-					ns1IDs: ns1IDs,
-				}
-				if len(nodes) > 0 {
-					s.nodes = nodes
-				}
-				result[k] = s
-			}
+			continue
+		}
+		ea, eb := compareEntries(sa, sb)
+		if !diff.Changed(ea, eb) {
+			continue
+		}
+		s := service{
+			id:               sa.id,
+			name:             sa.name,
+			healths:          sa.healths,
+			consulID:         sa.consulID,
+			txtRecAnswer:     sa.txtRecAnswer,
+			cnameRecAnswer:   sa.cnameRecAnswer,
+			httpsRecAnswer:   sa.httpsRecAnswer,
+			ownerRecAnswer:   sa.ownerRecAnswer,
+			healthyInstances: sa.healthyInstances,
+			srvTarget:        sa.srvTarget,
+			datacenter:       sa.datacenter,
+			tag:              sa.tag,
+			publishAfter:     sa.publishAfter,
+			ttlOverride:      sa.ttlOverride,
+			filters:          sa.filters,
+			filterKeys:       sa.filterKeys,
+			clientSubnet:     sa.clientSubnet,
+		}
+		if len(s.id) == 0 {
+			s.id = sb.id
+		}
+		if len(s.name) == 0 {
+			s.name = sb.name
+		}
+		if len(sa.nodes) > 0 {
+			s.nodes = sa.nodes
+		}
+		unchanged, merged := diff.Unchanged(ea, eb), diff.Merge(ea, eb)
+		for _, f := range recordFamilies {
+			f.store(&s, merged[f.family], unchanged[f.family])
 		}
+		result[k] = s
 	}
 	return result
 }
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/nsone/consul-ns1/diff"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
 )
 
 func TestNodesAreEqual(t *testing.T) {
@@ -23,12 +25,12 @@ func TestNodesAreEqual(t *testing.T) {
 		"Node only in first": {
 			a:        map[string]node{"h1": {}},
 			b:        map[string]node{},
-			expected: false,
+			expected: true,
 		},
 		"Node only in second": {
 			a:        map[string]node{},
 			b:        map[string]node{"h1": {}},
-			expected: false,
+			expected: true,
 		},
 		"Node in both": {
 			a:        map[string]node{"h1": {}},
@@ -38,7 +40,12 @@ func TestNodesAreEqual(t *testing.T) {
 		"Extra node in second": {
 			a:        map[string]node{"h1": {}},
 			b:        map[string]node{"h1": {}, "h2": {}},
-			expected: false,
+			expected: true,
+		},
+		"Same answers keyed differently": {
+			a:        map[string]node{"n1/s1": {aRecAnswer: "1.1.1.1"}, "n1/s2": {aRecAnswer: "1.1.1.1"}},
+			b:        map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}},
+			expected: true,
 		},
 		"A record answer only in first": {
 			a:        map[string]node{"h1": {aRecAnswer: "1.1.1.1"}},
@@ -119,6 +126,55 @@ func TestNodesAreEqual(t *testing.T) {
 	}
 }
 
+func TestAnswersAreEqual(t *testing.T) {
+	type variant struct {
+		a           map[string]node
+		b           map[string]node
+		expectedA   bool
+		expectedSRV bool
+	}
+	srv := map[int]srvAnswer{1: srvAnswer{priority: 1, weight: 1, port: 1, address: "1.1.1.1"}}
+
+	table := map[string]variant{
+		"Empty nodes": {
+			a:           map[string]node{},
+			b:           map[string]node{},
+			expectedA:   true,
+			expectedSRV: true,
+		},
+		"Nodes without answers": {
+			a:           map[string]node{"h1": {}},
+			b:           map[string]node{"h2": {}},
+			expectedA:   true,
+			expectedSRV: true,
+		},
+		"Only A answer differs": {
+			a:           map[string]node{"h1": {aRecAnswer: "1.1.1.1", srvRecAnswers: srv}},
+			b:           map[string]node{"h1": {srvRecAnswers: srv}},
+			expectedA:   false,
+			expectedSRV: true,
+		},
+		"Only SRV answer differs": {
+			a:           map[string]node{"h1": {aRecAnswer: "1.1.1.1", srvRecAnswers: srv}},
+			b:           map[string]node{"h1": {aRecAnswer: "1.1.1.1"}},
+			expectedA:   true,
+			expectedSRV: false,
+		},
+		"Both answers differ": {
+			a:           map[string]node{"h1": {aRecAnswer: "1.1.1.1", srvRecAnswers: srv}},
+			b:           map[string]node{"h2": {aRecAnswer: "2.2.2.2"}},
+			expectedA:   false,
+			expectedSRV: false,
+		},
+	}
+
+	for name, v := range table {
+		unchanged := diff.Unchanged(service{nodes: v.a}.entry(), service{nodes: v.b}.entry())
+		assert.Equal(t, v.expectedA, unchanged[diff.A], fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.expectedSRV, unchanged[diff.SRV], fmt.Sprintf("Test case: %s", name))
+	}
+}
+
 func TestOnlyInFirst(t *testing.T) {
 	type variant struct {
 		a        map[string]service
@@ -155,7 +211,7 @@ func TestOnlyInFirst(t *testing.T) {
 				"s5": {nodes: map[string]node{"h2": {aRecAnswer: "2.2.2.2"}}},
 			},
 			expected: map[string]service{
-				"s5": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}},
+				"s5": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}, unchanged: recordTypes{aaaaRec: true, srvRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true}},
 			},
 		},
 		"Extra node in second": {
@@ -166,7 +222,7 @@ func TestOnlyInFirst(t *testing.T) {
 				"s5": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}, "h2": {aRecAnswer: "2.2.2.2"}}},
 			},
 			expected: map[string]service{
-				"s5": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}},
+				"s5": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}, unchanged: recordTypes{aaaaRec: true, srvRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true}},
 			},
 		},
 		"SRV answer only in first": {
@@ -194,6 +250,7 @@ func TestOnlyInFirst(t *testing.T) {
 			},
 			expected: map[string]service{
 				"s6": {
+					unchanged: recordTypes{aRec: true, aaaaRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true},
 					nodes: map[string]node{
 						"h1": {
 							srvRecAnswers: map[int]srvAnswer{
@@ -230,6 +287,7 @@ func TestOnlyInFirst(t *testing.T) {
 			},
 			expected: map[string]service{
 				"s7": {
+					unchanged: recordTypes{aRec: true, aaaaRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true},
 					nodes: map[string]node{
 						"h1": {
 							srvRecAnswers: map[int]srvAnswer{
@@ -266,6 +324,7 @@ func TestOnlyInFirst(t *testing.T) {
 			},
 			expected: map[string]service{
 				"s8": {
+					unchanged: recordTypes{aRec: true, aaaaRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true},
 					nodes: map[string]node{
 						"h1": {
 							srvRecAnswers: map[int]srvAnswer{
@@ -283,7 +342,7 @@ func TestOnlyInFirst(t *testing.T) {
 					id:     "id",
 					name:   "name",
 					ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"},
-					nodes:  map[string]node{"h1": {}, "h2": {}},
+					nodes:  map[string]node{"h1": {aRecAnswer: "1.1.1.1"}, "h2": {}},
 				},
 			},
 			b: map[string]service{
@@ -294,10 +353,11 @@ func TestOnlyInFirst(t *testing.T) {
 			},
 			expected: map[string]service{
 				"s9": {
-					id:     "id",
-					name:   "name",
-					ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"},
-					nodes:  map[string]node{"h1": {}, "h2": {}},
+					unchanged: recordTypes{aaaaRec: true, srvRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true},
+					id:        "id",
+					name:      "name",
+					ns1IDs:    recordIDs{aRecID: "r1", srvRecID: "r2"},
+					nodes:     map[string]node{"h1": {aRecAnswer: "1.1.1.1"}, "h2": {}},
 				},
 			},
 		},
@@ -305,7 +365,7 @@ func TestOnlyInFirst(t *testing.T) {
 			a: map[string]service{
 				"s10": {
 					ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"},
-					nodes:  map[string]node{"h1": {}, "h2": {}},
+					nodes:  map[string]node{"h1": {aRecAnswer: "1.1.1.1"}, "h2": {}},
 				},
 			},
 			b: map[string]service{
@@ -318,10 +378,11 @@ func TestOnlyInFirst(t *testing.T) {
 			},
 			expected: map[string]service{
 				"s10": {
-					id:     "id",
-					name:   "name",
-					ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"},
-					nodes:  map[string]node{"h1": {}, "h2": {}},
+					unchanged: recordTypes{aaaaRec: true, srvRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true},
+					id:        "id",
+					name:      "name",
+					ns1IDs:    recordIDs{aRecID: "r1", srvRecID: "r2"},
+					nodes:     map[string]node{"h1": {aRecAnswer: "1.1.1.1"}, "h2": {}},
 				},
 			},
 		},
@@ -329,7 +390,7 @@ func TestOnlyInFirst(t *testing.T) {
 			a: map[string]service{
 				"s11": {
 					ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"},
-					nodes:  map[string]node{"h1": {}, "h2": {}},
+					nodes:  map[string]node{"h1": {aRecAnswer: "1.1.1.1"}, "h2": {}},
 				},
 			},
 			b: map[string]service{
@@ -339,18 +400,72 @@ func TestOnlyInFirst(t *testing.T) {
 			},
 			expected: map[string]service{
 				"s11": {
-					ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"},
-					nodes:  map[string]node{"h1": {}, "h2": {}},
+					unchanged: recordTypes{aaaaRec: true, srvRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true},
+					ns1IDs:    recordIDs{aRecID: "r1", srvRecID: "r2"},
+					nodes:     map[string]node{"h1": {aRecAnswer: "1.1.1.1"}, "h2": {}},
 				},
 			},
 		},
+		"Only SRV TTL doesn't match": {
+			a:        map[string]service{"s13": {ttls: recordTTLs{aRecTTL: 1, srvRecTTL: 2}}},
+			b:        map[string]service{"s13": {ttls: recordTTLs{aRecTTL: 1, srvRecTTL: 4}}},
+			expected: map[string]service{"s13": {ttls: recordTTLs{aRecTTL: 1, srvRecTTL: 2}, unchanged: recordTypes{aRec: true, aaaaRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true}}},
+		},
+		"Port hints don't match": {
+			a:        map[string]service{"s14": {txtRecAnswer: "ports=1,2", ns1IDs: recordIDs{aRecID: "r1"}}},
+			b:        map[string]service{"s14": {txtRecAnswer: "ports=1", ns1IDs: recordIDs{txtRecID: "r3"}}},
+			expected: map[string]service{"s14": {txtRecAnswer: "ports=1,2", ns1IDs: recordIDs{aRecID: "r1", txtRecID: "r3"}, unchanged: recordTypes{aRec: true, aaaaRec: true, srvRec: true, cnameRec: true, httpsRec: true, ownerRec: true}}},
+		},
 		"TTLs don't match": {
 			a:        map[string]service{"s12": {ttls: recordTTLs{aRecTTL: 1, srvRecTTL: 2}}},
 			b:        map[string]service{"s12": {ttls: recordTTLs{aRecTTL: 3, srvRecTTL: 4}}},
-			expected: map[string]service{"s12": {ttls: recordTTLs{aRecTTL: 1, srvRecTTL: 2}}},
+			expected: map[string]service{"s12": {ttls: recordTTLs{aRecTTL: 1, srvRecTTL: 2}, unchanged: recordTypes{aaaaRec: true, txtRec: true, cnameRec: true, httpsRec: true, ownerRec: true}}},
 		},
 	}
 	for name, v := range table {
 		assert.Equal(t, v.expected, onlyInFirst(v.a, v.b), fmt.Sprintf("Test case: %s", name))
 	}
 }
+
+func TestOnlyInFirst_ServiceFields(t *testing.T) {
+	filters, subnet := []*filter.Filter{{Type: "up", Config: filter.Config{}}}, false
+	a := map[string]service{"s1": {
+		nodes:        map[string]node{"h1": {aRecAnswer: "1.1.1.1"}},
+		ttls:         recordTTLs{aRecTTL: 30},
+		ttlOverride:  30,
+		filters:      filters,
+		filterKeys:   map[diff.Family]string{diff.A: overrideKey(filters, &subnet)},
+		clientSubnet: &subnet,
+	}}
+	b := map[string]service{"s1": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1"}}, ttls: recordTTLs{aRecTTL: 60}}}
+
+	// the settings of a changed service are written along with its records
+	s := onlyInFirst(a, b)["s1"]
+	assert.Equal(t, int64(30), s.ttlOverride)
+	assert.Equal(t, filters, s.filters)
+	assert.Equal(t, a["s1"].filterKeys, s.filterKeys)
+	assert.Equal(t, &subnet, s.clientSubnet)
+}
+
+func TestParseHealthAggregation(t *testing.T) {
+	p, err := parseHealthAggregation("")
+	assert.NoError(t, err)
+	assert.Equal(t, worstHealth, p)
+
+	p, err = parseHealthAggregation("best")
+	assert.NoError(t, err)
+	assert.Equal(t, bestHealth, p)
+
+	_, err = parseHealthAggregation("median")
+	assert.Error(t, err)
+}
+
+func TestPortsTXTAnswer(t *testing.T) {
+	nodes := map[string]node{
+		"n1/s1": {srvRecAnswers: map[int]srvAnswer{8443: {priority: 1, weight: 1, port: 8443, address: "1.1.1.1"}}},
+		"n2/s1": {srvRecAnswers: map[int]srvAnswer{8080: {priority: 1, weight: 1, port: 8080, address: "2.2.2.2"}}},
+		"n3/s1": {srvRecAnswers: map[int]srvAnswer{8080: {priority: 1, weight: 1, port: 8080, address: "3.3.3.3"}}},
+	}
+	assert.Equal(t, "ports=8080,8443", portsTXTAnswer(nodes))
+	assert.Equal(t, "ports=", portsTXTAnswer(map[string]node{}))
+}
@@ -0,0 +1,105 @@
+package catalog
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// regionSetting is the name of the region of answers among the settings managed by consul-ns1
+const regionSetting = "region"
+
+// answerSettings keeps the settings of answers tuned outside of consul-ns1, e.g. a note or a weight set in the NS1
+// portal, when their record is updated. Answers are rewritten on every update, so meta fields and regions that
+// consul-ns1 doesn't manage are carried over from the answers with the same rdata fetched before the update.
+// A nil answerSettings carries nothing over.
+type answerSettings struct {
+	// managed holds the meta fields managed by consul-ns1, by JSON name, and regionSetting if it manages regions.
+	// They are always written as consul-ns1 sets them, unset fields included.
+	managed map[string]bool
+
+	lock sync.Mutex
+	// answers holds the answers of each record fetched before updating it, keyed by `recordKey` and rdata
+	answers map[string]map[string]*dns.Answer
+}
+
+// managedSettings returns the answer settings managed by consul-ns1 with a configuration
+func managedSettings(cfg Config) map[string]bool {
+	managed := map[string]bool{}
+	if cfg.WeightedAnswers {
+		managed["weight"] = true
+	}
+	if cfg.UpFilter || cfg.UpFeeds {
+		managed["up"] = true
+	}
+	if cfg.GeoMetadata {
+		managed["georegion"], managed["country"], managed["latitude"], managed["longitude"] = true, true, true, true
+	}
+	if cfg.CoManagedRecords || len(cfg.TaggedAddresses) > 0 || cfg.VersionNotes {
+		managed["note"] = true
+	}
+	if cfg.DatacenterRegions {
+		managed[regionSetting] = true
+	}
+	return managed
+}
+
+// fetched remembers the answers of a record fetched from NS1 before updating it
+func (s *answerSettings) fetched(rec *dns.Record) {
+	if s == nil {
+		return
+	}
+	answers := map[string]*dns.Answer{}
+	for _, a := range rec.Answers {
+		answers[strings.Join(a.Rdata, " ")] = a
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.answers == nil {
+		s.answers = map[string]map[string]*dns.Answer{}
+	}
+	s.answers[recordKey(rec.Domain, rec.Type)] = answers
+}
+
+// restore carries the settings not managed by consul-ns1 over from the answers fetched before updating a record
+// to the answers it is about to be written with
+func (s *answerSettings) restore(rec *dns.Record) {
+	if s == nil {
+		return
+	}
+	key := recordKey(rec.Domain, rec.Type)
+	s.lock.Lock()
+	fetched := s.answers[key]
+	delete(s.answers, key)
+	s.lock.Unlock()
+	for _, a := range rec.Answers {
+		old, ok := fetched[strings.Join(a.Rdata, " ")]
+		if !ok || old == a {
+			continue
+		}
+		if a.RegionName == "" && !s.managed[regionSetting] {
+			a.RegionName = old.RegionName
+		}
+		if old.Meta != nil {
+			if a.Meta == nil {
+				a.Meta = &data.Meta{}
+			}
+			mergeMeta(a.Meta, old.Meta, s.managed)
+		}
+	}
+}
+
+// mergeMeta sets the fields of `meta` that are unset and not managed to their value in `old`
+func mergeMeta(meta, old *data.Meta, managed map[string]bool) {
+	mv, ov := reflect.ValueOf(meta).Elem(), reflect.ValueOf(old).Elem()
+	for i := 0; i < mv.NumField(); i++ {
+		name := strings.Split(mv.Type().Field(i).Tag.Get("json"), ",")[0]
+		if managed[name] || !mv.Field(i).IsNil() {
+			continue
+		}
+		mv.Field(i).Set(ov.Field(i))
+	}
+}
@@ -0,0 +1,90 @@
+package catalog
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// tunedRecordService holds records whose answer 1.1.1.1 was tuned in the NS1 portal
+type tunedRecordService struct {
+	mockRecordService
+}
+
+func (s *tunedRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	rec := dns.NewRecord(zone, domain, t)
+	a := dns.NewAv4Answer("1.1.1.1")
+	a.Meta = &data.Meta{Note: "tuned", Weight: float64(5), Priority: float64(1)}
+	a.SetRegion("manual")
+	rec.AddAnswer(a)
+	rec.Regions = data.Regions{"manual": {Meta: data.Meta{Georegion: []string{"EUROPE"}}}}
+	return rec, nil, nil
+}
+
+func TestAnswerSettings_Restore(t *testing.T) {
+	s := &answerSettings{managed: map[string]bool{"weight": true}}
+	rec := dns.NewRecord("test.zone", "s1.test.zone", "A")
+	a := dns.NewAv4Answer("1.1.1.1")
+	a.Meta = &data.Meta{Note: "tuned", Weight: float64(5), Priority: float64(1)}
+	a.SetRegion("manual")
+	rec.Answers = []*dns.Answer{a, dns.NewAv4Answer("2.2.2.2")}
+	s.fetched(rec)
+
+	// managed fields are written as set, unset fields are carried over
+	a1, a3 := dns.NewAv4Answer("1.1.1.1"), dns.NewAv4Answer("3.3.3.3")
+	a1.Meta.Priority = float64(2)
+	rec.Answers = []*dns.Answer{a1, a3}
+	s.restore(rec)
+	assert.Equal(t, &data.Meta{Note: "tuned", Priority: float64(2)}, a1.Meta)
+	assert.Equal(t, "manual", a1.RegionName)
+	assert.Equal(t, &data.Meta{}, a3.Meta)
+	assert.Empty(t, s.answers)
+
+	// managed regions aren't carried over
+	s.managed[regionSetting] = true
+	rec.Answers = []*dns.Answer{a}
+	s.fetched(rec)
+	a1 = dns.NewAv4Answer("1.1.1.1")
+	rec.Answers = []*dns.Answer{a1}
+	s.restore(rec)
+	assert.Equal(t, "", a1.RegionName)
+
+	var disabled *answerSettings
+	disabled.fetched(rec)
+	disabled.restore(rec)
+}
+
+func TestManagedSettings(t *testing.T) {
+	assert.Empty(t, managedSettings(Config{}))
+	assert.Equal(t, map[string]bool{"weight": true, "up": true, "note": true, "region": true},
+		managedSettings(Config{WeightedAnswers: true, UpFeeds: true, TaggedAddresses: []string{"wan"}, DatacenterRegions: true}))
+}
+
+func TestCreate_TunedSettings(t *testing.T) {
+	n := testClient(nil)
+	n.settings = &answerSettings{managed: map[string]bool{regionSetting: true}}
+	n.regions = &answerRegions{}
+	records := &tunedRecordService{mockRecordService{mux: &sync.Mutex{}}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]service{
+		"s1": {nodes: map[string]node{
+			"n1/web": {aRecAnswer: "1.1.1.1", region: "dc1"},
+			"n2/web": {aRecAnswer: "2.2.2.2", region: "dc1"},
+		}, ns1IDs: recordIDs{aRecID: "r1", srvRecID: "r2"}},
+	}
+
+	assert.Equal(t, int32(2), n.create(desired))
+	for _, rec := range records.records {
+		if rec.Type != "A" {
+			continue
+		}
+		assert.Equal(t, &data.Meta{Note: "tuned", Weight: float64(5), Priority: float64(1)}, rec.Answers[0].Meta)
+		assert.Equal(t, "dc1", rec.Answers[0].RegionName)
+		// regions tuned outside of consul-ns1 are left alone
+		assert.Equal(t, data.Regions{"manual": {Meta: data.Meta{Georegion: []string{"EUROPE"}}}, "dc1": {}}, rec.Regions)
+	}
+}
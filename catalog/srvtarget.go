@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+)
+
+// srvTargetLabel returns the DNS label of the record a Consul node is published at as SRV target
+func srvTargetLabel(host string) string {
+	label := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(host))
+	label = strings.Trim(label, "-")
+	if label == "" {
+		return "node"
+	}
+	return label
+}
+
+// addSRVTargets points the SRV answers of services at per-node hostnames instead of raw IPs, as RFC 2782 requires
+// SRV targets to be hostnames. The node of each instance is published as a service of its own, named
+// "<node>.<prefix><service>", holding only its A and AAAA records, so it is diffed, written and removed like any
+// other service and read back from NS1 as such.
+func (c *consul) addSRVTargets(services map[string]service) {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		s := services[k]
+		if s.cnameRecAnswer != "" {
+			continue
+		}
+		nodes := make(map[string]node, len(s.nodes))
+		for key, n := range s.nodes {
+			name := srvTargetLabel(n.host) + "." + c.ns1Prefix + k
+			target := c.ns1Prefix + name + "." + c.srvTargetZone
+			answers := make(map[int]srvAnswer, len(n.srvRecAnswers))
+			for port, a := range n.srvRecAnswers {
+				a.address = target
+				answers[port] = a
+			}
+			n.srvRecAnswers = answers
+			nodes[key] = n
+
+			t, ok := services[name]
+			if ok && !t.srvTarget {
+				c.log.Warn("service name collides with the SRV target of a node, its SRV answers point to the service",
+					"service", name, "node", n.host)
+				continue
+			}
+			if !ok {
+				t = service{name: name, nodes: map[string]node{}, srvTarget: true}
+				if c.addressFamily.v4() {
+					t.ttls.aRecTTL = c.ttl(name, "A", s.ttlOverride)
+				}
+				if c.addressFamily.v6() {
+					t.ttls.aaaaRecTTL = c.ttl(name, "AAAA", s.ttlOverride)
+				}
+				if c.ownershipRegistry {
+					t.ownerRecAnswer = ownerTXTAnswer(c.ns1Prefix)
+				}
+			}
+			t.nodes[key] = node{host: n.host, address: n.address, aRecAnswer: n.aRecAnswer, aaaaRecAnswer: n.aaaaRecAnswer,
+				taggedAnswers: n.taggedAnswers}
+			services[name] = t
+		}
+		s.nodes = nodes
+		services[k] = s
+	}
+}
@@ -0,0 +1,68 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestSRVTargetLabel(t *testing.T) {
+	table := map[string]string{
+		"node-1":         "node-1",
+		"Node_1.example": "node-1-example",
+		"--":             "node",
+	}
+	for host, expected := range table {
+		assert.Equal(t, expected, srvTargetLabel(host), fmt.Sprintf("Test case: %s", host))
+	}
+}
+
+func TestAddSRVTargets(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), ns1Prefix: "p-", dnsTTL: 10, addressFamily: ipv4Family, srvTargetZone: "test.zone"}
+	instance := func(host, address string, port int) node {
+		return node{host: host, address: address, port: port, aRecAnswer: address,
+			srvRecAnswers: map[int]srvAnswer{port: {priority: 1, weight: 1, port: int64(port), address: address}}}
+	}
+	services := map[string]service{
+		"web": {nodes: map[string]node{
+			"h1/web-1": instance("h1", "1.1.1.1", 80),
+			"h1/web-2": instance("h1", "1.1.1.1", 81),
+			"h2/web":   instance("h2", "2.2.2.2", 80),
+		}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+		"api": {cnameRecAnswer: "ingress.example.com", ttls: recordTTLs{cnameRecTTL: 10}},
+	}
+	c.addSRVTargets(services)
+
+	assert.Len(t, services, 4)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, aAnswers(services["web"].nodes))
+	assert.Equal(t, []srvAnswer{
+		{priority: 1, weight: 1, port: 80, address: "p-h1.p-web.test.zone"},
+		{priority: 1, weight: 1, port: 80, address: "p-h2.p-web.test.zone"},
+		{priority: 1, weight: 1, port: 81, address: "p-h1.p-web.test.zone"},
+	}, srvAnswers(services["web"].nodes))
+	h1 := services["h1.p-web"]
+	assert.True(t, h1.srvTarget)
+	assert.Equal(t, []string{"1.1.1.1"}, aAnswers(h1.nodes))
+	assert.Empty(t, srvAnswers(h1.nodes))
+	assert.Equal(t, recordTTLs{aRecTTL: 10}, h1.ttls)
+	assert.Len(t, h1.entry(), len(srvTargetFamilies))
+
+	// the records read back from NS1 match the desired state
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, ns1Prefix: "p-", addressFamily: ipv4Family, log: hclog.NewNullLogger()}
+	z := &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+		{Domain: "p-web.test.zone", ID: "1", Type: "A", TTL: 10, ShortAns: []string{"1.1.1.1", "2.2.2.2"}},
+		{Domain: "p-web.test.zone", ID: "2", Type: "SRV", TTL: 10,
+			ShortAns: []string{"1 1 80 p-h1.p-web.test.zone.", "1 1 81 p-h1.p-web.test.zone.", "1 1 80 p-h2.p-web.test.zone."}},
+		{Domain: "p-api.test.zone", ID: "3", Type: "CNAME", TTL: 10, ShortAns: []string{"ingress.example.com."}},
+		{Domain: "p-h1.p-web.test.zone", ID: "4", Type: "A", TTL: 10, ShortAns: []string{"1.1.1.1"}},
+		{Domain: "p-h2.p-web.test.zone", ID: "5", Type: "A", TTL: 10, ShortAns: []string{"2.2.2.2"}},
+		// nodes only have A and AAAA records, others are left alone
+		{Domain: "p-h2.p-web.test.zone", ID: "6", Type: "SRV", TTL: 10},
+	}}
+	actual := n.transformZoneRecords(z)
+	assert.Empty(t, onlyInFirst(services, actual))
+	assert.Empty(t, serviceOnlyInFirst(actual, services))
+}
@@ -0,0 +1,88 @@
+package catalog
+
+import (
+	"strconv"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+const (
+	// srvPriorityMetaKey is the service meta key holding the priority of the SRV answer of an instance
+	srvPriorityMetaKey = "ns1-srv-priority"
+	// srvWeightMetaKey is the service meta key holding the weight of the SRV answer of an instance,
+	// it takes precedence over the Consul weights of the instance
+	srvWeightMetaKey = "ns1-srv-weight"
+	// defaultSRVPriority and defaultSRVWeight are used for instances declaring neither meta nor weights
+	defaultSRVPriority = 1
+	defaultSRVWeight   = 1
+)
+
+// srvPriorityWeight returns the priority and weight of the SRV answer of an instance. The weight is taken from
+// the meta of the instance, or its passing weight, see `passingWeight`, and `warningWeights` for instances in
+// warning state.
+func (c *consul) srvPriorityWeight(n *consulapi.CatalogService) (int64, int64) {
+	priority, weight := int64(defaultSRVPriority), int64(defaultSRVWeight)
+	if w := c.passingWeight(n); w > 0 {
+		weight = w
+	}
+	if v, ok := c.srvMeta(n, srvPriorityMetaKey); ok {
+		priority = v
+	}
+	if v, ok := c.srvMeta(n, srvWeightMetaKey); ok {
+		weight = v
+	}
+	return priority, weight
+}
+
+// srvMeta parses an SRV field from the meta of an instance, invalid values are logged and ignored
+func (c *consul) srvMeta(n *consulapi.CatalogService, key string) (int64, bool) {
+	v, ok := n.ServiceMeta[key]
+	if !ok {
+		return 0, false
+	}
+	i, err := strconv.ParseUint(v, 10, 16)
+	if err != nil {
+		c.log.Warn("invalid SRV field in service meta, ignoring", "service", n.ServiceName, "node", n.Node, "key", key, "value", v)
+		return 0, false
+	}
+	return int64(i), true
+}
+
+// warningWeights returns the Consul warning weight of each instance without a weight in its meta, keyed by `instanceKey`
+func warningWeights(cnodes []*consulapi.CatalogService) map[string]int64 {
+	weights := map[string]int64{}
+	for _, n := range cnodes {
+		if _, ok := n.ServiceMeta[srvWeightMetaKey]; ok || n.ServiceWeights.Warning <= 0 {
+			continue
+		}
+		weights[instanceKey(n.Node, n.ServiceID)] = int64(n.ServiceWeights.Warning)
+	}
+	return weights
+}
+
+// applyWarningWeights sets the weight of the SRV answers of instances with a check in warning state to their
+// warning weight, like Consul DNS does
+func applyWarningWeights(nodes map[string]node, chealths consulapi.HealthChecks, weights map[string]int64) map[string]node {
+	if len(weights) == 0 {
+		return nodes
+	}
+	warning := map[string]bool{}
+	for _, h := range chealths {
+		if h.Status == consulapi.HealthWarning {
+			warning[instanceKey(h.Node, h.ServiceID)] = true
+		}
+	}
+	result := make(map[string]node, len(nodes))
+	for key, n := range nodes {
+		if weight, ok := weights[key]; ok && warning[key] {
+			answers := make(map[int]srvAnswer, len(n.srvRecAnswers))
+			for port, a := range n.srvRecAnswers {
+				a.weight = weight
+				answers[port] = a
+			}
+			n.srvRecAnswers = answers
+		}
+		result[key] = n
+	}
+	return result
+}
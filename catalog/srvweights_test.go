@@ -0,0 +1,64 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestSRVPriorityWeight(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	table := map[string]struct {
+		meta     map[string]string
+		weights  consulapi.Weights
+		priority int64
+		weight   int64
+	}{
+		"default":        {nil, consulapi.Weights{}, 1, 1},
+		"consul weights": {nil, consulapi.Weights{Passing: 10, Warning: 1}, 1, 10},
+		"meta":           {map[string]string{"ns1-srv-priority": "2", "ns1-srv-weight": "20"}, consulapi.Weights{Passing: 10}, 2, 20},
+		"invalid meta":   {map[string]string{"ns1-srv-priority": "-2", "ns1-srv-weight": "70000"}, consulapi.Weights{Passing: 10}, 1, 10},
+	}
+	for name, v := range table {
+		priority, weight := c.srvPriorityWeight(&consulapi.CatalogService{ServiceMeta: v.meta, ServiceWeights: v.weights})
+		assert.Equal(t, v.priority, priority, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.weight, weight, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestApplyWarningWeights(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), addressFamily: ipv4Family}
+	cnodes := []*consulapi.CatalogService{
+		{Node: "n1", ServiceID: "web", Address: "1.1.1.1", ServicePort: 80, ServiceWeights: consulapi.Weights{Passing: 10, Warning: 2}},
+		{Node: "n2", ServiceID: "web", Address: "2.2.2.2", ServicePort: 80, ServiceWeights: consulapi.Weights{Passing: 10, Warning: 2}},
+		{Node: "n3", ServiceID: "web", Address: "3.3.3.3", ServicePort: 80, ServiceWeights: consulapi.Weights{Passing: 10, Warning: 2},
+			ServiceMeta: map[string]string{"ns1-srv-weight": "5"}},
+	}
+	checks := consulapi.HealthChecks{
+		{Node: "n1", ServiceID: "web", Status: "passing"},
+		{Node: "n2", ServiceID: "web", Status: "warning"},
+		{Node: "n3", ServiceID: "web", Status: "warning"},
+	}
+	nodes := applyWarningWeights(c.transformNodes(cnodes), checks, warningWeights(cnodes))
+	assert.Equal(t, int64(10), nodes["n1/web"].srvRecAnswers[80].weight)
+	assert.Equal(t, int64(2), nodes["n2/web"].srvRecAnswers[80].weight)
+	assert.Equal(t, int64(5), nodes["n3/web"].srvRecAnswers[80].weight, "the meta takes precedence")
+}
+
+func TestTransformZoneRecords_SRVPriorityWeight(t *testing.T) {
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "web.test.zone", ID: "r1", ShortAns: []string{"2 20 80 1.1.1.1"}, Type: "SRV", TTL: 5},
+		},
+	}
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, log: hclog.NewNullLogger(), addressFamily: ipv4Family}
+	c := consul{log: hclog.NewNullLogger(), addressFamily: ipv4Family}
+	desired := c.transformNodes([]*consulapi.CatalogService{{Node: "n1", ServiceID: "web", Address: "1.1.1.1", ServicePort: 80,
+		ServiceMeta: map[string]string{"ns1-srv-priority": "2", "ns1-srv-weight": "20"}}})
+	assert.Equal(t, srvAnswers(desired), srvAnswers(n.transformZoneRecords(z)["web"].nodes))
+}
@@ -0,0 +1,208 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// stateVersion is the version of the format of the sync state, states of other versions are ignored
+const stateVersion = 1
+
+// syncState is what the NS1 side of the syncer knows about the records it wrote beyond the zone itself,
+// kept across restarts by a stateStore. Record keys are `recordKey`s.
+type syncState struct {
+	Version int `json:"version"`
+	// Synced holds the answers last written to each record, see `editGuard`
+	Synced map[string][]string `json:"synced,omitempty"`
+	// Weights holds the weights of the answers last written to each record, see `answerWeights`
+	Weights map[string]map[string]int64 `json:"weights,omitempty"`
+	// Down holds the answers last written down to each record, see `answerStates`
+	Down map[string]map[string]bool `json:"down,omitempty"`
+	// Geo holds the locations of the answers last written to each record, see `answerGeos`
+	Geo map[string]map[string]string `json:"geo,omitempty"`
+	// Versions holds the versions of the answers last written to each record, see `answerVersions`
+	Versions map[string]map[string]string `json:"versions,omitempty"`
+	// Regions holds the regions of the answers last written to each record, see `answerRegions`
+	Regions map[string]map[string]string `json:"regions,omitempty"`
+	// FilterOverrides holds the filter chain override last written to each record, see `filterOverrides`
+	FilterOverrides map[string]string `json:"filter_overrides,omitempty"`
+	// Foreign and RecordIDs hold the answers not written by this instance and the IDs of the records without
+	// answers written by this instance, see `coManaged`
+	Foreign   map[string][]string `json:"foreign,omitempty"`
+	RecordIDs map[string]string   `json:"record_ids,omitempty"`
+	// InstanceCounts holds the instance count last written for each service
+	InstanceCounts map[string]int `json:"instance_counts,omitempty"`
+}
+
+// stateStore persists the sync state, so a restarted syncer neither rewrites records it already wrote nor
+// loses track of the edits made to them
+type stateStore interface {
+	// load returns the stored state, an empty state if none was stored
+	load() (syncState, error)
+	save(state syncState) error
+}
+
+// fileStore is a stateStore keeping the state in a JSON file
+type fileStore struct {
+	file string
+}
+
+func (s *fileStore) load() (syncState, error) {
+	state := syncState{}
+	b, err := ioutil.ReadFile(s.file)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return syncState{}, err
+	}
+	if state.Version != stateVersion {
+		return syncState{}, fmt.Errorf("unsupported state version %d", state.Version)
+	}
+	return state, nil
+}
+
+func (s *fileStore) save(state syncState) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	// write atomically, so a crash while writing never leaves a truncated state
+	tmp := s.file + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.file)
+}
+
+// snapshot returns the sync state of the NS1 side of the syncer
+func (n *ns1) snapshot() syncState {
+	state := syncState{Version: stateVersion}
+	if n.edits != nil {
+		n.edits.lock.Lock()
+		state.Synced = make(map[string][]string, len(n.edits.synced))
+		for k, v := range n.edits.synced {
+			state.Synced[k] = v
+		}
+		n.edits.lock.Unlock()
+	}
+	if n.weights != nil {
+		n.weights.lock.Lock()
+		state.Weights = make(map[string]map[string]int64, len(n.weights.weights))
+		for k, v := range n.weights.weights {
+			state.Weights[k] = v
+		}
+		n.weights.lock.Unlock()
+	}
+	if n.states != nil {
+		n.states.lock.Lock()
+		state.Down = make(map[string]map[string]bool, len(n.states.down))
+		for k, v := range n.states.down {
+			state.Down[k] = v
+		}
+		n.states.lock.Unlock()
+	}
+	if n.geos != nil {
+		n.geos.lock.Lock()
+		state.Geo = make(map[string]map[string]string, len(n.geos.geos))
+		for k, v := range n.geos.geos {
+			state.Geo[k] = v
+		}
+		n.geos.lock.Unlock()
+	}
+	if n.versions != nil {
+		n.versions.lock.Lock()
+		state.Versions = make(map[string]map[string]string, len(n.versions.versions))
+		for k, v := range n.versions.versions {
+			state.Versions[k] = v
+		}
+		n.versions.lock.Unlock()
+	}
+	if n.regions != nil {
+		n.regions.lock.Lock()
+		state.Regions = make(map[string]map[string]string, len(n.regions.regions))
+		for k, v := range n.regions.regions {
+			state.Regions[k] = v
+		}
+		n.regions.lock.Unlock()
+	}
+	if n.filterOverrides != nil {
+		n.filterOverrides.lock.Lock()
+		state.FilterOverrides = make(map[string]string, len(n.filterOverrides.written))
+		for k, v := range n.filterOverrides.written {
+			state.FilterOverrides[k] = v
+		}
+		n.filterOverrides.lock.Unlock()
+	}
+	if n.coManaged != nil {
+		n.coManaged.lock.Lock()
+		state.Foreign = make(map[string][]string, len(n.coManaged.foreign))
+		for k, v := range n.coManaged.foreign {
+			state.Foreign[k] = v
+		}
+		state.RecordIDs = make(map[string]string, len(n.coManaged.ids))
+		for k, v := range n.coManaged.ids {
+			state.RecordIDs[k] = v
+		}
+		n.coManaged.lock.Unlock()
+	}
+	n.instanceCountsLock.Lock()
+	state.InstanceCounts = make(map[string]int, len(n.instanceCounts))
+	for k, v := range n.instanceCounts {
+		state.InstanceCounts[k] = v
+	}
+	n.instanceCountsLock.Unlock()
+	return state
+}
+
+// restore sets the sync state of the NS1 side of the syncer, before it starts fetching and writing records
+func (n *ns1) restore(state syncState) {
+	if n.edits != nil && state.Synced != nil {
+		n.edits.synced = state.Synced
+	}
+	if n.weights != nil && state.Weights != nil {
+		n.weights.weights = state.Weights
+	}
+	if n.states != nil && state.Down != nil {
+		n.states.down = state.Down
+	}
+	if n.geos != nil && state.Geo != nil {
+		n.geos.geos = state.Geo
+	}
+	if n.versions != nil && state.Versions != nil {
+		n.versions.versions = state.Versions
+	}
+	if n.regions != nil && state.Regions != nil {
+		n.regions.regions = state.Regions
+	}
+	if n.filterOverrides != nil && state.FilterOverrides != nil {
+		n.filterOverrides.written = state.FilterOverrides
+	}
+	if n.coManaged != nil && state.Foreign != nil {
+		n.coManaged.foreign, n.coManaged.pending, n.coManaged.ids = state.Foreign, map[string][]*dns.Answer{}, state.RecordIDs
+		if n.coManaged.ids == nil {
+			n.coManaged.ids = map[string]string{}
+		}
+	}
+	if state.InstanceCounts != nil {
+		n.instanceCounts = state.InstanceCounts
+	}
+}
+
+// saveState stores the sync state, if a store is configured. Errors are logged, the state is stored again
+// after the next sync cycle.
+func (n *ns1) saveState() {
+	if n.store == nil {
+		return
+	}
+	if err := n.store.save(n.snapshot()); err != nil {
+		n.log.Error("cannot store sync state", "error", err.Error())
+	}
+}
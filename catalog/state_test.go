@@ -0,0 +1,75 @@
+package catalog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	s := &fileStore{file: filepath.Join(dir, "state.json")}
+
+	state, err := s.load()
+	require.NoError(t, err, "a missing state is empty")
+	assert.Equal(t, syncState{}, state)
+
+	stored := syncState{Version: stateVersion, Synced: map[string][]string{"s1.test.zone A": {"1.1.1.1"}}}
+	require.NoError(t, s.save(stored))
+	state, err = s.load()
+	require.NoError(t, err)
+	assert.Equal(t, stored, state)
+
+	require.NoError(t, ioutil.WriteFile(s.file, []byte(`{"version": 2}`), 0644))
+	_, err = s.load()
+	assert.Error(t, err)
+	require.NoError(t, ioutil.WriteFile(s.file, []byte(`{`), 0644))
+	_, err = s.load()
+	assert.Error(t, err)
+}
+
+func TestRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "state.json")
+
+	n := testClient(nil)
+	n.store = &fileStore{file: file}
+	n.edits = &editGuard{log: hclog.NewNullLogger(), policy: skipEdits}
+	n.weights = &answerWeights{}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: &mockRecordService{mux: &sync.Mutex{}}}
+	input := map[string]service{
+		"s1": {nodes: map[string]node{"h1": {aRecAnswer: "1.1.1.1", answerWeight: 5}}, unchanged: recordTypes{srvRec: true}},
+	}
+	setWeights := n.create(input)
+	assert.Equal(t, int32(1), setWeights)
+	n.saveState()
+
+	// a restarted syncer knows the answers and weights it wrote
+	restarted := testClient(nil)
+	restarted.store = &fileStore{file: file}
+	restarted.edits = &editGuard{log: hclog.NewNullLogger(), policy: skipEdits}
+	restarted.weights = &answerWeights{}
+	state, err := restarted.store.load()
+	require.NoError(t, err)
+	restarted.restore(state)
+	assert.Equal(t, int64(5), restarted.weights.weight("s1.test.zone", "A", "1.1.1.1"))
+
+	// so a record edited while it was stopped is detected
+	edited := dns.NewRecord("test.zone", "s1.test.zone", "A")
+	edited.AddAnswer(dns.NewAv4Answer("9.9.9.9"))
+	restarted.edits.fetched(edited)
+	rec := dns.NewRecord("test.zone", "s1.test.zone", "A")
+	rec.AddAnswer(dns.NewAv4Answer("2.2.2.2"))
+	assert.False(t, restarted.edits.resolve(rec))
+}
@@ -0,0 +1,56 @@
+package catalog
+
+import (
+	"fmt"
+	"strconv"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// clientSubnetMetaKey is the service meta key enabling or disabling EDNS Client Subnet on the A, AAAA and SRV
+// records of a service, e.g. "false"
+const clientSubnetMetaKey = "ns1-use-client-subnet"
+
+// parseClientSubnet parses the EDNS Client Subnet option of the records created, nil for the default of NS1
+func parseClientSubnet(s string) (*bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client subnet option %q, must be true or false", s)
+	}
+	return &b, nil
+}
+
+// serviceClientSubnet returns the EDNS Client Subnet option declared by the meta of the instances of a service,
+// nil if none does. Instances are expected to agree, if they don't the option of the first instance is used.
+func (c *consul) serviceClientSubnet(name string, cnodes []*consulapi.CatalogService) *bool {
+	var subnet *bool
+	for _, n := range cnodes {
+		v, ok := n.ServiceMeta[clientSubnetMetaKey]
+		if !ok {
+			continue
+		}
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			c.log.Warn("invalid client subnet option in service meta, ignoring", "service", name, "node", n.Node, "value", v)
+			continue
+		}
+		if subnet == nil {
+			subnet = &b
+		} else if *subnet != b {
+			c.log.Warn("instances declare different client subnet options, using the first", "service", name, "node", n.Node)
+		}
+	}
+	return subnet
+}
+
+// setClientSubnet sets the EDNS Client Subnet option of a record about to be written, unless `subnet` is nil
+func setClientSubnet(rec *dns.Record, subnet *bool) {
+	if subnet != nil {
+		b := *subnet
+		rec.UseClientSubnet = &b
+	}
+}
@@ -0,0 +1,82 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseClientSubnet(t *testing.T) {
+	subnet, err := parseClientSubnet("")
+	require.NoError(t, err)
+	assert.Nil(t, subnet)
+	subnet, err = parseClientSubnet("false")
+	require.NoError(t, err)
+	assert.Equal(t, false, *subnet)
+	_, err = parseClientSubnet("sometimes")
+	assert.Error(t, err)
+}
+
+func TestServiceClientSubnet(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	enabled, disabled := true, false
+	table := map[string]struct {
+		cnodes   []*consulapi.CatalogService
+		expected *bool
+	}{
+		"none": {[]*consulapi.CatalogService{{Node: "n1"}}, nil},
+		"meta": {[]*consulapi.CatalogService{
+			{Node: "n1"},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-use-client-subnet": "false"}},
+		}, &disabled},
+		"invalid": {[]*consulapi.CatalogService{{Node: "n1", ServiceMeta: map[string]string{"ns1-use-client-subnet": "maybe"}}}, nil},
+		"different": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-use-client-subnet": "true"}},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-use-client-subnet": "false"}},
+		}, &enabled},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, c.serviceClientSubnet("web", v.cnodes), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCreate_ClientSubnet(t *testing.T) {
+	enabled, disabled := true, false
+	n := testClient(nil)
+	n.clientSubnet = &disabled
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]service{
+		"s1": {nodes: map[string]node{"n1/web": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}}}},
+		"s2": {nodes: map[string]node{"n1/api": {aRecAnswer: "1.1.1.1"}}, clientSubnet: &enabled},
+	}
+
+	// records created get the default unless their service declares an option
+	assert.Equal(t, int32(4), n.create(desired))
+	for _, rec := range records.records {
+		expected := rec.Domain == "s2.test.zone"
+		require.NotNil(t, rec.UseClientSubnet, rec.Domain)
+		assert.Equal(t, expected, *rec.UseClientSubnet, rec.Domain)
+	}
+
+	// existing records keep their option unless their service declares one
+	stored := &storedRecordService{mockRecordService{mux: &sync.Mutex{}}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: stored}
+	for k, s := range desired {
+		s.ns1IDs = recordIDs{aRecID: "r1", srvRecID: "r2"}
+		desired[k] = s
+	}
+	assert.Equal(t, int32(4), n.create(desired))
+	for _, rec := range stored.records {
+		if rec.Domain == "s2.test.zone" {
+			assert.Equal(t, &enabled, rec.UseClientSubnet, rec.Type)
+		} else {
+			assert.Nil(t, rec.UseClientSubnet, rec.Type)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package catalog
+
+import (
+	"runtime"
+	"sync"
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+	"github.com/hashicorp/go-hclog"
+)
+
+// supervisorCheckInterval is the interval between liveness checks of the supervised loops
+var supervisorCheckInterval = time.Second
+
+// heartbeat is beaten by a loop at every iteration. The zero value is ready to use.
+type heartbeat struct {
+	// clock is set by the supervisor watching the heartbeat
+	clock *clock
+	lock  sync.Mutex
+	last  time.Time
+	// next is the time within which the loop expects to beat again
+	next time.Duration
+}
+
+// beat records an iteration of the loop, which expects to beat again within `next`
+func (h *heartbeat) beat(next time.Duration) {
+	h.lock.Lock()
+	h.last, h.next = h.clock.Now(), next
+	h.lock.Unlock()
+}
+
+// reset restarts the wait for the next beat
+func (h *heartbeat) reset() {
+	h.lock.Lock()
+	h.last = h.clock.Now()
+	h.lock.Unlock()
+}
+
+// overdue returns the time since the last beat and whether it exceeds `factor` times the expected time
+func (h *heartbeat) overdue(now time.Time, factor int) (time.Duration, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.last.IsZero() {
+		return 0, false
+	}
+	since := now.Sub(h.last)
+	return since, since > time.Duration(factor)*h.next
+}
+
+// supervisedLoop is a loop run and watched by the supervisor
+type supervisedLoop struct {
+	name      string
+	heartbeat *heartbeat
+	run       func(stop, stopped chan struct{})
+	// done is closed when the loop exits, unless it exits because it was restarted
+	done chan struct{}
+
+	lock    sync.Mutex
+	stop    chan struct{}
+	stopped chan struct{}
+	stalled bool
+}
+
+// start runs a new instance of the loop
+func (l *supervisedLoop) start() {
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	l.lock.Lock()
+	l.stop, l.stopped = stop, stopped
+	l.lock.Unlock()
+	go l.run(stop, stopped)
+	go func() {
+		<-stopped
+		l.lock.Lock()
+		current := l.stopped == stopped
+		l.lock.Unlock()
+		if current {
+			close(l.done)
+		}
+	}()
+}
+
+// halt asks the current instance of the loop to stop, `done` is closed once it did
+func (l *supervisedLoop) halt() {
+	l.lock.Lock()
+	close(l.stop)
+	l.lock.Unlock()
+}
+
+// restart asks the current instance of the loop to stop and runs a new one without waiting for it.
+// The stalled instance exits as soon as it gets unblocked.
+func (l *supervisedLoop) restart() {
+	l.halt()
+	l.start()
+}
+
+// supervisor detects when a loop hasn't completed an iteration within a multiple of the time it expected to,
+// e.g. because a channel send or a request blocks forever, and optionally restarts it
+type supervisor struct {
+	log hclog.Logger
+	// factor is the multiple of the expected iteration time after which a loop is considered stalled, 0 disables checks
+	factor int
+	// restart restarts stalled loops
+	restart bool
+	clock   *clock
+	loops   []*supervisedLoop
+}
+
+// start runs and watches a loop beating the given heartbeat
+func (s *supervisor) start(name string, h *heartbeat, run func(stop, stopped chan struct{})) *supervisedLoop {
+	h.clock = s.clock
+	l := &supervisedLoop{name: name, heartbeat: h, run: run, done: make(chan struct{})}
+	s.loops = append(s.loops, l)
+	l.start()
+	return l
+}
+
+// watch checks the liveness of all loops until stopped
+func (s *supervisor) watch(stop chan struct{}) {
+	if s.factor <= 0 {
+		return
+	}
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-s.clock.After(supervisorCheckInterval):
+			for _, l := range s.loops {
+				s.check(l, now)
+			}
+		}
+	}
+}
+
+// check reports a loop that stalled since the last check, and restarts it if configured to
+func (s *supervisor) check(l *supervisedLoop, now time.Time) {
+	since, stalled := l.heartbeat.overdue(now, s.factor)
+	l.lock.Lock()
+	reported := l.stalled
+	l.stalled = stalled
+	l.lock.Unlock()
+	if !stalled {
+		if reported {
+			s.log.Info("loop recovered", "loop", l.name)
+		}
+		return
+	}
+	if reported && !s.restart {
+		return
+	}
+	s.log.Error("loop stalled", "loop", l.name, "since", since.String(), "goroutines", stackDump())
+	metrics.IncrCounterWithLabels([]string{"loop", "stall"}, 1, []metrics.Label{{Name: "loop", Value: l.name}})
+	if s.restart {
+		s.log.Warn("restarting stalled loop", "loop", l.name)
+		l.heartbeat.reset()
+		l.lock.Lock()
+		l.stalled = false
+		l.lock.Unlock()
+		l.restart()
+	}
+}
+
+// stackDump returns the stack traces of all goroutines
+func stackDump() string {
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
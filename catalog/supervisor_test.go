@@ -0,0 +1,94 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeatOverdue(t *testing.T) {
+	now := time.Now()
+	cases := map[string]struct {
+		last    time.Time
+		next    time.Duration
+		factor  int
+		stalled bool
+	}{
+		"never beaten":   {stalled: false},
+		"within":         {last: now.Add(-4 * time.Second), next: time.Second, factor: 5, stalled: false},
+		"overdue":        {last: now.Add(-6 * time.Second), next: time.Second, factor: 5, stalled: true},
+		"longer retries": {last: now.Add(-6 * time.Second), next: 2 * time.Second, factor: 5, stalled: false},
+	}
+	for name, c := range cases {
+		h := heartbeat{last: c.last, next: c.next}
+		_, stalled := h.overdue(now, c.factor)
+		assert.Equal(t, c.stalled, stalled, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+// stuckLoop beats once and then blocks until it is stopped, like a loop stuck on a channel send
+func stuckLoop(h *heartbeat, started chan int) func(stop, stopped chan struct{}) {
+	count := 0
+	return func(stop, stopped chan struct{}) {
+		defer close(stopped)
+		count++
+		h.beat(time.Millisecond)
+		started <- count
+		<-stop
+	}
+}
+
+func TestSupervisorCheck(t *testing.T) {
+	for _, restart := range []bool{false, true} {
+		name := fmt.Sprintf("restart=%t", restart)
+		h := &heartbeat{}
+		started := make(chan int, 10)
+		s := &supervisor{log: hclog.NewNullLogger(), factor: 5, restart: restart}
+		l := s.start("test", h, stuckLoop(h, started))
+		require.Equal(t, 1, <-started, fmt.Sprintf("Test case: %s", name))
+
+		s.check(l, time.Now())
+		assert.False(t, l.stalled, fmt.Sprintf("Test case: %s", name))
+
+		s.check(l, time.Now().Add(time.Second))
+		if restart {
+			assert.Equal(t, 2, <-started, fmt.Sprintf("Test case: %s", name))
+			assert.False(t, l.stalled, fmt.Sprintf("Test case: %s", name))
+		} else {
+			assert.True(t, l.stalled, fmt.Sprintf("Test case: %s", name))
+			assert.Len(t, started, 0, fmt.Sprintf("Test case: %s", name))
+		}
+
+		// a restarted instance exiting doesn't mark the loop as done
+		select {
+		case <-l.done:
+			t.Fatalf("Test case: %s: loop done before it was halted", name)
+		case <-time.After(10 * time.Millisecond):
+		}
+
+		l.halt()
+		select {
+		case <-l.done:
+		case <-time.After(time.Second):
+			t.Fatalf("Test case: %s: loop not done after it was halted", name)
+		}
+	}
+}
+
+func TestSupervisorWatch_Disabled(t *testing.T) {
+	s := &supervisor{log: hclog.NewNullLogger()}
+	returned := make(chan struct{})
+	go func() {
+		s.watch(make(chan struct{}))
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("watch should return right away when disabled")
+	}
+}
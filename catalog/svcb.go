@@ -0,0 +1,76 @@
+package catalog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+const (
+	// svcbALPNMetaKey is the service meta key holding the comma separated ALPN protocol IDs published
+	// in the HTTPS record of a service, e.g. "h2,h3"
+	svcbALPNMetaKey = "ns1-svcb-alpn"
+	// svcbPortMetaKey is the service meta key holding the port published in the HTTPS record of a service,
+	// clients connect to the default port of the scheme without it
+	svcbPortMetaKey = "ns1-svcb-port"
+)
+
+// httpsAnswer returns the answer of the HTTPS record of a service declared by the meta of its instances,
+// e.g. "1 . alpn=h2,h3 port=8443", or "" if no instance declares ALPN protocol IDs. The record is in
+// service mode with the service name as target. Instances are expected to agree, if they don't
+// the answer first in lexical order is published.
+func (c *consul) httpsAnswer(name string, cnodes []*consulapi.CatalogService) string {
+	seen := map[string]struct{}{}
+	answers := []string{}
+	for _, n := range cnodes {
+		alpn := []string{}
+		for _, id := range strings.Split(n.ServiceMeta[svcbALPNMetaKey], ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				alpn = append(alpn, id)
+			}
+		}
+		if len(alpn) == 0 {
+			continue
+		}
+		answer := "1 . alpn=" + strings.Join(alpn, ",")
+		if v, ok := n.ServiceMeta[svcbPortMetaKey]; ok {
+			port, err := strconv.Atoi(v)
+			if err != nil || port < 1 || port > 65535 {
+				c.log.Warn("invalid HTTPS port in service meta, ignoring", "service", name, "node", n.Node, "port", v)
+			} else {
+				answer += " port=" + strconv.Itoa(port)
+			}
+		}
+		if _, ok := seen[answer]; !ok {
+			seen[answer] = struct{}{}
+			answers = append(answers, answer)
+		}
+	}
+	if len(answers) == 0 {
+		return ""
+	}
+	sort.Strings(answers)
+	if len(answers) > 1 {
+		c.log.Warn("instances declare different HTTPS parameters, publishing the first", "service", name, "answers", answers)
+	}
+	return answers[0]
+}
+
+// transformHTTPSRecord adds an HTTPS record to the service it belongs to
+func (n *ns1) transformHTTPSRecord(record *dns.ZoneRecord, services map[string]service) {
+	serviceName := strings.TrimPrefix(record.Domain, n.ns1Prefix)
+	serviceName = strings.TrimSuffix(serviceName, "."+n.serviceZone.name)
+	svc, ok := services[serviceName]
+	if !ok {
+		svc = service{name: serviceName}
+	}
+	svc.ns1IDs.httpsRecID = record.ID
+	svc.ttls.httpsRecTTL = int64(record.TTL)
+	if len(record.ShortAns) > 0 {
+		svc.httpsRecAnswer = record.ShortAns[0]
+	}
+	services[serviceName] = svc
+}
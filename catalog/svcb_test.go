@@ -0,0 +1,90 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestHTTPSAnswer(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	table := map[string]struct {
+		cnodes   []*consulapi.CatalogService
+		expected string
+	}{
+		"none": {[]*consulapi.CatalogService{{Node: "n1"}}, ""},
+		"alpn": {[]*consulapi.CatalogService{
+			{Node: "n1"},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-svcb-alpn": "h2, h3"}},
+		}, "1 . alpn=h2,h3"},
+		"port": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-svcb-alpn": "h2", "ns1-svcb-port": "8443"}},
+		}, "1 . alpn=h2 port=8443"},
+		"invalid port": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-svcb-alpn": "h2", "ns1-svcb-port": "99999"}},
+		}, "1 . alpn=h2"},
+		"port only": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-svcb-port": "8443"}},
+		}, ""},
+		"different": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-svcb-alpn": "h3"}},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-svcb-alpn": "h2"}},
+		}, "1 . alpn=h2"},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, c.httpsAnswer("s1", v.cnodes), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestTransformZoneRecords_HTTPS(t *testing.T) {
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1"}, Type: "A", TTL: 5},
+			{Domain: "s1.test.zone", ID: "r2", ShortAns: []string{"1 . alpn=h2,h3"}, Type: "HTTPS", TTL: 5},
+		},
+	}
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, log: hclog.NewNullLogger(), addressFamily: ipv4Family}
+	assert.Equal(t, map[string]service{
+		"s1": {
+			name:           "s1",
+			nodes:          map[string]node{"1.1.1.1": {aRecAnswer: "1.1.1.1"}},
+			ns1IDs:         recordIDs{aRecID: "r1", httpsRecID: "r2"},
+			ttls:           recordTTLs{aRecTTL: 5, httpsRecTTL: 5},
+			httpsRecAnswer: "1 . alpn=h2,h3",
+		},
+	}, n.transformZoneRecords(z))
+}
+
+func TestCreate_HTTPS(t *testing.T) {
+	n := testClient(nil)
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+
+	input := map[string]service{
+		"s1": {
+			httpsRecAnswer: "1 . alpn=h2,h3 port=8443",
+			unchanged:      recordTypes{aRec: true, aaaaRec: true, srvRec: true, txtRec: true, ownerRec: true},
+		},
+	}
+	assert.Equal(t, int32(1), n.create(input))
+	assert.Equal(t, []*dns.Record{newTestRecord("HTTPS", "s1", n.serviceZone.name, []string{"1 . alpn=h2,h3 port=8443"})}, records.records)
+
+	// the record is deleted once no instance declares HTTPS parameters
+	records = &mockRecordService{mux: &sync.Mutex{}}
+	n.client.Records = records
+	input = map[string]service{
+		"s1": {
+			ns1IDs:    recordIDs{httpsRecID: "r1"},
+			unchanged: recordTypes{aRec: true, aaaaRec: true, srvRec: true, txtRec: true, ownerRec: true},
+		},
+	}
+	assert.Equal(t, int32(1), n.create(input))
+	assert.Empty(t, records.records)
+	assert.Equal(t, []string{"s1.test.zone HTTPS"}, records.deleted)
+}
@@ -1,7 +1,11 @@
 package catalog
 
 import (
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 
 	consulapi "github.com/hashicorp/consul/api"
@@ -9,83 +13,710 @@ import (
 	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
 )
 
-// Sync consul->ns1
-func Sync(ns1Prefix, ns1PollInterval string, ns1DNSTTL int64, ns1Domain string, stale bool, ns1Client *ns1api.Client, consulClient *consulapi.Client, stop, stopped chan struct{}) {
+// Config holds the settings used to sync Consul services to NS1
+type Config struct {
+	// NS1Prefix is prepended to all service names written to NS1
+	NS1Prefix string
+	// NS1PollInterval is the interval between fetches from NS1, e.g. "30s"
+	NS1PollInterval string
+	// Reload is signalled to restore the settings changed at runtime, e.g. the poll interval, to their configured
+	// values, nil to disable
+	Reload <-chan struct{}
+	// NS1ReadClient polls the zone and reads records when set, so the frequent reads can use an API key with
+	// read permissions only. The client passed to Sync then only writes, nil to read and write with it.
+	NS1ReadClient *ns1api.Client
+	// NS1PrefixSearch polls only the records under NS1Prefix with the NS1 search API instead of the whole zone.
+	// It requires a prefix and can't be combined with AccountMaxRecords, which counts the records of the zone.
+	NS1PrefixSearch bool
+	// NS1DNSTTL is the TTL in seconds of records created in NS1
+	NS1DNSTTL int64
+	// NS1DNSTTLJitter deviates the TTL of each record from NS1DNSTTL by up to this percentage,
+	// derived from the name and type of the record, 0 disables jitter
+	NS1DNSTTLJitter int
+	// NS1AnswerSeed orders the answers of each record pseudo-randomly but stably across syncs, empty to sort them
+	NS1AnswerSeed string
+	// NS1Domain is the name of the NS1 zone to sync services to
+	NS1Domain string
+	// CreateZone creates NS1Domain with the SOA defaults of NS1 when it's missing instead of failing
+	CreateZone bool
+	// ZoneRouting only syncs the services routed to NS1Domain by their "ns1-zone" service meta or tag, so the
+	// services of a catalog can be split between zones synced by an instance each
+	ZoneRouting bool
+	// DefaultZone is the zone of the services without a route with ZoneRouting, empty for NS1Domain
+	DefaultZone string
+	// Stale allows any Consul server to answer queries, not just the leader
+	Stale bool
+	// HealthAggregation is the policy used to combine multiple health checks of an instance,
+	// either "worst" (the default) or "best"
+	HealthAggregation string
+	// IgnoreNodeChecks excludes node-level checks (e.g. serfHealth) from the health of service instances
+	IgnoreNodeChecks bool
+	// OnlyPassing only publishes the instances passing their checks, critical instances are left out
+	OnlyPassing bool
+	// WarningPolicy decides whether instances in warning state are published with OnlyPassing,
+	// either "exclude" (the default) or "publish"
+	WarningPolicy string
+	// UpFilter keeps the instances failing their checks in the A and AAAA answers of their service, with their
+	// up meta set to false, and appends the up filter to the filter chain of the records. It can't be combined
+	// with OnlyPassing, and instances in warning state follow WarningPolicy.
+	UpFilter bool
+	// UpFeeds points the up meta of the A, AAAA and SRV answers to data feeds of an NS1 API data source, one per
+	// address of a service, and publishes Consul health changes to the feeds as soon as they are fetched, without
+	// rewriting records. It can't be combined with OnlyPassing or UpFilter.
+	UpFeeds bool
+	// SyncMonitors mirrors the HTTP and TCP checks of the instances of each service as NS1 monitoring jobs, one per
+	// address, and points the up meta of the A, AAAA and SRV answers to the feeds of the jobs. It can't be combined
+	// with UpFilter or UpFeeds.
+	SyncMonitors bool
+	// MonitorRegions are the NS1 regions monitoring jobs run from, "lga", "sjc" and "ams" if empty
+	MonitorRegions []string
+	// PortHints publishes a TXT record listing the ports of a service next to its A record
+	PortHints bool
+	// MaxRecords is the maximum number of records managed under NS1Prefix, 0 means unlimited
+	MaxRecords int
+	// MaxAnswers is the maximum number of answers of a single managed record, 0 means unlimited
+	MaxAnswers int
+	// AddressFamily selects which instance addresses are published: "ipv4" (the default) in A records,
+	// "ipv6" in AAAA records or "dual" for both
+	AddressFamily string
+	// LowercaseServiceNames publishes services under their lowercased name
+	LowercaseServiceNames bool
+	// SanitizeServiceNames is a comma-separated list of the steps turning service names into valid DNS names:
+	// "lowercase", "underscores" replaced with hyphens, "long" labels shortened with a hash and "unicode"
+	// labels encoded in punycode, or "all" of them. Services are published and read back under their
+	// sanitized name.
+	SanitizeServiceNames string
+	// PublishConnectProxies publishes the addresses and ports of the Connect sidecar proxies of a service under its
+	// name instead of the ones of its instances, and doesn't publish the proxies as services of their own
+	PublishConnectProxies bool
+	// PublishProxyServices publishes the Connect sidecar proxies and gateways, e.g. "web-sidecar-proxy" or
+	// "mesh-gateway", as services of their own. They're mesh internals left out of DNS otherwise.
+	PublishProxyServices bool
+	// DatacenterSubdomains publishes the instances of each service in each datacenter at
+	// "<prefix><service>.<datacenter>" next to "<prefix><service>". It can't be combined with UpFeeds or
+	// SyncMonitors, whose feeds are named after services.
+	DatacenterSubdomains bool
+	// TagSubdomains publishes the instances of each service carrying a tag at "<prefix><tag>.<service>" next to
+	// "<prefix><service>", like Consul DNS does. It can't be combined with DomainTemplate, UpFeeds or
+	// SyncMonitors.
+	TagSubdomains bool
+	// DomainTemplate names the records of services after a template of placeholders, e.g.
+	// "{prefix}{service}.{dc}.{zone}", see `parseDomainTemplate`. It can't be combined with DatacenterSubdomains,
+	// SRVTargetHostnames, UpFeeds or SyncMonitors, which name records after services.
+	DomainTemplate string
+	// AllowServices and DenyServices are comma-separated lists of globs, or regular expressions between slashes,
+	// selecting the services published by their name, see `parseServicePatterns`. Deny patterns take precedence.
+	// The records of services that aren't selected are left alone.
+	AllowServices string
+	DenyServices  string
+	// SRVTargetHostnames points SRV answers at per-node A and AAAA records managed next to the service,
+	// "<node>.<prefix><service>", instead of raw IPs
+	SRVTargetHostnames bool
+	// CheckedPortsOnly only publishes SRV answers for the ports targeted by a TCP or HTTP health check
+	// of the service on the node of the instance
+	CheckedPortsOnly bool
+	// OwnershipRegistry marks every managed service with a TXT record naming the owning instance by its
+	// NS1Prefix, so instances with different prefixes never touch each other's records in a shared zone
+	OwnershipRegistry bool
+	// ConflictPolicy decides what happens to records without an ownership record at the domain of a service,
+	// either "skip" (the default), "adopt" or "error". It only applies with OwnershipRegistry.
+	ConflictPolicy string
+	// EditPolicy decides what happens to records edited outside of consul-ns1 since they were last synced,
+	// either "overwrite" (the default), "skip" or "merge"
+	EditPolicy string
+	// EmptyAnswerPolicy decides what happens when an update would remove all the answers of a record: "warn"
+	// (the default) writes it and logs a warning, "block" leaves the record with its answers
+	EmptyAnswerPolicy string
+	// NS1Filters is the default filter chain of the records created, a JSON list of filters in the format of the
+	// NS1 API or the path of a file holding one, empty to create records without filters. The filters of records
+	// adopted are left alone.
+	NS1Filters string
+	// FiltersKVPrefix is the Consul KV prefix holding filter chains overriding NS1Filters for the A, AAAA and SRV
+	// records of services at "<prefix>/<service>/filters", e.g. "consul-ns1/services", empty to only read them
+	// from the "ns1-filters" service meta
+	FiltersKVPrefix string
+	// RecordMarker marks every record written with "managed-by=consul-ns1" in its note, and only deletes the
+	// records carrying the marker
+	RecordMarker bool
+	// UseClientSubnet enables ("true") or disables ("false") EDNS Client Subnet on the records created, empty for
+	// the default of NS1. The "ns1-use-client-subnet" service meta takes precedence.
+	UseClientSubnet string
+	// NS1TemplateRecord is the domain of records of the zone whose filters, meta and regions are cloned onto the
+	// records of the same type created, e.g. "_template", empty to create records without template
+	NS1TemplateRecord string
+	// CoManagedRecords marks the answers written to A, AAAA and SRV records and leaves all other answers alone,
+	// so records can hold answers managed by hand next to answers synced from Consul
+	CoManagedRecords bool
+	// SyncerID identifies the instance among the instances sharing co-managed records, typically one per datacenter.
+	// Its answers are marked with it and it registers the regions it writes to in a coordination record, empty to
+	// share the marker of all instances syncing the same prefix
+	SyncerID string
+	// TaggedAddresses are the tags of node or service tagged addresses, e.g. "wan", published as additional
+	// answers of the A and AAAA records of the instances next to their address
+	TaggedAddresses []string
+	// RolloutsKVPrefix is the Consul KV prefix holding the rollouts of services at "<prefix>/<service>", e.g.
+	// `{"tag": "canary", "weight": 10}` sending 10 percent of the traffic to the instances tagged "canary", empty
+	// to disable rollouts. It requires WeightedAnswers.
+	RolloutsKVPrefix string
+	// FeatureFlagsKVPrefix is the Consul KV prefix holding feature flags toggling behaviors at runtime at
+	// "<prefix>/<flag>", "true" or "false": "deletes", "only-passing" and "observe-only". Flags that aren't set keep
+	// the configured behavior, empty to disable feature flags.
+	FeatureFlagsKVPrefix string
+	// WeightedAnswers sets the weight of A and AAAA answers from the Consul weights of the instances or the
+	// ns1-weight meta of their nodes, and appends the weighted_shuffle filter to the filter chain of the records
+	WeightedAnswers bool
+	// GeoMetadata publishes the location of the instances in the meta of A and AAAA answers: the georegion of their
+	// datacenter, overridden by the georegion, country, latitude and longitude meta of their nodes
+	GeoMetadata bool
+	// VersionNotes publishes the version of the instances in the note of A and AAAA answers, taken from their
+	// "version" service meta or the first of their tags naming a version, e.g. "v1.2.3"
+	VersionNotes bool
+	// GeoRegions map Consul datacenters to NS1 georegions, each "DATACENTER=GEOREGION", e.g. "dc1=US-EAST"
+	GeoRegions []string
+	// GeotargetRegional appends the geotarget_regional filter to the records of located answers
+	GeotargetRegional bool
+	// DatacenterRegions groups the A, AAAA and SRV answers of the instances in NS1 regions named after their
+	// datacenter, with the georegion of the datacenter in the meta of the region if it's mapped by GeoRegions
+	DatacenterRegions bool
+	// ResyncEvent is the name of a Consul user event triggering an immediate full reconciliation, empty to disable
+	ResyncEvent string
+	// ResyncKey is a Consul KV key whose modification triggers an immediate full reconciliation, empty to disable
+	ResyncKey string
+	// FreezeWindows are periods during which no changes are written to NS1, each a five field cron schedule
+	// in UTC followed by a duration, e.g. "0 18 * * 5 62h"
+	FreezeWindows []string
+	// Approval holds back sync cycles changing more than ApprovalThreshold services until they are approved
+	Approval bool
+	// ApprovalThreshold is the number of changed services a sync cycle may apply without approval
+	ApprovalThreshold int
+	// ApprovalFile is written with the changes pending approval, empty to disable
+	ApprovalFile string
+	// ApprovalKey is a Consul KV key approving the pending changes whose ID it holds, empty to disable
+	ApprovalKey string
+	// AdminAddr is the address the admin API listens on, e.g. "127.0.0.1:9090", empty to disable
+	AdminAddr string
+	// StallFactor is the multiple of their expected iteration time after which the fetch and sync loops
+	// are considered stalled, 0 disables liveness checks
+	StallFactor int
+	// RestartStalledLoops restarts loops considered stalled
+	RestartStalledLoops bool
+	// RegistryGCInterval is the interval between garbage collections of the ownership registry, e.g. "10m", 0 disables them
+	RegistryGCInterval string
+	// ConsulMinQueryInterval is the minimum time between two blocking queries for Consul services, e.g. "1s",
+	// empty or 0 only rate limits queries that returned no change
+	ConsulMinQueryInterval string
+	// PublishInstanceCount writes the number of healthy instances of each service into the note of its records
+	PublishInstanceCount bool
+	// SyncHealthServiceID is the ID of a Consul service registered on the local agent that a TTL check reporting
+	// whether its records are in sync is registered on for each managed service, empty to disable
+	SyncHealthServiceID string
+	// JournalFile is a file the mutations of a sync cycle are recorded in while they are applied, so an interrupted
+	// cycle is healed on startup, empty to disable
+	JournalFile string
+	// StateFile is a file the sync state is kept in across restarts, e.g. the answers last written to each record,
+	// empty to keep it in memory only
+	StateFile string
+	// ChurnThreshold is the number of changes per hour above which a service is reported as flapping, 0 disables
+	// churn tracking
+	ChurnThreshold int
+	// LeaderLockKey is a Consul KV key used to elect a single active instance among the instances sharing it,
+	// empty to disable leader election
+	LeaderLockKey string
+	// DeregisterOnShutdown removes all managed records from NS1 when the syncer is stopped
+	DeregisterOnShutdown bool
+	// AccountMaxRecords is the record limit of the NS1 plan, 0 disables record usage checks
+	AccountMaxRecords int
+	// AccountMaxQPS is the query rate limit of the NS1 plan, 0 disables query usage checks
+	AccountMaxQPS float64
+	// AccountCheckInterval is the interval between account usage checks, e.g. "5m"
+	AccountCheckInterval string
+	// PauseCreatesNearLimit stops creating records while account usage is above the second warning threshold
+	PauseCreatesNearLimit bool
+	// NS1APIRate is the number of tokens per second of the limiter shared by NS1 API reads and writes,
+	// 0 disables the limiter
+	NS1APIRate float64
+	// NS1APIReadWeight and NS1APIWriteWeight are the tokens taken by a read and a write of the NS1 API
+	NS1APIReadWeight  float64
+	NS1APIWriteWeight float64
+}
+
+// Sync consul->ns1. It returns once `stop` is closed, or with an error of one of the classes of `Error`
+// if syncing failed, e.g. ErrConsulUnavailable.
+func Sync(cfg Config, ns1Client *ns1api.Client, consulClient *consulapi.Client, stop, stopped chan struct{}) error {
 	defer close(stopped)
 	log := hclog.Default().Named("sync")
-	consul := consul{
-		client:    consulClient,
-		log:       hclog.Default().Named("consul"),
-		trigger:   make(chan bool, 1),
-		ns1Prefix: ns1Prefix,
-		stale:     stale,
-		dnsTTL:    ns1DNSTTL,
-	}
-	pollInterval, err := time.ParseDuration(ns1PollInterval)
+	consul, err := newConsul(cfg, consulClient)
+	if err != nil {
+		log.Error("invalid configuration", "error", err)
+		return err
+	}
+	conflicts, err := parseConflictPolicy(cfg.ConflictPolicy)
+	if err != nil {
+		log.Error("invalid conflict policy", "error", err)
+		return wrapError(ErrInvalidConfig, err)
+	}
+	edits, err := parseEditPolicy(cfg.EditPolicy)
+	if err != nil {
+		log.Error("invalid edit policy", "error", err)
+		return wrapError(ErrInvalidConfig, err)
+	}
+	empty, err := parseEmptyPolicy(cfg.EmptyAnswerPolicy)
+	if err != nil {
+		log.Error("invalid empty answer policy", "error", err)
+		return wrapError(ErrInvalidConfig, err)
+	}
+	if cfg.GeotargetRegional && !cfg.GeoMetadata {
+		log.Error("the geotarget_regional filter requires geo metadata")
+		return wrapError(ErrInvalidConfig, errors.New("geotarget_regional filter without geo metadata"))
+	}
+	if len(cfg.GeoRegions) > 0 && !cfg.GeoMetadata && !cfg.DatacenterRegions {
+		log.Error("georegion mappings require geo metadata or datacenter regions")
+		return wrapError(ErrInvalidConfig, errors.New("georegion mappings without geo metadata or datacenter regions"))
+	}
+	filters, err := parseFilters(cfg.NS1Filters)
+	if err != nil {
+		log.Error("invalid default filter chain", "error", err)
+		return wrapError(ErrInvalidConfig, err)
+	}
+	clientSubnet, err := parseClientSubnet(cfg.UseClientSubnet)
+	if err != nil {
+		log.Error("invalid client subnet option", "error", err)
+		return wrapError(ErrInvalidConfig, err)
+	}
+	freezeWindows, err := parseFreezeWindows(cfg.FreezeWindows)
+	if err != nil {
+		log.Error("invalid freeze window", "error", err)
+		return wrapError(ErrInvalidConfig, err)
+	}
+	if cfg.DefaultZone != "" && !cfg.ZoneRouting {
+		log.Error("a default zone requires zone routing")
+		return wrapError(ErrInvalidConfig, errors.New("default zone without zone routing"))
+	}
+	if cfg.RolloutsKVPrefix != "" && !cfg.WeightedAnswers {
+		log.Error("rollouts require weighted answers")
+		return wrapError(ErrInvalidConfig, errors.New("rollouts without weighted answers"))
+	}
+	if cfg.VersionNotes && cfg.CoManagedRecords {
+		log.Error("version notes can't be combined with co-managed records, which mark answers in their note")
+		return wrapError(ErrInvalidConfig, errors.New("version notes and co-managed records can't be combined"))
+	}
+	if (cfg.OnlyPassing && cfg.UpFilter) || (cfg.UpFeeds && (cfg.OnlyPassing || cfg.UpFilter)) {
+		log.Error("only one of -only-passing, the up filter and up feeds can be used")
+		return wrapError(ErrInvalidConfig, errors.New("only passing instances, the up filter and up feeds can't be combined"))
+	}
+	if cfg.NS1DNSTTLJitter < 0 || cfg.NS1DNSTTLJitter > maxTTLJitter {
+		log.Error(fmt.Sprintf("invalid TTL jitter, must be between 0 and %d percent", maxTTLJitter),
+			"jitter", fmt.Sprintf("%d", cfg.NS1DNSTTLJitter))
+		return wrapError(ErrInvalidConfig, fmt.Errorf("TTL jitter %d out of range", cfg.NS1DNSTTLJitter))
+	}
+	if cfg.NS1APIRate < 0 || (cfg.NS1APIRate > 0 && (cfg.NS1APIReadWeight <= 0 || cfg.NS1APIWriteWeight <= 0)) {
+		log.Error("invalid NS1 API limiter, the rate must not be negative and the weights must be positive",
+			"rate", fmt.Sprintf("%g", cfg.NS1APIRate), "read-weight", fmt.Sprintf("%g", cfg.NS1APIReadWeight),
+			"write-weight", fmt.Sprintf("%g", cfg.NS1APIWriteWeight))
+		return wrapError(ErrInvalidConfig, errors.New("invalid NS1 API limiter"))
+	}
+	if cfg.SyncMonitors && (cfg.UpFilter || cfg.UpFeeds) {
+		log.Error("syncing monitoring jobs can't be combined with the up filter or up feeds")
+		return wrapError(ErrInvalidConfig, errors.New("invalid monitoring jobs"))
+	}
+	if cfg.DatacenterSubdomains && (cfg.UpFeeds || cfg.SyncMonitors) {
+		log.Error("datacenter subdomains can't be combined with up feeds or monitoring jobs")
+		return wrapError(ErrInvalidConfig, errors.New("invalid datacenter subdomains"))
+	}
+	if cfg.TagSubdomains && (cfg.UpFeeds || cfg.SyncMonitors) {
+		log.Error("tag subdomains can't be combined with up feeds or monitoring jobs")
+		return wrapError(ErrInvalidConfig, errors.New("invalid tag subdomains"))
+	}
+	if consul.naming != nil && (cfg.DatacenterSubdomains || cfg.TagSubdomains || cfg.SRVTargetHostnames || cfg.UpFeeds || cfg.SyncMonitors) {
+		log.Error("a domain template can't be combined with datacenter or tag subdomains, SRV target hostnames, up feeds or monitoring jobs")
+		return wrapError(ErrInvalidConfig, errors.New("invalid domain template"))
+	}
+	if cfg.NS1PrefixSearch && (cfg.NS1Prefix == "" || cfg.AccountMaxRecords > 0) {
+		log.Error("searching records under the service prefix requires a prefix and can't be combined with -ns1-account-max-records")
+		return wrapError(ErrInvalidConfig, errors.New("invalid prefix search"))
+	}
+	if cfg.SyncerID != "" && (!cfg.CoManagedRecords || strings.ContainsAny(cfg.SyncerID, ", :\"")) {
+		log.Error("a syncer ID requires co-managed records and must not contain commas, colons, spaces or quotes")
+		return wrapError(ErrInvalidConfig, fmt.Errorf("invalid syncer ID %q", cfg.SyncerID))
+	}
+	if cfg.ChurnThreshold < 0 {
+		log.Error("invalid churn threshold, must not be negative", "threshold", fmt.Sprintf("%d", cfg.ChurnThreshold))
+		return wrapError(ErrInvalidConfig, fmt.Errorf("negative churn threshold %d", cfg.ChurnThreshold))
+	}
+	if cfg.ConsulMinQueryInterval != "" {
+		consul.minQueryInterval, err = time.ParseDuration(cfg.ConsulMinQueryInterval)
+		if err != nil || consul.minQueryInterval < 0 {
+			log.Error("invalid consul minimum query interval", "interval", cfg.ConsulMinQueryInterval)
+			return wrapError(ErrInvalidConfig, fmt.Errorf("invalid consul minimum query interval %q", cfg.ConsulMinQueryInterval))
+		}
+	}
+	pollInterval, err := parsePollInterval(cfg.NS1PollInterval)
 	if err != nil {
-		log.Error("cannot parse ns1 pull interval", "error", err)
-		return
+		log.Error("invalid ns1 poll interval", "error", err)
+		return wrapError(ErrInvalidConfig, err)
+	}
+	reader := ns1Client
+	if cfg.NS1ReadClient != nil {
+		reader = cfg.NS1ReadClient
 	}
 	ns1 := ns1{
-		client:       &ns1APIClient{Zones: ns1Client.Zones, Records: ns1Client.Records},
-		log:          hclog.Default().Named("ns1"),
-		ns1Prefix:    ns1Prefix,
-		trigger:      make(chan bool, 1),
-		pollInterval: pollInterval,
-		dnsTTL:       ns1DNSTTL,
+		client: &ns1APIClient{
+			Zones:    reader.Zones,
+			Records:  ns1Client.Records,
+			Warnings: reader.Warnings,
+			Stats:    reader.Stats,
+		},
+		log:               hclog.Default().Named("ns1"),
+		ns1Prefix:         cfg.NS1Prefix,
+		trigger:           make(chan bool, 1),
+		pollInterval:      pollInterval,
+		tuner:             &pollTuner{},
+		dnsTTL:            cfg.NS1DNSTTL,
+		answerSeed:        cfg.NS1AnswerSeed,
+		naming:            consul.naming,
+		selection:         consul.selection,
+		portHints:         cfg.PortHints,
+		quota:             quota{maxRecords: cfg.MaxRecords, maxAnswers: cfg.MaxAnswers},
+		addressFamily:     consul.addressFamily,
+		ownershipRegistry: cfg.OwnershipRegistry,
+		recordMarker:      cfg.RecordMarker,
+		conflictPolicy:    conflicts,
+		instanceCountMeta: cfg.PublishInstanceCount,
+		freezeWindows:     freezeWindows,
+		filters:           filters,
+		clientSubnet:      clientSubnet,
+		filterOverrides:   &filterOverrides{},
+		settings:          &answerSettings{managed: managedSettings(cfg)},
+		cycle:             &syncCycle{},
+		edits:             &editGuard{log: hclog.Default().Named("edits"), policy: edits},
+		empty:             &emptyGuard{log: hclog.Default().Named("empty"), policy: empty},
+		accountLimits: accountLimits{
+			maxRecords:   cfg.AccountMaxRecords,
+			maxQPS:       cfg.AccountMaxQPS,
+			pauseCreates: cfg.PauseCreatesNearLimit,
+		},
+	}
+	if cfg.NS1ReadClient != nil {
+		ns1.client.Records = &splitRecordService{read: reader.Records, write: ns1Client.Records}
+	}
+	if cfg.CreateZone {
+		ns1.client.ZoneCreator = ns1Client.Zones
+	}
+	if cfg.NS1PrefixSearch {
+		ns1.client.Search = &ns1SearchService{client: reader}
+	}
+	if cfg.CoManagedRecords {
+		ns1.coManaged = &coManaged{marker: ownerTXTAnswer(cfg.NS1Prefix)}
+		if cfg.SyncerID != "" {
+			ns1.coManaged.marker, ns1.coManaged.legacy = syncerMarker(cfg.NS1Prefix, cfg.SyncerID), ns1.coManaged.marker
+		}
+	}
+	if cfg.WeightedAnswers {
+		ns1.weights = &answerWeights{}
+	}
+	if cfg.UpFilter {
+		ns1.states = &answerStates{}
+	}
+	if cfg.GeoMetadata {
+		ns1.geos, ns1.geotargetRegional = &answerGeos{}, cfg.GeotargetRegional
+	}
+	if cfg.DatacenterRegions {
+		ns1.regions, ns1.georegions = &answerRegions{}, consul.geoRegions
+	}
+	if cfg.VersionNotes {
+		ns1.versions = &answerVersions{}
+	}
+	if cfg.NS1APIRate > 0 {
+		ns1.limiter = &apiLimiter{rate: cfg.NS1APIRate, readWeight: cfg.NS1APIReadWeight, writeWeight: cfg.NS1APIWriteWeight}
+	}
+	if cfg.NS1TemplateRecord != "" {
+		ns1.template = &recordTemplate{
+			records: reader.Records,
+			log:     hclog.Default().Named("template"),
+			limiter: ns1.limiter,
+			zone:    cfg.NS1Domain,
+			domain:  templateDomain(cfg.NS1TemplateRecord, cfg.NS1Domain),
+		}
+	}
+	if cfg.UpFeeds {
+		feeds := &upFeeds{
+			sources: ns1Client.DataSources,
+			feeds:   ns1Client.DataFeeds,
+			log:     hclog.Default().Named("feeds"),
+			limiter: ns1.limiter,
+			name:    "consul-ns1 " + cfg.NS1Prefix + cfg.NS1Domain,
+		}
+		if err := feeds.init(); err != nil {
+			log.Error("cannot set up the data source of up feeds", "error", err.Error())
+			return wrapError(ErrNS1Unavailable, err)
+		}
+		consul.feeds, ns1.feeds = feeds, feeds
+	}
+	if cfg.SyncMonitors {
+		monitors := &monitors{
+			jobs:    ns1Client.Jobs,
+			sources: ns1Client.DataSources,
+			feeds:   ns1Client.DataFeeds,
+			log:     hclog.Default().Named("monitors"),
+			limiter: ns1.limiter,
+			name:    "consul-ns1 " + cfg.NS1Prefix + cfg.NS1Domain,
+			regions: cfg.MonitorRegions,
+		}
+		if len(monitors.regions) == 0 {
+			monitors.regions = defaultMonitorRegions
+		}
+		if err := monitors.init(); err != nil {
+			log.Error("cannot set up the data source of monitoring jobs", "error", err.Error())
+			return wrapError(ErrNS1Unavailable, err)
+		}
+		consul.monitors, ns1.feeds = monitors, monitors
+	}
+	approval, err := newApprovalGate(cfg, consulClient, consul.requestResync)
+	if err != nil {
+		log.Error("invalid approval mode", "error", err)
+		return wrapError(ErrInvalidConfig, err)
+	}
+	ns1.approval = approval
+	if cfg.AdminAddr != "" {
+		ln, err := net.Listen("tcp", cfg.AdminAddr)
+		if err != nil {
+			log.Error("cannot start admin API", "error", err)
+			return wrapError(ErrInvalidConfig, err)
+		}
+		ns1.events = &eventStream{}
+		srv := &http.Server{Handler: adminHandler(ns1.approval, ns1.events, ns1.cycle, &ns1)}
+		go srv.Serve(ln)
+		defer srv.Close()
+		log.Info("admin API listening", "address", ln.Addr().String())
 	}
 	/*ns1.client = &ns1APIClient{
 		Zones:   ns1Client.Zones,
 		Records: ns1Client.Records,
 	}*/
-	err = ns1.setupServiceZone(ns1Domain)
+	err = ns1.setupServiceZone(cfg.NS1Domain)
 	if err != nil {
-		switch err {
-		case ns1api.ErrZoneMissing:
-			log.Error(fmt.Sprintf("zone %s not found in NS1", ns1Domain), "error", err)
+		switch ErrorClass(err) {
+		case ErrZoneMissing:
+			log.Error(fmt.Sprintf("zone %s not found in NS1", cfg.NS1Domain), "error", err)
 		default:
-			log.Error(fmt.Sprintf("cannot sync to domain %s", ns1Domain), "error", err)
+			log.Error(fmt.Sprintf("cannot sync to domain %s", cfg.NS1Domain), "error", err)
+		}
+		return err
+	}
+
+	if cfg.OwnershipRegistry && cfg.RegistryGCInterval != "" {
+		ns1.registryGCInterval, err = time.ParseDuration(cfg.RegistryGCInterval)
+		if err != nil {
+			log.Error("cannot parse registry garbage collection interval", "error", err)
+			return wrapError(ErrInvalidConfig, err)
+		}
+	}
+	if ns1.accountLimits.enabled() {
+		interval, err := time.ParseDuration(cfg.AccountCheckInterval)
+		if err != nil || interval <= 0 {
+			log.Error("invalid account check interval", "interval", cfg.AccountCheckInterval)
+			return wrapError(ErrInvalidConfig, fmt.Errorf("invalid account check interval %q", cfg.AccountCheckInterval))
+		}
+		// count the records of the zone before the first check
+		if err := ns1.fetch(); err != nil {
+			log.Warn("cannot fetch NS1 zone to check account usage", "error", err)
+		}
+		ns1.checkAccountUsage()
+		accountStop := make(chan struct{})
+		defer close(accountStop)
+		go ns1.watchAccountUsage(interval, accountStop)
+	}
+
+	// lost is closed when leadership is lost, it is nil and never closed without leader election
+	var lost <-chan struct{}
+	if cfg.LeaderLockKey != "" {
+		leader, err := newLeadership(consulClient, cfg.LeaderLockKey)
+		if err != nil {
+			log.Error("cannot set up leader election", "error", err)
+			return wrapError(ErrConsulUnavailable, err)
+		}
+		lost, err = leader.acquire(stop)
+		if err != nil {
+			log.Error("cannot acquire leadership", "error", err)
+			return wrapError(ErrConsulUnavailable, err)
+		}
+		if lost == nil {
+			return nil
+		}
+		defer leader.release()
+		consul.heal(&ns1)
+	}
+
+	if cfg.SyncHealthServiceID != "" {
+		// checks are updated every sync cycle, which runs once both fetch loops completed an iteration
+		interval := pollInterval
+		for _, d := range []time.Duration{WaitTime * time.Second, consul.minQueryInterval} {
+			if d > interval {
+				interval = d
+			}
+		}
+		consul.syncHealth = &syncHealth{
+			log:       hclog.Default().Named("sync-health"),
+			agent:     consulClient.Agent(),
+			serviceID: cfg.SyncHealthServiceID,
+			ttl:       syncHealthTTLFactor * interval,
+		}
+	}
+	if cfg.JournalFile != "" {
+		ns1.journal = &journal{log: hclog.Default().Named("journal"), file: cfg.JournalFile}
+		interrupted, err := ns1.journal.recover()
+		if err != nil {
+			log.Error("cannot read journal", "file", cfg.JournalFile, "error", err)
+			return wrapError(ErrInvalidConfig, err)
 		}
-		return
+		if len(interrupted) > 0 {
+			consul.heal(&ns1)
+		}
+	}
+	if cfg.StateFile != "" {
+		ns1.store = &fileStore{file: cfg.StateFile}
+		state, err := ns1.store.load()
+		if err != nil {
+			log.Error("cannot read sync state", "file", cfg.StateFile, "error", err)
+			return wrapError(ErrInvalidConfig, err)
+		}
+		ns1.restore(state)
+	}
+	if cfg.SyncerID != "" {
+		ns1.syncers = &syncers{
+			records: ns1Client.Records,
+			log:     hclog.Default().Named("syncers"),
+			limiter: ns1.limiter,
+			zone:    cfg.NS1Domain,
+			dnsTTL:  ns1.dnsTTL,
+			marker:  ns1.coManaged.marker,
+		}
+		if err := ns1.syncers.register(ns1.regions.written()); err != nil {
+			log.Error("cannot register in the coordination record", "error", err.Error())
+			return wrapError(ErrNS1Unavailable, err)
+		}
+	}
+	if cfg.FiltersKVPrefix != "" {
+		// overrides are read before the first sync, so records aren't written with the default chain first
+		if _, err := consul.fetchFilterOverrides(0); err != nil {
+			log.Error("cannot read filter chains", "prefix", cfg.FiltersKVPrefix, "error", err)
+			return wrapError(ErrConsulUnavailable, err)
+		}
+	}
+	if cfg.RolloutsKVPrefix != "" {
+		// rollouts are read before the first sync, so answers aren't written with their full weight first
+		if _, err := consul.fetchRollouts(0); err != nil {
+			log.Error("cannot read rollouts", "prefix", cfg.RolloutsKVPrefix, "error", err)
+			return wrapError(ErrConsulUnavailable, err)
+		}
+	}
+	if cfg.FeatureFlagsKVPrefix != "" {
+		// flags are read before the first sync, so safety switches apply to it
+		consul.features = &featureFlags{prefix: cfg.FeatureFlagsKVPrefix}
+		if _, err := consul.fetchFeatureFlags(0); err != nil {
+			log.Error("cannot read feature flags", "prefix", cfg.FeatureFlagsKVPrefix, "error", err)
+			return wrapError(ErrConsulUnavailable, err)
+		}
+	}
+	consul.latency = &syncLatency{}
+	ns1.latency = consul.latency
+	if cfg.ChurnThreshold > 0 {
+		ns1.churn = &churn{log: hclog.Default().Named("churn"), threshold: cfg.ChurnThreshold}
 	}
 
-	fetchConsulStop := make(chan struct{})
-	fetchConsulStopped := make(chan struct{})
-	go consul.fetchIndefinitely(fetchConsulStop, fetchConsulStopped)
-	fetchNS1Stop := make(chan struct{})
-	fetchNS1Stopped := make(chan struct{})
-	go ns1.fetchIndefinitely(fetchNS1Stop, fetchNS1Stopped)
+	sup := &supervisor{
+		log:     hclog.Default().Named("supervisor"),
+		factor:  cfg.StallFactor,
+		restart: cfg.RestartStalledLoops,
+	}
+	fetchConsul := sup.start("consul-fetch", &consul.fetchBeat, consul.fetchIndefinitely)
+	fetchNS1 := sup.start("ns1-fetch", &ns1.fetchBeat, ns1.fetchIndefinitely)
+
+	resyncStop := make(chan struct{})
+	defer close(resyncStop)
+	if cfg.ResyncEvent != "" {
+		go consul.watchResyncEvent(cfg.ResyncEvent, resyncStop)
+	}
+	if cfg.ResyncKey != "" {
+		go consul.watchResyncKey(cfg.ResyncKey, resyncStop)
+	}
+	if cfg.FiltersKVPrefix != "" {
+		go consul.watchFilterOverrides(resyncStop)
+	}
+	if cfg.RolloutsKVPrefix != "" {
+		go consul.watchRollouts(resyncStop)
+	}
+	if cfg.FeatureFlagsKVPrefix != "" {
+		go consul.watchFeatureFlags(resyncStop)
+	}
+	if cfg.Reload != nil {
+		go ns1.watchReload(cfg.Reload, resyncStop)
+	}
 
-	toNS1Stop := make(chan struct{})
-	toNS1Stopped := make(chan struct{})
+	toNS1 := sup.start("sync", &consul.syncBeat, func(stop, stopped chan struct{}) {
+		consul.sync(&ns1, stop, stopped)
+	})
 
-	go consul.sync(&ns1, toNS1Stop, toNS1Stopped)
+	supervisorStop := make(chan struct{})
+	defer close(supervisorStop)
+	go sup.watch(supervisorStop)
 
+	// stopErr is the error syncing stopped with, nil when stopped on request
+	var stopErr error
 	select {
 	case <-stop:
-		close(toNS1Stop)
-		close(fetchNS1Stop)
-		close(fetchConsulStop)
-		<-fetchConsulStopped
-		<-fetchNS1Stopped
-		<-toNS1Stopped
-	case <-fetchNS1Stopped:
+		toNS1.halt()
+		fetchNS1.halt()
+		fetchConsul.halt()
+		<-fetchConsul.done
+		<-fetchNS1.done
+		<-toNS1.done
+		if cfg.DeregisterOnShutdown {
+			log.Info("deregistering services from NS1 before shutting down")
+			ns1.drain()
+		}
+	case <-fetchNS1.done:
 		log.Info("problem with NS1 fetch. shutting down...")
-		close(toNS1Stop)
-		close(fetchConsulStop)
-		<-toNS1Stopped
-		<-fetchConsulStopped
-	case <-fetchConsulStopped:
+		toNS1.halt()
+		fetchConsul.halt()
+		<-toNS1.done
+		<-fetchConsul.done
+		stopErr = wrapError(ErrNS1Unavailable, errors.New("NS1 fetch stopped"))
+	case <-fetchConsul.done:
 		log.Info("problem with consul fetch. shutting down...")
-		close(toNS1Stop)
-		close(fetchNS1Stop)
-		<-toNS1Stopped
-		<-fetchNS1Stopped
-	case <-toNS1Stopped:
+		toNS1.halt()
+		fetchNS1.halt()
+		<-toNS1.done
+		<-fetchNS1.done
+		stopErr = consul.fetchErr
+		if stopErr == nil {
+			stopErr = errors.New("consul fetch stopped")
+		}
+		stopErr = wrapError(ErrConsulUnavailable, stopErr)
+	case <-toNS1.done:
 		log.Info("problem with NS1 sync. shutting down...")
-		close(fetchConsulStop)
-		close(fetchNS1Stop)
-		<-fetchConsulStopped
-		<-fetchNS1Stopped
+		fetchConsul.halt()
+		fetchNS1.halt()
+		<-fetchConsul.done
+		<-fetchNS1.done
+		stopErr = consul.syncErr
+		if stopErr == nil {
+			stopErr = errors.New("NS1 sync stopped")
+		}
+		stopErr = wrapError(ErrNS1Unavailable, stopErr)
+	case <-lost:
+		log.Error("lost leadership. shutting down...")
+		toNS1.halt()
+		fetchNS1.halt()
+		fetchConsul.halt()
+		<-fetchConsul.done
+		<-fetchNS1.done
+		<-toNS1.done
+		stopErr = wrapError(ErrLeadershipLost, errors.New("lock released"))
+	}
+	ns1.saveState()
+	if stopErr != nil {
+		countError(stopErr)
 	}
+	return stopErr
 }
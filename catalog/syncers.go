@@ -0,0 +1,135 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// syncersRecordLabel is prepended to the zone to name the TXT record coordinating the instances sharing its records
+const syncersRecordLabel = "_consul-ns1-syncers."
+
+// syncerMarker returns the note marking the answers written by the instance syncing `prefix` with a syncer ID,
+// and its answer in the coordination record
+func syncerMarker(prefix, id string) string {
+	return ownerTXTAnswer(prefix) + ",syncer=" + id
+}
+
+// syncerAnswer returns the answer of an instance in the coordination record, claiming the regions it writes to
+func syncerAnswer(marker string, regions []string) string {
+	return marker + ",regions=" + strings.Join(regions, ":")
+}
+
+// parseSyncerAnswer returns the marker of an instance and the regions it claims from its answer in the coordination
+// record
+func parseSyncerAnswer(answer string) (string, []string, bool) {
+	answer = strings.Trim(answer, "\"")
+	i := strings.LastIndex(answer, ",regions=")
+	if !strings.HasPrefix(answer, ownerTXTPrefix) || i < 0 {
+		return "", nil, false
+	}
+	regions := []string{}
+	for _, r := range strings.Split(answer[i+len(",regions="):], ":") {
+		if r != "" {
+			regions = append(regions, r)
+		}
+	}
+	return answer[:i], regions, true
+}
+
+// syncers coordinates the instances, typically one per datacenter, sharing the co-managed records of a zone through
+// a TXT record holding one answer per instance, with the marker of its answers and the regions it writes to. The
+// regions claimed by other instances are never removed from records. A nil syncers doesn't coordinate.
+type syncers struct {
+	records recordService
+	log     hclog.Logger
+	limiter *apiLimiter
+	zone    string
+	// dnsTTL is the TTL the coordination record is created with
+	dnsTTL int64
+	// marker is the marker of the answers of this instance, see `syncerMarker`
+	marker string
+
+	lock sync.Mutex
+	// claimed holds the regions claimed by other instances as last fetched, with the marker of the instance
+	claimed map[string]string
+}
+
+// domain returns the domain of the coordination record
+func (s *syncers) domain() string {
+	return syncersRecordLabel + s.zone
+}
+
+// register claims the regions this instance writes to in the coordination record, and reads the regions claimed by
+// the other instances. The record is only written when the claims of this instance changed. It's called on startup
+// and after each sync cycle writing records.
+func (s *syncers) register(regions []string) error {
+	if s == nil {
+		return nil
+	}
+	sort.Strings(regions)
+	s.limiter.read()
+	rec, resp, err := s.records.Get(s.zone, s.domain(), "TXT")
+	if err == ns1api.ErrRecordMissing {
+		rec = dns.NewRecord(s.zone, s.domain(), "TXT")
+		rec.TTL = int(s.dnsTTL)
+	} else if err != nil {
+		return ns1Error(resp, err)
+	}
+	claimed := map[string]string{}
+	answers := []*dns.Answer{}
+	registered := false
+	for _, a := range rec.Answers {
+		marker, claims, ok := parseSyncerAnswer(strings.Join(a.Rdata, " "))
+		if !ok {
+			answers = append(answers, a)
+			continue
+		}
+		if marker == s.marker {
+			registered = strings.Join(claims, ":") == strings.Join(regions, ":")
+			continue
+		}
+		answers = append(answers, a)
+		for _, r := range claims {
+			claimed[r] = marker
+		}
+	}
+	for _, r := range regions {
+		if marker, ok := claimed[r]; ok {
+			s.log.Warn("region is claimed by another instance, its answers may be overwritten", "region", r, "instance", marker)
+		}
+	}
+	s.lock.Lock()
+	s.claimed = claimed
+	s.lock.Unlock()
+	if registered {
+		return nil
+	}
+	rec.Answers = append(answers, dns.NewTXTAnswer(syncerAnswer(s.marker, regions)))
+	s.limiter.write()
+	if rec.ID == "" {
+		resp, err = s.records.Create(rec)
+	} else {
+		resp, err = s.records.Update(rec)
+	}
+	if err != nil {
+		return ns1Error(resp, err)
+	}
+	s.log.Debug("Registered regions in the coordination record", "domain", s.domain(), "regions", strings.Join(regions, ","))
+	return nil
+}
+
+// claimedByOthers reports whether another instance claims a region
+func (s *syncers) claimedByOthers(region string) bool {
+	if s == nil {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, ok := s.claimed[region]
+	return ok
+}
@@ -0,0 +1,119 @@
+package catalog
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// syncersRecordService holds the coordination record, if any
+type syncersRecordService struct {
+	mockRecordService
+	record *dns.Record
+}
+
+func (s *syncersRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	if s.record == nil {
+		return nil, nil, ns1api.ErrRecordMissing
+	}
+	return s.record, nil, nil
+}
+
+func (s *syncersRecordService) Create(r *dns.Record) (*http.Response, error) {
+	r.ID = "coordination"
+	s.record = r
+	return s.mockRecordService.Create(r)
+}
+
+func (s *syncersRecordService) Update(r *dns.Record) (*http.Response, error) {
+	s.record = r
+	return s.mockRecordService.Update(r)
+}
+
+func TestParseSyncerAnswer(t *testing.T) {
+	table := map[string]struct {
+		answer  string
+		marker  string
+		regions []string
+		ok      bool
+	}{
+		"regions":    {"heritage=consul-ns1,prefix=,syncer=dc1,regions=dc1:dc3", "heritage=consul-ns1,prefix=,syncer=dc1", []string{"dc1", "dc3"}, true},
+		"no regions": {"\"heritage=consul-ns1,prefix=p-,syncer=dc2,regions=\"", "heritage=consul-ns1,prefix=p-,syncer=dc2", []string{}, true},
+		"foreign":    {"v=spf1 -all", "", nil, false},
+		"owner":      {"heritage=consul-ns1,prefix=", "", nil, false},
+	}
+	for name, v := range table {
+		marker, regions, ok := parseSyncerAnswer(v.answer)
+		assert.Equal(t, v.ok, ok, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.marker, marker, fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.regions, regions, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestSyncers_Register(t *testing.T) {
+	records := &syncersRecordService{mockRecordService: mockRecordService{mux: &sync.Mutex{}}}
+	dc1 := &syncers{records: records, log: hclog.NewNullLogger(), zone: "test.zone", dnsTTL: 60, marker: syncerMarker("", "dc1")}
+	dc2 := &syncers{records: records, log: hclog.NewNullLogger(), zone: "test.zone", dnsTTL: 60, marker: syncerMarker("", "dc2")}
+
+	assert.NoError(t, dc1.register([]string{"dc1"}))
+	assert.Equal(t, "_consul-ns1-syncers.test.zone", records.record.Domain)
+	assert.Equal(t, 60, records.record.TTL)
+	assert.NoError(t, dc2.register([]string{"dc2"}))
+	assert.Equal(t, []string{
+		"heritage=consul-ns1,prefix=,syncer=dc1,regions=dc1",
+		"heritage=consul-ns1,prefix=,syncer=dc2,regions=dc2",
+	}, answerStrings(records.record.Answers))
+	assert.True(t, dc2.claimedByOthers("dc1"))
+	assert.False(t, dc2.claimedByOthers("dc2"))
+
+	// claims are only written when they change
+	assert.NoError(t, dc1.register([]string{"dc1"}))
+	assert.Equal(t, 2, records.callCount)
+	assert.True(t, dc1.claimedByOthers("dc2"))
+	assert.NoError(t, dc1.register([]string{"dc3", "dc1"}))
+	assert.Equal(t, 3, records.callCount)
+	assert.Equal(t, []string{
+		"heritage=consul-ns1,prefix=,syncer=dc2,regions=dc2",
+		"heritage=consul-ns1,prefix=,syncer=dc1,regions=dc1:dc3",
+	}, answerStrings(records.record.Answers))
+}
+
+func TestCoManaged_SyncerID(t *testing.T) {
+	legacy := ownerTXTAnswer("")
+	m := &coManaged{marker: syncerMarker("", "dc1"), legacy: legacy}
+	rec := dns.NewRecord("test.zone", "s1.test.zone", "A")
+	rec.Answers = []*dns.Answer{
+		{Rdata: []string{"1.1.1.1"}, Meta: &data.Meta{Note: syncerMarker("", "dc1")}},
+		{Rdata: []string{"2.2.2.2"}, Meta: &data.Meta{Note: syncerMarker("", "dc2")}},
+		{Rdata: []string{"3.3.3.3"}, Meta: &data.Meta{Note: legacy}},
+	}
+
+	// answers of other syncers are kept, answers marked before the syncer ID was set are adopted
+	assert.Equal(t, []string{"1.1.1.1", "3.3.3.3"}, answerStrings(m.fetched(rec)))
+	rec.Answers = []*dns.Answer{dns.NewAv4Answer("4.4.4.4")}
+	m.mark(rec)
+	assert.Equal(t, []string{"4.4.4.4", "2.2.2.2"}, answerStrings(rec.Answers))
+	assert.Equal(t, syncerMarker("", "dc1"), rec.Answers[0].Meta.Note)
+}
+
+func TestAnswerRegions_PruneClaimed(t *testing.T) {
+	r := &answerRegions{}
+	rec := dns.NewRecord("test.zone", "s1.test.zone", "A")
+	a := dns.NewAv4Answer("1.1.1.1")
+	a.SetRegion("dc2")
+	rec.Answers = []*dns.Answer{a}
+	r.wrote(rec)
+
+	others := &syncers{claimed: map[string]string{"dc2": syncerMarker("", "dc2")}}
+	rec.Answers = []*dns.Answer{}
+	rec.Regions = data.Regions{"dc2": {}}
+	r.prune(rec, others)
+	assert.Equal(t, data.Regions{"dc2": {}}, rec.Regions)
+}
@@ -0,0 +1,87 @@
+package catalog
+
+import (
+	"sort"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+)
+
+// syncHealthCheckPrefix prefixes the IDs of the checks reporting the sync health of managed services
+const syncHealthCheckPrefix = "ns1-sync:"
+
+// syncHealthTTLFactor is the multiple of the sync interval after which a check that wasn't updated turns critical
+const syncHealthTTLFactor = 3
+
+// checkAgent registers and updates TTL checks, it is implemented by *consulapi.Agent
+type checkAgent interface {
+	CheckRegister(check *consulapi.AgentCheckRegistration) error
+	CheckDeregister(checkID string) error
+	UpdateTTL(checkID, output, status string) error
+}
+
+// syncHealth reports whether the records of each managed service are in sync with Consul through a TTL check
+// on a Consul service, so Consul consumers such as deploy pipelines can wait for DNS to be in sync.
+// The checks turn critical if the syncer stops updating them. A nil syncHealth reports nothing.
+type syncHealth struct {
+	log   hclog.Logger
+	agent checkAgent
+	// serviceID is the ID of the Consul service the checks are registered on
+	serviceID string
+	// ttl is the time after which a check that wasn't updated turns critical
+	ttl time.Duration
+	// registered holds the services a check is registered for
+	registered map[string]bool
+}
+
+// report updates the check of each desired service, registering missing ones and deregistering the checks
+// of services that are no longer desired. Services with pending changes are reported with a warning.
+func (h *syncHealth) report(desired, pending map[string]service) {
+	if h == nil {
+		return
+	}
+	if h.registered == nil {
+		h.registered = map[string]bool{}
+	}
+	names := make([]string, 0, len(desired))
+	for k, s := range desired {
+		if !s.srvTarget {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		id := syncHealthCheckPrefix + k
+		if !h.registered[k] {
+			err := h.agent.CheckRegister(&consulapi.AgentCheckRegistration{
+				ID:                id,
+				Name:              "NS1 sync of " + k,
+				ServiceID:         h.serviceID,
+				AgentServiceCheck: consulapi.AgentServiceCheck{TTL: h.ttl.String()},
+			})
+			if err != nil {
+				h.log.Error("cannot register sync health check", "service", k, "error", err.Error())
+				continue
+			}
+			h.registered[k] = true
+		}
+		status, output := consulapi.HealthPassing, "records are in sync"
+		if _, ok := pending[k]; ok {
+			status, output = consulapi.HealthWarning, "records have pending changes"
+		}
+		if err := h.agent.UpdateTTL(id, output, status); err != nil {
+			h.log.Error("cannot update sync health check", "service", k, "error", err.Error())
+		}
+	}
+	for k := range h.registered {
+		if _, ok := desired[k]; ok {
+			continue
+		}
+		if err := h.agent.CheckDeregister(syncHealthCheckPrefix + k); err != nil {
+			h.log.Error("cannot deregister sync health check", "service", k, "error", err.Error())
+			continue
+		}
+		delete(h.registered, k)
+	}
+}
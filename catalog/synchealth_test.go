@@ -0,0 +1,68 @@
+package catalog
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+// mockCheckAgent records the checks registered and their last status
+type mockCheckAgent struct {
+	checks   map[string]*consulapi.AgentCheckRegistration
+	statuses map[string]string
+	failing  bool
+}
+
+func (a *mockCheckAgent) CheckRegister(check *consulapi.AgentCheckRegistration) error {
+	if a.failing {
+		return errors.New("agent unavailable")
+	}
+	a.checks[check.ID] = check
+	return nil
+}
+
+func (a *mockCheckAgent) CheckDeregister(checkID string) error {
+	delete(a.checks, checkID)
+	delete(a.statuses, checkID)
+	return nil
+}
+
+func (a *mockCheckAgent) UpdateTTL(checkID, output, status string) error {
+	a.statuses[checkID] = status
+	return nil
+}
+
+func TestSyncHealthReport(t *testing.T) {
+	agent := &mockCheckAgent{checks: map[string]*consulapi.AgentCheckRegistration{}, statuses: map[string]string{}}
+	h := &syncHealth{log: hclog.NewNullLogger(), agent: agent, serviceID: "consul-ns1", ttl: time.Minute}
+
+	h.report(map[string]service{"web": {}, "api": {}}, map[string]service{"api": {}})
+	assert.Len(t, agent.checks, 2)
+	assert.Equal(t, "consul-ns1", agent.checks["ns1-sync:web"].ServiceID)
+	assert.Equal(t, "1m0s", agent.checks["ns1-sync:web"].TTL)
+	assert.Equal(t, map[string]string{
+		"ns1-sync:web": consulapi.HealthPassing,
+		"ns1-sync:api": consulapi.HealthWarning,
+	}, agent.statuses)
+
+	// the check of a service that is no longer desired is deregistered
+	h.report(map[string]service{"api": {}}, map[string]service{})
+	assert.Equal(t, map[string]string{"ns1-sync:api": consulapi.HealthPassing}, agent.statuses)
+	assert.Len(t, agent.checks, 1)
+	assert.Equal(t, map[string]bool{"api": true}, h.registered)
+}
+
+func TestSyncHealthReport_RegisterError(t *testing.T) {
+	agent := &mockCheckAgent{checks: map[string]*consulapi.AgentCheckRegistration{}, statuses: map[string]string{}, failing: true}
+	h := &syncHealth{log: hclog.NewNullLogger(), agent: agent, serviceID: "missing", ttl: time.Minute}
+	h.report(map[string]service{"web": {}}, nil)
+	assert.Empty(t, agent.statuses)
+	assert.Empty(t, h.registered, "registration is retried on the next report")
+
+	var disabled *syncHealth
+	disabled.report(map[string]service{"web": {}}, nil)
+}
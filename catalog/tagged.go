@@ -0,0 +1,92 @@
+package catalog
+
+import (
+	"net"
+	"sort"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// taggedAnswerNote returns the note of an answer published from a tagged address, naming its tag
+func taggedAnswerNote(tag string) string {
+	return "consul-ns1 tagged_address=" + tag
+}
+
+// taggedAnswers returns the tagged addresses of an instance published next to its IPv4 and IPv6 addresses,
+// keyed by address with the tag as value. The tagged addresses of the service take precedence over the ones
+// of its node. Addresses that aren't IPs, of a family that isn't published, or already published are skipped.
+func (c *consul) taggedAnswers(n *consulapi.CatalogService, v4, v6 string) map[string]string {
+	if len(c.taggedAddresses) == 0 {
+		return nil
+	}
+	answers := map[string]string{}
+	for _, tag := range c.taggedAddresses {
+		address := n.TaggedAddresses[tag]
+		if a, ok := n.ServiceTaggedAddresses[tag]; ok {
+			address = a.Address
+		}
+		ip := net.ParseIP(address)
+		if ip == nil {
+			continue
+		}
+		address = ip.String()
+		if address == v4 || address == v6 {
+			continue
+		}
+		if (ip.To4() != nil && !c.addressFamily.v4()) || (ip.To4() == nil && !c.addressFamily.v6()) {
+			continue
+		}
+		if _, ok := answers[address]; !ok {
+			answers[address] = tag
+		}
+	}
+	if len(answers) == 0 {
+		return nil
+	}
+	return answers
+}
+
+// tagged returns the sorted tagged IPv4 or IPv6 addresses of an instance
+func (n node) tagged(v4 bool) []string {
+	addresses := []string{}
+	for a := range n.taggedAnswers {
+		if ip := net.ParseIP(a); ip != nil && (ip.To4() != nil) == v4 {
+			addresses = append(addresses, a)
+		}
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// nodeTags returns the tag of each answer published from a tagged address in a map of nodes. Addresses that
+// are also the address of an instance aren't tagged.
+func nodeTags(nodes map[string]node) map[string]string {
+	tags := map[string]string{}
+	for _, n := range nodes {
+		for a, tag := range n.taggedAnswers {
+			tags[a] = tag
+		}
+	}
+	for _, n := range nodes {
+		delete(tags, n.aRecAnswer)
+		delete(tags, n.aaaaRecAnswer)
+	}
+	return tags
+}
+
+// setTags notes the tag of the answers of a record published from tagged addresses
+func setTags(rec *dns.Record, tags map[string]string) {
+	for _, a := range rec.Answers {
+		tag, ok := tags[strings.Join(a.Rdata, " ")]
+		if !ok {
+			continue
+		}
+		if a.Meta == nil {
+			a.Meta = &data.Meta{}
+		}
+		a.Meta.Note = taggedAnswerNote(tag)
+	}
+}
@@ -0,0 +1,64 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestTaggedAnswers(t *testing.T) {
+	table := map[string]struct {
+		family   addressFamily
+		node     map[string]string
+		service  map[string]consulapi.ServiceAddress
+		expected map[string]string
+	}{
+		"none": {ipv4Family, nil, nil, nil},
+		"node": {ipv4Family, map[string]string{"wan": "8.8.8.8"}, nil, map[string]string{"8.8.8.8": "wan"}},
+		"service precedence": {ipv4Family, map[string]string{"wan": "8.8.8.8"},
+			map[string]consulapi.ServiceAddress{"wan": {Address: "9.9.9.9"}}, map[string]string{"9.9.9.9": "wan"}},
+		"same as address": {ipv4Family, map[string]string{"wan": "1.1.1.1"}, nil, nil},
+		"not an IP":       {ipv4Family, map[string]string{"wan": "example.com"}, nil, nil},
+		"other family":    {ipv4Family, map[string]string{"wan_ipv6": "2001:db8::8"}, nil, nil},
+		"dual":            {dualFamily, map[string]string{"wan": "8.8.8.8", "wan_ipv6": "2001:db8::8"}, nil, map[string]string{"8.8.8.8": "wan", "2001:db8::8": "wan_ipv6"}},
+	}
+	for name, v := range table {
+		c := consul{log: hclog.NewNullLogger(), addressFamily: v.family, taggedAddresses: []string{"wan", "wan_ipv6"}}
+		n := &consulapi.CatalogService{Address: "1.1.1.1", TaggedAddresses: v.node, ServiceTaggedAddresses: v.service}
+		assert.Equal(t, v.expected, c.taggedAnswers(n, "1.1.1.1", ""), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCreate_TaggedAddresses(t *testing.T) {
+	n := testClient(nil)
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	c := consul{log: hclog.NewNullLogger(), addressFamily: ipv4Family, taggedAddresses: []string{"wan"}}
+	desired := c.transformNodes([]*consulapi.CatalogService{
+		{Node: "n1", ServiceID: "web", Address: "10.0.0.1", ServicePort: 80, TaggedAddresses: map[string]string{"wan": "8.8.8.8"}},
+		{Node: "n2", ServiceID: "web", Address: "10.0.0.2", ServicePort: 80},
+	})
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "8.8.8.8"}, aAnswers(desired))
+
+	assert.Equal(t, int32(1), n.create(map[string]service{"s1": {nodes: desired, unchanged: recordTypes{srvRec: true}}}))
+	assert.Len(t, records.records, 1)
+	rec := records.records[0]
+	assert.Equal(t, []string{"10.0.0.1", "10.0.0.2", "8.8.8.8"}, answerStrings(rec.Answers))
+	assert.Nil(t, rec.Answers[0].Meta.Note)
+	assert.Equal(t, "consul-ns1 tagged_address=wan", rec.Answers[2].Meta.Note)
+
+	// tagged addresses read back from the zone match the desired state
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"10.0.0.1", "10.0.0.2", "8.8.8.8"}, Type: "A", TTL: 10},
+			{Domain: "s1.test.zone", ID: "r2", ShortAns: []string{"1 1 80 10.0.0.1", "1 1 80 10.0.0.2"}, Type: "SRV", TTL: 10},
+		},
+	}
+	assert.True(t, nodesAreEqual(desired, n.transformZoneRecords(z)["s1"].nodes))
+}
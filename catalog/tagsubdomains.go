@@ -0,0 +1,46 @@
+package catalog
+
+import (
+	"sort"
+)
+
+// addTagSubdomains publishes the instances of each service carrying a tag as a service of its own, named
+// "<tag>.<service>" like Consul DNS does, next to the service holding all its instances, e.g. to split canary and
+// primary instances. Instances with several tags are published under each of them. The services are diffed,
+// written and removed like any other service and read back from NS1 as such. `tags` holds the tags of the
+// instances of each service, keyed by service and `instanceKey`.
+func (c *consul) addTagSubdomains(services map[string]service, tags map[string]map[string][]string) {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		s := services[k]
+		if s.cnameRecAnswer != "" || s.datacenter != "" || s.tag != "" {
+			continue
+		}
+		subdomains := map[string]map[string]node{}
+		labels := map[string]string{}
+		for key, n := range s.nodes {
+			for _, tag := range tags[k][key] {
+				name := srvTargetLabel(tag) + "." + k
+				if subdomains[name] == nil {
+					subdomains[name] = map[string]node{}
+				}
+				subdomains[name][key] = n
+				labels[name] = tag
+			}
+		}
+		for name, nodes := range subdomains {
+			if other, ok := services[name]; ok && other.tag == "" {
+				c.log.Warn("service name collides with the subdomain of a tag, the service is published",
+					"service", name, "tag", labels[name])
+				continue
+			}
+			d := c.subsetService(s, name, nodes)
+			d.tag = labels[name]
+			services[name] = d
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package catalog
+
+import (
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestAddTagSubdomains(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), ns1Prefix: "p-", dnsTTL: 10, addressFamily: ipv4Family}
+	instance := func(address string) node {
+		return node{host: "h-" + address, address: address, port: 80, aRecAnswer: address,
+			srvRecAnswers: map[int]srvAnswer{80: {priority: 1, weight: 1, port: 80, address: address}}}
+	}
+	services := map[string]service{
+		"web": {id: "web", name: "web", consulID: "web", nodes: map[string]node{
+			"h1/web": instance("1.1.1.1"),
+			"h2/web": instance("2.2.2.2"),
+			"h3/web": instance("3.3.3.3"),
+		}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+		"api":        {cnameRecAnswer: "ingress.example.com", ttls: recordTTLs{cnameRecTTL: 10}},
+		"db":         {nodes: map[string]node{"h1/db": instance("1.1.1.1")}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+		"primary.db": {nodes: map[string]node{"h4/db": instance("4.4.4.4")}, ttls: recordTTLs{aRecTTL: 10, srvRecTTL: 10}},
+	}
+	tags := map[string]map[string][]string{
+		"web": {"h1/web": {"primary", "v1"}, "h2/web": {"Canary_V2"}},
+		"api": {"h1/api": {"primary"}},
+		"db":  {"h1/db": {"primary"}},
+	}
+	c.addTagSubdomains(services, tags)
+
+	assert.Len(t, services, 7)
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2", "3.3.3.3"}, aAnswers(services["web"].nodes))
+	primary := services["primary.web"]
+	assert.Equal(t, "primary", primary.tag)
+	assert.Equal(t, "web", primary.consulID)
+	assert.Equal(t, []string{"1.1.1.1"}, aAnswers(primary.nodes))
+	assert.Equal(t, recordTTLs{aRecTTL: 10, srvRecTTL: 10}, primary.ttls)
+	assert.Equal(t, []string{"1.1.1.1"}, aAnswers(services["v1.web"].nodes))
+	assert.Equal(t, []string{"2.2.2.2"}, aAnswers(services["canary-v2.web"].nodes))
+	// services colliding with a subdomain are published as they are
+	assert.Equal(t, []string{"4.4.4.4"}, aAnswers(services["primary.db"].nodes))
+	assert.Equal(t, "", services["primary.db"].tag)
+
+	// the records read back from NS1 match the desired state
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, ns1Prefix: "p-", addressFamily: ipv4Family, log: hclog.NewNullLogger()}
+	for _, k := range []string{"web", "api", "db", "primary.db", "v1.web", "canary-v2.web"} {
+		delete(services, k)
+	}
+	z := &dns.Zone{Zone: "test.zone", Records: []*dns.ZoneRecord{
+		{Domain: "p-primary.web.test.zone", ID: "1", Type: "A", TTL: 10, ShortAns: []string{"1.1.1.1"}},
+		{Domain: "p-primary.web.test.zone", ID: "2", Type: "SRV", TTL: 10, ShortAns: []string{"1 1 80 1.1.1.1"}},
+	}}
+	actual := n.transformZoneRecords(z)
+	assert.Empty(t, onlyInFirst(services, actual))
+	assert.Empty(t, serviceOnlyInFirst(actual, services))
+}
@@ -0,0 +1,100 @@
+package catalog
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/go-hclog"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+// templateDomain returns the domain of the template record in a zone, names outside of the zone are relative to it
+func templateDomain(name, zone string) string {
+	name = strings.TrimSuffix(name, ".")
+	if name == zone || strings.HasSuffix(name, "."+zone) {
+		return name
+	}
+	return name + "." + zone
+}
+
+// recordTemplate clones the filters, meta and regions of a record of the zone, e.g. _template.example.com, onto
+// every record created, so zone owners manage the default routing of records in NS1. The template of a type of
+// record is the template record of the same type. A nil recordTemplate doesn't clone anything.
+type recordTemplate struct {
+	records recordService
+	log     hclog.Logger
+	limiter *apiLimiter
+	zone    string
+	domain  string
+
+	lock sync.Mutex
+	// fetched holds the template of each type of record fetched since the last reset, nil if there is none
+	fetched map[string]*dns.Record
+}
+
+// reset forgets the templates fetched, so edits of the template records apply to the records created next
+func (t *recordTemplate) reset() {
+	if t == nil {
+		return
+	}
+	t.lock.Lock()
+	t.fetched = nil
+	t.lock.Unlock()
+}
+
+// template returns the template of a type of record, nil if there is none or it can't be fetched
+func (t *recordTemplate) template(recType string) *dns.Record {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if tmpl, ok := t.fetched[recType]; ok {
+		return tmpl
+	}
+	t.limiter.read()
+	tmpl, _, err := t.records.Get(t.zone, t.domain, recType)
+	if err == ns1api.ErrRecordMissing {
+		t.log.Debug("no template record for type, creating records without template", "domain", t.domain, "type", recType)
+	} else if err != nil {
+		// not remembered, so the template is fetched again for the next record
+		t.log.Warn("cannot fetch template record, creating record without template", "domain", t.domain, "type", recType, "error", err.Error())
+		return nil
+	}
+	if t.fetched == nil {
+		t.fetched = map[string]*dns.Record{}
+	}
+	t.fetched[recType] = tmpl
+	return tmpl
+}
+
+// apply clones the filters, meta and regions of the template of its type onto a record being created
+func (t *recordTemplate) apply(rec *dns.Record) {
+	if t == nil {
+		return
+	}
+	tmpl := t.template(rec.Type)
+	if tmpl == nil {
+		return
+	}
+	rec.Filters = make([]*filter.Filter, 0, len(tmpl.Filters))
+	for _, f := range tmpl.Filters {
+		clone := *f
+		rec.Filters = append(rec.Filters, &clone)
+	}
+	if tmpl.Meta != nil {
+		meta := *tmpl.Meta
+		rec.Meta = &meta
+	}
+	if len(tmpl.Regions) > 0 {
+		rec.Regions = make(data.Regions, len(tmpl.Regions))
+		for name, region := range tmpl.Regions {
+			rec.Regions[name] = region
+		}
+	}
+}
+
+// isTemplate returns whether a domain is the domain of the template records, which are never managed
+func (t *recordTemplate) isTemplate(domain string) bool {
+	return t != nil && domain == t.domain
+}
@@ -0,0 +1,106 @@
+package catalog
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+// templateRecordService holds an A template record
+type templateRecordService struct {
+	mockRecordService
+	gets int
+	err  error
+}
+
+func (s *templateRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	s.gets++
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	if domain != "_template.test.zone" || t != "A" {
+		return nil, nil, ns1api.ErrRecordMissing
+	}
+	rec := dns.NewRecord(zone, domain, t)
+	rec.Filters = []*filter.Filter{filter.NewGeotargetCountry(), filter.NewSelFirstN(1)}
+	rec.Meta = &data.Meta{Note: "template"}
+	rec.Regions = data.Regions{"us": data.Region{Meta: data.Meta{Country: []string{"US"}}}}
+	return rec, nil, nil
+}
+
+func TestTemplateDomain(t *testing.T) {
+	table := map[string]string{
+		"_template":            "_template.test.zone",
+		"_template.test.zone":  "_template.test.zone",
+		"_template.test.zone.": "_template.test.zone",
+		"_template.other.zone": "_template.other.zone.test.zone",
+		"test.zone":            "test.zone",
+		"defaults._template":   "defaults._template.test.zone",
+	}
+	for name, expected := range table {
+		assert.Equal(t, expected, templateDomain(name, "test.zone"), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestCreate_Template(t *testing.T) {
+	n := testClient(nil)
+	records := &templateRecordService{mockRecordService: mockRecordService{mux: &sync.Mutex{}}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	n.template = &recordTemplate{records: records, log: hclog.NewNullLogger(), zone: "test.zone", domain: "_template.test.zone"}
+	n.weights = &answerWeights{}
+	desired := map[string]service{
+		"s1": {nodes: map[string]node{"n1/web": {aRecAnswer: "1.1.1.1", answerWeight: 2,
+			srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}}}},
+		"s2": {nodes: map[string]node{"n1/web": {aRecAnswer: "2.2.2.2",
+			srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2.2.2.2"}}}}},
+	}
+
+	assert.Equal(t, int32(4), n.create(desired))
+	// the template of each type is fetched once per cycle
+	assert.Equal(t, 2, records.gets)
+	for _, rec := range records.records {
+		if rec.Type == "SRV" {
+			assert.Empty(t, rec.Filters)
+			assert.Nil(t, rec.Meta.Note)
+			continue
+		}
+		assert.Equal(t, "template", rec.Meta.Note)
+		assert.Len(t, rec.Regions, 1)
+		types := []string{}
+		for _, f := range rec.Filters {
+			types = append(types, f.Type)
+		}
+		if rec.Domain == "s1.test.zone" {
+			assert.Equal(t, []string{"geotarget_country", "select_first_n", "weighted_shuffle"}, types)
+		} else {
+			assert.Equal(t, []string{"geotarget_country", "select_first_n"}, types)
+		}
+	}
+
+	// records are created without template when it can't be fetched
+	records.records, records.err = nil, errors.New("unavailable")
+	assert.Equal(t, int32(4), n.create(desired))
+	for _, rec := range records.records {
+		assert.Nil(t, rec.Meta.Note)
+		assert.Empty(t, rec.Regions)
+	}
+	assert.Equal(t, 2+4, records.gets)
+
+	// template records are not synced
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "_template.test.zone", ID: "t1", ShortAns: []string{"127.0.0.1"}, Type: "A", TTL: 10},
+		},
+	}
+	assert.Empty(t, n.transformZoneRecords(z))
+}
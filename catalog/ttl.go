@@ -0,0 +1,70 @@
+package catalog
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// maxTTLJitter is the largest TTL jitter in percent
+const maxTTLJitter = 50
+
+// ttlMetaKey is the service meta key overriding the TTL of the records of a service in seconds, e.g. "30"
+const ttlMetaKey = "ns1-ttl"
+
+// ttl returns the TTL of a record of a service, jittered when configured.
+// A non-zero `override` replaces the default TTL, see `serviceTTL`.
+func (c *consul) ttl(name, recType string, override int64) int64 {
+	ttl := c.dnsTTL
+	if override > 0 {
+		ttl = override
+	}
+	return jitterTTL(ttl, c.ttlJitter, c.ns1Prefix+name+" "+recType)
+}
+
+// serviceTTL returns the TTL declared by the meta of the instances of a service, or else their ttl directive, or 0
+// if none does. Instances are expected to agree, if they don't the lowest TTL is used.
+func (c *consul) serviceTTL(name string, cnodes []*consulapi.CatalogService) int64 {
+	var ttl int64
+	for _, n := range cnodes {
+		v, ok := n.ServiceMeta[ttlMetaKey]
+		if !ok {
+			t, ok := c.directiveInt(name, n.Node, n.ServiceTags, ttlDirective, math.MaxInt32)
+			if !ok {
+				continue
+			}
+			v = strconv.FormatInt(t, 10)
+		}
+		t, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || t <= 0 {
+			c.log.Warn("invalid TTL in service meta, ignoring", "service", name, "node", n.Node, "ttl", v)
+			continue
+		}
+		if ttl != 0 && t != ttl {
+			c.log.Warn("instances declare different TTLs, using the lowest", "service", name, "node", n.Node)
+		}
+		if ttl == 0 || t < ttl {
+			ttl = t
+		}
+	}
+	return ttl
+}
+
+// jitterTTL deviates a TTL by up to `percent` percent in either direction, so records don't expire in lockstep
+// across resolvers. The deviation is derived from the key of the record, so a record always gets the same TTL
+// and its diffs don't flap. The result is never below 1.
+func jitterTTL(ttl int64, percent int, key string) int64 {
+	spread := ttl * int64(percent) / 100
+	if spread <= 0 {
+		return ttl
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	jittered := ttl - spread + int64(h.Sum32())%(2*spread+1)
+	if jittered < 1 {
+		return 1
+	}
+	return jittered
+}
@@ -0,0 +1,82 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitterTTL(t *testing.T) {
+	type variant struct {
+		ttl      int64
+		percent  int
+		min, max int64
+	}
+	table := map[string]variant{
+		"disabled":        {ttl: 60, percent: 0, min: 60, max: 60},
+		"spread below 1s": {ttl: 5, percent: 10, min: 5, max: 5},
+		"10 percent":      {ttl: 60, percent: 10, min: 54, max: 66},
+		"50 percent":      {ttl: 300, percent: 50, min: 150, max: 450},
+		"never below 1":   {ttl: 2, percent: 50, min: 1, max: 3},
+	}
+	for name, v := range table {
+		seen := map[int64]bool{}
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("service-%d A", i)
+			ttl := jitterTTL(v.ttl, v.percent, key)
+			assert.True(t, ttl >= v.min && ttl <= v.max, fmt.Sprintf("Test case: %s, ttl %d out of range", name, ttl))
+			assert.Equal(t, ttl, jitterTTL(v.ttl, v.percent, key), fmt.Sprintf("Test case: %s, ttl must be stable", name))
+			seen[ttl] = true
+		}
+		if v.min != v.max {
+			assert.True(t, len(seen) > 1, fmt.Sprintf("Test case: %s, ttls must be spread", name))
+		}
+	}
+}
+
+func TestConsulTTL(t *testing.T) {
+	c := consul{dnsTTL: 60, ns1Prefix: "p-"}
+	assert.Equal(t, int64(60), c.ttl("web", "A", 0))
+	assert.Equal(t, int64(30), c.ttl("web", "A", 30))
+	c.ttlJitter = 20
+	assert.Equal(t, jitterTTL(60, 20, "p-web A"), c.ttl("web", "A", 0))
+	assert.Equal(t, jitterTTL(30, 20, "p-web A"), c.ttl("web", "A", 30))
+}
+
+func TestServiceTTL(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	table := map[string]struct {
+		cnodes   []*consulapi.CatalogService
+		expected int64
+	}{
+		"none": {[]*consulapi.CatalogService{{Node: "n1"}}, 0},
+		"meta": {[]*consulapi.CatalogService{
+			{Node: "n1"},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-ttl": "30"}},
+		}, 30},
+		"invalid": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-ttl": "-1"}},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-ttl": "soon"}},
+		}, 0},
+		"different": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceMeta: map[string]string{"ns1-ttl": "60"}},
+			{Node: "n2", ServiceMeta: map[string]string{"ns1-ttl": "30"}},
+		}, 30},
+		"directive": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceTags: []string{"primary", "ns1:ttl=45"}},
+			{Node: "n2"},
+		}, 45},
+		"meta and directive": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceTags: []string{"ns1:ttl=45"}, ServiceMeta: map[string]string{"ns1-ttl": "90"}},
+		}, 90},
+		"invalid directive": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceTags: []string{"ns1:ttl=soon"}},
+		}, 0},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, c.serviceTTL("web", v.cnodes), fmt.Sprintf("Test case: %s", name))
+	}
+}
@@ -0,0 +1,83 @@
+package catalog
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nsone/consul-ns1/diff"
+)
+
+// ttlPassInterval is the pause between two records updated by the TTL pass, so it doesn't burst the NS1 API
+var ttlPassInterval = 100 * time.Millisecond
+
+// updateTTLs updates the TTL of the records of the services whose records only differ from NS1 by TTL, e.g.
+// after -ns1-dns-ttl changed between runs, without rewriting their answers: each record is fetched and written
+// back as is with the new TTL, one at a time. It returns the services left to upsert, i.e. the ones with other
+// changes and the ones whose TTLs couldn't be updated.
+func (n *ns1) updateTTLs(upsert, desired, actual map[string]service) map[string]service {
+	names := make([]string, 0, len(upsert))
+	for k := range upsert {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	left := map[string]service{}
+	services, records := 0, 0
+	for _, k := range names {
+		left[k] = upsert[k]
+		a, ok := actual[k]
+		if !ok {
+			continue
+		}
+		de, ae := compareEntries(desired[k], a)
+		families, ok := diff.TTLOnly(de, ae)
+		if !ok || len(families) == 0 {
+			continue
+		}
+		updated := 0
+		for _, f := range families {
+			if ae[f].ID == "" {
+				break
+			}
+			if records > 0 {
+				<-n.clock.After(ttlPassInterval)
+			}
+			if !n.updateTTL(ae[f].ID, n.ns1Prefix+k, string(f), de[f].TTL) {
+				break
+			}
+			updated++
+			records++
+		}
+		if updated == len(families) {
+			delete(left, k)
+			services++
+		}
+	}
+	if records > 0 {
+		n.log.Info("only TTLs changed since the last run, updated TTLs without rewriting answers",
+			"services", fmt.Sprintf("%d", services), "records", fmt.Sprintf("%d", records))
+	}
+	return left
+}
+
+// updateTTL writes a record back to NS1 as is with a new TTL, and reports whether it succeeded
+func (n *ns1) updateTTL(id, name, recType string, ttl int64) bool {
+	if n.writesPaused() {
+		return false
+	}
+	domain := name + "." + n.serviceZone.name
+	n.limiter.read()
+	rec, _, err := n.client.Records.Get(n.serviceZone.name, domain, recType)
+	if err != nil || rec == nil {
+		n.log.Error("cannot fetch record to update its TTL", "domain", domain, "type", recType, "error", fmt.Sprintf("%v", err))
+		return false
+	}
+	rec.TTL = int(ttl)
+	if err := n.upsertRecord(id, rec); err != nil {
+		n.log.Error("cannot update TTL of record", "domain", domain, "type", recType, "error", err.Error())
+		return false
+	}
+	n.drift.wrote(rec.Domain, rec.Type, writtenState(rec), n.clock.Now())
+	return true
+}
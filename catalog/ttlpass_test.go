@@ -0,0 +1,54 @@
+package catalog
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// storedRecordService returns records with answers as stored in NS1
+type storedRecordService struct {
+	mockRecordService
+}
+
+func (s *storedRecordService) Get(zone, domain, t string) (*dns.Record, *http.Response, error) {
+	rec := dns.NewRecord(zone, domain, t)
+	rec.TTL = 30
+	rec.AddAnswer(dns.NewAv4Answer("1.1.1.1"))
+	return rec, nil, nil
+}
+
+func TestUpdateTTLs(t *testing.T) {
+	defer func(interval time.Duration) { ttlPassInterval = interval }(ttlPassInterval)
+	ttlPassInterval = 0
+	n := testClient(nil)
+	records := &storedRecordService{mockRecordService{mux: &sync.Mutex{}}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+
+	nodes := map[string]node{"h1": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{}}}
+	actual := map[string]service{
+		"ttl":     {nodes: nodes, ttls: recordTTLs{aRecTTL: 30}, ns1IDs: recordIDs{aRecID: "a1"}},
+		"answers": {nodes: nodes, ttls: recordTTLs{aRecTTL: 30}, ns1IDs: recordIDs{aRecID: "a2"}},
+	}
+	desired := map[string]service{
+		"ttl":     {nodes: nodes, ttls: recordTTLs{aRecTTL: 60}},
+		"answers": {nodes: map[string]node{"h2": {aRecAnswer: "2.2.2.2"}}, ttls: recordTTLs{aRecTTL: 60}},
+		"new":     {nodes: nodes, ttls: recordTTLs{aRecTTL: 60}},
+	}
+	upsert := onlyInFirst(desired, actual)
+
+	left := n.updateTTLs(upsert, desired, actual)
+	assert.Contains(t, left, "answers")
+	assert.Contains(t, left, "new")
+	assert.NotContains(t, left, "ttl")
+
+	assert.Len(t, records.records, 1)
+	rec := records.records[0]
+	assert.Equal(t, "ttl.test.zone", rec.Domain)
+	assert.Equal(t, 60, rec.TTL)
+	assert.Equal(t, []string{"1.1.1.1"}, rec.Answers[0].Rdata, "answers are written back as stored")
+}
@@ -0,0 +1,147 @@
+package catalog
+
+import (
+	"strings"
+	"sync"
+
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+// upFilter is the NS1 filter leaving answers whose up meta is false out of responses
+const upFilter = "up"
+
+// markDown marks the instances of a service failing their checks down, keyed by `instanceKey`, instead of
+// leaving them out like `passingInstances` does
+func (c *consul) markDown(name string, nodes map[string]node, healths map[string]health) map[string]node {
+	for key, n := range nodes {
+		h, ok := healths[key]
+		n.down = ok && c.failing(h)
+		if n.down {
+			c.log.Debug("instance not passing its checks, marking down", "service", name, "instance", key, "health", string(h))
+		}
+		nodes[key] = n
+	}
+	return nodes
+}
+
+// downAnswers returns the answers selected by `answers` from a map of nodes whose instances are all down.
+// An answer shared with an instance that is up is up.
+func downAnswers(nodes map[string]node, answers func(node) []string) map[string]bool {
+	down := map[string]bool{}
+	for _, n := range nodes {
+		for _, a := range answers(n) {
+			if a == "" {
+				continue
+			}
+			if v, ok := down[a]; !ok || v {
+				down[a] = n.down
+			}
+		}
+	}
+	for a, v := range down {
+		if !v {
+			delete(down, a)
+		}
+	}
+	return down
+}
+
+// recordAnswers returns the sorted, de-duplicated answers selected by `selector` from a map of nodes, with
+// their weight, whether they're down, their location and their region, so a change of any updates their record
+func recordAnswers(nodes map[string]node, selector func(node) []string) []string {
+	answers := weightedAnswers(nodes, selector)
+	down := downAnswers(nodes, selector)
+	geos := nodeGeos(nodes, selector)
+	regions := nodeRegions(nodes, selector)
+	versions := nodeVersions(nodes, selector)
+	for i, a := range answers {
+		address := strings.Fields(a)[0]
+		if down[address] {
+			answers[i] += " up=false"
+		}
+		if g, ok := geos[address]; ok {
+			answers[i] += " " + g.String()
+		}
+		if region, ok := regions[address]; ok {
+			answers[i] += " region=" + region
+		}
+		if version, ok := versions[address]; ok {
+			answers[i] += " version=" + version
+		}
+	}
+	return answers
+}
+
+// setUp sets the up meta of every answer of a record, false for the `down` answers, and appends the up filter
+// to its filter chain, unless it's already there
+func setUp(rec *dns.Record, down map[string]bool) {
+	for _, a := range rec.Answers {
+		if a.Meta == nil {
+			a.Meta = &data.Meta{}
+		}
+		a.Meta.Up = !down[strings.Join(a.Rdata, " ")]
+	}
+	appendUpFilter(rec)
+}
+
+// appendUpFilter appends the up filter to the filter chain of a record, unless it's already there
+func appendUpFilter(rec *dns.Record) {
+	for _, f := range rec.Filters {
+		if f.Type == upFilter {
+			return
+		}
+	}
+	rec.Filters = append(rec.Filters, filter.NewUp())
+}
+
+// answerStates remembers the answers written down to NS1, whose up meta isn't part of the zone records
+// answers are read back from
+type answerStates struct {
+	lock sync.Mutex
+	// down holds the answers written down, keyed by `recordKey`
+	down map[string]map[string]bool
+}
+
+// wrote remembers the answers of a record written down to NS1
+func (s *answerStates) wrote(rec *dns.Record) {
+	if s == nil {
+		return
+	}
+	down := map[string]bool{}
+	for _, a := range rec.Answers {
+		if a.Meta == nil {
+			continue
+		}
+		if v, ok := a.Meta.Up.(bool); ok && !v {
+			down[strings.Join(a.Rdata, " ")] = true
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.down == nil {
+		s.down = map[string]map[string]bool{}
+	}
+	s.down[recordKey(rec.Domain, rec.Type)] = down
+}
+
+// deleted forgets the answers of a deleted record
+func (s *answerStates) deleted(domain, recType string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	delete(s.down, recordKey(domain, recType))
+	s.lock.Unlock()
+}
+
+// isDown returns whether an answer of a record was last written down
+func (s *answerStates) isDown(domain, recType, answer string) bool {
+	if s == nil {
+		return false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.down[recordKey(domain, recType)][answer]
+}
@@ -0,0 +1,85 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/diff"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestMarkDown(t *testing.T) {
+	table := map[string]struct {
+		policy   warningPolicy
+		expected map[string]bool
+	}{
+		"exclude warning": {excludeWarning, map[string]bool{"n1/web": false, "n2/web": true, "n3/web": true, "n4/web": false}},
+		"publish warning": {publishWarning, map[string]bool{"n1/web": false, "n2/web": false, "n3/web": true, "n4/web": false}},
+	}
+	for name, v := range table {
+		c := consul{log: hclog.NewNullLogger(), warningPolicy: v.policy}
+		nodes := map[string]node{
+			"n1/web": {aRecAnswer: "1.1.1.1"},
+			"n2/web": {aRecAnswer: "2.2.2.2"},
+			"n3/web": {aRecAnswer: "3.3.3.3"},
+			"n4/web": {aRecAnswer: "4.4.4.4"},
+		}
+		healths := map[string]health{"n1/web": passing, "n2/web": "warning", "n3/web": critical}
+		down := map[string]bool{}
+		for key, n := range c.markDown("web", nodes, healths) {
+			down[key] = n.down
+		}
+		assert.Equal(t, v.expected, down, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestRecordAnswers_Down(t *testing.T) {
+	nodes := map[string]node{
+		"n1/web":  {aRecAnswer: "1.1.1.1", down: true},
+		"n1/web2": {aRecAnswer: "1.1.1.1"},
+		"n2/web":  {aRecAnswer: "2.2.2.2", down: true, answerWeight: 3},
+		"n3/web":  {aRecAnswer: "3.3.3.3"},
+	}
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2 weight=3 up=false", "3.3.3.3"}, recordAnswers(nodes, node.v4Answers))
+}
+
+func TestCreate_UpFilter(t *testing.T) {
+	n := testClient(nil)
+	n.states = &answerStates{}
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]node{
+		"n1/web": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}},
+		"n2/web": {aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2.2.2.2"}}, down: true},
+	}
+
+	assert.Equal(t, int32(2), n.create(map[string]service{"s1": {nodes: desired}}))
+	assert.Len(t, records.records, 2)
+	for _, rec := range records.records {
+		assert.Len(t, rec.Answers, 2)
+		assert.Equal(t, true, rec.Answers[0].Meta.Up, rec.Type)
+		assert.Equal(t, false, rec.Answers[1].Meta.Up, rec.Type)
+		assert.Len(t, rec.Filters, 1)
+		assert.Equal(t, "up", rec.Filters[0].Type)
+	}
+
+	// the answers written down are part of the service read back from the zone
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1", "2.2.2.2"}, Type: "A", TTL: 10},
+			{Domain: "s1.test.zone", ID: "r2", ShortAns: []string{"1 1 80 1.1.1.1", "1 1 80 2.2.2.2"}, Type: "SRV", TTL: 10},
+		},
+	}
+	actual := n.transformZoneRecords(z)["s1"].nodes
+	for _, family := range []diff.Family{diff.A, diff.SRV} {
+		assert.Equal(t, service{nodes: desired}.entry()[family].Answers, service{nodes: actual}.entry()[family].Answers)
+	}
+
+	// an instance passing its checks again updates the records
+	desired["n2/web"] = node{aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2.2.2.2"}}}
+	assert.False(t, nodesAreEqual(desired, actual))
+}
@@ -0,0 +1,155 @@
+package catalog
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// Resolver looks up published records through DNS, it is implemented by *net.Resolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupCNAME(ctx context.Context, host string) (string, error)
+}
+
+// VerifyResult compares the answers of a published record as resolved through DNS with the desired state
+type VerifyResult struct {
+	// Name is the fully qualified name of the record
+	Name string
+	// Type is the record type, e.g. "A"
+	Type string
+	// Missing holds the desired answers that weren't resolved
+	Missing []string
+	// Unexpected holds the resolved answers that aren't desired
+	Unexpected []string
+	// Err is set when the record couldn't be resolved
+	Err error
+}
+
+// OK reports whether the resolved answers match the desired state
+func (r VerifyResult) OK() bool {
+	return r.Err == nil && len(r.Missing) == 0 && len(r.Unexpected) == 0
+}
+
+// Verify fetches the services from Consul once and resolves the records they are published in
+// through DNS, comparing the answers with the desired state. Results are sorted by name and type.
+func Verify(ctx context.Context, cfg Config, consulClient *consulapi.Client, resolver Resolver) ([]VerifyResult, error) {
+	consul, err := fetchOnce(cfg, consulClient)
+	if err != nil {
+		return nil, err
+	}
+	return verifyServices(ctx, consul.getServices(), cfg.NS1Prefix, cfg.NS1Domain, consul.addressFamily, resolver), nil
+}
+
+// fetchOnce fetches the services from Consul once, transformed as configured for syncing
+func fetchOnce(cfg Config, consulClient *consulapi.Client) (*consul, error) {
+	consul, err := newConsul(cfg, consulClient)
+	if err != nil {
+		return nil, err
+	}
+	// answers aren't connected to feeds or marked down when fetching once, so the instances that would be
+	// served are the passing ones
+	consul.onlyPassing, consul.upFilter = cfg.OnlyPassing || cfg.UpFilter || cfg.UpFeeds, false
+	if _, err := consul.fetch(0); err != nil {
+		return nil, err
+	}
+	return consul, nil
+}
+
+// verifyServices resolves the records of each service and compares them with its desired answers
+func verifyServices(ctx context.Context, services map[string]service, prefix, domain string, family addressFamily, resolver Resolver) []VerifyResult {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	results := []VerifyResult{}
+	for _, k := range names {
+		s := services[k]
+		name := prefix + k + "." + domain
+		if s.cnameRecAnswer != "" {
+			cname, err := resolver.LookupCNAME(ctx, name)
+			resolved := []string{}
+			if isNotFound(err) {
+				err = nil
+			} else if err == nil {
+				resolved = append(resolved, strings.TrimSuffix(cname, "."))
+			}
+			results = append(results, compareAnswers(name, "CNAME", []string{s.cnameRecAnswer}, resolved, err))
+			continue
+		}
+		ips, ipErr := resolver.LookupIPAddr(ctx, name)
+		if isNotFound(ipErr) {
+			ipErr = nil
+		}
+		var v4, v6 []string
+		for _, ip := range ips {
+			if ip.IP.To4() != nil {
+				v4 = append(v4, ip.IP.String())
+			} else {
+				v6 = append(v6, ip.IP.String())
+			}
+		}
+		if family.v4() {
+			results = append(results, compareAnswers(name, "A", aAnswers(s.nodes), v4, ipErr))
+		}
+		if family.v6() {
+			results = append(results, compareAnswers(name, "AAAA", aaaaAnswers(s.nodes), v6, ipErr))
+		}
+
+		desired := []string{}
+		for _, a := range srvAnswers(s.nodes) {
+			desired = append(desired, a.String())
+		}
+		_, srvs, srvErr := resolver.LookupSRV(ctx, "", "", name)
+		if isNotFound(srvErr) {
+			srvErr = nil
+		}
+		resolved := []string{}
+		for _, srv := range srvs {
+			resolved = append(resolved, fmt.Sprintf("%d %d %d %s", srv.Priority, srv.Weight, srv.Port, strings.TrimSuffix(srv.Target, ".")))
+		}
+		results = append(results, compareAnswers(name, "SRV", desired, resolved, srvErr))
+	}
+	return results
+}
+
+// compareAnswers returns the differences between desired and resolved answers of a record
+func compareAnswers(name, recType string, desired, resolved []string, err error) VerifyResult {
+	r := VerifyResult{Name: name, Type: recType, Err: err}
+	if err != nil {
+		return r
+	}
+	desiredSet, resolvedSet := map[string]bool{}, map[string]bool{}
+	for _, a := range desired {
+		desiredSet[a] = true
+	}
+	for _, a := range resolved {
+		resolvedSet[a] = true
+		if !desiredSet[a] {
+			r.Unexpected = append(r.Unexpected, a)
+		}
+	}
+	for _, a := range desired {
+		if !resolvedSet[a] {
+			r.Missing = append(r.Missing, a)
+		}
+	}
+	sort.Strings(r.Missing)
+	sort.Strings(r.Unexpected)
+	return r
+}
+
+// isNotFound reports whether a lookup failed because the name doesn't exist or has no records of the type
+func isNotFound(err error) bool {
+	if dnsErr, ok := err.(*net.DNSError); ok {
+		return dnsErr.Err == "no such host"
+	}
+	return false
+}
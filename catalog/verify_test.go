@@ -0,0 +1,107 @@
+package catalog
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockResolver fulfils the Resolver interface with static answers keyed by name
+type mockResolver struct {
+	ips    map[string][]net.IPAddr
+	srvs   map[string][]*net.SRV
+	cnames map[string]string
+	err    error
+}
+
+func (r *mockResolver) LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error) {
+	if r.err != nil {
+		return nil, r.err
+	}
+	if ips, ok := r.ips[host]; ok {
+		return ips, nil
+	}
+	return nil, &net.DNSError{Err: "no such host", Name: host}
+}
+
+func (r *mockResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	if r.err != nil {
+		return "", nil, r.err
+	}
+	if srvs, ok := r.srvs[name]; ok {
+		return name, srvs, nil
+	}
+	return "", nil, &net.DNSError{Err: "no such host", Name: name}
+}
+
+func (r *mockResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	if r.err != nil {
+		return "", r.err
+	}
+	if cname, ok := r.cnames[host]; ok {
+		return cname, nil
+	}
+	return "", &net.DNSError{Err: "no such host", Name: host}
+}
+
+func TestVerifyServices(t *testing.T) {
+	services := map[string]service{
+		"s1": {nodes: map[string]node{
+			"n1/s1": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {priority: 1, weight: 1, port: 80, address: "1.1.1.1"}}},
+			"n2/s1": {aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {priority: 1, weight: 1, port: 80, address: "2.2.2.2"}}},
+		}},
+		"s2": {},
+	}
+	resolver := &mockResolver{
+		ips: map[string][]net.IPAddr{
+			"p-s1.test.zone": {{IP: net.ParseIP("1.1.1.1")}, {IP: net.ParseIP("3.3.3.3")}, {IP: net.ParseIP("2001:db8::1")}},
+		},
+		srvs: map[string][]*net.SRV{
+			"p-s1.test.zone": {{Target: "1.1.1.1.", Port: 80, Priority: 1, Weight: 1}, {Target: "2.2.2.2.", Port: 80, Priority: 1, Weight: 1}},
+		},
+	}
+	expected := []VerifyResult{
+		{Name: "p-s1.test.zone", Type: "A", Missing: []string{"2.2.2.2"}, Unexpected: []string{"3.3.3.3"}},
+		{Name: "p-s1.test.zone", Type: "SRV"},
+		{Name: "p-s2.test.zone", Type: "A"},
+		{Name: "p-s2.test.zone", Type: "SRV"},
+	}
+	actual := verifyServices(context.Background(), services, "p-", "test.zone", ipv4Family, resolver)
+	assert.Equal(t, expected, actual)
+	assert.False(t, actual[0].OK())
+	assert.True(t, actual[1].OK())
+
+	expected = []VerifyResult{
+		{Name: "p-s1.test.zone", Type: "AAAA", Unexpected: []string{"2001:db8::1"}},
+		{Name: "p-s1.test.zone", Type: "SRV"},
+		{Name: "p-s2.test.zone", Type: "AAAA"},
+		{Name: "p-s2.test.zone", Type: "SRV"},
+	}
+	assert.Equal(t, expected, verifyServices(context.Background(), services, "p-", "test.zone", ipv6Family, resolver))
+}
+
+func TestVerifyServices_ResolverError(t *testing.T) {
+	err := errors.New("i/o timeout")
+	services := map[string]service{"s1": {}}
+	actual := verifyServices(context.Background(), services, "", "test.zone", ipv4Family, &mockResolver{err: err})
+	assert.Equal(t, []VerifyResult{
+		{Name: "s1.test.zone", Type: "A", Err: err},
+		{Name: "s1.test.zone", Type: "SRV", Err: err},
+	}, actual)
+	assert.False(t, actual[0].OK())
+}
+
+func TestVerifyServices_VirtualHost(t *testing.T) {
+	services := map[string]service{
+		"s1": {cnameRecAnswer: "ingress.example.com"},
+		"s2": {cnameRecAnswer: "ingress.example.com"},
+	}
+	resolver := &mockResolver{cnames: map[string]string{"s1.test.zone": "ingress.example.com."}}
+	assert.Equal(t, []VerifyResult{
+		{Name: "s1.test.zone", Type: "CNAME"},
+		{Name: "s2.test.zone", Type: "CNAME", Missing: []string{"ingress.example.com"}},
+	}, verifyServices(context.Background(), services, "", "test.zone", ipv4Family, resolver))
+}
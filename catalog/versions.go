@@ -0,0 +1,143 @@
+package catalog
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// versionMetaKey is the service meta key holding the version of an instance, it takes precedence over its tags
+const versionMetaKey = "version"
+
+// versionTag matches the tags naming the version of an instance, e.g. "v1.2.3" or "2.0.1-rc1"
+var versionTag = regexp.MustCompile(`^v?[0-9]+(\.[0-9]+){1,2}([-+][0-9A-Za-z.+-]+)?$`)
+
+// versionNote returns the note of an answer published with a version, keeping the note consul-ns1 set on it for
+// another purpose, e.g. `taggedAnswerNote`
+func versionNote(note interface{}, version string) string {
+	s, _ := note.(string)
+	if !strings.HasPrefix(s, "consul-ns1 ") {
+		s = "consul-ns1"
+	}
+	return s + " version=" + version
+}
+
+// noteVersion returns the version in the note of an answer, empty if there is none
+func noteVersion(note interface{}) string {
+	s, _ := note.(string)
+	for _, f := range strings.Fields(s) {
+		if strings.HasPrefix(f, versionMetaKey+"=") {
+			return strings.TrimPrefix(f, versionMetaKey+"=")
+		}
+	}
+	return ""
+}
+
+// instanceVersion returns the version of an instance, taken from its meta or the first of its tags naming a
+// version, empty if it has none. Versions with spaces are ignored.
+func (c *consul) instanceVersion(n *consulapi.CatalogService) string {
+	if v, ok := n.ServiceMeta[versionMetaKey]; ok {
+		if v != "" && !strings.ContainsAny(v, " \t\n") {
+			return v
+		}
+		c.log.Warn("invalid version in service meta, ignoring", "service", n.ServiceName, "node", n.Node, "value", v)
+	}
+	for _, tag := range n.ServiceTags {
+		if versionTag.MatchString(tag) {
+			return tag
+		}
+	}
+	return ""
+}
+
+// nodeVersions returns the version of each answer selected by `answers` from a map of nodes. Instances sharing an
+// address share the version of the first of them, by instance key, that has one.
+func nodeVersions(nodes map[string]node, answers func(node) []string) map[string]string {
+	keys := make([]string, 0, len(nodes))
+	for k := range nodes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	versions := map[string]string{}
+	for _, k := range keys {
+		n := nodes[k]
+		if n.version == "" {
+			continue
+		}
+		for _, a := range answers(n) {
+			if _, ok := versions[a]; !ok && a != "" {
+				versions[a] = n.version
+			}
+		}
+	}
+	return versions
+}
+
+// setVersions notes the version of the answers of a record. Records without versioned answers are left alone.
+func setVersions(rec *dns.Record, versions map[string]string) {
+	for _, a := range rec.Answers {
+		v, ok := versions[strings.Join(a.Rdata, " ")]
+		if !ok {
+			continue
+		}
+		if a.Meta == nil {
+			a.Meta = &data.Meta{}
+		}
+		a.Meta.Note = versionNote(a.Meta.Note, v)
+	}
+}
+
+// answerVersions remembers the versions of the answers written to NS1, which aren't part of the zone records
+// answers are read back from
+type answerVersions struct {
+	lock sync.Mutex
+	// versions holds the version of each answer, keyed by `recordKey` and the answer
+	versions map[string]map[string]string
+}
+
+// wrote remembers the versions of the answers of a record written to NS1
+func (v *answerVersions) wrote(rec *dns.Record) {
+	if v == nil {
+		return
+	}
+	versions := map[string]string{}
+	for _, a := range rec.Answers {
+		if a.Meta == nil {
+			continue
+		}
+		if version := noteVersion(a.Meta.Note); version != "" {
+			versions[strings.Join(a.Rdata, " ")] = version
+		}
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if v.versions == nil {
+		v.versions = map[string]map[string]string{}
+	}
+	v.versions[recordKey(rec.Domain, rec.Type)] = versions
+}
+
+// deleted forgets the versions of the answers of a deleted record
+func (v *answerVersions) deleted(domain, recType string) {
+	if v == nil {
+		return
+	}
+	v.lock.Lock()
+	delete(v.versions, recordKey(domain, recType))
+	v.lock.Unlock()
+}
+
+// version returns the version last written for an answer of a record, empty if unknown
+func (v *answerVersions) version(domain, recType, answer string) string {
+	if v == nil {
+		return ""
+	}
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.versions[recordKey(domain, recType)][answer]
+}
@@ -0,0 +1,91 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/diff"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestInstanceVersion(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	table := map[string]struct {
+		meta     map[string]string
+		tags     []string
+		expected string
+	}{
+		"none":         {nil, []string{"primary", "http"}, ""},
+		"tag":          {nil, []string{"primary", "v1.2.3"}, "v1.2.3"},
+		"bare tag":     {nil, []string{"2.0"}, "2.0"},
+		"pre-release":  {nil, []string{"v2.0.1-rc1"}, "v2.0.1-rc1"},
+		"first tag":    {nil, []string{"v1.2.3", "v1.2.4"}, "v1.2.3"},
+		"not version":  {nil, []string{"v1", "1.2.3.4", "v1.x"}, ""},
+		"meta":         {map[string]string{"version": "2019-10-01"}, []string{"v1.2.3"}, "2019-10-01"},
+		"invalid meta": {map[string]string{"version": "1.2 beta"}, []string{"v1.2.3"}, "v1.2.3"},
+	}
+	for name, v := range table {
+		n := &consulapi.CatalogService{Node: "n1", ServiceName: "web", ServiceMeta: v.meta, ServiceTags: v.tags}
+		assert.Equal(t, v.expected, c.instanceVersion(n), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestVersionNote(t *testing.T) {
+	assert.Equal(t, "consul-ns1 version=v1.2.3", versionNote(nil, "v1.2.3"))
+	assert.Equal(t, "consul-ns1 tagged_address=wan version=v1.2.3", versionNote(taggedAnswerNote("wan"), "v1.2.3"))
+	assert.Equal(t, "consul-ns1 version=v1.2.3", versionNote("set by hand", "v1.2.3"))
+	assert.Equal(t, "v1.2.3", noteVersion("consul-ns1 tagged_address=wan version=v1.2.3"))
+	assert.Equal(t, "", noteVersion(taggedAnswerNote("wan")))
+}
+
+func TestCreate_Versions(t *testing.T) {
+	n := testClient(nil)
+	n.versions = &answerVersions{}
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	desired := map[string]node{
+		"n1/web": {aRecAnswer: "1.1.1.1", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "1.1.1.1"}}, version: "v1.2.3"},
+		"n2/web": {aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "2.2.2.2"}},
+			taggedAnswers: map[string]string{"3.3.3.3": "wan"}, version: "v1.2.4"},
+		"n3/web": {aRecAnswer: "4.4.4.4", srvRecAnswers: map[int]srvAnswer{80: {1, 1, 80, "4.4.4.4"}}},
+	}
+
+	assert.Equal(t, int32(2), n.create(map[string]service{"s1": {nodes: desired}}))
+	for _, rec := range records.records {
+		if rec.Type != "A" {
+			continue
+		}
+		notes := map[string]interface{}{}
+		for _, a := range rec.Answers {
+			notes[a.Rdata[0]] = a.Meta.Note
+		}
+		assert.Equal(t, map[string]interface{}{
+			"1.1.1.1": "consul-ns1 version=v1.2.3",
+			"2.2.2.2": "consul-ns1 version=v1.2.4",
+			"3.3.3.3": "consul-ns1 tagged_address=wan version=v1.2.4",
+			"4.4.4.4": nil,
+		}, notes)
+	}
+
+	// the versions written are part of the service read back from the zone, so rollouts rewrite the notes
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1", "2.2.2.2", "3.3.3.3", "4.4.4.4"}, Type: "A", TTL: 10},
+		},
+	}
+	actual := n.transformZoneRecords(z)["s1"].nodes
+	assert.Equal(t, service{nodes: desired}.entry()[diff.A].Answers, service{nodes: actual}.entry()[diff.A].Answers)
+	upgraded := desired["n1/web"]
+	upgraded.version = "v1.3.0"
+	desired["n1/web"] = upgraded
+	assert.NotEqual(t, service{nodes: desired}.entry()[diff.A].Answers, service{nodes: actual}.entry()[diff.A].Answers)
+
+	// a deleted record forgets its versions
+	n.versions.deleted("s1.test.zone", "A")
+	assert.Equal(t, "", n.versions.version("s1.test.zone", "A", "1.1.1.1"))
+}
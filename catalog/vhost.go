@@ -0,0 +1,137 @@
+package catalog
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// hostnameMetaKey is the service meta key designating the hostname of the ingress or gateway fronting
+// a virtual-hosted service. Such services are published as a CNAME to that hostname instead of
+// A, AAAA and SRV records, so instance addresses are never exposed.
+const hostnameMetaKey = "ns1-hostname"
+
+// virtualHost returns the hostname a service is fronted by, or an empty string if its instances don't
+// designate one. If instances disagree, the first hostname in lexical order wins.
+func (c *consul) virtualHost(name string, cnodes []*consulapi.CatalogService) string {
+	hosts := map[string]bool{}
+	for _, n := range cnodes {
+		if h := strings.TrimSuffix(strings.TrimSpace(n.ServiceMeta[hostnameMetaKey]), "."); h != "" {
+			hosts[h] = true
+		}
+	}
+	if len(hosts) == 0 {
+		return ""
+	}
+	sorted := make([]string, 0, len(hosts))
+	for h := range hosts {
+		sorted = append(sorted, h)
+	}
+	sort.Strings(sorted)
+	if len(sorted) > 1 {
+		c.log.Warn("instances of service designate different hostnames", "service", name,
+			"meta", hostnameMetaKey, "hostnames", strings.Join(sorted, ","), "using", sorted[0])
+	}
+	return sorted[0]
+}
+
+// hostnameAddress returns the hostname the instances of a service are registered with as their address, e.g. the
+// DNS name of a load balancer registered as an external service, or an empty string if any instance has an IP
+// address. A hostname isn't a valid A record answer, so such services are published as a CNAME to it instead.
+// If instances disagree, the first hostname in lexical order wins.
+func (c *consul) hostnameAddress(name string, cnodes []*consulapi.CatalogService) string {
+	hosts := map[string]bool{}
+	ips := 0
+	for _, n := range cnodes {
+		if h := instanceHostname(n); h != "" {
+			hosts[h] = true
+		} else if v4, v6 := instanceAddresses(n); v4 != "" || v6 != "" {
+			ips++
+		}
+	}
+	if len(hosts) == 0 {
+		return ""
+	}
+	sorted := make([]string, 0, len(hosts))
+	for h := range hosts {
+		sorted = append(sorted, h)
+	}
+	sort.Strings(sorted)
+	if ips > 0 {
+		c.log.Warn("instances of service registered with a hostname as address are not published, as other instances have IP addresses",
+			"service", name, "hostnames", strings.Join(sorted, ","))
+		return ""
+	}
+	if len(sorted) > 1 {
+		c.log.Warn("instances of service are registered with different hostnames", "service", name,
+			"hostnames", strings.Join(sorted, ","), "using", sorted[0])
+	}
+	return sorted[0]
+}
+
+// instanceHostname returns the hostname an instance is registered with as its address, or an empty string if its
+// address is an IP. The service address takes precedence over the node address, so an instance registered with a
+// hostname on a node with an IP address is a hostname instance.
+func instanceHostname(n *consulapi.CatalogService) string {
+	primary := n.ServiceAddress
+	if primary == "" {
+		primary = n.Address
+	}
+	if primary == "" || net.ParseIP(primary) != nil {
+		return ""
+	}
+	return strings.TrimSuffix(primary, ".")
+}
+
+// transformCNAMERecord adds a CNAME record to the service it belongs to
+func (n *ns1) transformCNAMERecord(record *dns.ZoneRecord, services map[string]service) {
+	if len(record.ShortAns) == 0 {
+		return
+	}
+	serviceName := strings.TrimPrefix(record.Domain, n.ns1Prefix)
+	serviceName = strings.TrimSuffix(serviceName, "."+n.serviceZone.name)
+	svc, ok := services[serviceName]
+	if !ok {
+		svc = service{name: serviceName}
+	}
+	svc.ns1IDs.cnameRecID = record.ID
+	svc.ttls.cnameRecTTL = int64(record.TTL)
+	svc.cnameRecAnswer = strings.TrimSuffix(record.ShortAns[0], ".")
+	services[serviceName] = svc
+}
+
+// removeConflictingRecords deletes the records that can't coexist with the records about to be written
+// for a set of services: a CNAME can't coexist with any other record of the same name, so the A, AAAA, SRV,
+// TXT and HTTPS records of a service turning virtual-hosted are deleted, and vice versa.
+func (n *ns1) removeConflictingRecords(services map[string]service) {
+	wg := sync.WaitGroup{}
+	var count int32
+	for k, s := range services {
+		domain := n.ns1Prefix + k + "." + n.serviceZone.name
+		conflicting := map[string]string{"CNAME": s.ns1IDs.cnameRecID}
+		if s.cnameRecAnswer != "" {
+			conflicting = map[string]string{
+				"A":     s.ns1IDs.aRecID,
+				"AAAA":  s.ns1IDs.aaaaRecID,
+				"SRV":   s.ns1IDs.srvRecID,
+				"TXT":   s.ns1IDs.txtRecID,
+				"HTTPS": s.ns1IDs.httpsRecID,
+			}
+		}
+		for t, id := range conflicting {
+			if id != "" {
+				wg.Add(1)
+				go n.removeRecordWorker(&wg, n.serviceZone.name, domain, t, &count)
+			}
+		}
+	}
+	wg.Wait()
+	if count > 0 {
+		n.log.Info("removed records conflicting with CNAME records", "count", fmt.Sprintf("%d", count))
+	}
+}
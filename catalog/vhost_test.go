@@ -0,0 +1,110 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestVirtualHost(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	assert.Equal(t, "", c.virtualHost("s1", []*consulapi.CatalogService{{Node: "n1"}}))
+	assert.Equal(t, "ingress.example.com", c.virtualHost("s1", []*consulapi.CatalogService{
+		{Node: "n1"},
+		{Node: "n2", ServiceMeta: map[string]string{"ns1-hostname": "ingress.example.com."}},
+	}))
+	assert.Equal(t, "a.example.com", c.virtualHost("s1", []*consulapi.CatalogService{
+		{Node: "n1", ServiceMeta: map[string]string{"ns1-hostname": "b.example.com"}},
+		{Node: "n2", ServiceMeta: map[string]string{"ns1-hostname": "a.example.com"}},
+	}))
+}
+
+func TestHostnameAddress(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	table := map[string]struct {
+		cnodes   []*consulapi.CatalogService
+		expected string
+	}{
+		"ip":       {[]*consulapi.CatalogService{{Node: "n1", Address: "1.1.1.1"}}, ""},
+		"hostname": {[]*consulapi.CatalogService{{Node: "n1", Address: "lb.example.com."}}, "lb.example.com"},
+		"hostname on node with ip": {[]*consulapi.CatalogService{
+			{Node: "n1", Address: "10.0.0.1", ServiceAddress: "lb.example.com"},
+		}, "lb.example.com"},
+		"mixed": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceAddress: "lb.example.com"},
+			{Node: "n2", ServiceAddress: "2.2.2.2"},
+		}, ""},
+		"different": {[]*consulapi.CatalogService{
+			{Node: "n1", ServiceAddress: "b.example.com"},
+			{Node: "n2", ServiceAddress: "a.example.com"},
+		}, "a.example.com"},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, c.hostnameAddress("s1", v.cnodes), fmt.Sprintf("Test case: %s", name))
+	}
+
+	// hostnames never end up as A record answers
+	nodes := c.transformNodes([]*consulapi.CatalogService{
+		{Node: "n1", ServiceID: "s1", ServiceAddress: "lb.example.com"},
+		{Node: "n2", ServiceID: "s1", ServiceAddress: "2.2.2.2"},
+		{Node: "n3", ServiceID: "s1", Address: "10.0.0.3", ServiceAddress: "lb.example.com"},
+	})
+	assert.Equal(t, []string{"2.2.2.2"}, aAnswers(nodes))
+}
+
+func TestTransformZoneRecords_CNAME(t *testing.T) {
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"ingress.example.com."}, Type: "CNAME", TTL: 5},
+		},
+	}
+	n := ns1{serviceZone: zone{id: "1", name: "test.zone"}, log: hclog.NewNullLogger()}
+	assert.Equal(t, map[string]service{
+		"s1": {
+			name:           "s1",
+			ns1IDs:         recordIDs{cnameRecID: "r1"},
+			ttls:           recordTTLs{cnameRecTTL: 5},
+			cnameRecAnswer: "ingress.example.com",
+		},
+	}, n.transformZoneRecords(z))
+}
+
+func TestCreate_VirtualHost(t *testing.T) {
+	n := testClient(nil)
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+
+	// a service turning virtual-hosted replaces its A and SRV records with a CNAME
+	input := map[string]service{
+		"s1": {
+			cnameRecAnswer: "ingress.example.com",
+			ns1IDs:         recordIDs{aRecID: "r1", srvRecID: "r2"},
+			unchanged:      recordTypes{aaaaRec: true, txtRec: true, ownerRec: true},
+		},
+	}
+	cname := newTestRecord("CNAME", "s1", n.serviceZone.name, nil)
+	cname.AddAnswer(dns.NewCNAMEAnswer("ingress.example.com"))
+	assert.Equal(t, int32(1), n.create(input))
+	assert.Equal(t, []*dns.Record{cname}, records.records)
+	assert.ElementsMatch(t, []string{"s1.test.zone A", "s1.test.zone SRV"}, records.deleted)
+
+	// and vice versa
+	records = &mockRecordService{mux: &sync.Mutex{}}
+	n.client.Records = records
+	input = map[string]service{
+		"s1": {
+			nodes:     map[string]node{"h1": {aRecAnswer: "1.1.1.1"}},
+			ns1IDs:    recordIDs{cnameRecID: "r3"},
+			unchanged: recordTypes{srvRec: true},
+		},
+	}
+	assert.Equal(t, int32(1), n.create(input))
+	assert.Equal(t, []*dns.Record{newTestRecord("A", "s1", n.serviceZone.name, []string{"1.1.1.1"})}, records.records)
+	assert.Equal(t, []string{"s1.test.zone CNAME"}, records.deleted)
+}
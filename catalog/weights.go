@@ -0,0 +1,143 @@
+package catalog
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+// answerWeightMetaKey is the node meta key holding the weight of the A and AAAA answers of the instances
+// running on a node, it takes precedence over the Consul weights of the instances
+const answerWeightMetaKey = "ns1-weight"
+
+// weightedShuffleFilter is the NS1 filter picking answers at random in proportion to their weight
+const weightedShuffleFilter = "weighted_shuffle"
+
+// answerWeight returns the weight of the A and AAAA answers of an instance, taken from the meta of its node or
+// its passing weight, see `passingWeight`
+func (c *consul) answerWeight(n *consulapi.CatalogService) int64 {
+	weight := int64(1)
+	if w := c.passingWeight(n); w > 0 {
+		weight = w
+	}
+	v, ok := n.NodeMeta[answerWeightMetaKey]
+	if !ok {
+		return weight
+	}
+	i, err := strconv.ParseUint(v, 10, 32)
+	if err != nil || i == 0 {
+		c.log.Warn("invalid answer weight in node meta, ignoring", "node", n.Node, "key", answerWeightMetaKey, "value", v)
+		return weight
+	}
+	return int64(i)
+}
+
+// nodeWeights returns the weight of each answer selected by `answers` from a map of nodes. Instances sharing
+// an address add up their weights.
+func nodeWeights(nodes map[string]node, answers func(node) []string) map[string]int64 {
+	weights := map[string]int64{}
+	for _, n := range nodes {
+		if n.answerWeight <= 0 {
+			continue
+		}
+		for _, a := range answers(n) {
+			if a != "" {
+				weights[a] += n.answerWeight
+			}
+		}
+	}
+	return weights
+}
+
+// weightedAnswers returns the sorted, de-duplicated answers selected by `selector` from a map of nodes, followed
+// by their weight if they're weighted, so changing the weight of an answer updates its record
+func weightedAnswers(nodes map[string]node, selector func(node) []string) []string {
+	answers := nodeAnswers(nodes, selector)
+	weights := nodeWeights(nodes, selector)
+	for i, a := range answers {
+		if w, ok := weights[a]; ok {
+			answers[i] = fmt.Sprintf("%s weight=%d", a, w)
+		}
+	}
+	return answers
+}
+
+// setWeights sets the weight meta of the answers of a record and appends the weighted_shuffle filter to its
+// filter chain, unless it's already there. Records without weighted answers are left alone.
+func setWeights(rec *dns.Record, weights map[string]int64) {
+	if len(weights) == 0 {
+		return
+	}
+	for _, a := range rec.Answers {
+		w, ok := weights[strings.Join(a.Rdata, " ")]
+		if !ok {
+			continue
+		}
+		if a.Meta == nil {
+			a.Meta = &data.Meta{}
+		}
+		a.Meta.Weight = float64(w)
+	}
+	for _, f := range rec.Filters {
+		if f.Type == weightedShuffleFilter {
+			return
+		}
+	}
+	rec.Filters = append(rec.Filters, filter.NewWeightedShuffle())
+}
+
+// answerWeights remembers the weights of the answers written to NS1, which aren't part of the zone records
+// answers are read back from
+type answerWeights struct {
+	lock sync.Mutex
+	// weights holds the weight of each answer, keyed by `recordKey` and the answer
+	weights map[string]map[string]int64
+}
+
+// wrote remembers the weights of the answers of a record written to NS1
+func (w *answerWeights) wrote(rec *dns.Record) {
+	if w == nil {
+		return
+	}
+	weights := map[string]int64{}
+	for _, a := range rec.Answers {
+		if a.Meta == nil {
+			continue
+		}
+		if v, ok := a.Meta.Weight.(float64); ok {
+			weights[strings.Join(a.Rdata, " ")] = int64(v)
+		}
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.weights == nil {
+		w.weights = map[string]map[string]int64{}
+	}
+	w.weights[recordKey(rec.Domain, rec.Type)] = weights
+}
+
+// deleted forgets the weights of the answers of a deleted record
+func (w *answerWeights) deleted(domain, recType string) {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	delete(w.weights, recordKey(domain, recType))
+	w.lock.Unlock()
+}
+
+// weight returns the weight last written for an answer of a record, 0 if unknown
+func (w *answerWeights) weight(domain, recType, answer string) int64 {
+	if w == nil {
+		return 0
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.weights[recordKey(domain, recType)][answer]
+}
@@ -0,0 +1,77 @@
+package catalog
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/diff"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestAnswerWeight(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger()}
+	table := map[string]struct {
+		meta     map[string]string
+		weights  consulapi.Weights
+		expected int64
+	}{
+		"default":        {nil, consulapi.Weights{}, 1},
+		"consul weights": {nil, consulapi.Weights{Passing: 10, Warning: 1}, 10},
+		"node meta":      {map[string]string{"ns1-weight": "20"}, consulapi.Weights{Passing: 10}, 20},
+		"invalid meta":   {map[string]string{"ns1-weight": "0"}, consulapi.Weights{Passing: 10}, 10},
+	}
+	for name, v := range table {
+		weight := c.answerWeight(&consulapi.CatalogService{NodeMeta: v.meta, ServiceWeights: v.weights})
+		assert.Equal(t, v.expected, weight, fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestWeightedAnswers(t *testing.T) {
+	nodes := map[string]node{
+		"n1/web":  {aRecAnswer: "1.1.1.1", answerWeight: 10},
+		"n1/web2": {aRecAnswer: "1.1.1.1", answerWeight: 5},
+		"n2/web":  {aRecAnswer: "2.2.2.2", answerWeight: 1},
+		"n3/web":  {aRecAnswer: "3.3.3.3"},
+	}
+	answers := weightedAnswers(nodes, node.v4Answers)
+	assert.Equal(t, []string{"1.1.1.1 weight=15", "2.2.2.2 weight=1", "3.3.3.3"}, answers)
+}
+
+func TestCreate_WeightedAnswers(t *testing.T) {
+	n := testClient(nil)
+	n.weights = &answerWeights{}
+	records := &mockRecordService{mux: &sync.Mutex{}}
+	n.client = &ns1APIClient{Zones: &mockZoneService{}, Records: records}
+	c := consul{log: hclog.NewNullLogger(), addressFamily: ipv4Family, weightedAnswers: true}
+	desired := c.transformNodes([]*consulapi.CatalogService{
+		{Node: "n1", ServiceID: "web", Address: "1.1.1.1", ServicePort: 80, ServiceWeights: consulapi.Weights{Passing: 10}},
+		{Node: "n2", ServiceID: "web", Address: "2.2.2.2", ServicePort: 80, NodeMeta: map[string]string{"ns1-weight": "3"}},
+	})
+
+	assert.Equal(t, int32(1), n.create(map[string]service{"s1": {nodes: desired, unchanged: recordTypes{srvRec: true}}}))
+	assert.Len(t, records.records, 1)
+	rec := records.records[0]
+	assert.Equal(t, []string{"1.1.1.1", "2.2.2.2"}, answerStrings(rec.Answers))
+	assert.Equal(t, float64(10), rec.Answers[0].Meta.Weight)
+	assert.Equal(t, float64(3), rec.Answers[1].Meta.Weight)
+	assert.Len(t, rec.Filters, 1)
+	assert.Equal(t, "weighted_shuffle", rec.Filters[0].Type)
+
+	// the weights written are part of the service read back from the zone
+	z := &dns.Zone{
+		Zone: "test.zone",
+		Records: []*dns.ZoneRecord{
+			{Domain: "s1.test.zone", ID: "r1", ShortAns: []string{"1.1.1.1", "2.2.2.2"}, Type: "A", TTL: 10},
+		},
+	}
+	actual := n.transformZoneRecords(z)["s1"].nodes
+	assert.Equal(t, service{nodes: desired}.entry()[diff.A].Answers, service{nodes: actual}.entry()[diff.A].Answers)
+
+	// changing a weight updates the record
+	desired["n2/web"] = node{aRecAnswer: "2.2.2.2", answerWeight: 4}
+	assert.False(t, nodesAreEqual(desired, actual))
+}
@@ -0,0 +1,43 @@
+package catalog
+
+import (
+	"net/http"
+	"strings"
+
+	metrics "github.com/armon/go-metrics"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// SOA defaults of the zones created, the defaults of the NS1 portal
+const (
+	zoneTTL     = 3600
+	zoneRefresh = 43200
+	zoneRetry   = 7200
+	zoneExpiry  = 1209600
+	zoneNxTTL   = 3600
+)
+
+type zoneCreateService interface {
+	Create(z *dns.Zone) (*http.Response, error)
+}
+
+// createZone creates a missing zone with the SOA defaults. Its NS records are the nameservers NS1 assigns to it,
+// which are logged so the zone can be delegated to them. A zone created concurrently, e.g. by another instance,
+// is fetched instead.
+func (n *ns1) createZone(zoneName string) (*dns.Zone, error) {
+	zone := dns.NewZone(zoneName)
+	zone.TTL, zone.Refresh, zone.Retry, zone.Expiry, zone.NxTTL = zoneTTL, zoneRefresh, zoneRetry, zoneExpiry, zoneNxTTL
+	n.limiter.write()
+	resp, err := n.client.ZoneCreator.Create(zone)
+	if err == ns1api.ErrZoneExists {
+		n.log.Info("zone was created concurrently, fetching it", "zone", zoneName)
+		return n.fetchZone(zoneName)
+	}
+	if err != nil {
+		return nil, ns1Error(resp, err)
+	}
+	metrics.IncrCounter([]string{"ns1", "zone_created"}, 1)
+	n.log.Info("created missing zone", "zone", zoneName, "nameservers", strings.Join(zone.DNSServers, ","))
+	return zone, nil
+}
@@ -0,0 +1,63 @@
+package catalog
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// creatableZoneService holds the zones of `zones`, and creates zones unless `exists` is set, as if they were
+// created concurrently
+type creatableZoneService struct {
+	zones   map[string]*dns.Zone
+	created []*dns.Zone
+	exists  bool
+}
+
+func (s *creatableZoneService) Get(z string) (*dns.Zone, *http.Response, error) {
+	if zone, ok := s.zones[z]; ok {
+		return zone, nil, nil
+	}
+	return nil, &http.Response{StatusCode: http.StatusNotFound}, ns1api.ErrZoneMissing
+}
+
+func (s *creatableZoneService) Create(z *dns.Zone) (*http.Response, error) {
+	if s.exists {
+		s.zones[z.Zone] = &dns.Zone{ID: "z2", Zone: z.Zone}
+		return nil, ns1api.ErrZoneExists
+	}
+	s.created = append(s.created, z)
+	z.ID, z.DNSServers = "z1", []string{"dns1.p01.nsone.net", "dns2.p01.nsone.net"}
+	return nil, nil
+}
+
+func TestSetupServiceZone_Create(t *testing.T) {
+	n := testClient(nil)
+	zones := &creatableZoneService{zones: map[string]*dns.Zone{}}
+	n.client = &ns1APIClient{Zones: zones, Records: &mockRecordService{}}
+
+	// missing zones aren't created by default
+	assert.Equal(t, ErrZoneMissing, ErrorClass(n.setupServiceZone("new.zone")))
+	assert.Empty(t, zones.created)
+
+	n.client.ZoneCreator = zones
+	if assert.NoError(t, n.setupServiceZone("new.zone")) {
+		assert.Equal(t, zone{id: "z1", name: "new.zone"}, n.serviceZone)
+	}
+	if assert.Len(t, zones.created, 1) {
+		assert.Equal(t, 3600, zones.created[0].TTL)
+		assert.Equal(t, 43200, zones.created[0].Refresh)
+		assert.Equal(t, 7200, zones.created[0].Retry)
+		assert.Equal(t, 1209600, zones.created[0].Expiry)
+		assert.Equal(t, 3600, zones.created[0].NxTTL)
+	}
+
+	// a zone created concurrently is fetched
+	zones.exists = true
+	if assert.NoError(t, n.setupServiceZone("other.zone")) {
+		assert.Equal(t, zone{id: "z2", name: "other.zone"}, n.serviceZone)
+	}
+}
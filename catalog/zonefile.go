@@ -0,0 +1,92 @@
+package catalog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// ExportFormatZoneFile exports records as an RFC 1035 zone file
+const ExportFormatZoneFile = "zonefile"
+
+// Export fetches the services from Consul once and writes the records they are published as in the given format
+func Export(cfg Config, consulClient *consulapi.Client, format string, w io.Writer) error {
+	if format != ExportFormatZoneFile {
+		return fmt.Errorf("unknown export format %q, must be %q", format, ExportFormatZoneFile)
+	}
+	consul, err := fetchOnce(cfg, consulClient)
+	if err != nil {
+		return err
+	}
+	return writeZoneFile(w, consul.getServices(), cfg.NS1Prefix, cfg.NS1Domain, consul.addressFamily, consul.dnsTTL)
+}
+
+// zoneFileRecord is a resource record of a zone file
+type zoneFileRecord struct {
+	name    string
+	ttl     int64
+	recType string
+	data    string
+}
+
+// writeZoneFile writes the records of services as a zone file of `domain`, with names relative to its origin.
+// SRV targets and CNAME hostnames are written as absolute names, as published in NS1. Ownership records
+// are written with the unjittered `ownerTTL`.
+func writeZoneFile(w io.Writer, services map[string]service, prefix, domain string, family addressFamily, ownerTTL int64) error {
+	names := make([]string, 0, len(services))
+	for k := range services {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	records := []zoneFileRecord{}
+	for _, k := range names {
+		s, name := services[k], prefix+k
+		if s.cnameRecAnswer != "" {
+			records = append(records, zoneFileRecord{name, s.ttls.cnameRecTTL, "CNAME", absoluteName(s.cnameRecAnswer)})
+		} else {
+			if family.v4() {
+				for _, a := range aAnswers(s.nodes) {
+					records = append(records, zoneFileRecord{name, s.ttls.aRecTTL, "A", a})
+				}
+			}
+			if family.v6() {
+				for _, a := range aaaaAnswers(s.nodes) {
+					records = append(records, zoneFileRecord{name, s.ttls.aaaaRecTTL, "AAAA", a})
+				}
+			}
+			for _, a := range srvAnswers(s.nodes) {
+				data := fmt.Sprintf("%d %d %d %s", a.priority, a.weight, a.port, absoluteName(a.address))
+				records = append(records, zoneFileRecord{name, s.ttls.srvRecTTL, "SRV", data})
+			}
+			if s.txtRecAnswer != "" {
+				records = append(records, zoneFileRecord{name, s.ttls.txtRecTTL, "TXT", strconv.Quote(s.txtRecAnswer)})
+			}
+			if s.httpsRecAnswer != "" {
+				records = append(records, zoneFileRecord{name, s.ttls.httpsRecTTL, "HTTPS", s.httpsRecAnswer})
+			}
+		}
+		if s.ownerRecAnswer != "" {
+			records = append(records, zoneFileRecord{ownerRecordLabel + name, ownerTTL, "TXT", strconv.Quote(s.ownerRecAnswer)})
+		}
+	}
+
+	lines := []string{
+		fmt.Sprintf("; records of %d services managed by consul-ns1", len(names)),
+		"$ORIGIN " + absoluteName(domain),
+	}
+	for _, r := range records {
+		lines = append(lines, fmt.Sprintf("%s\t%d\tIN\t%s\t%s", r.name, r.ttl, r.recType, r.data))
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// absoluteName returns a domain name terminated by the root label
+func absoluteName(name string) string {
+	return strings.TrimSuffix(name, ".") + "."
+}
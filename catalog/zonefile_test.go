@@ -0,0 +1,43 @@
+package catalog
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteZoneFile(t *testing.T) {
+	services := map[string]service{
+		"web": {
+			nodes: map[string]node{
+				"h1": {aRecAnswer: "1.1.1.1", aaaaRecAnswer: "::1", srvRecAnswers: map[int]srvAnswer{80: {port: 80, address: "h1.node.dc1.consul"}}},
+				"h2": {aRecAnswer: "2.2.2.2", srvRecAnswers: map[int]srvAnswer{80: {port: 80, address: "h2.node.dc1.consul."}}},
+			},
+			ttls:           recordTTLs{aRecTTL: 55, aaaaRecTTL: 56, srvRecTTL: 57, txtRecTTL: 58},
+			txtRecAnswer:   "port=80",
+			ownerRecAnswer: ownerTXTAnswer("p-"),
+		},
+		"api": {cnameRecAnswer: "ingress.example.com", ttls: recordTTLs{cnameRecTTL: 60}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writeZoneFile(&buf, services, "p-", "test.zone", ipv4Family, 60))
+	assert.Equal(t, `; records of 2 services managed by consul-ns1
+$ORIGIN test.zone.
+p-api	60	IN	CNAME	ingress.example.com.
+p-web	55	IN	A	1.1.1.1
+p-web	55	IN	A	2.2.2.2
+p-web	57	IN	SRV	0 0 80 h1.node.dc1.consul.
+p-web	57	IN	SRV	0 0 80 h2.node.dc1.consul.
+p-web	58	IN	TXT	"port=80"
+_consul-ns1.p-web	60	IN	TXT	"heritage=consul-ns1,prefix=p-"
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, writeZoneFile(&buf, map[string]service{"web": services["web"]}, "", "test.zone.", ipv6Family, 60))
+	assert.Contains(t, buf.String(), "$ORIGIN test.zone.\n")
+	assert.Contains(t, buf.String(), "web\t56\tIN\tAAAA\t::1\n")
+	assert.NotContains(t, buf.String(), "\tA\t")
+}
@@ -0,0 +1,76 @@
+package catalog
+
+import (
+	"sort"
+	"strings"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// zoneMetaKey is the service meta key, and tag prefix as "ns1-zone=<zone>", routing a service to an NS1 zone
+const zoneMetaKey = "ns1-zone"
+
+// zoneRouting splits the services of the catalog between the NS1 zones synced by several instances, each syncing
+// the services routed to its own zone
+type zoneRouting struct {
+	// zone is the zone synced by this instance
+	zone string
+	// fallback is the zone of the services without a route
+	fallback string
+}
+
+// normalizeZone lowercases a zone name and removes its trailing dot, so routes match the names of NS1 zones
+func normalizeZone(zone string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(zone)), ".")
+}
+
+// route returns the zone a service is routed to: the ns1-zone meta of its instances, else its ns1-zone tag or zone
+// directive, else the fallback zone. Instances routing their service to different zones are reported and the first zone in
+// lexical order is used.
+func (c *consul) route(name string, tags []string, cnodes []*consulapi.CatalogService) string {
+	zones := map[string]bool{}
+	for _, n := range cnodes {
+		if v, ok := n.ServiceMeta[zoneMetaKey]; ok && normalizeZone(v) != "" {
+			zones[normalizeZone(v)] = true
+		}
+	}
+	if len(zones) == 0 {
+		for _, tag := range tags {
+			if z := normalizeZone(strings.TrimPrefix(tag, zoneMetaKey+"=")); strings.HasPrefix(tag, zoneMetaKey+"=") && z != "" {
+				zones[z] = true
+			}
+		}
+		for _, v := range tagDirectives(tags)[zoneDirective] {
+			if z := normalizeZone(v); z != "" {
+				zones[z] = true
+			}
+		}
+	}
+	if len(zones) == 0 {
+		return c.zones.fallback
+	}
+	routes := make([]string, 0, len(zones))
+	for z := range zones {
+		routes = append(routes, z)
+	}
+	sort.Strings(routes)
+	if len(routes) > 1 {
+		c.log.Warn("instances route service to different zones, using the first", "service", name,
+			"zones", strings.Join(routes, ","))
+	}
+	return routes[0]
+}
+
+// routedHere reports whether a service is routed to the zone of this instance. All services are without zone
+// routing.
+func (c *consul) routedHere(name string, tags []string, cnodes []*consulapi.CatalogService) bool {
+	if c.zones == nil {
+		return true
+	}
+	zone := c.route(name, tags, cnodes)
+	if zone != c.zones.zone {
+		c.log.Debug("service routed to another zone, ignoring", "service", name, "zone", zone)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package catalog
+
+import (
+	"fmt"
+	"testing"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoute(t *testing.T) {
+	c := consul{log: hclog.NewNullLogger(), zones: &zoneRouting{zone: "public.example.com", fallback: "public.example.com"}}
+	table := map[string]struct {
+		tags     []string
+		meta     []map[string]string
+		expected string
+		here     bool
+	}{
+		"none":         {[]string{"primary"}, []map[string]string{nil}, "public.example.com", true},
+		"tag":          {[]string{"ns1-zone=internal.example.com"}, []map[string]string{nil}, "internal.example.com", false},
+		"tag here":     {[]string{"ns1-zone=public.example.com"}, []map[string]string{nil}, "public.example.com", true},
+		"normalized":   {[]string{"ns1-zone=Internal.Example.com."}, []map[string]string{nil}, "internal.example.com", false},
+		"empty tag":    {[]string{"ns1-zone="}, []map[string]string{nil}, "public.example.com", true},
+		"directive":    {[]string{"ns1:zone=internal.example.com"}, []map[string]string{nil}, "internal.example.com", false},
+		"meta":         {[]string{"ns1-zone=public.example.com"}, []map[string]string{{"ns1-zone": "internal.example.com"}}, "internal.example.com", false},
+		"some meta":    {nil, []map[string]string{nil, {"ns1-zone": "internal.example.com"}}, "internal.example.com", false},
+		"conflicting":  {nil, []map[string]string{{"ns1-zone": "public.example.com"}, {"ns1-zone": "internal.example.com"}}, "internal.example.com", false},
+		"no instances": {[]string{"ns1-zone=internal.example.com"}, nil, "internal.example.com", false},
+	}
+	for name, v := range table {
+		var cnodes []*consulapi.CatalogService
+		for i, meta := range v.meta {
+			cnodes = append(cnodes, &consulapi.CatalogService{Node: fmt.Sprintf("n%d", i), ServiceName: "web", ServiceMeta: meta})
+		}
+		assert.Equal(t, v.expected, c.route("web", v.tags, cnodes), fmt.Sprintf("Test case: %s", name))
+		assert.Equal(t, v.here, c.routedHere("web", v.tags, cnodes), fmt.Sprintf("Test case: %s", name))
+	}
+
+	// services without a route go to the default zone
+	c.zones.fallback = "internal.example.com"
+	assert.False(t, c.routedHere("web", nil, nil))
+
+	// all services are synced without zone routing
+	c.zones = nil
+	assert.True(t, c.routedHere("web", []string{"ns1-zone=internal.example.com"}, nil))
+}
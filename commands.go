@@ -4,8 +4,14 @@ import (
 	"os"
 
 	"github.com/mitchellh/cli"
+	cmdBootstrapConsul "github.com/nsone/consul-ns1/subcommand/bootstrap-consul"
+	cmdExport "github.com/nsone/consul-ns1/subcommand/export"
+	cmdPlan "github.com/nsone/consul-ns1/subcommand/plan"
+	cmdServices "github.com/nsone/consul-ns1/subcommand/services"
 	cmdSyncCatalog "github.com/nsone/consul-ns1/subcommand/sync-catalog"
+	cmdVerify "github.com/nsone/consul-ns1/subcommand/verify"
 	cmdVersion "github.com/nsone/consul-ns1/subcommand/version"
+	cmdZones "github.com/nsone/consul-ns1/subcommand/zones"
 	"github.com/nsone/consul-ns1/version"
 )
 
@@ -20,6 +26,30 @@ func init() {
 			return &cmdSyncCatalog.Command{UI: ui}, nil
 		},
 
+		"verify": func() (cli.Command, error) {
+			return &cmdVerify.Command{UI: ui}, nil
+		},
+
+		"plan": func() (cli.Command, error) {
+			return &cmdPlan.Command{UI: ui}, nil
+		},
+
+		"services": func() (cli.Command, error) {
+			return &cmdServices.Command{UI: ui}, nil
+		},
+
+		"export": func() (cli.Command, error) {
+			return &cmdExport.Command{UI: ui}, nil
+		},
+
+		"bootstrap-consul": func() (cli.Command, error) {
+			return &cmdBootstrapConsul.Command{UI: ui}, nil
+		},
+
+		"zones": func() (cli.Command, error) {
+			return &cmdZones.Command{UI: ui}, nil
+		},
+
 		"version": func() (cli.Command, error) {
 			return &cmdVersion.Command{UI: ui, Version: version.GetHumanVersion()}, nil
 		},
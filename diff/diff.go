@@ -0,0 +1,141 @@
+// Package diff compares the desired and actual state of DNS records, grouped by record family.
+// It has no knowledge of how records are published, so supporting a new type of record only
+// requires describing it as a Family.
+package diff
+
+import "sort"
+
+// Family is a type of record, e.g. "A" or "SRV"
+type Family string
+
+// Families of records published for a service
+const (
+	A     Family = "A"
+	AAAA  Family = "AAAA"
+	SRV   Family = "SRV"
+	TXT   Family = "TXT"
+	CNAME Family = "CNAME"
+	HTTPS Family = "HTTPS"
+)
+
+// Record is the state of the record of one family of an entry
+type Record struct {
+	// Answers are compared regardless of order, duplicates and empty answers
+	Answers []string
+	TTL     int64
+	// Filters identifies the filter chain set on the record, empty if it isn't managed
+	Filters string
+	// ID identifies an existing record, it isn't compared
+	ID string
+}
+
+// Entry holds the records of an entry, e.g. a service, by family. A missing family is equal to an empty record.
+type Entry map[Family]Record
+
+// answers returns the sorted, de-duplicated non-empty answers of a record
+func (r Record) answers() []string {
+	seen := map[string]struct{}{}
+	answers := []string{}
+	for _, a := range r.Answers {
+		if a == "" {
+			continue
+		}
+		if _, ok := seen[a]; !ok {
+			seen[a] = struct{}{}
+			answers = append(answers, a)
+		}
+	}
+	sort.Strings(answers)
+	return answers
+}
+
+// Equal determines if two records have the same answers, TTL and filter chain
+func Equal(a, b Record) bool {
+	if a.TTL != b.TTL || a.Filters != b.Filters {
+		return false
+	}
+	answersA, answersB := a.answers(), b.answers()
+	if len(answersA) != len(answersB) {
+		return false
+	}
+	for i := range answersA {
+		if answersA[i] != answersB[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Unchanged returns for each family of either entry whether its records are equal
+func Unchanged(desired, actual Entry) map[Family]bool {
+	unchanged := map[Family]bool{}
+	for f, r := range desired {
+		unchanged[f] = Equal(r, actual[f])
+	}
+	for f, r := range actual {
+		if _, ok := desired[f]; !ok {
+			unchanged[f] = Equal(Record{}, r)
+		}
+	}
+	return unchanged
+}
+
+// TTLOnly returns the families whose records only differ by TTL, sorted, and whether no record of two entries
+// differs otherwise
+func TTLOnly(desired, actual Entry) ([]Family, bool) {
+	families := []Family{}
+	for f, ok := range Unchanged(desired, actual) {
+		if ok {
+			continue
+		}
+		d, a := desired[f], actual[f]
+		d.TTL, a.TTL = 0, 0
+		if !Equal(d, a) {
+			return nil, false
+		}
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool { return families[i] < families[j] })
+	return families, true
+}
+
+// Changed determines if any record of two entries differs
+func Changed(desired, actual Entry) bool {
+	for _, ok := range Unchanged(desired, actual) {
+		if !ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge returns the desired records, completed with the IDs and TTLs of the actual records they don't set
+func Merge(desired, actual Entry) Entry {
+	merged := Entry{}
+	for f, r := range desired {
+		merged[f] = r
+	}
+	for f, a := range actual {
+		r := merged[f]
+		if r.ID == "" {
+			r.ID = a.ID
+		}
+		if r.TTL == 0 {
+			r.TTL = a.TTL
+		}
+		merged[f] = r
+	}
+	return merged
+}
+
+// Removed returns the sorted keys of the actual entries that have no desired entry
+func Removed(desired, actual map[string]Entry) []string {
+	keys := []string{}
+	for k := range actual {
+		if _, ok := desired[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,76 @@
+package diff
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEqual(t *testing.T) {
+	table := map[string]struct {
+		a, b     Record
+		expected bool
+	}{
+		"Empty records":          {expected: true},
+		"Empty answer":           {a: Record{Answers: []string{""}}, expected: true},
+		"Same answers reordered": {a: Record{Answers: []string{"1", "2"}}, b: Record{Answers: []string{"2", "1", "1"}}, expected: true},
+		"IDs are ignored":        {a: Record{ID: "a"}, b: Record{ID: "b"}, expected: true},
+		"Answer only in first":   {a: Record{Answers: []string{"1"}}, expected: false},
+		"Answer only in second":  {b: Record{Answers: []string{"1"}}, expected: false},
+		"Different TTL":          {a: Record{TTL: 1}, b: Record{TTL: 2}, expected: false},
+		"Different filters":      {a: Record{Filters: `[{"filter":"up"}]`}, expected: false},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, Equal(v.a, v.b), fmt.Sprintf("Test case: %s", name))
+	}
+}
+
+func TestUnchanged(t *testing.T) {
+	desired := Entry{A: {Answers: []string{"1.1.1.1"}}, SRV: {Answers: []string{"1 1 80 1.1.1.1"}}}
+	actual := Entry{A: {Answers: []string{"1.1.1.1"}}, TXT: {Answers: []string{"ports=80"}}}
+	assert.Equal(t, map[Family]bool{A: true, SRV: false, TXT: false}, Unchanged(desired, actual))
+	assert.False(t, Changed(desired, Entry{A: {Answers: []string{"1.1.1.1"}, ID: "a"}, SRV: desired[SRV]}))
+	assert.True(t, Changed(desired, actual))
+}
+
+func TestTTLOnly(t *testing.T) {
+	actual := Entry{A: {Answers: []string{"1.1.1.1"}, TTL: 30}, SRV: {Answers: []string{"1 1 80 1.1.1.1"}, TTL: 30}}
+	families, ok := TTLOnly(Entry{A: {Answers: []string{"1.1.1.1"}, TTL: 60}, SRV: {Answers: []string{"1 1 80 1.1.1.1"}, TTL: 60}}, actual)
+	assert.True(t, ok)
+	assert.Equal(t, []Family{A, SRV}, families)
+
+	families, ok = TTLOnly(Entry{A: {Answers: []string{"1.1.1.1"}, TTL: 60}, SRV: actual[SRV]}, actual)
+	assert.True(t, ok)
+	assert.Equal(t, []Family{A}, families)
+
+	_, ok = TTLOnly(Entry{A: {Answers: []string{"2.2.2.2"}, TTL: 60}, SRV: actual[SRV]}, actual)
+	assert.False(t, ok, "answers differ")
+
+	_, ok = TTLOnly(Entry{A: {Answers: []string{"1.1.1.1"}, TTL: 60, Filters: "[]"}, SRV: actual[SRV]}, actual)
+	assert.False(t, ok, "filters differ")
+}
+
+func TestMerge(t *testing.T) {
+	desired := Entry{
+		A:    {Answers: []string{"1.1.1.1"}, TTL: 60},
+		AAAA: {Answers: []string{"::1"}, ID: "aaaa"},
+	}
+	actual := Entry{
+		A:    {Answers: []string{"2.2.2.2"}, TTL: 30, ID: "a"},
+		AAAA: {TTL: 30, ID: "other"},
+		TXT:  {Answers: []string{"ports=80"}, TTL: 30, ID: "txt"},
+	}
+	assert.Equal(t, Entry{
+		A:    {Answers: []string{"1.1.1.1"}, TTL: 60, ID: "a"},
+		AAAA: {Answers: []string{"::1"}, TTL: 30, ID: "aaaa"},
+		TXT:  {TTL: 30, ID: "txt"},
+	}, Merge(desired, actual))
+}
+
+func TestRemoved(t *testing.T) {
+	desired := map[string]Entry{"s1": {}, "s2": {}}
+	actual := map[string]Entry{"s1": {}, "s3": {}, "s0": {}}
+	assert.Equal(t, []string{"s0", "s3"}, Removed(desired, actual))
+	assert.Equal(t, []string{}, Removed(actual, actual))
+}
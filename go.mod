@@ -3,11 +3,13 @@ module github.com/nsone/consul-ns1
 go 1.12
 
 require (
+	github.com/armon/go-metrics v0.0.0-20190430140413-ec5e00d3c878
 	github.com/hashicorp/consul v1.6.1
 	github.com/hashicorp/consul/api v1.2.0
 	github.com/hashicorp/go-hclog v0.9.2
 	github.com/miekg/dns v1.0.15 // indirect
 	github.com/mitchellh/cli v1.0.0
+	github.com/posener/complete v1.1.1
 	github.com/stretchr/testify v1.4.0
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 	gopkg.in/ns1/ns1-go.v2 v2.0.0-20190923172200-72e0216bb8b5
@@ -0,0 +1,161 @@
+package bootstrapconsul
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/catalog"
+	"github.com/nsone/consul-ns1/subcommand"
+	"github.com/posener/complete"
+)
+
+// Command is the command for registering the services published in an NS1 zone into Consul
+type Command struct {
+	UI cli.Ui
+
+	flags                *flag.FlagSet
+	flagHelpFormat       string
+	http                 *flags.HTTPFlags
+	flagNS1ServicePrefix string
+	flagNS1Domain        string
+	flagNS1Endpoint      string
+	flagNS1APIKey        string
+	flagNS1IgnoreSSL     bool
+	flagDryRun           bool
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	c.flags.StringVar(&c.flagNS1ServicePrefix, "ns1-service-prefix", "",
+		"Only import records whose name starts with this prefix, which is stripped from the service names. "+
+			"Use the -ns1-service-prefix sync-catalog will run with.")
+	c.flags.StringVar(&c.flagNS1Domain, "ns1-domain", "",
+		"Name of the DNS domain in NS1 to import services from.")
+	c.flags.StringVar(&c.flagNS1Endpoint, "ns1-endpoint", "",
+		"The absolute URL of the NS1 API endpoint. (Defaults to https://api.nsone.net/v1/)")
+	c.flags.StringVar(&c.flagNS1APIKey, "ns1-apikey", "",
+		"The API key to use when communicating with NS1.  This can also be specified via the "+
+			"NS1_APIKEY environment variable.")
+	c.flags.BoolVar(&c.flagNS1IgnoreSSL, "ns1-ignoressl", false,
+		"Ignore SSL validation when communicating with NS1. (Defaults to false)")
+	c.flags.BoolVar(&c.flagDryRun, "dry-run", false,
+		"List the services that would be registered without registering them. (Defaults to false)")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	subcommand.HelpFormatVar(c.flags, &c.flagHelpFormat)
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run registers the services published in the NS1 zone as external services in Consul
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if c.flagHelpFormat != "" {
+		return subcommand.PrintHelp(c.UI, "bootstrap-consul", c.flags, c.flagHelpFormat)
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagNS1Domain == "" {
+		c.UI.Error("Please provide -ns1-domain")
+		return 1
+	}
+
+	tc := subcommand.DefaultTransportConfig()
+	tc.IgnoreSSL = c.flagNS1IgnoreSSL
+	ns1Client, err := subcommand.NS1Client(c.flagNS1Endpoint, c.flagNS1APIKey, tc)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error retrieving NS1 client: %s", err))
+		return 1
+	}
+	consulClient, err := c.http.APIClient()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	cfg := catalog.Config{
+		NS1Prefix: c.flagNS1ServicePrefix,
+		NS1Domain: c.flagNS1Domain,
+	}
+	results, err := catalog.Bootstrap(cfg, ns1Client, consulClient, c.flagDryRun)
+	if len(results) > 0 {
+		c.UI.Output(formatResults(results, c.flagDryRun))
+	}
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error bootstrapping Consul: %s", err))
+		return 1
+	}
+	if len(results) == 0 {
+		c.UI.Warn(fmt.Sprintf("No services are published in %s", c.flagNS1Domain))
+	}
+	return 0
+}
+
+// formatResults returns a table of the registered instances
+func formatResults(results []catalog.BootstrapResult, dryRun bool) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tNODE\tADDRESS\tPORT\tNOTES")
+	for _, r := range results {
+		s := r.Registration.Service
+		notes := r.Skipped
+		if notes == "" && dryRun {
+			notes = "not registered, dry run"
+		}
+		port := "-"
+		if s.Port != 0 {
+			port = fmt.Sprintf("%d", s.Port)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", s.Service, r.Registration.Node, s.Address, port, notes)
+	}
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Synopsis returns a short description of the program
+func (c *Command) Synopsis() string { return synopsis }
+
+// Help returns usage info for the program
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+// AutocompleteFlags returns the predictors of the flags of the program
+func (c *Command) AutocompleteFlags() complete.Flags {
+	c.once.Do(c.init)
+	return subcommand.PredictFlags(c.flags, complete.Flags{
+		"-help-format": complete.PredictSet(subcommand.HelpFormatJSON),
+		"-ns1-domain":  subcommand.PredictZones(),
+	})
+}
+
+// AutocompleteArgs returns the predictor of the arguments of the program, which takes none
+func (c *Command) AutocompleteArgs() complete.Predictor { return complete.PredictNothing }
+
+const synopsis = "Register the services published in an NS1 zone into Consul."
+const help = `
+Usage: consul-ns1 bootstrap-consul [options]
+
+  Read the records of the NS1 zone and register the services they describe
+  as external services in the Consul catalog, to seed Consul when migrating
+  from NS1 as the source of truth. Each address is registered on an external
+  node of its own. Services already registered in Consul are skipped.
+
+`
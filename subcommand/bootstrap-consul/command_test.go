@@ -0,0 +1,33 @@
+package bootstrapconsul
+
+import (
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/testutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestRun_Flags(t *testing.T) {
+	for _, args := range [][]string{{"-unknown"}, {"-ns1-domain=example.com", "extra"}, {}} {
+		ui := cli.NewMockUi()
+		cmd := Command{UI: ui}
+		assert.Equal(t, 1, cmd.Run(append([]string{"-ns1-apikey=fake"}, args...)), args)
+	}
+}
+
+func TestRun_DryRun(t *testing.T) {
+	fakeNS1, fakeConsul := testutil.NewNS1(), testutil.NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeNS1.SetRecord(&dns.Record{Zone: "example.com", Domain: "web.example.com", Type: "A",
+		Answers: []*dns.Answer{dns.NewAv4Answer("1.1.1.1")}})
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	assert.Equal(t, 0, cmd.Run([]string{"-ns1-endpoint=" + fakeNS1.Endpoint(), "-ns1-apikey=fake",
+		"-http-addr=" + fakeConsul.Address(), "-ns1-domain=example.com", "-dry-run"}))
+	assert.Contains(t, ui.OutputWriter.String(), "web      ns1-1-1-1-1  1.1.1.1  -     not registered, dry run")
+}
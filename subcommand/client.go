@@ -4,18 +4,40 @@ import (
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/nsone/consul-ns1/version"
 	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
 )
 
-// NS1Client returns a client for the NS1 API
-func NS1Client(endpoint string, apiKey string, ignoreSSL bool) (*ns1api.Client, error) {
-	decos := []func(*ns1api.Client){}
+// TransportConfig holds the tuning parameters of the HTTP transport used to talk to the NS1 API
+type TransportConfig struct {
+	// IgnoreSSL disables TLS certificate verification
+	IgnoreSSL bool
+	// MaxIdleConns is the maximum number of idle (keep-alive) connections kept open to the NS1 API
+	MaxIdleConns int
+	// IdleConnTimeout is how long an idle connection is kept in the pool before being closed
+	IdleConnTimeout time.Duration
+	// KeepAlive is the interval between TCP keep-alive probes on open connections
+	KeepAlive time.Duration
+	// Timeout bounds each request to the NS1 API, 0 means no timeout
+	Timeout time.Duration
+}
 
-	ua := fmt.Sprintf("consul-ns1-%s", version.GetHumanVersion())
+// DefaultTransportConfig returns the transport settings used when no tuning is provided
+func DefaultTransportConfig() TransportConfig {
+	return TransportConfig{
+		MaxIdleConns:    100,
+		IdleConnTimeout: 90 * time.Second,
+		KeepAlive:       30 * time.Second,
+	}
+}
+
+// NS1Client returns a client for the NS1 API
+func NS1Client(endpoint string, apiKey string, tc TransportConfig) (*ns1api.Client, error) {
 	k := os.Getenv("NS1_APIKEY")
 	if apiKey != "" {
 		k = apiKey
@@ -23,23 +45,53 @@ func NS1Client(endpoint string, apiKey string, ignoreSSL bool) (*ns1api.Client,
 	if k == "" {
 		return nil, errors.New("NS1 API key must be provided via environment variable NS1_APIKEY or -ns1-apikey flag")
 	}
-	decos = append(decos, ns1api.SetUserAgent(ua), ns1api.SetAPIKey(k))
+	return newNS1Client(endpoint, k, tc), nil
+}
+
+// NS1ReadClient returns a client for the read requests to the NS1 API, e.g. polling the zone, using a separate
+// API key that may only have read permissions. It returns nil if no read key is provided, reads then use the
+// client of NS1Client.
+func NS1ReadClient(endpoint string, apiKey string, tc TransportConfig) *ns1api.Client {
+	k := os.Getenv("NS1_READ_APIKEY")
+	if apiKey != "" {
+		k = apiKey
+	}
+	if k == "" {
+		return nil
+	}
+	return newNS1Client(endpoint, k, tc)
+}
+
+// newNS1Client returns a client for the NS1 API using an API key
+func newNS1Client(endpoint string, apiKey string, tc TransportConfig) *ns1api.Client {
+	ua := fmt.Sprintf("consul-ns1-%s", version.GetHumanVersion())
+	decos := []func(*ns1api.Client){ns1api.SetUserAgent(ua), ns1api.SetAPIKey(apiKey)}
 
 	if endpoint != "" {
 		decos = append(decos, ns1api.SetEndpoint(endpoint))
 	}
-	httpClient := configureHTTPDoer(ignoreSSL)
-	return ns1api.NewClient(httpClient, decos...), nil
+	httpClient := configureHTTPDoer(tc)
+	return ns1api.NewClient(httpClient, decos...)
 }
 
-// configureHTTPDoer configures HTTP client for the NS1 API
-func configureHTTPDoer(ignoreSSL bool) *http.Client {
-	httpClient := http.DefaultClient
-	if ignoreSSL {
-		tr := &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		}
-		httpClient.Transport = tr
+// configureHTTPDoer configures a dedicated HTTP client for the NS1 API.
+// A new client and transport are always created so that the connection pool is not shared
+// with http.DefaultClient, which is also used by other libraries such as the Consul client.
+func configureHTTPDoer(tc TransportConfig) *http.Client {
+	tr := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: tc.KeepAlive,
+		}).DialContext,
+		MaxIdleConns:          tc.MaxIdleConns,
+		MaxIdleConnsPerHost:   tc.MaxIdleConns,
+		IdleConnTimeout:       tc.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+	}
+	if tc.IgnoreSSL {
+		tr.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
 	}
-	return httpClient
+	return &http.Client{Transport: tr, Timeout: tc.Timeout}
 }
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
@@ -24,14 +25,14 @@ func TestNS1Client_APIKey(t *testing.T) {
 	k := "testapikey"
 	os.Setenv("NS1_APIKEY", k)
 	expected := ns1api.NewClient(nil, ns1api.SetAPIKey(k))
-	client, err := NS1Client("", "", false)
+	client, err := NS1Client("", "", DefaultTransportConfig())
 	if assert.NoError(t, err) {
 		assert.Equal(t, expected.APIKey, client.APIKey)
 	}
 
 	k = "testanotherapikey"
 	expected = ns1api.NewClient(nil, ns1api.SetAPIKey(k))
-	client, err = NS1Client("", k, false)
+	client, err = NS1Client("", k, DefaultTransportConfig())
 	if assert.NoError(t, err) {
 		assert.Equal(t, expected.APIKey, client.APIKey)
 	}
@@ -40,7 +41,7 @@ func TestNS1Client_APIKey(t *testing.T) {
 func TestNS1Client_Endpoint(t *testing.T) {
 	k := "testapikey"
 	expected := ns1api.NewClient(nil, ns1api.SetAPIKey(k))
-	client, err := NS1Client("", k, false)
+	client, err := NS1Client("", k, DefaultTransportConfig())
 	if assert.NoError(t, err) {
 		assert.Equal(t, expected.Endpoint, client.Endpoint)
 		assert.Contains(t, client.UserAgent, "consul-ns1")
@@ -50,7 +51,7 @@ func TestNS1Client_Endpoint(t *testing.T) {
 	expected = ns1api.NewClient(nil,
 		ns1api.SetAPIKey("testapikey"),
 		ns1api.SetEndpoint(endpoint))
-	client, err = NS1Client(endpoint, k, false)
+	client, err = NS1Client(endpoint, k, DefaultTransportConfig())
 	if assert.NoError(t, err) {
 		assert.Equal(t, expected.Endpoint, client.Endpoint)
 		assert.Contains(t, client.UserAgent, "consul-ns1")
@@ -59,18 +60,55 @@ func TestNS1Client_Endpoint(t *testing.T) {
 
 func TestNS1Client_Error(t *testing.T) {
 	defer UnsetEnv()()
-	client, err := NS1Client("http://ns1.endpoint.test/", "", false)
+	client, err := NS1Client("http://ns1.endpoint.test/", "", DefaultTransportConfig())
 	assert.Nil(t, client)
 	assert.Error(t, err)
 }
 
+func TestNS1ReadClient(t *testing.T) {
+	before := os.Getenv("NS1_READ_APIKEY")
+	defer os.Setenv("NS1_READ_APIKEY", before)
+	os.Unsetenv("NS1_READ_APIKEY")
+
+	assert.Nil(t, NS1ReadClient("", "", DefaultTransportConfig()), "reads use the write client without read key")
+
+	os.Setenv("NS1_READ_APIKEY", "testreadapikey")
+	client := NS1ReadClient("", "", DefaultTransportConfig())
+	if assert.NotNil(t, client) {
+		assert.Equal(t, "testreadapikey", client.APIKey)
+		assert.Contains(t, client.UserAgent, "consul-ns1")
+	}
+
+	endpoint := "http://ns1.endpoint.test/"
+	client = NS1ReadClient(endpoint, "testanotherapikey", DefaultTransportConfig())
+	if assert.NotNil(t, client) {
+		assert.Equal(t, "testanotherapikey", client.APIKey)
+		assert.Equal(t, ns1api.NewClient(nil, ns1api.SetEndpoint(endpoint)).Endpoint, client.Endpoint)
+	}
+}
+
 func TestConfigureHTTPDoer(t *testing.T) {
-	expected := http.DefaultClient
-	assert.Equal(t, expected, configureHTTPDoer(false))
+	tc := DefaultTransportConfig()
+	client := configureHTTPDoer(tc)
+	assert.NotEqual(t, http.DefaultClient, client, "Expected a dedicated HTTP client")
+	tr, ok := client.Transport.(*http.Transport)
+	if assert.True(t, ok) {
+		assert.Equal(t, tc.MaxIdleConns, tr.MaxIdleConns)
+		assert.Equal(t, tc.MaxIdleConns, tr.MaxIdleConnsPerHost)
+		assert.Equal(t, tc.IdleConnTimeout, tr.IdleConnTimeout)
+		assert.Nil(t, tr.TLSClientConfig)
+	}
+	assert.Nil(t, http.DefaultClient.Transport, "Expected http.DefaultClient to be left untouched")
 
-	tr := &http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	tc.IgnoreSSL = true
+	tc.MaxIdleConns = 5
+	tc.IdleConnTimeout = time.Second
+	client = configureHTTPDoer(tc)
+	tr, ok = client.Transport.(*http.Transport)
+	if assert.True(t, ok) {
+		assert.Equal(t, 5, tr.MaxIdleConns)
+		assert.Equal(t, time.Second, tr.IdleConnTimeout)
+		assert.Equal(t, &tls.Config{InsecureSkipVerify: true}, tr.TLSClientConfig)
 	}
-	expected.Transport = tr
-	assert.Equal(t, expected, configureHTTPDoer(true))
+	assert.Nil(t, http.DefaultClient.Transport, "Expected http.DefaultClient to be left untouched")
 }
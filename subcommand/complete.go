@@ -0,0 +1,82 @@
+package subcommand
+
+import (
+	"flag"
+	"strings"
+	"time"
+
+	"github.com/posener/complete"
+)
+
+// completionTimeout bounds the NS1 API requests made while completing the command line
+const completionTimeout = 5 * time.Second
+
+// boolFlag is implemented by the values of boolean flags, which take no argument
+type boolFlag interface {
+	IsBoolFlag() bool
+}
+
+// PredictFlags returns the autocompletion predictors of all flags of a flag set. Boolean flags predict
+// nothing and other flags predict anything, unless a predictor is given for them in `predictors`,
+// keyed by the flag name including its leading dash, e.g. "-ns1-domain".
+func PredictFlags(fs *flag.FlagSet, predictors complete.Flags) complete.Flags {
+	flags := complete.Flags{}
+	fs.VisitAll(func(f *flag.Flag) {
+		name := "-" + f.Name
+		if p, ok := predictors[name]; ok {
+			flags[name] = p
+			return
+		}
+		if b, ok := f.Value.(boolFlag); ok && b.IsBoolFlag() {
+			flags[name] = complete.PredictNothing
+			return
+		}
+		flags[name] = complete.PredictAnything
+	})
+	return flags
+}
+
+// PredictZones predicts the names of the zones available to the NS1 API key given by -ns1-apikey on the
+// command line being completed or by the NS1_APIKEY environment variable. Nothing is predicted without a key
+// or if the zones can't be listed.
+func PredictZones() complete.Predictor {
+	return complete.PredictFunc(func(args complete.Args) []string {
+		tc := DefaultTransportConfig()
+		tc.Timeout = completionTimeout
+		tc.IgnoreSSL = flagValue(args.All, "ns1-ignoressl") == "true"
+		client, err := NS1Client(flagValue(args.All, "ns1-endpoint"), flagValue(args.All, "ns1-apikey"), tc)
+		if err != nil {
+			return nil
+		}
+		zones, _, err := client.Zones.List()
+		if err != nil {
+			return nil
+		}
+		names := make([]string, 0, len(zones))
+		for _, z := range zones {
+			names = append(names, z.Zone)
+		}
+		return names
+	})
+}
+
+// flagValue returns the value of the last occurrence of a flag in a command line, given either as
+// "-name=value" or "-name value" with one or two dashes. Boolean flags given as "-name" are "true".
+func flagValue(args []string, name string) string {
+	value := ""
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, "-") {
+			continue
+		}
+		arg = strings.TrimPrefix(strings.TrimPrefix(arg, "-"), "-")
+		switch {
+		case strings.HasPrefix(arg, name+"="):
+			value = strings.TrimPrefix(arg, name+"=")
+		case arg == name && i+1 < len(args) && !strings.HasPrefix(args[i+1], "-"):
+			value = args[i+1]
+		case arg == name:
+			value = "true"
+		}
+	}
+	return value
+}
@@ -0,0 +1,44 @@
+package subcommand
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/posener/complete"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPredictFlags(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.String("ns1-domain", "", "")
+	fs.String("ns1-endpoint", "", "")
+	fs.Bool("stale", false, "")
+	domains := complete.PredictSet("example.com")
+
+	predicted := PredictFlags(fs, complete.Flags{"-ns1-domain": domains})
+	assert.Len(t, predicted, 3)
+	assert.Equal(t, []string{"example.com"}, predicted["-ns1-domain"].Predict(complete.Args{}))
+	assert.NotNil(t, predicted["-ns1-endpoint"], "flags with a value predict anything")
+	assert.Nil(t, predicted["-stale"], "boolean flags predict nothing")
+	assert.Contains(t, predicted, "-stale")
+}
+
+func TestFlagValue(t *testing.T) {
+	type variant struct {
+		args     []string
+		expected string
+	}
+	table := map[string]variant{
+		"missing":         {args: []string{"sync-catalog", "-ns1-domain=example.com"}, expected: ""},
+		"equals":          {args: []string{"sync-catalog", "-ns1-apikey=key"}, expected: "key"},
+		"separate":        {args: []string{"sync-catalog", "-ns1-apikey", "key"}, expected: "key"},
+		"two dashes":      {args: []string{"sync-catalog", "--ns1-apikey=key"}, expected: "key"},
+		"last wins":       {args: []string{"-ns1-apikey=first", "-ns1-apikey=second"}, expected: "second"},
+		"boolean":         {args: []string{"-ns1-apikey", "-ns1-domain"}, expected: "true"},
+		"prefix of other": {args: []string{"-ns1-apikey-file=path"}, expected: ""},
+	}
+	for name, v := range table {
+		assert.Equal(t, v.expected, flagValue(v.args, "ns1-apikey"), fmt.Sprintf("Test case: %s", name))
+	}
+}
@@ -0,0 +1,178 @@
+package export
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/catalog"
+	"github.com/nsone/consul-ns1/subcommand"
+	"github.com/posener/complete"
+)
+
+// Command is the command for exporting the records managed for Consul services
+type Command struct {
+	UI cli.Ui
+
+	flags                 *flag.FlagSet
+	flagHelpFormat        string
+	http                  *flags.HTTPFlags
+	flagFormat            string
+	flagNS1ServicePrefix  string
+	flagNS1Domain         string
+	flagNS1DNSTTL         int64
+	flagNS1DNSTTLJitter   int
+	flagAddressFamily     string
+	flagPortHints         bool
+	flagLowercaseNames    bool
+	flagSanitizeNames     string
+	flagAllowServices     string
+	flagDenyServices      string
+	flagConnectProxies    bool
+	flagProxyServices     bool
+	flagCheckedPortsOnly  bool
+	flagTaggedAddresses   flags.AppendSliceValue
+	flagSRVTargetHosts    bool
+	flagOwnershipRegistry bool
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	c.flags.StringVar(&c.flagFormat, "format", catalog.ExportFormatZoneFile,
+		"The output format. Only \"zonefile\" is supported, an RFC 1035 zone file. (Defaults to zonefile)")
+	c.flags.StringVar(&c.flagNS1ServicePrefix, "ns1-service-prefix", "",
+		"The prefix prepended to all services written to NS1 by sync-catalog.")
+	c.flags.StringVar(&c.flagNS1Domain, "ns1-domain", "",
+		"Name of the DNS domain in NS1 services are synced to.")
+	c.flags.Int64Var(&c.flagNS1DNSTTL, "ns1-dns-ttl", 60,
+		"The -ns1-dns-ttl used by sync-catalog. (Defaults to 60)")
+	c.flags.IntVar(&c.flagNS1DNSTTLJitter, "ns1-dns-ttl-jitter", 0,
+		"The -ns1-dns-ttl-jitter used by sync-catalog. (Defaults to 0)")
+	c.flags.StringVar(&c.flagAddressFamily, "address-family", "ipv4",
+		"The -address-family used by sync-catalog. (Defaults to ipv4)")
+	c.flags.BoolVar(&c.flagPortHints, "ns1-port-hints", false,
+		"The -ns1-port-hints setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagLowercaseNames, "lowercase-service-names", false,
+		"The -lowercase-service-names setting used by sync-catalog. (Defaults to false)")
+	c.flags.StringVar(&c.flagSanitizeNames, "sanitize-service-names", "",
+		"The -sanitize-service-names setting used by sync-catalog. (Defaults to none)")
+	c.flags.StringVar(&c.flagAllowServices, "allow-services", "",
+		"The -allow-services setting used by sync-catalog. (Defaults to none)")
+	c.flags.StringVar(&c.flagDenyServices, "deny-services", "",
+		"The -deny-services setting used by sync-catalog. (Defaults to none)")
+	c.flags.BoolVar(&c.flagConnectProxies, "publish-connect-proxies", false,
+		"The -publish-connect-proxies setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagProxyServices, "publish-proxy-services", false,
+		"The -publish-proxy-services setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagCheckedPortsOnly, "publish-checked-ports-only", false,
+		"The -publish-checked-ports-only setting used by sync-catalog. (Defaults to false)")
+	c.flags.Var(&c.flagTaggedAddresses, "publish-tagged-address",
+		"The -publish-tagged-address tags used by sync-catalog. May be specified multiple times.")
+	c.flags.BoolVar(&c.flagSRVTargetHosts, "ns1-srv-target-hostnames", false,
+		"The -ns1-srv-target-hostnames setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagOwnershipRegistry, "ns1-ownership-registry", false,
+		"The -ns1-ownership-registry setting used by sync-catalog. (Defaults to false)")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	subcommand.HelpFormatVar(c.flags, &c.flagHelpFormat)
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run writes the records managed for the Consul services to stdout in the requested format
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if c.flagHelpFormat != "" {
+		return subcommand.PrintHelp(c.UI, "export", c.flags, c.flagHelpFormat)
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagNS1Domain == "" {
+		c.UI.Error("Please provide -ns1-domain")
+		return 1
+	}
+	if c.flagFormat != catalog.ExportFormatZoneFile {
+		c.UI.Error(fmt.Sprintf("Unknown -format %q, must be %q", c.flagFormat, catalog.ExportFormatZoneFile))
+		return 1
+	}
+
+	consulClient, err := c.http.APIClient()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	cfg := catalog.Config{
+		NS1Prefix:             c.flagNS1ServicePrefix,
+		NS1Domain:             c.flagNS1Domain,
+		NS1DNSTTL:             c.flagNS1DNSTTL,
+		NS1DNSTTLJitter:       c.flagNS1DNSTTLJitter,
+		Stale:                 true,
+		AddressFamily:         c.flagAddressFamily,
+		PortHints:             c.flagPortHints,
+		LowercaseServiceNames: c.flagLowercaseNames,
+		SanitizeServiceNames:  c.flagSanitizeNames,
+		AllowServices:         c.flagAllowServices,
+		DenyServices:          c.flagDenyServices,
+		PublishConnectProxies: c.flagConnectProxies,
+		PublishProxyServices:  c.flagProxyServices,
+		CheckedPortsOnly:      c.flagCheckedPortsOnly,
+		TaggedAddresses:       c.flagTaggedAddresses,
+		SRVTargetHostnames:    c.flagSRVTargetHosts,
+		OwnershipRegistry:     c.flagOwnershipRegistry,
+	}
+	var buf bytes.Buffer
+	if err := catalog.Export(cfg, consulClient, c.flagFormat, &buf); err != nil {
+		c.UI.Error(fmt.Sprintf("Error exporting records: %s", err))
+		return 1
+	}
+	c.UI.Output(strings.TrimSuffix(buf.String(), "\n"))
+	return 0
+}
+
+// Synopsis returns a short description of the program
+func (c *Command) Synopsis() string { return synopsis }
+
+// Help returns usage info for the program
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+// AutocompleteFlags returns the predictors of the flags of the program
+func (c *Command) AutocompleteFlags() complete.Flags {
+	c.once.Do(c.init)
+	return subcommand.PredictFlags(c.flags, complete.Flags{
+		"-help-format":    complete.PredictSet(subcommand.HelpFormatJSON),
+		"-format":         complete.PredictSet(catalog.ExportFormatZoneFile),
+		"-ns1-domain":     subcommand.PredictZones(),
+		"-address-family": complete.PredictSet("ipv4", "ipv6", "dual"),
+	})
+}
+
+// AutocompleteArgs returns the predictor of the arguments of the program, which takes none
+func (c *Command) AutocompleteArgs() complete.Predictor { return complete.PredictNothing }
+
+const synopsis = "Export the records managed for Consul services."
+const help = `
+Usage: consul-ns1 export [options]
+
+  Write the records sync-catalog manages for the services registered in
+  Consul to stdout, e.g. as a zone file to diff against the output of a zone
+  transfer or to import into another DNS provider. Nothing is written to NS1.
+
+`
@@ -0,0 +1,103 @@
+package subcommand
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+)
+
+const (
+	// helpFormatFlag is the name of the flag printing the flags of a subcommand instead of running it
+	helpFormatFlag = "help-format"
+	// HelpFormatJSON is the only supported value of -help-format
+	HelpFormatJSON = "json"
+)
+
+// FlagSchema describes a flag of a subcommand in the JSON help output
+type FlagSchema struct {
+	Name    string `json:"name"`
+	Type    string `json:"type"`
+	Default string `json:"default"`
+	Usage   string `json:"usage"`
+}
+
+// CommandSchema describes a subcommand and its flags in the JSON help output
+type CommandSchema struct {
+	Command string       `json:"command"`
+	Flags   []FlagSchema `json:"flags"`
+}
+
+// HelpFormatVar defines the -help-format flag on a flag set
+func HelpFormatVar(fs *flag.FlagSet, p *string) {
+	fs.StringVar(p, helpFormatFlag, "",
+		"Prints the flags of the command in the given format instead of running it. Only \"json\" is supported.")
+}
+
+// PrintHelp prints the schema of the flags of a subcommand in the format given by -help-format and returns
+// the exit code of the subcommand.
+func PrintHelp(ui cli.Ui, command string, fs *flag.FlagSet, format string) int {
+	if format != HelpFormatJSON {
+		ui.Error(fmt.Sprintf("Unsupported -help-format %q, only %q is supported.", format, HelpFormatJSON))
+		return 1
+	}
+	out, err := json.MarshalIndent(Schema(command, fs), "", "  ")
+	if err != nil {
+		ui.Error(fmt.Sprintf("Error encoding help: %s", err))
+		return 1
+	}
+	ui.Output(string(out))
+	return 0
+}
+
+// Schema returns the schema of the flags of a subcommand in lexical order, leaving -help-format out
+func Schema(command string, fs *flag.FlagSet) CommandSchema {
+	schema := CommandSchema{Command: command, Flags: []FlagSchema{}}
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == helpFormatFlag {
+			return
+		}
+		schema.Flags = append(schema.Flags, FlagSchema{
+			Name:    f.Name,
+			Type:    flagType(f.Value),
+			Default: f.DefValue,
+			Usage:   f.Usage,
+		})
+	})
+	return schema
+}
+
+// flagType returns the type of the value of a flag: "bool", "int", "uint", "float", "duration", "list" for
+// flags that may be given several times, or "string".
+func flagType(v flag.Value) string {
+	switch v.(type) {
+	case *flags.BoolValue:
+		return "bool"
+	case *flags.UintValue:
+		return "uint"
+	case *flags.DurationValue:
+		return "duration"
+	case *flags.AppendSliceValue:
+		return "list"
+	}
+	g, ok := v.(flag.Getter)
+	if !ok {
+		return "string"
+	}
+	switch g.Get().(type) {
+	case bool:
+		return "bool"
+	case int, int64:
+		return "int"
+	case uint, uint64:
+		return "uint"
+	case float64:
+		return "float"
+	case time.Duration:
+		return "duration"
+	}
+	return "string"
+}
@@ -0,0 +1,49 @@
+package subcommand
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchema(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	var format string
+	var windows flags.AppendSliceValue
+	fs.String("ns1-domain", "", "The domain.")
+	fs.Int64("ns1-dns-ttl", 60, "The TTL.")
+	fs.Duration("consul-write-interval", 30*time.Second, "The interval.")
+	fs.Var(&windows, "freeze-window", "A window.")
+	http := &flags.HTTPFlags{}
+	flags.Merge(fs, http.ServerFlags())
+	HelpFormatVar(fs, &format)
+
+	schema := Schema("sync-catalog", fs)
+	assert.Equal(t, "sync-catalog", schema.Command)
+	assert.Equal(t, []FlagSchema{
+		{Name: "consul-write-interval", Type: "duration", Default: "30s", Usage: "The interval."},
+		{Name: "datacenter", Type: "string", Default: "", Usage: fs.Lookup("datacenter").Usage},
+		{Name: "freeze-window", Type: "list", Default: "", Usage: "A window."},
+		{Name: "ns1-dns-ttl", Type: "int", Default: "60", Usage: "The TTL."},
+		{Name: "ns1-domain", Type: "string", Default: "", Usage: "The domain."},
+		{Name: "stale", Type: "bool", Default: "false", Usage: fs.Lookup("stale").Usage},
+	}, schema.Flags, "-help-format is left out")
+}
+
+func TestPrintHelp(t *testing.T) {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.Bool("dry-run", false, "Dry run.")
+
+	ui := cli.NewMockUi()
+	assert.Equal(t, 0, PrintHelp(ui, "zones", fs, HelpFormatJSON))
+	assert.JSONEq(t, `{"command": "zones", "flags": [{"name": "dry-run", "type": "bool", "default": "false", "usage": "Dry run."}]}`,
+		ui.OutputWriter.String())
+
+	ui = cli.NewMockUi()
+	assert.Equal(t, 1, PrintHelp(ui, "zones", fs, "yaml"))
+	assert.Empty(t, ui.OutputWriter.String())
+}
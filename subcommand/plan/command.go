@@ -0,0 +1,298 @@
+package plan
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/catalog"
+	"github.com/nsone/consul-ns1/subcommand"
+	"github.com/posener/complete"
+)
+
+// ANSI escape codes of the colors of the changes
+const (
+	colorReset  = "\x1b[0m"
+	colorGreen  = "\x1b[32m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorBold   = "\x1b[1m"
+)
+
+// symbols and colors of the actions planned
+var actionSymbols = map[string]string{
+	catalog.PlanCreate: "+",
+	catalog.PlanUpdate: "~",
+	catalog.PlanDelete: "-",
+}
+var actionColors = map[string]string{
+	catalog.PlanCreate: colorGreen,
+	catalog.PlanUpdate: colorYellow,
+	catalog.PlanDelete: colorRed,
+}
+
+// Command is the command for showing the changes the next sync would make
+type Command struct {
+	UI cli.Ui
+
+	flags                 *flag.FlagSet
+	flagHelpFormat        string
+	http                  *flags.HTTPFlags
+	flagOutput            string
+	flagNoColor           bool
+	flagNS1ServicePrefix  string
+	flagNS1Domain         string
+	flagNS1Endpoint       string
+	flagNS1APIKey         string
+	flagNS1IgnoreSSL      bool
+	flagNS1DNSTTL         int64
+	flagNS1DNSTTLJitter   int
+	flagHealthAggregation string
+	flagIgnoreNodeChecks  bool
+	flagOnlyPassing       bool
+	flagWarningPolicy     string
+	flagAddressFamily     string
+	flagPortHints         bool
+	flagOwnershipRegistry bool
+	flagLowercaseNames    bool
+	flagSanitizeNames     string
+	flagAllowServices     string
+	flagDenyServices      string
+	flagConnectProxies    bool
+	flagProxyServices     bool
+	flagCheckedPortsOnly  bool
+	flagTaggedAddresses   flags.AppendSliceValue
+	flagSRVTargetHosts    bool
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	c.flags.StringVar(&c.flagOutput, "output", "text",
+		"The format of the plan, \"text\" for a diff grouped by service or \"json\". (Defaults to text)")
+	c.flags.BoolVar(&c.flagNoColor, "no-color", false,
+		"Don't color the text output. Colors are also disabled by the NO_COLOR environment variable. "+
+			"(Defaults to false)")
+	c.flags.StringVar(&c.flagNS1ServicePrefix, "ns1-service-prefix", "",
+		"The prefix prepended to all services written to NS1 by sync-catalog.")
+	c.flags.StringVar(&c.flagNS1Domain, "ns1-domain", "",
+		"Name of the DNS domain in NS1 services are synced to.")
+	c.flags.StringVar(&c.flagNS1Endpoint, "ns1-endpoint", "",
+		"The absolute URL of the NS1 API endpoint. (Defaults to https://api.nsone.net/v1/)")
+	c.flags.StringVar(&c.flagNS1APIKey, "ns1-apikey", "",
+		"The API key to use when communicating with NS1.  This can also be specified via the "+
+			"NS1_APIKEY environment variable.")
+	c.flags.BoolVar(&c.flagNS1IgnoreSSL, "ns1-ignoressl", false,
+		"Ignore SSL validation when communicating with NS1. (Defaults to false)")
+	c.flags.Int64Var(&c.flagNS1DNSTTL, "ns1-dns-ttl", 60,
+		"The -ns1-dns-ttl used by sync-catalog. (Defaults to 60)")
+	c.flags.IntVar(&c.flagNS1DNSTTLJitter, "ns1-dns-ttl-jitter", 0,
+		"The -ns1-dns-ttl-jitter used by sync-catalog. (Defaults to 0)")
+	c.flags.StringVar(&c.flagHealthAggregation, "health-aggregation", "worst",
+		"The -health-aggregation policy used by sync-catalog. (Defaults to worst)")
+	c.flags.BoolVar(&c.flagIgnoreNodeChecks, "ignore-node-checks", false,
+		"The -ignore-node-checks setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagOnlyPassing, "only-passing", false,
+		"The -only-passing setting used by sync-catalog. (Defaults to false)")
+	c.flags.StringVar(&c.flagWarningPolicy, "warning-policy", "exclude",
+		"The -warning-policy used by sync-catalog. (Defaults to exclude)")
+	c.flags.StringVar(&c.flagAddressFamily, "address-family", "ipv4",
+		"The -address-family used by sync-catalog. (Defaults to ipv4)")
+	c.flags.BoolVar(&c.flagPortHints, "ns1-port-hints", false,
+		"The -ns1-port-hints setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagOwnershipRegistry, "ns1-ownership-registry", false,
+		"The -ns1-ownership-registry setting used by sync-catalog, services without an ownership record "+
+			"aren't deleted. (Defaults to false)")
+	c.flags.BoolVar(&c.flagLowercaseNames, "lowercase-service-names", false,
+		"The -lowercase-service-names setting used by sync-catalog. (Defaults to false)")
+	c.flags.StringVar(&c.flagSanitizeNames, "sanitize-service-names", "",
+		"The -sanitize-service-names setting used by sync-catalog. (Defaults to none)")
+	c.flags.StringVar(&c.flagAllowServices, "allow-services", "",
+		"The -allow-services setting used by sync-catalog. (Defaults to none)")
+	c.flags.StringVar(&c.flagDenyServices, "deny-services", "",
+		"The -deny-services setting used by sync-catalog. (Defaults to none)")
+	c.flags.BoolVar(&c.flagConnectProxies, "publish-connect-proxies", false,
+		"The -publish-connect-proxies setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagProxyServices, "publish-proxy-services", false,
+		"The -publish-proxy-services setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagCheckedPortsOnly, "publish-checked-ports-only", false,
+		"The -publish-checked-ports-only setting used by sync-catalog. (Defaults to false)")
+	c.flags.Var(&c.flagTaggedAddresses, "publish-tagged-address",
+		"The -publish-tagged-address tags used by sync-catalog. May be specified multiple times.")
+	c.flags.BoolVar(&c.flagSRVTargetHosts, "ns1-srv-target-hostnames", false,
+		"The -ns1-srv-target-hostnames setting used by sync-catalog. (Defaults to false)")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	subcommand.HelpFormatVar(c.flags, &c.flagHelpFormat)
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run compares the Consul services with the records in NS1 and shows the changes the next sync would make
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if c.flagHelpFormat != "" {
+		return subcommand.PrintHelp(c.UI, "plan", c.flags, c.flagHelpFormat)
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagNS1Domain == "" {
+		c.UI.Error("Please provide -ns1-domain")
+		return 1
+	}
+	if c.flagOutput != "text" && c.flagOutput != "json" {
+		c.UI.Error(fmt.Sprintf("Invalid -output %q, must be text or json", c.flagOutput))
+		return 1
+	}
+
+	tc := subcommand.DefaultTransportConfig()
+	tc.IgnoreSSL = c.flagNS1IgnoreSSL
+	ns1Client, err := subcommand.NS1Client(c.flagNS1Endpoint, c.flagNS1APIKey, tc)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error retrieving NS1 client: %s", err))
+		return 1
+	}
+	consulClient, err := c.http.APIClient()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	cfg := catalog.Config{
+		NS1Prefix:             c.flagNS1ServicePrefix,
+		NS1Domain:             c.flagNS1Domain,
+		NS1DNSTTL:             c.flagNS1DNSTTL,
+		NS1DNSTTLJitter:       c.flagNS1DNSTTLJitter,
+		Stale:                 true,
+		HealthAggregation:     c.flagHealthAggregation,
+		IgnoreNodeChecks:      c.flagIgnoreNodeChecks,
+		OnlyPassing:           c.flagOnlyPassing,
+		WarningPolicy:         c.flagWarningPolicy,
+		AddressFamily:         c.flagAddressFamily,
+		PortHints:             c.flagPortHints,
+		OwnershipRegistry:     c.flagOwnershipRegistry,
+		LowercaseServiceNames: c.flagLowercaseNames,
+		SanitizeServiceNames:  c.flagSanitizeNames,
+		AllowServices:         c.flagAllowServices,
+		DenyServices:          c.flagDenyServices,
+		PublishConnectProxies: c.flagConnectProxies,
+		PublishProxyServices:  c.flagProxyServices,
+		CheckedPortsOnly:      c.flagCheckedPortsOnly,
+		TaggedAddresses:       c.flagTaggedAddresses,
+		SRVTargetHostnames:    c.flagSRVTargetHosts,
+	}
+	plans, err := catalog.Plan(cfg, ns1Client, consulClient)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error planning changes: %s", err))
+		return 1
+	}
+
+	if c.flagOutput == "json" {
+		b, err := json.MarshalIndent(plans, "", "  ")
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error encoding plan: %s", err))
+			return 1
+		}
+		c.UI.Output(string(b))
+		return 0
+	}
+	_, noColor := os.LookupEnv("NO_COLOR")
+	c.UI.Output(formatPlans(plans, !c.flagNoColor && !noColor))
+	return 0
+}
+
+// formatPlans returns the changes of each service as a diff of its answers, followed by a summary
+func formatPlans(plans []catalog.ServicePlan, color bool) string {
+	paint := func(code, s string) string {
+		if !color || code == "" {
+			return s
+		}
+		return code + s + colorReset
+	}
+	var buf bytes.Buffer
+	counts := map[string]int{}
+	for _, p := range plans {
+		counts[p.Action]++
+		fmt.Fprintf(&buf, "%s\n", paint(colorBold+actionColors[p.Action],
+			fmt.Sprintf("%s %s (%s)", actionSymbols[p.Action], p.Service, p.Domain)))
+		for _, r := range p.Records {
+			ttl := fmt.Sprintf("ttl %d", r.TTL)
+			switch {
+			case r.Action == catalog.PlanDelete:
+				ttl = fmt.Sprintf("ttl %d", r.OldTTL)
+			case r.OldTTL != 0:
+				ttl = fmt.Sprintf("ttl %d -> %d", r.OldTTL, r.TTL)
+			}
+			fmt.Fprintf(&buf, "  %s\n", paint(actionColors[r.Action],
+				fmt.Sprintf("%s %s %s", actionSymbols[r.Action], r.Type, ttl)))
+			for _, a := range r.Added {
+				fmt.Fprintf(&buf, "      %s\n", paint(colorGreen, "+ "+a))
+			}
+			for _, a := range r.Removed {
+				fmt.Fprintf(&buf, "      %s\n", paint(colorRed, "- "+a))
+			}
+			for _, a := range r.Kept {
+				fmt.Fprintf(&buf, "        %s\n", a)
+			}
+		}
+		buf.WriteString("\n")
+	}
+	if len(plans) == 0 {
+		buf.WriteString("No changes, NS1 matches the Consul catalog.")
+		return buf.String()
+	}
+	fmt.Fprintf(&buf, "Plan: %s to create, %s to update, %s to delete.",
+		paint(colorGreen, fmt.Sprint(counts[catalog.PlanCreate])),
+		paint(colorYellow, fmt.Sprint(counts[catalog.PlanUpdate])),
+		paint(colorRed, fmt.Sprint(counts[catalog.PlanDelete])))
+	return buf.String()
+}
+
+// Synopsis returns a short description of the program
+func (c *Command) Synopsis() string { return synopsis }
+
+// Help returns usage info for the program
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+// AutocompleteFlags returns the predictors of the flags of the program
+func (c *Command) AutocompleteFlags() complete.Flags {
+	c.once.Do(c.init)
+	return subcommand.PredictFlags(c.flags, complete.Flags{
+		"-help-format":        complete.PredictSet(subcommand.HelpFormatJSON),
+		"-output":             complete.PredictSet("text", "json"),
+		"-ns1-domain":         subcommand.PredictZones(),
+		"-health-aggregation": complete.PredictSet("worst", "best"),
+		"-address-family":     complete.PredictSet("ipv4", "ipv6", "dual"),
+		"-warning-policy":     complete.PredictSet("exclude", "publish"),
+	})
+}
+
+// AutocompleteArgs returns the predictor of the arguments of the program, which takes none
+func (c *Command) AutocompleteArgs() complete.Predictor { return complete.PredictNothing }
+
+const synopsis = "Show the changes the next sync would make to NS1."
+const help = `
+Usage: consul-ns1 plan [options]
+
+  Compare the Consul catalog with the records in NS1 and show the answers
+  the next sync would add and remove, grouped by service, without changing
+  anything. Use -output=json for a machine-readable plan.
+
+`
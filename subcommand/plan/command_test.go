@@ -0,0 +1,46 @@
+package plan
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/catalog"
+	"github.com/nsone/consul-ns1/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_Flags(t *testing.T) {
+	for _, args := range [][]string{{"-unknown"}, {"-ns1-domain=example.com", "extra"}, {},
+		{"-ns1-domain=example.com", "-output=yaml"}} {
+		ui := cli.NewMockUi()
+		cmd := Command{UI: ui}
+		assert.Equal(t, 1, cmd.Run(append([]string{"-ns1-apikey=fake"}, args...)), args)
+	}
+}
+
+func TestRun(t *testing.T) {
+	fakeNS1, fakeConsul := testutil.NewNS1(), testutil.NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(testutil.Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+	args := []string{"-ns1-endpoint=" + fakeNS1.Endpoint(), "-ns1-apikey=fake", "-http-addr=" + fakeConsul.Address(),
+		"-ns1-domain=example.com"}
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	assert.Equal(t, 0, cmd.Run(append(args, "-no-color")))
+	assert.Contains(t, ui.OutputWriter.String(), "+ web (web.example.com)\n  + A ttl 60\n      + 1.1.1.1\n")
+	assert.Contains(t, ui.OutputWriter.String(), "Plan: 1 to create, 0 to update, 0 to delete.")
+
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui}
+	assert.Equal(t, 0, cmd.Run(append(args, "-output=json")))
+	plans := []catalog.ServicePlan{}
+	if assert.NoError(t, json.Unmarshal(ui.OutputWriter.Bytes(), &plans)) && assert.Len(t, plans, 1) {
+		assert.Equal(t, catalog.PlanCreate, plans[0].Action)
+	}
+	// nothing is written to NS1
+	assert.Equal(t, 0, fakeNS1.Writes())
+}
@@ -0,0 +1,171 @@
+package services
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/catalog"
+	"github.com/nsone/consul-ns1/subcommand"
+	"github.com/posener/complete"
+)
+
+// Command is the command for previewing where Consul services are published in NS1
+type Command struct {
+	UI cli.Ui
+
+	flags                *flag.FlagSet
+	flagHelpFormat       string
+	http                 *flags.HTTPFlags
+	flagNS1ServicePrefix string
+	flagNS1Domain        string
+	flagAddressFamily    string
+	flagPortHints        bool
+	flagLowercaseNames   bool
+	flagSanitizeNames    string
+	flagAllowServices    string
+	flagDenyServices     string
+	flagConnectProxies   bool
+	flagProxyServices    bool
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	c.flags.StringVar(&c.flagNS1ServicePrefix, "ns1-service-prefix", "",
+		"The prefix prepended to all services written to NS1 by sync-catalog.")
+	c.flags.StringVar(&c.flagNS1Domain, "ns1-domain", "",
+		"Name of the DNS domain in NS1 services are synced to.")
+	c.flags.StringVar(&c.flagAddressFamily, "address-family", "ipv4",
+		"The -address-family used by sync-catalog. (Defaults to ipv4)")
+	c.flags.BoolVar(&c.flagPortHints, "ns1-port-hints", false,
+		"The -ns1-port-hints setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagLowercaseNames, "lowercase-service-names", false,
+		"The -lowercase-service-names setting used by sync-catalog. (Defaults to false)")
+	c.flags.StringVar(&c.flagSanitizeNames, "sanitize-service-names", "",
+		"The -sanitize-service-names setting used by sync-catalog. (Defaults to none)")
+	c.flags.StringVar(&c.flagAllowServices, "allow-services", "",
+		"The -allow-services setting used by sync-catalog. (Defaults to none)")
+	c.flags.StringVar(&c.flagDenyServices, "deny-services", "",
+		"The -deny-services setting used by sync-catalog. (Defaults to none)")
+	c.flags.BoolVar(&c.flagConnectProxies, "publish-connect-proxies", false,
+		"The -publish-connect-proxies setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagProxyServices, "publish-proxy-services", false,
+		"The -publish-proxy-services setting used by sync-catalog. (Defaults to false)")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	subcommand.HelpFormatVar(c.flags, &c.flagHelpFormat)
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run lists the Consul services with the NS1 domains they are published at
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if c.flagHelpFormat != "" {
+		return subcommand.PrintHelp(c.UI, "services", c.flags, c.flagHelpFormat)
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagNS1Domain == "" {
+		c.UI.Error("Please provide -ns1-domain")
+		return 1
+	}
+
+	consulClient, err := c.http.APIClient()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	cfg := catalog.Config{
+		NS1Prefix:             c.flagNS1ServicePrefix,
+		NS1Domain:             c.flagNS1Domain,
+		Stale:                 true,
+		AddressFamily:         c.flagAddressFamily,
+		PortHints:             c.flagPortHints,
+		LowercaseServiceNames: c.flagLowercaseNames,
+		SanitizeServiceNames:  c.flagSanitizeNames,
+		AllowServices:         c.flagAllowServices,
+		DenyServices:          c.flagDenyServices,
+		PublishConnectProxies: c.flagConnectProxies,
+		PublishProxyServices:  c.flagProxyServices,
+	}
+	previews, err := catalog.Preview(cfg, consulClient)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error fetching services: %s", err))
+		return 1
+	}
+	if len(previews) == 0 {
+		c.UI.Warn("No services are registered in Consul")
+		return 0
+	}
+	c.UI.Output(formatPreviews(previews))
+	return 0
+}
+
+// formatPreviews returns a table of services with the domain and records they are published as
+func formatPreviews(previews []catalog.ServicePreview) string {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "SERVICE\tDOMAIN\tRECORDS\tNOTES")
+	for _, p := range previews {
+		domain, notes := p.Domain, ""
+		switch {
+		case p.Conflict != "":
+			domain, notes = "-", fmt.Sprintf("not published, name only differs by case from %s", p.Conflict)
+		case domain == "":
+			// e.g. Connect proxies published under the name of the service they front
+			domain, notes = "-", "not published"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Service, domain, strings.Join(p.Records, ","), notes)
+	}
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Synopsis returns a short description of the program
+func (c *Command) Synopsis() string { return synopsis }
+
+// Help returns usage info for the program
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+// AutocompleteFlags returns the predictors of the flags of the program
+func (c *Command) AutocompleteFlags() complete.Flags {
+	c.once.Do(c.init)
+	return subcommand.PredictFlags(c.flags, complete.Flags{
+		"-help-format":    complete.PredictSet(subcommand.HelpFormatJSON),
+		"-ns1-domain":     subcommand.PredictZones(),
+		"-address-family": complete.PredictSet("ipv4", "ipv6", "dual"),
+	})
+}
+
+// AutocompleteArgs returns the predictor of the arguments of the program, which takes none
+func (c *Command) AutocompleteArgs() complete.Predictor { return complete.PredictNothing }
+
+const synopsis = "Preview the NS1 domains Consul services are published at."
+const help = `
+Usage: consul-ns1 services [options]
+
+  List the services registered in Consul with the NS1 domain and the types
+  of records each of them would be published as, applying the prefix and
+  naming rules of sync-catalog. Nothing is written to NS1.
+
+`
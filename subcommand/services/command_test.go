@@ -0,0 +1,29 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_Flags(t *testing.T) {
+	for _, args := range [][]string{{"-unknown"}, {"-ns1-domain=example.com", "extra"}, {}} {
+		ui := cli.NewMockUi()
+		cmd := Command{UI: ui}
+		assert.Equal(t, 1, cmd.Run(args), args)
+	}
+}
+
+func TestRun(t *testing.T) {
+	fakeConsul := testutil.NewConsul()
+	defer fakeConsul.Close()
+	fakeConsul.Register(testutil.Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	assert.Equal(t, 0, cmd.Run([]string{"-http-addr=" + fakeConsul.Address(), "-ns1-domain=example.com",
+		"-ns1-service-prefix=p-"}))
+	assert.Contains(t, ui.OutputWriter.String(), "web      p-web.example.com  A,SRV")
+}
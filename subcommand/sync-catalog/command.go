@@ -6,26 +6,108 @@ import (
 	"os"
 	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/hashicorp/consul/command/flags"
 	"github.com/mitchellh/cli"
 	"github.com/nsone/consul-ns1/catalog"
 	"github.com/nsone/consul-ns1/subcommand"
+	"github.com/posener/complete"
 )
 
 // Command is the command for syncing the A
 type Command struct {
 	UI cli.Ui
 
-	flags                *flag.FlagSet
-	http                 *flags.HTTPFlags
-	flagNS1ServicePrefix string
-	flagNS1PollInterval  string
-	flagNS1DNSTTL        int64
-	flagNS1Endpoint      string
-	flagNS1Domain        string
-	flagNS1APIKey        string
-	flagNS1IgnoreSSL     bool
+	flags                    *flag.FlagSet
+	flagHelpFormat           string
+	http                     *flags.HTTPFlags
+	flagNS1ServicePrefix     string
+	flagNS1PollInterval      string
+	flagNS1PrefixSearch      bool
+	flagNS1DNSTTL            int64
+	flagNS1DNSTTLJitter      int
+	flagNS1AnswerSeed        string
+	flagNS1Endpoint          string
+	flagNS1Domain            string
+	flagCreateZone           bool
+	flagZoneRouting          bool
+	flagDefaultZone          string
+	flagNS1APIKey            string
+	flagNS1ReadAPIKey        string
+	flagNS1IgnoreSSL         bool
+	flagNS1MaxIdleConns      int
+	flagNS1IdleTimeout       string
+	flagNS1KeepAlive         string
+	flagHealthAggregation    string
+	flagIgnoreNodeChecks     bool
+	flagOnlyPassing          bool
+	flagUpFilter             bool
+	flagUpFeeds              bool
+	flagSyncMonitors         bool
+	flagMonitorRegions       flags.AppendSliceValue
+	flagWarningPolicy        string
+	flagPortHints            bool
+	flagMaxRecords           int
+	flagMaxAnswers           int
+	flagAddressFamily        string
+	flagOwnershipRegistry    bool
+	flagRecordMarker         bool
+	flagConflictPolicy       string
+	flagEditPolicy           string
+	flagEmptyAnswerPolicy    string
+	flagTemplateRecord       string
+	flagFilters              string
+	flagCoManaged            bool
+	flagSyncerID             string
+	flagWeightedAnswers      bool
+	flagRolloutsKVPrefix     string
+	flagFeatureFlagsKVPrefix string
+	flagGeoMetadata          bool
+	flagVersionNotes         bool
+	flagGeoRegions           flags.AppendSliceValue
+	flagGeotargetRegional    bool
+	flagDatacenterRegions    bool
+	flagTaggedAddresses      flags.AppendSliceValue
+	flagResyncEvent          string
+	flagResyncKey            string
+	flagFiltersKVPrefix      string
+	flagUseClientSubnet      string
+	flagFreezeWindows        flags.AppendSliceValue
+	flagApprovalThreshold    int
+	flagApprovalFile         string
+	flagApprovalKey          string
+	flagAdminAddr            string
+	flagStallFactor          int
+	flagRestartStalled       bool
+	flagRegistryGCInterval   string
+	flagMinQueryInterval     string
+	flagLowercaseNames       bool
+	flagSanitizeNames        string
+	flagAllowServices        string
+	flagDenyServices         string
+	flagConnectProxies       bool
+	flagProxyServices        bool
+	flagCheckedPortsOnly     bool
+	flagSRVTargetHosts       bool
+	flagDCSubdomains         bool
+	flagTagSubdomains        bool
+	flagDomainTemplate       string
+	flagDeregister           bool
+	flagLeaderLockKey        string
+	flagJournalFile          string
+	flagStateFile            string
+	flagChurnThreshold       int
+	flagSyncHealthService    string
+	flagInstanceCount        bool
+	flagAccountMaxRecords    int
+	flagAccountMaxQPS        float64
+	flagAPIRate              float64
+	flagAPIReadWeight        float64
+	flagAPIWriteWeight       float64
+	flagAccountInterval      string
+	flagPauseCreates         bool
 
 	once sync.Once
 	help string
@@ -40,24 +122,360 @@ func (c *Command) init() {
 	c.flags.StringVar(&c.flagNS1PollInterval, "ns1-poll-interval",
 		"30s", "The interval between fetching from NS1. "+
 			"Accepts a sequence of decimal numbers, each with optional "+
-			"fraction and a unit suffix, such as \"300ms\", \"10s\", \"1.5m\". "+
+			"fraction and a unit suffix, such as \"10s\" or \"1.5m\", of at least 1s. "+
+			"It can be changed at runtime through the admin API, and SIGHUP restores it. "+
 			"(Defaults to 30s)")
+	c.flags.BoolVar(&c.flagNS1PrefixSearch, "ns1-prefix-search", false,
+		"Poll only the records under -ns1-service-prefix with the NS1 search API instead of the whole zone, "+
+			"for zones holding many records that aren't managed. The whole zone is fetched when the search fails. "+
+			"Requires -ns1-service-prefix, and can't be combined with -ns1-account-max-records. (Defaults to false)")
 	c.flags.Int64Var(&c.flagNS1DNSTTL, "ns1-dns-ttl",
 		60, "DNS TTL for services created in NS1 in seconds. (Defaults to 60)")
+	c.flags.IntVar(&c.flagNS1DNSTTLJitter, "ns1-dns-ttl-jitter", 0,
+		"Deviate the TTL of each record from -ns1-dns-ttl by up to this percentage, at most 50, so records "+
+			"don't expire in lockstep across resolvers. The deviation is derived from the name and type of the "+
+			"record, so a record always gets the same TTL. 0 disables jitter. (Defaults to 0)")
+	c.flags.StringVar(&c.flagNS1AnswerSeed, "ns1-answer-seed", "",
+		"Order the answers of each A, AAAA and SRV record by a hash of this seed, the record and the answer "+
+			"instead of sorting them. The order is stable across syncs and answers keep their relative order as "+
+			"others are added or removed, so filters picking the first answers, e.g. select_first_n, don't favour "+
+			"the same addresses in every record. If this is not set then answers are sorted.")
 	c.flags.StringVar(&c.flagNS1Endpoint, "ns1-endpoint", "",
 		"The absolute URL of the NS1 API endpoint. (Defaults to https://api.nsone.net/v1/)")
 	c.flags.StringVar(&c.flagNS1Domain, "ns1-domain", "",
 		"Name of the DNS domain in NS1 to create records for Consul services in. "+
-			"WARNING: consul-ns1 will delete any records in this zone that do not correspond to a Consul service.")
+			"WARNING: consul-ns1 will delete any records in this zone that do not correspond to a Consul service, "+
+			"unless -ns1-record-marker or -ns1-ownership-registry is set.")
+	c.flags.BoolVar(&c.flagCreateZone, "ns1-create-zone", false,
+		"Create the zone named by -ns1-domain when it doesn't exist in NS1 instead of exiting, with the SOA "+
+			"defaults of NS1 and the nameservers NS1 assigns to it, e.g. for ephemeral environments. "+
+			"The zone isn't deleted on shutdown. (Defaults to false)")
+	c.flags.BoolVar(&c.flagZoneRouting, "ns1-zone-routing", false,
+		"Only sync the services routed to -ns1-domain by their \"ns1-zone\" service meta or "+
+			"\"ns1-zone=<zone>\" tag, so the services of a catalog can be split between zones, each synced by "+
+			"its own sync-catalog. Services routed to other zones are removed from -ns1-domain. (Defaults to false)")
+	c.flags.StringVar(&c.flagDefaultZone, "ns1-default-zone", "",
+		"The zone of the services without a route with -ns1-zone-routing. "+
+			"If this is not set then they are synced to -ns1-domain.")
 	c.flags.StringVar(&c.flagNS1APIKey, "ns1-apikey", "",
 		"The API key to use when communicating with NS1.  This can also be specified via the "+
 			"NS1_APIKEY environment variable.")
+	c.flags.StringVar(&c.flagNS1ReadAPIKey, "ns1-read-apikey", "",
+		"A separate API key to poll the zone and read records with, which only needs read permissions. "+
+			"-ns1-apikey is then only used to write records. This can also be specified via the "+
+			"NS1_READ_APIKEY environment variable. (Defaults to -ns1-apikey)")
 	c.flags.BoolVar(&c.flagNS1IgnoreSSL, "ns1-ignoressl", false,
 		"Ignore SSL validation when communicating with NS1. (Defaults to false)")
+	c.flags.IntVar(&c.flagNS1MaxIdleConns, "ns1-max-idle-conns", 100,
+		"The maximum number of idle keep-alive connections kept open to the NS1 API. "+
+			"Raise this when syncing many services to allow more parallel writes. (Defaults to 100)")
+	c.flags.StringVar(&c.flagNS1IdleTimeout, "ns1-idle-conn-timeout", "90s",
+		"How long an idle connection to the NS1 API is kept open before being closed. (Defaults to 90s)")
+	c.flags.StringVar(&c.flagNS1KeepAlive, "ns1-keep-alive", "30s",
+		"The interval between TCP keep-alive probes on connections to the NS1 API. (Defaults to 30s)")
+
+	c.flags.StringVar(&c.flagMinQueryInterval, "consul-min-query-interval", "0s",
+		"The minimum time between two blocking queries for Consul services, distinct from the time a query "+
+			"blocks for. Raise this when a churning catalog wakes consul-ns1 up in a tight loop. Queries that "+
+			"return no change are always at least 1s apart. (Defaults to 0s)")
+
+	c.flags.StringVar(&c.flagHealthAggregation, "health-aggregation", "worst",
+		"How the statuses of multiple health checks of a service instance are combined. "+
+			"\"worst\" uses the worst status of all checks, \"best\" uses the best one. (Defaults to worst)")
+
+	c.flags.BoolVar(&c.flagIgnoreNodeChecks, "ignore-node-checks", false,
+		"Ignore node-level health checks (e.g. serfHealth) when determining the health of a service instance. "+
+			"By default an instance on a failing node is considered unhealthy. (Defaults to false)")
+
+	c.flags.BoolVar(&c.flagOnlyPassing, "only-passing", false,
+		"Only publish the instances passing their health checks, critical instances are left out of the answers "+
+			"of their service. Instances without checks are published. (Defaults to false)")
+
+	c.flags.StringVar(&c.flagWarningPolicy, "warning-policy", "exclude",
+		"Whether instances in warning state are published with -only-passing, \"exclude\" leaves them out "+
+			"and \"publish\" publishes them like passing instances. With -ns1-up-filter, \"exclude\" marks them "+
+			"down. (Defaults to exclude)")
+
+	c.flags.BoolVar(&c.flagUpFilter, "ns1-up-filter", false,
+		"Keep the instances failing their health checks in the answers of their service with their up meta set "+
+			"to false, and append the up filter to the filter chain of the records, instead of leaving them out "+
+			"with -only-passing. Can't be combined with -only-passing. (Defaults to false)")
+
+	c.flags.BoolVar(&c.flagUpFeeds, "ns1-up-feeds", false,
+		"Point the up meta of the answers of each service to data feeds of an NS1 API data source, one feed per "+
+			"address, append the up filter to the filter chain of the records, and publish the health of the "+
+			"addresses to the feeds as soon as it changes in Consul, without rewriting records. Can't be combined "+
+			"with -only-passing or -ns1-up-filter. (Defaults to false)")
+
+	c.flags.BoolVar(&c.flagSyncMonitors, "ns1-sync-monitors", false,
+		"Mirror the HTTP and TCP checks of the instances of each service as NS1 monitoring jobs targeting their "+
+			"addresses, and point the up meta of their answers to the feeds of the jobs, so NS1 validates that "+
+			"addresses are reachable from the edge. Can't be combined with -ns1-up-filter or -ns1-up-feeds. "+
+			"(Defaults to false)")
+
+	c.flags.Var(&c.flagMonitorRegions, "ns1-monitor-region",
+		"An NS1 region monitoring jobs run from with -ns1-sync-monitors, e.g. \"lga\". "+
+			"May be specified multiple times. (Defaults to lga, sjc and ams)")
+
+	c.flags.BoolVar(&c.flagPortHints, "ns1-port-hints", false,
+		"Publish a TXT record next to each A record listing the ports of the service, e.g. \"ports=8080,8443\", "+
+			"for clients that can't consume SRV records. (Defaults to false)")
+
+	c.flags.IntVar(&c.flagMaxRecords, "ns1-prefix-max-records", 0,
+		"The maximum number of records consul-ns1 may manage under -ns1-service-prefix. "+
+			"Services that would exceed the quota are not created. 0 means unlimited. (Defaults to 0)")
+	c.flags.IntVar(&c.flagMaxAnswers, "ns1-prefix-max-answers", 0,
+		"The maximum number of answers of a single record managed under -ns1-service-prefix. "+
+			"Services that would exceed the quota are not updated. 0 means unlimited. (Defaults to 0)")
+
+	c.flags.StringVar(&c.flagAddressFamily, "address-family", "ipv4",
+		"Which instance addresses are eligible for publication: \"ipv4\" publishes IPv4 addresses in A records, "+
+			"\"ipv6\" publishes IPv6 addresses in AAAA records and \"dual\" publishes both. (Defaults to ipv4)")
+
+	c.flags.BoolVar(&c.flagLowercaseNames, "lowercase-service-names", false,
+		"Publish services under their lowercased name. DNS names are case-insensitive, so services whose "+
+			"names only differ by case are always reported and only the first name in lexical order is published. "+
+			"(Defaults to false)")
+	c.flags.StringVar(&c.flagSanitizeNames, "sanitize-service-names", "",
+		"A comma-separated list of the steps turning service names into valid DNS names: \"lowercase\", "+
+			"\"underscores\" replaced with hyphens, \"long\" labels over 63 characters shortened with a hash and "+
+			"\"unicode\" labels encoded in punycode, or \"all\" of them. Services whose sanitized names collide "+
+			"are reported and only the first name in lexical order is published. (Defaults to none)")
+	c.flags.StringVar(&c.flagAllowServices, "allow-services", "",
+		"A comma-separated list of globs, e.g. \"web-*\", or regular expressions between slashes, e.g. "+
+			"\"/^web-[0-9]+$/\", selecting the services to publish by name. All services are published if "+
+			"empty. The records of services that aren't selected are left alone. (Defaults to none)")
+	c.flags.StringVar(&c.flagDenyServices, "deny-services", "",
+		"A comma-separated list of globs or regular expressions between slashes, like -allow-services, of the "+
+			"services not to publish. It takes precedence over -allow-services. (Defaults to none)")
+	c.flags.BoolVar(&c.flagConnectProxies, "publish-connect-proxies", false,
+		"Publish the addresses and ports of the Connect sidecar proxies of a service under its name instead of "+
+			"the ones of its instances, so DNS consumers outside the mesh reach its entry point. Proxies are not "+
+			"published as services of their own. (Defaults to false)")
+	c.flags.BoolVar(&c.flagProxyServices, "publish-proxy-services", false,
+		"Publish the Connect sidecar proxies and gateways, e.g. web-sidecar-proxy or mesh-gateway, as "+
+			"services of their own. They're detected by their proxy registration or the names Consul gives them, "+
+			"and left out of DNS by default as mesh internals. (Defaults to false)")
+	c.flags.BoolVar(&c.flagCheckedPortsOnly, "publish-checked-ports-only", false,
+		"Only publish SRV answers for the ports targeted by a TCP or HTTP health check of the service on the "+
+			"node of the instance, so ports that are never validated aren't advertised. Instances on nodes whose "+
+			"checks target no port, e.g. TTL checks, are published unchanged. (Defaults to false)")
+	c.flags.BoolVar(&c.flagSRVTargetHosts, "ns1-srv-target-hostnames", false,
+		"Point SRV answers at a hostname per Consul node, <node>.<prefix><service>, instead of the IP of the "+
+			"instance, as RFC 2782 requires. The A and AAAA records of each node are managed next to the "+
+			"service. (Defaults to false)")
+	c.flags.BoolVar(&c.flagDCSubdomains, "ns1-dc-subdomains", false,
+		"Publish the instances of each service in each Consul datacenter at <prefix><service>.<datacenter> "+
+			"next to <prefix><service>, which holds the instances of all datacenters. Can't be combined with "+
+			"-ns1-up-feeds or -ns1-sync-monitors. (Defaults to false)")
+	c.flags.BoolVar(&c.flagTagSubdomains, "ns1-tag-subdomains", false,
+		"Publish the instances of each service carrying a tag at <prefix><tag>.<service> next to "+
+			"<prefix><service>, like Consul DNS does, e.g. to split canary and primary instances. Can't be "+
+			"combined with -ns1-domain-template, -ns1-up-feeds or -ns1-sync-monitors. (Defaults to false)")
+	c.flags.StringVar(&c.flagDomainTemplate, "ns1-domain-template", "",
+		"Name the records of services after a template, e.g. \"{prefix}{service}.{dc}.{zone}\". {service} is "+
+			"required, {dc} splits services per datacenter and {tag} per tag. Records whose name the template "+
+			"doesn't render are left alone. Can't be combined with -ns1-dc-subdomains, -ns1-tag-subdomains, "+
+			"-ns1-srv-target-hostnames, -ns1-up-feeds or -ns1-sync-monitors. "+
+			"(Defaults to \"{prefix}{service}.{zone}\")")
+
+	c.flags.BoolVar(&c.flagOwnershipRegistry, "ns1-ownership-registry", false,
+		"Mark every managed service with a TXT record (_consul-ns1.<service>) naming this instance by its "+
+			"-ns1-service-prefix. Records marked by an instance with another prefix are never modified or deleted, "+
+			"allowing multiple consul-ns1 deployments to share a zone. (Defaults to false)")
+
+	c.flags.BoolVar(&c.flagRecordMarker, "ns1-record-marker", false,
+		"Mark every record written with \"managed-by=consul-ns1\" in its note, and only delete records carrying "+
+			"the marker, so records made by hand in the zone are never deleted. Records written before the marker "+
+			"was enabled are only deleted once they were updated. (Defaults to false)")
+
+	c.flags.StringVar(&c.flagConflictPolicy, "ns1-conflict-policy", "skip",
+		"What to do when a service's domain already holds records without an ownership record, "+
+			"requires -ns1-ownership-registry. \"adopt\" overwrites and marks them, \"skip\" leaves them alone "+
+			"and \"error\" stops syncing. Records found on the first cycle are reported at startup. (Defaults to skip)")
+
+	c.flags.StringVar(&c.flagEditPolicy, "ns1-edit-policy", "overwrite",
+		"What to do when a record about to be updated was edited outside of consul-ns1 since it was last "+
+			"synced, e.g. in the NS1 portal. \"overwrite\" replaces the edited answers, \"skip\" leaves the record "+
+			"alone and \"merge\" keeps the answers added by the edit. (Defaults to overwrite)")
+
+	c.flags.StringVar(&c.flagEmptyAnswerPolicy, "ns1-empty-answer-policy", "warn",
+		"What to do when an update would remove all the answers of a record, which usually means the health "+
+			"or the catalog of the service is wrong. \"warn\" writes the record and logs a warning, \"block\" "+
+			"leaves the record with its answers until the service has instances again. (Defaults to warn)")
+
+	c.flags.StringVar(&c.flagFilters, "ns1-filters", "",
+		"The default filter chain of the records created, a JSON list of filters in the format of the NS1 API, "+
+			"e.g. '[{\"filter\": \"up\"}, {\"filter\": \"select_first_n\", \"config\": {\"N\": 1}}]', or the "+
+			"path of a file holding one. The filters of existing records are left alone, and the filters of "+
+			"a template record, see -ns1-template-record, take precedence.")
+
+	c.flags.StringVar(&c.flagFiltersKVPrefix, "ns1-filters-kv-prefix", "",
+		"A Consul KV prefix, e.g. \"consul-ns1/services\", holding filter chains at <prefix>/<service>/filters "+
+			"that replace the filter chain of the A, AAAA and SRV records of the service. They take precedence "+
+			"over the \"ns1-filters\" service meta and records are rewritten when they change.")
+
+	c.flags.StringVar(&c.flagUseClientSubnet, "ns1-use-client-subnet", "",
+		"Enable (\"true\") or disable (\"false\") EDNS Client Subnet on the records created, so NS1 routes "+
+			"queries by the subnet of the client rather than of its resolver. The \"ns1-use-client-subnet\" "+
+			"service meta takes precedence and also applies to existing records. (Defaults to the default of NS1)")
+
+	c.flags.StringVar(&c.flagTemplateRecord, "ns1-template-record", "",
+		"The domain of records of the zone, e.g. \"_template\", whose filters, meta and regions are cloned "+
+			"onto the records of the same type created for services, so the default routing of records is "+
+			"managed in NS1. Records already created are left alone.")
+
+	c.flags.BoolVar(&c.flagCoManaged, "ns1-co-managed-records", false,
+		"Only manage the answers written by this instance within A, AAAA and SRV records, marked with a note, "+
+			"and leave all other answers alone, so records can hold answers managed by hand. (Defaults to false)")
+
+	c.flags.StringVar(&c.flagSyncerID, "ns1-syncer-id", "",
+		"Identifies the instance among the instances sharing co-managed records, e.g. the name of its "+
+			"datacenter, so they never remove each other's answers. Answers are marked with it and the regions "+
+			"the instance writes to are registered in the _consul-ns1-syncers TXT record of the zone. Requires "+
+			"-ns1-co-managed-records.")
+
+	c.flags.Var(&c.flagTaggedAddresses, "publish-tagged-address",
+		"The tag of a tagged address of the instances, e.g. \"wan\", published as an additional answer of the A "+
+			"or AAAA record of their service next to their address, with the tag in the note of the answer. "+
+			"The tagged addresses of a service take precedence over the ones of its node. "+
+			"May be specified multiple times.")
+
+	c.flags.BoolVar(&c.flagWeightedAnswers, "ns1-weighted-answers", false,
+		"Weight the A and AAAA answers of each instance by its Consul weights or the ns1-weight meta of its "+
+			"node, and append the weighted_shuffle filter to the filter chain of the records. (Defaults to false)")
+	c.flags.StringVar(&c.flagRolloutsKVPrefix, "ns1-rollouts-kv-prefix", "",
+		"Consul KV prefix holding the rollouts of services at \"<prefix>/<service>\", e.g. "+
+			"{\"tag\": \"canary\", \"weight\": 10} sending 10 percent of the traffic of the service to its "+
+			"instances tagged canary, e.g. \"consul-ns1/rollouts\". The weights are applied on every sync and "+
+			"changes are watched. Requires -ns1-weighted-answers. If this is not set then rollouts are disabled.")
+	c.flags.StringVar(&c.flagFeatureFlagsKVPrefix, "feature-flags-kv-prefix", "",
+		"Consul KV prefix holding feature flags toggling behaviors at runtime at \"<prefix>/<flag>\", \"true\" "+
+			"or \"false\": \"deletes\" removes the records of services gone from Consul, \"only-passing\" only "+
+			"publishes passing instances and \"observe-only\" stops writing to NS1, e.g. \"consul-ns1/flags\". "+
+			"Changes are watched and flags that aren't set keep the configured behavior. If this is not set then "+
+			"feature flags are disabled.")
+
+	c.flags.BoolVar(&c.flagGeoMetadata, "ns1-geo-metadata", false,
+		"Publish the location of each instance in the meta of its A and AAAA answers: the georegion of its "+
+			"datacenter, see -ns1-geo-region, overridden by the georegion, country, latitude and longitude "+
+			"meta of its node. (Defaults to false)")
+
+	c.flags.BoolVar(&c.flagVersionNotes, "ns1-version-notes", false,
+		"Note the version of each instance, e.g. \"version=v1.2.3\", on its A and AAAA answers, so DNS answers "+
+			"can be correlated with deployed versions during rollouts. The version is taken from the \"version\" "+
+			"service meta or the first tag naming a version, e.g. \"v1.2.3\". Can't be combined with "+
+			"-ns1-co-managed-records. (Defaults to false)")
+
+	c.flags.Var(&c.flagGeoRegions, "ns1-geo-region",
+		"The NS1 georegion of the instances of a Consul datacenter when -ns1-geo-metadata or "+
+			"-ns1-datacenter-regions is set, as "+
+			"DATACENTER=GEOREGION, e.g. \"dc1=US-EAST\". May be specified multiple times.")
+
+	c.flags.BoolVar(&c.flagGeotargetRegional, "ns1-geotarget-regional", false,
+		"Append the geotarget_regional filter to the filter chain of the A and AAAA records of located answers "+
+			"when -ns1-geo-metadata is set, so clients are answered from their georegion first. (Defaults to false)")
+
+	c.flags.BoolVar(&c.flagDatacenterRegions, "ns1-datacenter-regions", false,
+		"Group the A, AAAA and SRV answers of the instances in NS1 regions named after their Consul datacenter, "+
+			"with the georegion mapped by -ns1-geo-region in the meta of the regions. Combined with "+
+			"-ns1-co-managed-records, instances syncing different datacenters share records. (Defaults to false)")
+
+	c.flags.StringVar(&c.flagRegistryGCInterval, "ns1-registry-gc-interval", "10m",
+		"How often the ownership registry is garbage collected when -ns1-ownership-registry is set: "+
+			"ownership records left without records are deleted, and unmarked records matching the desired "+
+			"state are marked. 0 disables garbage collection. (Defaults to 10m)")
+
+	c.flags.BoolVar(&c.flagInstanceCount, "publish-instance-count", false,
+		"Write the number of healthy instances of each service into the note of its records, "+
+			"e.g. \"consul-ns1 healthy_instances=3\", so NS1 dashboards can graph capacity. (Defaults to false)")
+	c.flags.BoolVar(&c.flagDeregister, "deregister-on-shutdown", false,
+		"Remove all records managed by this instance from NS1 when it is stopped by SIGINT or SIGTERM, "+
+			"e.g. in ephemeral environments where the records should not outlive the syncer. (Defaults to false)")
+
+	c.flags.StringVar(&c.flagLeaderLockKey, "leader-lock-key", "",
+		"A Consul KV key used to elect a single active instance among all instances configured with it, "+
+			"e.g. \"consul-ns1/leader\". Standby instances wait for the lock; an instance that acquires it "+
+			"runs a full reconciliation and heals the ownership registry first, and exits when it loses the lock. "+
+			"If this is not set then leader election is disabled.")
+
+	c.flags.StringVar(&c.flagJournalFile, "journal-file", "",
+		"A file the changes of a sync cycle are written to before they are applied, and removed from once they "+
+			"were. If the syncer stops mid-cycle, e.g. because it crashed, the interrupted changes are reported and "+
+			"healed by a full reconciliation on startup. If this is not set then no journal is kept.")
+
+	c.flags.StringVar(&c.flagStateFile, "state-file", "",
+		"A file the sync state is kept in across restarts: the answers last written to each record, their "+
+			"weights, the answers of co-managed records and the published instance counts. A restarted syncer "+
+			"doesn't rewrite records it already wrote and keeps detecting edits. If this is not set then the "+
+			"state is only kept in memory.")
+
+	c.flags.IntVar(&c.flagChurnThreshold, "churn-threshold", 0,
+		"The number of changes per hour above which a service is reported as flapping with a warning and the "+
+			"service.churn_exceeded metric, since a flapping deployment can dominate the NS1 quota. The changes of "+
+			"each service within the last hour are published as the service.churn metric. 0 disables churn "+
+			"tracking. (Defaults to 0)")
+
+	c.flags.StringVar(&c.flagSyncHealthService, "sync-health-service", "",
+		"The ID of a service registered on the local Consul agent to register a TTL check \"ns1-sync:<service>\" "+
+			"on for each managed service. A check passes while the records of its service are in sync, warns while "+
+			"changes are pending and turns critical when the syncer stops updating it, so Consul consumers such "+
+			"as deploy pipelines can gate on DNS being in sync. If this is not set then no checks are registered.")
+
+	c.flags.StringVar(&c.flagResyncEvent, "resync-event", "",
+		"The name of a Consul user event that triggers an immediate full reconciliation when fired, "+
+			"e.g. \"consul event -name=ns1-resync\". If this is not set then events are not watched.")
+	c.flags.StringVar(&c.flagResyncKey, "resync-key", "",
+		"A Consul KV key that triggers an immediate full reconciliation when modified, "+
+			"e.g. \"consul kv put ns1/resync 1\". If this is not set then no key is watched.")
+
+	c.flags.Var(&c.flagFreezeWindows, "freeze-window",
+		"A period during which no changes are written to NS1, given as a five field cron schedule in UTC "+
+			"followed by a duration, e.g. \"0 18 * * 5 62h\" from Friday 18:00 until Monday 08:00. "+
+			"Changes are applied once the window ends. Can be specified multiple times.")
+
+	c.flags.IntVar(&c.flagApprovalThreshold, "approval-threshold", -1,
+		"Hold back sync cycles changing more than this number of services until an operator approves them "+
+			"via the admin API or -approval-key. -1 disables approval mode. (Defaults to -1)")
+	c.flags.StringVar(&c.flagApprovalFile, "approval-file", "",
+		"A file the changes pending approval are written to as JSON.")
+	c.flags.StringVar(&c.flagApprovalKey, "approval-key", "",
+		"A Consul KV key approving the pending changes when it holds their ID, "+
+			"e.g. \"consul kv put ns1/approve <id>\".")
+	c.flags.StringVar(&c.flagAdminAddr, "admin-addr", "",
+		"The address the admin API listens on, e.g. \"127.0.0.1:9090\". "+
+			"If this is not set then the admin API is disabled.")
+
+	c.flags.IntVar(&c.flagStallFactor, "stall-factor", 5,
+		"Consider the fetch and sync loops stalled when an iteration takes longer than this multiple of "+
+			"its expected time, logging the stacks of all goroutines. 0 disables liveness checks. (Defaults to 5)")
+	c.flags.BoolVar(&c.flagRestartStalled, "restart-stalled-loops", false,
+		"Restart loops considered stalled by -stall-factor. (Defaults to false)")
+
+	c.flags.IntVar(&c.flagAccountMaxRecords, "ns1-account-max-records", 0,
+		"The record limit of the NS1 account plan. Warns when the records of the zone approach it, "+
+			"using the thresholds of the account usage warnings. 0 disables the check. (Defaults to 0)")
+	c.flags.Float64Var(&c.flagAccountMaxQPS, "ns1-account-max-qps", 0,
+		"The queries per second limit of the NS1 account plan. Warns when the queries of the account approach it, "+
+			"using the thresholds of the account usage warnings. 0 disables the check. (Defaults to 0)")
+	c.flags.StringVar(&c.flagAccountInterval, "ns1-account-check-interval", "5m",
+		"How often account usage is checked against -ns1-account-max-records and -ns1-account-max-qps. (Defaults to 5m)")
+	c.flags.BoolVar(&c.flagPauseCreates, "ns1-pause-creates-near-limit", false,
+		"Stop creating records while account usage is above the second warning threshold. "+
+			"Existing records are still updated and deleted. (Defaults to false)")
+
+	c.flags.Float64Var(&c.flagAPIRate, "ns1-api-rate", 0,
+		"The rate of NS1 API requests, in tokens per second shared by the polls of the zone and the writes. "+
+			"Writes wait for tokens and polls are deferred until enough tokens are left. 0 disables the limit. (Defaults to 0)")
+	c.flags.Float64Var(&c.flagAPIReadWeight, "ns1-api-read-weight", 1,
+		"The tokens taken by a read of the NS1 API, e.g. a poll of the zone, with -ns1-api-rate. (Defaults to 1)")
+	c.flags.Float64Var(&c.flagAPIWriteWeight, "ns1-api-write-weight", 1,
+		"The tokens taken by a write of the NS1 API with -ns1-api-rate. (Defaults to 1)")
 
 	c.http = &flags.HTTPFlags{}
 	flags.Merge(c.flags, c.http.ClientFlags())
 	flags.Merge(c.flags, c.http.ServerFlags())
+	subcommand.HelpFormatVar(c.flags, &c.flagHelpFormat)
 	c.help = flags.Usage(help, c.flags)
 }
 
@@ -67,6 +485,9 @@ func (c *Command) Run(args []string) int {
 	if err := c.flags.Parse(args); err != nil {
 		return 1
 	}
+	if c.flagHelpFormat != "" {
+		return subcommand.PrintHelp(c.UI, "sync-catalog", c.flags, c.flagHelpFormat)
+	}
 	if len(c.flags.Args()) > 0 {
 		c.UI.Error("Should have no non-flag arguments.")
 		return 1
@@ -75,11 +496,22 @@ func (c *Command) Run(args []string) int {
 		c.UI.Error("Please provide -ns1-domain")
 		return 1
 	}
-	ns1Client, err := subcommand.NS1Client(c.flagNS1Endpoint, c.flagNS1APIKey, c.flagNS1IgnoreSSL)
+	tc, err := c.transportConfig()
+	if err != nil {
+		c.UI.Error(err.Error())
+		return 1
+	}
+	ns1Client, err := subcommand.NS1Client(c.flagNS1Endpoint, c.flagNS1APIKey, tc)
 	if err != nil {
 		c.UI.Error(fmt.Sprintf("Error retrieving NS1 client: %s", err))
 		return 1
 	}
+	ns1ReadClient := subcommand.NS1ReadClient(c.flagNS1Endpoint, c.flagNS1ReadAPIKey, tc)
+
+	if _, err := subcommand.SetupMetrics(); err != nil {
+		c.UI.Error(fmt.Sprintf("Error setting up metrics: %s", err))
+		return 1
+	}
 
 	consulClient, err := c.http.APIClient()
 	if err != nil {
@@ -89,24 +521,138 @@ func (c *Command) Run(args []string) int {
 
 	stop := make(chan struct{})
 	stopped := make(chan struct{})
-	go catalog.Sync(
-		c.flagNS1ServicePrefix, c.flagNS1PollInterval, c.flagNS1DNSTTL,
-		c.flagNS1Domain, c.getStaleWithDefaultTrue(), ns1Client, consulClient,
-		stop, stopped,
-	)
+	reload := make(chan struct{}, 1)
+	cfg := catalog.Config{
+		NS1Prefix:              c.flagNS1ServicePrefix,
+		NS1PollInterval:        c.flagNS1PollInterval,
+		Reload:                 reload,
+		NS1ReadClient:          ns1ReadClient,
+		NS1PrefixSearch:        c.flagNS1PrefixSearch,
+		NS1DNSTTL:              c.flagNS1DNSTTL,
+		NS1DNSTTLJitter:        c.flagNS1DNSTTLJitter,
+		NS1AnswerSeed:          c.flagNS1AnswerSeed,
+		NS1Domain:              c.flagNS1Domain,
+		CreateZone:             c.flagCreateZone,
+		ZoneRouting:            c.flagZoneRouting,
+		DefaultZone:            c.flagDefaultZone,
+		Stale:                  c.getStaleWithDefaultTrue(),
+		HealthAggregation:      c.flagHealthAggregation,
+		IgnoreNodeChecks:       c.flagIgnoreNodeChecks,
+		OnlyPassing:            c.flagOnlyPassing,
+		UpFilter:               c.flagUpFilter,
+		UpFeeds:                c.flagUpFeeds,
+		SyncMonitors:           c.flagSyncMonitors,
+		MonitorRegions:         c.flagMonitorRegions,
+		WarningPolicy:          c.flagWarningPolicy,
+		PortHints:              c.flagPortHints,
+		MaxRecords:             c.flagMaxRecords,
+		MaxAnswers:             c.flagMaxAnswers,
+		AddressFamily:          c.flagAddressFamily,
+		OwnershipRegistry:      c.flagOwnershipRegistry,
+		RecordMarker:           c.flagRecordMarker,
+		ConflictPolicy:         c.flagConflictPolicy,
+		EditPolicy:             c.flagEditPolicy,
+		EmptyAnswerPolicy:      c.flagEmptyAnswerPolicy,
+		NS1Filters:             c.flagFilters,
+		FiltersKVPrefix:        c.flagFiltersKVPrefix,
+		UseClientSubnet:        c.flagUseClientSubnet,
+		NS1TemplateRecord:      c.flagTemplateRecord,
+		CoManagedRecords:       c.flagCoManaged,
+		SyncerID:               c.flagSyncerID,
+		WeightedAnswers:        c.flagWeightedAnswers,
+		RolloutsKVPrefix:       c.flagRolloutsKVPrefix,
+		FeatureFlagsKVPrefix:   c.flagFeatureFlagsKVPrefix,
+		GeoMetadata:            c.flagGeoMetadata,
+		VersionNotes:           c.flagVersionNotes,
+		GeoRegions:             c.flagGeoRegions,
+		GeotargetRegional:      c.flagGeotargetRegional,
+		DatacenterRegions:      c.flagDatacenterRegions,
+		TaggedAddresses:        c.flagTaggedAddresses,
+		ResyncEvent:            c.flagResyncEvent,
+		ResyncKey:              c.flagResyncKey,
+		FreezeWindows:          c.flagFreezeWindows,
+		Approval:               c.flagApprovalThreshold >= 0,
+		ApprovalThreshold:      c.flagApprovalThreshold,
+		ApprovalFile:           c.flagApprovalFile,
+		ApprovalKey:            c.flagApprovalKey,
+		AdminAddr:              c.flagAdminAddr,
+		StallFactor:            c.flagStallFactor,
+		RestartStalledLoops:    c.flagRestartStalled,
+		RegistryGCInterval:     c.flagRegistryGCInterval,
+		ConsulMinQueryInterval: c.flagMinQueryInterval,
+		LowercaseServiceNames:  c.flagLowercaseNames,
+		SanitizeServiceNames:   c.flagSanitizeNames,
+		AllowServices:          c.flagAllowServices,
+		DenyServices:           c.flagDenyServices,
+		PublishConnectProxies:  c.flagConnectProxies,
+		PublishProxyServices:   c.flagProxyServices,
+		CheckedPortsOnly:       c.flagCheckedPortsOnly,
+		SRVTargetHostnames:     c.flagSRVTargetHosts,
+		DatacenterSubdomains:   c.flagDCSubdomains,
+		TagSubdomains:          c.flagTagSubdomains,
+		DomainTemplate:         c.flagDomainTemplate,
+		DeregisterOnShutdown:   c.flagDeregister,
+		LeaderLockKey:          c.flagLeaderLockKey,
+		JournalFile:            c.flagJournalFile,
+		StateFile:              c.flagStateFile,
+		SyncHealthServiceID:    c.flagSyncHealthService,
+		ChurnThreshold:         c.flagChurnThreshold,
+		PublishInstanceCount:   c.flagInstanceCount,
+		AccountMaxRecords:      c.flagAccountMaxRecords,
+		AccountMaxQPS:          c.flagAccountMaxQPS,
+		NS1APIRate:             c.flagAPIRate,
+		NS1APIReadWeight:       c.flagAPIReadWeight,
+		NS1APIWriteWeight:      c.flagAPIWriteWeight,
+		AccountCheckInterval:   c.flagAccountInterval,
+		PauseCreatesNearLimit:  c.flagPauseCreates,
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- catalog.Sync(cfg, ns1Client, consulClient, stop, stopped)
+	}()
 
 	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, os.Interrupt)
-	select {
-	// Unexpected failure
-	case <-stopped:
-		return 1
-	case <-sigCh:
-		c.UI.Info("shutting down...")
-		close(stop)
-		<-stopped
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	hupCh := make(chan os.Signal, 1)
+	signal.Notify(hupCh, syscall.SIGHUP)
+	for {
+		select {
+		// Unexpected failure
+		case err := <-errCh:
+			if err == nil {
+				return 1
+			}
+			c.UI.Error(err.Error())
+			return catalog.ExitCode(err)
+		case <-hupCh:
+			select {
+			case reload <- struct{}{}:
+			default:
+			}
+		case <-sigCh:
+			c.UI.Info("shutting down...")
+			close(stop)
+			return catalog.ExitCode(<-errCh)
+		}
+	}
+}
+
+// transportConfig builds the NS1 HTTP transport settings from flags
+func (c *Command) transportConfig() (subcommand.TransportConfig, error) {
+	tc := subcommand.DefaultTransportConfig()
+	tc.IgnoreSSL = c.flagNS1IgnoreSSL
+	tc.MaxIdleConns = c.flagNS1MaxIdleConns
+	idleTimeout, err := time.ParseDuration(c.flagNS1IdleTimeout)
+	if err != nil {
+		return tc, fmt.Errorf("Cannot parse -ns1-idle-conn-timeout: %s", err)
+	}
+	tc.IdleConnTimeout = idleTimeout
+	keepAlive, err := time.ParseDuration(c.flagNS1KeepAlive)
+	if err != nil {
+		return tc, fmt.Errorf("Cannot parse -ns1-keep-alive: %s", err)
 	}
-	return 0
+	tc.KeepAlive = keepAlive
+	return tc, nil
 }
 
 func (c *Command) getStaleWithDefaultTrue() bool {
@@ -129,6 +675,26 @@ func (c *Command) Help() string {
 	return c.help
 }
 
+// AutocompleteFlags returns the predictors of the flags of the program
+func (c *Command) AutocompleteFlags() complete.Flags {
+	c.once.Do(c.init)
+	return subcommand.PredictFlags(c.flags, complete.Flags{
+		"-help-format":             complete.PredictSet(subcommand.HelpFormatJSON),
+		"-ns1-domain":              subcommand.PredictZones(),
+		"-health-aggregation":      complete.PredictSet("worst", "best"),
+		"-address-family":          complete.PredictSet("ipv4", "ipv6", "dual"),
+		"-ns1-conflict-policy":     complete.PredictSet("adopt", "skip", "error"),
+		"-ns1-edit-policy":         complete.PredictSet("overwrite", "skip", "merge"),
+		"-ns1-filters":             complete.PredictFiles("*.json"),
+		"-ns1-empty-answer-policy": complete.PredictSet("warn", "block"),
+		"-ns1-use-client-subnet":   complete.PredictSet("true", "false"),
+		"-warning-policy":          complete.PredictSet("exclude", "publish"),
+	})
+}
+
+// AutocompleteArgs returns the predictor of the arguments of the program, which takes none
+func (c *Command) AutocompleteArgs() complete.Predictor { return complete.PredictNothing }
+
 const synopsis = "Sync NS1 and Consul services."
 const help = `
 Usage: consul-ns1 sync-catalog [options]
@@ -0,0 +1,21 @@
+package subcommand
+
+import (
+	"time"
+
+	metrics "github.com/armon/go-metrics"
+)
+
+// SetupMetrics configures the global metrics registry with an in-memory sink.
+// The collected metrics can be dumped to stderr by sending SIGUSR1 to the process.
+func SetupMetrics() (*metrics.InmemSink, error) {
+	inm := metrics.NewInmemSink(10*time.Second, time.Minute)
+	metrics.DefaultInmemSignal(inm)
+
+	cfg := metrics.DefaultConfig("consul-ns1")
+	cfg.EnableHostname = false
+	if _, err := metrics.NewGlobal(cfg, inm); err != nil {
+		return nil, err
+	}
+	return inm, nil
+}
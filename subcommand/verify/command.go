@@ -0,0 +1,277 @@
+package verify
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/catalog"
+	"github.com/nsone/consul-ns1/subcommand"
+	"github.com/posener/complete"
+)
+
+// Command is the command for verifying published records through DNS
+type Command struct {
+	UI cli.Ui
+
+	flags                 *flag.FlagSet
+	flagHelpFormat        string
+	http                  *flags.HTTPFlags
+	flagNS1ServicePrefix  string
+	flagNS1Domain         string
+	flagNS1Endpoint       string
+	flagNS1APIKey         string
+	flagNS1IgnoreSSL      bool
+	flagNS1Nameservers    bool
+	flagResolver          string
+	flagTimeout           string
+	flagHealthAggregation string
+	flagIgnoreNodeChecks  bool
+	flagOnlyPassing       bool
+	flagUpFilter          bool
+	flagUpFeeds           bool
+	flagWarningPolicy     string
+	flagAddressFamily     string
+	flagLowercaseNames    bool
+	flagSanitizeNames     string
+	flagAllowServices     string
+	flagDenyServices      string
+	flagConnectProxies    bool
+	flagProxyServices     bool
+	flagCheckedPortsOnly  bool
+	flagTaggedAddresses   flags.AppendSliceValue
+	flagSRVTargetHosts    bool
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	c.flags.StringVar(&c.flagNS1ServicePrefix, "ns1-service-prefix", "",
+		"The prefix prepended to all services written to NS1 by sync-catalog.")
+	c.flags.StringVar(&c.flagNS1Domain, "ns1-domain", "",
+		"Name of the DNS domain in NS1 services are synced to.")
+	c.flags.StringVar(&c.flagResolver, "resolver", "",
+		"The address of the DNS server to query, e.g. \"8.8.8.8:53\". "+
+			"If this is not set then the system resolver is used.")
+	c.flags.BoolVar(&c.flagNS1Nameservers, "ns1-nameservers", false,
+		"Query the first authoritative NS1 nameserver of -ns1-domain directly, bypassing caching resolvers. "+
+			"Requires access to the NS1 API. (Defaults to false)")
+	c.flags.StringVar(&c.flagNS1Endpoint, "ns1-endpoint", "",
+		"The absolute URL of the NS1 API endpoint. (Defaults to https://api.nsone.net/v1/)")
+	c.flags.StringVar(&c.flagNS1APIKey, "ns1-apikey", "",
+		"The API key to use when communicating with NS1.  This can also be specified via the "+
+			"NS1_APIKEY environment variable.")
+	c.flags.BoolVar(&c.flagNS1IgnoreSSL, "ns1-ignoressl", false,
+		"Ignore SSL validation when communicating with NS1. (Defaults to false)")
+	c.flags.StringVar(&c.flagTimeout, "timeout", "30s",
+		"The maximum time to spend resolving all records. (Defaults to 30s)")
+	c.flags.StringVar(&c.flagHealthAggregation, "health-aggregation", "worst",
+		"The -health-aggregation policy used by sync-catalog. (Defaults to worst)")
+	c.flags.BoolVar(&c.flagIgnoreNodeChecks, "ignore-node-checks", false,
+		"The -ignore-node-checks setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagOnlyPassing, "only-passing", false,
+		"The -only-passing setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagUpFilter, "ns1-up-filter", false,
+		"The -ns1-up-filter setting used by sync-catalog, answers marked down aren't expected to resolve. "+
+			"(Defaults to false)")
+	c.flags.BoolVar(&c.flagUpFeeds, "ns1-up-feeds", false,
+		"The -ns1-up-feeds setting used by sync-catalog, answers whose feed is down aren't expected to resolve. "+
+			"(Defaults to false)")
+	c.flags.StringVar(&c.flagWarningPolicy, "warning-policy", "exclude",
+		"The -warning-policy used by sync-catalog. (Defaults to exclude)")
+	c.flags.StringVar(&c.flagAddressFamily, "address-family", "ipv4",
+		"The -address-family used by sync-catalog. (Defaults to ipv4)")
+	c.flags.BoolVar(&c.flagLowercaseNames, "lowercase-service-names", false,
+		"The -lowercase-service-names setting used by sync-catalog. (Defaults to false)")
+	c.flags.StringVar(&c.flagSanitizeNames, "sanitize-service-names", "",
+		"The -sanitize-service-names setting used by sync-catalog. (Defaults to none)")
+	c.flags.StringVar(&c.flagAllowServices, "allow-services", "",
+		"The -allow-services setting used by sync-catalog. (Defaults to none)")
+	c.flags.StringVar(&c.flagDenyServices, "deny-services", "",
+		"The -deny-services setting used by sync-catalog. (Defaults to none)")
+	c.flags.BoolVar(&c.flagConnectProxies, "publish-connect-proxies", false,
+		"The -publish-connect-proxies setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagProxyServices, "publish-proxy-services", false,
+		"The -publish-proxy-services setting used by sync-catalog. (Defaults to false)")
+	c.flags.BoolVar(&c.flagCheckedPortsOnly, "publish-checked-ports-only", false,
+		"The -publish-checked-ports-only setting used by sync-catalog. (Defaults to false)")
+	c.flags.Var(&c.flagTaggedAddresses, "publish-tagged-address",
+		"The -publish-tagged-address tags used by sync-catalog. May be specified multiple times.")
+	c.flags.BoolVar(&c.flagSRVTargetHosts, "ns1-srv-target-hostnames", false,
+		"The -ns1-srv-target-hostnames setting used by sync-catalog. (Defaults to false)")
+
+	c.http = &flags.HTTPFlags{}
+	flags.Merge(c.flags, c.http.ClientFlags())
+	flags.Merge(c.flags, c.http.ServerFlags())
+	subcommand.HelpFormatVar(c.flags, &c.flagHelpFormat)
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run resolves the records of all Consul services and reports the ones that don't match the desired state
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if c.flagHelpFormat != "" {
+		return subcommand.PrintHelp(c.UI, "verify", c.flags, c.flagHelpFormat)
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	if c.flagNS1Domain == "" {
+		c.UI.Error("Please provide -ns1-domain")
+		return 1
+	}
+	timeout, err := time.ParseDuration(c.flagTimeout)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Cannot parse -timeout: %s", err))
+		return 1
+	}
+
+	server := c.flagResolver
+	if c.flagNS1Nameservers {
+		server, err = c.ns1Nameserver()
+		if err != nil {
+			c.UI.Error(err.Error())
+			return 1
+		}
+	}
+
+	consulClient, err := c.http.APIClient()
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error connecting to Consul agent: %s", err))
+		return 1
+	}
+
+	cfg := catalog.Config{
+		NS1Prefix:             c.flagNS1ServicePrefix,
+		NS1Domain:             c.flagNS1Domain,
+		Stale:                 true,
+		HealthAggregation:     c.flagHealthAggregation,
+		IgnoreNodeChecks:      c.flagIgnoreNodeChecks,
+		OnlyPassing:           c.flagOnlyPassing,
+		UpFilter:              c.flagUpFilter,
+		UpFeeds:               c.flagUpFeeds,
+		WarningPolicy:         c.flagWarningPolicy,
+		AddressFamily:         c.flagAddressFamily,
+		LowercaseServiceNames: c.flagLowercaseNames,
+		SanitizeServiceNames:  c.flagSanitizeNames,
+		AllowServices:         c.flagAllowServices,
+		DenyServices:          c.flagDenyServices,
+		PublishConnectProxies: c.flagConnectProxies,
+		PublishProxyServices:  c.flagProxyServices,
+		CheckedPortsOnly:      c.flagCheckedPortsOnly,
+		TaggedAddresses:       c.flagTaggedAddresses,
+		SRVTargetHostnames:    c.flagSRVTargetHosts,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	results, err := catalog.Verify(ctx, cfg, consulClient, resolver(server))
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error verifying records: %s", err))
+		return 1
+	}
+
+	gaps := 0
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			c.UI.Error(fmt.Sprintf("%s %s: %s", r.Name, r.Type, r.Err))
+		case !r.OK():
+			c.UI.Warn(fmt.Sprintf("%s %s: missing [%s], unexpected [%s]", r.Name, r.Type,
+				strings.Join(r.Missing, ", "), strings.Join(r.Unexpected, ", ")))
+		default:
+			c.UI.Output(fmt.Sprintf("%s %s: ok", r.Name, r.Type))
+			continue
+		}
+		gaps++
+	}
+	if gaps > 0 {
+		c.UI.Error(fmt.Sprintf("%d of %d records don't match the desired state", gaps, len(results)))
+		return 1
+	}
+	c.UI.Info(fmt.Sprintf("All %d records match the desired state", len(results)))
+	return 0
+}
+
+// ns1Nameserver returns the address of the first nameserver NS1 assigned to the domain
+func (c *Command) ns1Nameserver() (string, error) {
+	tc := subcommand.DefaultTransportConfig()
+	tc.IgnoreSSL = c.flagNS1IgnoreSSL
+	ns1Client, err := subcommand.NS1Client(c.flagNS1Endpoint, c.flagNS1APIKey, tc)
+	if err != nil {
+		return "", fmt.Errorf("Error retrieving NS1 client: %s", err)
+	}
+	zone, _, err := ns1Client.Zones.Get(c.flagNS1Domain)
+	if err != nil {
+		return "", fmt.Errorf("Error fetching zone %s from NS1: %s", c.flagNS1Domain, err)
+	}
+	if len(zone.DNSServers) == 0 {
+		return "", fmt.Errorf("No nameservers assigned to zone %s in NS1", c.flagNS1Domain)
+	}
+	return zone.DNSServers[0], nil
+}
+
+// resolver returns a resolver querying the DNS server at addr, or the system resolver if addr is empty.
+// The port defaults to 53.
+func resolver(addr string) *net.Resolver {
+	if addr == "" {
+		return net.DefaultResolver
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "53")
+	}
+	dialer := net.Dialer{}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// Synopsis returns a short description of the program
+func (c *Command) Synopsis() string { return synopsis }
+
+// Help returns usage info for the program
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+// AutocompleteFlags returns the predictors of the flags of the program
+func (c *Command) AutocompleteFlags() complete.Flags {
+	c.once.Do(c.init)
+	return subcommand.PredictFlags(c.flags, complete.Flags{
+		"-help-format":        complete.PredictSet(subcommand.HelpFormatJSON),
+		"-ns1-domain":         subcommand.PredictZones(),
+		"-health-aggregation": complete.PredictSet("worst", "best"),
+		"-address-family":     complete.PredictSet("ipv4", "ipv6", "dual"),
+		"-warning-policy":     complete.PredictSet("exclude", "publish"),
+	})
+}
+
+// AutocompleteArgs returns the predictor of the arguments of the program, which takes none
+func (c *Command) AutocompleteArgs() complete.Predictor { return complete.PredictNothing }
+
+const synopsis = "Verify published records through DNS."
+const help = `
+Usage: consul-ns1 verify [options]
+
+  Resolve the records of all Consul services through DNS and compare the
+  answers with the desired state, reporting records that haven't propagated
+  yet or were changed outside of consul-ns1. Exits with 1 if any record
+  doesn't match.
+
+`
@@ -0,0 +1,38 @@
+package verify
+
+import (
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRun_Flags(t *testing.T) {
+	for _, args := range [][]string{{"-unknown"}, {"-ns1-domain=example.com", "extra"}, {},
+		{"-ns1-domain=example.com", "-timeout=soon"}} {
+		ui := cli.NewMockUi()
+		cmd := Command{UI: ui}
+		assert.Equal(t, 1, cmd.Run(args), args)
+	}
+}
+
+func TestRun(t *testing.T) {
+	fakeNS1, fakeConsul := testutil.NewNS1(), testutil.NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+
+	// no services, no records to resolve
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	assert.Equal(t, 0, cmd.Run([]string{"-http-addr=" + fakeConsul.Address(), "-ns1-domain=example.com"}))
+	assert.Contains(t, ui.OutputWriter.String(), "All 0 records match the desired state")
+
+	// the zone has no nameservers to resolve the records with
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui}
+	assert.Equal(t, 1, cmd.Run([]string{"-ns1-endpoint=" + fakeNS1.Endpoint(), "-ns1-apikey=fake",
+		"-http-addr=" + fakeConsul.Address(), "-ns1-domain=example.com", "-ns1-nameservers"}))
+	assert.Contains(t, ui.ErrorWriter.String(), "No nameservers assigned to zone example.com")
+}
@@ -0,0 +1,158 @@
+package zones
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/hashicorp/consul/command/flags"
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/subcommand"
+	"github.com/posener/complete"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+// Command is the command for listing the zones available to an NS1 API key
+type Command struct {
+	UI cli.Ui
+
+	flags            *flag.FlagSet
+	flagHelpFormat   string
+	flagNS1Domain    string
+	flagNS1Endpoint  string
+	flagNS1APIKey    string
+	flagNS1IgnoreSSL bool
+
+	once sync.Once
+	help string
+}
+
+func (c *Command) init() {
+	c.flags = flag.NewFlagSet("", flag.ContinueOnError)
+
+	c.flags.StringVar(&c.flagNS1Domain, "ns1-domain", "",
+		"Only show this zone, exiting with 1 if it isn't available to the API key. "+
+			"If this is not set then all zones are listed.")
+	c.flags.StringVar(&c.flagNS1Endpoint, "ns1-endpoint", "",
+		"The absolute URL of the NS1 API endpoint. (Defaults to https://api.nsone.net/v1/)")
+	c.flags.StringVar(&c.flagNS1APIKey, "ns1-apikey", "",
+		"The API key to use when communicating with NS1.  This can also be specified via the "+
+			"NS1_APIKEY environment variable.")
+	c.flags.BoolVar(&c.flagNS1IgnoreSSL, "ns1-ignoressl", false,
+		"Ignore SSL validation when communicating with NS1. (Defaults to false)")
+
+	subcommand.HelpFormatVar(c.flags, &c.flagHelpFormat)
+	c.help = flags.Usage(help, c.flags)
+}
+
+// Run lists the zones available to the API key with their record counts and networks
+func (c *Command) Run(args []string) int {
+	c.once.Do(c.init)
+	if err := c.flags.Parse(args); err != nil {
+		return 1
+	}
+	if c.flagHelpFormat != "" {
+		return subcommand.PrintHelp(c.UI, "zones", c.flags, c.flagHelpFormat)
+	}
+	if len(c.flags.Args()) > 0 {
+		c.UI.Error("Should have no non-flag arguments.")
+		return 1
+	}
+	tc := subcommand.DefaultTransportConfig()
+	tc.IgnoreSSL = c.flagNS1IgnoreSSL
+	ns1Client, err := subcommand.NS1Client(c.flagNS1Endpoint, c.flagNS1APIKey, tc)
+	if err != nil {
+		c.UI.Error(fmt.Sprintf("Error retrieving NS1 client: %s", err))
+		return 1
+	}
+
+	var zones []*dns.Zone
+	if c.flagNS1Domain != "" {
+		zone, _, err := ns1Client.Zones.Get(c.flagNS1Domain)
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Zone %s is not available to the API key: %s", c.flagNS1Domain, err))
+			return 1
+		}
+		zones = append(zones, zone)
+	} else {
+		listed, _, err := ns1Client.Zones.List()
+		if err != nil {
+			c.UI.Error(fmt.Sprintf("Error listing zones: %s", err))
+			return 1
+		}
+		// zones are listed without their records
+		for _, z := range listed {
+			zone, _, err := ns1Client.Zones.Get(z.Zone)
+			if err != nil {
+				c.UI.Error(fmt.Sprintf("Error fetching zone %s: %s", z.Zone, err))
+				return 1
+			}
+			zones = append(zones, zone)
+		}
+	}
+	if len(zones) == 0 {
+		c.UI.Warn("No zones are available to the API key")
+		return 0
+	}
+	c.UI.Output(formatZones(zones))
+	return 0
+}
+
+// formatZones returns a table of zones sorted by name with their record counts and networks
+func formatZones(zones []*dns.Zone) string {
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Zone < zones[j].Zone })
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ZONE\tRECORDS\tNETWORKS\tNOTES")
+	for _, z := range zones {
+		networks := make([]string, len(z.NetworkIDs))
+		for i, id := range z.NetworkIDs {
+			networks[i] = fmt.Sprintf("%d", id)
+		}
+		notes := []string{}
+		if z.Link != nil {
+			notes = append(notes, "linked to "+*z.Link)
+		}
+		if z.Secondary != nil && z.Secondary.Enabled {
+			notes = append(notes, "secondary")
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\n", z.Zone, len(z.Records), strings.Join(networks, ","), strings.Join(notes, ", "))
+	}
+	w.Flush()
+	return strings.TrimSuffix(buf.String(), "\n")
+}
+
+// Synopsis returns a short description of the program
+func (c *Command) Synopsis() string { return synopsis }
+
+// Help returns usage info for the program
+func (c *Command) Help() string {
+	c.once.Do(c.init)
+	return c.help
+}
+
+// AutocompleteFlags returns the predictors of the flags of the program
+func (c *Command) AutocompleteFlags() complete.Flags {
+	c.once.Do(c.init)
+	return subcommand.PredictFlags(c.flags, complete.Flags{
+		"-help-format": complete.PredictSet(subcommand.HelpFormatJSON),
+		"-ns1-domain":  subcommand.PredictZones(),
+	})
+}
+
+// AutocompleteArgs returns the predictor of the arguments of the program, which takes none
+func (c *Command) AutocompleteArgs() complete.Predictor { return complete.PredictNothing }
+
+const synopsis = "List the NS1 zones available to the API key."
+const help = `
+Usage: consul-ns1 zones [options]
+
+  List the zones available to the NS1 API key with their number of records
+  and the networks they are served on, to pick and verify the -ns1-domain
+  services are synced to. Linked and secondary zones can't be synced to.
+
+`
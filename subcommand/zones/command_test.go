@@ -0,0 +1,39 @@
+package zones
+
+import (
+	"testing"
+
+	"github.com/mitchellh/cli"
+	"github.com/nsone/consul-ns1/testutil"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestRun_Flags(t *testing.T) {
+	for _, args := range [][]string{{"-unknown"}, {"extra"}} {
+		ui := cli.NewMockUi()
+		cmd := Command{UI: ui}
+		assert.Equal(t, 1, cmd.Run(append([]string{"-ns1-apikey=fake"}, args...)), args)
+	}
+}
+
+func TestRun(t *testing.T) {
+	fakeNS1 := testutil.NewNS1()
+	defer fakeNS1.Close()
+	fakeNS1.AddZone("example.com")
+	fakeNS1.AddZone("example.org")
+	fakeNS1.SetRecord(&dns.Record{Zone: "example.com", Domain: "web.example.com", Type: "A",
+		Answers: []*dns.Answer{dns.NewAv4Answer("1.1.1.1")}})
+
+	ui := cli.NewMockUi()
+	cmd := Command{UI: ui}
+	assert.Equal(t, 0, cmd.Run([]string{"-ns1-endpoint=" + fakeNS1.Endpoint(), "-ns1-apikey=fake"}))
+	assert.Contains(t, ui.OutputWriter.String(), "example.com  1")
+	assert.Contains(t, ui.OutputWriter.String(), "example.org  0")
+
+	// a zone that isn't available exits with 1
+	ui = cli.NewMockUi()
+	cmd = Command{UI: ui}
+	assert.Equal(t, 1, cmd.Run([]string{"-ns1-endpoint=" + fakeNS1.Endpoint(), "-ns1-apikey=fake", "-ns1-domain=example.net"}))
+	assert.Contains(t, ui.ErrorWriter.String(), "example.net is not available")
+}
@@ -0,0 +1,337 @@
+package testutil
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+)
+
+// maxBlockingWait is the longest a blocking query of the fake Consul API waits for a change, so tests using the
+// default wait time of the sync don't hang
+const maxBlockingWait = time.Second
+
+// Instance is a service instance registered in the fake Consul catalog
+type Instance struct {
+	Node       string
+	Address    string
+	Datacenter string
+	NodeMeta   map[string]string
+	// ID is the ID of the instance, the service name if empty
+	ID      string
+	Service string
+	// ServiceAddress is the address of the instance, the address of the node if empty
+	ServiceAddress string
+	Port           int
+	Tags           []string
+	Meta           map[string]string
+	// ProxyFor is the service fronted by the instance if it is a Connect proxy
+	ProxyFor string
+	// Status is the status of the health check of the instance, "passing" if empty
+	Status string
+}
+
+// id returns the ID of an instance
+func (i Instance) id() string {
+	if i.ID == "" {
+		return i.Service
+	}
+	return i.ID
+}
+
+// status returns the health status of an instance
+func (i Instance) status() string {
+	if i.Status == "" {
+		return consulapi.HealthPassing
+	}
+	return i.Status
+}
+
+// Consul is an in-memory fake of the catalog, health and KV endpoints of the Consul API. Catalog queries block
+// until the catalog changes, like Consul does, for at most a second. Other endpoints respond with 404.
+type Consul struct {
+	server    *httptest.Server
+	lock      sync.Mutex
+	index     uint64
+	changed   chan struct{}
+	instances map[string]Instance
+	kv        map[string][]byte
+	failures  failures
+}
+
+// NewConsul starts a fake Consul API with an empty catalog. It must be closed.
+func NewConsul() *Consul {
+	f := &Consul{index: 1, changed: make(chan struct{}), instances: map[string]Instance{}, kv: map[string][]byte{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Close stops the fake API
+func (f *Consul) Close() {
+	f.server.Close()
+}
+
+// Client returns a Consul API client talking to the fake API
+func (f *Consul) Client() *consulapi.Client {
+	client, err := consulapi.NewClient(&consulapi.Config{Address: f.Address(), HttpClient: f.server.Client()})
+	if err != nil {
+		// the configuration is always valid
+		panic(err)
+	}
+	return client
+}
+
+// Address returns the address of the fake API, e.g. to pass to the -http-addr flag of a command
+func (f *Consul) Address() string {
+	return f.server.URL
+}
+
+// Register registers an instance, or replaces the instance with the same node and ID
+func (f *Consul) Register(i Instance) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.instances[i.Node+"/"+i.id()] = i
+	f.bump()
+}
+
+// Deregister removes an instance
+func (f *Consul) Deregister(node, id string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.instances, node+"/"+id)
+	f.bump()
+}
+
+// SetStatus changes the health status of an instance, e.g. to "critical"
+func (f *Consul) SetStatus(node, id, status string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	i, ok := f.instances[node+"/"+id]
+	if !ok {
+		return
+	}
+	i.Status = status
+	f.instances[node+"/"+id] = i
+	f.bump()
+}
+
+// PutKV writes a KV entry, e.g. a filter chain or rollout read by the sync
+func (f *Consul) PutKV(key string, value []byte) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.kv[key] = value
+	f.bump()
+}
+
+// DeleteKV removes a KV entry
+func (f *Consul) DeleteKV(key string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	delete(f.kv, key)
+	f.bump()
+}
+
+// Fail scripts the next `times` requests with `method` whose path starts with `path` to fail with an HTTP status
+// and message, or every such request if `times` is 0. Paths are relative to the API root, e.g. "catalog/services",
+// and an empty method matches every method.
+func (f *Consul) Fail(method, path string, status int, message string, times int) {
+	f.failures.add(method, path, status, message, times)
+}
+
+// Recover removes the scripted failures
+func (f *Consul) Recover() {
+	f.failures.reset()
+}
+
+// bump increases the index and wakes up the blocking queries, the lock must be held
+func (f *Consul) bump() {
+	f.index++
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// serve routes the requests to the catalog, health and KV endpoints
+func (f *Consul) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if f.failures.fail(w, r, path) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	f.wait(r)
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(f.index, 10))
+	switch {
+	case path == "catalog/services":
+		writeJSON(w, http.StatusOK, f.services())
+	case strings.HasPrefix(path, "catalog/service/"):
+		writeJSON(w, http.StatusOK, f.catalogService(strings.TrimPrefix(path, "catalog/service/"), false))
+	case strings.HasPrefix(path, "catalog/connect/"):
+		writeJSON(w, http.StatusOK, f.catalogService(strings.TrimPrefix(path, "catalog/connect/"), true))
+	case strings.HasPrefix(path, "health/service/"):
+		writeJSON(w, http.StatusOK, f.healthService(strings.TrimPrefix(path, "health/service/"), false, passing(r)))
+	case strings.HasPrefix(path, "health/connect/"):
+		writeJSON(w, http.StatusOK, f.healthService(strings.TrimPrefix(path, "health/connect/"), true, passing(r)))
+	case strings.HasPrefix(path, "kv/"):
+		f.serveKV(w, r, strings.TrimPrefix(path, "kv/"))
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// wait blocks a query with an `index` until the catalog changes past the index or its wait time elapsed
+func (f *Consul) wait(r *http.Request) {
+	index, err := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+	if err != nil || index == 0 {
+		return
+	}
+	wait := maxBlockingWait
+	if d, err := time.ParseDuration(r.URL.Query().Get("wait")); err == nil && d < wait {
+		wait = d
+	}
+	f.lock.Lock()
+	current, changed := f.index, f.changed
+	f.lock.Unlock()
+	if current > index {
+		return
+	}
+	select {
+	case <-changed:
+	case <-time.After(wait):
+	case <-r.Context().Done():
+	}
+}
+
+// sortedInstances returns the instances sorted by node and ID, the lock must be held
+func (f *Consul) sortedInstances() []Instance {
+	keys := make([]string, 0, len(f.instances))
+	for k := range f.instances {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	instances := make([]Instance, 0, len(keys))
+	for _, k := range keys {
+		instances = append(instances, f.instances[k])
+	}
+	return instances
+}
+
+// services returns the tags of each service, the lock must be held
+func (f *Consul) services() map[string][]string {
+	services := map[string][]string{}
+	for _, i := range f.sortedInstances() {
+		tags := services[i.Service]
+		if tags == nil {
+			tags = []string{}
+		}
+		for _, t := range i.Tags {
+			if !contains(tags, t) {
+				tags = append(tags, t)
+			}
+		}
+		services[i.Service] = tags
+	}
+	return services
+}
+
+// matches reports whether an instance is an instance of a service, or a Connect proxy fronting it
+func (i Instance) matches(service string, connect bool) bool {
+	if connect {
+		return i.ProxyFor == service
+	}
+	return i.Service == service
+}
+
+// catalogService returns the instances of a service, or the Connect proxies fronting it, the lock must be held
+func (f *Consul) catalogService(service string, connect bool) []*consulapi.CatalogService {
+	entries := []*consulapi.CatalogService{}
+	for _, i := range f.sortedInstances() {
+		if !i.matches(service, connect) {
+			continue
+		}
+		e := &consulapi.CatalogService{
+			Node: i.Node, Address: i.Address, Datacenter: i.Datacenter, NodeMeta: i.NodeMeta,
+			ServiceID: i.id(), ServiceName: i.Service, ServiceAddress: i.ServiceAddress, ServicePort: i.Port,
+			ServiceTags: i.Tags, ServiceMeta: i.Meta, ServiceWeights: consulapi.Weights{Passing: 1, Warning: 1},
+		}
+		if i.ProxyFor != "" {
+			e.ServiceProxy = &consulapi.AgentServiceConnectProxyConfig{DestinationServiceName: i.ProxyFor}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// passing reports whether a health query only asks for passing instances
+func passing(r *http.Request) bool {
+	_, ok := r.URL.Query()["passing"]
+	return ok
+}
+
+// healthService returns the instances of a service, or the Connect proxies fronting it, with a health check
+// each, the lock must be held
+func (f *Consul) healthService(service string, connect, passingOnly bool) []*consulapi.ServiceEntry {
+	entries := []*consulapi.ServiceEntry{}
+	for _, i := range f.sortedInstances() {
+		if !i.matches(service, connect) || (passingOnly && i.status() != consulapi.HealthPassing) {
+			continue
+		}
+		e := &consulapi.ServiceEntry{
+			Node: &consulapi.Node{Node: i.Node, Address: i.Address, Datacenter: i.Datacenter, Meta: i.NodeMeta},
+			Service: &consulapi.AgentService{
+				ID: i.id(), Service: i.Service, Tags: i.Tags, Meta: i.Meta, Port: i.Port, Address: i.ServiceAddress,
+				Weights: consulapi.AgentWeights{Passing: 1, Warning: 1},
+			},
+			Checks: consulapi.HealthChecks{{
+				Node: i.Node, CheckID: "service:" + i.id(), Name: fmt.Sprintf("Service '%s' check", i.Service),
+				Status: i.status(), ServiceID: i.id(), ServiceName: i.Service,
+			}},
+		}
+		if i.ProxyFor != "" {
+			e.Service.Kind = consulapi.ServiceKindConnectProxy
+			e.Service.Proxy = &consulapi.AgentServiceConnectProxyConfig{DestinationServiceName: i.ProxyFor}
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// serveKV responds with a KV entry, or the entries under a prefix with `recurse`, the lock must be held
+func (f *Consul) serveKV(w http.ResponseWriter, r *http.Request, key string) {
+	_, recurse := r.URL.Query()["recurse"]
+	keys := []string{}
+	for k := range f.kv {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			keys = append(keys, k)
+		}
+	}
+	if len(keys) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	sort.Strings(keys)
+	pairs := []*consulapi.KVPair{}
+	for _, k := range keys {
+		pairs = append(pairs, &consulapi.KVPair{Key: k, Value: f.kv[k], ModifyIndex: f.index})
+	}
+	writeJSON(w, http.StatusOK, pairs)
+}
+
+// contains reports whether a list holds a string
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
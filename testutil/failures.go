@@ -0,0 +1,78 @@
+package testutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// failure is a scripted failure of the requests matching a method and a path prefix
+type failure struct {
+	method  string
+	path    string
+	status  int
+	message string
+	// times is the number of requests left to fail, negative to fail every request
+	times int
+}
+
+// failures holds the scripted failures of a fake API
+type failures struct {
+	lock sync.Mutex
+	list []*failure
+}
+
+// add scripts a failure, see `NS1.Fail`
+func (f *failures) add(method, path string, status int, message string, times int) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if times == 0 {
+		times = -1
+	}
+	f.list = append(f.list, &failure{method: method, path: path, status: status, message: message, times: times})
+}
+
+// reset removes the scripted failures
+func (f *failures) reset() {
+	f.lock.Lock()
+	f.list = nil
+	f.lock.Unlock()
+}
+
+// fail responds with the first scripted failure matching a request whose path is relative to the API root, and
+// reports whether it did
+func (f *failures) fail(w http.ResponseWriter, r *http.Request, path string) bool {
+	f.lock.Lock()
+	var match *failure
+	for i, fl := range f.list {
+		if (fl.method == "" || fl.method == r.Method) && strings.HasPrefix(path, fl.path) {
+			match = fl
+			if fl.times > 0 {
+				fl.times--
+				if fl.times == 0 {
+					f.list = append(f.list[:i], f.list[i+1:]...)
+				}
+			}
+			break
+		}
+	}
+	f.lock.Unlock()
+	if match == nil {
+		return false
+	}
+	writeError(w, match.status, match.message)
+	return true
+}
+
+// writeJSON responds with a JSON body
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError responds with an error message, the way the NS1 API does
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"message": message})
+}
@@ -0,0 +1,393 @@
+// Package testutil provides in-memory fakes of the NS1 and Consul HTTP APIs, so configurations of the catalog
+// sync can be tested without network access. The fakes serve the endpoints the sync uses on a local HTTP server
+// and hand out API clients talking to it, to be passed to catalog.Sync, catalog.Plan and the other entry points.
+// Failures can be scripted per endpoint.
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/data"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/filter"
+)
+
+// NS1 is an in-memory fake of the zones and records endpoints of the NS1 API. Other endpoints respond with 404.
+type NS1 struct {
+	server   *httptest.Server
+	lock     sync.Mutex
+	zones    map[string]*dns.Zone
+	records  map[string]*dns.Record
+	lastID   int
+	failures failures
+	// readOnlyRequests counts the requests made with the read-only key
+	readOnlyRequests int
+	// writes counts the records created, updated and deleted, and polls the zones read
+	writes int
+	polls  int
+}
+
+// readOnlyKey is the API key of the clients returned by ReadOnlyClient
+const readOnlyKey = "fake-read-only"
+
+// NewNS1 starts a fake NS1 API without zones. It must be closed.
+func NewNS1() *NS1 {
+	f := &NS1{zones: map[string]*dns.Zone{}, records: map[string]*dns.Record{}}
+	f.server = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+// Close stops the fake API
+func (f *NS1) Close() {
+	f.server.Close()
+}
+
+// Client returns an NS1 API client talking to the fake API
+func (f *NS1) Client() *ns1api.Client {
+	return ns1api.NewClient(f.server.Client(), ns1api.SetAPIKey("fake"), ns1api.SetEndpoint(f.Endpoint()))
+}
+
+// Endpoint returns the URL of the fake API, e.g. to pass to the -ns1-endpoint flag of a command
+func (f *NS1) Endpoint() string {
+	return f.server.URL + "/v1/"
+}
+
+// ReadOnlyClient returns an NS1 API client talking to the fake API with a key that may only read, its other
+// requests fail like NS1 does for keys without write permissions
+func (f *NS1) ReadOnlyClient() *ns1api.Client {
+	return ns1api.NewClient(f.server.Client(), ns1api.SetAPIKey(readOnlyKey), ns1api.SetEndpoint(f.Endpoint()))
+}
+
+// ReadOnlyRequests returns the number of requests made with the clients returned by ReadOnlyClient
+func (f *NS1) ReadOnlyRequests() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.readOnlyRequests
+}
+
+// Writes returns the number of records created, updated and deleted through the API, e.g. to check that a sync
+// in a steady state doesn't write
+func (f *NS1) Writes() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.writes
+}
+
+// Polls returns the number of zones read through the API, e.g. to wait for the next polls of a sync
+func (f *NS1) Polls() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.polls
+}
+
+// AddZone creates an empty zone
+func (f *NS1) AddZone(name string) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.lastID++
+	f.zones[name] = &dns.Zone{ID: fmt.Sprintf("%d", f.lastID), Zone: name, TTL: 3600}
+}
+
+// SetRecord creates or replaces a record, e.g. to seed a zone with records managed by others
+func (f *NS1) SetRecord(r *dns.Record) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	stored := copyRecord(r)
+	if stored.ID == "" {
+		f.lastID++
+		stored.ID = fmt.Sprintf("%d", f.lastID)
+	}
+	f.records[recordKey(r.Zone, r.Domain, r.Type)] = stored
+}
+
+// Record returns a copy of a record, nil if it doesn't exist
+func (f *NS1) Record(zone, domain, t string) *dns.Record {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	r, ok := f.records[recordKey(zone, domain, t)]
+	if !ok {
+		return nil
+	}
+	return copyRecord(r)
+}
+
+// Records returns copies of the records of a zone, sorted by domain and type
+func (f *NS1) Records(zone string) []*dns.Record {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	records := []*dns.Record{}
+	for _, r := range f.records {
+		if r.Zone == zone {
+			records = append(records, copyRecord(r))
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return recordKey("", records[i].Domain, records[i].Type) < recordKey("", records[j].Domain, records[j].Type)
+	})
+	return records
+}
+
+// Fail scripts the next `times` requests with `method` whose path starts with `path` to fail with an HTTP status
+// and message, or every such request if `times` is 0. Paths are relative to the API root, e.g.
+// "zones/example.com/web.example.com/A", and an empty method matches every method. NS1 API clients map some
+// messages to typed errors, e.g. "record not found" to ns1api.ErrRecordMissing.
+func (f *NS1) Fail(method, path string, status int, message string, times int) {
+	f.failures.add(method, path, status, message, times)
+}
+
+// Recover removes the scripted failures
+func (f *NS1) Recover() {
+	f.failures.reset()
+}
+
+// serve routes the requests to the zones and records endpoints
+func (f *NS1) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v1/")
+	if f.failures.fail(w, r, path) {
+		return
+	}
+	parts := strings.Split(path, "/")
+	if parts[0] != "zones" {
+		writeError(w, http.StatusNotFound, "not found")
+		return
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if r.Header.Get("X-NSONE-Key") == readOnlyKey {
+		f.readOnlyRequests++
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusForbidden, "insufficient permissions")
+			return
+		}
+	}
+	switch len(parts) {
+	case 1:
+		f.serveZones(w, r)
+	case 2:
+		f.serveZone(w, r, parts[1])
+	case 4:
+		f.serveRecord(w, r, parts[1], parts[2], parts[3])
+	default:
+		writeError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// serveZones lists the zones
+func (f *NS1) serveZones(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	zones := []*dns.Zone{}
+	for _, z := range f.zones {
+		zones = append(zones, z)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Zone < zones[j].Zone })
+	writeJSON(w, http.StatusOK, zones)
+}
+
+// serveZone gets, creates, updates or deletes a zone. Zones are returned with the short answers of their records.
+func (f *NS1) serveZone(w http.ResponseWriter, r *http.Request, name string) {
+	z, ok := f.zones[name]
+	if r.Method == http.MethodPut {
+		if ok {
+			writeError(w, http.StatusBadRequest, "zone already exists")
+			return
+		}
+		z = &dns.Zone{}
+		if err := json.NewDecoder(r.Body).Decode(z); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.lastID++
+		z.ID, z.Zone = fmt.Sprintf("%d", f.lastID), name
+		f.zones[name] = z
+		writeJSON(w, http.StatusOK, z)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "zone not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		f.polls++
+		copied := *z
+		copied.Records = []*dns.ZoneRecord{}
+		for _, rec := range f.records {
+			if rec.Zone != name {
+				continue
+			}
+			short := []string{}
+			for _, a := range rec.Answers {
+				short = append(short, strings.Join(a.Rdata, " "))
+			}
+			copied.Records = append(copied.Records, &dns.ZoneRecord{
+				Domain: rec.Domain, ID: rec.ID, Link: rec.Link, ShortAns: short, TTL: rec.TTL, Type: rec.Type,
+			})
+		}
+		sort.Slice(copied.Records, func(i, j int) bool {
+			return recordKey("", copied.Records[i].Domain, copied.Records[i].Type) <
+				recordKey("", copied.Records[j].Domain, copied.Records[j].Type)
+		})
+		writeJSON(w, http.StatusOK, &copied)
+	case http.MethodPost:
+		if err := json.NewDecoder(r.Body).Decode(z); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, z)
+	case http.MethodDelete:
+		delete(f.zones, name)
+		for k, rec := range f.records {
+			if rec.Zone == name {
+				delete(f.records, k)
+			}
+		}
+		writeJSON(w, http.StatusOK, map[string]string{})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// serveRecord gets, creates, updates or deletes a record. Updates only change the fields they hold, like NS1 does.
+func (f *NS1) serveRecord(w http.ResponseWriter, r *http.Request, zone, domain, t string) {
+	if _, ok := f.zones[zone]; !ok {
+		writeError(w, http.StatusNotFound, "zone not found")
+		return
+	}
+	key := recordKey(zone, domain, t)
+	rec, ok := f.records[key]
+	switch r.Method {
+	case http.MethodGet:
+		if !ok {
+			writeError(w, http.StatusNotFound, "record not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, rec)
+	case http.MethodPut:
+		if ok {
+			writeError(w, http.StatusBadRequest, "record already exists")
+			return
+		}
+		rec = &dns.Record{}
+		if err := json.NewDecoder(r.Body).Decode(rec); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.lastID++
+		f.writes++
+		rec.ID, rec.Zone, rec.Domain, rec.Type = fmt.Sprintf("%d", f.lastID), zone, domain, t
+		f.records[key] = rec
+		writeJSON(w, http.StatusOK, rec)
+	case http.MethodPost:
+		if !ok {
+			writeError(w, http.StatusNotFound, "record not found")
+			return
+		}
+		changes := &dns.Record{}
+		if err := json.NewDecoder(r.Body).Decode(changes); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		f.writes++
+		updated := mergeRecord(rec, changes)
+		updated.ID, updated.Zone, updated.Domain, updated.Type = rec.ID, zone, domain, t
+		f.records[key] = updated
+		writeJSON(w, http.StatusOK, updated)
+	case http.MethodDelete:
+		if !ok {
+			writeError(w, http.StatusNotFound, "record not found")
+			return
+		}
+		f.writes++
+		delete(f.records, key)
+		writeJSON(w, http.StatusOK, map[string]string{})
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// mergeRecord returns a copy of a record with the fields set in `changes` replaced
+func mergeRecord(rec, changes *dns.Record) *dns.Record {
+	merged := copyRecord(rec)
+	if changes.Meta != nil {
+		merged.Meta = changes.Meta
+	}
+	if changes.Link != "" {
+		merged.Link = changes.Link
+	}
+	if changes.TTL != 0 {
+		merged.TTL = changes.TTL
+	}
+	if changes.UseClientSubnet != nil {
+		merged.UseClientSubnet = changes.UseClientSubnet
+	}
+	if changes.Answers != nil {
+		merged.Answers = changes.Answers
+	}
+	if changes.Filters != nil {
+		merged.Filters = changes.Filters
+	}
+	if changes.Regions != nil {
+		merged.Regions = changes.Regions
+	}
+	return merged
+}
+
+// copyRecord returns a deep copy of a record, so the stored records never share memory with callers
+func copyRecord(r *dns.Record) *dns.Record {
+	copied := *r
+	if r.Meta != nil {
+		meta := *r.Meta
+		copied.Meta = &meta
+	}
+	if r.UseClientSubnet != nil {
+		useClientSubnet := *r.UseClientSubnet
+		copied.UseClientSubnet = &useClientSubnet
+	}
+	if r.Answers != nil {
+		copied.Answers = make([]*dns.Answer, len(r.Answers))
+		for i, a := range r.Answers {
+			answer := *a
+			answer.Rdata = append([]string(nil), a.Rdata...)
+			if a.Meta != nil {
+				meta := *a.Meta
+				answer.Meta = &meta
+			}
+			copied.Answers[i] = &answer
+		}
+	}
+	if r.Filters != nil {
+		copied.Filters = make([]*filter.Filter, len(r.Filters))
+		for i, fl := range r.Filters {
+			copiedFilter := *fl
+			if fl.Config != nil {
+				copiedFilter.Config = filter.Config{}
+				for k, v := range fl.Config {
+					copiedFilter.Config[k] = v
+				}
+			}
+			copied.Filters[i] = &copiedFilter
+		}
+	}
+	if r.Regions != nil {
+		copied.Regions = data.Regions{}
+		for name, region := range r.Regions {
+			copied.Regions[name] = region
+		}
+	}
+	return &copied
+}
+
+// recordKey is the key of a record, domains being case-insensitive
+func recordKey(zone, domain, t string) string {
+	return strings.ToLower(zone) + "/" + strings.ToLower(domain) + "/" + t
+}
@@ -0,0 +1,485 @@
+package testutil
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	consulapi "github.com/hashicorp/consul/api"
+	"github.com/hashicorp/go-hclog"
+	"github.com/nsone/consul-ns1/catalog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ns1api "gopkg.in/ns1/ns1-go.v2/rest"
+	"gopkg.in/ns1/ns1-go.v2/rest/model/dns"
+)
+
+func TestNS1(t *testing.T) {
+	f := NewNS1()
+	defer f.Close()
+	client := f.Client()
+
+	_, _, err := client.Zones.Get("example.com")
+	assert.Equal(t, ns1api.ErrZoneMissing, err)
+	f.AddZone("example.com")
+
+	r := dns.NewRecord("example.com", "web.example.com", "A")
+	r.AddAnswer(dns.NewAv4Answer("1.1.1.1"))
+	_, err = client.Records.Create(r)
+	require.NoError(t, err)
+	assert.NotEmpty(t, r.ID)
+	_, err = client.Records.Create(r)
+	assert.Equal(t, ns1api.ErrRecordExists, err)
+
+	r.Answers = nil
+	r.AddAnswer(dns.NewAv4Answer("2.2.2.2"))
+	_, err = client.Records.Update(r)
+	require.NoError(t, err)
+	z, _, err := client.Zones.Get("example.com")
+	require.NoError(t, err)
+	require.Len(t, z.Records, 1)
+	assert.Equal(t, []string{"2.2.2.2"}, z.Records[0].ShortAns)
+	assert.Equal(t, 2, f.Writes())
+	assert.Equal(t, 1, f.Polls())
+
+	// scripted failures
+	f.Fail(http.MethodGet, "zones/example.com/web.example.com", http.StatusNotFound, "record not found", 1)
+	_, _, err = client.Records.Get("example.com", "web.example.com", "A")
+	assert.Equal(t, ns1api.ErrRecordMissing, err)
+	_, _, err = client.Records.Get("example.com", "web.example.com", "A")
+	assert.NoError(t, err)
+	f.Fail("", "zones", http.StatusInternalServerError, "unavailable", 0)
+	_, _, err = client.Zones.Get("example.com")
+	assert.Error(t, err)
+	_, _, err = client.Zones.Get("example.com")
+	assert.Error(t, err)
+	f.Recover()
+
+	_, err = client.Records.Delete("example.com", "web.example.com", "A")
+	require.NoError(t, err)
+	assert.Nil(t, f.Record("example.com", "web.example.com", "A"))
+	_, err = client.Records.Update(r)
+	assert.Equal(t, ns1api.ErrRecordMissing, err)
+}
+
+func TestConsul(t *testing.T) {
+	f := NewConsul()
+	defer f.Close()
+	client := f.Client()
+
+	f.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80, Tags: []string{"v1"}})
+	f.Register(Instance{Node: "n2", Address: "2.2.2.2", Service: "web", Port: 80, Tags: []string{"v2"}, Status: "critical"})
+	services, meta, err := client.Catalog().Services(nil)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"web": {"v1", "v2"}}, services)
+
+	nodes, _, err := client.Catalog().Service("web", "", nil)
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+	assert.Equal(t, "web", nodes[0].ServiceID)
+	entries, _, err := client.Health().Service("web", "", true, nil)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "n1", entries[0].Node.Node)
+
+	// blocking queries return once the catalog changed
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		f.Deregister("n2", "web")
+	}()
+	services, next, err := client.Catalog().Services(&consulapi.QueryOptions{WaitIndex: meta.LastIndex, WaitTime: time.Minute})
+	require.NoError(t, err)
+	assert.True(t, next.LastIndex > meta.LastIndex)
+	assert.Equal(t, map[string][]string{"web": {"v1"}}, services)
+
+	f.PutKV("ns1/filters/web", []byte("[]"))
+	pairs, _, err := client.KV().List("ns1/filters/", nil)
+	require.NoError(t, err)
+	require.Len(t, pairs, 1)
+	pair, _, err := client.KV().Get("ns1/missing", nil)
+	require.NoError(t, err)
+	assert.Nil(t, pair)
+
+	f.Fail("", "catalog/services", http.StatusInternalServerError, "no leader", 1)
+	_, _, err = client.Catalog().Services(nil)
+	assert.Error(t, err)
+}
+
+// steady waits for the polls following the writes of a sync, and fails the test if the sync writes again while it
+// polls a few more times, which a sync does when the records it writes don't read back as it wrote them
+func steady(t *testing.T, f *NS1) {
+	polls := f.Polls()
+	eventually(t, func() bool { return f.Polls() >= polls+1 })
+	writes, polls := f.Writes(), f.Polls()
+	eventually(t, func() bool { return f.Polls() >= polls+2 })
+	assert.Equal(t, writes, f.Writes(), "records are rewritten in a steady state")
+}
+
+// syncBuffer is a buffer safe for concurrent writes, collecting the logs of a sync
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+// contains reports whether a message was written
+func (b *syncBuffer) contains(s string) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return strings.Contains(b.buf.String(), s)
+}
+
+// eventually waits until a condition holds, failing the test after 10 seconds
+func eventually(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestSync(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	published := func(domain string) []string {
+		answers := []string{}
+		if r := fakeNS1.Record("example.com", domain, "A"); r != nil {
+			for _, a := range r.Answers {
+				answers = append(answers, a.Rdata...)
+			}
+		}
+		return answers
+	}
+	eventually(t, func() bool { return len(published("web.example.com")) == 1 })
+	assert.Equal(t, []string{"1.1.1.1"}, published("web.example.com"))
+
+	fakeConsul.Register(Instance{Node: "n2", Address: "2.2.2.2", Service: "web", Port: 80})
+	eventually(t, func() bool { return len(published("web.example.com")) == 2 })
+	steady(t, fakeNS1)
+}
+
+func TestSync_ReadClient(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	_, err := fakeNS1.ReadOnlyClient().Records.Create(dns.NewRecord("example.com", "web.example.com", "A"))
+	require.Error(t, err, "read-only keys can't write")
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60,
+		NS1ReadClient: fakeNS1.ReadOnlyClient()}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	// records are written with the client passed to Sync and the zone is polled with the read client
+	eventually(t, func() bool { return fakeNS1.Record("example.com", "web.example.com", "A") != nil })
+	before := fakeNS1.ReadOnlyRequests()
+	assert.True(t, before > 0)
+	eventually(t, func() bool { return fakeNS1.ReadOnlyRequests() > before })
+}
+
+func TestSync_FeatureFlags(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.PutKV("consul-ns1/flags/observe-only", []byte("true"))
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	// cycles log when flags keep them from writing
+	logs := &syncBuffer{}
+	previous := hclog.SetDefault(hclog.New(&hclog.LoggerOptions{Output: logs}))
+	defer hclog.SetDefault(previous)
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60,
+		FeatureFlagsKVPrefix: "consul-ns1/flags"}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	// nothing is written while observing
+	eventually(t, func() bool { return logs.contains("observe-only feature flag set, not writing to NS1") })
+	assert.Nil(t, fakeNS1.Record("example.com", "web.example.com", "A"))
+
+	fakeConsul.PutKV("consul-ns1/flags/observe-only", []byte("false"))
+	fakeConsul.PutKV("consul-ns1/flags/deletes", []byte("false"))
+	eventually(t, func() bool { return fakeNS1.Record("example.com", "web.example.com", "A") != nil })
+
+	// records of services gone from Consul are kept until deletes are enabled again
+	fakeConsul.Deregister("n1", "web")
+	eventually(t, func() bool { return logs.contains("deletes feature flag disabled") })
+	assert.NotNil(t, fakeNS1.Record("example.com", "web.example.com", "A"))
+	fakeConsul.DeleteKV("consul-ns1/flags/deletes")
+	eventually(t, func() bool { return fakeNS1.Record("example.com", "web.example.com", "A") == nil })
+}
+
+func TestPlan_DomainTemplate(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60,
+		DomainTemplate: "{prefix}{service}-svc.{zone}"}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	eventually(t, func() bool {
+		return fakeNS1.Record("example.com", "web-svc.example.com", "A") != nil &&
+			fakeNS1.Record("example.com", "web-svc.example.com", "SRV") != nil
+	})
+	close(stop)
+	<-stopped
+
+	// plans name services like the sync does
+	plans, err := catalog.Plan(cfg, fakeNS1.Client(), fakeConsul.Client())
+	require.NoError(t, err)
+	assert.Empty(t, plans)
+}
+
+func TestSync_ErrorClass(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60, AdminAddr: "not an address"}
+	err := catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), make(chan struct{}), make(chan struct{}))
+	assert.Equal(t, catalog.ErrInvalidConfig, catalog.ErrorClass(err))
+	assert.Equal(t, 2, catalog.ExitCode(err))
+}
+
+func TestSync_TTLJitter(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60, NS1DNSTTLJitter: 50}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	// records are written with their jittered TTL, so the next cycles don't rewrite them
+	eventually(t, func() bool {
+		a, srv := fakeNS1.Record("example.com", "web.example.com", "A"), fakeNS1.Record("example.com", "web.example.com", "SRV")
+		return a != nil && srv != nil
+	})
+	steady(t, fakeNS1)
+	for _, rt := range []string{"A", "SRV"} {
+		ttl := fakeNS1.Record("example.com", "web.example.com", rt).TTL
+		assert.True(t, ttl >= 30 && ttl <= 90, "TTL %d of the %s record is jittered", ttl, rt)
+	}
+}
+
+func TestSync_TTLOverride(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80,
+		Meta: map[string]string{"ns1-ttl": "30"}})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	ttl := func(t string) int {
+		if r := fakeNS1.Record("example.com", "web.example.com", t); r != nil {
+			return r.TTL
+		}
+		return 0
+	}
+	eventually(t, func() bool { return ttl("A") == 30 && ttl("SRV") == 30 })
+	steady(t, fakeNS1)
+
+	// records are updated when the override changes
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80,
+		Meta: map[string]string{"ns1-ttl": "45"}})
+	eventually(t, func() bool { return ttl("A") == 45 && ttl("SRV") == 45 })
+	steady(t, fakeNS1)
+}
+
+func TestSync_FilterOverride(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	filters := func(t string) []string {
+		types := []string{}
+		if r := fakeNS1.Record("example.com", "web.example.com", t); r != nil {
+			for _, f := range r.Filters {
+				types = append(types, f.Type)
+			}
+		}
+		return types
+	}
+	eventually(t, func() bool { return fakeNS1.Record("example.com", "web.example.com", "SRV") != nil })
+
+	// the records of a published service get the chain overriding the default
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80,
+		Meta: map[string]string{"ns1-filters": `[{"filter":"up","config":{}},{"filter":"shuffle","config":{}}]`}})
+	eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"up", "shuffle"}, filters("A")) &&
+			assert.ObjectsAreEqual([]string{"up", "shuffle"}, filters("SRV"))
+	})
+	steady(t, fakeNS1)
+}
+
+func TestSync_ClientSubnet(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	disabled := func(t string) bool {
+		r := fakeNS1.Record("example.com", "web.example.com", t)
+		return r != nil && r.UseClientSubnet != nil && !*r.UseClientSubnet
+	}
+	eventually(t, func() bool { return fakeNS1.Record("example.com", "web.example.com", "SRV") != nil })
+
+	// the records of a published service get the option declared in its meta
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80,
+		Meta: map[string]string{"ns1-use-client-subnet": "false"}})
+	eventually(t, func() bool { return disabled("A") && disabled("SRV") })
+	steady(t, fakeNS1)
+}
+
+func TestSync_GeoMetadata(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Datacenter: "dc1", NodeMeta: map[string]string{"country": "us"},
+		Service: "web", Port: 80})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60,
+		GeoMetadata: true, GeoRegions: []string{"dc1=US-EAST"}}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	// the location of the answers is written once, meta lists are decoded as []interface{} by the fake
+	eventually(t, func() bool {
+		r := fakeNS1.Record("example.com", "web.example.com", "A")
+		return r != nil && len(r.Answers) == 1 && r.Answers[0].Meta != nil &&
+			fmt.Sprint(r.Answers[0].Meta.Georegion) == "[US-EAST]" && fmt.Sprint(r.Answers[0].Meta.Country) == "[US]"
+	})
+	steady(t, fakeNS1)
+}
+
+func TestSync_SRVTargetHostnames(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60, SRVTargetHostnames: true}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+
+	// SRV answers point to the A record of the node, which has no SRV record of its own
+	eventually(t, func() bool {
+		r := fakeNS1.Record("example.com", "web.example.com", "SRV")
+		return r != nil && len(r.Answers) == 1 &&
+			assert.ObjectsAreEqual([]string{"1", "1", "80", "n1.web.example.com"}, r.Answers[0].Rdata) &&
+			fakeNS1.Record("example.com", "n1.web.example.com", "A") != nil
+	})
+	steady(t, fakeNS1)
+	assert.Nil(t, fakeNS1.Record("example.com", "n1.web.example.com", "SRV"))
+}
+
+func TestSync_DualAddressFamily(t *testing.T) {
+	fakeNS1, fakeConsul := NewNS1(), NewConsul()
+	defer fakeNS1.Close()
+	defer fakeConsul.Close()
+	fakeNS1.AddZone("example.com")
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "web", Port: 80})
+	fakeConsul.Register(Instance{Node: "n2", Address: "2001:db8::2", Service: "web", Port: 80})
+	fakeConsul.Register(Instance{Node: "n1", Address: "1.1.1.1", Service: "api", Port: 8080})
+
+	cfg := catalog.Config{NS1Domain: "example.com", NS1PollInterval: "1s", NS1DNSTTL: 60, AddressFamily: "dual"}
+	stop, stopped := make(chan struct{}), make(chan struct{})
+	go catalog.Sync(cfg, fakeNS1.Client(), fakeConsul.Client(), stop, stopped)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+	eventually(t, func() bool { return fakeNS1.Record("example.com", "web.example.com", "AAAA") != nil })
+
+	// a service left without IPv6 addresses has no AAAA record
+	fakeConsul.Deregister("n2", "web")
+	eventually(t, func() bool { return fakeNS1.Record("example.com", "web.example.com", "AAAA") == nil })
+	steady(t, fakeNS1)
+	assert.NotNil(t, fakeNS1.Record("example.com", "web.example.com", "A"))
+	// nor does a service that never had one
+	assert.NotNil(t, fakeNS1.Record("example.com", "api.example.com", "A"))
+	assert.Nil(t, fakeNS1.Record("example.com", "api.example.com", "AAAA"))
+}